@@ -0,0 +1,48 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// PendingAddressNames lists all custom address names that wait for approval.
+func (s Service) PendingAddressNames(w http.ResponseWriter, r *http.Request) {
+	addressNames, err := s.bot.PendingAddressNames()
+	if err != nil {
+		log.Errorf("[ADMIN] could not load address names: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(addressNames)
+}
+
+func (s Service) ApproveAddressName(w http.ResponseWriter, r *http.Request) {
+	s.reviewAddressName(w, r, true)
+}
+
+func (s Service) RejectAddressName(w http.ResponseWriter, r *http.Request) {
+	s.reviewAddressName(w, r, false)
+}
+
+func (s Service) reviewAddressName(w http.ResponseWriter, r *http.Request, approve bool) {
+	name := mux.Vars(r)["name"]
+	err := s.bot.ReviewAddressName(name, approve)
+	action := "reject"
+	if approve {
+		action = "approve"
+	}
+	s.audit(r, telegram.AuditActionAddressNameReview, fmt.Sprintf("%s %s", action, name), err)
+	if err != nil {
+		log.Errorf("[ADMIN] could not review address name %s: %v", name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
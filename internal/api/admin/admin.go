@@ -1,6 +1,9 @@
 package admin
 
 import (
+	"fmt"
+	"net/http"
+
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
 )
 
@@ -13,3 +16,12 @@ func New(b *telegram.TipBot) Service {
 		bot: b,
 	}
 }
+
+// audit records an operation of the admin API in the audit log of the bot.
+func (s Service) audit(r *http.Request, action, target string, err error) {
+	details := ""
+	if err != nil {
+		details = fmt.Sprintf("failed: %v", err)
+	}
+	s.bot.Audit(fmt.Sprintf("admin api %s", r.RemoteAddr), action, target, details)
+}
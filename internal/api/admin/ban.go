@@ -27,6 +27,7 @@ func (s Service) UnbanUser(w http.ResponseWriter, r *http.Request) {
 	adminSlice := strings.Split(user.Wallet.Adminkey, "_")
 	user.Wallet.Adminkey = adminSlice[len(adminSlice)-1]
 	err = telegram.UpdateUserRecord(user, *s.bot)
+	s.audit(r, telegram.AuditActionUnfreeze, fmt.Sprint(user.Telegram.ID), err)
 	if err != nil {
 		log.Errorf("[ADMIN] could not update user: %v", err)
 		return
@@ -53,6 +54,7 @@ func (s Service) BanUser(w http.ResponseWriter, r *http.Request) {
 	}
 	user.Wallet.Adminkey = fmt.Sprintf("%s_%s", "banned", user.Wallet.Adminkey)
 	err = telegram.UpdateUserRecord(user, *s.bot)
+	s.audit(r, telegram.AuditActionFreeze, fmt.Sprint(user.Telegram.ID), err)
 	if err != nil {
 		log.Errorf("[ADMIN] could not update user: %v", err)
 		return
@@ -68,7 +70,7 @@ func (s Service) getUserByTelegramId(r *http.Request) (*lnbits.User, error) {
 	if v["id"] == "" {
 		return nil, fmt.Errorf("invalid id")
 	}
-	tx := s.bot.DB.Users.Where("telegram_id = ? COLLATE NOCASE", v["id"]).First(user)
+	tx := s.bot.DB.Users.Where("telegram_id = ?", v["id"]).First(user)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
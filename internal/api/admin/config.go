@@ -0,0 +1,20 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
+)
+
+// ReloadConfiguration applies changes of config.yaml and the translation files, like sending SIGHUP.
+func (s Service) ReloadConfiguration(w http.ResponseWriter, r *http.Request) {
+	err := s.bot.ReloadConfiguration()
+	s.audit(r, telegram.AuditActionConfigReload, "", err)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("could not reload configuration: %v", err)))
+		return
+	}
+	w.Write([]byte("configuration reloaded"))
+}
@@ -1,14 +1,18 @@
 package admin
 
 import (
-	"github.com/LightningTipBot/LightningTipBot/internal/dalle"
 	"net/http"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/dalle"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
 )
 
 func (s Service) DisableDalle(w http.ResponseWriter, r *http.Request) {
 	dalle.Enabled = false
+	s.audit(r, telegram.AuditActionFeatureToggle, "dalle", nil)
 }
 
 func (s Service) EnableDalle(w http.ResponseWriter, r *http.Request) {
 	dalle.Enabled = true
+	s.audit(r, telegram.AuditActionFeatureToggle, "dalle", nil)
 }
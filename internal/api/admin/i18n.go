@@ -0,0 +1,17 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
+)
+
+// ReloadTranslations reloads all translation files from the translations directory.
+func (s Service) ReloadTranslations(w http.ResponseWriter, r *http.Request) {
+	languages := i18n.Reload()
+	s.audit(r, telegram.AuditActionTranslationsReload, "", nil)
+	w.Write([]byte(fmt.Sprintf("loaded languages: %s", strings.Join(languages, ", "))))
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type ReadinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Healthz responds as long as the process is running.
+func (s Service) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(StatusOk))
+}
+
+// Readyz checks Telegram, the wallet backend and the databases and responds with
+// 503 if one of them is not available. The errors are only logged.
+func (s Service) Readyz(w http.ResponseWriter, r *http.Request) {
+	response := ReadinessResponse{Status: StatusOk, Checks: map[string]string{}}
+	status := http.StatusOK
+	for name, err := range s.Bot.Readiness() {
+		if err != nil {
+			log.Warnf("[api] readiness check %s failed: %v", name, err)
+			response.Checks[name] = StatusError
+			response.Status = StatusError
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		response.Checks[name] = StatusOk
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -52,15 +52,14 @@ func (s Service) CreateInvoice(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	invoice, err := user.Wallet.Invoice(
+	invoice, err := s.Bot.Client.CreateInvoice(*user.Wallet,
 		lnbits.InvoiceParams{
 			Amount:              createInvoiceRequest.Amount,
 			Out:                 false,
 			DescriptionHash:     createInvoiceRequest.DescriptionHash,
 			UnhashedDescription: createInvoiceRequest.UnhashedDescription,
 			Memo:                createInvoiceRequest.Memo,
-			Webhook:             internal.Configuration.Lnbits.WebhookServer},
-		s.Bot.Client)
+			Webhook:             internal.Configuration.Lnbits.WebhookServer})
 	if err != nil {
 		RespondError(w, "could not create invoice")
 		return
@@ -79,7 +78,7 @@ func (s Service) PayInvoice(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	invoice, err := user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: payInvoiceRequest.PayRequest}, s.Bot.Client)
+	invoice, err := s.Bot.Client.Pay(*user.Wallet, lnbits.PaymentParams{Out: true, Bolt11: payInvoiceRequest.PayRequest})
 	if err != nil {
 		RespondError(w, "could not pay invoice: "+err.Error())
 		return
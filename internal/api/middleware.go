@@ -8,6 +8,7 @@ import (
 	"net/http/httputil"
 	"strings"
 
+	db "github.com/LightningTipBot/LightningTipBot/internal/database"
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
 	"gorm.io/gorm"
@@ -71,9 +72,9 @@ func AuthorizationMiddleware(database *gorm.DB, authType AuthType, accessType Ac
 		user := &lnbits.User{}
 		var tx *gorm.DB
 		if accessType.Type == "admin" {
-			tx = database.Where("wallet_adminkey = ? COLLATE NOCASE", password).First(user)
+			tx = database.Where(db.EqualFold("wallet_adminkey"), password).First(user)
 		} else if accessType.Type == "invoice" {
-			tx = database.Where("wallet_inkey = ? OR wallet_adminkey = ? COLLATE NOCASE", password, password).First(user)
+			tx = database.Where("wallet_inkey = ? OR "+db.EqualFold("wallet_adminkey"), password, password).First(user)
 		} else {
 			log.Errorf("[api] route without access type")
 			w.WriteHeader(401)
@@ -33,6 +33,7 @@ const botImage = "https://avatars.githubusercontent.com/u/88730856?v=7"
 var templates embed.FS
 var userpage_tmpl = template.Must(template.ParseFS(templates, "static/userpage.html"))
 var qr_tmpl = template.Must(template.ParseFS(templates, "static/webapp.html"))
+var pool_tmpl = template.Must(template.ParseFS(templates, "static/pool.html"))
 
 var Client = &http.Client{
 	Timeout: 10 * time.Second,
@@ -110,3 +111,35 @@ func (s Service) UserWebAppHandler(w http.ResponseWriter, r *http.Request) {
 		log.Errorf("failed to render template")
 	}
 }
+
+// PoolPageHandler renders the public page of a pool where anyone can contribute with LNURL.
+func (s Service) PoolPageHandler(w http.ResponseWriter, r *http.Request) {
+	// https://ln.tips/pool/<token>
+	token := mux.Vars(r)["token"]
+	pool, err := s.bot.PoolByToken(token)
+	if err != nil {
+		log.Errorln("[PoolPage]", err)
+		http.NotFound(w, r)
+		return
+	}
+	log.Infof("[PoolPage] rendering page of %s", pool.ID)
+	callback := fmt.Sprintf("%s/lnurlp/pool/%s", internal.Configuration.Bot.LNURLHostName, token)
+	lnurlEncode, err := lnurl.LNURLEncode(callback)
+	if err != nil {
+		log.Errorln("[PoolPage]", err)
+		return
+	}
+	percent := int64(100)
+	if pool.Goal > 0 && pool.Collected < pool.Goal {
+		percent = pool.Collected * 100 / pool.Goal
+	}
+	if err := pool_tmpl.ExecuteTemplate(w, "pool", struct {
+		Pool     *telegram.Pool
+		Percent  int64
+		Open     bool
+		LNURLPay string
+		DeepLink string
+	}{pool, percent, pool.Active && time.Now().Before(pool.Expires), lnurlEncode, pool.DeepLink(s.bot)}); err != nil {
+		log.Errorf("failed to render template")
+	}
+}
@@ -0,0 +1,86 @@
+// Package chart draws simple bar charts as PNG images. It draws no text so that no fonts
+// are needed, labels and legends are sent along with the image.
+package chart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+)
+
+const (
+	padding = 24
+	gap     = 0.3 // share of a group that is left empty between groups
+	grid    = 4   // number of horizontal grid lines
+)
+
+var (
+	background = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	gridColor  = color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+	axisColor  = color.RGBA{0x80, 0x80, 0x80, 0xff}
+)
+
+// Series is a row of values that is drawn in one color.
+type Series struct {
+	Values []int64
+	Color  color.RGBA
+}
+
+// Bars is a chart with a group of bars for every index of the series. All series should
+// have the same number of values.
+type Bars struct {
+	Width  int
+	Height int
+	Series []Series
+}
+
+func fill(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	draw.Draw(img, image.Rect(x0, y0, x1, y1), &image.Uniform{C: c}, image.Point{}, draw.Src)
+}
+
+// PNG returns the chart as PNG image.
+func (b Bars) PNG() ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, b.Width, b.Height))
+	fill(img, 0, 0, b.Width, b.Height, background)
+	var groups int
+	var max int64
+	for _, series := range b.Series {
+		if len(series.Values) > groups {
+			groups = len(series.Values)
+		}
+		for _, value := range series.Values {
+			if value > max {
+				max = value
+			}
+		}
+	}
+	left, right := padding, b.Width-padding
+	top, bottom := padding, b.Height-padding
+	for i := 1; i <= grid; i++ {
+		y := bottom - (bottom-top)*i/grid
+		fill(img, left, y, right, y+1, gridColor)
+	}
+	if groups > 0 && len(b.Series) > 0 {
+		groupWidth := float64(right-left) / float64(groups)
+		barWidth := groupWidth * (1 - gap) / float64(len(b.Series))
+		for i := 0; i < groups; i++ {
+			x := float64(left) + float64(i)*groupWidth + groupWidth*gap/2
+			for j, series := range b.Series {
+				if i >= len(series.Values) || series.Values[i] <= 0 {
+					continue
+				}
+				height := int(float64(bottom-top) * float64(series.Values[i]) / float64(max))
+				x0 := int(x + float64(j)*barWidth)
+				fill(img, x0, bottom-height, int(x+float64(j+1)*barWidth)-1, bottom, series.Color)
+			}
+		}
+	}
+	fill(img, left, bottom, right, bottom+2, axisColor)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
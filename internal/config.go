@@ -1,25 +1,157 @@
 package internal
 
 import (
+	"encoding/hex"
 	"fmt"
 	"net/url"
+	"os"
 	"strings"
+	"sync"
 
+	"github.com/LightningTipBot/LightningTipBot/pkg/lightning"
 	"github.com/jinzhu/configor"
 	log "github.com/sirupsen/logrus"
 )
 
-var Configuration = struct {
-	Bot      BotConfiguration      `yaml:"bot"`
-	Telegram TelegramConfiguration `yaml:"telegram"`
-	Database DatabaseConfiguration `yaml:"database"`
-	Lnbits   LnbitsConfiguration   `yaml:"lnbits"`
-	Generate GenerateConfiguration `yaml:"generate"`
-	Nostr    NostrConfiguration    `yaml:"nostr"`
-}{}
+type configuration struct {
+	Bot              BotConfiguration             `yaml:"bot"`
+	Telegram         TelegramConfiguration        `yaml:"telegram"`
+	Database         DatabaseConfiguration        `yaml:"database"`
+	Lnbits           LnbitsConfiguration          `yaml:"lnbits"`
+	Generate         GenerateConfiguration        `yaml:"generate"`
+	Nostr            NostrConfiguration           `yaml:"nostr"`
+	Node             NodeConfiguration            `yaml:"node"`
+	Cluster          ClusterConfiguration         `yaml:"cluster"`
+	RateLimit        RateLimitConfiguration       `yaml:"rate_limit"`
+	Log              LogConfiguration             `yaml:"log"`
+	Telemetry        TelemetryConfiguration       `yaml:"telemetry"`
+	Reporting        ErrorReportingConfiguration  `yaml:"error_reporting"`
+	Referral         ReferralConfiguration        `yaml:"referral"`
+	Fee              FeeConfiguration             `yaml:"fee"`
+	Liquidity        LiquidityConfiguration       `yaml:"liquidity"`
+	OperatorPayments OperatorPaymentConfiguration `yaml:"operator_payments"`
+	Anomaly          AnomalyConfiguration         `yaml:"anomaly"`
+}
+
+var Configuration = configuration{}
+
+// reloadMutex guards the settings that Reload changes while the bot runs.
+var reloadMutex sync.RWMutex
+
+// CurrentConfiguration returns a copy of the configuration. Settings that Reload can
+// change have to be read through it.
+func CurrentConfiguration() configuration {
+	reloadMutex.RLock()
+	defer reloadMutex.RUnlock()
+	return Configuration
+}
+
+const (
+	NodeBackendLnbits = "lnbits"
+	NodeBackendLnd    = "lnd"
+	NodeBackendCln    = "cln"
+)
+
+// NodeConfiguration is used if the bot talks to a Lightning node directly instead of LNbits.
+type NodeConfiguration struct {
+	Backend  string `yaml:"backend"`  // lnbits (default), lnd or cln
+	Host     string `yaml:"host"`     // lnd: gRPC endpoint, e.g. 127.0.0.1:10009, cln: REST endpoint, e.g. https://127.0.0.1:3010
+	Macaroon string `yaml:"macaroon"` // lnd: admin macaroon in hex or base64
+	Rune     string `yaml:"rune"`     // cln: rune for the clnrest plugin
+	Cert     string `yaml:"cert"`     // TLS certificate of the node (PEM). TLS is not verified if empty.
+	DbPath   string `yaml:"db_path"`  // database for the accounts and balances of the users
+	// routing fees of outgoing payments are limited to FeeLimitPercent of the amount, but at least FeeLimitMinSat
+	FeeLimitPercent float64 `yaml:"fee_limit_percent"`
+	FeeLimitMinSat  int64   `yaml:"fee_limit_min_sat"`
+	// payments that can not be routed over a single path are split into at most MaxParts parts (lnd)
+	MaxParts int `yaml:"max_parts"`
+}
+
+// TelemetryConfiguration exports traces of the updates, wallet backend calls and database queries.
+type TelemetryConfiguration struct {
+	OtlpEndpoint string  `yaml:"otlp_endpoint"` // host:port of an OTLP/HTTP collector, tracing is off if empty
+	Insecure     bool    `yaml:"insecure"`      // use http instead of https
+	ServiceName  string  `yaml:"service_name"`
+	SampleRate   float64 `yaml:"sample_rate"` // share of the updates that are traced, 0 to 1
+}
+
+// ErrorReportingConfiguration sends handler errors and panics to Sentry or a compatible service.
+type ErrorReportingConfiguration struct {
+	SentryDsn   string `yaml:"sentry_dsn"` // reporting is off if empty
+	Environment string `yaml:"environment"`
+}
+
+// ReferralConfiguration rewards users who invite others with /invite. The bonus is paid from
+// the wallet of the operator once the invitee made a first transaction.
+type ReferralConfiguration struct {
+	Bonus          int64 `yaml:"bonus"`           // sat per invitee, referrals are off if 0
+	MinTransaction int64 `yaml:"min_transaction"` // sat that the first transaction of the invitee needs
+	MaxPerDay      int   `yaml:"max_per_day"`     // bonuses per referrer and day
+	MaxTotal       int   `yaml:"max_total"`       // bonuses per referrer, 0 for no limit
+}
+
+// FeeConfiguration is a service fee on tips, sends and donations that the sender pays on top
+// of the amount into the wallet of the operator.
+type FeeConfiguration struct {
+	BasisPoints int64   `yaml:"basis_points"` // 100 basis points are 1%, there is no fee if 0
+	Min         int64   `yaml:"min"`          // sat, the fee is at least this much
+	Max         int64   `yaml:"max"`          // sat, the fee is at most this much, 0 for no limit
+	FreeBelow   int64   `yaml:"free_below"`   // transfers below this many sat have no fee
+	ExemptUsers []int64 `yaml:"exempt_users"` // Telegram ids of users who pay no fee
+}
+
+// LiquidityConfiguration monitors the funds of the backend and the channels of the node and
+// alerts the admin chat when they run low. A limit of 0 turns its check off.
+type LiquidityConfiguration struct {
+	AdminChatId     int64   `yaml:"admin_chat_id"`     // chat that gets the alerts, the operator if 0
+	CheckInterval   int64   `yaml:"check_interval"`    // minutes between the checks, 0 uses the default
+	MinReserveRatio float64 `yaml:"min_reserve_ratio"` // the reserve must cover the balances of all users this many times, like 1.1
+	MinOutbound     int64   `yaml:"min_outbound"`      // sat the node must be able to send
+	MinInbound      int64   `yaml:"min_inbound"`       // sat the node must be able to receive
+}
+
+// OperatorPaymentConfiguration guards /admin pay. Payments above ApprovalAbove are posted to
+// the admin chat of the liquidity configuration and wait until a second admin confirms them.
+type OperatorPaymentConfiguration struct {
+	ApprovalAbove  int64 `yaml:"approval_above"`  // sat, larger payments need a second admin, every payment if 0
+	ApprovalExpiry int64 `yaml:"approval_expiry"` // minutes a payment waits for the second admin, 0 uses the default
+}
+
+// AnomalyConfiguration pauses wallets with suspicious outgoing payments until their user
+// confirms them in a private message. A limit of 0 turns its check off.
+type AnomalyConfiguration struct {
+	DrainRatio            float64 `yaml:"drain_ratio"`             // share of the balance of an hour ago that a wallet may send within an hour, like 0.8
+	DrainMin              int64   `yaml:"drain_min"`               // sat, smaller drains are not suspicious
+	FreshDestinationSends int     `yaml:"fresh_destination_sends"` // payments within an hour to a destination that was first paid less than a day ago
+	VelocityMin           int     `yaml:"velocity_min"`            // payments within an hour that can be a spike
+	VelocityFactor        float64 `yaml:"velocity_factor"`         // a spike has this many times the payments per hour of the rest of the day
+	PauseDuration         int64   `yaml:"pause_duration"`          // minutes a wallet stays paused, 0 uses the default
+}
+
+const LogFormatJson = "json"
+
+type LogConfiguration struct {
+	Format string `yaml:"format"` // text (default) or json
+}
+
+// RateLimitConfiguration limits the messages the bot sends per chat and in total (messages per second).
+type RateLimitConfiguration struct {
+	ChatRate    float64 `yaml:"chat_rate"`
+	ChatBurst   int     `yaml:"chat_burst"`
+	GlobalRate  float64 `yaml:"global_rate"`
+	GlobalBurst int     `yaml:"global_burst"`
+}
+
+// ClusterConfiguration allows several bot instances to run behind the Telegram webhook.
+// The instances share their state and locks through the PostgreSQL database.
+type ClusterConfiguration struct {
+	Enabled    bool   `yaml:"enabled"`
+	InstanceId string `yaml:"instance_id"` // defaults to the hostname and process id
+}
 
 type NostrConfiguration struct {
-	PrivateKey string `yaml:"private_key"`
+	PrivateKey         string `yaml:"private_key"`
+	WalletConnectRelay string `yaml:"wallet_connect_relay"` // relay for Nostr Wallet Connect, turned off if empty
 }
 
 type GenerateConfiguration struct {
@@ -43,14 +175,58 @@ type BotConfiguration struct {
 	LNURLHostName  string              `yaml:"lnurl_public_host_name"`
 	LNURLHostUrl   *url.URL            `yaml:"-"`
 	LNURLSendImage bool                `yaml:"lnurl_image"`
+	LNURLDomains   []LNURLDomain       `yaml:"lnurl_domains,omitempty"`
 	AdminAPIHost   string              `yaml:"admin_api_host"`
+	// TrustedProxies are the addresses or networks (127.0.0.1, 10.0.0.0/8) of reverse proxies whose X-Forwarded-Host
+	// header is used to tell the custom lightning address domains apart. Other clients can't pick the domain with it.
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+	// DonationAddress is the lightning address that /donate pays to
+	DonationAddress string `yaml:"donation_address"`
+	// DonationThankYou is the message after a donation, {name} and {amount} are replaced with the donor and the amount
+	DonationThankYou string `yaml:"donation_thank_you"`
+	// Network of the wallet backend, amounts are shown as tsat on all networks but mainnet
+	Network string `yaml:"network"`
+	// TipUndoWindow is the time in seconds in which the sender of a tip can take it back, 0 uses the default and -1 turns undo off
+	TipUndoWindow int64 `yaml:"tip_undo_window"`
+	// OperatorId is the Telegram id of the operator, whose wallet pays referral bonuses and collects service fees.
+	// Only the operator can use /admin.
+	OperatorId int64 `yaml:"operator_id"`
+	// Operators are the roles of the other operators by Telegram id: admin, support or read-only.
+	// The operator above is the owner.
+	Operators map[int64]string `yaml:"operators"`
+	// Amounts are shorthands for amounts in sat that users can enter instead of a number, like 🍺: 1000
+	Amounts map[string]int64 `yaml:"amounts"`
+	// EscrowDays is how long a send to a user without a wallet waits to be claimed before it is refunded, 0 uses the default
+	EscrowDays int64 `yaml:"escrow_days"`
+	// PaywallKey is the hex encoded 32 byte key that encrypts paid media at rest. Users can only sell media with /ppv if it is set.
+	PaywallKey      string `yaml:"paywall_key"`
+	PaywallKeyBytes []byte `yaml:"-"`
+}
+
+// LNURLDomain is an additional domain the bot serves lightning addresses on.
+// Users maps address names on this domain to bot usernames (tips@mygroup.com -> @mygroupadmin).
+// If AllUsers is set, every bot user can also be paid with <username>@<host>.
+type LNURLDomain struct {
+	Host     string            `yaml:"host"`
+	Users    map[string]string `yaml:"users"`
+	AllUsers bool              `yaml:"all_users"`
 }
 
 type TelegramConfiguration struct {
 	MessageDisposeDuration int64  `yaml:"message_dispose_duration"`
 	ApiKey                 string `yaml:"api_key"`
+	WebhookUrl             string `yaml:"webhook_url"`    // receive updates through this public url instead of long polling
+	WebhookListen          string `yaml:"webhook_listen"` // address of the webhook server, e.g. 0.0.0.0:8443
 }
+
+const (
+	DatabaseDriverSqlite   = "sqlite"
+	DatabaseDriverPostgres = "postgres"
+)
+
 type DatabaseConfiguration struct {
+	Driver           string `yaml:"driver"`       // sqlite (default) or postgres
+	PostgresDsn      string `yaml:"postgres_dsn"` // only used with the postgres driver
 	DbPath           string `yaml:"db_path"`
 	ShopBuntDbPath   string `yaml:"shop_buntdb_path"`
 	BuntDbPath       string `yaml:"buntdb_path"`
@@ -65,6 +241,8 @@ type LnbitsConfiguration struct {
 	LnbitsPublicUrl  string   `yaml:"lnbits_public_url"`
 	WebhookServer    string   `yaml:"webhook_server"`
 	WebhookServerUrl *url.URL `yaml:"-"`
+	ApiVersion       string   `yaml:"api_version"`    // empty to detect the version at startup
+	PaymentStream    bool     `yaml:"payment_stream"` // follow wallets with open invoices on the payment stream of LNbits
 }
 
 func init() {
@@ -88,7 +266,188 @@ func init() {
 		panic(err)
 	}
 	Configuration.Bot.LNURLHostUrl = hostname
-	checkLnbitsConfiguration()
+	if err := checkLNURLDomains(Configuration.Bot.LNURLDomains); err != nil {
+		panic(err)
+	}
+	checkTunables(&Configuration)
+	checkNetwork()
+	checkPaywallKey()
+	checkTelemetryConfiguration()
+	if Configuration.Node.Backend == "" {
+		Configuration.Node.Backend = NodeBackendLnbits
+	}
+	if Configuration.Node.Backend == NodeBackendLnbits {
+		checkLnbitsConfiguration()
+	} else {
+		checkNodeConfiguration()
+	}
+	checkDatabaseConfiguration()
+	checkClusterConfiguration()
+}
+
+func checkClusterConfiguration() {
+	if !Configuration.Cluster.Enabled {
+		return
+	}
+	if Configuration.Database.Driver != DatabaseDriverPostgres {
+		panic(fmt.Errorf("please use the postgres database driver to run a cluster"))
+	}
+	if Configuration.Telegram.WebhookUrl == "" {
+		panic(fmt.Errorf("please configure a telegram webhook_url to run a cluster"))
+	}
+	if Configuration.Node.Backend != NodeBackendLnbits {
+		// the ledger of a node backend is local to one instance
+		panic(fmt.Errorf("please use the lnbits backend to run a cluster"))
+	}
+	if Configuration.Cluster.InstanceId == "" {
+		hostname, _ := os.Hostname()
+		Configuration.Cluster.InstanceId = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+}
+
+func checkDatabaseConfiguration() {
+	switch Configuration.Database.Driver {
+	case "":
+		Configuration.Database.Driver = DatabaseDriverSqlite
+	case DatabaseDriverSqlite:
+	case DatabaseDriverPostgres:
+		if Configuration.Database.PostgresDsn == "" {
+			panic(fmt.Errorf("please configure postgres_dsn for the postgres database driver"))
+		}
+	default:
+		panic(fmt.Errorf("unknown database driver %s", Configuration.Database.Driver))
+	}
+}
+
+// checkLNURLDomains normalizes the hosts and address names of custom lightning address domains
+func checkLNURLDomains(domains []LNURLDomain) error {
+	for i, domain := range domains {
+		if domain.Host == "" {
+			return fmt.Errorf("please configure a host for every lnurl domain")
+		}
+		users := make(map[string]string, len(domain.Users))
+		for name, username := range domain.Users {
+			users[strings.ToLower(name)] = strings.TrimPrefix(strings.ToLower(username), "@")
+		}
+		domains[i].Host = strings.ToLower(domain.Host)
+		domains[i].Users = users
+	}
+	return nil
+}
+
+func checkNetwork() {
+	switch Configuration.Bot.Network {
+	case "":
+		Configuration.Bot.Network = lightning.Mainnet
+	case lightning.Mainnet, lightning.Testnet, lightning.Signet, lightning.Regtest:
+	default:
+		panic(fmt.Errorf("unknown network %s", Configuration.Bot.Network))
+	}
+}
+
+func checkPaywallKey() {
+	if Configuration.Bot.PaywallKey == "" {
+		return
+	}
+	key, err := hex.DecodeString(Configuration.Bot.PaywallKey)
+	if err != nil || len(key) != 32 {
+		panic(fmt.Errorf("please configure a paywall_key of 32 bytes in hex, e.g. from openssl rand -hex 32"))
+	}
+	Configuration.Bot.PaywallKeyBytes = key
+}
+
+// IsMainnet returns false if the bot runs on a test network without real funds.
+func IsMainnet() bool {
+	return Configuration.Bot.Network == lightning.Mainnet
+}
+
+func checkTelemetryConfiguration() {
+	if Configuration.Telemetry.ServiceName == "" {
+		Configuration.Telemetry.ServiceName = "lightningtipbot"
+	}
+	if Configuration.Telemetry.SampleRate <= 0 || Configuration.Telemetry.SampleRate > 1 {
+		Configuration.Telemetry.SampleRate = 1
+	}
+}
+
+// checkTunables sets the defaults of the settings that can be reloaded at runtime.
+func checkTunables(c *configuration) {
+	if c.Bot.DonationAddress == "" {
+		c.Bot.DonationAddress = "kevinrav@btip.nl"
+	}
+	if c.Bot.TipUndoWindow == 0 {
+		c.Bot.TipUndoWindow = 30
+	}
+	if c.Referral.MinTransaction <= 0 {
+		c.Referral.MinTransaction = 100
+	}
+	if c.Referral.MaxPerDay <= 0 {
+		c.Referral.MaxPerDay = 10
+	}
+	if c.Node.FeeLimitPercent <= 0 {
+		c.Node.FeeLimitPercent = 1
+	}
+	if c.Node.FeeLimitMinSat <= 0 {
+		c.Node.FeeLimitMinSat = 10
+	}
+	if c.Node.MaxParts <= 0 {
+		c.Node.MaxParts = 16
+	}
+	if c.RateLimit.ChatRate <= 0 {
+		c.RateLimit.ChatRate = 0.29
+	}
+	if c.RateLimit.ChatBurst <= 0 {
+		c.RateLimit.ChatBurst = 19
+	}
+	if c.RateLimit.GlobalRate <= 0 {
+		c.RateLimit.GlobalRate = 30
+	}
+	if c.RateLimit.GlobalBurst <= 0 {
+		c.RateLimit.GlobalBurst = 30
+	}
+}
+
+// Reload reads config.yaml again and applies the settings that can change at runtime.
+// All other settings, like the database or the Telegram api key, need a restart.
+func Reload() error {
+	reloaded := configuration{}
+	err := configor.Load(&reloaded, "config.yaml")
+	if err != nil {
+		return err
+	}
+	if err := checkLNURLDomains(reloaded.Bot.LNURLDomains); err != nil {
+		return err
+	}
+	checkTunables(&reloaded)
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	Configuration.Telegram.MessageDisposeDuration = reloaded.Telegram.MessageDisposeDuration
+	Configuration.Generate.DallePrice = reloaded.Generate.DallePrice
+	Configuration.Bot.LNURLSendImage = reloaded.Bot.LNURLSendImage
+	Configuration.Bot.LNURLDomains = reloaded.Bot.LNURLDomains
+	Configuration.Bot.DonationAddress = reloaded.Bot.DonationAddress
+	Configuration.Bot.DonationThankYou = reloaded.Bot.DonationThankYou
+	Configuration.Bot.TipUndoWindow = reloaded.Bot.TipUndoWindow
+	Configuration.Node.FeeLimitPercent = reloaded.Node.FeeLimitPercent
+	Configuration.Node.FeeLimitMinSat = reloaded.Node.FeeLimitMinSat
+	Configuration.Node.MaxParts = reloaded.Node.MaxParts
+	Configuration.RateLimit = reloaded.RateLimit
+	log.Infof("[Config] reloaded configuration")
+	return nil
+}
+
+func checkNodeConfiguration() {
+	switch Configuration.Node.Backend {
+	case NodeBackendLnd, NodeBackendCln:
+	default:
+		panic(fmt.Errorf("unknown node backend %s", Configuration.Node.Backend))
+	}
+	if Configuration.Node.Host == "" {
+		panic(fmt.Errorf("please configure the host of your node"))
+	}
+	if Configuration.Node.DbPath == "" {
+		Configuration.Node.DbPath = "data/node.db"
+	}
 }
 
 func checkLnbitsConfiguration() {
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"gorm.io/gorm"
+)
+
+const (
+	AddressNameStatusPending  = "pending"
+	AddressNameStatusApproved = "approved"
+	AddressNameStatusRejected = "rejected"
+)
+
+// AddressName is a custom lightning address handle of a user that is independent
+// of the Telegram username. Requested names only resolve after an admin approved them.
+type AddressName struct {
+	Name      string    `json:"name" gorm:"primaryKey"`
+	UserID    string    `json:"user_id" gorm:"index"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created"`
+	UpdatedAt time.Time `json:"updated"`
+}
+
+// FindUserByAddressName returns the user that owns the approved address name.
+func FindUserByAddressName(database *gorm.DB, name string) (*lnbits.User, *gorm.DB) {
+	user := &lnbits.User{}
+	addressName := &AddressName{}
+	tx := database.Where("name = ? AND status = ?", strings.ToLower(name), AddressNameStatusApproved).First(addressName)
+	if tx.Error != nil {
+		return user, tx
+	}
+	tx = database.Where("id = ?", addressName.UserID).First(user)
+	return user, tx
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Migration is a versioned change of a database or key/value store. Down reverts Up,
+// migrations without Down can not be reverted.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func() error
+	Down        func() error
+}
+
+// SchemaMigration records an applied migration of a scope.
+type SchemaMigration struct {
+	Scope       string `gorm:"primaryKey"`
+	Version     int    `gorm:"primaryKey"`
+	Description string
+	AppliedAt   time.Time
+}
+
+// Migrator applies the migrations of one scope, like the users database, and
+// records the applied versions in db.
+type Migrator struct {
+	Scope      string
+	db         *gorm.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *gorm.DB, scope string, migrations ...Migration) *Migrator {
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return &Migrator{Scope: scope, db: db, migrations: migrations}
+}
+
+// Version returns the latest applied version of the scope, 0 if none was applied.
+func (m *Migrator) Version() (int, error) {
+	if err := m.db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return 0, err
+	}
+	var version int
+	tx := m.db.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Where("scope = ?", m.Scope).Scan(&version)
+	return version, tx.Error
+}
+
+// Latest returns the version of the newest migration.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Migrate runs the up migrations to reach target or the down migrations
+// if target is older than the current version.
+func (m *Migrator) Migrate(target int) error {
+	current, err := m.Version()
+	if err != nil {
+		return err
+	}
+	for _, migration := range m.migrations {
+		if migration.Version <= current || migration.Version > target {
+			continue
+		}
+		log.Infof("[Migrate] %s: applying %d (%s)", m.Scope, migration.Version, migration.Description)
+		if err := migration.Up(); err != nil {
+			return fmt.Errorf("migration %s %d failed: %w", m.Scope, migration.Version, err)
+		}
+		tx := m.db.Create(&SchemaMigration{Scope: m.Scope, Version: migration.Version, Description: migration.Description, AppliedAt: time.Now()})
+		if tx.Error != nil {
+			return tx.Error
+		}
+	}
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version > current || migration.Version <= target {
+			continue
+		}
+		if migration.Down == nil {
+			return fmt.Errorf("migration %s %d can not be reverted", m.Scope, migration.Version)
+		}
+		log.Infof("[Migrate] %s: reverting %d (%s)", m.Scope, migration.Version, migration.Description)
+		if err := migration.Down(); err != nil {
+			return fmt.Errorf("reverting migration %s %d failed: %w", m.Scope, migration.Version, err)
+		}
+		tx := m.db.Where("scope = ? AND version = ?", m.Scope, migration.Version).Delete(&SchemaMigration{})
+		if tx.Error != nil {
+			return tx.Error
+		}
+	}
+	return nil
+}
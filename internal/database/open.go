@@ -0,0 +1,57 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/telemetry"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var (
+	postgresDB   *gorm.DB
+	postgresErr  error
+	postgresOnce sync.Once
+)
+
+func config() *gorm.Config {
+	return &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true, FullSaveAssociations: true}
+}
+
+// Open opens the SQLite database at path. If PostgreSQL is configured, all
+// databases share the same PostgreSQL connection and path is ignored.
+func Open(path string) (*gorm.DB, error) {
+	if !UsePostgres() {
+		db, err := gorm.Open(sqlite.Open(path), config())
+		return instrument(db, err)
+	}
+	postgresOnce.Do(func() {
+		db, err := gorm.Open(postgres.Open(internal.Configuration.Database.PostgresDsn), config())
+		postgresDB, postgresErr = instrument(db, err)
+	})
+	return postgresDB, postgresErr
+}
+
+// instrument traces the queries of a database that was opened successfully.
+func instrument(db *gorm.DB, err error) (*gorm.DB, error) {
+	if err == nil {
+		telemetry.InstrumentGorm(db)
+	}
+	return db, err
+}
+
+// UsePostgres returns true if PostgreSQL is the configured datastore.
+func UsePostgres() bool {
+	return internal.Configuration.Database.Driver == internal.DatabaseDriverPostgres
+}
+
+// EqualFold returns a condition that compares column case insensitively with a parameter.
+func EqualFold(column string) string {
+	if UsePostgres() {
+		return fmt.Sprintf("LOWER(%s) = LOWER(?)", column)
+	}
+	return fmt.Sprintf("%s = ? COLLATE NOCASE", column)
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"gorm.io/gorm"
+)
+
+// UsernameChange records that a user gave up a Telegram username, either by renaming or
+// because another user took it over.
+type UsernameChange struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	UserID      string    `json:"user_id" gorm:"index"`
+	Username    string    `json:"username" gorm:"index"` // lowercase
+	NewUsername string    `json:"new_username"`
+	CreatedAt   time.Time `json:"created" gorm:"index"`
+}
+
+// RecordUsernameChange adds the former username of a user to the history.
+func RecordUsernameChange(database *gorm.DB, user *lnbits.User, former string) error {
+	change := &UsernameChange{UserID: user.ID, Username: strings.ToLower(former)}
+	if user.Telegram != nil {
+		change.NewUsername = user.Telegram.Username
+	}
+	return database.Create(change).Error
+}
+
+// LastUsernameChange returns the latest record of a user giving up the username.
+func LastUsernameChange(database *gorm.DB, username string) (*UsernameChange, *gorm.DB) {
+	change := &UsernameChange{}
+	tx := database.Where("username = ?", strings.ToLower(username)).Order("created_at desc").First(change)
+	return change, tx
+}
+
+// FindUserByFormerUsername returns the user that had the username last.
+func FindUserByFormerUsername(database *gorm.DB, username string) (*lnbits.User, *UsernameChange, *gorm.DB) {
+	user := &lnbits.User{}
+	change, tx := LastUsernameChange(database, username)
+	if tx.Error != nil {
+		return user, change, tx
+	}
+	tx = database.Where("id = ?", change.UserID).First(user)
+	return user, change, tx
+}
@@ -24,7 +24,13 @@ func FindUser(database *gorm.DB, username string) (*lnbits.User, *gorm.DB) {
 		tx = database.Where("uuid = ?", username).First(user)
 	} else {
 		// assume it's a string @username
-		tx = database.Where("telegram_username = ? COLLATE NOCASE", username).First(user)
+		tx = database.Where(EqualFold("telegram_username"), username).First(user)
+		if tx.Error != nil {
+			// it could also be an approved custom address name
+			if u, addressTx := FindUserByAddressName(database, username); addressTx.Error == nil {
+				return u, addressTx
+			}
+		}
 	}
 	return user, tx
 }
@@ -27,3 +27,10 @@ func (e TipBotError) Error() string {
 	}
 	return string(j)
 }
+
+// Expected returns true for errors that were created with Create. They describe the
+// state of a user or the input, like a missing wallet, not a failure of the bot.
+func Expected(err error) bool {
+	e, ok := err.(TipBotError)
+	return ok && len(e.Message) == 0
+}
@@ -25,6 +25,8 @@ const (
 	DecodePerUserAmountError
 	InvalidAmountError
 	InvalidAmountPerUserError
+	DuplicateOperationError
+	OperationInProgressError
 )
 
 const (
@@ -52,6 +54,8 @@ var errMap = map[TipBotErrorType]TipBotError{
 	UnknownError:              unknown,
 	NotActiveError:            notActive,
 	InvalidTypeError:          invalidType,
+	DuplicateOperationError:   duplicateOperation,
+	OperationInProgressError:  operationInProgress,
 }
 
 var (
@@ -72,4 +76,6 @@ var (
 	unknown              = TipBotError{Err: fmt.Errorf("unknown error")}
 	notActive            = TipBotError{Err: fmt.Errorf("element not active")}
 	invalidType          = TipBotError{Err: fmt.Errorf("invalid type")}
+	duplicateOperation   = TipBotError{Err: fmt.Errorf("operation was already processed")}
+	operationInProgress  = TipBotError{Err: fmt.Errorf("operation is in progress")}
 )
@@ -1,40 +1,198 @@
 package i18n
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
 	"github.com/BurntSushi/toml"
+	"github.com/LightningTipBot/LightningTipBot/internal"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/text/language"
 )
 
-var Bundle *i18n.Bundle
+const TranslationsDir = "translations"
+
+var (
+	// bundle holds the translations, Reload replaces it while handlers translate
+	bundle *i18n.Bundle
+	// languages holds the language codes of all loaded translation files
+	languages   []string
+	bundleMutex sync.RWMutex
+)
+
+// CurrentBundle returns the bundle with the translations that are loaded now.
+func CurrentBundle() *i18n.Bundle {
+	bundleMutex.RLock()
+	defer bundleMutex.RUnlock()
+	return bundle
+}
+
+// LoadedLanguages returns the language codes of all loaded translation files.
+func LoadedLanguages() []string {
+	bundleMutex.RLock()
+	defer bundleMutex.RUnlock()
+	return languages
+}
+
+// LanguageNames maps language codes to the label shown in the language picker.
+var LanguageNames = map[string]string{
+	"en":    "🇬🇧 English",
+	"de":    "🇩🇪 Deutsch",
+	"fi":    "🇫🇮 Suomi",
+	"it":    "🇮🇹 Italiano",
+	"es":    "🇪🇸 Español",
+	"nl":    "🇳🇱 Nederlands",
+	"pl":    "🇵🇱 Polski",
+	"fr":    "🇫🇷 Français",
+	"pt-br": "🇧🇷 Português",
+	"tr":    "🇹🇷 Türkçe",
+	"cs":    "🇨🇿 Čeština",
+	"id":    "🇮🇩 Bahasa Indonesia",
+	"ru":    "🇷🇺 Русский",
+}
 
 func init() {
-	Bundle = RegisterLanguages()
+	bundle, languages = RegisterLanguages()
+}
+
+// translationsDir returns the translations directory in the working directory. Tests run
+// in the directory of their package and fall back to the translations of the repository.
+func translationsDir() string {
+	if _, err := os.Stat(TranslationsDir); err == nil {
+		return TranslationsDir
+	}
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		return TranslationsDir
+	}
+	return filepath.Join(filepath.Dir(file), "..", "..", TranslationsDir)
 }
 
-func RegisterLanguages() *i18n.Bundle {
+// RegisterLanguages loads every *.toml file in the translations directory.
+// en.toml is mandatory, all other files are optional.
+func RegisterLanguages() (*i18n.Bundle, []string) {
 	bundle := i18n.NewBundle(language.English)
 	bundle.RegisterUnmarshalFunc("toml", toml.Unmarshal)
-	bundle.MustLoadMessageFile("translations/en.toml")
-	bundle.LoadMessageFile("translations/de.toml")
-	bundle.LoadMessageFile("translations/fi.toml")
-	bundle.LoadMessageFile("translations/it.toml")
-	bundle.LoadMessageFile("translations/es.toml")
-	bundle.LoadMessageFile("translations/nl.toml")
-	bundle.LoadMessageFile("translations/pl.toml")
-	bundle.LoadMessageFile("translations/fr.toml")
-	bundle.LoadMessageFile("translations/pt-br.toml")
-	bundle.LoadMessageFile("translations/tr.toml")
-	bundle.LoadMessageFile("translations/cs.toml")
-	bundle.LoadMessageFile("translations/id.toml")
-	bundle.LoadMessageFile("translations/ru.toml")
-	return bundle
+	dir := translationsDir()
+	bundle.MustLoadMessageFile(filepath.Join(dir, "en.toml"))
+	languages := []string{"en"}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		log.Errorf("[i18n] could not read translations directory: %v", err)
+		return bundle, languages
+	}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".toml" || f.Name() == "en.toml" {
+			continue
+		}
+		_, err := bundle.LoadMessageFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			log.Errorf("[i18n] could not load %s: %v", f.Name(), err)
+			continue
+		}
+		languages = append(languages, strings.TrimSuffix(f.Name(), ".toml"))
+	}
+	sort.Strings(languages[1:])
+	return bundle, languages
 }
+
+// Reload rebuilds the bundle from the translations directory. New translation
+// files are picked up without restarting the bot.
+func Reload() []string {
+	// the files are read without blocking the handlers
+	newBundle, newLanguages := RegisterLanguages()
+	bundleMutex.Lock()
+	bundle, languages = newBundle, newLanguages
+	bundleMutex.Unlock()
+	log.Infof("[i18n] loaded %d languages: %s", len(newLanguages), strings.Join(newLanguages, ", "))
+	return newLanguages
+}
+
+// IsSupported returns true if a translation file for languageCode is loaded.
+func IsSupported(languageCode string) bool {
+	for _, l := range LoadedLanguages() {
+		if strings.EqualFold(l, languageCode) {
+			return true
+		}
+	}
+	return false
+}
+
+// LanguageName returns the display name of a language code.
+func LanguageName(languageCode string) string {
+	if name, ok := LanguageNames[languageCode]; ok {
+		return name
+	}
+	return languageCode
+}
+
 func Translate(languageCode string, MessgeID string) string {
-	str, err := i18n.NewLocalizer(Bundle, languageCode).Localize(&i18n.LocalizeConfig{MessageID: MessgeID})
+	str, err := i18n.NewLocalizer(CurrentBundle(), languageCode).Localize(&i18n.LocalizeConfig{MessageID: MessgeID})
 	if err != nil {
 		log.Warnf("Error translating message %s: %s", MessgeID, err)
 	}
-	return str
+	return LabelAmounts(str)
+}
+
+var satUnit = regexp.MustCompile(`\bsat(s?)\b`)
+
+// LabelAmounts marks the amounts in a text as test sats (tsat) if the bot
+// does not run on mainnet, so that nobody mistakes them for real funds.
+func LabelAmounts(text string) string {
+	if internal.IsMainnet() {
+		return text
+	}
+	return satUnit.ReplaceAllString(text, "tsat$1")
+}
+
+// Units that users can see amounts in, see FormatAmount.
+const (
+	UnitSat  = "sat"
+	UnitBTC  = "btc"
+	UnitBits = "bits"
+)
+
+// FormatAmount formats an amount in sat in a unit: 1000 sat, 0.00001000 BTC or 10 bits.
+func FormatAmount(amount int64, unit string) string {
+	return LabelAmounts(formatAmount(amount, unit, ""))
+}
+
+func formatAmount(amount int64, unit string, test string) string {
+	switch unit {
+	case UnitBTC:
+		return fmt.Sprintf("%.8f %sBTC", float64(amount)/100_000_000, test)
+	case UnitBits:
+		if amount%100 == 0 {
+			return fmt.Sprintf("%d %sbits", amount/100, test)
+		}
+		return fmt.Sprintf("%.2f %sbits", float64(amount)/100, test)
+	}
+	return fmt.Sprintf("%d %ssat", amount, test)
+}
+
+var satAmount = regexp.MustCompile(`\b(\d+) (t?)sats?\b`)
+
+// ConvertAmounts shows the amounts in sat of a text in a unit. Texts are translated with
+// amounts in sat, the unit of the reader is applied when the text is sent.
+func ConvertAmounts(text string, unit string) string {
+	if unit == "" || unit == UnitSat {
+		return text
+	}
+	return satAmount.ReplaceAllStringFunc(text, func(match string) string {
+		parts := satAmount.FindStringSubmatch(match)
+		amount, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return match
+		}
+		return formatAmount(amount, unit, parts[2])
+	})
 }
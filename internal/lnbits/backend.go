@@ -0,0 +1,112 @@
+package lnbits
+
+// WalletBackend is the interface between the bot and the Lightning wallets of its users.
+// Telegram handlers only talk to the backend through this interface so that other
+// backends can be plugged in. Client is the LNbits implementation.
+type WalletBackend interface {
+	// CreateUserWithInitialWallet creates an account with its first wallet.
+	CreateUserWithInitialWallet(userName, walletName, adminId string, email string) (User, error)
+	// Wallets returns all wallets of an account.
+	Wallets(u User) ([]Wallet, error)
+	// CreateInvoice creates an invoice that pays to the wallet.
+	CreateInvoice(w Wallet, params InvoiceParams) (Invoice, error)
+	// Pay pays an invoice from the wallet.
+	Pay(w Wallet, params PaymentParams) (Invoice, error)
+	// Balance returns the wallet with its current balance.
+	Balance(w Wallet) (Wallet, error)
+	// Payments returns the latest payments of the wallet.
+	Payments(w Wallet) (Payments, error)
+	// Payment returns the state of a single payment.
+	Payment(w Wallet, paymentHash string) (LNbitsPayment, error)
+	// Subscribe registers a handler that is called for every incoming payment.
+	Subscribe(handler PaymentHandler)
+}
+
+// IncomingPayment is passed to subscribers of a backend when a wallet was paid.
+type IncomingPayment struct {
+	WalletID    string
+	PaymentHash string
+	Amount      int64 // msat
+	Memo        string
+}
+
+type PaymentHandler func(payment IncomingPayment)
+
+// ReserveReporter is implemented by backends that know the funds they hold on the
+// Lightning network, so that the operator can compare them with the balances of all users.
+type ReserveReporter interface {
+	// Reserve returns the spendable funds of the backend in msat.
+	Reserve() (int64, error)
+}
+
+// Liquidity is the capacity of the channels of a node in msat.
+type Liquidity struct {
+	Outbound int64 // what the node can send
+	Inbound  int64 // what the node can receive
+	Channels int   // active channels
+}
+
+// LiquidityReporter is implemented by backends that know the channels of their node.
+type LiquidityReporter interface {
+	Liquidity() (Liquidity, error)
+}
+
+var _ WalletBackend = (*Client)(nil)
+
+// Unwrap returns the backend below the circuit breaker, the balance cache, the network
+// guard, the payment observer and the tracing.
+func Unwrap(backend WalletBackend) WalletBackend {
+	for {
+		switch b := backend.(type) {
+		case *CircuitBreaker:
+			backend = b.WalletBackend
+		case *BalanceCache:
+			backend = b.WalletBackend
+		case *NetworkGuard:
+			backend = b.WalletBackend
+		case *PaymentObserver:
+			backend = b.WalletBackend
+		case *Traced:
+			backend = b.WalletBackend
+		default:
+			return backend
+		}
+	}
+}
+
+// CreateInvoice creates an invoice associated with the wallet.
+func (c *Client) CreateInvoice(w Wallet, params InvoiceParams) (Invoice, error) {
+	invoice, err := w.Invoice(params, c)
+	if err == nil {
+		c.watch(w, invoice.PaymentHash)
+	}
+	return invoice, err
+}
+
+// Pay pays a given invoice with funds from the wallet.
+func (c *Client) Pay(w Wallet, params PaymentParams) (Invoice, error) {
+	return w.Pay(params, c)
+}
+
+// Balance returns the wallet information including its balance.
+func (c *Client) Balance(w Wallet) (Wallet, error) {
+	return c.Info(w)
+}
+
+// Subscribe registers a handler for incoming payments. LNbits delivers payments
+// to the webhook server and, if enabled, on the payment stream, which pass them on
+// with Notify.
+func (c *Client) Subscribe(handler PaymentHandler) {
+	c.subscribers = append(c.subscribers, handler)
+}
+
+// Notify calls all subscribers with an incoming payment. A payment that was already
+// passed on is dropped.
+func (c *Client) Notify(payment IncomingPayment) {
+	if !c.settle(payment) {
+		return
+	}
+	for _, handler := range c.subscribers {
+		handler(payment)
+	}
+}
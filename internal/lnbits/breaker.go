@@ -0,0 +1,155 @@
+package lnbits
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrBackendUnavailable is returned by the CircuitBreaker while the backend is considered down.
+var ErrBackendUnavailable = errors.New("wallet backend temporarily unavailable")
+
+const (
+	circuitBreakerThreshold     = 5
+	circuitBreakerProbeInterval = 15 * time.Second
+)
+
+// CircuitBreaker wraps a WalletBackend. After several consecutive failures to reach the
+// backend, the breaker opens and fails all calls immediately. A health monitor probes
+// the backend in the background and closes the breaker once it is reachable again.
+type CircuitBreaker struct {
+	WalletBackend
+	mutex    sync.Mutex
+	failures int
+	open     bool
+}
+
+var _ WalletBackend = (*CircuitBreaker)(nil)
+
+func NewCircuitBreaker(backend WalletBackend) *CircuitBreaker {
+	return &CircuitBreaker{WalletBackend: backend}
+}
+
+// Open returns true if the backend is currently considered down.
+func (b *CircuitBreaker) Open() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.open
+}
+
+// isOutage returns true if err means that the backend could not be reached. Errors that
+// the backend responded with, like an insufficient balance, mean that it is up.
+func isOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, ok := err.(Error)
+	return !ok
+}
+
+// Ping returns an error if the backend can not be reached.
+func Ping(backend WalletBackend) error {
+	// any response, even an error response, shows that the backend is reachable
+	_, err := backend.Wallets(User{})
+	if isOutage(err) {
+		return err
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) record(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !isOutage(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= circuitBreakerThreshold && !b.open {
+		b.open = true
+		log.Errorf("[CircuitBreaker] wallet backend unavailable after %d failures: %v", b.failures, err)
+		go b.monitor()
+	}
+}
+
+// monitor probes the backend until it responds again.
+func (b *CircuitBreaker) monitor() {
+	for {
+		time.Sleep(circuitBreakerProbeInterval)
+		err := Ping(b.WalletBackend)
+		if err == nil {
+			b.mutex.Lock()
+			b.open = false
+			b.failures = 0
+			b.mutex.Unlock()
+			log.Infof("[CircuitBreaker] wallet backend recovered")
+			return
+		}
+		log.Warnf("[CircuitBreaker] wallet backend still unavailable: %v", err)
+	}
+}
+
+func (b *CircuitBreaker) CreateUserWithInitialWallet(userName, walletName, adminId string, email string) (User, error) {
+	if b.Open() {
+		return User{}, ErrBackendUnavailable
+	}
+	user, err := b.WalletBackend.CreateUserWithInitialWallet(userName, walletName, adminId, email)
+	b.record(err)
+	return user, err
+}
+
+func (b *CircuitBreaker) Wallets(u User) ([]Wallet, error) {
+	if b.Open() {
+		return nil, ErrBackendUnavailable
+	}
+	wallets, err := b.WalletBackend.Wallets(u)
+	b.record(err)
+	return wallets, err
+}
+
+func (b *CircuitBreaker) CreateInvoice(w Wallet, params InvoiceParams) (Invoice, error) {
+	if b.Open() {
+		return Invoice{}, ErrBackendUnavailable
+	}
+	invoice, err := b.WalletBackend.CreateInvoice(w, params)
+	b.record(err)
+	return invoice, err
+}
+
+func (b *CircuitBreaker) Pay(w Wallet, params PaymentParams) (Invoice, error) {
+	if b.Open() {
+		return Invoice{}, ErrBackendUnavailable
+	}
+	invoice, err := b.WalletBackend.Pay(w, params)
+	b.record(err)
+	return invoice, err
+}
+
+func (b *CircuitBreaker) Balance(w Wallet) (Wallet, error) {
+	if b.Open() {
+		return Wallet{}, ErrBackendUnavailable
+	}
+	wallet, err := b.WalletBackend.Balance(w)
+	b.record(err)
+	return wallet, err
+}
+
+func (b *CircuitBreaker) Payments(w Wallet) (Payments, error) {
+	if b.Open() {
+		return nil, ErrBackendUnavailable
+	}
+	payments, err := b.WalletBackend.Payments(w)
+	b.record(err)
+	return payments, err
+}
+
+func (b *CircuitBreaker) Payment(w Wallet, paymentHash string) (LNbitsPayment, error) {
+	if b.Open() {
+		return LNbitsPayment{}, ErrBackendUnavailable
+	}
+	payment, err := b.WalletBackend.Payment(w, paymentHash)
+	b.record(err)
+	return payment, err
+}
@@ -0,0 +1,76 @@
+package lnbits
+
+import (
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+)
+
+const (
+	// balances are invalidated on every payment, the expiration only limits how long
+	// a balance can be off if the backend was changed from outside the bot
+	balanceCacheExpiration = 10 * time.Minute
+	invoiceCacheExpiration = 24 * time.Hour
+)
+
+// BalanceCache wraps a WalletBackend and caches wallet balances. A balance is invalidated
+// when the wallet pays, when it receives a payment and when another wallet pays an
+// invoice of it, which the backend may report later than the payment returns.
+type BalanceCache struct {
+	WalletBackend
+	balances *gocache.Cache
+	// invoices maps the payment hashes of created invoices to their wallet
+	invoices *gocache.Cache
+}
+
+var _ WalletBackend = (*BalanceCache)(nil)
+
+func NewBalanceCache(backend WalletBackend) *BalanceCache {
+	c := &BalanceCache{
+		WalletBackend: backend,
+		balances:      gocache.New(balanceCacheExpiration, 2*balanceCacheExpiration),
+		invoices:      gocache.New(invoiceCacheExpiration, time.Hour),
+	}
+	// registered before all other subscribers so that they already see the new balance
+	backend.Subscribe(func(payment IncomingPayment) {
+		c.Invalidate(payment.WalletID)
+	})
+	return c
+}
+
+// Invalidate removes the cached balance of a wallet.
+func (c *BalanceCache) Invalidate(walletID string) {
+	c.balances.Delete(walletID)
+}
+
+func (c *BalanceCache) Balance(w Wallet) (Wallet, error) {
+	if balance, ok := c.balances.Get(w.ID); ok {
+		w.Balance = balance.(int64)
+		return w, nil
+	}
+	wallet, err := c.WalletBackend.Balance(w)
+	if err != nil {
+		return wallet, err
+	}
+	c.balances.SetDefault(w.ID, wallet.Balance)
+	return wallet, nil
+}
+
+func (c *BalanceCache) CreateInvoice(w Wallet, params InvoiceParams) (Invoice, error) {
+	invoice, err := c.WalletBackend.CreateInvoice(w, params)
+	if err == nil {
+		c.invoices.SetDefault(invoice.PaymentHash, w.ID)
+	}
+	return invoice, err
+}
+
+func (c *BalanceCache) Pay(w Wallet, params PaymentParams) (Invoice, error) {
+	// also failed payments may have reserved fees or be pending
+	defer c.Invalidate(w.ID)
+	invoice, err := c.WalletBackend.Pay(w, params)
+	if walletID, ok := c.invoices.Get(invoice.PaymentHash); ok && err == nil {
+		c.Invalidate(walletID.(string))
+		c.invoices.Delete(invoice.PaymentHash)
+	}
+	return invoice, err
+}
@@ -0,0 +1,203 @@
+package lnbits
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imroc/req"
+	log "github.com/sirupsen/logrus"
+)
+
+// APIVersion is the major version of the LNbits API. LNbits v1 changed the format of
+// payments and invoices and replaced the user manager extension with the core users API.
+type APIVersion int
+
+const (
+	APIVersionV0 APIVersion = iota
+	APIVersionV1
+)
+
+func (v APIVersion) String() string {
+	if v == APIVersionV1 {
+		return "v1"
+	}
+	return "v0"
+}
+
+// ParseAPIVersion parses a configured version like "v1" or "1.2.1".
+func ParseAPIVersion(version string) (APIVersion, bool) {
+	major, err := strconv.Atoi(strings.Split(strings.TrimPrefix(version, "v"), ".")[0])
+	if err != nil {
+		return APIVersionV0, false
+	}
+	if major >= 1 {
+		return APIVersionV1, true
+	}
+	return APIVersionV0, true
+}
+
+// SetAPIVersion overrides the detected API version.
+func (c *Client) SetAPIVersion(version APIVersion) {
+	c.version = version
+}
+
+// DetectAPIVersion asks LNbits for its version and falls back to v0 for old servers that
+// don't report one. If LNbits can't be asked, v1 is assumed.
+func (c *Client) DetectAPIVersion() APIVersion {
+	c.version = APIVersionV1
+	resp, err := req.Get(c.url+"/api/v1/health", c.header, nil)
+	if err != nil || resp.Response().StatusCode >= 300 && resp.Response().StatusCode != http.StatusNotFound {
+		log.Warnf("[lnbits] could not detect api version, assuming %s. Set api_version in the lnbits config if this is wrong.", c.version)
+		return c.version
+	}
+	// servers before v1 don't have the health endpoint or don't report their version
+	c.version = APIVersionV0
+	var health struct {
+		Version string `json:"version"`
+	}
+	if resp.Response().StatusCode < 300 {
+		if err := resp.ToJSON(&health); err == nil {
+			if version, ok := ParseAPIVersion(health.Version); ok {
+				c.version = version
+			}
+		}
+	}
+	log.Infof("[lnbits] using api %s (server version %s)", c.version, health.Version)
+	return c.version
+}
+
+// invoiceV0 is the invoice returned by LNbits v0, which calls the bolt11 payment_request.
+type invoiceV0 struct {
+	PaymentHash    string `json:"payment_hash"`
+	PaymentRequest string `json:"payment_request"`
+}
+
+func (i invoiceV0) invoice() Invoice {
+	return Invoice{PaymentHash: i.PaymentHash, PaymentRequest: i.PaymentRequest}
+}
+
+// paymentV1 is a payment of LNbits v1. The time is a timestamp instead of
+// unix seconds and the pending flag was replaced by a status.
+type paymentV1 struct {
+	CheckingID  string `json:"checking_id"`
+	Status      string `json:"status"`
+	Amount      int64  `json:"amount"`
+	Fee         int64  `json:"fee"`
+	Memo        string `json:"memo"`
+	Time        string `json:"time"`
+	Bolt11      string `json:"bolt11"`
+	Preimage    string `json:"preimage"`
+	PaymentHash string `json:"payment_hash"`
+	WalletID    string `json:"wallet_id"`
+}
+
+func (p paymentV1) payment() Payment {
+	return Payment{
+		CheckingID:  p.CheckingID,
+		Pending:     p.Status == "pending",
+		Amount:      p.Amount,
+		Fee:         p.Fee,
+		Memo:        p.Memo,
+		Time:        int(parseTimeV1(p.Time).Unix()),
+		Bolt11:      p.Bolt11,
+		Preimage:    p.Preimage,
+		PaymentHash: p.PaymentHash,
+		WalletID:    p.WalletID,
+	}
+}
+
+// parseTimeV1 parses the timestamps of LNbits v1, which may come without a time zone.
+func parseTimeV1(s string) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999", "2006-01-02 15:04:05.999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+type paymentStatusV1 struct {
+	Paid     bool      `json:"paid"`
+	Preimage string    `json:"preimage"`
+	Details  paymentV1 `json:"details"`
+}
+
+// userV1 and walletV1 are returned by the users API of LNbits v1.
+type userV1 struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type walletV1 struct {
+	ID          string `json:"id"`
+	Adminkey    string `json:"adminkey"`
+	Inkey       string `json:"inkey"`
+	Name        string `json:"name"`
+	User        string `json:"user"`
+	BalanceMsat int64  `json:"balance_msat"`
+}
+
+func (w walletV1) wallet() Wallet {
+	return Wallet{ID: w.ID, Adminkey: w.Adminkey, Inkey: w.Inkey, Name: w.Name, User: w.User, Balance: w.BalanceMsat}
+}
+
+// createUserV1 creates a user with the users API of LNbits v1, which needs the id of an admin user.
+func (c *Client) createUserV1(userName, walletName, adminId string, email string) (User, error) {
+	resp, err := req.Post(fmt.Sprintf("%s/users/api/v1/user?usr=%s", c.url, adminId), c.header, req.BodyJSON(struct {
+		Username string `json:"username,omitempty"`
+		Email    string `json:"email,omitempty"`
+	}{userName, email}))
+	if err != nil {
+		return User{}, err
+	}
+	if resp.Response().StatusCode >= 300 {
+		var reqErr Error
+		resp.ToJSON(&reqErr)
+		return User{}, reqErr
+	}
+	var user userV1
+	if err := resp.ToJSON(&user); err != nil {
+		return User{}, err
+	}
+	// older v1 releases don't create a default wallet for new users
+	wallets, err := c.walletsV1(user.ID)
+	if err == nil && len(wallets) == 0 {
+		resp, err = req.Post(fmt.Sprintf("%s/users/api/v1/user/%s/wallet?usr=%s", c.url, user.ID, adminId), c.header, req.BodyJSON(struct {
+			Name string `json:"name"`
+		}{walletName}))
+		if err == nil && resp.Response().StatusCode >= 300 {
+			var reqErr Error
+			resp.ToJSON(&reqErr)
+			err = reqErr
+		}
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return User{ID: user.ID, Name: user.Username}, nil
+}
+
+func (c Client) walletsV1(userId string) ([]Wallet, error) {
+	resp, err := req.Get(fmt.Sprintf("%s/users/api/v1/user/%s/wallet?usr=%s", c.url, userId, c.AdminID), c.header, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Response().StatusCode >= 300 {
+		var reqErr Error
+		resp.ToJSON(&reqErr)
+		return nil, reqErr
+	}
+	var wallets []walletV1
+	if err := resp.ToJSON(&wallets); err != nil {
+		return nil, err
+	}
+	result := make([]Wallet, 0, len(wallets))
+	for _, w := range wallets {
+		result = append(result, w.wallet())
+	}
+	return result, nil
+}
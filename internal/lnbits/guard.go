@@ -0,0 +1,42 @@
+package lnbits
+
+import "sync"
+
+// PaymentCheck decides whether a wallet may pay. An error refuses the payment.
+type PaymentCheck func(w Wallet, params PaymentParams) error
+
+// PaymentGuard wraps a WalletBackend and asks its checks before every outgoing payment,
+// internal transfers between wallets included. A payment that a check refuses is not sent.
+type PaymentGuard struct {
+	WalletBackend
+	mutex  sync.RWMutex
+	checks []PaymentCheck
+}
+
+var _ WalletBackend = (*PaymentGuard)(nil)
+
+func NewPaymentGuard(backend WalletBackend) *PaymentGuard {
+	return &PaymentGuard{WalletBackend: backend}
+}
+
+// BeforePayment registers a check for outgoing payments. Checks run in the order of their
+// registration, the first error stops the payment.
+func (g *PaymentGuard) BeforePayment(check PaymentCheck) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.checks = append(g.checks, check)
+}
+
+func (g *PaymentGuard) Pay(w Wallet, params PaymentParams) (Invoice, error) {
+	if params.Out {
+		g.mutex.RLock()
+		checks := g.checks
+		g.mutex.RUnlock()
+		for _, check := range checks {
+			if err := check(w, params); err != nil {
+				return Invoice{}, err
+			}
+		}
+	}
+	return g.WalletBackend.Pay(w, params)
+}
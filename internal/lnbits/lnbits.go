@@ -20,6 +20,7 @@ func NewClient(key, url string) *Client {
 			"Accept":       "application/json",
 			"X-Api-Key":    key,
 		},
+		streams: newPaymentStreams(),
 	}
 }
 
@@ -43,6 +44,9 @@ func (c *Client) GetUser(userId string) (user User, err error) {
 
 // CreateUserWithInitialWallet creates new user with initial wallet
 func (c *Client) CreateUserWithInitialWallet(userName, walletName, adminId string, email string) (wal User, err error) {
+	if c.version == APIVersionV1 {
+		return c.createUserV1(userName, walletName, adminId, email)
+	}
 	resp, err := req.Post(c.url+"/usermanager/api/v1/users", c.header, req.BodyJSON(struct {
 		WalletName string `json:"wallet_name"`
 		AdminId    string `json:"admin_id"`
@@ -104,6 +108,11 @@ func (w Wallet) Invoice(params InvoiceParams, c *Client) (lntx Invoice, err erro
 		return
 	}
 
+	if c.version == APIVersionV0 {
+		var invoice invoiceV0
+		err = resp.ToJSON(&invoice)
+		return invoice.invoice(), err
+	}
 	err = resp.ToJSON(&lntx)
 	return
 }
@@ -152,6 +161,14 @@ func (c Client) Payments(w Wallet) (wtx Payments, err error) {
 		return
 	}
 
+	if c.version == APIVersionV1 {
+		var payments []paymentV1
+		err = resp.ToJSON(&payments)
+		for _, p := range payments {
+			wtx = append(wtx, p.payment())
+		}
+		return
+	}
 	err = resp.ToJSON(&wtx)
 	return
 }
@@ -176,12 +193,20 @@ func (c Client) Payment(w Wallet, payment_hash string) (payment LNbitsPayment, e
 		return
 	}
 
+	if c.version == APIVersionV1 {
+		var status paymentStatusV1
+		err = resp.ToJSON(&status)
+		return LNbitsPayment{Paid: status.Paid, Preimage: status.Preimage, Details: status.Details.payment()}, err
+	}
 	err = resp.ToJSON(&payment)
 	return
 }
 
 // Wallets returns all wallets belonging to an user
 func (c Client) Wallets(w User) (wtx []Wallet, err error) {
+	if c.version == APIVersionV1 {
+		return c.walletsV1(w.ID)
+	}
 	resp, err := req.Get(c.url+"/usermanager/api/v1/wallets/"+w.ID, c.header, nil)
 	if err != nil {
 		return
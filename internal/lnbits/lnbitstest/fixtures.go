@@ -0,0 +1,133 @@
+package lnbitstest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+// newInvoice creates a signed bolt11 invoice of the fake node. The invoices decode like
+// the ones of a real node so that handlers can parse them.
+func (s *Server) newInvoice(w *wallet, amount int64, memo, descriptionHash, unhashedDescription string) (*invoice, error) {
+	preimage := make([]byte, 32)
+	if _, err := rand.Read(preimage); err != nil {
+		return nil, err
+	}
+	paymentHash := sha256.Sum256(preimage)
+	var paymentAddr [32]byte
+	if _, err := rand.Read(paymentAddr[:]); err != nil {
+		return nil, err
+	}
+	options := []func(*zpay32.Invoice){
+		zpay32.Amount(lnwire.MilliSatoshi(amount * 1000)),
+		zpay32.PaymentAddr(paymentAddr),
+		zpay32.Expiry(time.Hour),
+	}
+	switch {
+	case len(descriptionHash) > 0:
+		var hash [32]byte
+		decoded, err := hex.DecodeString(descriptionHash)
+		if err != nil || len(decoded) != 32 {
+			return nil, fmt.Errorf("invalid description hash")
+		}
+		copy(hash[:], decoded)
+		options = append(options, zpay32.DescriptionHash(hash))
+	case len(unhashedDescription) > 0:
+		options = append(options, zpay32.DescriptionHash(sha256.Sum256([]byte(unhashedDescription))))
+	default:
+		options = append(options, zpay32.Description(memo))
+	}
+	bolt11, err := zpay32.NewInvoice(&chaincfg.MainNetParams, paymentHash, time.Now(), options...)
+	if err != nil {
+		return nil, err
+	}
+	paymentRequest, err := bolt11.Encode(zpay32.MessageSigner{
+		SignCompact: func(msg []byte) ([]byte, error) {
+			return ecdsa.SignCompact(s.nodeKey, chainhash.HashB(msg), true)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	i := &invoice{
+		paymentHash:    hex.EncodeToString(paymentHash[:]),
+		preimage:       hex.EncodeToString(preimage),
+		paymentRequest: paymentRequest,
+		amount:         amount * 1000,
+		memo:           memo,
+		wallet:         w,
+	}
+	s.invoices[i.paymentHash] = i
+	return i, nil
+}
+
+// NewUser creates an account with one wallet, like CreateUserWithInitialWallet.
+func (s *Server) NewUser(name string) (lnbits.User, lnbits.Wallet) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u := &user{ID: s.newId("user"), Name: name}
+	s.users[u.ID] = u
+	w := s.addWallet(u.ID, name)
+	return lnbits.User{ID: u.ID, Name: u.Name}, w.wallet()
+}
+
+func (w *wallet) wallet() lnbits.Wallet {
+	return lnbits.Wallet{ID: w.ID, Adminkey: w.Adminkey, Inkey: w.Inkey, Name: w.Name, User: w.User, Balance: w.Balance}
+}
+
+// Fund pays amount sat to a wallet from outside of the server.
+func (s *Server) Fund(walletID string, amount int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	w, ok := s.wallets[walletID]
+	if !ok {
+		return fmt.Errorf("wallet %s does not exist", walletID)
+	}
+	i, err := s.newInvoice(w, amount, "funding", "", "")
+	if err != nil {
+		return err
+	}
+	i.paid = true
+	w.Balance += i.amount
+	s.payments = append(s.payments, &payment{wallet: w, invoice: i, amount: i.amount, time: time.Now()})
+	return nil
+}
+
+// ExternalInvoice returns an invoice of another node over amount sat. Paying it
+// only takes the funds from the paying wallet.
+func (s *Server) ExternalInvoice(amount int64, memo string) (lnbits.Invoice, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	i, err := s.newInvoice(nil, amount, memo, "", "")
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	return lnbits.Invoice{PaymentHash: i.paymentHash, PaymentRequest: i.paymentRequest}, nil
+}
+
+// Balance returns the balance of a wallet in sat.
+func (s *Server) Balance(walletID string) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if w, ok := s.wallets[walletID]; ok {
+		return w.Balance / 1000
+	}
+	return 0
+}
+
+// Paid returns true if the invoice with the payment hash was paid.
+func (s *Server) Paid(paymentHash string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	i, ok := s.invoices[paymentHash]
+	return ok && i.paid
+}
@@ -0,0 +1,454 @@
+// Package lnbitstest provides a fake LNbits server for tests.
+package lnbitstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/gorilla/mux"
+	"github.com/imroc/req"
+	log "github.com/sirupsen/logrus"
+)
+
+// Server is an in-memory LNbits with the user manager extension that speaks the v0 API.
+// Invoices of its own wallets are settled instantly like internal payments of LNbits,
+// invoices of other nodes are created with ExternalInvoice.
+type Server struct {
+	*httptest.Server
+	AdminKey string
+
+	mutex       sync.Mutex
+	unavailable bool
+	nodeKey     *btcec.PrivateKey
+	users       map[string]*user
+	wallets     map[string]*wallet // by id
+	keys        map[string]apiKey
+	invoices    map[string]*invoice // by payment hash
+	payments    []*payment
+	streams     map[*wallet][]chan lnbits.Payment // subscribers of the payment stream
+	counter     int
+}
+
+type user struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Admin string `json:"admin"`
+	Email string `json:"email"`
+}
+
+type wallet struct {
+	ID       string `json:"id"`
+	Adminkey string `json:"adminkey"`
+	Inkey    string `json:"inkey"`
+	Name     string `json:"name"`
+	User     string `json:"user"`
+	Balance  int64  `json:"balance"` // msat
+}
+
+// apiKey is the invoice or admin key of a wallet.
+type apiKey struct {
+	wallet *wallet
+	admin  bool
+}
+
+type invoice struct {
+	paymentHash    string
+	preimage       string
+	paymentRequest string
+	amount         int64 // msat
+	memo           string
+	webhook        string
+	wallet         *wallet // nil for invoices of other nodes
+	paid           bool
+}
+
+type payment struct {
+	wallet  *wallet
+	invoice *invoice
+	amount  int64 // msat, negative for outgoing payments
+	time    time.Time
+}
+
+// NewServer starts a fake LNbits. Stop it with Close.
+func NewServer() *Server {
+	nodeKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		panic(err)
+	}
+	s := &Server{
+		nodeKey:  nodeKey,
+		users:    make(map[string]*user),
+		wallets:  make(map[string]*wallet),
+		keys:     make(map[string]apiKey),
+		invoices: make(map[string]*invoice),
+		streams:  make(map[*wallet][]chan lnbits.Payment),
+	}
+	s.AdminKey = s.newId("adminkey")
+	s.Server = httptest.NewServer(s.newRouter())
+	return s
+}
+
+// Client returns a client of the fake with its admin key.
+func (s *Server) Client() *lnbits.Client {
+	client := lnbits.NewClient(s.AdminKey, s.URL)
+	client.SetAPIVersion(lnbits.APIVersionV0)
+	return client
+}
+
+// SetUnavailable lets all requests fail without a response, like an unreachable LNbits.
+func (s *Server) SetUnavailable(unavailable bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.unavailable = unavailable
+}
+
+func (s *Server) newRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.Use(s.availability)
+	router.HandleFunc("/usermanager/api/v1/users", s.admin(s.createUser)).Methods(http.MethodPost)
+	router.HandleFunc("/usermanager/api/v1/users/{id}", s.admin(s.getUser)).Methods(http.MethodPost, http.MethodGet)
+	router.HandleFunc("/usermanager/api/v1/wallets", s.admin(s.createWallet)).Methods(http.MethodPost)
+	router.HandleFunc("/usermanager/api/v1/wallets/{id}", s.admin(s.getWallets)).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/wallet", s.getWallet).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/payments", s.postPayment).Methods(http.MethodPost)
+	router.HandleFunc("/api/v1/payments", s.getPayments).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/payments/sse", s.streamPayments).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/payments/{hash}", s.getPayment).Methods(http.MethodGet)
+	return router
+}
+
+func (s *Server) availability(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		s.mutex.Lock()
+		unavailable := s.unavailable
+		s.mutex.Unlock()
+		if unavailable {
+			if conn, _, err := writer.(http.Hijacker).Hijack(); err == nil {
+				conn.Close()
+			}
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// admin only lets requests with the admin key of the server through.
+func (s *Server) admin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("X-Api-Key") != s.AdminKey {
+			writeError(writer, http.StatusUnauthorized, "Invalid key")
+			return
+		}
+		handler(writer, request)
+	}
+}
+
+func writeJSON(writer http.ResponseWriter, status int, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(body)
+}
+
+func writeError(writer http.ResponseWriter, status int, detail string) {
+	writeJSON(writer, status, lnbits.Error{Detail: detail})
+}
+
+// newId returns a unique id that looks like the hex ids of LNbits.
+func (s *Server) newId(kind string) string {
+	s.counter++
+	return fmt.Sprintf("%x", fmt.Sprintf("%-8s%08d", kind, s.counter))
+}
+
+// wallet returns the wallet of the key in the request.
+func (s *Server) wallet(request *http.Request, admin bool) (*wallet, bool) {
+	key, ok := s.keys[request.Header.Get("X-Api-Key")]
+	if !ok || (admin && !key.admin) {
+		return nil, false
+	}
+	return key.wallet, true
+}
+
+func (s *Server) createUser(writer http.ResponseWriter, request *http.Request) {
+	var params struct {
+		WalletName string `json:"wallet_name"`
+		AdminId    string `json:"admin_id"`
+		UserName   string `json:"user_name"`
+		Email      string `json:"email"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&params); err != nil {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u := &user{ID: s.newId("user"), Name: params.UserName, Admin: params.AdminId, Email: params.Email}
+	s.users[u.ID] = u
+	s.addWallet(u.ID, params.WalletName)
+	writeJSON(writer, http.StatusCreated, u)
+}
+
+func (s *Server) getUser(writer http.ResponseWriter, request *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.users[mux.Vars(request)["id"]]
+	if !ok {
+		writeError(writer, http.StatusNotFound, "User does not exist.")
+		return
+	}
+	writeJSON(writer, http.StatusOK, u)
+}
+
+func (s *Server) createWallet(writer http.ResponseWriter, request *http.Request) {
+	var params struct {
+		UserId     string `json:"user_id"`
+		WalletName string `json:"wallet_name"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&params); err != nil {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.users[params.UserId]; !ok {
+		writeError(writer, http.StatusNotFound, "User does not exist.")
+		return
+	}
+	writeJSON(writer, http.StatusCreated, s.addWallet(params.UserId, params.WalletName))
+}
+
+func (s *Server) addWallet(userId, name string) *wallet {
+	w := &wallet{ID: s.newId("wallet"), Adminkey: s.newId("admin"), Inkey: s.newId("invoice"), Name: name, User: userId}
+	s.wallets[w.ID] = w
+	s.keys[w.Adminkey] = apiKey{wallet: w, admin: true}
+	s.keys[w.Inkey] = apiKey{wallet: w}
+	return w
+}
+
+func (s *Server) getWallets(writer http.ResponseWriter, request *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	wallets := []*wallet{}
+	for _, w := range s.wallets {
+		if w.User == mux.Vars(request)["id"] {
+			wallets = append(wallets, w)
+		}
+	}
+	sort.Slice(wallets, func(i, j int) bool { return wallets[i].ID < wallets[j].ID })
+	writeJSON(writer, http.StatusOK, wallets)
+}
+
+func (s *Server) getWallet(writer http.ResponseWriter, request *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	w, ok := s.wallet(request, false)
+	if !ok {
+		writeError(writer, http.StatusUnauthorized, "Invalid key")
+		return
+	}
+	writeJSON(writer, http.StatusOK, struct {
+		ID      string `json:"id"`
+		Name    string `json:"name"`
+		Balance int64  `json:"balance"`
+	}{w.ID, w.Name, w.Balance})
+}
+
+// postPayment creates an invoice or pays one, depending on out.
+func (s *Server) postPayment(writer http.ResponseWriter, request *http.Request) {
+	var params struct {
+		lnbits.InvoiceParams
+		Bolt11 string `json:"bolt11"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&params); err != nil {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	w, ok := s.wallet(request, params.Out)
+	if !ok {
+		writeError(writer, http.StatusUnauthorized, "Invalid key")
+		return
+	}
+	if !params.Out {
+		if params.Amount <= 0 {
+			writeError(writer, http.StatusBadRequest, "Amount must be positive.")
+			return
+		}
+		i, err := s.newInvoice(w, params.Amount, params.Memo, params.DescriptionHash, params.UnhashedDescription)
+		if err != nil {
+			writeError(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+		i.webhook = params.Webhook
+		writeJSON(writer, http.StatusCreated, map[string]string{
+			"payment_hash":    i.paymentHash,
+			"payment_request": i.paymentRequest,
+			"checking_id":     i.paymentHash,
+		})
+		return
+	}
+	i, err := s.pay(w, params.Bolt11)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusCreated, map[string]string{
+		"payment_hash": i.paymentHash,
+		"checking_id":  i.paymentHash,
+	})
+}
+
+// pay settles an invoice with the balance of w. Incoming payments of the wallets of the
+// server are delivered to the webhook of the invoice and on the payment stream like
+// LNbits does.
+func (s *Server) pay(w *wallet, bolt11 string) (*invoice, error) {
+	var i *invoice
+	for _, candidate := range s.invoices {
+		if strings.EqualFold(candidate.paymentRequest, bolt11) {
+			i = candidate
+			break
+		}
+	}
+	switch {
+	case i == nil:
+		return nil, fmt.Errorf("Invalid bolt11 invoice.")
+	case i.paid:
+		return nil, fmt.Errorf("Payment already paid.")
+	case i.wallet == w:
+		return nil, fmt.Errorf("Cannot pay your own invoice.")
+	case w.Balance < i.amount:
+		return nil, fmt.Errorf("Insufficient balance.")
+	}
+	i.paid = true
+	w.Balance -= i.amount
+	now := time.Now()
+	s.payments = append(s.payments, &payment{wallet: w, invoice: i, amount: -i.amount, time: now})
+	if i.wallet != nil {
+		i.wallet.Balance += i.amount
+		incoming := &payment{wallet: i.wallet, invoice: i, amount: i.amount, time: now}
+		s.payments = append(s.payments, incoming)
+		if len(i.webhook) > 0 {
+			go deliverWebhook(i.webhook, incoming.details())
+		}
+		for _, events := range s.streams[i.wallet] {
+			select {
+			case events <- incoming.details():
+			default:
+			}
+		}
+	}
+	return i, nil
+}
+
+func deliverWebhook(url string, details lnbits.Payment) {
+	_, err := req.Post(url, req.BodyJSON(&details))
+	if err != nil {
+		log.Warnf("[lnbitstest] could not deliver webhook: %v", err)
+	}
+}
+
+func (p *payment) details() lnbits.Payment {
+	return lnbits.Payment{
+		CheckingID:  p.invoice.paymentHash,
+		Amount:      p.amount,
+		Memo:        p.invoice.memo,
+		Time:        int(p.time.Unix()),
+		Bolt11:      p.invoice.paymentRequest,
+		Preimage:    p.invoice.preimage,
+		PaymentHash: p.invoice.paymentHash,
+		WalletID:    p.wallet.ID,
+	}
+}
+
+// streamPayments sends the incoming payments of a wallet as server-sent events until the
+// client disconnects.
+func (s *Server) streamPayments(writer http.ResponseWriter, request *http.Request) {
+	s.mutex.Lock()
+	w, ok := s.wallet(request, false)
+	if !ok {
+		s.mutex.Unlock()
+		writeError(writer, http.StatusUnauthorized, "Invalid key")
+		return
+	}
+	events := make(chan lnbits.Payment, 16)
+	s.streams[w] = append(s.streams[w], events)
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for i, subscriber := range s.streams[w] {
+			if subscriber == events {
+				s.streams[w] = append(s.streams[w][:i], s.streams[w][i+1:]...)
+				break
+			}
+		}
+	}()
+
+	flusher := writer.(http.Flusher)
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case payment := <-events:
+			data, _ := json.Marshal(payment)
+			fmt.Fprintf(writer, "event: payment-received\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+// getPayments returns the payments of a wallet, the newest first.
+func (s *Server) getPayments(writer http.ResponseWriter, request *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	w, ok := s.wallet(request, false)
+	if !ok {
+		writeError(writer, http.StatusUnauthorized, "Invalid key")
+		return
+	}
+	payments := lnbits.Payments{}
+	for i := len(s.payments) - 1; i >= 0; i-- {
+		if s.payments[i].wallet == w {
+			payments = append(payments, s.payments[i].details())
+		}
+	}
+	// unpaid invoices are listed as pending incoming payments
+	for _, i := range s.invoices {
+		if i.wallet == w && !i.paid {
+			payments = append(payments, lnbits.Payment{CheckingID: i.paymentHash, Pending: true, Amount: i.amount, Memo: i.memo, Bolt11: i.paymentRequest, PaymentHash: i.paymentHash, WalletID: w.ID})
+		}
+	}
+	writeJSON(writer, http.StatusOK, payments)
+}
+
+func (s *Server) getPayment(writer http.ResponseWriter, request *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	w, ok := s.wallet(request, false)
+	if !ok {
+		writeError(writer, http.StatusUnauthorized, "Invalid key")
+		return
+	}
+	for _, p := range s.payments {
+		if p.wallet == w && p.invoice.paymentHash == mux.Vars(request)["hash"] {
+			writeJSON(writer, http.StatusOK, lnbits.LNbitsPayment{Paid: true, Preimage: p.invoice.preimage, Details: p.details()})
+			return
+		}
+	}
+	if i, ok := s.invoices[mux.Vars(request)["hash"]]; ok && i.wallet == w {
+		writeJSON(writer, http.StatusOK, lnbits.LNbitsPayment{Paid: false, Details: lnbits.Payment{CheckingID: i.paymentHash, Pending: true, Amount: i.amount, PaymentHash: i.paymentHash, WalletID: w.ID}})
+		return
+	}
+	writeError(writer, http.StatusNotFound, "Payment does not exist.")
+}
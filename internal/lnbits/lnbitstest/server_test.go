@@ -0,0 +1,193 @@
+package lnbitstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+)
+
+func TestInternalPayment(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	_, alice := server.NewUser("alice")
+	_, bob := server.NewUser("bob")
+	if err := server.Fund(alice.ID, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	invoice, err := client.CreateInvoice(bob, lnbits.InvoiceParams{Amount: 100, Memo: "tip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bolt11, err := decodepay.Decodepay(invoice.PaymentRequest)
+	if err != nil {
+		t.Fatalf("invoice does not decode: %v", err)
+	}
+	if bolt11.MSatoshi != 100000 || bolt11.PaymentHash != invoice.PaymentHash || bolt11.Description != "tip" {
+		t.Errorf("unexpected invoice %+v", bolt11)
+	}
+	if _, err := client.Pay(alice, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}); err != nil {
+		t.Fatal(err)
+	}
+
+	wallet, err := client.Balance(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Balance != 900000 {
+		t.Errorf("balance of alice = %d msat, want 900000", wallet.Balance)
+	}
+	if balance := server.Balance(bob.ID); balance != 100 {
+		t.Errorf("balance of bob = %d sat, want 100", balance)
+	}
+	payment, err := client.Payment(bob, invoice.PaymentHash)
+	if err != nil || !payment.Paid {
+		t.Errorf("payment = %+v, %v, want paid", payment, err)
+	}
+	payments, err := client.Payments(bob)
+	if err != nil || len(payments) != 1 || payments[0].Amount != 100000 {
+		t.Errorf("payments of bob = %+v, %v", payments, err)
+	}
+}
+
+func TestPaymentErrors(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	_, alice := server.NewUser("alice")
+	_, bob := server.NewUser("bob")
+	if err := server.Fund(alice.ID, 10); err != nil {
+		t.Fatal(err)
+	}
+	invoice, err := client.CreateInvoice(bob, lnbits.InvoiceParams{Amount: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		wallet lnbits.Wallet
+		bolt11 string
+	}{
+		{name: "insufficient balance", wallet: alice, bolt11: invoice.PaymentRequest},
+		{name: "own invoice", wallet: bob, bolt11: invoice.PaymentRequest},
+		{name: "invalid invoice", wallet: alice, bolt11: "lnbc1invalid"},
+		{name: "invoice key", wallet: lnbits.Wallet{Adminkey: alice.Inkey}, bolt11: invoice.PaymentRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Pay(tt.wallet, lnbits.PaymentParams{Out: true, Bolt11: tt.bolt11})
+			if _, ok := err.(lnbits.Error); !ok {
+				t.Errorf("Pay() error = %v, want an lnbits error", err)
+			}
+		})
+	}
+	if balance := server.Balance(alice.ID); balance != 10 {
+		t.Errorf("balance of alice = %d sat, want 10", balance)
+	}
+}
+
+func TestExternalInvoice(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	_, alice := server.NewUser("alice")
+	if err := server.Fund(alice.ID, 1000); err != nil {
+		t.Fatal(err)
+	}
+	invoice, err := server.ExternalInvoice(21, "donation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Pay(alice, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}); err != nil {
+		t.Fatal(err)
+	}
+	if !server.Paid(invoice.PaymentHash) {
+		t.Error("external invoice was not paid")
+	}
+	if balance := server.Balance(alice.ID); balance != 979 {
+		t.Errorf("balance of alice = %d sat, want 979", balance)
+	}
+}
+
+func TestUserManager(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	user, err := client.CreateUserWithInitialWallet("1234", "1234 (@alice)", "", "@alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallets, err := client.Wallets(user)
+	if err != nil || len(wallets) != 1 {
+		t.Fatalf("wallets = %+v, %v, want one wallet", wallets, err)
+	}
+	if wallets[0].Adminkey == "" || wallets[0].Inkey == "" {
+		t.Errorf("wallet without keys %+v", wallets[0])
+	}
+
+	if _, err := lnbits.NewClient("wrong", server.URL).CreateUserWithInitialWallet("1", "1", "", ""); err == nil {
+		t.Error("created a user without the admin key")
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	server.SetUnavailable(true)
+	if err := lnbits.Ping(client); err == nil {
+		t.Error("Ping() of an unavailable server succeeded")
+	}
+	server.SetUnavailable(false)
+	if err := lnbits.Ping(client); err != nil {
+		t.Errorf("Ping() = %v", err)
+	}
+}
+
+func TestPaymentStream(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := server.Client()
+	client.EnableStream()
+	payments := make(chan lnbits.IncomingPayment, 4)
+	client.Subscribe(func(payment lnbits.IncomingPayment) {
+		payments <- payment
+	})
+
+	_, alice := server.NewUser("alice")
+	_, bob := server.NewUser("bob")
+	if err := server.Fund(alice.ID, 1000); err != nil {
+		t.Fatal(err)
+	}
+	invoice, err := client.CreateInvoice(bob, lnbits.InvoiceParams{Amount: 100, Memo: "tip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Pay(alice, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case payment := <-payments:
+		if payment.PaymentHash != invoice.PaymentHash || payment.WalletID != bob.ID || payment.Amount != 100000 {
+			t.Errorf("unexpected payment %+v", payment)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("payment did not arrive on the stream")
+	}
+
+	// the webhook of the same payment is not passed on again
+	client.Notify(lnbits.IncomingPayment{WalletID: bob.ID, PaymentHash: invoice.PaymentHash, Amount: 100000})
+	select {
+	case payment := <-payments:
+		t.Errorf("payment passed on twice %+v", payment)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
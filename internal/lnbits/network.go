@@ -0,0 +1,29 @@
+package lnbits
+
+import (
+	"fmt"
+
+	"github.com/LightningTipBot/LightningTipBot/pkg/lightning"
+)
+
+// NetworkGuard wraps a WalletBackend and refuses to pay invoices of other networks,
+// so that a testnet bot never pays mainnet invoices and the other way around.
+type NetworkGuard struct {
+	WalletBackend
+	network string
+}
+
+var _ WalletBackend = (*NetworkGuard)(nil)
+
+func NewNetworkGuard(backend WalletBackend, network string) *NetworkGuard {
+	return &NetworkGuard{WalletBackend: backend, network: network}
+}
+
+func (g *NetworkGuard) Pay(w Wallet, params PaymentParams) (Invoice, error) {
+	// the backend rejects strings that are no invoice at all
+	if network := lightning.InvoiceNetwork(params.Bolt11); network != "" && network != g.network {
+		// an Error, the backend is not down and the payment must not be retried
+		return Invoice{}, Error{Detail: fmt.Sprintf("invalid invoice: the invoice is for %s, this bot runs on %s", network, g.network)}
+	}
+	return g.WalletBackend.Pay(w, params)
+}
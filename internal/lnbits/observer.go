@@ -0,0 +1,50 @@
+package lnbits
+
+import "sync"
+
+// OutgoingPayment is passed to the handlers of a PaymentObserver when a wallet paid an invoice.
+type OutgoingPayment struct {
+	WalletID      string
+	PaymentHash   string
+	Bolt11        string
+	Recipient     string // see PaymentParams
+	Batch         bool
+	UserInitiated bool
+}
+
+type OutgoingPaymentHandler func(payment OutgoingPayment)
+
+// PaymentObserver wraps a WalletBackend and calls its handlers after every successful
+// outgoing payment, internal transfers between wallets included.
+type PaymentObserver struct {
+	WalletBackend
+	mutex    sync.RWMutex
+	handlers []OutgoingPaymentHandler
+}
+
+var _ WalletBackend = (*PaymentObserver)(nil)
+
+func NewPaymentObserver(backend WalletBackend) *PaymentObserver {
+	return &PaymentObserver{WalletBackend: backend}
+}
+
+// OnPayment registers a handler for outgoing payments. Handlers run before Pay returns.
+func (o *PaymentObserver) OnPayment(handler OutgoingPaymentHandler) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.handlers = append(o.handlers, handler)
+}
+
+func (o *PaymentObserver) Pay(w Wallet, params PaymentParams) (Invoice, error) {
+	invoice, err := o.WalletBackend.Pay(w, params)
+	if err != nil || !params.Out {
+		return invoice, err
+	}
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	for _, handler := range o.handlers {
+		handler(OutgoingPayment{WalletID: w.ID, PaymentHash: invoice.PaymentHash, Bolt11: params.Bolt11,
+			Recipient: params.Recipient, Batch: params.Batch, UserInitiated: params.UserInitiated})
+	}
+	return invoice, nil
+}
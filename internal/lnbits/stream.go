@@ -0,0 +1,179 @@
+package lnbits
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/r3labs/sse"
+	log "github.com/sirupsen/logrus"
+	backoff "gopkg.in/cenkalti/backoff.v1"
+)
+
+const (
+	// streamTimeout is how long a wallet is followed after its last invoice was created.
+	// LNbits invoices expire after an hour by default.
+	streamTimeout = time.Hour
+	// streamRetry is the pause before a stream that gave up reconnecting is opened again.
+	streamRetry = 10 * time.Second
+	// notifiedTTL is how long a payment hash is remembered, so that a payment that arrives
+	// on the stream and at the webhook is only passed on once.
+	notifiedTTL = 2 * time.Hour
+)
+
+// paymentStreams follows the wallets with open invoices on the server-sent events of LNbits.
+type paymentStreams struct {
+	mutex    sync.Mutex
+	enabled  bool
+	wallets  map[string]*paymentStream // by wallet id
+	notified map[string]time.Time      // payment hashes that were passed on
+}
+
+type paymentStream struct {
+	open   map[string]bool // payment hashes of the open invoices
+	until  time.Time
+	cancel context.CancelFunc
+}
+
+func newPaymentStreams() *paymentStreams {
+	return &paymentStreams{
+		wallets:  make(map[string]*paymentStream),
+		notified: make(map[string]time.Time),
+	}
+}
+
+// EnableStream lets the client follow every wallet with an open invoice on the payment
+// stream of LNbits. Payments are then passed on the moment they settle, the webhook is
+// still delivered and only passed on if the stream missed the payment.
+func (c *Client) EnableStream() {
+	c.streams.mutex.Lock()
+	defer c.streams.mutex.Unlock()
+	c.streams.enabled = true
+}
+
+// watch follows the wallet until the invoice is paid or expired.
+func (c *Client) watch(w Wallet, paymentHash string) {
+	c.streams.mutex.Lock()
+	defer c.streams.mutex.Unlock()
+	if !c.streams.enabled {
+		return
+	}
+	stream, ok := c.streams.wallets[w.ID]
+	if !ok {
+		stream = &paymentStream{open: make(map[string]bool)}
+		c.streams.wallets[w.ID] = stream
+		go c.follow(w, stream)
+	}
+	stream.open[paymentHash] = true
+	stream.until = time.Now().Add(streamTimeout)
+}
+
+// settle remembers that a payment was passed on and closes the stream of its wallet if
+// it has no open invoices left. It returns false if the payment was already passed on.
+func (c *Client) settle(payment IncomingPayment) bool {
+	c.streams.mutex.Lock()
+	defer c.streams.mutex.Unlock()
+	if len(payment.PaymentHash) == 0 {
+		return true
+	}
+	now := time.Now()
+	for hash, notified := range c.streams.notified {
+		if now.Sub(notified) > notifiedTTL {
+			delete(c.streams.notified, hash)
+		}
+	}
+	if _, ok := c.streams.notified[payment.PaymentHash]; ok {
+		return false
+	}
+	c.streams.notified[payment.PaymentHash] = now
+	if stream, ok := c.streams.wallets[payment.WalletID]; ok {
+		delete(stream.open, payment.PaymentHash)
+		if len(stream.open) == 0 && stream.cancel != nil {
+			stream.cancel()
+		}
+	}
+	return true
+}
+
+// follow keeps the payment stream of the wallet open while it has open invoices.
+func (c *Client) follow(w Wallet, stream *paymentStream) {
+	for {
+		c.streams.mutex.Lock()
+		if len(stream.open) == 0 || time.Now().After(stream.until) {
+			delete(c.streams.wallets, w.ID)
+			c.streams.mutex.Unlock()
+			return
+		}
+		ctx, cancel := context.WithDeadline(context.Background(), stream.until)
+		stream.cancel = cancel
+		c.streams.mutex.Unlock()
+
+		err := c.subscribeStream(ctx, w, stream)
+		failed := ctx.Err() == nil
+		cancel()
+		if failed {
+			log.Errorf("[lnbits] payment stream of wallet %s stopped: %v", w.ID, err)
+			time.Sleep(streamRetry)
+		}
+	}
+}
+
+// subscribeStream passes on the payments on the stream of the wallet until ctx is done.
+func (c *Client) subscribeStream(ctx context.Context, w Wallet, stream *paymentStream) error {
+	client := sse.NewClient(c.url + "/api/v1/payments/sse")
+	client.Connection.Transport = &http.Transport{DisableCompression: true}
+	client.Headers = map[string]string{"X-Api-Key": w.Inkey}
+	client.ReconnectStrategy = backoff.WithContext(backoff.NewExponentialBackOff(), ctx)
+	client.ResponseValidator = func(_ *sse.Client, resp *http.Response) error {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("could not connect to stream: %s", resp.Status)
+		}
+		// payments that settled while the stream was not connected
+		go c.catchUp(w, stream)
+		return nil
+	}
+	return client.SubscribeWithContext(ctx, "", func(event *sse.Event) {
+		if string(event.Event) != "payment-received" {
+			return
+		}
+		payment := Payment{}
+		if err := json.Unmarshal(event.Data, &payment); err != nil {
+			log.Errorf("[lnbits] could not decode payment of wallet %s: %v", w.ID, err)
+			return
+		}
+		if payment.Pending || payment.Amount <= 0 {
+			return
+		}
+		c.Notify(IncomingPayment{
+			WalletID:    w.ID,
+			PaymentHash: payment.PaymentHash,
+			Amount:      payment.Amount,
+			Memo:        payment.Memo,
+		})
+	})
+}
+
+// catchUp passes on the open invoices of the wallet that are already paid.
+func (c *Client) catchUp(w Wallet, stream *paymentStream) {
+	c.streams.mutex.Lock()
+	var open []string
+	for paymentHash := range stream.open {
+		open = append(open, paymentHash)
+	}
+	c.streams.mutex.Unlock()
+	for _, paymentHash := range open {
+		payment, err := c.Payment(w, paymentHash)
+		if err != nil || !payment.Paid {
+			continue
+		}
+		c.Notify(IncomingPayment{
+			WalletID:    w.ID,
+			PaymentHash: paymentHash,
+			Amount:      payment.Details.Amount,
+			Memo:        payment.Details.Memo,
+		})
+	}
+}
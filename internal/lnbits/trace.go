@@ -0,0 +1,90 @@
+package lnbits
+
+import (
+	"context"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Traced wraps a WalletBackend and records a span for every call as a child of the
+// span in its context, usually the span of the Telegram update.
+type Traced struct {
+	WalletBackend
+	ctx context.Context
+}
+
+var _ WalletBackend = (*Traced)(nil)
+
+func WithTrace(ctx context.Context, backend WalletBackend) *Traced {
+	return &Traced{WalletBackend: backend, ctx: ctx}
+}
+
+func (t *Traced) start(name string, walletID string) trace.Span {
+	_, span := telemetry.Tracer().Start(t.ctx, "wallet."+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("wallet.id", walletID)))
+	return span
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *Traced) CreateUserWithInitialWallet(userName, walletName, adminId string, email string) (User, error) {
+	span := t.start("CreateUserWithInitialWallet", "")
+	user, err := t.WalletBackend.CreateUserWithInitialWallet(userName, walletName, adminId, email)
+	end(span, err)
+	return user, err
+}
+
+func (t *Traced) Wallets(u User) ([]Wallet, error) {
+	span := t.start("Wallets", "")
+	wallets, err := t.WalletBackend.Wallets(u)
+	end(span, err)
+	return wallets, err
+}
+
+func (t *Traced) CreateInvoice(w Wallet, params InvoiceParams) (Invoice, error) {
+	span := t.start("CreateInvoice", w.ID)
+	invoice, err := t.WalletBackend.CreateInvoice(w, params)
+	span.SetAttributes(attribute.String("payment.hash", invoice.PaymentHash), attribute.Int64("payment.amount", params.Amount))
+	end(span, err)
+	return invoice, err
+}
+
+func (t *Traced) Pay(w Wallet, params PaymentParams) (Invoice, error) {
+	span := t.start("Pay", w.ID)
+	invoice, err := t.WalletBackend.Pay(w, params)
+	span.SetAttributes(attribute.String("payment.hash", invoice.PaymentHash))
+	end(span, err)
+	return invoice, err
+}
+
+func (t *Traced) Balance(w Wallet) (Wallet, error) {
+	span := t.start("Balance", w.ID)
+	wallet, err := t.WalletBackend.Balance(w)
+	end(span, err)
+	return wallet, err
+}
+
+func (t *Traced) Payments(w Wallet) (Payments, error) {
+	span := t.start("Payments", w.ID)
+	payments, err := t.WalletBackend.Payments(w)
+	end(span, err)
+	return payments, err
+}
+
+func (t *Traced) Payment(w Wallet, paymentHash string) (LNbitsPayment, error) {
+	span := t.start("Payment", w.ID)
+	span.SetAttributes(attribute.String("payment.hash", paymentHash))
+	payment, err := t.WalletBackend.Payment(w, paymentHash)
+	end(span, err)
+	return payment, err
+}
@@ -15,10 +15,14 @@ import (
 )
 
 type Client struct {
-	header     req.Header
-	url        string
-	AdminKey   string
-	InvoiceKey string
+	header      req.Header
+	url         string
+	AdminKey    string
+	AdminID     string // the users API of LNbits v1 needs an admin user
+	InvoiceKey  string
+	version     APIVersion
+	subscribers []PaymentHandler
+	streams     *paymentStreams
 }
 
 type User struct {
@@ -39,15 +43,100 @@ type User struct {
 }
 
 type Settings struct {
-	ID      string          `json:"id" gorm:"primarykey"`
-	Display DisplaySettings `gorm:"embedded;embeddedPrefix:display_"`
-	Node    NodeSettings    `gorm:"embedded;embeddedPrefix:node_"`
-	Nostr   NostrSettings   `gorm:"embedded;embeddedPrefix:nostr_"`
+	ID           string               `json:"id" gorm:"primarykey"`
+	Display      DisplaySettings      `gorm:"embedded;embeddedPrefix:display_"`
+	Node         NodeSettings         `gorm:"embedded;embeddedPrefix:node_"`
+	Nostr        NostrSettings        `gorm:"embedded;embeddedPrefix:nostr_"`
+	LNURL        LNURLSettings        `gorm:"embedded;embeddedPrefix:lnurl_"`
+	Payment      PaymentSettings      `gorm:"embedded;embeddedPrefix:payment_"`
+	Donation     DonationSettings     `gorm:"embedded;embeddedPrefix:donation_"`
+	Tip          TipSettings          `gorm:"embedded;embeddedPrefix:tip_"`
+	AutoForward  AutoForwardSettings  `gorm:"embedded;embeddedPrefix:autoforward_"`
+	Alert        AlertSettings        `gorm:"embedded;embeddedPrefix:alert_"`
+	PaidMessage  PaidMessageSettings  `gorm:"embedded;embeddedPrefix:pm_"`
+	Notification NotificationSettings `gorm:"embedded;embeddedPrefix:notification_"`
+	Birthday     BirthdaySettings     `gorm:"embedded;embeddedPrefix:birthday_"`
+	RoundUp      RoundUpSettings      `gorm:"embedded;embeddedPrefix:roundup_"`
 }
 
 type DisplaySettings struct {
 	DisplayCurrency string `json:"displaycurrency"`
+	Language        string `json:"language"`
+	// Unit of the amounts that the bot shows the user: sat, btc or bits. Empty is sat.
+	Unit string `json:"unit"`
 }
+
+// LNURLSettings configure the successAction that wallets show after paying the user's lightning address.
+type LNURLSettings struct {
+	SuccessMessage string `json:"successmessage"`
+	SuccessURL     string `json:"successurl"`
+}
+
+// PaymentSettings configure how outgoing payments of the user are confirmed.
+type PaymentSettings struct {
+	// payments up to ConfirmAbove sat are sent without asking for confirmation
+	ConfirmAbove int64 `json:"confirmabove"`
+	// payments whose routing fee may exceed MaxFeeSat or MaxFeePercent of the amount
+	// ask for an override, zero means no limit
+	MaxFeePercent float64 `json:"maxfeepercent"`
+	MaxFeeSat     int64   `json:"maxfeesat"`
+}
+
+// DonationSettings configure how the donations of the user are shown to others.
+type DonationSettings struct {
+	// Public names the user to donation recipients and on the donor leaderboard
+	Public bool `json:"public"`
+}
+
+type TipSettings struct {
+	// Anonymous hides the user from the receivers of tips unless a tip says public
+	Anonymous bool `json:"anonymous"`
+}
+
+// AutoForwardSettings sweep the balance above Threshold sat to a lightning address. An empty
+// Address turns auto-forwarding off.
+type AutoForwardSettings struct {
+	Address   string `json:"address"`
+	Threshold int64  `json:"threshold"`
+}
+
+// AlertSettings are the thresholds in sat at which the user is alerted about their outgoing
+// payments. Zero turns an alert off.
+type AlertSettings struct {
+	BalanceBelow      int64 `json:"balancebelow"`
+	PaymentAbove      int64 `json:"paymentabove"`
+	DailyOutflowAbove int64 `json:"dailyoutflowabove"`
+}
+
+// PaidMessageSettings gate the user: strangers pay Price sat to reach them with /pm. Zero
+// turns the gate off.
+type PaidMessageSettings struct {
+	Price int64 `json:"price"`
+}
+
+// BirthdaySettings hold the birthday of the user that groups celebrate. Zero is no birthday.
+type BirthdaySettings struct {
+	Month int `json:"month"`
+	Day   int `json:"day"`
+}
+
+// RoundUpSettings round every outgoing payment up to the next multiple of Step sat. The
+// differences are donated to Address every week. Zero turns round-ups off.
+type RoundUpSettings struct {
+	Step    int64  `json:"step"`
+	Address string `json:"address"`
+}
+
+// NotificationSettings configure which notifications the user gets and when.
+type NotificationSettings struct {
+	// tips below MinTip sat are not notified, zero notifies all tips
+	MinTip       int64 `json:"mintip"`
+	MuteFaucet   bool  `json:"mutefaucet"`
+	MuteMentions bool  `json:"mutementions"`
+	// Digest collects the notifications and sends them once a day
+	Digest bool `json:"digest"`
+}
+
 type NostrSettings struct {
 	PubKey string `json:"pubkey"`
 }
@@ -74,6 +163,7 @@ const (
 	UserStateShopItemSendItemFile
 	UserEnterShopsDescription
 	UserEnterDallePrompt
+	UserStatePos
 )
 
 type UserStateKey int
@@ -95,6 +185,25 @@ type InvoiceParams struct {
 type PaymentParams struct {
 	Out    bool   `json:"out"`
 	Bolt11 string `json:"bolt11"`
+	// Progress is called while the parts of a multi-part payment are in flight, if the backend reports them
+	Progress func(PaymentProgress) `json:"-"`
+	// Recipient is the receiving wallet of an internal transfer, the payee of all internal
+	// transfers is the same node
+	Recipient string `json:"-"`
+	// Batch marks a payment of a batch that the user confirmed as a whole
+	Batch bool `json:"-"`
+	// UserInitiated marks a payment that the user made with /pay, /tip or /send, unlike the fees,
+	// deposits and refunds that the bot pays on behalf of the user
+	UserInitiated bool `json:"-"`
+}
+
+// PaymentProgress is the state of the parts (HTLCs) of an outgoing payment.
+type PaymentProgress struct {
+	Parts       int   // parts that are in flight or arrived
+	Arrived     int   // parts that arrived at the receiver
+	Failed      int   // parts that failed and were retried
+	AmountMsat  int64 // amount of the parts that are in flight or arrived
+	ArrivedMsat int64 // amount of the parts that arrived
 }
 type PayParams struct {
 	// the BOLT11 payment request you want to pay.
@@ -153,8 +262,7 @@ type Payments []Payment
 
 type Invoice struct {
 	PaymentHash    string `json:"payment_hash"`
-	// PaymentRequest string `json:"payment_request"`
-	PaymentRequest string `json:"bolt11"` // Update for LNBits v1.2.1
+	PaymentRequest string `json:"bolt11"` // LNbits v0 calls it payment_request, see invoiceV0
 }
 
 // from fiatjaf/lnurl-go
@@ -2,34 +2,25 @@ package webhook
 
 import (
 	"encoding/json"
-	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/LightningTipBot/LightningTipBot/internal"
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
-	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
-
-	log "github.com/sirupsen/logrus"
-	"gorm.io/gorm"
-
-	"net/http"
-
-	"github.com/LightningTipBot/LightningTipBot/internal/storage"
-
 	"github.com/gorilla/mux"
-	tb "gopkg.in/lightningtipbot/telebot.v3"
-
-	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	log "github.com/sirupsen/logrus"
 )
 
+// Server receives the webhooks of paid invoices from LNbits and passes them
+// on to the subscribers of the lnbits client.
 type Server struct {
 	httpServer *http.Server
-	bot        *tb.Bot
 	c          *lnbits.Client
-	database   *gorm.DB
-	buntdb     *storage.DB
+	knows      WalletLookup
 }
 
+// WalletLookup returns true if the wallet belongs to the bot.
+type WalletLookup func(walletID string) (bool, error)
 type Webhook struct {
 	CheckingID    string      `json:"checking_id"`
 	Pending       bool        `json:"pending"`
@@ -46,18 +37,16 @@ type Webhook struct {
 	WebhookStatus interface{} `json:"webhook_status"`
 }
 
-func NewServer(bot *telegram.TipBot) *Server {
+func NewServer(c *lnbits.Client, knows WalletLookup) *Server {
 	srv := &http.Server{
 		Addr:         internal.Configuration.Lnbits.WebhookServerUrl.Host,
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 	}
 	apiServer := &Server{
-		c:          bot.Client,
-		database:   bot.DB.Users,
-		bot:        bot.Telegram,
+		c:          c,
 		httpServer: srv,
-		buntdb:     bot.Bunt,
+		knows:      knows,
 	}
 	apiServer.httpServer.Handler = apiServer.newRouter()
 	go apiServer.httpServer.ListenAndServe()
@@ -65,15 +54,6 @@ func NewServer(bot *telegram.TipBot) *Server {
 	return apiServer
 }
 
-func (w *Server) GetUserByWalletId(walletId string) (*lnbits.User, error) {
-	user := &lnbits.User{}
-	tx := w.database.Where("wallet_id = ?", walletId).First(user)
-	if tx.Error != nil {
-		return user, tx.Error
-	}
-	return user, nil
-}
-
 func (w *Server) newRouter() *mux.Router {
 	router := mux.NewRouter()
 	router.HandleFunc("/", w.receive).Methods(http.MethodPost)
@@ -91,36 +71,24 @@ func (w *Server) receive(writer http.ResponseWriter, request *http.Request) {
 		writer.WriteHeader(400)
 		return
 	}
-	user, err := w.GetUserByWalletId(webhookEvent.WalletID)
+	known, err := w.knows(webhookEvent.WalletID)
 	if err != nil {
-		log.Errorf("[Webhook] Error getting user: %s", err.Error())
-		writer.WriteHeader(400)
+		log.Errorf("[Webhook] Error looking up wallet %s: %s", webhookEvent.WalletID, err.Error())
+		writer.WriteHeader(500)
 		return
 	}
-	log.Infoln(fmt.Sprintf("[⚡️ WebHook] User %s (%d) received invoice of %d sat.", telegram.GetUserStr(user.Telegram), user.Telegram.ID, webhookEvent.Amount/1000))
-
-	writer.WriteHeader(200)
-
-	// trigger invoice events
-	txInvoiceEvent := &telegram.InvoiceEvent{Invoice: &telegram.Invoice{PaymentHash: webhookEvent.PaymentHash}}
-	err = w.buntdb.Get(txInvoiceEvent)
-	if err != nil {
-		log.Errorln(err)
-	} else {
-		// do something with the event
-		if c := telegram.InvoiceCallback[txInvoiceEvent.Callback]; c.Function != nil {
-			if err := telegram.AssertEventType(txInvoiceEvent, c.Type); err != nil {
-				log.Errorln(err)
-				return
-			}
-			go c.Function(txInvoiceEvent)
-			return
-		}
+	// a callback for a wallet of another service was misrouted or forged
+	if !known {
+		log.Warnf("[Webhook] Payment %s for unknown wallet %q from %s", webhookEvent.PaymentHash, webhookEvent.WalletID, request.RemoteAddr)
+		writer.WriteHeader(404)
+		return
 	}
+	writer.WriteHeader(200)
 
-	// fallback: send a message to the user if there is no callback for this invoice
-	_, err = w.bot.Send(user.Telegram, fmt.Sprintf(i18n.Translate(user.Telegram.LanguageCode, "invoiceReceivedMessage"), webhookEvent.Amount/1000))
-	if err != nil {
-		log.Errorln(err)
-	}
+	w.c.Notify(lnbits.IncomingPayment{
+		WalletID:    webhookEvent.WalletID,
+		PaymentHash: webhookEvent.PaymentHash,
+		Amount:      webhookEvent.Amount,
+		Memo:        webhookEvent.Memo,
+	})
 }
@@ -1,21 +1,433 @@
+// Package lndhub serves the LNDHub API on the wallets of the bot, so that users can use
+// their wallet in BlueWallet, Zeus and other LNDHub clients.
 package lndhub
 
 import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/LightningTipBot/LightningTipBot/internal"
-	"github.com/LightningTipBot/LightningTipBot/internal/api"
+	db "github.com/LightningTipBot/LightningTipBot/internal/database"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
+// error codes of LNDHub
+const (
+	errorBadAuth          = 1
+	errorNotEnoughBalance = 2
+	errorServer           = 6
+	errorBadArguments     = 8
+	errorPaymentFailed    = 10
+)
+
+// defaultTransactionsMax is the number of transactions and invoices returned without a limit.
+const defaultTransactionsMax = 100
+
 type LndHub struct {
+	bot      *telegram.TipBot
 	database *gorm.DB
 }
 
 func New(bot *telegram.TipBot) LndHub {
-	return LndHub{database: bot.DB.Users}
+	return LndHub{bot: bot, database: bot.DB.Users}
+}
+
+type errorResponse struct {
+	Error   bool   `json:"error"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func respond(writer http.ResponseWriter, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(body)
+}
+
+// respondError answers with an LNDHub error, which clients expect with status 200.
+func respondError(writer http.ResponseWriter, code int, message string) {
+	respond(writer, errorResponse{Error: true, Code: code, Message: message})
+}
+
+type authRequest struct {
+	Login        string `json:"login"`
+	Password     string `json:"password"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type authResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Auth exchanges the admin key of a wallet or a refresh token for a new session. The tokens
+// of a session are random, /lndhub revoke in Telegram ends all sessions of a user.
+func (w LndHub) Auth(writer http.ResponseWriter, request *http.Request) {
+	var auth authRequest
+	if err := json.NewDecoder(request.Body).Decode(&auth); err != nil {
+		respondError(writer, errorBadArguments, "bad request")
+		return
+	}
+	if len(auth.RefreshToken) > 0 {
+		access, refresh, err := w.bot.RefreshLndhubSession(auth.RefreshToken)
+		if err != nil {
+			respondError(writer, errorBadAuth, "bad auth")
+			return
+		}
+		respond(writer, authResponse{AccessToken: access, RefreshToken: refresh})
+		return
+	}
+	if len(auth.Password) == 0 || strings.Contains(auth.Password, "_") {
+		// banned users have a prefixed key
+		respondError(writer, errorBadAuth, "bad auth")
+		return
+	}
+	user := &lnbits.User{}
+	if tx := w.database.Where(db.EqualFold("wallet_adminkey"), auth.Password).First(user); tx.Error != nil {
+		respondError(writer, errorBadAuth, "bad auth")
+		return
+	}
+	access, refresh, err := w.bot.CreateLndhubSession(user)
+	if err != nil {
+		log.Errorf("[lndhub] could not create session of %s: %v", telegram.GetUserStr(user.Telegram), err)
+		respondError(writer, errorServer, "could not log in")
+		return
+	}
+	respond(writer, authResponse{AccessToken: access, RefreshToken: refresh})
+}
+
+// Authorize passes requests with a valid access token to next, with the user in the context.
+func (w LndHub) Authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		token := request.Header.Get("Authorization")
+		if len(token) < len("Bearer ") || !strings.EqualFold(token[:len("Bearer ")], "Bearer ") {
+			respondError(writer, errorBadAuth, "bad auth")
+			return
+		}
+		user, err := w.bot.LndhubUser(token[len("Bearer "):])
+		if err != nil {
+			respondError(writer, errorBadAuth, "bad auth")
+			return
+		}
+		next.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), "user", user)))
+	}
+}
+
+// GetInfo describes the node, clients only check that it answers.
+func (w LndHub) GetInfo(writer http.ResponseWriter, request *http.Request) {
+	respond(writer, map[string]interface{}{
+		"alias":               "LightningTipBot",
+		"identity_pubkey":     "",
+		"num_active_channels": 0,
+		"synced_to_chain":     true,
+		"block_height":        0,
+		"chains":              []map[string]string{{"chain": "bitcoin", "network": internal.Configuration.Bot.Network}},
+	})
+}
+
+// Balance returns the balance of the wallet in sat.
+func (w LndHub) Balance(writer http.ResponseWriter, request *http.Request) {
+	user := telegram.LoadUser(request.Context())
+	balance, err := w.bot.GetUserBalance(user)
+	if err != nil {
+		respondError(writer, errorServer, "could not fetch balance")
+		return
+	}
+	respond(writer, map[string]interface{}{"BTC": map[string]int64{"AvailableBalance": balance}})
+}
+
+type addInvoiceRequest struct {
+	Amount          json.Number `json:"amt"`
+	Memo            string      `json:"memo"`
+	DescriptionHash string      `json:"description_hash"`
+}
+
+type invoiceResponse struct {
+	RHash          string `json:"r_hash"`
+	PaymentHash    string `json:"payment_hash"`
+	PaymentRequest string `json:"payment_request"`
+	PayReq         string `json:"pay_req"`
+	AddIndex       string `json:"add_index"`
+}
+
+// AddInvoice creates an invoice that pays to the wallet.
+func (w LndHub) AddInvoice(writer http.ResponseWriter, request *http.Request) {
+	user := telegram.LoadUser(request.Context())
+	var params addInvoiceRequest
+	if err := json.NewDecoder(request.Body).Decode(&params); err != nil {
+		respondError(writer, errorBadArguments, "bad request")
+		return
+	}
+	amount, err := strconv.ParseInt(params.Amount.String(), 10, 64)
+	if err != nil || amount <= 0 {
+		respondError(writer, errorBadArguments, "invalid amount")
+		return
+	}
+	invoice, err := w.bot.Client.CreateInvoice(*user.Wallet, lnbits.InvoiceParams{
+		Amount:          amount,
+		Memo:            params.Memo,
+		DescriptionHash: params.DescriptionHash,
+		Webhook:         internal.Configuration.Lnbits.WebhookServer,
+	})
+	if err != nil {
+		log.Errorf("[lndhub] could not create invoice for %s: %v", telegram.GetUserStr(user.Telegram), err)
+		respondError(writer, errorServer, "could not create invoice")
+		return
+	}
+	respond(writer, invoiceResponse{
+		RHash:          invoice.PaymentHash,
+		PaymentHash:    invoice.PaymentHash,
+		PaymentRequest: invoice.PaymentRequest,
+		PayReq:         invoice.PaymentRequest,
+		AddIndex:       "1",
+	})
+}
+
+type payInvoiceRequest struct {
+	Invoice string `json:"invoice"`
 }
-func (w LndHub) Handle(writer http.ResponseWriter, request *http.Request) {
-	api.Proxy(writer, request, internal.Configuration.Lnbits.Url)
+
+type paymentResponse struct {
+	PaymentError    string         `json:"payment_error"`
+	PaymentPreimage string         `json:"payment_preimage"`
+	PaymentHash     string         `json:"payment_hash"`
+	PaymentRoute    paymentRoute   `json:"payment_route"`
+	Decoded         decodeResponse `json:"decoded"`
+	Type            string         `json:"type"`
+	Fee             int64          `json:"fee"`
+	Value           int64          `json:"value"`
+	Timestamp       int64          `json:"timestamp"`
+	Memo            string         `json:"memo"`
+}
+
+type paymentRoute struct {
+	TotalAmount int64 `json:"total_amt"`
+	TotalFees   int64 `json:"total_fees"`
+}
+
+// PayInvoice pays an invoice from the wallet.
+func (w LndHub) PayInvoice(writer http.ResponseWriter, request *http.Request) {
+	user := telegram.LoadUser(request.Context())
+	var params payInvoiceRequest
+	if err := json.NewDecoder(request.Body).Decode(&params); err != nil {
+		respondError(writer, errorBadArguments, "bad request")
+		return
+	}
+	bolt11, err := decodepay.Decodepay(params.Invoice)
+	if err != nil {
+		respondError(writer, errorBadArguments, "invalid invoice")
+		return
+	}
+	if bolt11.MSatoshi == 0 {
+		respondError(writer, errorBadArguments, "invoices without amount are not supported")
+		return
+	}
+	balance, err := w.bot.GetUserBalance(user)
+	if err == nil && balance < bolt11.MSatoshi/1000 {
+		respondError(writer, errorNotEnoughBalance, "not enough balance")
+		return
+	}
+	log.Infof("[lndhub] %s pays invoice of %d sat", telegram.GetUserStr(user.Telegram), bolt11.MSatoshi/1000)
+	// the limits of the user apply, larger payments wait for the confirmation in Telegram
+	invoice, err := w.bot.PayInvoiceFromAPI(user, params.Invoice)
+	if err != nil {
+		log.Errorf("[lndhub] payment of %s failed: %v", telegram.GetUserStr(user.Telegram), err)
+		respondError(writer, errorPaymentFailed, "payment failed: "+err.Error())
+		return
+	}
+	response := paymentResponse{
+		PaymentHash:  invoice.PaymentHash,
+		PaymentRoute: paymentRoute{TotalAmount: bolt11.MSatoshi / 1000},
+		Decoded:      decode(bolt11),
+		Type:         "paid_invoice",
+		Value:        bolt11.MSatoshi / 1000,
+		Timestamp:    int64(bolt11.CreatedAt),
+		Memo:         bolt11.Description,
+	}
+	if payment, err := w.bot.Client.Payment(*user.Wallet, invoice.PaymentHash); err == nil {
+		response.PaymentPreimage = payment.Preimage
+		response.Fee = abs(payment.Details.Fee) / 1000
+		response.PaymentRoute.TotalFees = response.Fee
+	}
+	respond(writer, response)
+}
+
+type transaction struct {
+	PaymentPreimage string `json:"payment_preimage"`
+	PaymentHash     string `json:"payment_hash"`
+	Type            string `json:"type"`
+	Fee             int64  `json:"fee"`
+	Value           int64  `json:"value"`
+	Timestamp       int64  `json:"timestamp"`
+	Memo            string `json:"memo"`
+}
+
+// GetTxs returns the settled outgoing payments of the wallet, the newest first.
+func (w LndHub) GetTxs(writer http.ResponseWriter, request *http.Request) {
+	user := telegram.LoadUser(request.Context())
+	payments, err := w.bot.Client.Payments(*user.Wallet)
+	if err != nil {
+		respondError(writer, errorServer, "could not fetch payments")
+		return
+	}
+	transactions := []transaction{}
+	for _, p := range payments {
+		if p.Pending || p.Amount >= 0 {
+			continue
+		}
+		transactions = append(transactions, transaction{
+			PaymentPreimage: p.Preimage,
+			PaymentHash:     p.PaymentHash,
+			Type:            "paid_invoice",
+			Fee:             abs(int64(p.Fee)) / 1000,
+			Value:           -p.Amount / 1000,
+			Timestamp:       int64(p.Time),
+			Memo:            p.Memo,
+		})
+	}
+	respond(writer, page(request, transactions))
+}
+
+type userInvoice struct {
+	RHash          string `json:"r_hash"`
+	PaymentHash    string `json:"payment_hash"`
+	PaymentRequest string `json:"payment_request"`
+	AddIndex       string `json:"add_index"`
+	Description    string `json:"description"`
+	IsPaid         bool   `json:"ispaid"`
+	Amount         int64  `json:"amt"`
+	ExpireTime     int64  `json:"expire_time"`
+	Timestamp      int64  `json:"timestamp"`
+	Type           string `json:"type"`
+}
+
+// GetUserInvoices returns the invoices of the wallet, paid and unpaid, the newest first.
+func (w LndHub) GetUserInvoices(writer http.ResponseWriter, request *http.Request) {
+	user := telegram.LoadUser(request.Context())
+	payments, err := w.bot.Client.Payments(*user.Wallet)
+	if err != nil {
+		respondError(writer, errorServer, "could not fetch invoices")
+		return
+	}
+	invoices := []userInvoice{}
+	for _, p := range payments {
+		if p.Amount <= 0 {
+			continue
+		}
+		invoice := userInvoice{
+			RHash:          p.PaymentHash,
+			PaymentHash:    p.PaymentHash,
+			PaymentRequest: p.Bolt11,
+			AddIndex:       "1",
+			Description:    p.Memo,
+			IsPaid:         !p.Pending,
+			Amount:         p.Amount / 1000,
+			ExpireTime:     3600,
+			Timestamp:      int64(p.Time),
+			Type:           "user_invoice",
+		}
+		if bolt11, err := decodepay.Decodepay(p.Bolt11); err == nil {
+			invoice.ExpireTime = int64(bolt11.Expiry)
+			invoice.Timestamp = int64(bolt11.CreatedAt)
+		}
+		invoices = append(invoices, invoice)
+	}
+	sort.SliceStable(invoices, func(i, j int) bool { return invoices[i].Timestamp > invoices[j].Timestamp })
+	respond(writer, page(request, invoices))
+}
+
+// GetPending returns the pending on-chain transactions, the bot has none.
+func (w LndHub) GetPending(writer http.ResponseWriter, request *http.Request) {
+	respond(writer, []interface{}{})
+}
+
+// GetBtc returns the on-chain deposit addresses, the bot has none.
+func (w LndHub) GetBtc(writer http.ResponseWriter, request *http.Request) {
+	respond(writer, []interface{}{})
+}
+
+type decodeResponse struct {
+	Destination     string `json:"destination"`
+	PaymentHash     string `json:"payment_hash"`
+	NumSatoshis     string `json:"num_satoshis"`
+	NumMsat         string `json:"num_msat"`
+	Timestamp       string `json:"timestamp"`
+	Expiry          string `json:"expiry"`
+	Description     string `json:"description"`
+	DescriptionHash string `json:"description_hash"`
+	CltvExpiry      string `json:"cltv_expiry"`
+}
+
+func decode(bolt11 decodepay.Bolt11) decodeResponse {
+	return decodeResponse{
+		Destination:     bolt11.Payee,
+		PaymentHash:     bolt11.PaymentHash,
+		NumSatoshis:     strconv.FormatInt(bolt11.MSatoshi/1000, 10),
+		NumMsat:         strconv.FormatInt(bolt11.MSatoshi, 10),
+		Timestamp:       strconv.Itoa(bolt11.CreatedAt),
+		Expiry:          strconv.Itoa(bolt11.Expiry),
+		Description:     bolt11.Description,
+		DescriptionHash: bolt11.DescriptionHash,
+		CltvExpiry:      strconv.Itoa(bolt11.MinFinalCLTVExpiry),
+	}
+}
+
+// DecodeInvoice decodes the invoice in the query.
+func (w LndHub) DecodeInvoice(writer http.ResponseWriter, request *http.Request) {
+	bolt11, err := decodepay.Decodepay(request.URL.Query().Get("invoice"))
+	if err != nil {
+		respondError(writer, errorBadArguments, "invalid invoice")
+		return
+	}
+	respond(writer, decode(bolt11))
+}
+
+// CheckPayment tells whether an invoice of the wallet is paid.
+func (w LndHub) CheckPayment(writer http.ResponseWriter, request *http.Request) {
+	user := telegram.LoadUser(request.Context())
+	paymentHash := mux.Vars(request)["payment_hash"]
+	if _, err := hex.DecodeString(paymentHash); err != nil {
+		respondError(writer, errorBadArguments, "invalid payment hash")
+		return
+	}
+	payment, err := w.bot.Client.Payment(*user.Wallet, paymentHash)
+	if err != nil {
+		respondError(writer, errorServer, "could not check payment")
+		return
+	}
+	respond(writer, map[string]bool{"paid": payment.Paid})
+}
+
+// page applies the limit and offset in the query to items.
+func page[T any](request *http.Request, items []T) []T {
+	limit, err := strconv.Atoi(request.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultTransactionsMax
+	}
+	offset, _ := strconv.Atoi(request.URL.Query().Get("offset"))
+	if offset < 0 || offset >= len(items) {
+		return []T{}
+	}
+	items = items[offset:]
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
 }
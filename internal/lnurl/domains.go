@@ -0,0 +1,96 @@
+package lnurl
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	log "github.com/sirupsen/logrus"
+)
+
+// Address is a lightning address served by the bot. Name and Host are the two parts
+// of the address, Username is the bot user that receives the payments.
+type Address struct {
+	Name     string
+	Host     string
+	Username string
+}
+
+func (a Address) String() string {
+	return fmt.Sprintf("%s@%s", a.Name, a.Host)
+}
+
+// requestHost returns the host a request was made to without the port.
+// If the bot runs behind a trusted reverse proxy, X-Forwarded-Host is used.
+func requestHost(request *http.Request) string {
+	host := request.Host
+	if forwarded := request.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		if trustedProxy(request.RemoteAddr) {
+			host = forwarded
+		} else {
+			log.Warnf("[requestHost] ignoring X-Forwarded-Host %s from untrusted %s", forwarded, request.RemoteAddr)
+		}
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// trustedProxy returns true if remoteAddr is one of the configured trusted proxies.
+func trustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range internal.Configuration.Bot.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if trusted := net.ParseIP(proxy); trusted != nil && trusted.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveAddress maps name on the host of the request to a bot user. Requests to hosts
+// that are not configured as custom domains are served on the default domain.
+func ResolveAddress(request *http.Request, name string) (Address, error) {
+	return resolveAddress(requestHost(request), name)
+}
+
+func resolveAddress(host string, name string) (Address, error) {
+	for _, domain := range internal.CurrentConfiguration().Bot.LNURLDomains {
+		if domain.Host != host {
+			continue
+		}
+		if username, ok := domain.Users[strings.ToLower(name)]; ok {
+			return Address{Name: name, Host: host, Username: username}, nil
+		}
+		if domain.AllUsers {
+			return Address{Name: name, Host: host, Username: name}, nil
+		}
+		return Address{}, fmt.Errorf("address %s@%s not found", name, host)
+	}
+	return Address{Name: name, Host: internal.Configuration.Bot.LNURLHostUrl.Hostname(), Username: name}, nil
+}
+
+// callbackURL returns the url of the LNURLp endpoint of an address on its own domain
+// so that the second request of the wallet resolves to the same user.
+func (w Lnurl) callbackURL(address Address) (*url.URL, error) {
+	if address.Host == w.callbackHostname.Hostname() {
+		return url.Parse(fmt.Sprintf("%s/%s/%s", w.callbackHostname.String(), Endpoint, address.Name))
+	}
+	return url.Parse(fmt.Sprintf("%s://%s/%s/%s", w.callbackHostname.Scheme, address.Host, Endpoint, address.Name))
+}
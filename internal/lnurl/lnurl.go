@@ -45,15 +45,16 @@ type Invoice struct {
 	Paid               bool         `json:"paid"`
 	PaidAt             time.Time    `json:"paid_at"`
 	From               string       `json:"from"`
+	FromName           string       `json:"from_name"`
 	Nip57Receipt       nostr.Event  `json:"nip57_receipt"`
 	Nip57ReceiptRelays []string     `json:"nip57_receipt_relays"`
 }
 type Lnurl struct {
 	telegram         *tb.Bot
-	c                *lnbits.Client
+	c                lnbits.WalletBackend
 	database         *gorm.DB
 	callbackHostname *url.URL
-	buntdb           *storage.DB
+	buntdb           storage.Store
 	WebhookServer    string
 	cache            telegram.Cache
 	bot              *telegram.TipBot
@@ -79,8 +80,13 @@ func (w Lnurl) Handle(writer http.ResponseWriter, request *http.Request) {
 	var err error
 	var response interface{}
 	username := mux.Vars(request)["username"]
+	address, err := ResolveAddress(request, username)
+	if err != nil {
+		api.NotFoundHandler(writer, fmt.Errorf("[handleLnUrl] %v", err))
+		return
+	}
 	if request.URL.RawQuery == "" {
-		response, err = w.serveLNURLpFirst(username)
+		response, err = w.serveLNURLpFirst(address)
 	} else {
 		stringAmount := request.FormValue("amount")
 		if stringAmount == "" {
@@ -145,7 +151,7 @@ func (w Lnurl) Handle(writer http.ResponseWriter, request *http.Request) {
 			}
 		}
 
-		response, err = w.serveLNURLpSecond(username, int64(amount), comment, payerData, zapEvent)
+		response, err = w.serveLNURLpSecond(address, int64(amount), comment, payerData, zapEvent)
 	}
 	// check if error was returned from first or second handlers
 	if err != nil {
@@ -166,8 +172,8 @@ func (w Lnurl) Handle(writer http.ResponseWriter, request *http.Request) {
 		api.NotFoundHandler(writer, err)
 	}
 }
-func (w Lnurl) getMetaDataCached(username string) lnurl.Metadata {
-	key := fmt.Sprintf("lnurl_metadata_%s", username)
+func (w Lnurl) getMetaDataCached(address Address) lnurl.Metadata {
+	key := fmt.Sprintf("lnurl_metadata_%s", address)
 
 	// load metadata from cache
 	if m, err := w.cache.Get(key); err == nil {
@@ -175,14 +181,14 @@ func (w Lnurl) getMetaDataCached(username string) lnurl.Metadata {
 	}
 
 	// otherwise, create new metadata
-	metadata := w.metaData(username)
+	metadata := w.metaData(address)
 
 	// load the user profile picture
-	if internal.Configuration.Bot.LNURLSendImage {
+	if internal.CurrentConfiguration().Bot.LNURLSendImage {
 		// get the user from the database
-		user, tx := db.FindUser(w.database, username)
+		user, tx := db.FindUser(w.database, address.Username)
 		if tx.Error == nil && user.Telegram != nil {
-			addImageToMetaData(w.telegram, &metadata, username, user.Telegram)
+			addImageToMetaData(w.telegram, &metadata, address.Username, user.Telegram)
 		}
 	}
 
@@ -209,15 +215,15 @@ type LNURLPayParamsCustom struct {
 
 // serveLNURLpFirst serves the first part of the LNURLp protocol with the endpoint
 // to call and the metadata that matches the description hash of the second response
-func (w Lnurl) serveLNURLpFirst(username string) (*LNURLPayParamsCustom, error) {
-	log.Infof("[LNURL] Serving endpoint for user %s", username)
-	callbackURL, err := url.Parse(fmt.Sprintf("%s/%s/%s", w.callbackHostname.String(), Endpoint, username))
+func (w Lnurl) serveLNURLpFirst(address Address) (*LNURLPayParamsCustom, error) {
+	log.Infof("[LNURL] Serving endpoint for user %s (%s)", address.Username, address)
+	callbackURL, err := w.callbackURL(address)
 	if err != nil {
 		return nil, err
 	}
 
 	// produce the metadata including the image
-	metadata := w.getMetaDataCached(username)
+	metadata := w.getMetaDataCached(address)
 
 	// check if the user has added a nostr key for nip57
 	var allowNostr bool = false
@@ -249,8 +255,9 @@ func (w Lnurl) serveLNURLpFirst(username string) (*LNURLPayParamsCustom, error)
 }
 
 // serveLNURLpSecond serves the second LNURL response with the payment request with the correct description hash
-func (w Lnurl) serveLNURLpSecond(username string, amount_msat int64, comment string, payerData lnurl.PayerDataValues, zapEvent nostr.Event) (*lnurl.LNURLPayValues, error) {
-	log.Infof("[LNURL] Serving invoice for user %s", username)
+func (w Lnurl) serveLNURLpSecond(address Address, amount_msat int64, comment string, payerData lnurl.PayerDataValues, zapEvent nostr.Event) (*lnurl.LNURLPayValues, error) {
+	username := address.Username
+	log.Infof("[LNURL] Serving invoice for user %s (%s)", username, address)
 	if amount_msat < MinSendable || amount_msat > MaxSendable {
 		// amount is not ok
 		return &lnurl.LNURLPayValues{
@@ -298,6 +305,8 @@ func (w Lnurl) serveLNURLpSecond(username string, amount_msat int64, comment str
 
 	var resp *lnurl.LNURLPayValues
 	var descriptionHash string
+	// node backends that can't sign a bare description hash need the unhashed description
+	var unhashedDescription string
 
 	// NIP57 ZAPs
 	// TODO: refactor all this into nip57.go
@@ -335,10 +344,11 @@ func (w Lnurl) serveLNURLpSecond(username string, amount_msat int64, comment str
 		}
 		// calculate description hash from the serialized nostr event
 		descriptionHash = w.Nip57DescriptionHash(zapEventSerializedStr)
+		unhashedDescription = hex.EncodeToString([]byte(zapEventSerializedStr))
 	} else {
 		// calculate normal LNURL descriptionhash
 		// the same description_hash needs to be built in the second request
-		metadata := w.getMetaDataCached(username)
+		metadata := w.getMetaDataCached(address)
 
 		var payerDataByte []byte
 		var err error
@@ -355,15 +365,16 @@ func (w Lnurl) serveLNURLpSecond(username string, amount_msat int64, comment str
 		if err != nil {
 			return nil, err
 		}
+		unhashedDescription = hex.EncodeToString([]byte(metadata.Encode() + string(payerDataByte)))
 	}
 
-	invoice, err := user.Wallet.Invoice(
+	invoice, err := w.c.CreateInvoice(*user.Wallet,
 		lnbits.InvoiceParams{
-			Amount:          amount_msat / 1000,
-			Out:             false,
-			DescriptionHash: descriptionHash,
-			Webhook:         w.WebhookServer},
-		w.c)
+			Amount:              amount_msat / 1000,
+			Out:                 false,
+			DescriptionHash:     descriptionHash,
+			UnhashedDescription: unhashedDescription,
+			Webhook:             w.WebhookServer})
 	if err != nil {
 		err = fmt.Errorf("[serveLNURLpSecond] Couldn't create invoice: %v", err.Error())
 		resp = &lnurl.LNURLPayValues{
@@ -408,6 +419,7 @@ func (w Lnurl) serveLNURLpSecond(username string, amount_msat int64, comment str
 			Comment:            comment,
 			CreatedAt:          time.Now(),
 			From:               extractSenderFromPayerdata(payerData),
+			FromName:           payerData.FreeName,
 			Nip57Receipt:       nip57Receipt,
 			Nip57ReceiptRelays: nip57ReceiptRelays,
 		}))
@@ -418,13 +430,14 @@ func (w Lnurl) serveLNURLpSecond(username string, amount_msat int64, comment str
 			User:         user,
 			Callback:     telegram.InvoiceCallbackLNURLPayReceive,
 			UserCurrency: user.Settings.Display.DisplayCurrency,
+			Open:         true,
 		}))
 
 	return &lnurl.LNURLPayValues{
 		LNURLResponse: lnurl.LNURLResponse{Status: api.StatusOk},
 		PR:            invoice.PaymentRequest,
 		Routes:        make([]struct{}, 0),
-		SuccessAction: &lnurl.SuccessAction{Message: "Payment received!", Tag: "message"},
+		SuccessAction: successAction(user),
 	}, nil
 
 }
@@ -445,20 +458,21 @@ func (w Lnurl) DescriptionHash(metadata lnurl.Metadata, payerData string) (strin
 
 // metaData returns the metadata that is sent in the first response
 // and is used again in the second response to verify the description hash
-func (w Lnurl) metaData(username string) lnurl.Metadata {
+func (w Lnurl) metaData(address Address) lnurl.Metadata {
 	// this is a bit stupid but if the address is a UUID starting with 1x...
 	// we actually want to find the users username so it looks nicer in the
 	// metadata description
-	if strings.HasPrefix(username, "1x") {
-		user, _ := db.FindUser(w.database, username)
+	name := address.Name
+	if strings.HasPrefix(name, "1x") {
+		user, _ := db.FindUser(w.database, address.Username)
 		if user.Telegram.Username != "" {
-			username = user.Telegram.Username
+			name = user.Telegram.Username
 		}
 	}
 
 	return lnurl.Metadata{
-		Description:      fmt.Sprintf("Pay to %s@%s", username, w.callbackHostname.Hostname()),
-		LightningAddress: fmt.Sprintf("%s@%s", username, w.callbackHostname.Hostname()),
+		Description:      fmt.Sprintf("Pay to %s@%s", name, address.Host),
+		LightningAddress: fmt.Sprintf("%s@%s", name, address.Host),
 	}
 }
 
@@ -491,6 +505,21 @@ func isAnonUsername(username string) bool {
 	}
 }
 
+// successAction returns the successAction the user has configured with /set success
+func successAction(user *lnbits.User) *lnurl.SuccessAction {
+	if user.Settings == nil {
+		return &lnurl.SuccessAction{Message: "Payment received!", Tag: "message"}
+	}
+	message := user.Settings.LNURL.SuccessMessage
+	if len(message) == 0 {
+		message = "Payment received!"
+	}
+	if len(user.Settings.LNURL.SuccessURL) > 0 {
+		return &lnurl.SuccessAction{Description: message, URL: user.Settings.LNURL.SuccessURL, Tag: "url"}
+	}
+	return &lnurl.SuccessAction{Message: message, Tag: "message"}
+}
+
 func extractSenderFromPayerdata(payer lnurl.PayerDataValues) string {
 	if payer.LightningAddress != "" {
 		return payer.LightningAddress
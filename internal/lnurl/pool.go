@@ -0,0 +1,146 @@
+package lnurl
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/api"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
+	"github.com/fiatjaf/go-lnurl"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const PoolEndpoint = "lnurlp/pool"
+
+// HandlePool serves the LNURL pay endpoint of a pool, so that outsiders can contribute
+// from any wallet through the public page of the pool.
+func (w Lnurl) HandlePool(writer http.ResponseWriter, request *http.Request) {
+	token := mux.Vars(request)["token"]
+	pool, err := w.bot.PoolByToken(token)
+	if err != nil || !pool.Active || time.Now().After(pool.Expires) {
+		api.NotFoundHandler(writer, fmt.Errorf("[HandlePool] pool %s is not active", token))
+		return
+	}
+	var response interface{}
+	if request.URL.RawQuery == "" {
+		response, err = w.servePoolFirst(pool)
+	} else {
+		amount, parseErr := strconv.ParseInt(request.FormValue("amount"), 10, 64)
+		if parseErr != nil {
+			api.NotFoundHandler(writer, fmt.Errorf("[HandlePool] Couldn't cast amount to int: %v", parseErr))
+			return
+		}
+		var payerData lnurl.PayerDataValues
+		if payerdata := request.FormValue("payerdata"); len(payerdata) > 0 {
+			if err := json.Unmarshal([]byte(payerdata), &payerData); err != nil {
+				log.Errorf("[HandlePool] Couldn't parse payerdata: %v", err)
+			}
+		}
+		response, err = w.servePoolSecond(pool, amount, payerData)
+	}
+	if err != nil {
+		log.Errorf("[LNURL] %v", err.Error())
+		if response == nil {
+			return
+		}
+	}
+	if err := api.WriteResponse(writer, response); err != nil {
+		api.NotFoundHandler(writer, err)
+	}
+}
+
+func poolMetaData(pool *telegram.Pool) lnurl.Metadata {
+	return lnurl.Metadata{Description: fmt.Sprintf("Contribution to %s", pool.Title)}
+}
+
+// servePoolFirst serves the first LNURL response of a pool. Payers can leave their name to be
+// listed as contributor.
+func (w Lnurl) servePoolFirst(pool *telegram.Pool) (*LNURLPayParamsCustom, error) {
+	maxSendable := (pool.Goal - pool.Collected) * 1000
+	if maxSendable < MinSendable {
+		maxSendable = MinSendable
+	}
+	return &LNURLPayParamsCustom{
+		LNURLResponse:   lnurl.LNURLResponse{Status: api.StatusOk},
+		Tag:             PayRequestTag,
+		Callback:        fmt.Sprintf("%s/%s/%s", w.callbackHostname.String(), PoolEndpoint, pool.Token),
+		MinSendable:     MinSendable,
+		MaxSendable:     maxSendable,
+		EncodedMetadata: poolMetaData(pool).Encode(),
+		PayerData: &lnurl.PayerDataSpec{
+			FreeName: &lnurl.PayerDataItemSpec{},
+		},
+	}, nil
+}
+
+// servePoolSecond creates the invoice of a contribution on the wallet of the bot, which
+// holds the contributions of pools until they are paid out.
+func (w Lnurl) servePoolSecond(pool *telegram.Pool, amountMsat int64, payerData lnurl.PayerDataValues) (*lnurl.LNURLPayValues, error) {
+	if amountMsat < MinSendable || amountMsat > MaxSendable {
+		return &lnurl.LNURLPayValues{
+			LNURLResponse: lnurl.LNURLResponse{
+				Status: api.StatusError,
+				Reason: fmt.Sprintf("Amount out of bounds (min: %d sat, max: %d sat).", MinSendable/1000, MaxSendable/1000)},
+		}, fmt.Errorf("amount out of bounds")
+	}
+	escrow, err := w.bot.EscrowWallet()
+	if err != nil {
+		return &lnurl.LNURLPayValues{
+			LNURLResponse: lnurl.LNURLResponse{
+				Status: api.StatusError,
+				Reason: "Couldn't create invoice."},
+		}, err
+	}
+	metadata := poolMetaData(pool)
+	var payerDataByte []byte
+	if payerData.FreeName != "" {
+		if payerDataByte, err = json.Marshal(payerData); err != nil {
+			return nil, err
+		}
+	}
+	descriptionHash, err := w.DescriptionHash(metadata, string(payerDataByte))
+	if err != nil {
+		return nil, err
+	}
+	invoice, err := w.c.CreateInvoice(*escrow.Wallet,
+		lnbits.InvoiceParams{
+			Amount:              amountMsat / 1000,
+			Out:                 false,
+			DescriptionHash:     descriptionHash,
+			UnhashedDescription: hex.EncodeToString([]byte(metadata.Encode() + string(payerDataByte))),
+			Webhook:             w.WebhookServer})
+	if err != nil {
+		return &lnurl.LNURLPayValues{
+			LNURLResponse: lnurl.LNURLResponse{
+				Status: api.StatusError,
+				Reason: "Couldn't create invoice."},
+		}, fmt.Errorf("[servePoolSecond] Couldn't create invoice: %v", err)
+	}
+	log.Infof("[LNURL] Serving invoice of %d sat for pool %s", amountMsat/1000, pool.ID)
+	runtime.IgnoreError(w.buntdb.Set(
+		telegram.InvoiceEvent{
+			Invoice: &telegram.Invoice{
+				PaymentRequest: invoice.PaymentRequest,
+				PaymentHash:    invoice.PaymentHash,
+				Amount:         amountMsat / 1000,
+			},
+			User:         escrow,
+			Callback:     telegram.InvoiceCallbackPoolContribution,
+			CallbackData: telegram.PoolInvoiceCallbackData(pool, payerData.FreeName),
+			LanguageCode: pool.LanguageCode,
+			Open:         true,
+		}))
+	return &lnurl.LNURLPayValues{
+		LNURLResponse: lnurl.LNURLResponse{Status: api.StatusOk},
+		PR:            invoice.PaymentRequest,
+		Routes:        make([]struct{}, 0),
+		SuccessAction: &lnurl.SuccessAction{Message: fmt.Sprintf("Thank you for your contribution to %s!", pool.Title), Tag: "message"},
+	}, nil
+}
@@ -0,0 +1,45 @@
+package lnurl
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/api"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
+	"github.com/fiatjaf/go-lnurl"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const WithdrawRequestTag = "withdrawRequest"
+
+// HandleVoucher serves the LNURL withdraw endpoint of a voucher. The first request returns
+// the withdraw parameters, the callback with k1 and pr pays the invoice of the wallet.
+func (w Lnurl) HandleVoucher(writer http.ResponseWriter, request *http.Request) {
+	token := mux.Vars(request)["token"]
+	voucher, err := w.bot.VoucherByToken(token)
+	if err != nil || !voucher.Active {
+		api.NotFoundHandler(writer, fmt.Errorf("[HandleVoucher] voucher %s is not active", token))
+		return
+	}
+	var response interface{}
+	if pr := request.FormValue("pr"); len(pr) == 0 {
+		response = lnurl.LNURLWithdrawResponse{
+			LNURLResponse:      lnurl.LNURLResponse{Status: api.StatusOk},
+			Tag:                WithdrawRequestTag,
+			Callback:           fmt.Sprintf("%s/%s/%s", w.callbackHostname.String(), telegram.VoucherEndpoint, voucher.Token),
+			K1:                 voucher.K1,
+			MinWithdrawable:    voucher.Amount * 1000,
+			MaxWithdrawable:    voucher.Amount * 1000,
+			DefaultDescription: fmt.Sprintf("Voucher of %d sat", voucher.Amount),
+		}
+	} else if err := w.bot.ClaimVoucher(token, request.FormValue("k1"), pr); err != nil {
+		log.Errorf("[HandleVoucher] could not claim voucher %s: %v", token, err)
+		response = lnurl.LNURLResponse{Status: api.StatusError, Reason: err.Error()}
+	} else {
+		response = lnurl.LNURLResponse{Status: api.StatusOk}
+	}
+	if err := api.WriteResponse(writer, response); err != nil {
+		api.NotFoundHandler(writer, err)
+	}
+}
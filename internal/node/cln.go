@@ -0,0 +1,175 @@
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/satdress"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Cln talks to the clnrest plugin of Core Lightning.
+type Cln struct {
+	host   string
+	rune   string
+	client *http.Client
+}
+
+// NewCln connects to clnrest at host. cert is the PEM encoded TLS certificate of the node.
+func NewCln(host, runeToken, cert string) (*Cln, error) {
+	client, err := satdress.SetupHttpClient(false, []byte(cert))
+	if err != nil {
+		return nil, err
+	}
+	return &Cln{host: strings.TrimSuffix(host, "/"), rune: runeToken, client: client}, nil
+}
+
+func (c *Cln) call(client *http.Client, method string, body string) (gjson.Result, error) {
+	req, err := http.NewRequest("POST", c.host+"/v1/"+method, bytes.NewBufferString(body))
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	req.Header.Set("Rune", c.rune)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	if resp.StatusCode >= 300 {
+		return gjson.Result{}, lnbits.Error{Detail: fmt.Sprintf("call to cln %s failed (%d): %s", method, resp.StatusCode, gjson.GetBytes(b, "message").String())}
+	}
+	return gjson.ParseBytes(b), nil
+}
+
+// Funds returns our part of the balance of all channels.
+func (c *Cln) Funds() (int64, error) {
+	res, err := c.call(c.client, "listfunds", "{}")
+	if err != nil {
+		return 0, err
+	}
+	var funds int64
+	for _, channel := range res.Get("channels").Array() {
+		funds += channel.Get("our_amount_msat").Int()
+	}
+	return funds, nil
+}
+
+// Liquidity returns our part and the peer's part of the channels that are connected and open.
+func (c *Cln) Liquidity() (lnbits.Liquidity, error) {
+	res, err := c.call(c.client, "listfunds", "{}")
+	if err != nil {
+		return lnbits.Liquidity{}, err
+	}
+	var liquidity lnbits.Liquidity
+	for _, channel := range res.Get("channels").Array() {
+		if !channel.Get("connected").Bool() || channel.Get("state").String() != "CHANNELD_NORMAL" {
+			continue
+		}
+		ours := channel.Get("our_amount_msat").Int()
+		liquidity.Outbound += ours
+		liquidity.Inbound += channel.Get("amount_msat").Int() - ours
+		liquidity.Channels++
+	}
+	return liquidity, nil
+}
+
+func (c *Cln) CreateInvoice(amountMsat int64, description string, descriptionHash []byte) (string, string, error) {
+	body, _ := sjson.Set("{}", "label", "tipbot-"+randomID())
+	if amountMsat > 0 {
+		body, _ = sjson.Set(body, "amount_msat", amountMsat)
+	} else {
+		body, _ = sjson.Set(body, "amount_msat", "any")
+	}
+	// cln hashes the description itself and only commits to the hash
+	body, _ = sjson.Set(body, "description", description)
+	if len(descriptionHash) > 0 {
+		body, _ = sjson.Set(body, "deschashonly", true)
+	}
+	res, err := c.call(c.client, "invoice", body)
+	if err != nil {
+		return "", "", err
+	}
+	return res.Get("bolt11").String(), res.Get("payment_hash").String(), nil
+}
+
+// PayInvoice uses pay, which splits payments on its own. It does not report the parts.
+func (c *Cln) PayInvoice(bolt11 string, maxFeeMsat int64, progress func(lnbits.PaymentProgress)) (string, int64, error) {
+	body, _ := sjson.Set("{}", "bolt11", bolt11)
+	body, _ = sjson.Set(body, "maxfee", maxFeeMsat)
+	res, err := c.call(c.client, "pay", body)
+	if err != nil {
+		return "", 0, err
+	}
+	if status := res.Get("status").String(); status != "complete" {
+		// a payment that is still pending may complete later
+		return "", 0, fmt.Errorf("payment %s", status)
+	}
+	fee := res.Get("amount_sent_msat").Int() - res.Get("amount_msat").Int()
+	return res.Get("payment_preimage").String(), fee, nil
+}
+
+// PaymentStatus looks the payment up with listpays.
+func (c *Cln) PaymentStatus(paymentHash string) (PaymentStatus, error) {
+	body, _ := sjson.Set("{}", "payment_hash", paymentHash)
+	res, err := c.call(c.client, "listpays", body)
+	if err != nil {
+		return PaymentStatus{}, err
+	}
+	pays := res.Get("pays").Array()
+	if len(pays) == 0 {
+		return PaymentStatus{State: PaymentUnknown}, nil
+	}
+	status := PaymentStatus{State: PaymentFailed}
+	for _, pay := range pays {
+		switch pay.Get("status").String() {
+		case "complete":
+			fee := pay.Get("amount_sent_msat").Int() - pay.Get("amount_msat").Int()
+			return PaymentStatus{State: PaymentSucceeded, Preimage: pay.Get("preimage").String(), FeeMsat: fee}, nil
+		case "pending":
+			status.State = PaymentInFlight
+		}
+	}
+	return status, nil
+}
+
+func (c *Cln) SubscribeInvoices(handler func(paymentHash string, amountMsat int64)) error {
+	// waitanyinvoice blocks until the next invoice is paid
+	client := *c.client
+	client.Timeout = 0
+	var lastPayIndex int64
+	res, err := c.call(&client, "listinvoices", "{}")
+	if err != nil {
+		return err
+	}
+	// invoices that were paid while the bot was offline are settled first
+	for _, invoice := range res.Get("invoices").Array() {
+		if invoice.Get("status").String() != "paid" {
+			continue
+		}
+		if index := invoice.Get("pay_index").Int(); index > lastPayIndex {
+			lastPayIndex = index
+		}
+		handler(invoice.Get("payment_hash").String(), invoice.Get("amount_received_msat").Int())
+	}
+	for {
+		body, _ := sjson.Set("{}", "lastpay_index", lastPayIndex)
+		invoice, err := c.call(&client, "waitanyinvoice", body)
+		if err != nil {
+			return err
+		}
+		lastPayIndex = invoice.Get("pay_index").Int()
+		if invoice.Get("status").String() == "paid" {
+			handler(invoice.Get("payment_hash").String(), invoice.Get("amount_received_msat").Int())
+		}
+	}
+}
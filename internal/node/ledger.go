@@ -0,0 +1,62 @@
+package node
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+)
+
+// Account is a user of the bot. It replaces the LNbits user manager extension.
+type Account struct {
+	ID        string `gorm:"primaryKey"`
+	Name      string `gorm:"index"`
+	Email     string
+	CreatedAt time.Time
+}
+
+// Wallet belongs to an account. The keys are only kept so that the wallet looks like an LNbits wallet.
+type Wallet struct {
+	ID        string `gorm:"primaryKey"`
+	AccountID string `gorm:"index"`
+	Name      string
+	Adminkey  string
+	Inkey     string
+	CreatedAt time.Time
+}
+
+// Entry is a line in the ledger of a wallet. Amounts are in msat and negative for outgoing payments.
+// An internal payment between two wallets has one entry for each wallet.
+type Entry struct {
+	PaymentHash string `gorm:"primaryKey"`
+	WalletID    string `gorm:"primaryKey"`
+	Amount      int64
+	Fee         int64 // negative, reserved while an outgoing payment is pending
+	Memo        string
+	Bolt11      string
+	Preimage    string
+	Pending     bool `gorm:"index"`
+	CreatedAt   time.Time
+}
+
+func (e Entry) payment() lnbits.Payment {
+	return lnbits.Payment{
+		CheckingID:  e.PaymentHash,
+		Pending:     e.Pending,
+		Amount:      e.Amount,
+		Fee:         -e.Fee,
+		Memo:        e.Memo,
+		Time:        int(e.CreatedAt.Unix()),
+		Bolt11:      e.Bolt11,
+		Preimage:    e.Preimage,
+		PaymentHash: e.PaymentHash,
+		WalletID:    e.WalletID,
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,249 @@
+package node
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// paymentTimeout is the time that the router of lnd tries to pay an invoice.
+const paymentTimeout = 60 * time.Second
+
+// requestTimeout limits the calls to lnd that are not streams.
+const requestTimeout = 30 * time.Second
+
+// Lnd talks to the gRPC interface of LND.
+type Lnd struct {
+	conn      *grpc.ClientConn
+	lightning lnrpc.LightningClient
+	router    routerrpc.RouterClient
+}
+
+// macaroonCredential sends the macaroon (hex) with every call.
+type macaroonCredential string
+
+func (m macaroonCredential) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"macaroon": string(m)}, nil
+}
+
+func (m macaroonCredential) RequireTransportSecurity() bool {
+	return true
+}
+
+// NewLnd connects to the LND gRPC interface at host (host:port). The macaroon is hex or
+// base64 encoded and cert is the PEM encoded TLS certificate of the node.
+func NewLnd(host, macaroon, cert string) (*Lnd, error) {
+	// the macaroon is sent in hex, so if it is in base64 we adjust that
+	if _, err := hex.DecodeString(macaroon); err != nil {
+		if b, err := base64.StdEncoding.DecodeString(macaroon); err == nil {
+			macaroon = hex.EncodeToString(b)
+		}
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if len(cert) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(cert)) {
+			return nil, fmt.Errorf("invalid certificate of lnd")
+		}
+		tlsConfig = &tls.Config{RootCAs: pool}
+	}
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	conn, err := grpc.Dial(strings.TrimSuffix(host, "/"),
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		grpc.WithPerRPCCredentials(macaroonCredential(macaroon)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Lnd{conn: conn, lightning: lnrpc.NewLightningClient(conn), router: routerrpc.NewRouterClient(conn)}, nil
+}
+
+// lndError returns errors that lnd responded with as lnbits.Error. Errors of the
+// connection are returned as they are.
+func lndError(err error) error {
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch s.Code() {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled, codes.Internal:
+		return err
+	}
+	return lnbits.Error{Detail: fmt.Sprintf("call to lnd failed (%s): %s", s.Code(), s.Message())}
+}
+
+// Funds returns the local balance of all channels.
+func (l *Lnd) Funds() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	res, err := l.lightning.ChannelBalance(ctx, &lnrpc.ChannelBalanceRequest{})
+	if err != nil {
+		return 0, lndError(err)
+	}
+	if res.LocalBalance == nil {
+		return 0, nil
+	}
+	return int64(res.LocalBalance.Msat), nil
+}
+
+// Liquidity returns the local and remote balance of the active channels.
+func (l *Lnd) Liquidity() (lnbits.Liquidity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	res, err := l.lightning.ListChannels(ctx, &lnrpc.ListChannelsRequest{ActiveOnly: true})
+	if err != nil {
+		return lnbits.Liquidity{}, lndError(err)
+	}
+	var liquidity lnbits.Liquidity
+	for _, channel := range res.Channels {
+		liquidity.Outbound += channel.LocalBalance * 1000
+		liquidity.Inbound += channel.RemoteBalance * 1000
+		liquidity.Channels++
+	}
+	return liquidity, nil
+}
+
+func (l *Lnd) CreateInvoice(amountMsat int64, description string, descriptionHash []byte) (string, string, error) {
+	invoice := &lnrpc.Invoice{ValueMsat: amountMsat}
+	if len(descriptionHash) > 0 {
+		invoice.DescriptionHash = descriptionHash
+	} else {
+		invoice.Memo = description
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	res, err := l.lightning.AddInvoice(ctx, invoice)
+	if err != nil {
+		return "", "", lndError(err)
+	}
+	return res.PaymentRequest, hex.EncodeToString(res.RHash), nil
+}
+
+// PayInvoice uses the router, which splits payments that don't fit a single path into at
+// most MaxParts parts. The router streams the state of the payment until it is final.
+func (l *Lnd) PayInvoice(bolt11 string, maxFeeMsat int64, progress func(lnbits.PaymentProgress)) (string, int64, error) {
+	// the stream lasts until the payment is final, which is bounded by TimeoutSeconds
+	ctx, cancel := context.WithTimeout(context.Background(), paymentTimeout+30*time.Second)
+	defer cancel()
+	stream, err := l.router.SendPaymentV2(ctx, &routerrpc.SendPaymentRequest{
+		PaymentRequest: bolt11,
+		FeeLimitMsat:   maxFeeMsat,
+		TimeoutSeconds: int32(paymentTimeout.Seconds()),
+		MaxParts:       uint32(internal.CurrentConfiguration().Node.MaxParts),
+	})
+	if err != nil {
+		return "", 0, lndError(err)
+	}
+	started := false
+	for {
+		payment, err := stream.Recv()
+		if err != nil {
+			if !started {
+				// lnd refused the payment before it sent any part of it
+				return "", 0, lndError(err)
+			}
+			// the router may have sent parts of the payment already
+			return "", 0, fmt.Errorf("payment stream failed: %v", err)
+		}
+		started = true
+		switch payment.Status {
+		case lnrpc.Payment_SUCCEEDED:
+			return payment.PaymentPreimage, payment.FeeMsat, nil
+		case lnrpc.Payment_FAILED:
+			return "", 0, lnbits.Error{Detail: fmt.Sprintf("payment failed: %s", payment.FailureReason)}
+		}
+		if progress != nil {
+			progress(htlcProgress(payment.Htlcs))
+		}
+	}
+}
+
+// PaymentStatus asks the router for the current state of a payment.
+func (l *Lnd) PaymentStatus(paymentHash string) (PaymentStatus, error) {
+	hash, err := hex.DecodeString(paymentHash)
+	if err != nil {
+		return PaymentStatus{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	stream, err := l.router.TrackPaymentV2(ctx, &routerrpc.TrackPaymentRequest{PaymentHash: hash})
+	if err != nil {
+		return PaymentStatus{}, err
+	}
+	// the first update of the stream is the current state of the payment
+	payment, err := stream.Recv()
+	if err != nil {
+		if strings.Contains(status.Convert(err).Message(), "isn't initiated") {
+			return PaymentStatus{State: PaymentUnknown}, nil
+		}
+		return PaymentStatus{}, err
+	}
+	switch payment.Status {
+	case lnrpc.Payment_SUCCEEDED:
+		return PaymentStatus{State: PaymentSucceeded, Preimage: payment.PaymentPreimage, FeeMsat: payment.FeeMsat}, nil
+	case lnrpc.Payment_FAILED:
+		return PaymentStatus{State: PaymentFailed}, nil
+	}
+	return PaymentStatus{State: PaymentInFlight}, nil
+}
+
+// htlcProgress counts the parts of a payment of the router.
+func htlcProgress(htlcs []*lnrpc.HTLCAttempt) lnbits.PaymentProgress {
+	p := lnbits.PaymentProgress{}
+	for _, htlc := range htlcs {
+		var amount int64
+		if htlc.Route != nil {
+			amount = htlc.Route.TotalAmtMsat - htlc.Route.TotalFeesMsat
+		}
+		switch htlc.Status {
+		case lnrpc.HTLCAttempt_IN_FLIGHT:
+			p.Parts++
+			p.AmountMsat += amount
+		case lnrpc.HTLCAttempt_SUCCEEDED:
+			p.Parts++
+			p.Arrived++
+			p.AmountMsat += amount
+			p.ArrivedMsat += amount
+		case lnrpc.HTLCAttempt_FAILED:
+			p.Failed++
+		}
+	}
+	return p
+}
+
+func (l *Lnd) SubscribeInvoices(handler func(paymentHash string, amountMsat int64)) error {
+	// the subscription is a long lived stream without a timeout
+	stream, err := l.lightning.SubscribeInvoices(context.Background(), &lnrpc.InvoiceSubscription{})
+	if err != nil {
+		return err
+	}
+	for {
+		invoice, err := stream.Recv()
+		if err == io.EOF {
+			return errors.New("invoice stream closed")
+		}
+		if err != nil {
+			return err
+		}
+		if invoice.State != lnrpc.Invoice_SETTLED {
+			continue
+		}
+		handler(hex.EncodeToString(invoice.RHash), invoice.AmtPaidMsat)
+	}
+}
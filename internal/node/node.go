@@ -0,0 +1,395 @@
+package node
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Node is a Lightning node that the bot uses without LNbits. Errors that the node
+// responded with are returned as lnbits.Error, other errors mean it was not reachable.
+type Node interface {
+	// CreateInvoice returns a bolt11 invoice and its payment hash (hex).
+	// If descriptionHash is set, description is the unhashed description.
+	CreateInvoice(amountMsat int64, description string, descriptionHash []byte) (bolt11 string, paymentHash string, err error)
+	// PayInvoice pays a bolt11 invoice with at most maxFeeMsat fees. Nodes that split
+	// payments into several parts report the state of the parts to progress.
+	PayInvoice(bolt11 string, maxFeeMsat int64, progress func(lnbits.PaymentProgress)) (preimage string, feeMsat int64, err error)
+	// SubscribeInvoices blocks and calls handler for every settled invoice.
+	SubscribeInvoices(handler func(paymentHash string, amountMsat int64)) error
+	// PaymentStatus returns the state of an outgoing payment.
+	PaymentStatus(paymentHash string) (PaymentStatus, error)
+}
+
+// PaymentState is the state of an outgoing payment on the node.
+type PaymentState int
+
+const (
+	PaymentInFlight PaymentState = iota
+	PaymentSucceeded
+	PaymentFailed
+	// PaymentUnknown means the node has never seen the payment
+	PaymentUnknown
+)
+
+type PaymentStatus struct {
+	State    PaymentState
+	Preimage string
+	FeeMsat  int64
+}
+
+// Funds is implemented by nodes that can report the balance of their channels.
+type Funds interface {
+	// Funds returns the local balance of all channels in msat.
+	Funds() (int64, error)
+}
+
+// Channels is implemented by nodes that can report the capacity of their active channels.
+type Channels interface {
+	Liquidity() (lnbits.Liquidity, error)
+}
+
+// Backend keeps the balances of all users in its own ledger and uses a Node
+// for payments that leave or enter the bot. It implements lnbits.WalletBackend.
+type Backend struct {
+	node        Node
+	db          *gorm.DB
+	mutex       sync.Mutex
+	subscribers []lnbits.PaymentHandler
+}
+
+var _ lnbits.WalletBackend = (*Backend)(nil)
+var _ lnbits.ReserveReporter = (*Backend)(nil)
+var _ lnbits.LiquidityReporter = (*Backend)(nil)
+
+// New connects to the node that is configured in the node section of the config.
+func New(config internal.NodeConfiguration) *Backend {
+	var n Node
+	var err error
+	switch config.Backend {
+	case internal.NodeBackendLnd:
+		n, err = NewLnd(config.Host, config.Macaroon, config.Cert)
+	case internal.NodeBackendCln:
+		n, err = NewCln(config.Host, config.Rune, config.Cert)
+	default:
+		err = fmt.Errorf("unknown node backend %s", config.Backend)
+	}
+	if err != nil {
+		panic(err)
+	}
+	db, err := gorm.Open(sqlite.Open(config.DbPath), &gorm.Config{})
+	if err != nil {
+		panic("Initialize orm failed.")
+	}
+	err = db.AutoMigrate(&Account{}, &Wallet{}, &Entry{})
+	if err != nil {
+		panic(err)
+	}
+	b := &Backend{node: n, db: db}
+	go b.subscribe()
+	go b.resolvePayments()
+	return b
+}
+
+// subscribe settles incoming invoices and restarts the subscription if it fails.
+func (b *Backend) subscribe() {
+	for {
+		err := b.node.SubscribeInvoices(b.settleInvoice)
+		log.Errorf("[node] invoice subscription stopped: %v", err)
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (b *Backend) settleInvoice(paymentHash string, amountMsat int64) {
+	// an internal payment of the same invoice settles it under the lock as well
+	b.mutex.Lock()
+	entry := &Entry{}
+	tx := b.db.Where("payment_hash = ? AND amount >= 0 AND pending = ?", paymentHash, true).First(entry)
+	if tx.Error != nil {
+		// not an invoice of the bot or already settled
+		b.mutex.Unlock()
+		return
+	}
+	entry.Amount = amountMsat
+	entry.Pending = false
+	tx = b.db.Save(entry)
+	b.mutex.Unlock()
+	if tx.Error != nil {
+		log.Errorf("[node] could not settle invoice %s: %v", paymentHash, tx.Error)
+		return
+	}
+	b.notify(*entry)
+}
+
+func (b *Backend) notify(entry Entry) {
+	for _, handler := range b.subscribers {
+		handler(lnbits.IncomingPayment{
+			WalletID:    entry.WalletID,
+			PaymentHash: entry.PaymentHash,
+			Amount:      entry.Amount,
+			Memo:        entry.Memo,
+		})
+	}
+}
+
+func (b *Backend) CreateUserWithInitialWallet(userName, walletName, adminId string, email string) (lnbits.User, error) {
+	account := &Account{ID: randomID(), Name: userName, Email: email, CreatedAt: time.Now()}
+	wallet := &Wallet{ID: randomID(), AccountID: account.ID, Name: walletName, Adminkey: randomID(), Inkey: randomID(), CreatedAt: time.Now()}
+	err := b.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(account).Error; err != nil {
+			return err
+		}
+		return tx.Create(wallet).Error
+	})
+	if err != nil {
+		return lnbits.User{}, err
+	}
+	return lnbits.User{ID: account.ID, Name: account.Name}, nil
+}
+
+func (b *Backend) Wallets(u lnbits.User) ([]lnbits.Wallet, error) {
+	wallets := []Wallet{}
+	tx := b.db.Where("account_id = ?", u.ID).Find(&wallets)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	result := make([]lnbits.Wallet, 0, len(wallets))
+	for _, w := range wallets {
+		result = append(result, lnbits.Wallet{
+			ID:       w.ID,
+			Adminkey: w.Adminkey,
+			Inkey:    w.Inkey,
+			Name:     w.Name,
+			User:     w.AccountID,
+			Balance:  b.balance(b.db, w.ID),
+		})
+	}
+	return result, nil
+}
+
+// balance is the sum of all settled entries and the pending outgoing payments of a wallet in msat.
+func (b *Backend) balance(db *gorm.DB, walletID string) int64 {
+	var balance int64
+	db.Model(&Entry{}).
+		Select("COALESCE(SUM(amount + fee), 0)").
+		Where("wallet_id = ? AND (pending = ? OR amount < 0)", walletID, false).
+		Scan(&balance)
+	return balance
+}
+
+func (b *Backend) Balance(w lnbits.Wallet) (lnbits.Wallet, error) {
+	w.Balance = b.balance(b.db, w.ID)
+	return w, nil
+}
+
+// Reserve returns the funds of the node. It implements lnbits.ReserveReporter.
+func (b *Backend) Reserve() (int64, error) {
+	funds, ok := b.node.(Funds)
+	if !ok {
+		return 0, fmt.Errorf("node does not report its funds")
+	}
+	return funds.Funds()
+}
+
+// Liquidity returns the capacity of the channels of the node. It implements lnbits.LiquidityReporter.
+func (b *Backend) Liquidity() (lnbits.Liquidity, error) {
+	channels, ok := b.node.(Channels)
+	if !ok {
+		return lnbits.Liquidity{}, fmt.Errorf("node does not report its channels")
+	}
+	return channels.Liquidity()
+}
+
+func (b *Backend) CreateInvoice(w lnbits.Wallet, params lnbits.InvoiceParams) (lnbits.Invoice, error) {
+	description := params.Memo
+	var descriptionHash []byte
+	if len(params.DescriptionHash) > 0 {
+		var err error
+		descriptionHash, err = hex.DecodeString(params.DescriptionHash)
+		if err != nil {
+			return lnbits.Invoice{}, err
+		}
+		if unhashed, err := hex.DecodeString(params.UnhashedDescription); err == nil {
+			description = string(unhashed)
+		}
+	}
+	bolt11, paymentHash, err := b.node.CreateInvoice(params.Amount*1000, description, descriptionHash)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	tx := b.db.Create(&Entry{
+		PaymentHash: paymentHash,
+		WalletID:    w.ID,
+		Amount:      params.Amount * 1000,
+		Memo:        params.Memo,
+		Bolt11:      bolt11,
+		Pending:     true,
+		CreatedAt:   time.Now(),
+	})
+	if tx.Error != nil {
+		return lnbits.Invoice{}, tx.Error
+	}
+	return lnbits.Invoice{PaymentHash: paymentHash, PaymentRequest: bolt11}, nil
+}
+
+// feeReserve is the routing fee that is reserved while an outgoing payment is in flight.
+func feeReserve(amountMsat int64) int64 {
+	config := internal.CurrentConfiguration().Node
+	reserve := int64(float64(amountMsat) * config.FeeLimitPercent / 100)
+	if minReserve := config.FeeLimitMinSat * 1000; reserve < minReserve {
+		reserve = minReserve
+	}
+	return reserve
+}
+
+func (b *Backend) Pay(w lnbits.Wallet, params lnbits.PaymentParams) (lnbits.Invoice, error) {
+	bolt11, err := decodepay.Decodepay(params.Bolt11)
+	if err != nil {
+		return lnbits.Invoice{}, lnbits.Error{Detail: err.Error()}
+	}
+	if bolt11.MSatoshi <= 0 {
+		return lnbits.Invoice{}, lnbits.Error{Detail: "invoices without amount are not supported"}
+	}
+	invoice := lnbits.Invoice{PaymentHash: bolt11.PaymentHash, PaymentRequest: params.Bolt11}
+	outgoing := &Entry{
+		PaymentHash: bolt11.PaymentHash,
+		WalletID:    w.ID,
+		Amount:      -bolt11.MSatoshi,
+		Memo:        bolt11.Description,
+		Bolt11:      params.Bolt11,
+		CreatedAt:   time.Now(),
+	}
+
+	// the balance check and the booking of the payment must not interleave with other payments
+	b.mutex.Lock()
+	incoming := &Entry{}
+	internalPayment := b.db.Where("payment_hash = ? AND amount > 0 AND pending = ?", bolt11.PaymentHash, true).First(incoming).Error == nil
+	if internalPayment && incoming.WalletID == w.ID {
+		b.mutex.Unlock()
+		return invoice, lnbits.Error{Detail: "can not pay your own invoice"}
+	}
+	if !internalPayment {
+		outgoing.Pending = true
+		outgoing.Fee = -feeReserve(bolt11.MSatoshi)
+	}
+	err = b.db.Transaction(func(tx *gorm.DB) error {
+		if b.balance(tx, w.ID) < bolt11.MSatoshi-outgoing.Fee {
+			return lnbits.Error{Detail: "insufficient balance"}
+		}
+		if err := tx.Create(outgoing).Error; err != nil {
+			return err
+		}
+		if internalPayment {
+			// invoices of other users are settled in the ledger without touching the node
+			incoming.Pending = false
+			return tx.Save(incoming).Error
+		}
+		return nil
+	})
+	b.mutex.Unlock()
+	if err != nil {
+		return invoice, err
+	}
+	if internalPayment {
+		b.notify(*incoming)
+		return invoice, nil
+	}
+
+	preimage, fee, err := b.node.PayInvoice(params.Bolt11, feeReserve(bolt11.MSatoshi), params.Progress)
+	if err != nil {
+		if _, failed := err.(lnbits.Error); failed {
+			// the node refused the payment, the funds are released
+			b.db.Delete(outgoing)
+			return invoice, err
+		}
+		// the payment may still settle, it stays pending and keeps its funds until resolvePayments knows
+		log.Warnf("[node] outcome of payment %s is unknown: %v", bolt11.PaymentHash, err)
+		return invoice, err
+	}
+	b.book(outgoing, PaymentStatus{State: PaymentSucceeded, Preimage: preimage, FeeMsat: fee})
+	return invoice, nil
+}
+
+// book settles an outgoing payment in the ledger once the node knows its outcome.
+func (b *Backend) book(outgoing *Entry, status PaymentStatus) {
+	switch status.State {
+	case PaymentSucceeded:
+		outgoing.Pending = false
+		outgoing.Fee = -status.FeeMsat
+		outgoing.Preimage = status.Preimage
+		if tx := b.db.Save(outgoing); tx.Error != nil {
+			log.Errorf("[node] could not book payment %s: %v", outgoing.PaymentHash, tx.Error)
+		}
+	case PaymentFailed, PaymentUnknown:
+		if tx := b.db.Delete(outgoing); tx.Error != nil {
+			log.Errorf("[node] could not release payment %s: %v", outgoing.PaymentHash, tx.Error)
+		}
+	}
+}
+
+// paymentResolveAfter is the age of a pending outgoing payment after which its outcome is
+// asked from the node. Younger payments are usually still waiting for PayInvoice.
+const paymentResolveAfter = 5 * time.Minute
+
+// resolvePayments books the outgoing payments whose outcome was unknown when PayInvoice returned.
+func (b *Backend) resolvePayments() {
+	ticker := time.NewTicker(time.Minute)
+	for range ticker.C {
+		b.resolvePendingPayments(time.Now().Add(-paymentResolveAfter))
+	}
+}
+
+func (b *Backend) resolvePendingPayments(before time.Time) {
+	entries := []Entry{}
+	tx := b.db.Where("amount < 0 AND pending = ? AND created_at < ?", true, before).Find(&entries)
+	if tx.Error != nil {
+		log.Errorf("[node] could not load pending payments: %v", tx.Error)
+		return
+	}
+	for i := range entries {
+		status, err := b.node.PaymentStatus(entries[i].PaymentHash)
+		if err != nil {
+			log.Errorf("[node] could not check payment %s: %v", entries[i].PaymentHash, err)
+			continue
+		}
+		if status.State == PaymentInFlight {
+			continue
+		}
+		log.Infof("[node] resolved payment %s of wallet %s", entries[i].PaymentHash, entries[i].WalletID)
+		b.book(&entries[i], status)
+	}
+}
+
+func (b *Backend) Payments(w lnbits.Wallet) (lnbits.Payments, error) {
+	entries := []Entry{}
+	tx := b.db.Where("wallet_id = ?", w.ID).Order("created_at desc").Limit(60).Find(&entries)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	payments := make(lnbits.Payments, 0, len(entries))
+	for _, e := range entries {
+		payments = append(payments, e.payment())
+	}
+	return payments, nil
+}
+
+func (b *Backend) Payment(w lnbits.Wallet, paymentHash string) (lnbits.LNbitsPayment, error) {
+	entry := &Entry{}
+	tx := b.db.Where("wallet_id = ? AND payment_hash = ?", w.ID, paymentHash).First(entry)
+	if tx.Error != nil {
+		return lnbits.LNbitsPayment{}, tx.Error
+	}
+	return lnbits.LNbitsPayment{Paid: !entry.Pending, Preimage: entry.Preimage, Details: entry.payment()}, nil
+}
+
+func (b *Backend) Subscribe(handler lnbits.PaymentHandler) {
+	b.subscribers = append(b.subscribers, handler)
+}
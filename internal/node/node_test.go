@@ -0,0 +1,127 @@
+package node
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits/lnbitstest"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// fakeNode creates invoices that decode like real ones and pays them with a given outcome.
+type fakeNode struct {
+	invoices *lnbitstest.Server
+	payErr   error
+	status   PaymentStatus
+}
+
+func (n *fakeNode) CreateInvoice(amountMsat int64, description string, descriptionHash []byte) (string, string, error) {
+	invoice, err := n.invoices.ExternalInvoice(amountMsat/1000, description)
+	return invoice.PaymentRequest, invoice.PaymentHash, err
+}
+
+func (n *fakeNode) PayInvoice(bolt11 string, maxFeeMsat int64, progress func(lnbits.PaymentProgress)) (string, int64, error) {
+	if n.payErr != nil {
+		return "", 0, n.payErr
+	}
+	return "preimage", 1000, nil
+}
+
+func (n *fakeNode) SubscribeInvoices(handler func(paymentHash string, amountMsat int64)) error {
+	return errors.New("not supported")
+}
+
+func (n *fakeNode) PaymentStatus(paymentHash string) (PaymentStatus, error) {
+	return n.status, nil
+}
+
+func newTestBackend(t *testing.T) (*Backend, *fakeNode, lnbits.Wallet) {
+	t.Helper()
+	server := lnbitstest.NewServer()
+	t.Cleanup(server.Close)
+	config := internal.Configuration.Node
+	t.Cleanup(func() { internal.Configuration.Node = config })
+	internal.Configuration.Node.FeeLimitPercent = 1
+	internal.Configuration.Node.FeeLimitMinSat = 10
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "node.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Account{}, &Wallet{}, &Entry{}); err != nil {
+		t.Fatal(err)
+	}
+	node := &fakeNode{invoices: server}
+	b := &Backend{node: node, db: db}
+	user, err := b.CreateUserWithInitialWallet("alice", "alice", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallets, err := b.Wallets(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoice, err := b.CreateInvoice(wallets[0], lnbits.InvoiceParams{Amount: 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.settleInvoice(invoice.PaymentHash, 10000*1000)
+	return b, node, wallets[0]
+}
+
+func TestPayRefused(t *testing.T) {
+	b, node, w := newTestBackend(t)
+	invoice, _ := node.invoices.ExternalInvoice(1000, "coffee")
+	node.payErr = lnbits.Error{Detail: "no route"}
+	if _, err := b.Pay(w, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}); err == nil {
+		t.Fatal("refused payment succeeded")
+	}
+	if balance := b.balance(b.db, w.ID); balance != 10000*1000 {
+		t.Errorf("balance = %d msat, want the funds back", balance)
+	}
+}
+
+func TestPayUnknownOutcome(t *testing.T) {
+	b, node, w := newTestBackend(t)
+	invoice, _ := node.invoices.ExternalInvoice(1000, "coffee")
+	node.payErr = errors.New("timeout")
+	if _, err := b.Pay(w, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}); err == nil {
+		t.Fatal("payment without an outcome succeeded")
+	}
+	// the amount and the fee reserve stay reserved
+	reserved := int64(9000*1000 - 10*1000)
+	if balance := b.balance(b.db, w.ID); balance != reserved {
+		t.Fatalf("balance = %d msat, want %d", balance, reserved)
+	}
+	node.payErr = nil
+	if _, err := b.Pay(w, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}); err == nil {
+		t.Fatal("paid an invoice twice while its first payment was unknown")
+	}
+
+	b.resolvePendingPayments(time.Now().Add(time.Minute))
+	if balance := b.balance(b.db, w.ID); balance != reserved {
+		t.Fatalf("balance = %d msat while the payment is in flight, want %d", balance, reserved)
+	}
+	node.status = PaymentStatus{State: PaymentSucceeded, Preimage: "preimage", FeeMsat: 2000}
+	b.resolvePendingPayments(time.Now().Add(time.Minute))
+	if balance := b.balance(b.db, w.ID); balance != 9000*1000-2000 {
+		t.Errorf("balance = %d msat after the payment succeeded", balance)
+	}
+	if payment, err := b.Payment(w, invoice.PaymentHash); err != nil || !payment.Paid {
+		t.Errorf("payment = %+v, %v, want a paid payment", payment, err)
+	}
+
+	failed, _ := node.invoices.ExternalInvoice(1000, "tea")
+	node.payErr = errors.New("timeout")
+	b.Pay(w, lnbits.PaymentParams{Out: true, Bolt11: failed.PaymentRequest})
+	node.status = PaymentStatus{State: PaymentFailed}
+	b.resolvePendingPayments(time.Now().Add(time.Minute))
+	if balance := b.balance(b.db, w.ID); balance != 9000*1000-2000 {
+		t.Errorf("balance = %d msat after the payment failed, want the funds back", balance)
+	}
+}
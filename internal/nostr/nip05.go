@@ -6,8 +6,9 @@ import (
 
 	"github.com/LightningTipBot/LightningTipBot/internal/api"
 	db "github.com/LightningTipBot/LightningTipBot/internal/database"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnurl"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
-	"github.com/prometheus/common/log"
+	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
@@ -23,34 +24,43 @@ func New(bot *telegram.TipBot) Nostr {
 	}
 }
 
+// nip05Response maps the names of NIP-05 identifiers to the hex pubkeys of their users.
+type nip05Response struct {
+	Names map[string]string `json:"names"`
+}
+
+// Handle serves /.well-known/nostr.json?name=<name>. A user's NIP-05 identifier is the same
+// as the lightning address, name@host resolves to the pubkey that was set with /nostr set.
 func (n Nostr) Handle(writer http.ResponseWriter, request *http.Request) {
-	username := request.FormValue("name")
-	if username == "" {
+	// web clients verify identifiers from other origins
+	writer.Header().Set("Access-Control-Allow-Origin", "*")
+	name := request.FormValue("name")
+	if name == "" {
 		api.NotFoundHandler(writer, fmt.Errorf("[NostrNip05] Form value 'name' is not set"))
 		return
 	}
-	user, tx := db.FindUser(n.database, username)
-	if tx.Error == nil && user.Telegram != nil {
-		user, err := db.FindUserSettings(user, n.bot.DB.Users.Preload("Settings"))
-		if err != nil {
-			log.Errorf("[NostrNip05] user settings not found")
-			api.NotFoundHandler(writer, fmt.Errorf("user settings error"))
-			return
-		}
-		if user.Settings.Nostr.PubKey != "" {
-			data := []byte(fmt.Sprintf(`{
-  "names":{
-    "%s":"%s"
-  }
-}
-`, username, user.Settings.Nostr.PubKey))
-			_, err = writer.Write(data)
-			if err != nil {
-				log.Errorf("[NostrNip05] Failed responding to user %s", username)
-			}
-		}
-	} else {
-		log.Errorf("[NostrNip05] user not found")
-		api.NotFoundHandler(writer, fmt.Errorf("user not found"))
+	address, err := lnurl.ResolveAddress(request, name)
+	if err != nil {
+		api.NotFoundHandler(writer, fmt.Errorf("[NostrNip05] %v", err))
+		return
+	}
+	user, tx := db.FindUser(n.database, address.Username)
+	if tx.Error != nil || user.Telegram == nil {
+		api.NotFoundHandler(writer, fmt.Errorf("[NostrNip05] user %s not found", address))
+		return
+	}
+	user, err = db.FindUserSettings(user, n.bot.DB.Users.Preload("Settings"))
+	if err != nil {
+		api.NotFoundHandler(writer, fmt.Errorf("[NostrNip05] settings of user %s not found: %v", address, err))
+		return
+	}
+	if user.Settings.Nostr.PubKey == "" {
+		api.NotFoundHandler(writer, fmt.Errorf("[NostrNip05] user %s has no nostr pubkey", address))
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	err = api.WriteResponse(writer, nip05Response{Names: map[string]string{name: user.Settings.Nostr.PubKey}})
+	if err != nil {
+		log.Errorf("[NostrNip05] Failed responding to %s: %v", address, err)
 	}
 }
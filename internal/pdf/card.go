@@ -0,0 +1,114 @@
+package pdf
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	cardWidth   = 255.0
+	cardHeight  = 190.0
+	cardColumns = 2
+	cardRows    = 4
+	cardPadding = 12.0
+	cardQRSize  = 120.0
+)
+
+// Card is a printable voucher with a QR code, like a gift or a voucher of a batch.
+type Card struct {
+	Amount       string // printed in large letters, like "1000 sat"
+	Memo         string
+	QR           string // content of the QR code
+	Code         string // printed below the QR code, optional
+	Instructions string
+}
+
+// Cards is a sheet of cards with lines to cut them out. A page holds eight cards.
+type Cards struct {
+	Title string
+	Brand string // printed on top of every card, like the name of the bot
+	Cards []Card
+}
+
+// qr draws the modules of a QR code as filled squares, so that it stays sharp when printed.
+func qr(content string, x, y, size float64) (string, error) {
+	code, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+	code.DisableBorder = true
+	bitmap := code.Bitmap()
+	module := size / float64(len(bitmap))
+	var c strings.Builder
+	c.WriteString("0 g\n")
+	for row, modules := range bitmap {
+		for column, black := range modules {
+			if black {
+				c.WriteString(rect(x+float64(column)*module, y+size-float64(row+1)*module, module, module))
+			}
+		}
+	}
+	return c.String(), nil
+}
+
+func (card Card) content(brand string, x, y float64) (string, error) {
+	var c strings.Builder
+	// dashed gray line to cut along
+	fmt.Fprintf(&c, "q 0.6 G 0.5 w [4 3] 0 d %.2f %.2f %.2f %.2f re S Q\n", x, y, cardWidth, cardHeight)
+	code, err := qr(card.QR, x+cardPadding, y+cardHeight-30-cardQRSize, cardQRSize)
+	if err != nil {
+		return "", err
+	}
+	c.WriteString(code)
+	c.WriteString(text("F2", 9, x+cardPadding, y+cardHeight-20, brand))
+	if len(card.Code) > 0 {
+		c.WriteString(text("F3", 9, x+cardPadding, y+22, card.Code))
+	}
+	// the column right of the QR code is about 24 characters wide
+	left := x + 2*cardPadding + cardQRSize
+	top := y + cardHeight - 44
+	c.WriteString(text("F2", 16, left, top, card.Amount))
+	top -= 18
+	for i, line := range wrap(card.Memo, 22) {
+		if i == 3 {
+			break
+		}
+		c.WriteString(text("F1", 8, left, top, line))
+		top -= 10
+	}
+	top -= 6
+	for _, line := range wrap(card.Instructions, 27) {
+		c.WriteString(text("F1", 7, left, top, line))
+		top -= 9
+	}
+	return c.String(), nil
+}
+
+// Bytes returns the cards as PDF file.
+func (s Cards) Bytes() ([]byte, error) {
+	perPage := cardColumns * cardRows
+	offsetX := (pageWidth - cardColumns*cardWidth) / 2
+	offsetY := (pageHeight - cardRows*cardHeight) / 2
+	var pages []string
+	var page strings.Builder
+	for i, card := range s.Cards {
+		position := i % perPage
+		x := offsetX + float64(position%cardColumns)*cardWidth
+		y := pageHeight - offsetY - float64(position/cardColumns+1)*cardHeight
+		content, err := card.content(s.Brand, x, y)
+		if err != nil {
+			return nil, err
+		}
+		page.WriteString(content)
+		if position == perPage-1 || i == len(s.Cards)-1 {
+			pages = append(pages, page.String())
+			page.Reset()
+		}
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("no cards")
+	}
+	return file(s.Title, pages), nil
+}
@@ -0,0 +1,136 @@
+// Package pdf writes simple PDF documents, like receipts and printable cards, with the
+// standard fonts of PDF readers so that no fonts have to be embedded.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	pageWidth  = 595 // A4 in points
+	pageHeight = 842
+	margin     = 50
+	valueX     = 190
+)
+
+// Row is a line of a document with a label and its value.
+type Row struct {
+	Label string
+	Value string
+}
+
+// Document is a page with a title, rows of labels and values and a footer.
+type Document struct {
+	Title  string
+	Rows   []Row
+	Footer string
+}
+
+// escape encodes text for a string of a content stream. Characters that the
+// standard fonts can't show are replaced with a question mark.
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			// Latin-1 matches WinAnsiEncoding in this range
+			b.WriteString(fmt.Sprintf("\\%03o", r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+func text(font string, size float64, x, y float64, s string) string {
+	return fmt.Sprintf("BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(s))
+}
+
+// rect fills a rectangle with the current color.
+func rect(x, y, width, height float64) string {
+	return fmt.Sprintf("%.2f %.2f %.2f %.2f re f\n", x, y, width, height)
+}
+
+// wrap breaks text into lines of at most width characters.
+func wrap(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if len(line) > 0 && len([]rune(line))+1+len([]rune(word)) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if len(line) > 0 {
+			line += " "
+		}
+		line += word
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (d Document) content() string {
+	var c strings.Builder
+	y := float64(pageHeight - margin - 20)
+	c.WriteString(text("F2", 20, margin, y, d.Title))
+	y -= 40
+	for _, row := range d.Rows {
+		c.WriteString(text("F2", 11, margin, y, row.Label))
+		c.WriteString(text("F3", 10, valueX, y, row.Value))
+		y -= 22
+	}
+	if len(d.Footer) > 0 {
+		c.WriteString(text("F1", 9, margin, margin, d.Footer))
+	}
+	return c.String()
+}
+
+// Bytes returns the document as PDF file.
+func (d Document) Bytes() []byte {
+	return file(d.Title, []string{d.content()})
+}
+
+// file returns a PDF file with an A4 page for each content stream.
+func file(title string, pages []string) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"", // the page tree, once the numbers of the pages are known
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Title (%s) /Producer (LightningTipBot) >>", escape(title)),
+	}
+	kids := make([]string, 0, len(pages))
+	for _, content := range pages {
+		page := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R /F3 5 0 R >> >> /Contents %d 0 R >>", pageWidth, pageHeight, page+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info 6 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.Bytes()
+}
@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"sync"
 
+	"github.com/LightningTipBot/LightningTipBot/internal"
 	log "github.com/sirupsen/logrus"
 
 	"golang.org/x/time/rate"
@@ -24,8 +25,24 @@ var globalLimiter *rate.Limiter
 
 // NewLimiter creates both chat and global rate limiters.
 func Start() {
-	idLimiter = newIdRateLimiter(rate.Limit(0.29), 19)
-	globalLimiter = rate.NewLimiter(rate.Limit(30), 30)
+	config := internal.CurrentConfiguration().RateLimit
+	idLimiter = newIdRateLimiter(rate.Limit(config.ChatRate), config.ChatBurst)
+	globalLimiter = rate.NewLimiter(rate.Limit(config.GlobalRate), config.GlobalBurst)
+}
+
+// Reload applies the rate limits of the configuration to all limiters.
+func Reload() {
+	config := internal.CurrentConfiguration().RateLimit
+	globalLimiter.SetLimit(rate.Limit(config.GlobalRate))
+	globalLimiter.SetBurst(config.GlobalBurst)
+	idLimiter.mu.Lock()
+	defer idLimiter.mu.Unlock()
+	idLimiter.r = rate.Limit(config.ChatRate)
+	idLimiter.b = config.ChatBurst
+	for _, limiter := range idLimiter.keys {
+		limiter.SetLimit(idLimiter.r)
+		limiter.SetBurst(idLimiter.b)
+	}
 }
 
 // NewRateLimiter .
@@ -0,0 +1,87 @@
+package reporting
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Reporter sends errors to an error tracking service.
+type Reporter interface {
+	Report(err error, fields map[string]string)
+	Flush(timeout time.Duration)
+}
+
+type noopReporter struct{}
+
+func (noopReporter) Report(err error, fields map[string]string) {}
+func (noopReporter) Flush(timeout time.Duration)                {}
+
+var reporter Reporter = noopReporter{}
+
+// SetReporter replaces the reporter that all errors are sent to.
+func SetReporter(r Reporter) {
+	reporter = r
+}
+
+// Start sets up the reporter that is configured in the error_reporting section.
+func Start(config internal.ErrorReportingConfiguration) {
+	if config.SentryDsn == "" {
+		return
+	}
+	r, err := NewSentry(config.SentryDsn, config.Environment)
+	if err != nil {
+		log.Errorf("[reporting] could not set up sentry: %v", err)
+		return
+	}
+	SetReporter(r)
+	log.Infof("[reporting] reporting errors to sentry")
+}
+
+var (
+	// keys of LNbits wallets and users are 32 hex characters
+	keyPattern     = regexp.MustCompile(`(?i)[0-9a-f]{32,}`)
+	invoicePattern = regexp.MustCompile(`(?i)ln(bc|tb|bcrt|tbs)[0-9a-z]{20,}`)
+)
+
+// sanitize removes keys, hashes and invoices from a text before it leaves the bot.
+func sanitize(text string) string {
+	text = invoicePattern.ReplaceAllString(text, "[invoice]")
+	return keyPattern.ReplaceAllString(text, "[redacted]")
+}
+
+type sanitizedError struct {
+	message string
+}
+
+func (e sanitizedError) Error() string {
+	return e.message
+}
+
+// Report sends an error of a handler with its context. Expected errors, like invalid
+// user input, are not reported.
+func Report(err error, fields map[string]string) {
+	if err == nil || errors.Expected(err) {
+		return
+	}
+	sanitized := make(map[string]string, len(fields)+1)
+	for key, value := range fields {
+		sanitized[key] = sanitize(value)
+	}
+	sanitized["error_type"] = fmt.Sprintf("%T", err)
+	reporter.Report(sanitizedError{message: sanitize(err.Error())}, sanitized)
+}
+
+// ReportPanic sends a recovered panic.
+func ReportPanic(recovered interface{}, fields map[string]string) {
+	Report(fmt.Errorf("panic: %v", recovered), fields)
+}
+
+// Flush waits until the reported errors were sent.
+func Flush(timeout time.Duration) {
+	reporter.Flush(timeout)
+}
@@ -0,0 +1,33 @@
+package reporting
+
+import (
+	"time"
+
+	sentry "github.com/getsentry/sentry-go"
+)
+
+// Sentry reports errors to Sentry or any service that accepts the Sentry protocol.
+type Sentry struct{}
+
+var _ Reporter = Sentry{}
+
+func NewSentry(dsn, environment string) (Sentry, error) {
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         dsn,
+		Environment: environment,
+		// requests and user details are never sent, see Report for the context
+		SendDefaultPII: false,
+	})
+	return Sentry{}, err
+}
+
+func (Sentry) Report(err error, fields map[string]string) {
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTags(fields)
+		sentry.CaptureException(err)
+	})
+}
+
+func (Sentry) Flush(timeout time.Duration) {
+	sentry.Flush(timeout)
+}
@@ -0,0 +1,113 @@
+package mutex
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// locks of crashed instances are released after distributedLockTTL. The instance that
+	// holds a lock renews it every distributedLockRenew until it unlocks.
+	distributedLockTTL   = 2 * time.Minute
+	distributedLockRenew = distributedLockTTL / 4
+	distributedLockRetry = 50 * time.Millisecond
+)
+
+// Locker locks names across several bot instances.
+type Locker interface {
+	Lock(name string)
+	Unlock(name string)
+}
+
+var locker Locker
+
+// SetLocker makes Lock and Unlock also acquire the lock in l. It has to be called
+// before the bot handles updates.
+func SetLocker(l Locker) {
+	locker = l
+}
+
+// DistributedLock is a lock held by one bot instance.
+type DistributedLock struct {
+	Name      string `gorm:"primaryKey"`
+	Owner     string
+	ExpiresAt time.Time
+}
+
+// DatabaseLocker is a Locker on a table that all instances share.
+type DatabaseLocker struct {
+	db     *gorm.DB
+	owner  string
+	mutex  sync.Mutex
+	leases map[string]chan struct{} // stops the renewal of a held lock
+}
+
+var _ Locker = (*DatabaseLocker)(nil)
+
+func NewDatabaseLocker(db *gorm.DB, owner string) *DatabaseLocker {
+	err := db.AutoMigrate(&DistributedLock{})
+	if err != nil {
+		panic(err)
+	}
+	return &DatabaseLocker{db: db, owner: owner, leases: make(map[string]chan struct{})}
+}
+
+// Lock waits until the lock is free and acquires it for this instance.
+func (l *DatabaseLocker) Lock(name string) {
+	for {
+		l.db.Where("name = ? AND expires_at < ?", name, time.Now()).Delete(&DistributedLock{})
+		tx := l.db.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&DistributedLock{Name: name, Owner: l.owner, ExpiresAt: time.Now().Add(distributedLockTTL)})
+		if tx.Error != nil {
+			log.Errorf("[Mutex] could not acquire distributed lock %s: %v", name, tx.Error)
+		} else if tx.RowsAffected == 1 {
+			l.renew(name)
+			return
+		}
+		time.Sleep(distributedLockRetry)
+	}
+}
+
+// renew extends the lock while this instance holds it, so that long handlers keep it.
+func (l *DatabaseLocker) renew(name string) {
+	stop := make(chan struct{})
+	l.mutex.Lock()
+	l.leases[name] = stop
+	l.mutex.Unlock()
+	go func() {
+		ticker := time.NewTicker(distributedLockRenew)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				tx := l.db.Model(&DistributedLock{}).Where("name = ? AND owner = ?", name, l.owner).
+					Update("expires_at", time.Now().Add(distributedLockTTL))
+				if tx.Error != nil {
+					log.Errorf("[Mutex] could not renew distributed lock %s: %v", name, tx.Error)
+				} else if tx.RowsAffected == 0 {
+					log.Errorf("[Mutex] distributed lock %s expired while it was held", name)
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (l *DatabaseLocker) Unlock(name string) {
+	l.mutex.Lock()
+	if stop, ok := l.leases[name]; ok {
+		close(stop)
+		delete(l.leases, name)
+	}
+	l.mutex.Unlock()
+	tx := l.db.Where("name = ? AND owner = ?", name, l.owner).Delete(&DistributedLock{})
+	if tx.Error != nil {
+		log.Errorf("[Mutex] could not release distributed lock %s: %v", name, tx.Error)
+	}
+}
@@ -30,6 +30,9 @@ func ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func UnlockHTTP(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	if m, ok := mutexMap.Get(vars["id"]); ok {
+		if locker != nil {
+			locker.Unlock(vars["id"])
+		}
 		m.(*sync.Mutex).Unlock()
 		w.Write([]byte(fmt.Sprintf("Unlocked mutex %s.\nCurrent number of locks: %d\nLocks: %+v",
 			vars["id"], len(mutexMap.Keys()), mutexMap.Keys())))
@@ -64,7 +67,7 @@ func LockWithContext(ctx context.Context, s string) {
 	}
 	// sync mutex to sync checkSoftLock with the increment of nLocks
 	// same user can't lock the same object multiple times
-	Lock(fmt.Sprintf("mutex-sync:%s:%s", s, uid))
+	lockLocal(fmt.Sprintf("mutex-sync:%s:%s", s, uid))
 	var nLocks = checkSoftLock(uid)
 	if nLocks == 0 {
 		Lock(s)
@@ -73,7 +76,7 @@ func LockWithContext(ctx context.Context, s string) {
 	}
 	nLocks++
 	mutexMap.Set(fmt.Sprintf("nLocks:%s", uid), nLocks)
-	Unlock(fmt.Sprintf("mutex-sync:%s:%s", s, uid))
+	unlockLocal(fmt.Sprintf("mutex-sync:%s:%s", s, uid))
 }
 
 // UnlockWithContext unlock a mutex only if it has been locked once within a context.
@@ -90,7 +93,7 @@ func UnlockWithContext(ctx context.Context, s string) {
 		log.Error("[Mutex] UnlockWithContext: s is empty!")
 		return
 	}
-	Lock(fmt.Sprintf("mutex-sync:%s:%s", s, uid))
+	lockLocal(fmt.Sprintf("mutex-sync:%s:%s", s, uid))
 	var nLocks = checkSoftLock(uid)
 	nLocks--
 	mutexMap.Set(fmt.Sprintf("nLocks:%s", uid), nLocks)
@@ -100,14 +103,31 @@ func UnlockWithContext(ctx context.Context, s string) {
 	} else {
 		log.Tracef("[Mutex] Skip unlock (nLocks: %d)", nLocks)
 	}
-	Unlock(fmt.Sprintf("mutex-sync:%s:%s", s, uid))
+	unlockLocal(fmt.Sprintf("mutex-sync:%s:%s", s, uid))
 	//mutexMap.Remove(fmt.Sprintf("mutex-sync:%s:%s", s, uid))
 }
 
-// Lock locks a mutex in the mutexMap. If the mutex is already in the map, it locks the current call.
+// Lock locks a mutex in the mutexMap and, if a distributed Locker is set, across all bot instances.
+func Lock(s string) {
+	lockLocal(s)
+	if locker != nil {
+		locker.Lock(s)
+	}
+}
+
+// Unlock releases the distributed lock before the local mutex so that the next local
+// caller can acquire both.
+func Unlock(s string) {
+	if locker != nil {
+		locker.Unlock(s)
+	}
+	unlockLocal(s)
+}
+
+// lockLocal locks a mutex in the mutexMap. If the mutex is already in the map, it locks the current call.
 // After it another call unlocks the mutex (and deletes it from the mutexMap) the mutex written again into the mutexMap.
 // If the mutex was not in the mutexMap before, a new mutext is created and locked and written into the mutexMap.
-func Lock(s string) {
+func lockLocal(s string) {
 	log.Tracef("[Mutex] Attempt Lock %s", s)
 	if m, ok := mutexMap.Get(s); ok {
 		m.(*sync.Mutex).Lock()
@@ -126,8 +146,8 @@ func Lock(s string) {
 	log.Tracef("[Mutex] Locked %s", s)
 }
 
-// Unlock unlocks a mutex in the mutexMap.
-func Unlock(s string) {
+// unlockLocal unlocks a mutex in the mutexMap.
+func unlockLocal(s string) {
 	mutexMapSync.Lock()
 	if m, ok := mutexMap.Get(s); ok {
 		mutexMap.Remove(s)
@@ -12,6 +12,15 @@ import (
 
 var transactionCache = store.NewGoCache(gocache.New(5*time.Minute, 10*time.Minute), nil)
 
+// objectCacheDisabled is set if several bot instances share the store, an object
+// cached in one instance could be outdated by another.
+var objectCacheDisabled bool
+
+// DisableObjectCache makes Get read every object from the store.
+func DisableObjectCache() {
+	objectCacheDisabled = true
+}
+
 type Base struct {
 	ID        string    `json:"id"`
 	Active    bool      `json:"active"`
@@ -44,7 +53,7 @@ func (tx Base) Key() string {
 	return tx.ID
 }
 
-func (tx *Base) Inactivate(s Storable, db *DB) error {
+func (tx *Base) Inactivate(s Storable, db Store) error {
 	tx.Active = false
 	err := tx.Set(s, db)
 	if err != nil {
@@ -55,7 +64,10 @@ func (tx *Base) Inactivate(s Storable, db *DB) error {
 	return nil
 }
 
-func (tx *Base) Get(s Storable, db *DB) (Storable, error) {
+func (tx *Base) Get(s Storable, db Store) (Storable, error) {
+	if objectCacheDisabled {
+		return s, db.Get(s)
+	}
 	cacheTx, err := transactionCache.Get(s.Key())
 	if err != nil {
 		err := db.Get(s)
@@ -70,7 +82,7 @@ func (tx *Base) Get(s Storable, db *DB) (Storable, error) {
 
 }
 
-func (tx *Base) Set(s Storable, db *DB) error {
+func (tx *Base) Set(s Storable, db Store) error {
 	tx.UpdatedAt = time.Now()
 	err := db.Set(s)
 	if err != nil {
@@ -78,6 +90,9 @@ func (tx *Base) Set(s Storable, db *DB) error {
 		return err
 	}
 	log.Tracef("[Bunt] set object %s", s.Key())
+	if objectCacheDisabled {
+		return nil
+	}
 	err = transactionCache.Set(s.Key(), s, &store.Options{Expiration: 5 * time.Minute})
 	if err != nil {
 		log.Errorf("[Bunt Cache] could not set object: %v", err.Error())
@@ -86,7 +101,7 @@ func (tx *Base) Set(s Storable, db *DB) error {
 	return err
 }
 
-func (tx *Base) Delete(s Storable, db *DB) error {
+func (tx *Base) Delete(s Storable, db Store) error {
 	tx.UpdatedAt = time.Now()
 	runtime.IgnoreError(transactionCache.Delete(s.Key()))
 	return db.Delete(s.Key(), s)
@@ -13,10 +13,24 @@ type Storable interface {
 	Key() string
 }
 
+// Store is a key/value store for Storable items. Items are saved as JSON.
+type Store interface {
+	Exists(storable Storable) (bool, error)
+	Get(object Storable) error
+	Set(object Storable) error
+	Delete(index string, object Storable) error
+	// CreateIndex creates an index over all keys matching pattern, like "join-ticket:*".
+	CreateIndex(name, pattern string, less ...func(a, b string) bool) error
+	// Ascend iterates over all items of an index until iterator returns false.
+	Ascend(index string, iterator func(key, value string) bool) error
+}
+
 type DB struct {
 	*buntdb.DB
 }
 
+var _ Store = (*DB)(nil)
+
 func NewBunt(filePath string) *DB {
 	db, err := buntdb.Open(filePath)
 	if err != nil {
@@ -108,3 +122,10 @@ func (db *DB) Delete(index string, object Storable) error {
 		return nil
 	})
 }
+
+// Ascend iterates over all items of an index.
+func (db *DB) Ascend(index string, iterator func(key, value string) bool) error {
+	return db.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend(index, iterator)
+	})
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/eko/gocache/store"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	SharedCacheType          = "shared"
+	sharedCacheExpiration    = 5 * time.Minute
+	sharedCacheCleanInterval = 10 * time.Minute
+)
+
+// CacheItem is a row of the shared cache table.
+type CacheItem struct {
+	Key       string `gorm:"primaryKey"`
+	Type      string
+	Value     string    `gorm:"type:text"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+var cacheTypes sync.Map // type name -> reflect.Type
+
+// RegisterCacheTypes makes types known to the SharedCache. Get returns values of the
+// same type that was Set, so values of unregistered types can not be cached.
+func RegisterCacheTypes(values ...interface{}) {
+	for _, value := range values {
+		t := reflect.TypeOf(value)
+		cacheTypes.Store(t.String(), t)
+	}
+}
+
+// SharedCache is a cache store on a database table, so that several bot instances
+// see the same cached state. Values are stored as JSON.
+type SharedCache struct {
+	db *gorm.DB
+}
+
+var _ store.StoreInterface = (*SharedCache)(nil)
+
+func NewSharedCache(db *gorm.DB) *SharedCache {
+	err := db.AutoMigrate(&CacheItem{})
+	if err != nil {
+		panic(err)
+	}
+	c := &SharedCache{db: db}
+	go c.clean()
+	return c
+}
+
+// clean periodically removes expired items.
+func (c *SharedCache) clean() {
+	for {
+		time.Sleep(sharedCacheCleanInterval)
+		tx := c.db.Where("expires_at < ?", time.Now()).Delete(&CacheItem{})
+		if tx.Error != nil {
+			log.Errorf("[SharedCache] could not remove expired items: %v", tx.Error)
+		}
+	}
+}
+
+func (c *SharedCache) Get(key interface{}) (interface{}, error) {
+	value, _, err := c.GetWithTTL(key)
+	return value, err
+}
+
+func (c *SharedCache) GetWithTTL(key interface{}) (interface{}, time.Duration, error) {
+	item := &CacheItem{}
+	tx := c.db.Where("key = ? AND expires_at > ?", fmt.Sprint(key), time.Now()).First(item)
+	if tx.Error != nil {
+		return nil, 0, fmt.Errorf("value not found in shared cache: %v", tx.Error)
+	}
+	t, ok := cacheTypes.Load(item.Type)
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown cache type %s", item.Type)
+	}
+	typ := t.(reflect.Type)
+	var value reflect.Value
+	if typ.Kind() == reflect.Ptr {
+		value = reflect.New(typ.Elem())
+		if err := json.Unmarshal([]byte(item.Value), value.Interface()); err != nil {
+			return nil, 0, err
+		}
+	} else {
+		value = reflect.New(typ)
+		if err := json.Unmarshal([]byte(item.Value), value.Interface()); err != nil {
+			return nil, 0, err
+		}
+		value = value.Elem()
+	}
+	return value.Interface(), time.Until(item.ExpiresAt), nil
+}
+
+func (c *SharedCache) Set(key interface{}, value interface{}, options *store.Options) error {
+	typ := reflect.TypeOf(value)
+	if typ == nil {
+		return fmt.Errorf("can not cache nil value")
+	}
+	if _, ok := cacheTypes.Load(typ.String()); !ok {
+		return fmt.Errorf("cache type %s is not registered", typ.String())
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	expiration := sharedCacheExpiration
+	if options != nil && options.Expiration > 0 {
+		expiration = options.Expiration
+	}
+	return c.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&CacheItem{
+		Key:       fmt.Sprint(key),
+		Type:      typ.String(),
+		Value:     string(b),
+		ExpiresAt: time.Now().Add(expiration),
+	}).Error
+}
+
+func (c *SharedCache) Delete(key interface{}) error {
+	return c.db.Where("key = ?", fmt.Sprint(key)).Delete(&CacheItem{}).Error
+}
+
+// Invalidate is not supported, the bot does not tag cached values.
+func (c *SharedCache) Invalidate(options store.InvalidateOptions) error {
+	return nil
+}
+
+func (c *SharedCache) Clear() error {
+	return c.db.Where("1 = 1").Delete(&CacheItem{}).Error
+}
+
+func (c *SharedCache) GetType() string {
+	return SharedCacheType
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// KeyValue is a row of a key/value table in PostgreSQL.
+type KeyValue struct {
+	Key   string `gorm:"primaryKey"`
+	Value string `gorm:"type:text"`
+}
+
+// PostgresDB stores Storable items in a PostgreSQL table instead of a buntdb file.
+type PostgresDB struct {
+	db      *gorm.DB
+	table   string
+	indexes sync.Map // index name -> key prefix
+}
+
+var _ Store = (*PostgresDB)(nil)
+
+// NewPostgres creates the key/value table if necessary.
+func NewPostgres(db *gorm.DB, table string) *PostgresDB {
+	err := db.Table(table).AutoMigrate(&KeyValue{})
+	if err != nil {
+		panic(err)
+	}
+	return &PostgresDB{db: db, table: table}
+}
+
+func (p *PostgresDB) Exists(storable Storable) (bool, error) {
+	var count int64
+	tx := p.db.Table(p.table).Where("key = ?", storable.Key()).Count(&count)
+	return count > 0, tx.Error
+}
+
+func (p *PostgresDB) Get(object Storable) error {
+	kv := &KeyValue{}
+	tx := p.db.Table(p.table).Where("key = ?", object.Key()).First(kv)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return json.Unmarshal([]byte(kv.Value), object)
+}
+
+func (p *PostgresDB) Set(object Storable) error {
+	b, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	return p.db.Table(p.table).Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&KeyValue{Key: object.Key(), Value: string(b)}).Error
+}
+
+func (p *PostgresDB) Delete(index string, object Storable) error {
+	tx := p.db.Table(p.table).Where("key = ?", object.Key()).Delete(&KeyValue{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("not found")
+	}
+	return nil
+}
+
+// CreateIndex remembers the key pattern of an index. Only patterns with a trailing
+// wildcard are supported, the order of the items is always the order of the keys.
+func (p *PostgresDB) CreateIndex(name, pattern string, less ...func(a, b string) bool) error {
+	if strings.Count(pattern, "*") != 1 || !strings.HasSuffix(pattern, "*") {
+		return fmt.Errorf("unsupported index pattern %s", pattern)
+	}
+	p.indexes.Store(name, strings.TrimSuffix(pattern, "*"))
+	return nil
+}
+
+func (p *PostgresDB) Ascend(index string, iterator func(key, value string) bool) error {
+	prefix, ok := p.indexes.Load(index)
+	if !ok {
+		return fmt.Errorf("unknown index %s", index)
+	}
+	rows, err := p.db.Table(p.table).Select("key, value").
+		Where("key LIKE ?", prefix.(string)+"%").Order("key").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		if !iterator(key, value) {
+			break
+		}
+	}
+	return rows.Err()
+}
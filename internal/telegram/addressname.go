@@ -0,0 +1,135 @@
+package telegram
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/database"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+)
+
+var addressNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{2,31}$`)
+
+// reservedAddressNames can not be requested as custom address names.
+var reservedAddressNames = []string{
+	"abuse", "admin", "administrator", "bot", "help", "hostmaster", "info", "lightning",
+	"lnurl", "lnurlp", "mod", "moderator", "noreply", "official", "pay", "payments",
+	"postmaster", "root", "security", "staff", "support", "team", "tipbot", "wallet", "webmaster",
+}
+
+func isReservedAddressName(name string) bool {
+	for _, reserved := range reservedAddressNames {
+		if name == reserved {
+			return true
+		}
+	}
+	// names that look like anonymous ids are resolved differently
+	if _, err := strconv.ParseInt(name, 10, 64); err == nil {
+		return true
+	}
+	return strings.HasPrefix(name, "0x") || strings.HasPrefix(name, "1x")
+}
+
+// addressNameAvailable checks that no other user has the name as Telegram username or address name.
+func (bot *TipBot) addressNameAvailable(user *lnbits.User, name string) bool {
+	var count int64
+	bot.DB.Users.Model(&lnbits.User{}).Where(database.EqualFold("telegram_username")+" AND id != ?", name, user.ID).Count(&count)
+	if count > 0 {
+		return false
+	}
+	bot.DB.Users.Model(&database.AddressName{}).Where("name = ? AND user_id != ? AND status != ?", name, user.ID, database.AddressNameStatusRejected).Count(&count)
+	return count == 0
+}
+
+// getAddressName returns the latest address name request of a user.
+func (bot *TipBot) getAddressName(user *lnbits.User) (*database.AddressName, error) {
+	addressName := &database.AddressName{}
+	tx := bot.DB.Users.Where("user_id = ?", user.ID).Order("updated_at desc").First(addressName)
+	return addressName, tx.Error
+}
+
+// addressHandler is invoked on /address [<name>]
+func (bot *TipBot) addressHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	host := internal.Configuration.Bot.LNURLHostUrl.Hostname()
+	name, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		message := fmt.Sprintf(Translate(ctx, "addressHelpMessage"), host)
+		if addressName, err := bot.getAddressName(user); err == nil {
+			message += "\n\n" + fmt.Sprintf(Translate(ctx, "addressCurrentMessage"), addressName.Name, host, addressName.Status)
+		}
+		bot.trySendMessage(m.Sender, message)
+		return ctx, nil
+	}
+	name = strings.ToLower(name)
+	if !addressNameRegex.MatchString(name) {
+		bot.trySendMessage(m.Sender, Translate(ctx, "addressInvalidMessage"))
+		return ctx, fmt.Errorf("invalid address name %s", name)
+	}
+	if isReservedAddressName(name) {
+		bot.trySendMessage(m.Sender, Translate(ctx, "addressReservedMessage"))
+		return ctx, fmt.Errorf("address name %s is reserved", name)
+	}
+	if !bot.addressNameAvailable(user, name) {
+		bot.trySendMessage(m.Sender, Translate(ctx, "addressTakenMessage"))
+		return ctx, fmt.Errorf("address name %s is taken", name)
+	}
+	// a user can only have one address name, a new request replaces the old one
+	bot.DB.Users.Where("user_id = ?", user.ID).Delete(&database.AddressName{})
+	tx := bot.DB.Users.Save(&database.AddressName{
+		Name:      name,
+		UserID:    user.ID,
+		Status:    database.AddressNameStatusPending,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	if tx.Error != nil {
+		log.Errorf("[addressHandler] could not save address name %s: %v", name, tx.Error)
+		return ctx, tx.Error
+	}
+	log.Infof("[addressHandler] %s requested address name %s", GetUserStr(user.Telegram), name)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "addressRequestedMessage"), name, host))
+	return ctx, nil
+}
+
+// PendingAddressNames returns all address names that wait for approval.
+func (bot *TipBot) PendingAddressNames() ([]database.AddressName, error) {
+	addressNames := []database.AddressName{}
+	tx := bot.DB.Users.Where("status = ?", database.AddressNameStatusPending).Order("created_at").Find(&addressNames)
+	return addressNames, tx.Error
+}
+
+// ReviewAddressName approves or rejects a pending address name and notifies the user.
+func (bot *TipBot) ReviewAddressName(name string, approve bool) error {
+	addressName := &database.AddressName{}
+	tx := bot.DB.Users.Where("name = ? AND status = ?", strings.ToLower(name), database.AddressNameStatusPending).First(addressName)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	addressName.Status = database.AddressNameStatusRejected
+	message := "addressRejectedMessage"
+	if approve {
+		addressName.Status = database.AddressNameStatusApproved
+		message = "addressApprovedMessage"
+	}
+	addressName.UpdatedAt = time.Now()
+	tx = bot.DB.Users.Save(addressName)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	user := &lnbits.User{}
+	tx = bot.DB.Users.Where("id = ?", addressName.UserID).First(user)
+	if tx.Error == nil && user.Telegram != nil {
+		bot.trySendMessage(user.Telegram, fmt.Sprintf(i18n.Translate(user.Telegram.LanguageCode, message), addressName.Name, internal.Configuration.Bot.LNURLHostUrl.Hostname()))
+	}
+	log.Infof("[ReviewAddressName] address name %s of user %s %s", addressName.Name, addressName.UserID, addressName.Status)
+	return nil
+}
@@ -0,0 +1,148 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/eko/gocache/store"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	AlertBalance = "balance"
+	AlertPayment = "payment"
+	AlertDaily   = "daily"
+)
+
+func dailyOutflowCacheKey(userId int64, day time.Time) string {
+	return fmt.Sprintf("outflow-%d-%s", userId, day.UTC().Format("2006-01-02"))
+}
+
+// addDailyOutflow adds amount to the outflow of the user today and returns the outflow
+// before and after the payment.
+func (bot *TipBot) addDailyOutflow(userId int64, amount int64) (before int64, after int64) {
+	key := dailyOutflowCacheKey(userId, time.Now())
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	if cached, err := bot.Cache.Get(key); err == nil {
+		before, _ = cached.(int64)
+	}
+	after = before + amount
+	bot.Cache.Set(key, after, &store.Options{Expiration: 25 * time.Hour})
+	return before, after
+}
+
+// checkAlerts is called after every outgoing payment and notifies the sender if the payment
+// crossed one of their alert thresholds. Every alert fires once when its threshold is crossed,
+// not for every payment after that.
+func (bot *TipBot) checkAlerts(payment lnbits.OutgoingPayment) {
+	user := &lnbits.User{}
+	tx := bot.DB.Users.Preload("Settings").Where("wallet_id = ?", payment.WalletID).First(user)
+	if tx.Error != nil || user.Telegram == nil || user.Settings == nil {
+		return
+	}
+	alerts := user.Settings.Alert
+	if alerts == (lnbits.AlertSettings{}) {
+		return
+	}
+	bolt11, err := decodepay.Decodepay(payment.Bolt11)
+	if err != nil {
+		return
+	}
+	amount := bolt11.MSatoshi / 1000
+	language := user.Telegram.LanguageCode
+	var messages []string
+	if alerts.PaymentAbove > 0 && amount > alerts.PaymentAbove {
+		messages = append(messages, fmt.Sprintf(i18n.Translate(language, "alertPaymentMessage"), amount, alerts.PaymentAbove))
+	}
+	if alerts.DailyOutflowAbove > 0 {
+		before, after := bot.addDailyOutflow(user.Telegram.ID, amount)
+		if before <= alerts.DailyOutflowAbove && after > alerts.DailyOutflowAbove {
+			messages = append(messages, fmt.Sprintf(i18n.Translate(language, "alertDailyMessage"), after, alerts.DailyOutflowAbove))
+		}
+	}
+	if alerts.BalanceBelow > 0 {
+		if balance, err := bot.GetUserBalance(user); err == nil && balance < alerts.BalanceBelow && balance+amount >= alerts.BalanceBelow {
+			messages = append(messages, fmt.Sprintf(i18n.Translate(language, "alertBalanceMessage"), balance, alerts.BalanceBelow))
+		}
+	}
+	if len(messages) == 0 {
+		return
+	}
+	log.Infof("[alerts] payment of %d sat of %s crossed %d alert thresholds", amount, GetUserStr(user.Telegram), len(messages))
+	// alerts are sent right away, even if the user gets a digest of their notifications
+	bot.trySendMessage(user.Telegram, strings.Join(messages, "\n"))
+}
+
+// alertsMessage shows the alerts of the user.
+func alertsMessage(ctx intercept.Context, alerts lnbits.AlertSettings) string {
+	if alerts == (lnbits.AlertSettings{}) {
+		return Translate(ctx, "alertsOffMessage")
+	}
+	message := Translate(ctx, "alertsListMessage")
+	if alerts.BalanceBelow > 0 {
+		message += fmt.Sprintf(Translate(ctx, "alertsBalanceEntryMessage"), alerts.BalanceBelow)
+	}
+	if alerts.PaymentAbove > 0 {
+		message += fmt.Sprintf(Translate(ctx, "alertsPaymentEntryMessage"), alerts.PaymentAbove)
+	}
+	if alerts.DailyOutflowAbove > 0 {
+		message += fmt.Sprintf(Translate(ctx, "alertsDailyEntryMessage"), alerts.DailyOutflowAbove)
+	}
+	return message
+}
+
+// alertsHandler is invoked on /alerts <balance|payment|daily> <amount|off> and /alerts off.
+func (bot *TipBot) alertsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	splits := strings.Fields(m.Text)
+	if len(splits) < 2 {
+		bot.trySendMessage(m.Sender, alertsMessage(ctx, user.Settings.Alert)+"\n\n"+Translate(ctx, "alertsHelpMessage"))
+		return ctx, nil
+	}
+	alerts := &user.Settings.Alert
+	kind := strings.ToLower(splits[1])
+	if kind == "off" {
+		*alerts = lnbits.AlertSettings{}
+	} else {
+		if len(splits) != 3 {
+			bot.trySendMessage(m.Sender, Translate(ctx, "alertsHelpMessage"))
+			return ctx, fmt.Errorf("invalid alerts command")
+		}
+		var threshold int64
+		if strings.ToLower(splits[2]) != "off" {
+			threshold, err = GetAmount(splits[2])
+			if err != nil || threshold < 1 {
+				bot.trySendMessage(m.Sender, Translate(ctx, "alertsHelpMessage"))
+				return ctx, fmt.Errorf("invalid alert threshold %s", splits[2])
+			}
+		}
+		switch kind {
+		case AlertBalance:
+			alerts.BalanceBelow = threshold
+		case AlertPayment:
+			alerts.PaymentAbove = threshold
+		case AlertDaily:
+			alerts.DailyOutflowAbove = threshold
+		default:
+			bot.trySendMessage(m.Sender, Translate(ctx, "alertsHelpMessage"))
+			return ctx, fmt.Errorf("invalid alert %s", kind)
+		}
+	}
+	if err := UpdateUserRecord(user, *bot); err != nil {
+		log.Errorf("[/alerts] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, alertsMessage(ctx, *alerts))
+	return ctx, nil
+}
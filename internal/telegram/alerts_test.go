@@ -0,0 +1,62 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestAlerts(t *testing.T) {
+	h := newTestHarness(t)
+	payments := lnbits.NewPaymentObserver(h.bot.Client)
+	payments.OnPayment(h.bot.checkAlerts)
+	h.bot.Client = payments
+	alice := &tb.User{ID: 9995, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9996, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	h.newUser(alice, 1000)
+	h.newUser(bob, 0)
+
+	h.sendMessage(alice, privateChat(alice), "/alerts balance 600")
+	h.sendMessage(alice, privateChat(alice), "/alerts payment 500")
+	h.sendMessage(alice, privateChat(alice), "/alerts daily 700")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "600") || !strings.Contains(text, "500") || !strings.Contains(text, "700") {
+		t.Fatalf("alerts = %q", text)
+	}
+
+	alerts := func() []string {
+		var texts []string
+		for _, r := range h.sent {
+			if r.ChatId() == alice.ID && r.Method == "sendMessage" && strings.HasPrefix(r.Text(), "🚨 You") {
+				texts = append(texts, r.Text())
+			}
+		}
+		h.sent = nil
+		return texts
+	}
+	send := func(text string) {
+		h.sendMessage(alice, privateChat(alice), text)
+		h.pressButton(alice, h.lastMessage(alice.ID), "✅ Send")
+	}
+
+	send("/send 100 @bob")
+	if texts := alerts(); len(texts) != 0 {
+		t.Errorf("alerts below the thresholds: %q", texts)
+	}
+	// 550 sat is above the payment alert and the balance drops to 350 sat
+	send("/send 550 @bob")
+	if texts := alerts(); len(texts) != 1 || !strings.Contains(texts[0], "You paid 550 sat") || !strings.Contains(texts[0], "dropped to 350 sat") {
+		t.Errorf("alerts = %q", texts)
+	}
+	// 750 sat today, the balance alert fired already
+	send("/send 100 @bob")
+	if texts := alerts(); len(texts) != 1 || !strings.Contains(texts[0], "750 sat today") || strings.Contains(texts[0], "balance") {
+		t.Errorf("alerts = %q", texts)
+	}
+
+	h.sendMessage(alice, privateChat(alice), "/alerts off")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "no alerts") {
+		t.Errorf("alerts off = %q", text)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/LightningTipBot/LightningTipBot/internal"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
 
 	"github.com/LightningTipBot/LightningTipBot/internal/errors"
@@ -21,12 +24,34 @@ import (
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
 
-// amountsMap maps string to int64 amounts
+// maxAmount is the largest amount in satoshis, all bitcoin that will ever exist.
+const maxAmount = 21_000_000 * 100_000_000
+
+// amountsMap maps shorthands to amounts in satoshis. Operators can add their own with
+// bot.amounts in the configuration.
 var amountsMap = map[string]int64{
 	"🍌": 777,
 	"🥜": 69,
 }
 
+// amountUnits are the units that amounts can end with, longer suffixes first.
+var amountUnits = []struct {
+	suffix string
+	sats   int64
+}{
+	{"sats", 1},
+	{"sat", 1},
+	{"bits", 100},
+	{"bit", 100},
+	{"btc", 100_000_000},
+	{"₿", 100_000_000},
+	{"k", 1_000},
+	{"m", 1_000_000},
+}
+
+// amountNumberRegex matches plain decimal numbers. Exponents, NaN and Inf are no amounts.
+var amountNumberRegex = regexp.MustCompile(`^(\d+\.?\d*|\.\d+)$`)
+
 func getArgumentFromCommand(input string, which int) (output string, err error) {
 	if len(strings.Split(input, " ")) < which+1 {
 		return "", fmt.Errorf("message doesn't contain enough arguments")
@@ -45,25 +70,28 @@ func decodeAmountFromCommand(input string) (amount int64, err error) {
 	return amount, err
 }
 
-// GetAmount parses an amount from a string like 1.2k or 3.50€
-// and returns the value in satoshis
+// GetAmount parses an amount from a string like 1.2k, 0.1m, 0.0001btc, 2🍺 or 3.50€
+// and returns the value in satoshis. All commands parse their amounts with it.
 func GetAmount(input string) (amount int64, err error) {
 	// replace occurances of comma with dot
-	input = strings.Replace(input, ",", ".", -1)
+	input = strings.TrimSpace(strings.Replace(input, ",", ".", -1))
 
-	// replace strings in amountsMap with their integer values
-	for k, v := range amountsMap {
-		input = strings.Replace(input, k, strconv.FormatInt(v, 10), -1)
+	// shorthands like 🍌 or 2🍌
+	if sats, count, ok := parseAmountShorthand(input); ok {
+		return checkAmount(count * float64(sats))
 	}
 
-	// convert something like 1.2k into 1200
-	if strings.HasSuffix(strings.ToLower(input), "k") {
-		fmount, err := strconv.ParseFloat(strings.TrimSpace(input[:len(input)-1]), 64)
-		if err != nil {
-			return 0, err
+	// units like 1.2k or 0.0001btc
+	lower := strings.ToLower(input)
+	for _, unit := range amountUnits {
+		if !strings.HasSuffix(lower, unit.suffix) {
+			continue
+		}
+		// currencies like RM also end with a unit, they are tried below
+		if number, err := parseAmountNumber(strings.TrimSuffix(lower, unit.suffix)); err == nil {
+			return checkAmount(number * float64(unit.sats))
 		}
-		amount = int64(fmount * 1000)
-		return amount, err
+		break
 	}
 
 	// convert fiat currencies to satoshis
@@ -74,16 +102,12 @@ func GetAmount(input string) (amount int64, err error) {
 			numeric_string := ""
 			numeric_string = strings.Replace(input, symbol, "", 1)                                              // for symbol like $
 			numeric_string = strings.Replace(strings.ToLower(numeric_string), strings.ToLower(currency), "", 1) // for 1USD
-			fmount, err := strconv.ParseFloat(numeric_string, 64)
+			fmount, err := parseAmountNumber(numeric_string)
 			if err != nil {
 				log.Errorln(err)
 				return 0, err
 			}
-			if !(price.Price[currency] > 0) {
-				return 0, fmt.Errorf("price is zero")
-			}
-			amount = int64(fmount / price.Price[currency] * float64(100_000_000))
-			return amount, nil
+			return FiatToSatoshis(fmount, currency)
 		}
 	}
 
@@ -95,9 +119,68 @@ func GetAmount(input string) (amount int64, err error) {
 	if amount <= 0 {
 		return 0, fmt.Errorf("amount must be greater than 0")
 	}
+	if amount > maxAmount {
+		return 0, fmt.Errorf("amount too large")
+	}
 	return amount, err
 }
 
+// parseAmountNumber parses the number of an amount like 1.5 or .5.
+func parseAmountNumber(input string) (float64, error) {
+	input = strings.TrimSpace(input)
+	if !amountNumberRegex.MatchString(input) {
+		return 0, fmt.Errorf("%q is not a number", input)
+	}
+	return strconv.ParseFloat(input, 64)
+}
+
+// parseAmountShorthand parses shorthands of amountsMap and of the configuration, optionally
+// with a count in front like 2🍺 or 2x🍺. It returns the amount of the shorthand and the count.
+func parseAmountShorthand(input string) (sats int64, count float64, ok bool) {
+	shorthands := make(map[string]int64, len(amountsMap)+len(internal.Configuration.Bot.Amounts))
+	for shorthand, sats := range amountsMap {
+		shorthands[shorthand] = sats
+	}
+	for shorthand, sats := range internal.Configuration.Bot.Amounts {
+		shorthands[shorthand] = sats
+	}
+	for shorthand, sats := range shorthands {
+		if len(shorthand) == 0 || !strings.HasSuffix(input, shorthand) {
+			continue
+		}
+		prefix := strings.TrimRight(strings.TrimSuffix(input, shorthand), "xX×* ")
+		if len(prefix) == 0 {
+			return sats, 1, true
+		}
+		if count, err := parseAmountNumber(prefix); err == nil {
+			return sats, count, true
+		}
+	}
+	return 0, 0, false
+}
+
+// checkAmount converts a parsed decimal amount to satoshis. Amounts that round
+// down to zero, negative amounts and overflows are invalid.
+func checkAmount(amount float64) (int64, error) {
+	// 0.00000003 btc are 2.9999999999999996 sat as float
+	amount = math.Floor(amount + 1e-6)
+	if math.IsNaN(amount) || amount < 1 {
+		return 0, fmt.Errorf("amount must be greater than 0")
+	}
+	if amount > maxAmount {
+		return 0, fmt.Errorf("amount too large")
+	}
+	return int64(amount), nil
+}
+
+// FiatToSatoshis converts an amount in a fiat currency to satoshis.
+func FiatToSatoshis(fiat float64, currency string) (int64, error) {
+	if !(price.Price[currency] > 0) {
+		return 0, fmt.Errorf("price is zero")
+	}
+	return checkAmount(fiat / price.Price[currency] * float64(100_000_000))
+}
+
 func SatoshisToFiat(amount int64, currency string) (fiat float64, err error) {
 	if !(price.Price[currency] > 0) {
 		return 0, fmt.Errorf("price is zero")
@@ -0,0 +1,84 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/price"
+)
+
+func init() {
+	price.NewPriceWatcher()
+	price.Price["USD"] = 20000
+	internal.Configuration.Bot.Amounts = map[string]int64{"🍺": 1000}
+}
+
+func TestGetAmount(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "100", want: 100},
+		{input: "1.2k", want: 1200},
+		{input: "1,5K", want: 1500},
+		{input: "0.1m", want: 100_000},
+		{input: "2M", want: 2_000_000},
+		{input: "0.0001btc", want: 10_000},
+		{input: "0.00000003BTC", want: 3},
+		{input: "₿0.001", wantErr: true},
+		{input: "0.001₿", want: 100_000},
+		{input: "100sat", want: 100},
+		{input: "21sats", want: 21},
+		{input: "5bits", want: 500},
+		{input: ".5k", want: 500},
+		{input: "🥜", want: 69},
+		{input: "3🥜", want: 207},
+		{input: "2x🍌", want: 1554},
+		{input: "🍺", want: 1000},
+		{input: "0🥜", wantErr: true},
+		{input: "-2🥜", wantErr: true},
+		{input: "1e3", wantErr: true},
+		{input: "0.5", wantErr: true},
+		{input: "1.5k🥜", wantErr: true},
+		{input: "0.00000001m", wantErr: true},
+		{input: "mk", wantErr: true},
+		{input: "$1", want: 5000},
+		{input: "1usd", want: 5000},
+		{input: "0", wantErr: true},
+		{input: "-100", wantErr: true},
+		{input: "-1k", wantErr: true},
+		{input: "0.0001k", wantErr: true},
+		{input: "NaNk", wantErr: true},
+		{input: "infk", wantErr: true},
+		{input: "1e30k", wantErr: true},
+		{input: "99999999999999999999", wantErr: true},
+		{input: "-1$", wantErr: true},
+		{input: "$0.000000001", wantErr: true},
+		{input: "€1", wantErr: true}, // no price
+		{input: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := GetAmount(tt.input)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("GetAmount(%q) = %d, %v, want %d, error %v", tt.input, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func FuzzDecodeAmountFromCommand(f *testing.F) {
+	for _, seed := range []string{"/tip 100", "/send 1.5k @user", "/tip 🍌", "/tip -5", "/tip 1e400k", "/tip NaNk", "/faucet 0,000001k 2", "/tip  100", "/tip", "/tip $-1", "/tip 1e-300usd", "/tip 0.1m", "/tip 0.0001btc", "/tip 3x🍌", "/tip 🍺🍺"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, command string) {
+		amount, err := decodeAmountFromCommand(command)
+		if err == nil && (amount < 1 || amount > maxAmount) {
+			t.Errorf("decodeAmountFromCommand(%q) = %d", command, amount)
+		}
+		if err != nil && amount != 0 {
+			t.Errorf("decodeAmountFromCommand(%q) = %d with error %v", command, amount, err)
+		}
+	})
+}
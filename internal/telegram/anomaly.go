@@ -0,0 +1,369 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/eko/gocache/store"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	AuditActionWalletPause   = "wallet pause"
+	AuditActionWalletUnpause = "wallet unpause"
+
+	AnomalyDrain            = "balance drain"
+	AnomalyFreshDestination = "many payments to a new destination"
+	AnomalyVelocity         = "spike of payments"
+
+	// anomalyWindow is the time in which the payments of a wallet are compared with its balance
+	// and the day before
+	anomalyWindow = time.Hour
+	// anomalyHistory is how long the payments of a wallet are remembered
+	anomalyHistory = 24 * time.Hour
+	// anomalyFreshDestination is how long a destination counts as new after its first payment
+	anomalyFreshDestination = 24 * time.Hour
+	// anomalyDestinationMemory is how long the destinations of a wallet are remembered
+	anomalyDestinationMemory = 30 * 24 * time.Hour
+	// anomalyDefaultPause is how long a wallet is paused if the configuration sets no duration
+	anomalyDefaultPause = 24 * time.Hour
+	// anomalyReportedPause is how long a wallet stays paused if its user did not make the payment
+	anomalyReportedPause = 30 * 24 * time.Hour
+	// anomalyTrustDuration is how long the checks skip a wallet after its pause was lifted
+	anomalyTrustDuration = time.Hour
+	// anomalyAdminLanguage is the language of the alerts in the admin chat
+	anomalyAdminLanguage = "en"
+)
+
+var (
+	anomalyMenu       = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnConfirmAnomaly = anomalyMenu.Data("✅ It was me", "confirm_anomaly")
+	btnReportAnomaly  = anomalyMenu.Data("🚨 It wasn't me", "report_anomaly")
+	walletPausedError = "wallet paused because of suspicious activity, check the messages of the bot"
+)
+
+// WalletActivity are the recent outgoing payments of a wallet and the destinations that it paid.
+type WalletActivity struct {
+	Payments []WalletPayment `json:"payments"`
+	// Destinations are the nodes and, for internal transfers, the wallets that the wallet paid
+	// and the time of their first payment
+	Destinations map[string]time.Time `json:"destinations"`
+}
+
+// WalletPayment is an outgoing payment of a wallet.
+type WalletPayment struct {
+	Time        time.Time `json:"time"`
+	Amount      int64     `json:"amount"`
+	Destination string    `json:"destination"`
+}
+
+// WalletPause stops the outgoing payments of a wallet with suspicious activity until its user
+// confirms the payments, support lifts the pause or it runs out. Pauses are kept as a log.
+type WalletPause struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time `json:"created_at"`
+	UserId     int64     `json:"user_id" gorm:"index"`
+	WalletId   string    `json:"wallet_id" gorm:"index"`
+	Reason     string    `json:"reason"`
+	Amount     int64     `json:"amount"` // the payment that was stopped
+	Until      time.Time `json:"until"`
+	Reported   bool      `json:"reported"`    // the user did not make the payments
+	ResolvedBy string    `json:"resolved_by"` // who lifted the pause, empty while it lasts
+	ResolvedAt time.Time `json:"resolved_at"`
+	MessageId  int       `json:"message_id"`
+}
+
+func walletActivityCacheKey(walletId string) string {
+	return fmt.Sprintf("wallet-activity-%s", walletId)
+}
+
+func anomalyTrustCacheKey(walletId string) string {
+	return fmt.Sprintf("anomaly-trust-%s", walletId)
+}
+
+func anomalyPauseDuration() time.Duration {
+	if minutes := internal.Configuration.Anomaly.PauseDuration; minutes > 0 {
+		return time.Duration(minutes) * time.Minute
+	}
+	return anomalyDefaultPause
+}
+
+func (bot *TipBot) walletActivity(walletId string) WalletActivity {
+	if activity, err := bot.Cache.Get(walletActivityCacheKey(walletId)); err == nil {
+		return activity.(WalletActivity)
+	}
+	return WalletActivity{}
+}
+
+// paymentDestination is what the anomaly checks tell the destinations of payments apart by: the
+// receiving wallet of an internal transfer or else the node of the invoice.
+func paymentDestination(recipient string, bolt11 decodepay.Bolt11) string {
+	if len(recipient) > 0 {
+		return recipient
+	}
+	return bolt11.Payee
+}
+
+// recordWalletActivity is called after every outgoing payment and remembers it for the checks
+// of checkAnomaly. Payments of confirmed batches are not remembered.
+func (bot *TipBot) recordWalletActivity(payment lnbits.OutgoingPayment) {
+	if bot.Cache.StoreInterface == nil || payment.Batch {
+		return
+	}
+	bolt11, err := decodepay.Decodepay(payment.Bolt11)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	key := walletActivityCacheKey(payment.WalletID)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	activity := bot.walletActivity(payment.WalletID)
+	updated := WalletActivity{Destinations: map[string]time.Time{}}
+	for _, p := range activity.Payments {
+		if now.Sub(p.Time) < anomalyHistory {
+			updated.Payments = append(updated.Payments, p)
+		}
+	}
+	for destination, first := range activity.Destinations {
+		if now.Sub(first) < anomalyDestinationMemory {
+			updated.Destinations[destination] = first
+		}
+	}
+	destination := paymentDestination(payment.Recipient, bolt11)
+	updated.Payments = append(updated.Payments, WalletPayment{Time: now, Amount: bolt11.MSatoshi / 1000, Destination: destination})
+	if _, ok := updated.Destinations[destination]; !ok {
+		updated.Destinations[destination] = now
+	}
+	bot.Cache.Set(key, updated, &store.Options{Expiration: anomalyDestinationMemory})
+}
+
+// detectAnomaly returns what is suspicious about a payment of amount to destination by a wallet
+// that holds balance, an empty string if nothing is. The destination of internal transfers is
+// the receiving wallet, see paymentDestination.
+func detectAnomaly(activity WalletActivity, balance, amount int64, destination string, now time.Time, config internal.AnomalyConfiguration) string {
+	var sent int64
+	recent, earlier, toDestination := 0, 0, 0
+	for _, p := range activity.Payments {
+		if now.Sub(p.Time) >= anomalyHistory {
+			continue
+		}
+		if now.Sub(p.Time) >= anomalyWindow {
+			earlier++
+			continue
+		}
+		recent++
+		sent += p.Amount
+		if p.Destination == destination {
+			toDestination++
+		}
+	}
+	// the balance of an hour ago, without what the wallet received since
+	if config.DrainRatio > 0 && sent+amount >= config.DrainMin && float64(sent+amount) > config.DrainRatio*float64(balance+sent) {
+		return AnomalyDrain
+	}
+	first, known := activity.Destinations[destination]
+	fresh := !known || now.Sub(first) < anomalyFreshDestination
+	if config.FreshDestinationSends > 0 && fresh && toDestination+1 > config.FreshDestinationSends {
+		return AnomalyFreshDestination
+	}
+	if config.VelocityMin > 0 && recent+1 >= config.VelocityMin {
+		// the payments per hour of the rest of the day
+		baseline := float64(earlier) / float64((anomalyHistory-anomalyWindow)/anomalyWindow)
+		if float64(recent+1) > config.VelocityFactor*baseline {
+			return AnomalyVelocity
+		}
+	}
+	return ""
+}
+
+// activeWalletPause returns the pause of the wallet if it is paused.
+func (bot *TipBot) activeWalletPause(walletId string) *WalletPause {
+	pause := &WalletPause{}
+	tx := bot.DB.Users.Where("wallet_id = ? AND resolved_by = ? AND (until > ? OR reported = ?)", walletId, "", time.Now(), true).
+		Order("id desc").First(pause)
+	if tx.Error != nil {
+		return nil
+	}
+	return pause
+}
+
+// checkAnomaly is asked before every outgoing payment. It refuses the payments of paused wallets
+// and pauses wallets whose payment is suspicious. Payments of batches that the user confirmed,
+// like /payout and /rain, are not checked.
+func (bot *TipBot) checkAnomaly(w lnbits.Wallet, params lnbits.PaymentParams) error {
+	if bot.activeWalletPause(w.ID) != nil {
+		return lnbits.Error{Detail: walletPausedError}
+	}
+	if params.Batch {
+		return nil
+	}
+	config := internal.Configuration.Anomaly
+	if config.DrainRatio == 0 && config.FreshDestinationSends == 0 && config.VelocityMin == 0 {
+		return nil
+	}
+	if _, err := bot.Cache.Get(anomalyTrustCacheKey(w.ID)); err == nil {
+		return nil
+	}
+	bolt11, err := decodepay.Decodepay(params.Bolt11)
+	if err != nil {
+		// the backend rejects it
+		return nil
+	}
+	wallet, err := bot.Client.Balance(w)
+	if err != nil {
+		return nil
+	}
+	amount := bolt11.MSatoshi / 1000
+	reason := detectAnomaly(bot.walletActivity(w.ID), wallet.Balance/1000, amount, paymentDestination(params.Recipient, bolt11), time.Now(), config)
+	if len(reason) == 0 {
+		return nil
+	}
+	user := &lnbits.User{}
+	if tx := bot.DB.Users.Where("wallet_id = ?", w.ID).First(user); tx.Error != nil || user.Telegram == nil {
+		return nil
+	}
+	// the wallets of the bot and the operator hold escrow and pay fees and bonuses
+	if bot.isBotOwnedUser(user.Telegram.ID) {
+		return nil
+	}
+	bot.pauseWallet(user, reason, amount)
+	return lnbits.Error{Detail: walletPausedError}
+}
+
+// pauseWallet pauses the wallet of the user, asks the user whether the payments were theirs
+// and alerts the admin chat.
+func (bot *TipBot) pauseWallet(user *lnbits.User, reason string, amount int64) {
+	key := fmt.Sprintf("wallet-pause:%s", user.Wallet.ID)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	if bot.activeWalletPause(user.Wallet.ID) != nil {
+		return
+	}
+	pause := &WalletPause{CreatedAt: time.Now(), UserId: user.Telegram.ID, WalletId: user.Wallet.ID, Reason: reason, Amount: amount,
+		Until: time.Now().Add(anomalyPauseDuration())}
+	if tx := bot.DB.Users.Create(pause); tx.Error != nil {
+		log.Errorf("[pauseWallet] could not pause the wallet of %s: %v", GetUserStr(user.Telegram), tx.Error)
+		return
+	}
+	log.Warnf("[pauseWallet] paused the wallet of %s: %s", GetUserStr(user.Telegram), reason)
+	bot.Audit("anomaly detection", AuditActionWalletPause, auditActor(user.Telegram), fmt.Sprintf("%s, payment of %d sat", reason, amount))
+	language := user.Telegram.LanguageCode
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	menu.Inline(menu.Row(
+		menu.Data(i18n.Translate(language, "anomalyConfirmButtonMessage"), btnConfirmAnomaly.Unique),
+		menu.Data(i18n.Translate(language, "anomalyReportButtonMessage"), btnReportAnomaly.Unique)))
+	message := bot.trySendMessage(user.Telegram, fmt.Sprintf(i18n.Translate(language, "anomalyPausedMessage"),
+		i18n.Translate(language, anomalyReasonKey(reason)), amount, pause.Until.UTC().Format(dropTimeLayout)), menu)
+	if message != nil {
+		bot.DB.Users.Model(pause).Update("message_id", message.ID)
+	}
+	bot.alertAdminChat(fmt.Sprintf(i18n.Translate(anomalyAdminLanguage, "anomalyAdminPausedMessage"), str.MarkdownEscape(GetUserStr(user.Telegram)),
+		i18n.Translate(anomalyAdminLanguage, anomalyReasonKey(reason)), amount))
+}
+
+// anomalyReasonKey is the translation of a reason for a pause.
+func anomalyReasonKey(reason string) string {
+	switch reason {
+	case AnomalyDrain:
+		return "anomalyDrainMessage"
+	case AnomalyFreshDestination:
+		return "anomalyFreshDestinationMessage"
+	default:
+		return "anomalyVelocityMessage"
+	}
+}
+
+// resolveWalletPause lifts a pause. The checks skip the wallet for a while, so that the
+// payment that was stopped can be made again.
+func (bot *TipBot) resolveWalletPause(pause *WalletPause, by string) {
+	pause.ResolvedBy, pause.ResolvedAt = by, time.Now()
+	if tx := bot.DB.Users.Save(pause); tx.Error != nil {
+		log.Errorf("[resolveWalletPause] could not save pause %d: %v", pause.ID, tx.Error)
+	}
+	bot.Cache.Set(anomalyTrustCacheKey(pause.WalletId), true, &store.Options{Expiration: anomalyTrustDuration})
+	bot.Audit(by, AuditActionWalletUnpause, fmt.Sprint(pause.UserId), pause.Reason)
+}
+
+// confirmAnomalyHandler is invoked when a user confirms that they made the payments that paused
+// their wallet.
+func (bot *TipBot) confirmAnomalyHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	pause := bot.activeWalletPause(user.Wallet.ID)
+	if pause == nil {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if pause.Reported {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "anomalyReportedMessage"))
+		return ctx, fmt.Errorf("the pause of %s was reported", GetUserStr(user.Telegram))
+	}
+	bot.resolveWalletPause(pause, auditActor(user.Telegram))
+	bot.tryEditMessage(ctx.Callback().Message, Translate(ctx, "anomalyConfirmedMessage"), &tb.ReplyMarkup{})
+	bot.alertAdminChat(fmt.Sprintf(i18n.Translate(anomalyAdminLanguage, "anomalyAdminResolvedMessage"), str.MarkdownEscape(GetUserStr(user.Telegram)),
+		str.MarkdownEscape(GetUserStr(user.Telegram))))
+	return ctx, nil
+}
+
+// reportAnomalyHandler is invoked when a user did not make the payments that paused their
+// wallet. The wallet stays paused until support lifts the pause.
+func (bot *TipBot) reportAnomalyHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	pause := bot.activeWalletPause(user.Wallet.ID)
+	if pause == nil {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	pause.Reported, pause.Until = true, time.Now().Add(anomalyReportedPause)
+	if tx := bot.DB.Users.Save(pause); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	bot.Audit(auditActor(user.Telegram), AuditActionWalletPause, auditActor(user.Telegram), "reported: "+pause.Reason)
+	bot.tryEditMessage(ctx.Callback().Message, Translate(ctx, "anomalyReportedMessage"), &tb.ReplyMarkup{})
+	bot.alertAdminChat(fmt.Sprintf(i18n.Translate(anomalyAdminLanguage, "anomalyAdminReportedMessage"), str.MarkdownEscape(GetUserStr(user.Telegram))))
+	return ctx, nil
+}
+
+// adminUnpauseHandler is invoked on /admin unpause <@user> and lifts the pause of a wallet.
+func (bot *TipBot) adminUnpauseHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	splits := strings.Fields(m.Text)
+	if len(splits) < 3 {
+		bot.trySendMessage(m.Sender, bot.adminHelp(operatorRole(m.Sender.ID)))
+		return ctx, fmt.Errorf("no user given")
+	}
+	user, err := GetUserByTelegramUsername(strings.TrimPrefix(splits[2], "@"), *bot)
+	if err != nil || user.Telegram == nil || user.Wallet == nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(adminLookupNotFoundMessage, str.MarkdownEscape(splits[2])))
+		return ctx, fmt.Errorf("user %s not found", splits[2])
+	}
+	pause := bot.activeWalletPause(user.Wallet.ID)
+	if pause == nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "anomalyAdminNotPausedMessage"), str.MarkdownEscape(GetUserStr(user.Telegram))))
+		return ctx, nil
+	}
+	bot.resolveWalletPause(pause, auditActor(m.Sender))
+	if pause.MessageId != 0 {
+		bot.tryEditMessage(&tb.StoredMessage{MessageID: fmt.Sprint(pause.MessageId), ChatID: user.Telegram.ID},
+			i18n.Translate(user.Telegram.LanguageCode, "anomalyConfirmedMessage"), &tb.ReplyMarkup{})
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "anomalyAdminUnpausedMessage"), str.MarkdownEscape(GetUserStr(user.Telegram))))
+	bot.alertAdminChat(fmt.Sprintf(i18n.Translate(anomalyAdminLanguage, "anomalyAdminResolvedMessage"), str.MarkdownEscape(GetUserStr(m.Sender)),
+		str.MarkdownEscape(GetUserStr(user.Telegram))))
+	return ctx, nil
+}
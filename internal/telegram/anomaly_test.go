@@ -0,0 +1,166 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestDetectAnomaly(t *testing.T) {
+	now := time.Now()
+	config := internal.AnomalyConfiguration{DrainRatio: 0.8, DrainMin: 1000, FreshDestinationSends: 3, VelocityMin: 10, VelocityFactor: 5}
+	payments := func(count int, age time.Duration, amount int64, destination string) []WalletPayment {
+		list := make([]WalletPayment, count)
+		for i := range list {
+			list[i] = WalletPayment{Time: now.Add(-age), Amount: amount, Destination: destination}
+		}
+		return list
+	}
+	old := map[string]time.Time{"node": now.Add(-7 * 24 * time.Hour)}
+	fresh := map[string]time.Time{"node": now.Add(-time.Hour / 2)}
+	for _, test := range []struct {
+		name     string
+		activity WalletActivity
+		balance  int64
+		amount   int64
+		want     string
+	}{
+		{"nothing", WalletActivity{Destinations: old}, 10000, 100, ""},
+		{"small drain", WalletActivity{Destinations: old}, 500, 500, ""},
+		{"drain", WalletActivity{Payments: payments(1, time.Minute, 5000, "node"), Destinations: old}, 5000, 4000, AnomalyDrain},
+		{"fresh destination", WalletActivity{Payments: payments(3, time.Minute, 10, "node"), Destinations: fresh}, 10000, 10, AnomalyFreshDestination},
+		{"known destination", WalletActivity{Payments: payments(3, time.Minute, 10, "node"), Destinations: old}, 10000, 10, ""},
+		{"spike", WalletActivity{Payments: payments(9, time.Minute, 10, "node"), Destinations: old}, 10000, 10, AnomalyVelocity},
+		{"busy day", WalletActivity{Payments: append(payments(9, time.Minute, 10, "node"), payments(46, 2*time.Hour, 10, "node")...), Destinations: old}, 10000, 10, ""},
+	} {
+		if got := detectAnomaly(test.activity, test.balance, test.amount, "node", now, config); got != test.want {
+			t.Errorf("%s: anomaly = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWalletPause(t *testing.T) {
+	h := newTestHarness(t)
+	guard := lnbits.NewPaymentGuard(h.bot.Client)
+	guard.BeforePayment(h.bot.checkAnomaly)
+	payments := lnbits.NewPaymentObserver(guard)
+	payments.OnPayment(h.bot.recordWalletActivity)
+	h.bot.Client = payments
+	config, bot := internal.Configuration.Anomaly, internal.Configuration.Bot
+	t.Cleanup(func() { internal.Configuration.Anomaly, internal.Configuration.Bot = config, bot })
+	internal.Configuration.Anomaly = internal.AnomalyConfiguration{VelocityMin: 3}
+	support := &tb.User{ID: 9941, Username: "support", FirstName: "Support", LanguageCode: "en"}
+	alice := &tb.User{ID: 9942, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9943, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	h.newUser(support, 0)
+	from := h.newUser(alice, 1000)
+	to := h.newUser(bob, 0)
+	internal.Configuration.Bot.OperatorId = support.ID
+
+	pay := func() error {
+		invoice, err := h.bot.Client.CreateInvoice(*to.Wallet, lnbits.InvoiceParams{Amount: 10, Memo: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = h.bot.Client.Pay(*from.Wallet, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest})
+		h.sent = append(h.sent, h.telegram.Requests()...)
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if err := pay(); err != nil {
+			t.Fatalf("payment %d: %v", i+1, err)
+		}
+	}
+	// the third payment within an hour is a spike
+	if err := pay(); err == nil || !strings.Contains(err.Error(), "paused") {
+		t.Fatalf("err = %v, want a paused wallet", err)
+	}
+	if text := h.lastMessage(support.ID).Text(); !strings.Contains(text, "Wallet paused") || !strings.Contains(text, "@alice") {
+		t.Errorf("alert = %q", text)
+	}
+	if err := pay(); err == nil {
+		t.Fatal("paid from a paused wallet")
+	}
+	h.pressButton(alice, h.lastMessage(alice.ID), "✅ It was me")
+	if err := pay(); err != nil {
+		t.Fatalf("payment after the confirmation: %v", err)
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 30 {
+		t.Errorf("balance of bob = %d, want 30", balance)
+	}
+
+	// a reported pause lasts until support lifts it
+	h.bot.Cache.Delete(anomalyTrustCacheKey(from.Wallet.ID))
+	if err := pay(); err == nil {
+		t.Fatal("no pause after the trust ran out")
+	}
+	h.pressButton(alice, h.lastMessage(alice.ID), "🚨 It wasn't me")
+	if text := h.lastMessage(support.ID).Text(); !strings.Contains(text, "did not make the payments") {
+		t.Errorf("alert = %q", text)
+	}
+	if pause := h.bot.activeWalletPause(from.Wallet.ID); pause == nil || !pause.Reported {
+		t.Fatalf("pause = %+v, want a reported pause", pause)
+	}
+	h.sendMessage(support, privateChat(support), "/admin unpause @alice")
+	if text := h.lastMessage(support.ID).Text(); !strings.Contains(text, "lifted the pause") {
+		t.Errorf("message = %q", text)
+	}
+	if err := pay(); err != nil {
+		t.Errorf("payment after the pause was lifted: %v", err)
+	}
+}
+
+func TestAnomalyInternalTransfers(t *testing.T) {
+	h := newTestHarness(t)
+	guard := lnbits.NewPaymentGuard(h.bot.Client)
+	guard.BeforePayment(h.bot.checkAnomaly)
+	payments := lnbits.NewPaymentObserver(guard)
+	payments.OnPayment(h.bot.recordWalletActivity)
+	h.bot.Client = payments
+	config := internal.Configuration.Anomaly
+	t.Cleanup(func() { internal.Configuration.Anomaly = config })
+	internal.Configuration.Anomaly = internal.AnomalyConfiguration{FreshDestinationSends: 2}
+	alice := &tb.User{ID: 9942, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9943, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	carol := &tb.User{ID: 9944, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	dave := &tb.User{ID: 9945, Username: "dave", FirstName: "Dave", LanguageCode: "en"}
+	escrow := h.newUser(testBotUser, 1000)
+	from := h.newUser(alice, 1000)
+	toBob := h.newUser(bob, 0)
+	toCarol := h.newUser(carol, 0)
+	toDave := h.newUser(dave, 0)
+
+	send := func(from, to *lnbits.User, opts ...TransactionOption) error {
+		_, err := NewTransaction(h.bot, from, to, 10, opts...).Send()
+		h.sent = append(h.sent, h.telegram.Requests()...)
+		return err
+	}
+	// transfers to different users are different destinations
+	for _, to := range []*lnbits.User{toBob, toCarol, toDave} {
+		if err := send(from, to); err != nil {
+			t.Fatalf("transfer to %s: %v", GetUserStr(to.Telegram), err)
+		}
+	}
+	// a confirmed batch is not checked
+	for i := 0; i < 3; i++ {
+		if err := send(from, toBob, TransactionBatch()); err != nil {
+			t.Fatalf("batch transfer %d: %v", i+1, err)
+		}
+	}
+	// the escrow wallet of the bot is never paused
+	for i := 0; i < 3; i++ {
+		if err := send(escrow, toCarol); err != nil {
+			t.Fatalf("escrow transfer %d: %v", i+1, err)
+		}
+	}
+	if err := send(from, toBob); err != nil {
+		t.Fatalf("second transfer to bob: %v", err)
+	}
+	if err := send(from, toBob); err == nil || !strings.Contains(err.Error(), "paused") {
+		t.Errorf("err = %v, want a paused wallet after three transfers to bob", err)
+	}
+}
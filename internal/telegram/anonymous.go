@@ -0,0 +1,121 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// postedAsChat returns true if m was posted on behalf of a chat: by an anonymous admin "as the
+// group" or by a user as their channel. The sender of such messages is a placeholder account
+// of Telegram, not the person who wrote them. Posts that Telegram copies from a channel into
+// its comments are handled as channel posts, see channelPost.
+func postedAsChat(m *tb.Message) bool {
+	return m != nil && m.SenderChat != nil && !m.AutomaticForward
+}
+
+// postedAsGroup returns true if m was posted by an anonymous admin of the group.
+func postedAsGroup(m *tb.Message) bool {
+	return postedAsChat(m) && m.Chat != nil && m.SenderChat.ID == m.Chat.ID
+}
+
+// senderIsAdmin returns true if the sender of m is an admin of the group. Only admins can post
+// as the group.
+func (bot *TipBot) senderIsAdmin(m *tb.Message) bool {
+	return postedAsGroup(m) || bot.isAdmin(m.Chat, m.Sender)
+}
+
+// senderChatInterceptor stops commands that were posted as the group or as a channel, the bot
+// doesn't know whose wallet to use. /groupsettings works for anonymous admins.
+func (bot TipBot) senderChatInterceptor(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if !postedAsChat(m) || len(m.Text) == 0 {
+		return ctx, nil
+	}
+	command := bot.commandName(m.Text)
+	if command == "" || command == "groupsettings" {
+		return ctx, nil
+	}
+	// the localizer didn't run yet
+	languageCode := bot.getGroupLanguageCode(m.Chat)
+	if len(languageCode) == 0 {
+		languageCode = "en"
+	}
+	message := fmt.Sprintf(i18n.Translate(languageCode, "anonymousSenderMessage"), command)
+	bot.trySendMessage(bot.topicOf(m), message)
+	return ctx, fmt.Errorf("[senderChatInterceptor] /%s posted as %d in %d", command, m.SenderChat.ID, m.Chat.ID)
+}
+
+// senderChatTipRecipient returns who receives tips on a message that was posted on behalf of a
+// chat: the group wallet for messages of anonymous admins, the channel's wallet for messages
+// posted as a channel. If nobody can receive the tip, the sender of the tip is told why.
+func (bot *TipBot) senderChatTipRecipient(ctx intercept.Context, tipped *tb.Message) (*lnbits.User, error) {
+	m := ctx.Message()
+	if postedAsGroup(tipped) {
+		settings := bot.getGroupSettings(tipped.Chat.ID)
+		if settings.WalletUserId == 0 {
+			bot.trySendMessage(m.Sender, Translate(ctx, "anonymousNoGroupWalletMessage"))
+			return nil, fmt.Errorf("group %d has no group wallet", tipped.Chat.ID)
+		}
+		return GetLnbitsUser(&tb.User{ID: settings.WalletUserId}, *bot)
+	}
+	if tipped.SenderChat.Type == tb.ChatChannel {
+		channel, err := bot.getChannel(tipped.SenderChat.ID)
+		if err != nil {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "channelNotTippableMessage"), str.MarkdownEscape(tipped.SenderChat.Title)))
+			return nil, fmt.Errorf("channel %d is not linked", tipped.SenderChat.ID)
+		}
+		return bot.channelTipRecipient(channel, tipped)
+	}
+	// messages posted as another group, like linked chats
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "anonymousNotTippableMessage"), str.MarkdownEscape(tipped.SenderChat.Title)))
+	return nil, fmt.Errorf("cannot tip messages posted as chat %d", tipped.SenderChat.ID)
+}
+
+// groupSettingsWalletHandler sets the member whose wallet receives the tips to the group, like
+// the tips on messages of anonymous admins: /groupsettings wallet @user|off.
+func (bot *TipBot) groupSettingsWalletHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	value, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil || (strings.ToLower(value) != "off" && !strings.HasPrefix(value, "@")) {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsHelpMessage"), strings.Join(i18n.LoadedLanguages(), ", ")))
+		return ctx, fmt.Errorf("invalid wallet setting")
+	}
+	settings := bot.getGroupSettings(m.Chat.ID)
+	message := Translate(ctx, "groupSettingsWalletDisabledMessage")
+	if strings.ToLower(value) == "off" {
+		settings.WalletUserId = 0
+	} else {
+		user, err := GetUserByTelegramUsername(strings.TrimPrefix(value, "@"), *bot)
+		if err != nil {
+			bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape(value)))
+			return ctx, err
+		}
+		settings.WalletUserId = user.Telegram.ID
+		message = fmt.Sprintf(Translate(ctx, "groupSettingsWalletEnabledMessage"), GetUserStrMd(user.Telegram))
+	}
+	if err := bot.saveGroupSettings(settings); err != nil {
+		log.Errorf("[groupSettingsWalletHandler] could not save settings of group %d: %v", m.Chat.ID, err)
+		return ctx, err
+	}
+	bot.trySendMessage(bot.topicOf(m), message)
+	return ctx, nil
+}
+
+// groupWalletStr names the group wallet for the group settings.
+func (bot *TipBot) groupWalletStr(settings *GroupSettings) string {
+	if settings.WalletUserId == 0 {
+		return "-"
+	}
+	user, err := GetLnbitsUser(&tb.User{ID: settings.WalletUserId}, *bot)
+	if err != nil || user.Telegram == nil {
+		return "-"
+	}
+	return GetUserStrMd(user.Telegram)
+}
@@ -0,0 +1,44 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestAnonymousAdmins(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9961, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9962, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	aliceUser := h.newUser(alice, 0)
+	h.newUser(bob, 1000)
+	group := &tb.Chat{ID: -9960, Type: tb.ChatSuperGroup, Title: "group"}
+	// Telegram sends messages of anonymous admins from a placeholder account
+	anonymous := &tb.User{ID: 1087968824, Username: "GroupAnonymousBot", FirstName: "Group", IsBot: true}
+	post := &tb.Message{ID: 601, Chat: group, Sender: anonymous, SenderChat: group, Text: "gm"}
+
+	// without a group wallet, messages of anonymous admins can't be tipped
+	h.sendReply(bob, group, "/tip 10", post)
+	if text := h.lastMessage(bob.ID).Text(); !strings.Contains(text, "anonymous admin") {
+		t.Fatalf("tip without group wallet: %q", text)
+	}
+
+	// anonymous admins can't tip, they don't have a wallet
+	h.process(tb.Update{Message: &tb.Message{ID: 602, Chat: group, Sender: anonymous, SenderChat: group, Text: "/tip 10", ReplyTo: post,
+		Entities: []tb.MessageEntity{{Type: tb.EntityCommand, Length: 4}}}})
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "Post as yourself") {
+		t.Errorf("tip of an anonymous admin: %q", text)
+	}
+
+	// anonymous admins can set the group wallet
+	h.process(tb.Update{Message: &tb.Message{ID: 603, Chat: group, Sender: anonymous, SenderChat: group, Text: "/groupsettings wallet @alice",
+		Entities: []tb.MessageEntity{{Type: tb.EntityCommand, Length: 14}}}})
+	if settings := h.bot.getGroupSettings(group.ID); settings.WalletUserId != alice.ID {
+		t.Fatalf("group wallet = %d, want %d", settings.WalletUserId, alice.ID)
+	}
+	h.sendReply(bob, group, "/tip 10", post)
+	if balance := h.lnbits.Balance(aliceUser.Wallet.ID); balance != 10 {
+		t.Errorf("balance of the group wallet = %d, want 10", balance)
+	}
+}
@@ -0,0 +1,130 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+	"gorm.io/gorm"
+)
+
+const (
+	AuditActionAdminCommand       = "admin command"
+	AuditActionAdminDenied        = "admin command denied"
+	AuditActionConfigReload       = "config reload"
+	AuditActionTranslationsReload = "translations reload"
+	AuditActionFeatureToggle      = "feature toggle"
+	AuditActionFreeze             = "wallet freeze"
+	AuditActionUnfreeze           = "wallet unfreeze"
+	AuditActionAddressNameReview  = "address name review"
+	AuditActionBalanceAdjustment  = "balance adjustment"
+)
+
+const (
+	auditLogLength        = 20
+	auditLogMaxLength     = 100
+	auditDetailsMaxLength = 200
+)
+
+var (
+	adminAuditMessage      = "*Audit log*"
+	adminAuditEntryMessage = "\n`%s` %s: %s"
+	adminAuditEmptyMessage = "The audit log is empty."
+)
+
+var errAuditAppendOnly = errors.New("the audit log is append-only")
+
+// AuditEntry records a privileged or sensitive operation. Entries are never changed or
+// deleted, the hooks below refuse updates and deletes through gorm.
+type AuditEntry struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+	// Actor is who did it: a Telegram user, the admin API with its remote address or a signal
+	Actor   string `json:"actor"`
+	Action  string `json:"action" gorm:"index"`
+	Target  string `json:"target"`
+	Details string `json:"details"`
+}
+
+func (AuditEntry) BeforeUpdate(*gorm.DB) error { return errAuditAppendOnly }
+func (AuditEntry) BeforeDelete(*gorm.DB) error { return errAuditAppendOnly }
+
+// auditActor names a Telegram user in the audit log by username and id, usernames change.
+func auditActor(user *tb.User) string {
+	return fmt.Sprintf("%s (%d)", GetUserStr(user), user.ID)
+}
+
+// Audit appends an entry to the audit log. Failures are logged, they don't stop the operation.
+func (bot *TipBot) Audit(actor, action, target, details string) {
+	if runes := []rune(details); len(runes) > auditDetailsMaxLength {
+		details = string(runes[:auditDetailsMaxLength])
+	}
+	entry := &AuditEntry{CreatedAt: time.Now(), Actor: actor, Action: action, Target: target, Details: details}
+	if tx := bot.DB.Users.Create(entry); tx.Error != nil {
+		log.Errorf("[Audit] could not record %s of %s: %v", action, actor, tx.Error)
+		return
+	}
+	log.Infof("[Audit] %s: %s %s %s", actor, action, target, details)
+}
+
+// errorDetails is the details of an audit entry of an operation that returned err.
+func errorDetails(err error) string {
+	if err != nil {
+		return "failed: " + err.Error()
+	}
+	return ""
+}
+
+// AuditLog returns the last entries of the audit log, the most recent first.
+func (bot *TipBot) AuditLog(limit int) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	tx := bot.DB.Users.Order("id desc").Limit(limit).Find(&entries)
+	return entries, tx.Error
+}
+
+func (e AuditEntry) String() string {
+	text := e.Action
+	if len(e.Target) > 0 {
+		text += " " + e.Target
+	}
+	if len(e.Details) > 0 {
+		text += " (" + e.Details + ")"
+	}
+	return fmt.Sprintf(adminAuditEntryMessage, e.CreatedAt.UTC().Format("2006-01-02 15:04"), str.MarkdownEscape(e.Actor), str.MarkdownEscape(text))
+}
+
+// adminAuditHandler is invoked on /admin audit [count] and sends the last entries of the
+// audit log to the operator.
+func (bot *TipBot) adminAuditHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	limit := auditLogLength
+	if splits := strings.Fields(m.Text); len(splits) > 2 {
+		if n, err := strconv.Atoi(splits[2]); err == nil && n > 0 {
+			limit = n
+		}
+	}
+	if limit > auditLogMaxLength {
+		limit = auditLogMaxLength
+	}
+	entries, err := bot.AuditLog(limit)
+	if err != nil {
+		return ctx, err
+	}
+	if len(entries) == 0 {
+		bot.trySendMessage(m.Sender, adminAuditEmptyMessage)
+		return ctx, nil
+	}
+	var b strings.Builder
+	b.WriteString(adminAuditMessage)
+	for _, entry := range entries {
+		b.WriteString(entry.String())
+	}
+	bot.trySendMessage(m.Sender, b.String())
+	return ctx, nil
+}
@@ -0,0 +1,42 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestAuditLog(t *testing.T) {
+	h := newTestHarness(t)
+	operatorUser := &tb.User{ID: 9801, Username: "operator", FirstName: "Operator", LanguageCode: "en"}
+	mallory := &tb.User{ID: 9802, Username: "mallory", FirstName: "Mallory", LanguageCode: "en"}
+	h.newUser(operatorUser, 0)
+	h.newUser(mallory, 0)
+	operatorId := internal.Configuration.Bot.OperatorId
+	t.Cleanup(func() { internal.Configuration.Bot.OperatorId = operatorId })
+	internal.Configuration.Bot.OperatorId = operatorUser.ID
+
+	h.sendMessage(mallory, privateChat(mallory), "/admin stats")
+	h.sendMessage(operatorUser, privateChat(operatorUser), "/admin claims")
+	h.sendMessage(operatorUser, privateChat(operatorUser), "/admin audit")
+	text := h.lastMessage(operatorUser.ID).Text()
+	for _, want := range []string{"admin command denied", "@mallory (9802)", "/admin claims", "/admin audit"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("audit log does not contain %q: %q", want, text)
+		}
+	}
+
+	// entries can't be changed or deleted
+	entries, err := h.bot.AuditLog(1)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("entries = %v, %v", entries, err)
+	}
+	if tx := h.bot.DB.Users.Model(&entries[0]).Update("actor", "nobody"); tx.Error == nil {
+		t.Error("updated an audit entry")
+	}
+	if tx := h.bot.DB.Users.Delete(&entries[0]); tx.Error == nil {
+		t.Error("deleted an audit entry")
+	}
+}
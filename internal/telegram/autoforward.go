@@ -0,0 +1,188 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/LightningTipBot/LightningTipBot/pkg/lightning"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// autoForwardDelay lets a wallet settle after it received funds before they are forwarded.
+	autoForwardDelay = 5 * time.Second
+	// autoForwardMinAmount is the smallest amount in sat that is forwarded, smaller amounts
+	// above the threshold wait for the next payment.
+	autoForwardMinAmount = 1000
+	autoForwardLogLength = 10
+)
+
+// Forward is a payment that swept the balance of a user above the threshold of their
+// auto-forward to their external wallet. Error is set if the payment failed.
+type Forward struct {
+	ID          uint      `gorm:"primarykey"`
+	CreatedAt   time.Time `json:"created_at"`
+	UserId      int64     `json:"user_id" gorm:"index"`
+	Address     string    `json:"address"`
+	Amount      int64     `json:"amount"`
+	PaymentHash string    `json:"payment_hash"`
+	Error       string    `json:"error"`
+}
+
+func autoForwardMutexKey(userId int64) string {
+	return fmt.Sprintf("autoforward:%d", userId)
+}
+
+// isBotAddress returns true if the lightning address belongs to this bot. Forwarding to
+// it would pay the funds back into a wallet of the bot.
+func isBotAddress(address string) bool {
+	hostUrl := internal.Configuration.Bot.LNURLHostUrl
+	if hostUrl == nil {
+		return false
+	}
+	return strings.HasSuffix(strings.ToLower(address), "@"+strings.ToLower(hostUrl.Hostname()))
+}
+
+// scheduleAutoForward forwards the balance of the user above the threshold of their
+// auto-forward, shortly after they received funds.
+func (bot *TipBot) scheduleAutoForward(user *lnbits.User) {
+	if user == nil || user.Telegram == nil {
+		return
+	}
+	telegramUser := user.Telegram
+	time.AfterFunc(autoForwardDelay, func() {
+		bot.autoForward(telegramUser)
+	})
+}
+
+// autoForward pays the balance of the user above the threshold to their lightning address.
+func (bot *TipBot) autoForward(telegramUser *tb.User) {
+	key := autoForwardMutexKey(telegramUser.ID)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	user, err := GetLnbitsUserWithSettings(telegramUser, *bot)
+	if err != nil || user.Wallet == nil {
+		return
+	}
+	settings := user.Settings.AutoForward
+	if len(settings.Address) == 0 {
+		return
+	}
+	balance, err := bot.GetUserBalance(user)
+	if err != nil {
+		return
+	}
+	// the routing fee is paid from the wallet too
+	amount := balance - settings.Threshold
+	amount -= estimateRoutingFee(amount)
+	if amount < autoForwardMinAmount {
+		return
+	}
+	forward := &Forward{UserId: telegramUser.ID, Address: settings.Address, Amount: amount, CreatedAt: time.Now()}
+	forward.PaymentHash, err = bot.payLightningAddress(user, settings.Address, amount, "")
+	if err != nil {
+		forward.Error = err.Error()
+	}
+	// failures are only reported once, not after every payment that the user receives
+	last := &Forward{}
+	lastFailed := bot.DB.Transactions.Where("user_id = ?", telegramUser.ID).Order("id desc").First(last).Error == nil && len(last.Error) > 0
+	if tx := bot.DB.Transactions.Create(forward); tx.Error != nil {
+		log.Errorf("[autoForward] could not log forward of %s: %v", GetUserStr(telegramUser), tx.Error)
+	}
+	if err != nil {
+		log.Warnf("[autoForward] could not forward %d sat of %s to %s: %v", amount, GetUserStr(telegramUser), settings.Address, err)
+		if !lastFailed {
+			bot.notify(user, NotificationPayment, amount, fmt.Sprintf(i18n.Translate(user.Telegram.LanguageCode, "autoForwardFailedMessage"), amount, str.MarkdownEscape(settings.Address), str.MarkdownEscape(err.Error())))
+		}
+		return
+	}
+	bot.saveCounterparty(forward.PaymentHash, user.Wallet.ID, CounterpartyTypeLightningAddress, settings.Address)
+	log.Infof("[autoForward] forwarded %d sat of %s to %s", amount, GetUserStr(telegramUser), settings.Address)
+	bot.notify(user, NotificationPayment, amount, fmt.Sprintf(i18n.Translate(user.Telegram.LanguageCode, "autoForwardedMessage"), amount, str.MarkdownEscape(settings.Address), settings.Threshold))
+}
+
+// autoForwardHandler is invoked on /autoforward <address> above <threshold>, /autoforward off
+// and /autoforward log.
+func (bot *TipBot) autoForwardHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	splits := strings.Fields(m.Text)
+	if len(splits) < 2 {
+		status := Translate(ctx, "autoForwardOffMessage")
+		if settings := user.Settings.AutoForward; len(settings.Address) > 0 {
+			status = fmt.Sprintf(Translate(ctx, "autoForwardOnMessage"), str.MarkdownEscape(settings.Address), settings.Threshold)
+		}
+		bot.trySendMessage(m.Sender, status+"\n\n"+Translate(ctx, "autoForwardHelpMessage"))
+		return ctx, nil
+	}
+	switch strings.ToLower(splits[1]) {
+	case "off":
+		user.Settings.AutoForward = lnbits.AutoForwardSettings{}
+		if err := UpdateUserRecord(user, *bot); err != nil {
+			return ctx, err
+		}
+		bot.trySendMessage(m.Sender, Translate(ctx, "autoForwardOffMessage"))
+		return ctx, nil
+	case "log":
+		return bot.autoForwardLogHandler(ctx)
+	}
+	// /autoforward <address> above <threshold>
+	if len(splits) != 4 || strings.ToLower(splits[2]) != "above" || !lightning.IsLightningAddress(splits[1]) {
+		bot.trySendMessage(m.Sender, Translate(ctx, "autoForwardHelpMessage"))
+		return ctx, fmt.Errorf("invalid autoforward command")
+	}
+	address := strings.ToLower(splits[1])
+	if isBotAddress(address) {
+		bot.trySendMessage(m.Sender, Translate(ctx, "autoForwardBotAddressMessage"))
+		return ctx, fmt.Errorf("cannot forward to %s", address)
+	}
+	threshold, err := GetAmount(splits[3])
+	if err != nil || threshold < 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "autoForwardHelpMessage"))
+		return ctx, fmt.Errorf("invalid autoforward threshold %s", splits[3])
+	}
+	user.Settings.AutoForward = lnbits.AutoForwardSettings{Address: address, Threshold: threshold}
+	if err := UpdateUserRecord(user, *bot); err != nil {
+		log.Errorf("[/autoforward] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	logger(ctx).Infof("[/autoforward] %s forwards above %d sat to %s", GetUserStr(user.Telegram), threshold, address)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "autoForwardOnMessage"), str.MarkdownEscape(address), threshold))
+	// the balance may be above the threshold already
+	bot.scheduleAutoForward(user)
+	return ctx, nil
+}
+
+// autoForwardLogHandler lists the last forwards of the user.
+func (bot *TipBot) autoForwardLogHandler(ctx intercept.Context) (intercept.Context, error) {
+	var forwards []Forward
+	tx := bot.DB.Transactions.Where("user_id = ?", ctx.Sender().ID).Order("id desc").Limit(autoForwardLogLength).Find(&forwards)
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	if len(forwards) == 0 {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "autoForwardNoLogMessage"))
+		return ctx, nil
+	}
+	message := Translate(ctx, "autoForwardLogMessage")
+	for _, forward := range forwards {
+		status := "✅"
+		if len(forward.Error) > 0 {
+			status = "🚫"
+		}
+		message += fmt.Sprintf("\n%s %s: %d sat to %s", status, forward.CreatedAt.UTC().Format("2 Jan 15:04"), forward.Amount, str.MarkdownEscape(forward.Address))
+	}
+	bot.trySendMessage(ctx.Sender(), message)
+	return ctx, nil
+}
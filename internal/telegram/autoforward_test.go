@@ -0,0 +1,44 @@
+package telegram
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestAutoForwardSettings(t *testing.T) {
+	h := newTestHarness(t)
+	hostUrl := internal.Configuration.Bot.LNURLHostUrl
+	t.Cleanup(func() { internal.Configuration.Bot.LNURLHostUrl = hostUrl })
+	internal.Configuration.Bot.LNURLHostUrl, _ = url.Parse("https://ln.tips")
+	alice := &tb.User{ID: 9991, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	h.newUser(alice, 0)
+
+	h.sendMessage(alice, privateChat(alice), "/autoforward alice@wallet.com above 50000")
+	user, err := GetLnbitsUserWithSettings(alice, *h.bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings := user.Settings.AutoForward; settings.Address != "alice@wallet.com" || settings.Threshold != 50000 {
+		t.Fatalf("settings = %+v", settings)
+	}
+
+	// forwarding to the bot itself is refused
+	h.sendMessage(alice, privateChat(alice), "/autoforward bob@ln.tips above 100")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "outside of the bot") {
+		t.Errorf("bot address: %q", text)
+	}
+
+	h.sendMessage(alice, privateChat(alice), "/autoforward off")
+	user, _ = GetLnbitsUserWithSettings(alice, *h.bot)
+	if len(user.Settings.AutoForward.Address) > 0 {
+		t.Errorf("auto-forward still on: %+v", user.Settings.AutoForward)
+	}
+	h.sendMessage(alice, privateChat(alice), "/autoforward log")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "Nothing was forwarded") {
+		t.Errorf("log = %q", text)
+	}
+}
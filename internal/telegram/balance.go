@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
 
 	log "github.com/sirupsen/logrus"
@@ -42,6 +43,37 @@ func (bot *TipBot) balanceHandler(ctx intercept.Context) (intercept.Context, err
 	}
 
 	log.Infof("[/balance] %s's balance: %d sat\n", usrStr, balance)
-	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "balanceMessage"), balance))
+	balanceMessage := fmt.Sprintf(Translate(ctx, "balanceMessage"), balance) + bot.fiatAmount(user, balance)
+
+	incoming, outgoing, err := bot.getPendingBalance(user)
+	if err != nil {
+		log.Warnf("[/balance] Error fetching %s's pending payments: %s", usrStr, err)
+	}
+	if incoming > 0 {
+		balanceMessage += fmt.Sprintf(Translate(ctx, "balancePendingIncomingMessage"), incoming)
+	}
+	if outgoing > 0 {
+		balanceMessage += fmt.Sprintf(Translate(ctx, "balancePendingOutgoingMessage"), outgoing)
+	}
+	if incoming > 0 || outgoing > 0 {
+		balanceMessage += Translate(ctx, "balancePendingDetailsMessage")
+	}
+	bot.trySendMessage(ctx.Sender(), balanceMessage)
 	return ctx, nil
 }
+
+// getPendingBalance returns the sum of unsettled incoming invoices that have not expired yet
+// and the sum of outgoing payments that are still in flight.
+func (bot *TipBot) getPendingBalance(user *lnbits.User) (incoming int64, outgoing int64, err error) {
+	pendingIncoming, pendingOutgoing, err := bot.getPendingPayments(user)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, p := range pendingIncoming {
+		incoming += p.Sat()
+	}
+	for _, p := range pendingOutgoing {
+		outgoing += p.Sat()
+	}
+	return incoming, outgoing, nil
+}
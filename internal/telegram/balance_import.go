@@ -0,0 +1,299 @@
+package telegram
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/eko/gocache/store"
+	gocache "github.com/patrickmn/go-cache"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+	"gorm.io/gorm"
+)
+
+// states of the entries in the report of a balance import
+const (
+	BalanceImportCredited = "credited"
+	BalanceImportPlanned  = "planned" // dry run
+	BalanceImportSkipped  = "skipped"
+	BalanceImportFailed   = "failed"
+)
+
+// BalanceImportEntry is the balance of a user in the export of another tip bot.
+type BalanceImportEntry struct {
+	TelegramId int64  `json:"telegram_id"`
+	Username   string `json:"username"`
+	Sats       int64  `json:"sats"`
+}
+
+// ImportedBalance is a balance that was credited by an import. Running an import again
+// only credits the balances that are not recorded yet.
+type ImportedBalance struct {
+	Source      string    `json:"source" gorm:"primaryKey"`
+	TelegramId  int64     `json:"telegram_id" gorm:"primaryKey"`
+	Amount      int64     `json:"amount"`
+	PaymentHash string    `json:"payment_hash"`
+	NewUser     bool      `json:"new_user"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// BalanceImportResult is the outcome of an entry of a balance import.
+type BalanceImportResult struct {
+	BalanceImportEntry
+	Status  string
+	NewUser bool
+	Reason  string
+}
+
+// BalanceImportReport reconciles the export with what was credited.
+type BalanceImportReport struct {
+	Results  []BalanceImportResult
+	Total    int64 // sat in the export
+	Credited int64 // sat credited by this run
+	Skipped  int64 // sat that were credited before or are not creditable
+	Failed   int64
+}
+
+// parseBalanceImport reads an export as JSON or, if name does not end in .json, as CSV
+// with the columns telegram_id, sats and optionally username. JSON exports are a list of
+// entries or an object that maps telegram ids to sats.
+func parseBalanceImport(r io.Reader, name string) ([]BalanceImportEntry, error) {
+	var entries []BalanceImportEntry
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &entries); err != nil {
+			balances := map[string]int64{}
+			if json.Unmarshal(data, &balances) != nil {
+				return nil, fmt.Errorf("invalid json export: %w", err)
+			}
+			for id, sats := range balances {
+				telegramId, err := strconv.ParseInt(id, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid telegram id %q", id)
+				}
+				entries = append(entries, BalanceImportEntry{TelegramId: telegramId, Sats: sats})
+			}
+		}
+	} else {
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, record := range records {
+			if len(record) < 2 {
+				return nil, fmt.Errorf("line %d: want telegram_id,sats[,username]", i+1)
+			}
+			telegramId, err := strconv.ParseInt(record[0], 10, 64)
+			if err != nil {
+				if i == 0 {
+					// header
+					continue
+				}
+				return nil, fmt.Errorf("line %d: invalid telegram id %q", i+1, record[0])
+			}
+			sats, err := strconv.ParseInt(record[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid amount %q", i+1, record[1])
+			}
+			entry := BalanceImportEntry{TelegramId: telegramId, Sats: sats}
+			if len(record) > 2 {
+				entry.Username = strings.TrimPrefix(record[2], "@")
+			}
+			entries = append(entries, entry)
+		}
+	}
+	seen := map[int64]bool{}
+	for _, entry := range entries {
+		if entry.TelegramId <= 0 || entry.Sats < 0 {
+			return nil, fmt.Errorf("invalid entry %+v", entry)
+		}
+		if seen[entry.TelegramId] {
+			return nil, fmt.Errorf("telegram id %d is in the export twice", entry.TelegramId)
+		}
+		seen[entry.TelegramId] = true
+	}
+	return entries, nil
+}
+
+// importBalances credits the balances of an export from the wallet of the operator, who
+// has to fund it with the sats of the other tip bot first. Users who don't have a wallet
+// get one, like users who receive a tip before they started the bot. With dryRun nothing
+// is credited and the report shows what would happen.
+func (bot *TipBot) importBalances(entries []BalanceImportEntry, source string, dryRun bool) (*BalanceImportReport, error) {
+	if internal.Configuration.Bot.OperatorId == 0 {
+		return nil, fmt.Errorf("no operator configured, the balances are credited from the wallet of the operator")
+	}
+	operator, err := GetUser(&tb.User{ID: internal.Configuration.Bot.OperatorId}, *bot)
+	if err != nil || operator.Wallet == nil {
+		return nil, fmt.Errorf("operator has no wallet: %v", err)
+	}
+	report := &BalanceImportReport{}
+	var pending []BalanceImportEntry
+	var due int64
+	for _, entry := range entries {
+		report.Total += entry.Sats
+		imported := &ImportedBalance{}
+		switch {
+		case entry.Sats == 0:
+			report.Results = append(report.Results, BalanceImportResult{BalanceImportEntry: entry, Status: BalanceImportSkipped, Reason: "no balance"})
+		case entry.TelegramId == operator.Telegram.ID:
+			report.Skipped += entry.Sats
+			report.Results = append(report.Results, BalanceImportResult{BalanceImportEntry: entry, Status: BalanceImportSkipped, Reason: "operator"})
+		case bot.DB.Transactions.Where("source = ? AND telegram_id = ?", source, entry.TelegramId).First(imported).Error == nil:
+			report.Skipped += entry.Sats
+			report.Results = append(report.Results, BalanceImportResult{BalanceImportEntry: entry, Status: BalanceImportSkipped, Reason: fmt.Sprintf("credited on %s", imported.CreatedAt.Format("2006-01-02"))})
+		default:
+			pending = append(pending, entry)
+			due += entry.Sats
+		}
+	}
+	funds, err := bot.GetUserBalance(operator)
+	if err != nil {
+		return nil, err
+	}
+	if funds < due && !dryRun {
+		return nil, fmt.Errorf("the wallet of the operator holds %d sat, the import needs %d sat", funds, due)
+	}
+
+	for _, entry := range pending {
+		result := bot.importBalance(operator, entry, source, dryRun)
+		switch result.Status {
+		case BalanceImportCredited:
+			report.Credited += entry.Sats
+		case BalanceImportFailed:
+			report.Failed += entry.Sats
+		}
+		report.Results = append(report.Results, result)
+	}
+	if dryRun && funds < due {
+		report.Results = append(report.Results, BalanceImportResult{Status: BalanceImportFailed, Reason: fmt.Sprintf("the wallet of the operator holds %d sat, the import needs %d sat", funds, due)})
+	}
+	return report, nil
+}
+
+// importBalance credits the balance of a single user.
+func (bot *TipBot) importBalance(operator *lnbits.User, entry BalanceImportEntry, source string, dryRun bool) BalanceImportResult {
+	result := BalanceImportResult{BalanceImportEntry: entry}
+	fail := func(err error) BalanceImportResult {
+		log.Errorf("[importBalances] could not credit %d sat to %d: %v", entry.Sats, entry.TelegramId, err)
+		result.Status = BalanceImportFailed
+		result.Reason = err.Error()
+		return result
+	}
+	telegramUser := &tb.User{ID: entry.TelegramId, Username: entry.Username}
+	user, err := GetUser(telegramUser, *bot)
+	if stderrors.Is(err, gorm.ErrRecordNotFound) {
+		result.NewUser = true
+		if dryRun {
+			result.Status = BalanceImportPlanned
+			return result
+		}
+		user, err = bot.CreateWalletForTelegramUser(telegramUser)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if user.Wallet == nil {
+		return fail(fmt.Errorf("user has no wallet"))
+	}
+	if dryRun {
+		result.Status = BalanceImportPlanned
+		return result
+	}
+	invoice, err := bot.Client.CreateInvoice(*user.Wallet, lnbits.InvoiceParams{Amount: entry.Sats, Memo: fmt.Sprintf("Balance from %s", source)})
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := bot.Client.Pay(*operator.Wallet, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}); err != nil {
+		return fail(err)
+	}
+	imported := &ImportedBalance{Source: source, TelegramId: entry.TelegramId, Amount: entry.Sats, PaymentHash: invoice.PaymentHash, NewUser: result.NewUser}
+	if tx := bot.DB.Transactions.Create(imported); tx.Error != nil {
+		// the balance is credited, a second run must not credit it again
+		log.Errorf("[importBalances] credited %d sat to %d but could not record it: %v", entry.Sats, entry.TelegramId, tx.Error)
+	}
+	log.Infof("[importBalances] credited %d sat to %s", entry.Sats, GetUserStr(user.Telegram))
+	bot.Audit("balance import", AuditActionBalanceAdjustment, auditActor(user.Telegram), fmt.Sprintf("+%d sat from %s", entry.Sats, source))
+	result.Status = BalanceImportCredited
+	return result
+}
+
+// writeBalanceImportReport writes the report as CSV.
+func writeBalanceImportReport(w io.Writer, report *BalanceImportReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"telegram_id", "username", "sats", "status", "new_user", "reason"})
+	for _, r := range report.Results {
+		writer.Write([]string{strconv.FormatInt(r.TelegramId, 10), r.Username, strconv.FormatInt(r.Sats, 10), r.Status, strconv.FormatBool(r.NewUser), r.Reason})
+	}
+	writer.Write([]string{"total", "", strconv.FormatInt(report.Total, 10), "", "", ""})
+	writer.Write([]string{"credited", "", strconv.FormatInt(report.Credited, 10), "", "", ""})
+	writer.Write([]string{"skipped", "", strconv.FormatInt(report.Skipped, 10), "", "", ""})
+	writer.Write([]string{"failed", "", strconv.FormatInt(report.Failed, 10), "", "", ""})
+	writer.Flush()
+	return writer.Error()
+}
+
+// RunBalanceImport imports the balances of the export at path, see importBalances. The
+// report is written next to the export. It is used by the --import-balances flag.
+func RunBalanceImport(path string, source string, dryRun bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	entries, err := parseBalanceImport(file, path)
+	if err != nil {
+		return err
+	}
+	if len(source) == 0 {
+		source = filepath.Base(path)
+	}
+	dbs := openDatabases()
+	bunt := createBunt(internal.Configuration.Database.BuntDbPath, "bunt")
+	shopBunt := createBunt(internal.Configuration.Database.ShopBuntDbPath, "shop_bunt")
+	if err := migrateDatabases(dbs, bunt, shopBunt, "latest"); err != nil {
+		return err
+	}
+	bot := &TipBot{
+		DB:       dbs,
+		Client:   newWalletBackend(),
+		Bunt:     bunt,
+		ShopBunt: shopBunt,
+		Cache:    Cache{StoreInterface: store.NewGoCache(gocache.New(5*time.Minute, 10*time.Minute), nil)},
+	}
+	report, err := bot.importBalances(entries, source, dryRun)
+	if err != nil {
+		return err
+	}
+	reportPath := fmt.Sprintf("%s.report.csv", strings.TrimSuffix(path, filepath.Ext(path)))
+	out, err := os.Create(reportPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := writeBalanceImportReport(out, report); err != nil {
+		return err
+	}
+	log.Infof("[importBalances] %d users, %d sat in the export, %d sat credited, %d sat skipped, %d sat failed, see %s",
+		len(entries), report.Total, report.Credited, report.Skipped, report.Failed, reportPath)
+	if report.Failed > 0 {
+		return fmt.Errorf("%d sat could not be credited", report.Failed)
+	}
+	return nil
+}
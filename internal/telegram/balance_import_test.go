@@ -0,0 +1,98 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestParseBalanceImport(t *testing.T) {
+	csvExport := "telegram_id,sats,username\n9921,100,@alice\n9922, 0\n"
+	entries, err := parseBalanceImport(strings.NewReader(csvExport), "export.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0] != (BalanceImportEntry{TelegramId: 9921, Username: "alice", Sats: 100}) {
+		t.Errorf("csv entries = %+v", entries)
+	}
+	entries, err = parseBalanceImport(strings.NewReader(`{"9921": 100}`), "export.json")
+	if err != nil || len(entries) != 1 || entries[0].Sats != 100 {
+		t.Errorf("json object entries = %+v, %v", entries, err)
+	}
+	entries, err = parseBalanceImport(strings.NewReader(`[{"telegram_id": 9921, "sats": 100}]`), "export.json")
+	if err != nil || len(entries) != 1 || entries[0].TelegramId != 9921 {
+		t.Errorf("json list entries = %+v, %v", entries, err)
+	}
+	for _, export := range []string{"9921,100\n9921,5\n", "9921,-5\n", "9921,100\nbob,5\n"} {
+		if _, err := parseBalanceImport(strings.NewReader(export), "export.csv"); err == nil {
+			t.Errorf("export %q was accepted", export)
+		}
+	}
+}
+
+func TestImportBalances(t *testing.T) {
+	h := newTestHarness(t)
+	operator := &tb.User{ID: 9920, Username: "operator", FirstName: "Operator", LanguageCode: "en"}
+	alice := &tb.User{ID: 9921, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	h.newUser(operator, 1000)
+	aliceUser := h.newUser(alice, 10)
+	configuration := internal.Configuration
+	t.Cleanup(func() { internal.Configuration = configuration })
+	internal.Configuration.Bot.OperatorId = operator.ID
+
+	entries := []BalanceImportEntry{
+		{TelegramId: alice.ID, Username: "alice", Sats: 100},
+		{TelegramId: 9922, Username: "bob", Sats: 200},
+		{TelegramId: 9923, Sats: 0},
+	}
+	report, err := h.bot.importBalances(entries, "otherbot", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Credited != 0 || report.Results[1].Status != BalanceImportPlanned || !report.Results[1].NewUser {
+		t.Errorf("dry run = %+v", report)
+	}
+	if _, err := GetUser(&tb.User{ID: 9922}, *h.bot); err == nil {
+		t.Error("the dry run created a wallet")
+	}
+
+	report, err = h.bot.importBalances(entries, "otherbot", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 300 || report.Credited != 300 || report.Failed != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	bob, err := GetUser(&tb.User{ID: 9922}, *h.bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bob.Initialized || bob.Telegram.Username != "bob" {
+		t.Errorf("bob = %+v", bob)
+	}
+	for user, want := range map[*tb.User]int64{operator: 700, alice: 110, bob.Telegram: 200} {
+		u, _ := GetUser(user, *h.bot)
+		if balance, _ := h.bot.GetUserBalance(u); balance != want {
+			t.Errorf("balance of %d = %d, want %d", user.ID, balance, want)
+		}
+	}
+
+	// balances are only credited once per source
+	report, err = h.bot.importBalances(entries, "otherbot", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Credited != 0 || report.Skipped != 300 {
+		t.Errorf("second import = %+v", report)
+	}
+	if balance, _ := h.bot.GetUserBalance(aliceUser); balance != 110 {
+		t.Errorf("balance of alice after the second import = %d, want 110", balance)
+	}
+
+	// the operator has to fund the import
+	if _, err := h.bot.importBalances([]BalanceImportEntry{{TelegramId: 9924, Sats: 5000}}, "otherbot", false); err == nil {
+		t.Error("import without funds succeeded")
+	}
+}
@@ -0,0 +1,395 @@
+package telegram
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// betAcceptDuration is how long a bet waits for the opponent before the stake is refunded
+	betAcceptDuration = 24 * time.Hour
+	// betDuration is how long an accepted bet waits to be resolved before both stakes are refunded
+	betDuration = 365 * 24 * time.Hour
+
+	BetOutcomeChallenger = "challenger"
+	BetOutcomeOpponent   = "opponent"
+	BetOutcomeTie        = "tie"
+)
+
+var (
+	betCommandRegex = regexp.MustCompile(`^/\S+\s+(\S+)\s+@(\S+)\s+["“”]([^"“”]+)["“”](?:\s+@(\S+))?\s*$`)
+	betMenu         = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnAcceptBet    = betMenu.Data("✅ Accept", "accept_bet")
+	btnCancelBet    = betMenu.Data("🚫 Cancel", "cancel_bet")
+	btnResolveBet   = betMenu.Data("🏆", "resolve_bet")
+)
+
+// Bet is a wager of two users on the outcome of something outside the bot. Both stakes are held
+// in the escrow wallet until the arbiter that both parties agreed on resolves the bet. Without an
+// arbiter, both parties have to pick the same outcome. A tie or a bet that nobody resolves in
+// time refunds both stakes.
+type Bet struct {
+	*storage.Base
+	Terms          string       `json:"terms"`
+	Amount         int64        `json:"amount"` // stake of each party
+	Challenger     *lnbits.User `json:"challenger"`
+	Opponent       *lnbits.User `json:"opponent"`
+	Arbiter        *lnbits.User `json:"arbiter,omitempty"` // nil if both parties resolve the bet
+	Accepted       bool         `json:"accepted"`
+	ChallengerVote string       `json:"challenger_vote"`
+	OpponentVote   string       `json:"opponent_vote"`
+	Message        *tb.Message  `json:"message"`
+	Expires        time.Time    `json:"expires"`
+	LanguageCode   string       `json:"languagecode"`
+	Refunded       []int64      `json:"refunded,omitempty"` // parties whose stake was sent back
+}
+
+// betStr shows the terms and the parties of a bet.
+func (bet *Bet) betStr() string {
+	arbiter := i18n.Translate(bet.LanguageCode, "betAgreementMessage")
+	if bet.Arbiter != nil {
+		arbiter = fmt.Sprintf(i18n.Translate(bet.LanguageCode, "betArbiterMessage"), GetUserStrMd(bet.Arbiter.Telegram))
+	}
+	return fmt.Sprintf(i18n.Translate(bet.LanguageCode, "betMessage"), str.MarkdownEscape(bet.Terms),
+		GetUserStrMd(bet.Challenger.Telegram), bet.Amount, GetUserStrMd(bet.Opponent.Telegram), arbiter)
+}
+
+func (bet *Bet) outcomeStr(outcome string) string {
+	switch outcome {
+	case BetOutcomeChallenger:
+		return fmt.Sprintf(i18n.Translate(bet.LanguageCode, "betOutcomeWinsMessage"), GetUserStrMd(bet.Challenger.Telegram))
+	case BetOutcomeOpponent:
+		return fmt.Sprintf(i18n.Translate(bet.LanguageCode, "betOutcomeWinsMessage"), GetUserStrMd(bet.Opponent.Telegram))
+	}
+	return i18n.Translate(bet.LanguageCode, "betOutcomeTieMessage")
+}
+
+// votesStr shows the outcomes that the parties picked so far.
+func (bet *Bet) votesStr() string {
+	votes := ""
+	if len(bet.ChallengerVote) > 0 {
+		votes += fmt.Sprintf(i18n.Translate(bet.LanguageCode, "betVoteMessage"), GetUserStrMd(bet.Challenger.Telegram), bet.outcomeStr(bet.ChallengerVote))
+	}
+	if len(bet.OpponentVote) > 0 {
+		votes += fmt.Sprintf(i18n.Translate(bet.LanguageCode, "betVoteMessage"), GetUserStrMd(bet.Opponent.Telegram), bet.outcomeStr(bet.OpponentVote))
+	}
+	return votes
+}
+
+func (bot *TipBot) makeBetKeyboard(bet *Bet) *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	if !bet.Accepted {
+		acceptButton := menu.Data(i18n.Translate(bet.LanguageCode, "betAcceptButtonMessage"), "accept_bet", bet.ID)
+		cancelButton := menu.Data(i18n.Translate(bet.LanguageCode, "cancelButtonMessage"), "cancel_bet", bet.ID)
+		menu.Inline(menu.Row(acceptButton, cancelButton))
+		return menu
+	}
+	challengerButton := menu.Data(fmt.Sprintf(i18n.Translate(bet.LanguageCode, "betWinsButtonMessage"), GetUserStr(bet.Challenger.Telegram)), "resolve_bet", bet.ID, BetOutcomeChallenger)
+	opponentButton := menu.Data(fmt.Sprintf(i18n.Translate(bet.LanguageCode, "betWinsButtonMessage"), GetUserStr(bet.Opponent.Telegram)), "resolve_bet", bet.ID, BetOutcomeOpponent)
+	tieButton := menu.Data(i18n.Translate(bet.LanguageCode, "betTieButtonMessage"), "resolve_bet", bet.ID, BetOutcomeTie)
+	menu.Inline(menu.Row(challengerButton, opponentButton), menu.Row(tieButton))
+	return menu
+}
+
+// betHandler is invoked on /bet <amount> @user "<terms>" [@arbiter] in groups. The stake of the
+// challenger goes to the escrow wallet right away.
+func (bot *TipBot) betHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Sender, Translate(ctx, "betHelpMessage"))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	matches := betCommandRegex.FindStringSubmatch(m.Text)
+	if matches == nil || len(strings.TrimSpace(matches[3])) == 0 {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "betHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(matches[1])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "betHelpMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	challenger := LoadUser(ctx)
+	opponent, err := GetUserByTelegramUsername(matches[2], *bot)
+	if err != nil || opponent.Wallet == nil {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape("@"+matches[2])))
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if opponent.Telegram.ID == challenger.Telegram.ID {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "betYourselfMessage"))
+		return ctx, fmt.Errorf("cannot bet against yourself")
+	}
+	var arbiter *lnbits.User
+	if len(matches[4]) > 0 {
+		arbiter, err = GetUserByTelegramUsername(matches[4], *bot)
+		if err != nil || arbiter.Wallet == nil {
+			bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape("@"+matches[4])))
+			return ctx, errors.Create(errors.UserNoWalletError)
+		}
+		if arbiter.Telegram.ID == challenger.Telegram.ID || arbiter.Telegram.ID == opponent.Telegram.ID {
+			bot.trySendMessage(bot.topicOf(m), Translate(ctx, "betArbiterIsPartyMessage"))
+			return ctx, fmt.Errorf("the arbiter is a party of the bet")
+		}
+	}
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		log.Errorf("[/bet] %v", err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	terms := strings.TrimSpace(matches[3])
+	if runes := []rune(terms); len(runes) > 200 {
+		terms = string(runes[:200]) + "..."
+	}
+	bet := &Bet{
+		Base:         storage.New(storage.ID(fmt.Sprintf("bet:%s", RandStringRunes(10)))),
+		Terms:        terms,
+		Amount:       amount,
+		Challenger:   challenger,
+		Opponent:     opponent,
+		Arbiter:      arbiter,
+		Expires:      time.Now().Add(betAcceptDuration),
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	t := NewTransaction(bot, challenger, escrow, amount, TransactionType("bet stake"), TransactionChat(m.Chat),
+		TransactionIdempotencyKey(fmt.Sprintf("%s:stake:%d", bet.ID, challenger.Telegram.ID)), TransactionContext(ctx))
+	t.Memo = fmt.Sprintf("🤝 Stake of %s in a bet.", GetUserStr(challenger.Telegram))
+	if success, err := t.Send(); !success {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "betStakeFailedMessage"), GetUserStrMd(challenger.Telegram)))
+		logger(ctx).Warnf("[bet] stake of %s failed: %v", GetUserStr(challenger.Telegram), err)
+		return ctx, err
+	}
+	text := bet.betStr() + fmt.Sprintf(i18n.Translate(bet.LanguageCode, "betOpenMessage"), GetUserStrMd(opponent.Telegram))
+	bet.Message = bot.trySendMessageEditable(bot.topicOf(m), text, bot.makeBetKeyboard(bet))
+	if bet.Message == nil {
+		bot.refundBet(bet, "")
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	logger(ctx).Infof("[🤝 bet] %s bet %d sat against %s in %s", GetUserStr(challenger.Telegram), amount, GetUserStr(opponent.Telegram), bet.ID)
+	bot.startBetTimer(bet)
+	return ctx, bet.Set(bet, bot.Bunt)
+}
+
+// loadBet loads an active bet.
+func (bot *TipBot) loadBet(id string) (*Bet, error) {
+	bet := &Bet{Base: storage.New(storage.ID(id))}
+	sn, err := bet.Get(bet, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	bet = sn.(*Bet)
+	if !bet.Active {
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	return bet, nil
+}
+
+// acceptBetHandler is invoked when the opponent accepts a bet, and with it the arbiter. Their
+// stake goes to the escrow wallet.
+func (bot *TipBot) acceptBetHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	bet, err := bot.loadBet(ctx.Data())
+	if err != nil {
+		return ctx, err
+	}
+	sender := ctx.Sender()
+	if bet.Accepted || time.Now().After(bet.Expires) || bet.Opponent.Telegram.ID != sender.ID {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		return ctx, err
+	}
+	opponent := LoadUser(ctx)
+	t := NewTransaction(bot, opponent, escrow, bet.Amount, TransactionType("bet stake"), TransactionChat(bet.Message.Chat),
+		TransactionIdempotencyKey(fmt.Sprintf("%s:stake:%d", bet.ID, opponent.Telegram.ID)), TransactionContext(ctx))
+	t.Memo = fmt.Sprintf("🤝 Stake of %s in a bet.", GetUserStr(opponent.Telegram))
+	success, err := t.Send()
+	if isDuplicateOperation(err) {
+		return ctx, err
+	}
+	if !success {
+		bot.trySendMessage(sender, fmt.Sprintf(Translate(ctx, "betStakeFailedMessage"), GetUserStrMd(sender)))
+		logger(ctx).Warnf("[bet] stake of %s in %s failed: %v", GetUserStr(sender), bet.ID, err)
+		return ctx, err
+	}
+	bet.Opponent = opponent
+	bet.Accepted = true
+	bet.Expires = time.Now().Add(betDuration)
+	runtime.IgnoreError(bet.Set(bet, bot.Bunt))
+	logger(ctx).Infof("[🤝 bet] %s accepted %s", GetUserStr(sender), bet.ID)
+	bot.tryEditMessage(bet.Message, bet.betStr()+fmt.Sprintf(i18n.Translate(bet.LanguageCode, "betAcceptedMessage"), 2*bet.Amount), bot.makeBetKeyboard(bet))
+	bot.startBetTimer(bet)
+	return ctx, nil
+}
+
+// resolveBetHandler is invoked when the arbiter or a party picks the outcome of an accepted bet.
+// Without an arbiter, the bet is resolved when both parties picked the same outcome.
+func (bot *TipBot) resolveBetHandler(ctx intercept.Context) (intercept.Context, error) {
+	data := strings.Split(ctx.Data(), "|")
+	if len(data) != 2 {
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	id, outcome := data[0], data[1]
+	if outcome != BetOutcomeChallenger && outcome != BetOutcomeOpponent && outcome != BetOutcomeTie {
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	bet, err := bot.loadBet(id)
+	if err != nil || !bet.Accepted {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	sender := ctx.Sender()
+	if bet.Arbiter != nil {
+		if bet.Arbiter.Telegram.ID != sender.ID {
+			return ctx, fmt.Errorf("only the arbiter resolves %s", bet.ID)
+		}
+		logger(ctx).Infof("[🤝 bet] arbiter %s resolved %s: %s", GetUserStr(sender), bet.ID, outcome)
+		bot.resolveBet(bet, outcome)
+		return ctx, nil
+	}
+	switch sender.ID {
+	case bet.Challenger.Telegram.ID:
+		bet.ChallengerVote = outcome
+	case bet.Opponent.Telegram.ID:
+		bet.OpponentVote = outcome
+	default:
+		return ctx, fmt.Errorf("only the parties resolve %s", bet.ID)
+	}
+	if bet.ChallengerVote == bet.OpponentVote {
+		logger(ctx).Infof("[🤝 bet] both parties resolved %s: %s", bet.ID, outcome)
+		bot.resolveBet(bet, outcome)
+		return ctx, nil
+	}
+	runtime.IgnoreError(bet.Set(bet, bot.Bunt))
+	text := bet.betStr() + fmt.Sprintf(i18n.Translate(bet.LanguageCode, "betAcceptedMessage"), 2*bet.Amount) + bet.votesStr()
+	bot.tryEditMessage(bet.Message, text, bot.makeBetKeyboard(bet))
+	return ctx, nil
+}
+
+// resolveBet pays both stakes to the winner or refunds them on a tie. If the payout fails, the
+// bet stays active and the parties can resolve it again.
+func (bot *TipBot) resolveBet(bet *Bet, outcome string) {
+	if outcome == BetOutcomeTie {
+		bot.refundBet(bet, bet.betStr()+i18n.Translate(bet.LanguageCode, "betTieMessage"))
+		return
+	}
+	winner := bet.Challenger
+	if outcome == BetOutcomeOpponent {
+		winner = bet.Opponent
+	}
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		log.Errorf("[bet] could not resolve %s: %v", bet.ID, err)
+		return
+	}
+	to, err := GetUser(winner.Telegram, *bot)
+	if err != nil {
+		log.Errorf("[bet] could not pay out %s: %v", bet.ID, err)
+		return
+	}
+	t := NewTransaction(bot, escrow, to, 2*bet.Amount, TransactionType("bet payout"), TransactionChat(bet.Message.Chat), TransactionIdempotencyKey(bet.ID+":payout"))
+	t.Memo = fmt.Sprintf("🤝 %s won a bet.", GetUserStr(winner.Telegram))
+	if success, err := t.Send(); !success {
+		log.Errorf("[bet] could not pay out %s: %v", bet.ID, err)
+		return
+	}
+	runtime.IgnoreError(bet.Inactivate(bet, bot.Bunt))
+	log.Infof("[🤝 bet] %s won %s: %d sat", GetUserStr(winner.Telegram), bet.ID, 2*bet.Amount)
+	bot.tryEditMessage(bet.Message, bet.betStr()+fmt.Sprintf(i18n.Translate(bet.LanguageCode, "betWonMessage"), GetUserStrMd(winner.Telegram), 2*bet.Amount), &tb.ReplyMarkup{})
+}
+
+func (bet *Bet) refunded(party *lnbits.User) bool {
+	for _, id := range bet.Refunded {
+		if id == party.Telegram.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// refundBet sends the stakes back and shows message, the bet is over.
+func (bot *TipBot) refundBet(bet *Bet, message string) {
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		log.Errorf("[bet] could not refund %s: %v", bet.ID, err)
+		return
+	}
+	parties := []*lnbits.User{bet.Challenger}
+	if bet.Accepted {
+		parties = append(parties, bet.Opponent)
+	}
+	for _, party := range parties {
+		if bet.refunded(party) {
+			continue
+		}
+		to, err := GetUser(party.Telegram, *bot)
+		if err != nil {
+			log.Errorf("[bet] could not refund %s to %s: %v", bet.ID, GetUserStr(party.Telegram), err)
+			return
+		}
+		t := NewTransaction(bot, escrow, to, bet.Amount, TransactionType("bet refund"), TransactionIdempotencyKey(fmt.Sprintf("%s:refund:%d", bet.ID, party.Telegram.ID)))
+		t.Memo = fmt.Sprintf("🤝 Refund of the stake of %s in a bet.", GetUserStr(party.Telegram))
+		if success, err := t.Send(); !success {
+			// the bet stays active, its expiry tries again
+			log.Errorf("[bet] could not refund %s to %s: %v", bet.ID, GetUserStr(party.Telegram), err)
+			return
+		}
+		bet.Refunded = append(bet.Refunded, party.Telegram.ID)
+		runtime.IgnoreError(bet.Set(bet, bot.Bunt))
+	}
+	runtime.IgnoreError(bet.Inactivate(bet, bot.Bunt))
+	log.Infof("[🤝 bet] refunded %s to %d parties", bet.ID, len(parties))
+	if len(message) > 0 {
+		bot.tryEditMessage(bet.Message, message, &tb.ReplyMarkup{})
+	}
+}
+
+// cancelBetHandler is invoked when the challenger cancels or the opponent declines a bet.
+func (bot *TipBot) cancelBetHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	bet, err := bot.loadBet(ctx.Data())
+	if err != nil {
+		return ctx, err
+	}
+	sender := ctx.Sender()
+	if bet.Accepted || (sender.ID != bet.Challenger.Telegram.ID && sender.ID != bet.Opponent.Telegram.ID) {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	bot.refundBet(bet, bet.betStr()+fmt.Sprintf(i18n.Translate(bet.LanguageCode, "betCancelledMessage"), GetUserStrMd(sender)))
+	return ctx, nil
+}
+
+// startBetTimer refunds a bet that nobody accepted or resolved when it expires.
+func (bot *TipBot) startBetTimer(bet *Bet) {
+	time.AfterFunc(time.Until(bet.Expires), func() {
+		bot.expireBet(bet.ID)
+	})
+}
+
+func (bot *TipBot) expireBet(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	bet, err := bot.loadBet(id)
+	if err != nil || time.Now().Before(bet.Expires) {
+		// accepting a bet extends its expiry
+		return
+	}
+	bot.refundBet(bet, bet.betStr()+i18n.Translate(bet.LanguageCode, "betExpiredMessage"))
+}
@@ -0,0 +1,79 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestBetArbiter(t *testing.T) {
+	h := newTestHarness(t)
+	escrow := h.newUser(testBotUser, 0)
+	alice := &tb.User{ID: 9951, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9952, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	carol := &tb.User{ID: 9953, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9950, Type: tb.ChatGroup, Title: "bets"}
+	challenger := h.newUser(alice, 5000)
+	opponent := h.newUser(bob, 5000)
+	h.newUser(carol, 0)
+
+	h.sendMessage(alice, group, `/bet 2000 @bob "ETH flips BTC by 2026" @carol`)
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "ETH flips BTC by 2026") || !strings.Contains(text, "Arbiter: @carol") {
+		t.Fatalf("bet message = %q", text)
+	}
+	h.pressButton(bob, h.lastMessage(group.ID), "✅ Accept")
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 4000 {
+		t.Fatalf("balance of the escrow = %d, want 4000", balance)
+	}
+	if claims := h.bot.pendingClaims(); len(claims) != 1 || claims[0].Type != ClaimTypeBet {
+		t.Errorf("pending claims = %+v, want the bet", claims)
+	}
+
+	// only the arbiter resolves the bet
+	h.pressButton(bob, h.lastMessage(group.ID), "🏆 @bob")
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 4000 {
+		t.Fatalf("balance of the escrow = %d after a party resolved, want 4000", balance)
+	}
+	h.pressButton(carol, h.lastMessage(group.ID), "🏆 @alice")
+	if balance := h.lnbits.Balance(challenger.Wallet.ID); balance != 7000 {
+		t.Errorf("balance of the winner = %d, want 7000", balance)
+	}
+	if balance := h.lnbits.Balance(opponent.Wallet.ID); balance != 3000 {
+		t.Errorf("balance of the loser = %d, want 3000", balance)
+	}
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "@alice won 4000 sat") {
+		t.Errorf("bet message = %q", text)
+	}
+}
+
+func TestBetAgreement(t *testing.T) {
+	h := newTestHarness(t)
+	escrow := h.newUser(testBotUser, 0)
+	alice := &tb.User{ID: 9961, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9962, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9960, Type: tb.ChatGroup, Title: "bets"}
+	challenger := h.newUser(alice, 1000)
+	opponent := h.newUser(bob, 1000)
+
+	h.sendMessage(alice, group, `/bet 500 @bob "It rains tomorrow"`)
+	h.pressButton(bob, h.lastMessage(group.ID), "✅ Accept")
+
+	// the parties disagree until both pick the same outcome
+	h.pressButton(alice, h.lastMessage(group.ID), "🏆 @alice")
+	h.pressButton(bob, h.lastMessage(group.ID), "🏆 @bob")
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 1000 {
+		t.Fatalf("balance of the escrow = %d while the parties disagree, want 1000", balance)
+	}
+	h.pressButton(alice, h.lastMessage(group.ID), "🤝 Tie")
+	h.pressButton(bob, h.lastMessage(group.ID), "🤝 Tie")
+	if balance := h.lnbits.Balance(challenger.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of the challenger = %d after a tie, want 1000", balance)
+	}
+	if balance := h.lnbits.Balance(opponent.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of the opponent = %d after a tie, want 1000", balance)
+	}
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "tie") {
+		t.Errorf("bet message = %q", text)
+	}
+}
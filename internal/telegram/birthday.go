@@ -0,0 +1,281 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	birthdayLayout        = "01-02"
+	birthdayCheckInterval = time.Hour
+)
+
+// birthdayWhipRoundAmounts are the buttons of the whip-round for a birthday.
+var birthdayWhipRoundAmounts = []int64{100, 1000, 5000}
+
+var (
+	birthdayMenu   = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnBirthdayTip = birthdayMenu.Data("🎂", "birthday_tip")
+)
+
+// BirthdayMember is a member who wants their birthday celebrated in a group.
+type BirthdayMember struct {
+	ChatId int64 `json:"chat_id" gorm:"primaryKey;autoIncrement:false"`
+	UserId int64 `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	// CelebratedAt is the time of the last celebration, a birthday is celebrated once a year
+	CelebratedAt time.Time `json:"celebrated_at"`
+}
+
+// isBirthday returns whether the birthday of the settings is on the day of now. Birthdays on
+// February 29 are celebrated on February 28 in other years.
+func isBirthday(settings lnbits.BirthdaySettings, now time.Time) bool {
+	if settings.Month == 0 || settings.Day == 0 {
+		return false
+	}
+	if settings.Month == 2 && settings.Day == 29 && now.Month() == 2 && now.Day() == 28 &&
+		time.Date(now.Year(), 2, 29, 0, 0, 0, 0, time.UTC).Month() != 2 {
+		return true
+	}
+	return int(now.Month()) == settings.Month && now.Day() == settings.Day
+}
+
+func birthdayStr(settings lnbits.BirthdaySettings) string {
+	return time.Date(2000, time.Month(settings.Month), settings.Day, 0, 0, 0, 0, time.UTC).Format("January 2")
+}
+
+// birthdayHandler is invoked on /birthday. /birthday <MM-DD> stores the birthday of the user,
+// /birthday off removes it. In a group, the user joins the birthday celebrations of the group.
+func (bot *TipBot) birthdayHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	args := strings.Fields(m.Text)
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	if len(args) > 2 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "birthdayHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if len(args) == 2 {
+		if strings.ToLower(args[1]) == "off" {
+			user.Settings.Birthday = lnbits.BirthdaySettings{}
+			if err := UpdateUserRecord(user, *bot); err != nil {
+				log.Errorf("[/birthday] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+				return ctx, err
+			}
+			bot.DB.Groups.Where("user_id = ?", user.Telegram.ID).Delete(&BirthdayMember{})
+			bot.trySendMessage(m.Sender, Translate(ctx, "birthdayRemovedMessage"))
+			return ctx, nil
+		}
+		// the year is needed to accept February 29
+		date, err := time.Parse("2006-"+birthdayLayout, "2000-"+args[1])
+		if err != nil {
+			bot.trySendMessage(m.Sender, Translate(ctx, "birthdayHelpMessage"))
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+		user.Settings.Birthday = lnbits.BirthdaySettings{Month: int(date.Month()), Day: date.Day()}
+		if err := UpdateUserRecord(user, *bot); err != nil {
+			log.Errorf("[/birthday] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+			return ctx, err
+		}
+	}
+	if user.Settings.Birthday.Month == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "birthdayHelpMessage"))
+		return ctx, nil
+	}
+	if m.Private() {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "birthdaySavedMessage"), birthdayStr(user.Settings.Birthday)))
+		return ctx, nil
+	}
+	member := &BirthdayMember{ChatId: m.Chat.ID, UserId: user.Telegram.ID}
+	bot.DB.Groups.Where(member).FirstOrCreate(member)
+	bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "birthdayJoinedMessage"), GetUserStrMd(user.Telegram), birthdayStr(user.Settings.Birthday)))
+	return ctx, nil
+}
+
+// startBirthdayWorker celebrates the birthdays of members in groups that turned it on.
+func (bot *TipBot) startBirthdayWorker() {
+	go func() {
+		for {
+			if !isShuttingDown() {
+				bot.celebrateBirthdays(time.Now().UTC())
+			}
+			time.Sleep(birthdayCheckInterval)
+		}
+	}()
+}
+
+// celebrateBirthdays celebrates the birthdays on the day of now that were not celebrated yet.
+func (bot *TipBot) celebrateBirthdays(now time.Time) {
+	var groups []GroupSettings
+	tx := bot.DB.Groups.Where("birthday_tip > ? OR birthday_whip_round = ?", 0, true).Find(&groups)
+	if tx.Error != nil {
+		log.Errorf("[birthdays] %v", tx.Error)
+		return
+	}
+	for _, settings := range groups {
+		var members []BirthdayMember
+		bot.DB.Groups.Where("chat_id = ?", settings.ID).Find(&members)
+		for _, member := range members {
+			if member.CelebratedAt.Year() == now.Year() {
+				continue
+			}
+			user, err := GetLnbitsUserWithSettings(&tb.User{ID: member.UserId}, *bot)
+			if err != nil || user.Wallet == nil || !isBirthday(user.Settings.Birthday, now) {
+				continue
+			}
+			bot.celebrateBirthday(settings.ID, user, now)
+		}
+	}
+}
+
+func (bot *TipBot) celebrateBirthday(chatId int64, user *lnbits.User, now time.Time) {
+	beginInFlight()
+	defer endInFlight()
+	// other instances of a cluster celebrate the same birthdays
+	key := fmt.Sprintf("birthday:%d:%d", chatId, user.Telegram.ID)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	member := &BirthdayMember{}
+	if tx := bot.DB.Groups.Where("chat_id = ? AND user_id = ?", chatId, user.Telegram.ID).First(member); tx.Error != nil || member.CelebratedAt.Year() == now.Year() {
+		return
+	}
+	if tx := bot.DB.Groups.Model(member).Update("celebrated_at", now); tx.Error != nil {
+		log.Errorf("[birthdays] could not save the celebration of %s in %d: %v", GetUserStr(user.Telegram), chatId, tx.Error)
+		return
+	}
+	settings := bot.getGroupSettings(chatId)
+	chat := &tb.Chat{ID: chatId, Type: tb.ChatGroup}
+	languageCode := bot.getGroupLanguageCode(chat)
+	if len(languageCode) == 0 {
+		languageCode = "en"
+	}
+	message := fmt.Sprintf(i18n.Translate(languageCode, "birthdayMessage"), GetUserStrMd(user.Telegram))
+	if settings.BirthdayTip > 0 && settings.WalletUserId != 0 && settings.WalletUserId != user.Telegram.ID {
+		if wallet, err := GetLnbitsUser(&tb.User{ID: settings.WalletUserId}, *bot); err == nil && wallet.Wallet != nil {
+			t := NewTransaction(bot, wallet, user, settings.BirthdayTip, TransactionType("birthday tip"), TransactionChat(chat),
+				TransactionIdempotencyKey(fmt.Sprintf("%s:%d", key, now.Year())))
+			t.Memo = fmt.Sprintf("🎂 Birthday tip for %s.", GetUserStr(user.Telegram))
+			if success, err := t.Send(); success {
+				message += fmt.Sprintf(i18n.Translate(languageCode, "birthdayGroupTipMessage"), settings.BirthdayTip)
+			} else {
+				log.Warnf("[birthdays] the group wallet of %d could not tip %s: %v", chatId, GetUserStr(user.Telegram), err)
+			}
+		}
+	}
+	log.Infof("[🎂 birthday] celebrating %s in %d", GetUserStr(user.Telegram), chatId)
+	if !settings.BirthdayWhipRound {
+		bot.trySendMessage(chat, message, keepMessage)
+		return
+	}
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	buttons := make([]tb.Btn, 0)
+	for _, amount := range birthdayWhipRoundAmounts {
+		buttons = append(buttons, menu.Data(fmt.Sprintf("🎂 %d", amount), "birthday_tip", strconv.FormatInt(user.Telegram.ID, 10), strconv.FormatInt(amount, 10)))
+	}
+	menu.Inline(menu.Row(buttons...))
+	bot.trySendMessage(chat, message+i18n.Translate(languageCode, "birthdayWhipRoundMessage"), menu, keepMessage)
+}
+
+// birthdayTipHandler is invoked when a member chips in to the whip-round for a birthday.
+func (bot *TipBot) birthdayTipHandler(ctx intercept.Context) (intercept.Context, error) {
+	data := strings.Split(ctx.Data(), "|")
+	if len(data) != 2 {
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	userId, err := strconv.ParseInt(data[0], 10, 64)
+	if err != nil {
+		return ctx, err
+	}
+	amount, err := strconv.ParseInt(data[1], 10, 64)
+	if err != nil || amount < 1 {
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	from := LoadUser(ctx)
+	if from.Telegram.ID == userId {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "sendYourselfMessage"))
+		return ctx, fmt.Errorf("cannot tip yourself")
+	}
+	to, err := GetLnbitsUser(&tb.User{ID: userId}, *bot)
+	if err != nil || to.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	t := NewTransaction(bot, from, to, amount, TransactionType("birthday tip"), TransactionChat(ctx.Callback().Message.Chat),
+		TransactionIdempotencyKey(idempotencyKey(ctx)), TransactionContext(ctx))
+	t.Memo = fmt.Sprintf("🎂 Birthday tip from %s.", GetUserStr(from.Telegram))
+	success, err := t.Send()
+	if isDuplicateOperation(err) {
+		return ctx, err
+	}
+	if !success {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "sendErrorMessage"))
+		return ctx, err
+	}
+	ctx.Context = context.WithValue(ctx, "callback_response", fmt.Sprintf(Translate(ctx, "birthdayTippedMessage"), amount))
+	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "birthdayReceivedMessage"), GetUserStrMd(from.Telegram), amount))
+	return ctx, nil
+}
+
+// groupSettingsBirthdaysHandler configures the birthday celebrations of the group with
+// /groupsettings birthdays <amount>|whipround|off. The group wallet tips amount on birthdays,
+// a whip-round lets the members chip in.
+func (bot *TipBot) groupSettingsBirthdaysHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	value, err := getArgumentFromCommand(m.Text, 2)
+	value = strings.ToLower(value)
+	if err != nil {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsHelpMessage"), strings.Join(i18n.LoadedLanguages(), ", ")))
+		return ctx, err
+	}
+	settings := bot.getGroupSettings(m.Chat.ID)
+	switch value {
+	case "off":
+		settings.BirthdayTip = 0
+		settings.BirthdayWhipRound = false
+	case "whipround":
+		settings.BirthdayWhipRound = true
+	default:
+		amount, err := GetAmount(value)
+		if err != nil || amount < 1 {
+			bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsHelpMessage"), strings.Join(i18n.LoadedLanguages(), ", ")))
+			return ctx, fmt.Errorf("invalid birthdays setting %s", value)
+		}
+		if settings.WalletUserId == 0 {
+			bot.trySendMessage(bot.topicOf(m), Translate(ctx, "groupSettingsCommissionNoWalletMessage"))
+			return ctx, fmt.Errorf("group %d has no group wallet", m.Chat.ID)
+		}
+		settings.BirthdayTip = amount
+	}
+	if err := bot.saveGroupSettings(settings); err != nil {
+		log.Errorf("[groupSettingsBirthdaysHandler] could not save settings of group %d: %v", m.Chat.ID, err)
+		return ctx, err
+	}
+	bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsBirthdaysMessage"), groupBirthdaysStr(ctx, settings)))
+	return ctx, nil
+}
+
+// groupBirthdaysStr shows the birthday celebrations in the group settings.
+func groupBirthdaysStr(ctx context.Context, settings *GroupSettings) string {
+	celebrations := make([]string, 0)
+	if settings.BirthdayTip > 0 {
+		celebrations = append(celebrations, fmt.Sprintf(Translate(ctx, "groupBirthdaysTipMessage"), settings.BirthdayTip))
+	}
+	if settings.BirthdayWhipRound {
+		celebrations = append(celebrations, Translate(ctx, "groupBirthdaysWhipRoundMessage"))
+	}
+	if len(celebrations) == 0 {
+		return "-"
+	}
+	return strings.Join(celebrations, ", ")
+}
@@ -0,0 +1,57 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestBirthday(t *testing.T) {
+	h := newTestHarness(t)
+	admin := &tb.User{ID: 9971, Username: "admin", FirstName: "Admin", LanguageCode: "en"}
+	bob := &tb.User{ID: 9972, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	treasurer := &tb.User{ID: 9973, Username: "treasurer", FirstName: "Treasurer", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9970, Type: tb.ChatGroup, Title: "friends"}
+	from := h.newUser(admin, 1000)
+	to := h.newUser(bob, 0)
+	wallet := h.newUser(treasurer, 1000)
+	h.telegram.admins = []*tb.User{admin}
+
+	h.sendMessage(bob, privateChat(bob), "/birthday 07-14")
+	if text := h.lastMessage(bob.ID).Text(); !strings.Contains(text, "July 14") {
+		t.Fatalf("message = %q", text)
+	}
+	h.sendMessage(bob, group, "/birthday")
+	h.sendMessage(admin, group, "/groupsettings wallet @treasurer")
+	h.sendMessage(admin, group, "/groupsettings birthdays 500")
+	h.sendMessage(admin, group, "/groupsettings birthdays whipround")
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "500 sat from the group wallet, whip-round") {
+		t.Fatalf("message = %q", text)
+	}
+
+	// nothing happens before the birthday
+	h.bot.celebrateBirthdays(time.Date(2026, 7, 13, 12, 0, 0, 0, time.UTC))
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 0 {
+		t.Fatalf("balance before the birthday = %d, want 0", balance)
+	}
+	birthday := time.Date(2026, 7, 14, 12, 0, 0, 0, time.UTC)
+	h.bot.celebrateBirthdays(birthday)
+	h.bot.celebrateBirthdays(birthday)
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if balance := h.lnbits.Balance(wallet.Wallet.ID); balance != 500 {
+		t.Errorf("balance of the group wallet = %d, want 500", balance)
+	}
+	celebration := h.lastMessage(group.ID)
+	if text := celebration.Text(); !strings.Contains(text, "Happy birthday @bob") || !strings.Contains(text, "500 sat") {
+		t.Fatalf("celebration = %q", text)
+	}
+	h.pressButton(admin, celebration, "🎂 100")
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 600 {
+		t.Errorf("balance of the birthday child = %d, want 600", balance)
+	}
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 900 {
+		t.Errorf("balance of the admin = %d, want 900", balance)
+	}
+}
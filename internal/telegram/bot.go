@@ -1,6 +1,7 @@
 package telegram
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
@@ -15,7 +16,10 @@ import (
 	"github.com/eko/gocache/store"
 
 	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/node"
+	"github.com/LightningTipBot/LightningTipBot/internal/satdress"
 	"github.com/LightningTipBot/LightningTipBot/internal/storage"
 	gocache "github.com/patrickmn/go-cache"
 	log "github.com/sirupsen/logrus"
@@ -24,15 +28,19 @@ import (
 
 type TipBot struct {
 	DB       *Databases
-	Bunt     *storage.DB
-	ShopBunt *storage.DB
+	Bunt     storage.Store
+	ShopBunt storage.Store
 	Telegram *tb.Bot
-	Client   *lnbits.Client
-	limiter  map[string]limiter.Limiter
+	Client   lnbits.WalletBackend
+	// Payments observes the outgoing payments of Client
+	Payments *lnbits.PaymentObserver
+	// Guard checks the outgoing payments of Client before they are sent
+	Guard   *lnbits.PaymentGuard
+	limiter map[string]limiter.Limiter
 	Cache
 }
 type Cache struct {
-	*store.GoCacheStore
+	store.StoreInterface
 }
 
 var (
@@ -42,26 +50,115 @@ var (
 
 // NewBot migrates data and creates a new bot
 func NewBot() TipBot {
-	gocacheClient := gocache.New(5*time.Minute, 10*time.Minute)
-	gocacheStore := store.NewGoCache(gocacheClient, nil)
-	// create sqlite databases
-	dbs := AutoMigration()
+	// open and migrate the databases
+	dbs := openDatabases()
+	bunt := createBunt(internal.Configuration.Database.BuntDbPath, "bunt")
+	shopBunt := createBunt(internal.Configuration.Database.ShopBuntDbPath, "shop_bunt")
+	err := migrateDatabases(dbs, bunt, shopBunt, "latest")
+	if err != nil {
+		panic(err)
+	}
+	// paid media that were stored without a paywall_key are sealed once a key is configured
+	if err := sealLegacyPaidMedia(dbs.Transactions); err != nil {
+		log.Errorf("[paywall] could not encrypt paid media: %v", err)
+	}
 	limiter.Start()
+	// the backend is created once, it subscribes to incoming payments
+	backend := newWalletBackend()
+	cache := Cache{StoreInterface: store.NewGoCache(gocache.New(5*time.Minute, 10*time.Minute), nil)}
+	if internal.Configuration.Cluster.Enabled {
+		// the state of this instance is shared with the other instances of the cluster
+		log.Infof("[Cluster] Running as instance %s", internal.Configuration.Cluster.InstanceId)
+		mutex.SetLocker(mutex.NewDatabaseLocker(dbs.Users, internal.Configuration.Cluster.InstanceId))
+		storage.DisableObjectCache()
+		registerCacheTypes()
+		cache = Cache{StoreInterface: storage.NewSharedCache(dbs.Users)}
+	} else {
+		// balances only change through payments that this instance handles
+		backend = lnbits.NewBalanceCache(backend)
+	}
+	guard := lnbits.NewPaymentGuard(lnbits.NewNetworkGuard(backend, internal.Configuration.Bot.Network))
+	payments := lnbits.NewPaymentObserver(guard)
 	return TipBot{
 		DB:       dbs,
-		Client:   lnbits.NewClient(internal.Configuration.Lnbits.AdminKey, internal.Configuration.Lnbits.Url),
-		Bunt:     createBunt(internal.Configuration.Database.BuntDbPath),
-		ShopBunt: createBunt(internal.Configuration.Database.ShopBuntDbPath),
+		Client:   lnbits.NewCircuitBreaker(payments),
+		Payments: payments,
+		Guard:    guard,
+		Bunt:     bunt,
+		ShopBunt: shopBunt,
 		Telegram: newTelegramBot(),
-		Cache:    Cache{GoCacheStore: gocacheStore},
+		Cache:    cache,
+	}
+}
+
+// registerCacheTypes registers the types of all values the bot puts into its cache.
+func registerCacheTypes() {
+	storage.RegisterCacheTypes(
+		"",
+		0,
+		&lnbits.User{},
+		&GroupSettings{},
+		&TopicSettings{},
+		[]tb.ChatMember{},
+		&tb.Message{},
+		ShopView{},
+		TransactionsList{},
+		ThreadPosters{},
+		RecentMembers{},
+		WalletActivity{},
+		TipMedia{},
+		int64(0),
+		satdress.CheckInvoiceParams{},
+		InlineSend{}, &InlineSend{},
+		InlineReceive{}, &InlineReceive{},
+		InlineFaucet{}, &InlineFaucet{},
+		InlineTipjar{}, &InlineTipjar{},
+	)
+}
+
+// newWalletBackend connects to LNbits or, if configured, directly to a Lightning node.
+func newWalletBackend() lnbits.WalletBackend {
+	switch internal.Configuration.Node.Backend {
+	case internal.NodeBackendLnd, internal.NodeBackendCln:
+		return node.New(internal.Configuration.Node)
 	}
+	client := lnbits.NewClient(internal.Configuration.Lnbits.AdminKey, internal.Configuration.Lnbits.Url)
+	client.AdminID = internal.Configuration.Lnbits.AdminId
+	if version, ok := lnbits.ParseAPIVersion(internal.Configuration.Lnbits.ApiVersion); ok {
+		client.SetAPIVersion(version)
+	} else {
+		client.DetectAPIVersion()
+	}
+	if internal.Configuration.Lnbits.PaymentStream {
+		client.EnableStream()
+	}
+	return client
+}
+
+// client returns the wallet backend that traces its calls as part of the update in ctx.
+func (bot TipBot) client(ctx context.Context) lnbits.WalletBackend {
+	return lnbits.WithTrace(ctx, bot.Client)
+}
+
+// walletBackendAvailable returns false while the circuit breaker of the wallet backend is open.
+func (bot TipBot) walletBackendAvailable() bool {
+	breaker, ok := bot.Client.(*lnbits.CircuitBreaker)
+	return !ok || !breaker.Open()
 }
 
 // newTelegramBot will create a new Telegram bot.
 func newTelegramBot() *tb.Bot {
+	var poller tb.Poller = &tb.LongPoller{Timeout: 60 * time.Second}
+	if len(internal.Configuration.Telegram.WebhookUrl) > 0 {
+		// several instances can receive updates behind a load balancer
+		poller = &tb.Webhook{
+			Listen:   internal.Configuration.Telegram.WebhookListen,
+			Endpoint: &tb.WebhookEndpoint{PublicURL: internal.Configuration.Telegram.WebhookUrl},
+		}
+	}
 	tgb, err := tb.NewBot(tb.Settings{
 		Token:     internal.Configuration.Telegram.ApiKey,
-		Poller:    &tb.LongPoller{Timeout: 60 * time.Second},
+		Poller:    poller,
 		ParseMode: tb.ModeMarkdown,
 		Verbose:   false,
 	})
@@ -84,29 +181,6 @@ func (bot TipBot) initBotWallet() error {
 	return nil
 }
 
-// GracefulShutdown will gracefully shutdown the bot
-// It will wait for all mutex locks to unlock before shutdown.
-func (bot *TipBot) GracefulShutdown() {
-	t := time.NewTicker(time.Second * 10)
-	log.Infof("[shutdown] Graceful shutdown (timeout=10s).")
-	for {
-		select {
-		case <-t.C:
-			// timer expired
-			log.Infof("[shutdown] Graceful shutdown timeout reached. Forcing shutdown.")
-			return
-		default:
-			// check if all mutex locks are unlocked
-			if mutex.IsEmpty() {
-				log.Infof("[shutdown] Graceful shutdown successful.")
-				return
-			}
-		}
-		time.Sleep(time.Second)
-		log.Tracef("[shutdown] Trying graceful shutdown...")
-	}
-}
-
 // Start will initialize the Telegram bot and lnbits.
 func (bot *TipBot) Start() {
 	log.Infof("[Telegram] Authorized on account @%s", bot.Telegram.Me.Username)
@@ -129,6 +203,17 @@ func (bot *TipBot) Start() {
 	// register callbacks for invoices
 	initInvoiceEventCallbacks(bot)
 
+	// handle payments that the wallet backend receives
+	bot.Client.Subscribe(bot.handleIncomingPayment)
+	// and the payments that it sends
+	bot.Payments.OnPayment(bot.checkAlerts)
+	bot.Payments.OnPayment(bot.roundUpPayment)
+	// the receiver of a tip can't be asked for it back once it's spent
+	bot.Payments.OnPayment(bot.closeSpentTipUndos)
+	// pause wallets with suspicious activity before they pay
+	bot.Guard.BeforePayment(bot.checkAnomaly)
+	bot.Payments.OnPayment(bot.recordWalletActivity)
+
 	// register callbacks for user state changes
 	initializeStateCallbackMessage(bot)
 
@@ -136,11 +221,70 @@ func (bot *TipBot) Start() {
 	go bot.Telegram.Start()
 
 	go bot.restartPersistedTickets()
+	go bot.restartDeletionTimers()
+
+	// retry outgoing payments that failed temporarily
+	bot.startPaymentRetryWorker()
+	// resume the payouts that a restart interrupted
+	bot.startPayoutWorker()
+	// pay recurring donations that are due
+	bot.startRecurringDonationWorker()
+	// close donation matching campaigns that ran out
+	bot.startMatchingCampaignWorker()
+	// donate the round-ups of outgoing payments every week
+	bot.startRoundUpWorker()
+	bot.startSubscriptionWorker()
+	// update the countdowns of drops and release them
+	bot.startDropWorker()
+	// post the weekly recaps of groups
+	bot.startGroupRecapWorker()
+	// celebrate the birthdays of group members
+	bot.startBirthdayWorker()
+	// expire the spending proposals of group wallets that were not decided in time
+	bot.startSpendProposalWorker()
+	// expire the operator payments that no second admin confirmed in time
+	bot.startOperatorPaymentWorker()
+	// send the daily digests of notifications
+	bot.startNotificationDigestWorker()
+	// refund gifts, pools, escrowed sends and tips to inactive users that expired
+	bot.startClaimExpiryWorker()
+	// settle lost invoice payments and resolve stuck outgoing payments
+	bot.startReconcileWorker()
+	// answer the requests of nostr wallet connect clients
+	bot.startNwcWorker()
+	// alert the admin chat when the reserve or the channels of the node run low
+	bot.startLiquidityWorker()
+	// reload the configuration on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	// gracefully shutdown
 	exit := make(chan os.Signal, 1) // we need to reserve to buffer size 1, so the notifier are not blocked
 	// we need to catch SIGTERM and SIGSTOP
 	signal.Notify(exit, os.Interrupt, syscall.SIGTERM, syscall.SIGSTOP)
-	<-exit
-	// gracefully shutdown
-	bot.GracefulShutdown()
+	for {
+		select {
+		case <-reload:
+			err := bot.ReloadConfiguration()
+			if err != nil {
+				log.Errorf("[Config] could not reload configuration: %v", err)
+			}
+			bot.Audit("SIGHUP", AuditActionConfigReload, "", errorDetails(err))
+		case <-exit:
+			// gracefully shutdown
+			bot.GracefulShutdown()
+			return
+		}
+	}
+}
+
+// ReloadConfiguration applies changes of config.yaml and of the translation files
+// without a restart. Users keep their sessions.
+func (bot *TipBot) ReloadConfiguration() error {
+	err := internal.Reload()
+	if err != nil {
+		return err
+	}
+	limiter.Reload()
+	i18n.Reload()
+	return nil
 }
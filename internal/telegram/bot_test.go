@@ -0,0 +1,60 @@
+package telegram
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/database"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits/lnbitstest"
+	"github.com/eko/gocache/store"
+	gocache "github.com/patrickmn/go-cache"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+	"gorm.io/gorm"
+)
+
+// newTestBot returns a bot with fresh databases in a temporary directory that
+// talks to a fake LNbits.
+func newTestBot(t *testing.T) (*TipBot, *lnbitstest.Server) {
+	t.Helper()
+	server := lnbitstest.NewServer()
+	t.Cleanup(server.Close)
+	dir := t.TempDir()
+	open := func(name string) *gorm.DB {
+		db, err := database.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	dbs := &Databases{Users: open("users.db"), Transactions: open("transactions.db"), Groups: open("groups.db")}
+	bunt := createBunt(filepath.Join(dir, "bunt.db"), "bunt")
+	shopBunt := createBunt(filepath.Join(dir, "shop_bunt.db"), "shop_bunt")
+	if err := migrateDatabases(dbs, bunt, shopBunt, "latest"); err != nil {
+		t.Fatal(err)
+	}
+	bot := &TipBot{
+		DB:       dbs,
+		Bunt:     bunt,
+		ShopBunt: shopBunt,
+		Client:   server.Client(),
+		Cache:    Cache{StoreInterface: store.NewGoCache(gocache.New(5*time.Minute, 10*time.Minute), nil)},
+	}
+	return bot, server
+}
+
+// newTestUser creates a user with a wallet that holds balance sat.
+func newTestUser(t *testing.T, bot *TipBot, server *lnbitstest.Server, telegramUser *tb.User, balance int64) *lnbits.User {
+	t.Helper()
+	user, err := bot.CreateWalletForTelegramUser(telegramUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance > 0 {
+		if err := server.Fund(user.Wallet.ID, balance); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
@@ -100,7 +100,7 @@ func (bot *TipBot) mainMenuBalanceButtonUpdate(to int64) {
 		amount, err := bot.GetUserBalanceCached(user)
 		if err == nil {
 			log.Tracef("[appendMainMenu] user %s balance %d sat", GetUserStr(user.Telegram), amount)
-			MainMenuCommandBalance := fmt.Sprintf("%s %d sat", MainMenuCommandBalance, amount)
+			MainMenuCommandBalance := fmt.Sprintf("%s %s", MainMenuCommandBalance, bot.formatAmount(user, amount))
 			btnBalanceMainMenu = mainMenu.Text(MainMenuCommandBalance)
 		}
 
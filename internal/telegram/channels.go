@@ -0,0 +1,207 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// channelStatsPosts is the number of posts that /channel stats lists.
+const channelStatsPosts = 10
+
+// Channel is a Telegram channel whose posts can be tipped in the comments, the discussion
+// group of the channel. Tips go to the owner unless the author of the post is known.
+type Channel struct {
+	ID           int64     `json:"id" gorm:"primaryKey"`
+	Title        string    `json:"title"`
+	Username     string    `json:"username"`
+	OwnerId      int64     `json:"owner_id" gorm:"index"`
+	DiscussionId int64     `json:"discussion_id" gorm:"index"`
+	CreatedAt    time.Time `json:"created"`
+	UpdatedAt    time.Time `json:"updated"`
+}
+
+// ChannelAuthor maps the signature of posts in a channel to the user who wrote them.
+type ChannelAuthor struct {
+	ChannelId int64  `json:"channel_id" gorm:"primaryKey"`
+	Signature string `json:"signature" gorm:"primaryKey"`
+	UserId    int64  `json:"user_id"`
+}
+
+// ChannelPostEarnings are the tips that a post of a channel earned.
+type ChannelPostEarnings struct {
+	Post   int
+	Tips   int64
+	Volume int64
+}
+
+// channelPost returns the channel and the id of the post in the channel if m is a post that
+// Telegram copied into the discussion group of the channel.
+func channelPost(m *tb.Message) (channelId int64, postId int, ok bool) {
+	if m == nil || !m.AutomaticForward || m.SenderChat == nil || m.SenderChat.Type != tb.ChatChannel {
+		return 0, 0, false
+	}
+	return m.SenderChat.ID, m.OriginalMessageID, true
+}
+
+// channelPostLink links to a post of a channel.
+func channelPostLink(channel *Channel, post int) string {
+	if len(channel.Username) > 0 {
+		return fmt.Sprintf("https://t.me/%s/%d", channel.Username, post)
+	}
+	// private channels have ids like -100<id>
+	return fmt.Sprintf("https://t.me/c/%s/%d", strings.TrimPrefix(strconv.FormatInt(channel.ID, 10), "-100"), post)
+}
+
+func (bot *TipBot) getChannel(channelId int64) (*Channel, error) {
+	channel := &Channel{}
+	tx := bot.DB.Groups.Where("id = ?", channelId).First(channel)
+	return channel, tx.Error
+}
+
+// channelTipRecipient returns who receives the tips on a post: the author of the post if the
+// owner told the bot who signs as whom, the owner of the channel otherwise.
+func (bot *TipBot) channelTipRecipient(channel *Channel, post *tb.Message) (*lnbits.User, error) {
+	recipient := channel.OwnerId
+	signature := post.OriginalSignature
+	if len(signature) == 0 {
+		// messages posted as the channel in groups
+		signature = post.Signature
+	}
+	if len(signature) > 0 {
+		author := &ChannelAuthor{}
+		if tx := bot.DB.Groups.Where("channel_id = ? AND signature = ?", channel.ID, signature).First(author); tx.Error == nil {
+			recipient = author.UserId
+		}
+	}
+	// GetLnbitsUser doesn't overwrite the stored user with the partial one
+	return GetLnbitsUser(&tb.User{ID: recipient}, *bot)
+}
+
+// channelPostEarnings lists the posts of a channel that earned the most.
+func (bot *TipBot) channelPostEarnings(channelId int64, limit int) ([]ChannelPostEarnings, error) {
+	var earnings []ChannelPostEarnings
+	tx := bot.DB.Transactions.Model(&Transaction{}).
+		Select("channel_post AS post, COUNT(*) AS tips, SUM(amount) AS volume").
+		Where("channel_id = ? AND success = ?", channelId, true).
+		Group("channel_post").Order("volume desc").Limit(limit).Scan(&earnings)
+	return earnings, tx.Error
+}
+
+// channelHandler handles /channel link|author|stats.
+func (bot *TipBot) channelHandler(ctx intercept.Context) (intercept.Context, error) {
+	args := strings.Fields(ctx.Message().Text)
+	if len(args) > 1 {
+		switch strings.ToLower(args[1]) {
+		case "link":
+			return bot.channelLinkHandler(ctx)
+		case "author":
+			return bot.channelAuthorHandler(ctx)
+		case "stats":
+			return bot.channelStatsHandler(ctx)
+		}
+	}
+	bot.trySendMessage(ctx.Message().Sender, Translate(ctx, "channelHelpMessage"))
+	return ctx, nil
+}
+
+// channelLinkHandler links a channel to its owner. The owner sends /channel link in the comments
+// of a post, the bot has to be an admin of the channel to check the owner.
+func (bot *TipBot) channelLinkHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	NewMessage(m, WithDuration(0, bot))
+	channelId, _, ok := channelPost(m.ReplyTo)
+	if !ok {
+		bot.trySendMessage(m.Sender, Translate(ctx, "channelHelpMessage"))
+		return ctx, fmt.Errorf("[/channel link] not in the comments of a channel post")
+	}
+	channelChat := m.ReplyTo.SenderChat
+	if !bot.isAdmin(channelChat, m.Sender) {
+		bot.trySendMessage(m.Sender, Translate(ctx, "channelNotAdminMessage"))
+		return ctx, fmt.Errorf("[/channel link] %s is not admin of channel %d", GetUserStr(m.Sender), channelId)
+	}
+	channel := &Channel{ID: channelId}
+	bot.DB.Groups.Where("id = ?", channelId).First(channel)
+	channel.Title = channelChat.Title
+	channel.Username = channelChat.Username
+	channel.OwnerId = m.Sender.ID
+	channel.DiscussionId = m.Chat.ID
+	if tx := bot.DB.Groups.Save(channel); tx.Error != nil {
+		log.Errorf("[/channel link] could not save channel %d: %v", channelId, tx.Error)
+		return ctx, tx.Error
+	}
+	log.Infof("[/channel link] %s linked channel %s (%d)", GetUserStr(m.Sender), channel.Title, channelId)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "channelLinkedMessage"), str.MarkdownEscape(channel.Title)))
+	return ctx, nil
+}
+
+// channelAuthorHandler sends the tips on posts signed with a signature to a user:
+// /channel author @user <signature>. Without signature, the mapping of the user is removed.
+func (bot *TipBot) channelAuthorHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	NewMessage(m, WithDuration(0, bot))
+	channel := &Channel{}
+	if tx := bot.DB.Groups.Where("discussion_id = ? AND owner_id = ?", m.Chat.ID, m.Sender.ID).First(channel); tx.Error != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "channelNotLinkedMessage"))
+		return ctx, tx.Error
+	}
+	args := strings.Fields(m.Text)
+	if len(args) < 3 || !strings.HasPrefix(args[2], "@") {
+		bot.trySendMessage(m.Sender, Translate(ctx, "channelHelpMessage"))
+		return ctx, fmt.Errorf("[/channel author] invalid syntax")
+	}
+	author, err := GetUserByTelegramUsername(strings.TrimPrefix(args[2], "@"), *bot)
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape(args[2])))
+		return ctx, err
+	}
+	signature := strings.TrimSpace(strings.SplitN(m.Text, args[2], 2)[1])
+	if len(signature) == 0 {
+		bot.DB.Groups.Where("channel_id = ? AND user_id = ?", channel.ID, author.Telegram.ID).Delete(&ChannelAuthor{})
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "channelAuthorRemovedMessage"), str.MarkdownEscape(args[2])))
+		return ctx, nil
+	}
+	if tx := bot.DB.Groups.Save(&ChannelAuthor{ChannelId: channel.ID, Signature: signature, UserId: author.Telegram.ID}); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "channelAuthorMessage"), str.MarkdownEscape(signature), str.MarkdownEscape(args[2])))
+	return ctx, nil
+}
+
+// channelStatsHandler sends the owner of channels the posts that earned the most tips.
+func (bot *TipBot) channelStatsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if !m.Private() {
+		NewMessage(m, WithDuration(0, bot))
+	}
+	var channels []Channel
+	bot.DB.Groups.Where("owner_id = ?", m.Sender.ID).Find(&channels)
+	if len(channels) == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "channelNotLinkedMessage"))
+		return ctx, nil
+	}
+	message := ""
+	for i := range channels {
+		channel := &channels[i]
+		earnings, err := bot.channelPostEarnings(channel.ID, channelStatsPosts)
+		if err != nil {
+			return ctx, err
+		}
+		message += fmt.Sprintf(Translate(ctx, "channelStatsChannelMessage"), str.MarkdownEscape(channel.Title))
+		if len(earnings) == 0 {
+			message += Translate(ctx, "channelStatsNoTipsMessage")
+		}
+		for _, post := range earnings {
+			message += fmt.Sprintf(Translate(ctx, "channelStatsPostMessage"), channelPostLink(channel, post.Post), post.Volume, post.Tips)
+		}
+	}
+	bot.trySendMessage(m.Sender, message, tb.NoPreview)
+	return ctx, nil
+}
@@ -0,0 +1,72 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestChannelComments(t *testing.T) {
+	h := newTestHarness(t)
+	owner := &tb.User{ID: 9951, Username: "owner", FirstName: "Owner", LanguageCode: "en"}
+	writer := &tb.User{ID: 9952, Username: "writer", FirstName: "Writer", LanguageCode: "en"}
+	reader := &tb.User{ID: 9953, Username: "reader", FirstName: "Reader", LanguageCode: "en"}
+	ownerUser := h.newUser(owner, 0)
+	writerUser := h.newUser(writer, 0)
+	h.newUser(reader, 1000)
+	channel := &tb.Chat{ID: -1009950, Type: tb.ChatChannel, Title: "news", Username: "news"}
+	comments := &tb.Chat{ID: -1009951, Type: tb.ChatSuperGroup, Title: "news chat"}
+	// Telegram copies the posts of the channel into the comments
+	telegramUser := &tb.User{ID: 777000, FirstName: "Telegram"}
+	post := &tb.Message{ID: 501, Chat: comments, Sender: telegramUser, SenderChat: channel, AutomaticForward: true, OriginalMessageID: 42}
+	signed := &tb.Message{ID: 502, Chat: comments, Sender: telegramUser, SenderChat: channel, AutomaticForward: true, OriginalMessageID: 43, OriginalSignature: "W. Riter"}
+
+	// channels that nobody linked can't be tipped
+	h.sendReply(reader, comments, "/tip 10", post)
+	if text := h.lastMessage(reader.ID).Text(); !strings.Contains(text, "doesn't accept tips") {
+		t.Fatalf("tip on an unlinked channel: %q", text)
+	}
+
+	// only admins of the channel can link it
+	h.sendReply(reader, comments, "/channel link", post)
+	if _, err := h.bot.getChannel(channel.ID); err == nil {
+		t.Fatal("a reader linked the channel")
+	}
+	h.telegram.admins = []*tb.User{owner}
+	h.sendReply(owner, comments, "/channel link", post)
+	if linked, err := h.bot.getChannel(channel.ID); err != nil || linked.OwnerId != owner.ID || linked.DiscussionId != comments.ID {
+		t.Fatalf("channel = %+v, %v", linked, err)
+	}
+
+	// tips go to the owner, or to the author of signed posts
+	h.sendReply(owner, comments, "/channel author @writer W. Riter", post)
+	h.sendReply(reader, comments, "/tip 10", post)
+	h.sendReply(reader, comments, "/tip 20", signed)
+	h.sendReply(reader, comments, "/tip 5", signed)
+	if balance := h.lnbits.Balance(ownerUser.Wallet.ID); balance != 10 {
+		t.Errorf("balance of the owner = %d, want 10", balance)
+	}
+	if balance := h.lnbits.Balance(writerUser.Wallet.ID); balance != 25 {
+		t.Errorf("balance of the writer = %d, want 25", balance)
+	}
+	attributed := false
+	for _, request := range h.sent {
+		if request.ChatId() == writer.ID && strings.Contains(request.Text(), "https://t.me/news/43") {
+			attributed = true
+		}
+	}
+	if !attributed {
+		t.Error("the writer was not told which post was tipped")
+	}
+
+	// the owner sees what each post earned
+	earnings, err := h.bot.channelPostEarnings(channel.ID, channelStatsPosts)
+	if err != nil || len(earnings) != 2 || earnings[0].Post != 43 || earnings[0].Volume != 25 || earnings[0].Tips != 2 {
+		t.Fatalf("earnings = %+v, %v", earnings, err)
+	}
+	h.sendMessage(owner, privateChat(owner), "/channel stats")
+	if text := h.lastMessage(owner.ID).Text(); !strings.Contains(text, "https://t.me/news/42") || !strings.Contains(text, "25 sat in 2 tips") {
+		t.Errorf("stats = %q", text)
+	}
+}
@@ -0,0 +1,293 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// claimExpiryInterval is how often expired claims are refunded. Claims have timers for their
+// expiry too, the worker refunds the claims whose timers were lost in a restart or whose
+// refunds failed.
+const claimExpiryInterval = 10 * time.Minute
+
+const (
+	ClaimTypeGift    = "gift"
+	ClaimTypeEscrow  = "escrow"
+	ClaimTypePool    = "pool"
+	ClaimTypeVoucher = "voucher"
+	ClaimTypeTip     = "tip" // tips to users who never started the bot
+	ClaimTypeGame    = "game"
+	ClaimTypeQuiz    = "quiz"
+	ClaimTypeBet     = "bet"
+)
+
+// claimTypes are the types of claims in the order of the report.
+var claimTypes = []string{ClaimTypeGift, ClaimTypeEscrow, ClaimTypePool, ClaimTypeVoucher, ClaimTypeTip, ClaimTypeGame, ClaimTypeQuiz, ClaimTypeBet}
+
+var (
+	adminClaimsMessage        = "*Pending claims*"
+	adminClaimsTypeMessage    = "\n%s: %d (%d sat)"
+	adminClaimsExpiredMessage = "\n⌛️ Expired, not refunded yet: %d (%d sat)"
+	adminClaimsEscrowMessage  = "\n\n🏦 Escrow wallet: %d sat for %d sat of claims (%s)"
+	adminClaimsTypeNames      = map[string]string{
+		ClaimTypeGift:    "🎁 Gifts",
+		ClaimTypeEscrow:  "🔒 Sends to users without a wallet",
+		ClaimTypePool:    "🎯 Pools",
+		ClaimTypeVoucher: "🎟 Vouchers",
+		ClaimTypeTip:     "🏅 Tips to inactive users",
+		ClaimTypeGame:    "🎲 Stakes of games",
+		ClaimTypeQuiz:    "🧠 Prize pools of quizzes",
+		ClaimTypeBet:     "🤝 Stakes of bets",
+	}
+)
+
+// PendingClaim is an amount that waits for someone to claim it. When it expires, it goes back
+// to Owner. All claims but tips to inactive users are held in the escrow wallet. Faucets and
+// tipjars hold nothing, they pay from the wallet of their creator when someone collects.
+type PendingClaim struct {
+	Type    string
+	ID      string
+	Amount  int64
+	Owner   *tb.User
+	Expires time.Time // zero if the claim doesn't expire, like vouchers
+	expire  func()
+}
+
+// Expired returns true if the claim should have been refunded at now.
+func (claim PendingClaim) Expired(now time.Time) bool {
+	return !claim.Expires.IsZero() && now.After(claim.Expires)
+}
+
+// pendingClaims returns all amounts that wait to be claimed.
+func (bot *TipBot) pendingClaims() []PendingClaim {
+	claims := make([]PendingClaim, 0)
+	bot.Bunt.Ascend("gift", func(key, value string) bool {
+		gift := &Gift{}
+		if err := json.Unmarshal([]byte(value), gift); err == nil && gift.Base != nil && gift.Active {
+			id := gift.ID
+			claims = append(claims, PendingClaim{Type: ClaimTypeGift, ID: id, Amount: gift.Amount, Owner: gift.Creator.Telegram, Expires: gift.Expires,
+				expire: func() { bot.expireGift(id) }})
+		}
+		return true // continue iteration
+	})
+	bot.Bunt.Ascend("escrow", func(key, value string) bool {
+		escrowed := &EscrowedSend{}
+		if err := json.Unmarshal([]byte(value), escrowed); err == nil && escrowed.Base != nil && escrowed.Active {
+			id := escrowed.ID
+			claims = append(claims, PendingClaim{Type: ClaimTypeEscrow, ID: id, Amount: escrowed.Amount, Owner: escrowed.From.Telegram, Expires: escrowed.Expires,
+				expire: func() { bot.expireEscrow(id) }})
+		}
+		return true // continue iteration
+	})
+	bot.Bunt.Ascend("pool", func(key, value string) bool {
+		pool := &Pool{}
+		if err := json.Unmarshal([]byte(value), pool); err == nil && pool.Base != nil && pool.Active {
+			id := pool.ID
+			claims = append(claims, PendingClaim{Type: ClaimTypePool, ID: id, Amount: pool.Collected, Owner: pool.Creator.Telegram, Expires: pool.Expires,
+				expire: func() { bot.expirePool(id) }})
+		}
+		return true // continue iteration
+	})
+	bot.Bunt.Ascend("game", func(key, value string) bool {
+		game := &Game{}
+		if err := json.Unmarshal([]byte(value), game); err == nil && game.Base != nil && game.Active {
+			id := game.ID
+			amount := game.Amount
+			if game.Accepted {
+				amount *= 2
+			}
+			claims = append(claims, PendingClaim{Type: ClaimTypeGame, ID: id, Amount: amount, Owner: game.Challenger.Telegram, Expires: game.Expires,
+				expire: func() { bot.expireGame(id) }})
+		}
+		return true // continue iteration
+	})
+	bot.Bunt.Ascend("quiz", func(key, value string) bool {
+		quiz := &Quiz{}
+		// quizzes that were not started hold nothing
+		if err := json.Unmarshal([]byte(value), quiz); err == nil && quiz.Base != nil && quiz.Active && quiz.Started {
+			id := quiz.ID
+			claims = append(claims, PendingClaim{Type: ClaimTypeQuiz, ID: id, Amount: quiz.Pot - quiz.Paid, Owner: quiz.Host.Telegram, Expires: quiz.Expires,
+				expire: func() { bot.expireQuiz(id) }})
+		}
+		return true // continue iteration
+	})
+	bot.Bunt.Ascend("bet", func(key, value string) bool {
+		bet := &Bet{}
+		if err := json.Unmarshal([]byte(value), bet); err == nil && bet.Base != nil && bet.Active {
+			id := bet.ID
+			amount := bet.Amount
+			if bet.Accepted {
+				amount *= 2
+			}
+			claims = append(claims, PendingClaim{Type: ClaimTypeBet, ID: id, Amount: amount, Owner: bet.Challenger.Telegram, Expires: bet.Expires,
+				expire: func() { bot.expireBet(id) }})
+		}
+		return true // continue iteration
+	})
+	var batches []*VoucherBatch
+	bot.Bunt.Ascend("voucher-batch", func(key, value string) bool {
+		batch := &VoucherBatch{}
+		if err := json.Unmarshal([]byte(value), batch); err == nil && batch.Base != nil && batch.Active {
+			batches = append(batches, batch)
+		}
+		return true // continue iteration
+	})
+	for _, batch := range batches {
+		for _, voucher := range bot.loadVouchers(batch) {
+			if voucher.Active && voucher.ClaimedAt.IsZero() {
+				claims = append(claims, PendingClaim{Type: ClaimTypeVoucher, ID: voucher.ID, Amount: voucher.Amount, Owner: batch.Creator.Telegram})
+			}
+		}
+	}
+	return append(claims, bot.inactiveTipClaims()...)
+}
+
+func tipExpiryKey(transactionID uint) string {
+	return fmt.Sprintf("tip-expiry:%d", transactionID)
+}
+
+// inactiveTipClaims returns the tips to users who didn't start the bot yet. The tips are in the
+// wallets of the receivers, they go back to the sender if the receiver doesn't start the bot
+// in time. Undone tips and tips that were refunded are left out.
+func (bot *TipBot) inactiveTipClaims() []PendingClaim {
+	claims := make([]PendingClaim, 0)
+	var receivers []int64
+	tx := bot.DB.Users.Model(&lnbits.User{}).Where("initialized = ? AND wallet_id != ''", false).Pluck("telegram_id", &receivers)
+	if tx.Error != nil || len(receivers) == 0 {
+		return claims
+	}
+	var done []string
+	bot.DB.Transactions.Model(&Transaction{}).
+		Where("success = ? AND (idempotency_key LIKE ? OR idempotency_key LIKE ?)", true, "tip-expiry:%", "undo_tip:%").
+		Pluck("idempotency_key", &done)
+	settled := make(map[string]bool, len(done))
+	for _, key := range done {
+		settled[key] = true
+	}
+	// chunks keep the queries below the limit of variables of the database
+	for len(receivers) > 0 {
+		chunk := receivers
+		if len(chunk) > 500 {
+			chunk = chunk[:500]
+		}
+		receivers = receivers[len(chunk):]
+		var tips []Transaction
+		bot.DB.Transactions.Where("to_id IN ? AND type IN ? AND success = ?", chunk, []string{"tip", "tipall"}, true).Find(&tips)
+		for _, tip := range tips {
+			if settled[tipExpiryKey(tip.ID)] || settled[fmt.Sprintf("undo_tip:%d", tip.ID)] {
+				continue
+			}
+			tip := tip
+			claims = append(claims, PendingClaim{Type: ClaimTypeTip, ID: fmt.Sprint(tip.ID), Amount: tip.Amount, Owner: &tb.User{ID: tip.FromId},
+				Expires: tip.Time.Add(escrowDuration()),
+				expire:  func() { bot.refundInactiveTip(&tip) }})
+		}
+	}
+	return claims
+}
+
+// refundInactiveTip sends a tip back to its sender if the receiver didn't start the bot.
+func (bot *TipBot) refundInactiveTip(tip *Transaction) {
+	to, err := GetUser(&tb.User{ID: tip.ToId}, *bot)
+	if err != nil || to.Wallet == nil || to.Initialized {
+		return
+	}
+	from, err := GetUser(&tb.User{ID: tip.FromId}, *bot)
+	if err != nil || from.Wallet == nil {
+		log.Errorf("[🏅 tip expiry] could not refund tip %d: %v", tip.ID, err)
+		return
+	}
+	// nobody can spend from the wallet, but the balance could be lower after a failed payment
+	balance, err := bot.GetUserBalance(to)
+	if err != nil {
+		return
+	}
+	amount := tip.Amount
+	if balance < amount {
+		amount = balance
+	}
+	if amount < 1 {
+		return
+	}
+	t := NewTransaction(bot, to, from, amount, TransactionType("tip refund"), TransactionIdempotencyKey(tipExpiryKey(tip.ID)))
+	t.Memo = fmt.Sprintf("↩️ Refund of the tip of %s to %s, who didn't start the bot.", GetUserStr(from.Telegram), GetUserStr(to.Telegram))
+	success, err := t.Send()
+	if !success {
+		if err != errDuplicateOperation {
+			log.Errorf("[🏅 tip expiry] could not refund tip %d: %v", tip.ID, err)
+		}
+		return
+	}
+	log.Infof("[🏅 tip expiry] refunded %d sat of tip %d to %s", amount, tip.ID, GetUserStr(from.Telegram))
+	bot.notify(from, NotificationPayment, amount, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "tipExpiredMessage"), amount, str.MarkdownEscape(tip.ToUser), int64(escrowDuration().Hours()/24)))
+}
+
+// expireClaims refunds all claims that expired.
+func (bot *TipBot) expireClaims(now time.Time) {
+	for _, claim := range bot.pendingClaims() {
+		if claim.Expired(now) && claim.expire != nil {
+			claim.expire()
+		}
+	}
+}
+
+// startClaimExpiryWorker refunds expired claims, right away for the claims that expired while
+// the bot was down and then every claimExpiryInterval.
+func (bot *TipBot) startClaimExpiryWorker() {
+	go func() {
+		for {
+			if bot.walletBackendAvailable() && !isShuttingDown() {
+				bot.expireClaims(time.Now())
+			}
+			time.Sleep(claimExpiryInterval)
+		}
+	}()
+}
+
+// ClaimsReport sums up the pending claims, the liabilities that the bot holds for others.
+func (bot *TipBot) ClaimsReport(now time.Time) string {
+	counts, amounts := map[string]int64{}, map[string]int64{}
+	var expired, expiredAmount, escrowed int64
+	for _, claim := range bot.pendingClaims() {
+		counts[claim.Type]++
+		amounts[claim.Type] += claim.Amount
+		if claim.Expired(now) {
+			expired++
+			expiredAmount += claim.Amount
+		}
+		if claim.Type != ClaimTypeTip {
+			escrowed += claim.Amount
+		}
+	}
+	var b strings.Builder
+	b.WriteString(adminClaimsMessage)
+	for _, claimType := range claimTypes {
+		fmt.Fprintf(&b, adminClaimsTypeMessage, adminClaimsTypeNames[claimType], counts[claimType], amounts[claimType])
+	}
+	fmt.Fprintf(&b, adminClaimsExpiredMessage, expired, expiredAmount)
+	if escrow, err := bot.EscrowWallet(); err == nil {
+		if balance, err := bot.GetUserBalance(escrow); err == nil {
+			status := "✅ covered"
+			if balance < escrowed {
+				status = fmt.Sprintf("⚠️ %d sat short", escrowed-balance)
+			}
+			fmt.Fprintf(&b, adminClaimsEscrowMessage, balance, escrowed, status)
+		}
+	}
+	return b.String()
+}
+
+// adminClaimsHandler sends the report of the pending claims to the operator.
+func (bot *TipBot) adminClaimsHandler(ctx intercept.Context) (intercept.Context, error) {
+	bot.trySendMessage(ctx.Message().Sender, bot.ClaimsReport(time.Now()))
+	return ctx, nil
+}
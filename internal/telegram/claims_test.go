@@ -0,0 +1,46 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestClaimExpiry(t *testing.T) {
+	h := newTestHarness(t)
+	h.newUser(testBotUser, 0)
+	alice := &tb.User{ID: 9995, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	inactive := &tb.User{ID: 9996, Username: "inactive", FirstName: "Inactive", LanguageCode: "en"}
+	from := h.newUser(alice, 1000)
+	group := &tb.Chat{ID: -9995, Type: tb.ChatGroup, Title: "group"}
+
+	// inactive never started the bot, the tip creates a wallet for them
+	post := h.sendMessage(inactive, group, "gm")
+	h.sendReply(alice, group, "/tip 100", post)
+	h.sendMessage(alice, privateChat(alice), "/gift 200")
+
+	report := h.bot.ClaimsReport(time.Now())
+	for _, want := range []string{"Gifts: 1 (200 sat)", "Tips to inactive users: 1 (100 sat)", "Expired, not refunded yet: 0"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report %q does not contain %q", report, want)
+		}
+	}
+
+	// nothing expires early
+	h.bot.expireClaims(time.Now())
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 700 {
+		t.Fatalf("balance of alice = %d, want 700", balance)
+	}
+	h.bot.expireClaims(time.Now().Add(giftDuration + time.Hour))
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of alice after the expiry = %d, want 1000", balance)
+	}
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "tip to @inactive") {
+		t.Errorf("message to alice = %q", text)
+	}
+	if claims := h.bot.pendingClaims(); len(claims) != 0 {
+		t.Errorf("pending claims after the expiry = %+v", claims)
+	}
+}
@@ -0,0 +1,109 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const groupCommissionTransactionType = "group commission"
+
+// maxGroupCommission is the largest share of a tip in percent that a group can keep.
+const maxGroupCommission = 10
+
+// groupCommission returns the part of a tip of amount sat in the chat that goes to the group
+// wallet, and the wallet that receives it. Tips from or to the group wallet pay no commission.
+func (bot *TipBot) groupCommission(chat *tb.Chat, from, to *lnbits.User, amount int64) (int64, *lnbits.User) {
+	if chat == nil || chat.Type == tb.ChatPrivate {
+		return 0, nil
+	}
+	settings := bot.getGroupSettings(chat.ID)
+	if settings.Commission <= 0 || settings.WalletUserId == 0 ||
+		settings.WalletUserId == from.Telegram.ID || settings.WalletUserId == to.Telegram.ID {
+		return 0, nil
+	}
+	commission := amount * settings.Commission / 100
+	if commission < 1 {
+		return 0, nil
+	}
+	wallet, err := GetLnbitsUser(&tb.User{ID: settings.WalletUserId}, *bot)
+	if err != nil || wallet.Wallet == nil {
+		log.Errorf("[groupCommission] could not load the group wallet of %d: %v", chat.ID, err)
+		return 0, nil
+	}
+	return commission, wallet
+}
+
+// collectGroupCommission moves the commission of a tip from its sender to the group wallet.
+// key is the idempotency key of the tip, the commission is only collected once per key.
+func (bot *TipBot) collectGroupCommission(from, wallet *lnbits.User, commission int64, chat *tb.Chat, anonymous bool, key string) error {
+	opts := []TransactionOption{TransactionType(groupCommissionTransactionType), TransactionChat(chat), TransactionAnonymous(anonymous)}
+	if len(key) > 0 {
+		opts = append(opts, TransactionIdempotencyKey(key+":commission"))
+	}
+	t := NewTransaction(bot, from, wallet, commission, opts...)
+	t.Memo = fmt.Sprintf("🏘 Commission on a tip in %s.", chat.Title)
+	success, err := t.Send()
+	if !success && err != errDuplicateOperation {
+		log.Errorf("[commission] could not collect %d sat for group %d: %v", commission, chat.ID, err)
+		return err
+	}
+	return nil
+}
+
+// commissionStr is appended to the confirmations of tips that paid a commission to the group.
+func commissionStr(languageCode string, commission int64) string {
+	if commission <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(i18n.Translate(languageCode, "groupCommissionMessage"), commission)
+}
+
+// groupCommissionStr shows the commission in the group settings.
+func groupCommissionStr(settings *GroupSettings) string {
+	if settings.Commission <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d%%", settings.Commission)
+}
+
+// groupSettingsCommissionHandler sets the share of every tip in the group that goes to the
+// group wallet: /groupsettings commission <percent>|off.
+func (bot *TipBot) groupSettingsCommissionHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	value, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsHelpMessage"), strings.Join(i18n.LoadedLanguages(), ", ")))
+		return ctx, err
+	}
+	var percent int64
+	if strings.ToLower(value) != "off" {
+		percent, err = strconv.ParseInt(strings.TrimSuffix(value, "%"), 10, 64)
+		if err != nil || percent < 1 || percent > maxGroupCommission {
+			bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsCommissionInvalidMessage"), maxGroupCommission))
+			return ctx, fmt.Errorf("invalid commission %s", value)
+		}
+	}
+	settings := bot.getGroupSettings(m.Chat.ID)
+	if percent > 0 && settings.WalletUserId == 0 {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "groupSettingsCommissionNoWalletMessage"))
+		return ctx, fmt.Errorf("group %d has no group wallet", m.Chat.ID)
+	}
+	settings.Commission = percent
+	if err := bot.saveGroupSettings(settings); err != nil {
+		log.Errorf("[groupSettingsCommissionHandler] could not save settings of group %d: %v", m.Chat.ID, err)
+		return ctx, err
+	}
+	if percent > 0 {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsCommissionEnabledMessage"), percent, bot.groupWalletStr(settings)))
+	} else {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "groupSettingsCommissionDisabledMessage"))
+	}
+	return ctx, nil
+}
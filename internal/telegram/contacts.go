@@ -0,0 +1,288 @@
+package telegram
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/LightningTipBot/LightningTipBot/pkg/lightning"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// contactsMaxCount is the number of contacts a user can save.
+	contactsMaxCount = 100
+	// contactsImportCount is the number of recent counterparties that /contacts import saves.
+	contactsImportCount = 10
+)
+
+var (
+	// contactNameRegex matches nicknames. They can't look like amounts, usernames or addresses.
+	contactNameRegex = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,31}$`)
+	// contactUsernameRegex matches Telegram usernames.
+	contactUsernameRegex  = regexp.MustCompile(`^[a-zA-Z0-9_]{4,32}$`)
+	contactNameCleanRegex = regexp.MustCompile(`[^a-z0-9_]`)
+)
+
+// Contact is an entry in the address book of a user. Address is a Telegram username with
+// an @ or a lightning address.
+type Contact struct {
+	UserId    int64     `json:"user_id" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"primaryKey"`
+	Address   string    `json:"address"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// contacts returns the address book of the user.
+func (bot *TipBot) contacts(userId int64) ([]Contact, error) {
+	var contacts []Contact
+	tx := bot.DB.Users.Where("user_id = ?", userId).Order("name").Find(&contacts)
+	return contacts, tx.Error
+}
+
+// getContact returns the contact of the user with the nickname.
+func (bot *TipBot) getContact(userId int64, name string) (*Contact, error) {
+	contact := &Contact{}
+	tx := bot.DB.Users.Where("user_id = ? AND name = ?", userId, strings.ToLower(name)).First(contact)
+	return contact, tx.Error
+}
+
+// parseContactAddress returns the address of a contact from a username or lightning address.
+func parseContactAddress(input string) (string, bool) {
+	if lightning.IsLightningAddress(input) {
+		return strings.ToLower(input), true
+	}
+	username := strings.TrimPrefix(input, "@")
+	if !strings.HasPrefix(input, "@") || !contactUsernameRegex.MatchString(username) {
+		return "", false
+	}
+	return "@" + username, true
+}
+
+// replaceContact replaces the nickname of a contact in /send with its address, /send 1000
+// bestie sends to the contact bestie. Nicknames come before usernames without an @.
+func (bot *TipBot) replaceContact(user *lnbits.User, m *tb.Message) {
+	fields := strings.Fields(m.Text)
+	i := 2
+	if len(fields) == 2 {
+		// /send bestie
+		i = 1
+	}
+	if len(fields) <= i || !contactNameRegex.MatchString(strings.ToLower(fields[i])) {
+		return
+	}
+	contact, err := bot.getContact(user.Telegram.ID, fields[i])
+	if err != nil {
+		return
+	}
+	fields[i] = contact.Address
+	m.Text = strings.Join(fields, " ")
+	// the offsets of mentions changed, only keep the command
+	if len(m.Entities) > 1 {
+		m.Entities = m.Entities[:1]
+	}
+}
+
+// contactsHandler is invoked on /contacts.
+func (bot *TipBot) contactsHandler(ctx intercept.Context) (intercept.Context, error) {
+	splits := strings.Fields(ctx.Message().Text)
+	if len(splits) > 1 {
+		switch strings.ToLower(splits[1]) {
+		case "add":
+			return bot.contactsAddHandler(ctx, splits[2:])
+		case "remove", "delete":
+			return bot.contactsRemoveHandler(ctx, splits[2:])
+		case "import":
+			return bot.contactsImportHandler(ctx)
+		case "help":
+			bot.trySendMessage(ctx.Sender(), Translate(ctx, "contactsHelpMessage"))
+			return ctx, nil
+		}
+	}
+	return bot.contactsListHandler(ctx)
+}
+
+// contactsListHandler lists the address book of the user.
+func (bot *TipBot) contactsListHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	contacts, err := bot.contacts(user.Telegram.ID)
+	if err != nil {
+		return ctx, err
+	}
+	if len(contacts) == 0 {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "contactsEmptyMessage")+"\n\n"+Translate(ctx, "contactsHelpMessage"))
+		return ctx, nil
+	}
+	message := Translate(ctx, "contactsListMessage")
+	for _, contact := range contacts {
+		message += fmt.Sprintf(Translate(ctx, "contactsEntryMessage"), str.MarkdownEscape(contact.Name), str.MarkdownEscape(contact.Address))
+	}
+	bot.trySendMessage(ctx.Sender(), message+"\n\n"+Translate(ctx, "contactsHelpMessage"))
+	return ctx, nil
+}
+
+// contactsAddHandler is invoked on /contacts add <name> <@user|address> and saves a contact.
+// Saving a nickname again changes its address.
+func (bot *TipBot) contactsAddHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	if len(args) < 2 {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "contactsHelpMessage"))
+		return ctx, fmt.Errorf("no contact given")
+	}
+	name := strings.ToLower(args[0])
+	if !contactNameRegex.MatchString(name) {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "contactsInvalidNameMessage"))
+		return ctx, fmt.Errorf("invalid contact name %s", args[0])
+	}
+	address, ok := parseContactAddress(args[1])
+	if !ok {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "contactsInvalidAddressMessage"))
+		return ctx, fmt.Errorf("invalid contact address %s", args[1])
+	}
+	if _, err := bot.getContact(user.Telegram.ID, name); err != nil {
+		contacts, err := bot.contacts(user.Telegram.ID)
+		if err != nil {
+			return ctx, err
+		}
+		if len(contacts) >= contactsMaxCount {
+			bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "contactsTooManyMessage"), contactsMaxCount))
+			return ctx, fmt.Errorf("too many contacts")
+		}
+	}
+	contact := &Contact{UserId: user.Telegram.ID, Name: name}
+	tx := bot.DB.Users.Where(contact).
+		Assign(Contact{Address: address}).
+		Attrs(Contact{CreatedAt: time.Now()}).
+		FirstOrCreate(contact)
+	if tx.Error != nil {
+		log.Errorf("[/contacts] could not save contact %s of %s: %v", name, GetUserStr(user.Telegram), tx.Error)
+		return ctx, tx.Error
+	}
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "contactsAddedMessage"), str.MarkdownEscape(name), str.MarkdownEscape(address), str.MarkdownEscape(name)))
+	return ctx, nil
+}
+
+// contactsRemoveHandler is invoked on /contacts remove <name>.
+func (bot *TipBot) contactsRemoveHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	if len(args) == 0 {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "contactsHelpMessage"))
+		return ctx, fmt.Errorf("no contact given")
+	}
+	name := strings.ToLower(args[0])
+	tx := bot.DB.Users.Where("user_id = ? AND name = ?", user.Telegram.ID, name).Delete(&Contact{})
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "contactsNotFoundMessage"))
+		return ctx, fmt.Errorf("contact %s not found", name)
+	}
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "contactsRemovedMessage"), str.MarkdownEscape(name)))
+	return ctx, nil
+}
+
+// recentCounterparties returns the usernames and lightning addresses that the user paid or
+// received from recently, the most recent first.
+func (bot *TipBot) recentCounterparties(user *lnbits.User) []string {
+	var sent, received []Transaction
+	self := GetUserStr(user.Telegram)
+	bot.DB.Transactions.Where("from_id = ? AND to_user LIKE ? AND to_user <> ?", user.Telegram.ID, "@%", self).
+		Order("id desc").Limit(100).Find(&sent)
+	bot.DB.Transactions.Where("to_id = ? AND from_user LIKE ? AND from_user <> ? AND anonymous = ?", user.Telegram.ID, "@%", self, false).
+		Order("id desc").Limit(100).Find(&received)
+	var addresses []PaymentCounterparty
+	if user.Wallet != nil {
+		bot.DB.Transactions.Where("wallet_id = ? AND type = ?", user.Wallet.ID, CounterpartyTypeLightningAddress).
+			Order("created_at desc").Limit(100).Find(&addresses)
+	}
+
+	type counterparty struct {
+		address string
+		time    time.Time
+	}
+	var all []counterparty
+	for _, t := range sent {
+		all = append(all, counterparty{t.ToUser, t.Time})
+	}
+	for _, t := range received {
+		all = append(all, counterparty{t.FromUser, t.Time})
+	}
+	for _, c := range addresses {
+		all = append(all, counterparty{c.Name, c.CreatedAt})
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].time.After(all[j].time) })
+
+	seen := map[string]bool{}
+	var counterparties []string
+	for _, c := range all {
+		address, ok := parseContactAddress(c.address)
+		if !ok || seen[address] {
+			continue
+		}
+		seen[address] = true
+		counterparties = append(counterparties, address)
+	}
+	return counterparties
+}
+
+// contactName returns a nickname for an address: the username or the name of the lightning
+// address, cleaned up to a valid nickname.
+func contactName(address string) string {
+	name := strings.ToLower(strings.Split(strings.TrimPrefix(address, "@"), "@")[0])
+	name = contactNameCleanRegex.ReplaceAllString(name, "_")
+	if !contactNameRegex.MatchString(name) {
+		// names can't start with a digit
+		name = "_" + name
+	}
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
+}
+
+// contactsImportHandler is invoked on /contacts import and saves the recent counterparties
+// of the user that are not in the address book yet.
+func (bot *TipBot) contactsImportHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	contacts, err := bot.contacts(user.Telegram.ID)
+	if err != nil {
+		return ctx, err
+	}
+	names, addresses := map[string]bool{}, map[string]bool{}
+	for _, contact := range contacts {
+		names[contact.Name] = true
+		addresses[contact.Address] = true
+	}
+	message := ""
+	imported := 0
+	for _, address := range bot.recentCounterparties(user) {
+		if imported >= contactsImportCount || len(contacts)+imported >= contactsMaxCount {
+			break
+		}
+		name := contactName(address)
+		if addresses[address] || names[name] {
+			continue
+		}
+		contact := &Contact{UserId: user.Telegram.ID, Name: name, Address: address, CreatedAt: time.Now()}
+		if tx := bot.DB.Users.Create(contact); tx.Error != nil {
+			log.Errorf("[/contacts] could not import contact %s of %s: %v", name, GetUserStr(user.Telegram), tx.Error)
+			continue
+		}
+		names[name], addresses[address] = true, true
+		message += fmt.Sprintf(Translate(ctx, "contactsEntryMessage"), str.MarkdownEscape(name), str.MarkdownEscape(address))
+		imported++
+	}
+	if imported == 0 {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "contactsNothingToImportMessage"))
+		return ctx, nil
+	}
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "contactsImportedMessage"), imported)+message)
+	return ctx, nil
+}
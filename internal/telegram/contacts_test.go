@@ -0,0 +1,53 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestContacts(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9981, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9982, Username: "bob_b", FirstName: "Bob", LanguageCode: "en"}
+	carol := &tb.User{ID: 9983, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	h.newUser(alice, 1000)
+	to := h.newUser(bob, 0)
+	h.newUser(carol, 0)
+
+	h.sendMessage(alice, privateChat(alice), "/contacts add bestie @bob_b")
+	h.sendMessage(alice, privateChat(alice), "/send 100 bestie")
+	confirmation := h.lastMessage(alice.ID)
+	if !strings.Contains(confirmation.Text(), "bob") {
+		t.Fatalf("confirmation = %q", confirmation.Text())
+	}
+	h.pressButton(alice, confirmation, "✅ Send")
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 100 {
+		t.Fatalf("balance of the contact = %d, want 100", balance)
+	}
+
+	// invalid nicknames and addresses
+	h.sendMessage(alice, privateChat(alice), "/contacts add 1000 @bob_b")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "Nicknames") {
+		t.Errorf("invalid name: %q", text)
+	}
+	h.sendMessage(alice, privateChat(alice), "/contacts add bob bob_b")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "lightning address") {
+		t.Errorf("invalid address: %q", text)
+	}
+
+	// recent counterparties that are not saved yet
+	h.sendMessage(alice, privateChat(alice), "/send 10 @carol")
+	h.pressButton(alice, h.lastMessage(alice.ID), "✅ Send")
+	h.sendMessage(alice, privateChat(alice), "/contacts import")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "Imported 1") || !strings.Contains(text, "carol") {
+		t.Errorf("import = %q", text)
+	}
+
+	h.sendMessage(alice, privateChat(alice), "/contacts remove bestie")
+	h.sendMessage(alice, privateChat(alice), "/contacts")
+	if text := h.lastMessage(alice.ID).Text(); strings.Contains(text, "bestie") || !strings.Contains(text, "carol") {
+		t.Errorf("contacts = %q", text)
+	}
+}
@@ -0,0 +1,116 @@
+package telegram
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	CounterpartyTypeTelegram         = "telegram"
+	CounterpartyTypeLightningAddress = "lnaddress"
+	CounterpartyTypeNode             = "node"
+)
+
+const commentMaxLength = 280
+
+// PaymentCounterparty records who was on the other side of a payment of a wallet,
+// the comment that was sent with it and the preimage of outgoing payments.
+// The same payment hash can have two records, one for each wallet of an internal payment.
+type PaymentCounterparty struct {
+	PaymentHash string    `json:"payment_hash" gorm:"primaryKey"`
+	WalletID    string    `json:"wallet_id" gorm:"primaryKey"`
+	Type        string    `json:"type"`
+	Name        string    `json:"name"`
+	Comment     string    `json:"comment"`
+	Preimage    string    `json:"preimage"`
+	CreatedAt   time.Time `json:"created"`
+}
+
+// String returns the counterparty in a format that can be shown to the user.
+func (c PaymentCounterparty) String() string {
+	if c.Type == CounterpartyTypeNode && len(c.Name) > 16 {
+		return c.Name[:16] + "..."
+	}
+	return c.Name
+}
+
+// saveCounterparty enriches a payment of a wallet with the counterparty.
+func (bot *TipBot) saveCounterparty(paymentHash string, walletID string, counterpartyType string, name string) {
+	if len(paymentHash) == 0 || len(walletID) == 0 || len(name) == 0 {
+		return
+	}
+	record := &PaymentCounterparty{PaymentHash: paymentHash, WalletID: walletID}
+	tx := bot.DB.Transactions.Where(record).
+		Assign(PaymentCounterparty{Type: counterpartyType, Name: name}).
+		Attrs(PaymentCounterparty{CreatedAt: time.Now()}).
+		FirstOrCreate(record)
+	if tx.Error != nil {
+		log.Errorf("[saveCounterparty] could not save counterparty of payment %s: %v", paymentHash, tx.Error)
+	}
+}
+
+// savePaymentComment stores the comment of a payment so that it shows up in /transactions.
+func (bot *TipBot) savePaymentComment(paymentHash string, walletID string, comment string) {
+	if len(paymentHash) == 0 || len(walletID) == 0 || len(comment) == 0 {
+		return
+	}
+	record := &PaymentCounterparty{PaymentHash: paymentHash, WalletID: walletID}
+	tx := bot.DB.Transactions.Where(record).
+		Assign(PaymentCounterparty{Comment: comment}).
+		Attrs(PaymentCounterparty{CreatedAt: time.Now()}).
+		FirstOrCreate(record)
+	if tx.Error != nil {
+		log.Errorf("[savePaymentComment] could not save comment of payment %s: %v", paymentHash, tx.Error)
+	}
+}
+
+// sanitizeComment removes control characters from a comment sent by an external
+// wallet and shortens it to commentMaxLength characters.
+func sanitizeComment(comment string) string {
+	comment = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return ' '
+		}
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, comment)
+	comment = strings.Join(strings.Fields(comment), " ")
+	if runes := []rune(comment); len(runes) > commentMaxLength {
+		comment = string(runes[:commentMaxLength]) + "..."
+	}
+	return comment
+}
+
+// loadCounterparties returns the counterparties and comments of payments of a wallet mapped by payment hash.
+func (bot *TipBot) loadCounterparties(wallet *lnbits.Wallet, payments lnbits.Payments) (counterparties map[string]string, comments map[string]string) {
+	counterparties = make(map[string]string)
+	comments = make(map[string]string)
+	hashes := make([]string, 0, len(payments))
+	for _, p := range payments {
+		hashes = append(hashes, p.PaymentHash)
+	}
+	if len(hashes) == 0 {
+		return counterparties, comments
+	}
+	records := []PaymentCounterparty{}
+	tx := bot.DB.Transactions.Where("wallet_id = ? AND payment_hash IN ?", wallet.ID, hashes).Find(&records)
+	if tx.Error != nil {
+		log.Errorf("[loadCounterparties] could not load counterparties: %v", tx.Error)
+		return counterparties, comments
+	}
+	for _, c := range records {
+		if len(c.Name) > 0 {
+			counterparties[c.PaymentHash] = c.String()
+		}
+		if len(c.Comment) > 0 {
+			comments[c.PaymentHash] = c.Comment
+		}
+	}
+	return counterparties, comments
+}
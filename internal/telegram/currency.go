@@ -0,0 +1,135 @@
+package telegram
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/price"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/eko/gocache/store"
+	log "github.com/sirupsen/logrus"
+)
+
+func currencyCacheKey(user *lnbits.User) string {
+	return fmt.Sprintf("%s_currency", user.Name)
+}
+
+// availableCurrencies returns all currencies the price module knows about.
+func availableCurrencies() []string {
+	currencies := []string{"BTC"}
+	if price.P == nil {
+		return currencies
+	}
+	fiat := []string{}
+	for currency := range price.P.Currencies {
+		fiat = append(fiat, currency)
+	}
+	sort.Strings(fiat)
+	return append(currencies, fiat...)
+}
+
+// parseCurrency returns the normalized currency code or an error if the
+// currency is not supported. "sat" and "sats" are treated like BTC.
+func parseCurrency(input string) (string, error) {
+	currency := strings.ToUpper(strings.TrimSpace(input))
+	if currency == "SAT" || currency == "SATS" {
+		currency = "BTC"
+	}
+	for _, c := range availableCurrencies() {
+		if c == currency {
+			return currency, nil
+		}
+	}
+	return "", fmt.Errorf("invalid currency %s", input)
+}
+
+// getUserCurrency returns the fiat currency the user has chosen.
+// If the user only wants to see satoshis, an empty string is returned.
+func (bot *TipBot) getUserCurrency(user *lnbits.User) string {
+	if user == nil || user.Telegram == nil {
+		return ""
+	}
+	var currency string
+	if user.Settings != nil {
+		currency = user.Settings.Display.DisplayCurrency
+	} else if c, err := bot.Cache.Get(currencyCacheKey(user)); err == nil {
+		currency = c.(string)
+	} else {
+		userWithSettings, err := GetLnbitsUserWithSettings(user.Telegram, *bot)
+		if err != nil {
+			return ""
+		}
+		currency = userWithSettings.Settings.Display.DisplayCurrency
+		bot.Cache.Set(currencyCacheKey(user), currency, &store.Options{Expiration: 1 * time.Hour})
+	}
+	currency = strings.ToUpper(currency)
+	if currency == "BTC" {
+		return ""
+	}
+	return currency
+}
+
+// fiatAmount returns the fiat value of amount in the user's currency, to be appended
+// to a message. If the user has no currency set or there is no price, it returns an empty string.
+func (bot *TipBot) fiatAmount(user *lnbits.User, amount int64) string {
+	// test coins have no price
+	if !internal.IsMainnet() {
+		return ""
+	}
+	currency := bot.getUserCurrency(user)
+	if currency == "" {
+		return ""
+	}
+	fiat, err := SatoshisToFiat(amount, currency)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf(i18n.Translate(user.Telegram.LanguageCode, "currencyFiatAmountMessage"), fiat, currency)
+}
+
+// setUserCurrency saves the currency in the user's settings.
+func (bot *TipBot) setUserCurrency(user *lnbits.User, currency string) error {
+	userWithSettings, err := GetLnbitsUserWithSettings(user.Telegram, *bot)
+	if err != nil {
+		return err
+	}
+	userWithSettings.Settings.Display.DisplayCurrency = currency
+	err = UpdateUserRecord(userWithSettings, *bot)
+	if err != nil {
+		return err
+	}
+	bot.Cache.Set(currencyCacheKey(user), currency, &store.Options{Expiration: 1 * time.Hour})
+	return nil
+}
+
+// currencyHandler is invoked on /currency [<code>]
+func (bot *TipBot) currencyHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	input, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		currency := bot.getUserCurrency(user)
+		if currency == "" {
+			currency = "BTC"
+		}
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "currencyHelpMessage"), strings.Join(availableCurrencies(), ", "))+"\n\n"+fmt.Sprintf(Translate(ctx, "currencyCurrentMessage"), currency))
+		return ctx, nil
+	}
+	currency, err := parseCurrency(input)
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "currencyInvalidMessage"), strings.Join(availableCurrencies(), ", ")))
+		return ctx, err
+	}
+	err = bot.setUserCurrency(user, currency)
+	if err != nil {
+		log.Errorf("[currencyHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "currencyChangedMessage"), currency))
+	return ctx, nil
+}
@@ -25,7 +25,6 @@ import (
 
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
@@ -37,15 +36,39 @@ type Databases struct {
 
 const (
 	JoinTicketIndex             = "join-ticket:*"
+	PaymentRetryIndex           = "payment-retry:*"
+	PoolIndex                   = "pool:*"
+	RecurringDonationIndex      = "recurring-donation:*"
+	GiftIndex                   = "gift:*"
+	VoucherBatchIndex           = "voucher-batch:*"
+	DeletionIndex               = "deletion:*"
+	InvoiceIndex                = "invoice:*"
+	OutgoingPaymentIndex        = "outgoing-payment:*"
+	EscrowIndex                 = "escrow:*"
+	GameIndex                   = "game:*"
+	QuizIndex                   = "quiz:*"
+	BetIndex                    = "bet:*"
+	PayoutIndex                 = "payout:*"
 	MessageOrderedByReplyToFrom = "message.reply_to_message.from.id"
 	TipTooltipKeyPattern        = "tip-tool-tip:*"
 )
 
-func createBunt(file string) *storage.DB {
+// createBunt opens the key/value store. With PostgreSQL, the items are kept in table instead of file.
+func createBunt(file, table string) storage.Store {
 	t1 := time.Now()
-	// create bunt database
-	bunt := storage.NewBunt(file)
-	log.Infof("[blunt] loaded file in %s", time.Since(t1))
+	var bunt storage.Store
+	if database.UsePostgres() {
+		db, err := database.Open(file)
+		if err != nil {
+			panic(err)
+		}
+		bunt = storage.NewPostgres(db, table)
+		log.Infof("[blunt] using postgres table %s", table)
+	} else {
+		// create bunt database
+		bunt = storage.NewBunt(file)
+		log.Infof("[blunt] loaded file in %s", time.Since(t1))
+	}
 	// create bunt database index for ascending (searching) TipTooltips
 	err := bunt.CreateIndex(MessageOrderedByReplyToFrom, TipTooltipKeyPattern, buntdb.IndexJSON(MessageOrderedByReplyToFrom))
 	log.Infof("[blunt] index 1 created in %s", time.Since(t1))
@@ -58,6 +81,71 @@ func createBunt(file string) *storage.DB {
 	if err != nil {
 		panic(err)
 	}
+	err = bunt.CreateIndex("payment-retry", PaymentRetryIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 3 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("pool", PoolIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 4 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("recurring-donation", RecurringDonationIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 5 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("gift", GiftIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 6 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("voucher-batch", VoucherBatchIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 7 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("deletion", DeletionIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 8 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("invoice", InvoiceIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 9 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("outgoing-payment", OutgoingPaymentIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 10 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("escrow", EscrowIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 11 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("game", GameIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 12 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("quiz", QuizIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 13 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("bet", BetIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 14 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("payout", PayoutIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 15 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
 	log.Infof("[blunt] total time: %s", time.Since(t1))
 	return bunt
 }
@@ -104,38 +192,20 @@ func ColumnMigrationTasks(db *gorm.DB) error {
 	return err
 }
 
-func AutoMigration() *Databases {
-	orm, err := gorm.Open(sqlite.Open(internal.Configuration.Database.DbPath), &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true, FullSaveAssociations: true})
+// openDatabases opens the databases without migrating them, see migrateDatabases.
+func openDatabases() *Databases {
+	orm, err := database.Open(internal.Configuration.Database.DbPath)
 	if err != nil {
 		panic("Initialize orm failed.")
 	}
-	err = ColumnMigrationTasks(orm)
-	if err != nil {
-		panic(err)
-	}
-	err = orm.AutoMigrate(&lnbits.User{})
-	if err != nil {
-		panic(err)
-	}
-
-	txLogger, err := gorm.Open(sqlite.Open(internal.Configuration.Database.TransactionsPath), &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true, FullSaveAssociations: true})
+	txLogger, err := database.Open(internal.Configuration.Database.TransactionsPath)
 	if err != nil {
 		panic("Initialize orm failed.")
 	}
-	err = txLogger.AutoMigrate(&Transaction{})
-	if err != nil {
-		panic(err)
-	}
-
-	groupsDb, err := gorm.Open(sqlite.Open(internal.Configuration.Database.GroupsDbPath), &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true, FullSaveAssociations: true})
+	groupsDb, err := database.Open(internal.Configuration.Database.GroupsDbPath)
 	if err != nil {
 		panic("Initialize orm failed.")
 	}
-	err = groupsDb.AutoMigrate(&Group{})
-	if err != nil {
-		panic(err)
-	}
-
 	return &Databases{
 		Users:        orm,
 		Transactions: txLogger,
@@ -149,7 +219,7 @@ func GetUserByTelegramUsername(toUserStrWithoutAt string, bot TipBot) (*lnbits.U
 	if len(toUserStrWithoutAt) > 100 {
 		return nil, fmt.Errorf("[GetUserByTelegramUsername] Telegram username is too long: %s..", toUserStrWithoutAt[:100])
 	}
-	tx := bot.DB.Users.Where("telegram_username = ? COLLATE NOCASE", toUserStrWithoutAt).First(toUserDb)
+	tx := bot.DB.Users.Where(database.EqualFold("telegram_username"), toUserStrWithoutAt).First(toUserDb)
 	if tx.Error != nil || toUserDb.Wallet == nil {
 		err := tx.Error
 		if toUserDb.Wallet == nil {
@@ -215,10 +285,13 @@ func GetUser(u *tb.User, bot TipBot) (*lnbits.User, error) {
 	}
 	if telegramUserChanged(u, user.Telegram) {
 		// update possibly changed user details in Database
+		stored := user.Telegram
 		user.Telegram = u
 		err = UpdateUserRecord(user, bot)
 		if err != nil {
 			log.Warnln(fmt.Sprintf("[UpdateUserRecord] %s", err.Error()))
+		} else if usernameRenamed(stored, u) {
+			bot.usernameChanged(user, stored.Username)
 		}
 	}
 	return user, err
@@ -290,7 +363,7 @@ func UpdateUserRecord(user *lnbits.User, bot TipBot) error {
 		return tx.Error
 	}
 	log.Tracef("[UpdateUserRecord] Records of user %s updated.", GetUserStr(user.Telegram))
-	if bot.Cache.GoCacheStore != nil {
+	if bot.Cache.StoreInterface != nil {
 		updateCachedUser(user, bot)
 	}
 	return nil
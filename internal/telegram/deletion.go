@@ -0,0 +1,115 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/rate"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// Telegram only lets bots delete messages of the last 48 hours
+	deleteAfterMax = 48 * time.Hour
+	deleteAfterMin = 5 * time.Second
+)
+
+// ScheduledDeletion is a message of the bot in a group that is deleted after the timeout
+// that the admins of the group set.
+type ScheduledDeletion struct {
+	*storage.Base
+	ChatID    int64     `json:"chat_id"`
+	MessageID int       `json:"message_id"`
+	DeleteAt  time.Time `json:"delete_at"`
+}
+
+type keepOption struct{}
+
+// keepMessage is a send option for group messages that must not be deleted automatically,
+// like tooltips that are updated with every tip. It is not passed on to Telegram.
+var keepMessage = keepOption{}
+
+// withoutKeep removes keepMessage from the options and returns whether it was set.
+func withoutKeep(options []interface{}) ([]interface{}, bool) {
+	keep := false
+	filtered := make([]interface{}, 0, len(options))
+	for _, option := range options {
+		if option == keepMessage {
+			keep = true
+			continue
+		}
+		filtered = append(filtered, option)
+	}
+	return filtered, keep
+}
+
+// hasButtons returns true if the options contain an inline keyboard. Messages with buttons
+// are not deleted automatically because users still interact with them.
+func hasButtons(options []interface{}) bool {
+	for _, option := range options {
+		if markup, ok := option.(*tb.ReplyMarkup); ok && len(markup.InlineKeyboard) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleDeletion deletes a message of the bot in a group after the timeout of the group.
+func (bot TipBot) scheduleDeletion(msg *tb.Message) {
+	if msg == nil || msg.Chat == nil || msg.Chat.Type == tb.ChatPrivate || msg.Chat.ID > 0 {
+		return
+	}
+	timeout := time.Duration(bot.getGroupSettings(msg.Chat.ID).DeleteAfter) * time.Second
+	if timeout <= 0 {
+		return
+	}
+	deletion := &ScheduledDeletion{
+		Base:      storage.New(storage.ID(fmt.Sprintf("deletion:%d:%d", msg.Chat.ID, msg.ID))),
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.ID,
+		DeleteAt:  time.Now().Add(timeout),
+	}
+	runtime.IgnoreError(deletion.Set(deletion, bot.Bunt))
+	bot.startDeletionTimer(deletion)
+}
+
+func (bot TipBot) startDeletionTimer(deletion *ScheduledDeletion) {
+	time.AfterFunc(time.Until(deletion.DeleteAt), func() {
+		bot.deleteScheduledMessage(deletion.ID)
+	})
+}
+
+func (bot TipBot) deleteScheduledMessage(id string) {
+	// other instances of a cluster have the same timers
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	deletion := &ScheduledDeletion{Base: storage.New(storage.ID(id))}
+	sn, err := deletion.Get(deletion, bot.Bunt)
+	if err != nil {
+		return
+	}
+	deletion = sn.(*ScheduledDeletion)
+	rate.CheckLimit(strconv.FormatInt(deletion.ChatID, 10))
+	if err := bot.Telegram.Delete(&tb.Message{ID: deletion.MessageID, Chat: &tb.Chat{ID: deletion.ChatID}}); err != nil {
+		// users or admins may have deleted it already
+		log.Debugf("[deleteScheduledMessage] %s: %v", id, err)
+	}
+	runtime.IgnoreError(deletion.Delete(deletion, bot.Bunt))
+}
+
+// restartDeletionTimers starts the timers of all scheduled deletions after a restart.
+func (bot *TipBot) restartDeletionTimers() {
+	bot.Bunt.Ascend("deletion", func(key, value string) bool {
+		deletion := &ScheduledDeletion{}
+		if err := json.Unmarshal([]byte(value), deletion); err == nil && deletion.Base != nil {
+			bot.startDeletionTimer(deletion)
+		}
+		return true // continue iteration
+	})
+}
@@ -0,0 +1,47 @@
+package telegram
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestScheduledDeletion(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9851, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9852, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9850, Type: tb.ChatGroup, Title: "group"}
+	h.newUser(alice, 1000)
+	h.newUser(bob, 0)
+	settings := h.bot.getGroupSettings(group.ID)
+	settings.DeleteAfter = 60
+	if err := h.bot.saveGroupSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	scheduled := func(msg *tb.Message) bool {
+		deletion := &ScheduledDeletion{Base: storage.New(storage.ID(fmt.Sprintf("deletion:%d:%d", msg.Chat.ID, msg.ID)))}
+		_, err := deletion.Get(deletion, h.bot.Bunt)
+		return err == nil
+	}
+
+	msg := h.bot.trySendMessage(group, "hello")
+	if !scheduled(msg) {
+		t.Fatal("message in the group was not scheduled for deletion")
+	}
+	if private := h.bot.trySendMessage(alice, "hello"); scheduled(private) {
+		t.Error("private message was scheduled for deletion")
+	}
+	if kept := h.bot.trySendMessage(group, "hello", keepMessage); scheduled(kept) {
+		t.Error("message with keepMessage was scheduled for deletion")
+	}
+
+	h.bot.deleteScheduledMessage(fmt.Sprintf("deletion:%d:%d", msg.Chat.ID, msg.ID))
+	if !h.called("deleteMessage", group.ID) {
+		t.Error("message was not deleted")
+	}
+	if scheduled(msg) {
+		t.Error("deletion was not removed after deleting the message")
+	}
+}
@@ -12,6 +12,8 @@ import (
 
 	"github.com/fiatjaf/go-lnurl"
 
+	"github.com/LightningTipBot/LightningTipBot/internal"
+
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
 
 	"github.com/LightningTipBot/LightningTipBot/internal/errors"
@@ -24,14 +26,12 @@ import (
 )
 
 // This file has been simplified so that all donations initiated through
-// the bot are forwarded to a fixed lightning address: kevinrav@btip.nl
-// (or the equivalent user @kevinrav). The behaviour is intentionally
+// the bot are forwarded to the configured donation address (bot.donation_address,
+// kevinrav@btip.nl by default). The behaviour is intentionally
 // straightforward: resolve the LN address to an LNURL pay endpoint,
 // request an invoice for the requested amount and pay it from the
 // user's wallet.
 
-const fixedLightningAddress = "kevinrav@btip.nl"
-
 func helpDonateUsage(ctx context.Context, errormsg string) string {
 	if len(errormsg) > 0 {
 		return fmt.Sprintf(Translate(ctx, "donateHelpText"), fmt.Sprintf("%s", errormsg))
@@ -48,6 +48,16 @@ func (bot TipBot) donationHandler(ctx intercept.Context) (intercept.Context, err
 	if user.Wallet == nil {
 		return ctx, errors.Create(errors.UserNoWalletError)
 	}
+	if arg, err := getArgumentFromCommand(m.Text, 1); err == nil {
+		switch strings.ToLower(arg) {
+		case "history":
+			return bot.donationHistoryHandler(ctx)
+		case "top":
+			return bot.donorLeaderboardHandler(ctx)
+		case "match":
+			return bot.donateMatchHandler(ctx)
+		}
+	}
 
 	// decode amount from command; if none and private chat, ask for it
 	amount, err := decodeAmountFromCommand(m.Text)
@@ -55,6 +65,14 @@ func (bot TipBot) donationHandler(ctx intercept.Context) (intercept.Context, err
 		_, err = bot.askForAmount(ctx, "", "CreateDonationState", 0, 0, m.Text)
 		return ctx, err
 	}
+	// /donate <amount> monthly [<lightning address>]
+	if interval, err := getArgumentFromCommand(m.Text, 2); err == nil && strings.ToLower(interval) == "monthly" {
+		if amount < 1 {
+			bot.trySendMessage(m.Sender, helpDonateUsage(ctx, Translate(ctx, "donateValidAmountMessage")))
+			return ctx, errors.Create(errors.InvalidAmountError)
+		}
+		return bot.recurringDonationHandler(ctx, amount)
+	}
 	// convert sats -> millisats (existing behaviour)
 	amount = amount * 1000
 
@@ -62,9 +80,10 @@ func (bot TipBot) donationHandler(ctx intercept.Context) (intercept.Context, err
 	msg := bot.trySendMessageEditable(m.Chat, Translate(ctx, "donationProgressMessage"))
 
 	// Resolve fixed lightning address to LNURL pay endpoint:
-	parts := strings.Split(fixedLightningAddress, "@")
+	donationAddress := internal.CurrentConfiguration().Bot.DonationAddress
+	parts := strings.Split(donationAddress, "@")
 	if len(parts) != 2 {
-		log.Errorln("invalid fixed lightning address:", fixedLightningAddress)
+		log.Errorln("invalid fixed lightning address:", donationAddress)
 		bot.tryEditMessage(msg, Translate(ctx, "donationErrorMessage"))
 		return ctx, fmt.Errorf("invalid fixed lightning address")
 	}
@@ -82,7 +101,7 @@ func (bot TipBot) donationHandler(ctx intercept.Context) (intercept.Context, err
 	}
 	q := url.Values{}
 	q.Set("amount", strconv.FormatInt(amount, 10))
-	q.Set("comment", fmt.Sprintf("from %s via bot %s", GetUserStr(user.Telegram), GetUserStr(bot.Telegram.Me)))
+	q.Set("comment", bot.donationComment(user.Telegram, false))
 	req.URL.RawQuery = q.Encode()
 
 	resp, err := http.DefaultClient.Do(req)
@@ -113,8 +132,16 @@ func (bot TipBot) donationHandler(ctx intercept.Context) (intercept.Context, err
 		return ctx, fmt.Errorf("lnurl pay endpoint error")
 	}
 
+	// the service fee is paid on top of the donation
+	if fee := serviceFee(user, "donation", amount/1000); fee > 0 {
+		if balance, err := bot.GetUserBalance(user); err == nil && balance < amount/1000+fee {
+			bot.tryEditMessage(msg, Translate(ctx, "donationErrorMessage"))
+			return ctx, errors.Create(errors.BalanceToLowError)
+		}
+	}
+
 	// pay the returned invoice
-	_, err = user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: string(pv.PR)}, bot.Client)
+	invoice, err := bot.Client.Pay(*user.Wallet, lnbits.PaymentParams{Out: true, Bolt11: string(pv.PR)})
 	if err != nil {
 		userStr := GetUserStr(user.Telegram)
 		errmsg := fmt.Sprintf("[/donate] Donation failed for user %s: %s", userStr, err)
@@ -123,9 +150,14 @@ func (bot TipBot) donationHandler(ctx intercept.Context) (intercept.Context, err
 		return ctx, err
 	}
 
+	donation := bot.recordDonation(user, donationAddress, amount/1000, invoice.PaymentHash, false)
+	fee := bot.collectDonationFee(user, amount/1000, invoice.PaymentHash, donation)
+	// sponsors match the donation in the background
+	go bot.matchDonation(donation)
+
 	// remove progress and notify success
 	bot.tryDeleteMessage(msg)
-	bot.trySendMessage(m.Chat, Translate(ctx, "donationSuccess"))
+	bot.trySendMessage(m.Chat, donationThankYou(ctx, user.Telegram, amount/1000)+feeStr(ctx.Value("publicLanguageCode").(string), fee), makeDonationReceiptKeyboard(ctx, donation))
 	return ctx, nil
 }
 
@@ -150,7 +182,7 @@ func (bot TipBot) parseCmdDonHandler(ctx intercept.Context) error {
 	}
 
 	// Inform the user that the donation will be forwarded to the fixed recipient
-	notice := fmt.Sprintf("Thanks — donations initiated here will be forwarded to @kevinrav (%s).", fixedLightningAddress)
+	notice := fmt.Sprintf("Thanks — donations initiated here will be forwarded to %s.", internal.CurrentConfiguration().Bot.DonationAddress)
 	bot.trySendMessage(m.Sender, str.MarkdownEscape(notice))
 
 	// rewrite message to call /donate with the detected amount (or with no amount so donateHandler asks)
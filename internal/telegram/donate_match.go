@@ -0,0 +1,235 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// matchingCampaignDuration is how long a campaign matches donations without a duration
+	matchingCampaignDuration = 7 * 24 * time.Hour
+	// matchingCampaignCheckInterval is how often campaigns that ran out are closed
+	matchingCampaignCheckInterval = 10 * time.Minute
+)
+
+// MatchingCampaign is the pledge of a sponsor to match the donations of others until Ends, up
+// to Cap sat. Every donation to the donation address is matched with a donation of the same
+// amount from the wallet of the sponsor.
+type MatchingCampaign struct {
+	ID           uint      `gorm:"primarykey"`
+	CreatedAt    time.Time `json:"created_at"`
+	SponsorId    int64     `json:"sponsor_id" gorm:"index"`
+	Sponsor      string    `json:"sponsor"`
+	Address      string    `json:"address"`
+	Cap          int64     `json:"cap"`
+	Matched      int64     `json:"matched"`
+	Donations    int64     `json:"donations"` // number of matched donations
+	Ends         time.Time `json:"ends"`
+	ChatId       int64     `json:"chat_id"`
+	MessageId    int       `json:"message_id"` // the progress of the campaign
+	LanguageCode string    `json:"language_code"`
+	Active       bool      `json:"active" gorm:"index"`
+}
+
+// matchAmount returns how much of a donation of amount sat the campaign matches.
+func (campaign *MatchingCampaign) matchAmount(amount int64) int64 {
+	if rest := campaign.Cap - campaign.Matched; amount > rest {
+		return rest
+	}
+	return amount
+}
+
+func (campaign *MatchingCampaign) progressStr() string {
+	text := fmt.Sprintf(i18n.Translate(campaign.LanguageCode, "donateMatchMessage"), str.MarkdownEscape(campaign.Sponsor), campaign.Cap,
+		campaign.Matched, campaign.Cap, campaign.Donations)
+	if !campaign.Active || campaign.Matched >= campaign.Cap || time.Now().After(campaign.Ends) {
+		return text + fmt.Sprintf(i18n.Translate(campaign.LanguageCode, "donateMatchEndedMessage"), campaign.Matched)
+	}
+	return text + fmt.Sprintf(i18n.Translate(campaign.LanguageCode, "donateMatchEndsMessage"), campaign.Ends.UTC().Format(dropTimeLayout))
+}
+
+// donateMatchHandler is invoked on /donate match <cap> [<duration>] to start a campaign,
+// /donate match stop to end the campaigns of the sponsor and /donate match to show the active
+// campaigns.
+func (bot *TipBot) donateMatchHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	args := strings.Fields(m.Text)
+	sponsor := LoadUser(ctx)
+	if len(args) == 2 {
+		campaigns := bot.activeMatchingCampaigns()
+		if len(campaigns) == 0 {
+			bot.trySendMessage(bot.topicOf(m), Translate(ctx, "donateMatchNoneMessage"))
+			return ctx, nil
+		}
+		texts := make([]string, 0)
+		for _, campaign := range campaigns {
+			texts = append(texts, campaign.progressStr())
+		}
+		bot.trySendMessage(bot.topicOf(m), strings.Join(texts, "\n\n"))
+		return ctx, nil
+	}
+	if strings.ToLower(args[2]) == "stop" {
+		stopped := 0
+		for _, campaign := range bot.activeMatchingCampaigns() {
+			if campaign.SponsorId == sponsor.Telegram.ID {
+				bot.closeMatchingCampaign(campaign.ID)
+				stopped++
+			}
+		}
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "donateMatchStoppedMessage"), stopped))
+		return ctx, nil
+	}
+	if len(args) > 4 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "donateMatchHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(args[2])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "donateMatchHelpMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	duration := matchingCampaignDuration
+	if len(args) == 4 {
+		when := strings.ToLower(args[3])
+		if days, err := strconv.ParseUint(strings.TrimSuffix(when, "d"), 10, 64); strings.HasSuffix(when, "d") && err == nil {
+			duration = time.Duration(days) * 24 * time.Hour
+		} else if duration, err = time.ParseDuration(when); err != nil || duration <= 0 {
+			bot.trySendMessage(m.Sender, Translate(ctx, "donateMatchHelpMessage"))
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+	}
+	campaign := &MatchingCampaign{
+		CreatedAt:    time.Now(),
+		SponsorId:    sponsor.Telegram.ID,
+		Sponsor:      GetUserStr(sponsor.Telegram),
+		Address:      internal.CurrentConfiguration().Bot.DonationAddress,
+		Cap:          amount,
+		Ends:         time.Now().Add(duration),
+		ChatId:       m.Chat.ID,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+		Active:       true,
+	}
+	if tx := bot.DB.Transactions.Create(campaign); tx.Error != nil {
+		log.Errorf("[/donate match] could not store the campaign of %s: %v", GetUserStr(sponsor.Telegram), tx.Error)
+		return ctx, tx.Error
+	}
+	if progress := bot.trySendMessage(bot.topicOf(m), campaign.progressStr(), keepMessage); progress != nil {
+		bot.DB.Transactions.Model(campaign).Update("message_id", progress.ID)
+	}
+	logger(ctx).Infof("[❤️ donate] %s matches donations up to %d sat until %s", GetUserStr(sponsor.Telegram), amount, campaign.Ends)
+	return ctx, nil
+}
+
+func (bot *TipBot) activeMatchingCampaigns() []MatchingCampaign {
+	campaigns := make([]MatchingCampaign, 0)
+	bot.DB.Transactions.Where("active = ?", true).Order("id").Find(&campaigns)
+	return campaigns
+}
+
+// matchDonation matches a donation with all active campaigns of other sponsors for the same
+// address. Matching donations are not matched again.
+func (bot *TipBot) matchDonation(donation *Donation) {
+	if donation == nil {
+		return
+	}
+	for _, campaign := range bot.activeMatchingCampaigns() {
+		if campaign.SponsorId == donation.FromId || campaign.Address != donation.Address {
+			continue
+		}
+		bot.matchDonationWith(campaign.ID, donation)
+	}
+}
+
+func (bot *TipBot) matchDonationWith(campaignId uint, donation *Donation) {
+	key := fmt.Sprintf("donation-match:%d", campaignId)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	campaign := &MatchingCampaign{}
+	if tx := bot.DB.Transactions.First(campaign, campaignId); tx.Error != nil || !campaign.Active || time.Now().After(campaign.Ends) {
+		return
+	}
+	amount := campaign.matchAmount(donation.Amount)
+	if amount < 1 {
+		return
+	}
+	sponsor, err := GetUser(&tb.User{ID: campaign.SponsorId}, *bot)
+	if err != nil || sponsor.Wallet == nil {
+		log.Errorf("[donationMatch] sponsor of campaign %d: %v", campaign.ID, err)
+		return
+	}
+	comment := fmt.Sprintf("Matching a donation of %s", donation.FromUser)
+	paymentHash, err := bot.payLightningAddress(sponsor, campaign.Address, amount, comment)
+	if err != nil {
+		log.Warnf("[donationMatch] campaign %d could not match %d sat: %v", campaign.ID, amount, err)
+		bot.trySendMessage(sponsor.Telegram, fmt.Sprintf(i18n.Translate(sponsor.Telegram.LanguageCode, "donateMatchFailedMessage"), amount))
+		return
+	}
+	receipt := bot.recordDonation(sponsor, campaign.Address, amount, paymentHash, false)
+	bot.collectDonationFee(sponsor, amount, paymentHash, receipt)
+	campaign.Matched += amount
+	campaign.Donations++
+	if campaign.Matched >= campaign.Cap {
+		campaign.Active = false
+	}
+	if tx := bot.DB.Transactions.Save(campaign); tx.Error != nil {
+		log.Errorf("[donationMatch] could not save campaign %d: %v", campaign.ID, tx.Error)
+	}
+	log.Infof("[❤️ donate] %s matched %d sat of %s", campaign.Sponsor, amount, donation.FromUser)
+	bot.updateMatchingCampaignProgress(campaign)
+}
+
+// updateMatchingCampaignProgress edits the progress message of the campaign.
+func (bot *TipBot) updateMatchingCampaignProgress(campaign *MatchingCampaign) {
+	if campaign.MessageId == 0 {
+		return
+	}
+	message := &tb.StoredMessage{MessageID: strconv.Itoa(campaign.MessageId), ChatID: campaign.ChatId}
+	bot.tryEditMessage(message, campaign.progressStr())
+}
+
+// startMatchingCampaignWorker closes the campaigns that ran out.
+func (bot *TipBot) startMatchingCampaignWorker() {
+	go func() {
+		for {
+			if !isShuttingDown() {
+				for _, campaign := range bot.activeMatchingCampaigns() {
+					if time.Now().After(campaign.Ends) {
+						bot.closeMatchingCampaign(campaign.ID)
+					}
+				}
+			}
+			time.Sleep(matchingCampaignCheckInterval)
+		}
+	}()
+}
+
+// closeMatchingCampaign ends a campaign and tells the sponsor how much they matched.
+func (bot *TipBot) closeMatchingCampaign(campaignId uint) {
+	key := fmt.Sprintf("donation-match:%d", campaignId)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	campaign := &MatchingCampaign{}
+	if tx := bot.DB.Transactions.First(campaign, campaignId); tx.Error != nil || !campaign.Active {
+		return
+	}
+	campaign.Active = false
+	if tx := bot.DB.Transactions.Model(campaign).Update("active", false); tx.Error != nil {
+		log.Errorf("[donationMatch] could not close campaign %d: %v", campaign.ID, tx.Error)
+		return
+	}
+	log.Infof("[❤️ donate] the campaign of %s ended, %d of %d sat matched", campaign.Sponsor, campaign.Matched, campaign.Cap)
+	bot.updateMatchingCampaignProgress(campaign)
+	sponsor := &tb.User{ID: campaign.SponsorId}
+	bot.trySendMessage(sponsor, fmt.Sprintf(i18n.Translate(campaign.LanguageCode, "donateMatchClosedMessage"), campaign.Matched, campaign.Donations))
+}
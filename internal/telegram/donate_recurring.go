@@ -0,0 +1,313 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// recurringDonationReminder is how long before a donation is due its donor is reminded.
+	recurringDonationReminder      = 24 * time.Hour
+	recurringDonationCheckInterval = 10 * time.Minute
+	// recurringDonationHistoryLength caps the number of donations that are kept per recurring donation.
+	recurringDonationHistoryLength = 24
+)
+
+const (
+	DonationStatusPaid    = "paid"
+	DonationStatusSkipped = "skipped"
+	DonationStatusFailed  = "failed"
+)
+
+var (
+	recurringDonationMenu      = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnSkipRecurringDonation   = recurringDonationMenu.Data("⏭ Skip", "skip_recurring_donation")
+	btnCancelRecurringDonation = recurringDonationMenu.Data("🚫 Cancel", "cancel_recurring_donation")
+)
+
+// DonationRecord is a single donation of a recurring donation.
+type DonationRecord struct {
+	Amount int64     `json:"amount"`
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+}
+
+// RecurringDonation pays Amount from the wallet of User to Address every month.
+type RecurringDonation struct {
+	*storage.Base
+	User         *lnbits.User     `json:"user"`
+	Amount       int64            `json:"amount"`
+	Address      string           `json:"address"`
+	NextDue      time.Time        `json:"next_due"`
+	Reminded     bool             `json:"reminded"`
+	History      []DonationRecord `json:"history"`
+	LanguageCode string           `json:"languagecode"`
+}
+
+// record adds a donation to the history and schedules the next one.
+func (donation *RecurringDonation) record(status string) {
+	donation.History = append(donation.History, DonationRecord{Amount: donation.Amount, Time: time.Now(), Status: status})
+	if len(donation.History) > recurringDonationHistoryLength {
+		donation.History = donation.History[len(donation.History)-recurringDonationHistoryLength:]
+	}
+	for !donation.NextDue.After(time.Now()) {
+		donation.NextDue = donation.NextDue.AddDate(0, 1, 0)
+	}
+	donation.Reminded = false
+}
+
+// makeRecurringDonationKeyboard returns the buttons of a recurring donation. If receipt is set,
+// there is a button for the receipt of the donation that was just paid.
+func (bot *TipBot) makeRecurringDonationKeyboard(donation *RecurringDonation, receipt *Donation) *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	skipButton := menu.Data(i18n.Translate(donation.LanguageCode, "skipButtonMessage"), "skip_recurring_donation", donation.ID)
+	cancelButton := menu.Data(i18n.Translate(donation.LanguageCode, "cancelButtonMessage"), "cancel_recurring_donation", donation.ID)
+	rows := []tb.Row{menu.Row(skipButton, cancelButton)}
+	if receipt != nil {
+		receiptButton := menu.Data(i18n.Translate(donation.LanguageCode, "receiptButtonMessage"), "donation_receipt", strconv.FormatUint(uint64(receipt.ID), 10))
+		rows = append(rows, menu.Row(receiptButton))
+	}
+	menu.Inline(rows...)
+	return menu
+}
+
+func (donation *RecurringDonation) nextDueStr() string {
+	return donation.NextDue.UTC().Format("2 Jan 06 15:04 MST")
+}
+
+// recurringDonationHandler is invoked on /donate <amount> monthly [<lightning address>]. The first
+// donation is paid right away, the following ones every month.
+func (bot *TipBot) recurringDonationHandler(ctx intercept.Context, amount int64) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Chat.Type != tb.ChatPrivate {
+		bot.tryDeleteMessage(m)
+		bot.trySendMessage(m.Sender, helpDonateUsage(ctx, Translate(ctx, "recurringDonationPrivateMessage")))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	address := internal.CurrentConfiguration().Bot.DonationAddress
+	if arg, err := getArgumentFromCommand(m.Text, 3); err == nil {
+		if !isLightningAddress(arg) {
+			bot.trySendMessage(m.Sender, helpDonateUsage(ctx, Translate(ctx, "recurringDonationInvalidAddressMessage")))
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+		address = strings.ToLower(arg)
+	}
+	user := LoadUser(ctx)
+	donation := &RecurringDonation{
+		Base:         storage.New(storage.ID(fmt.Sprintf("recurring-donation:%d:%s", user.Telegram.ID, RandStringRunes(5)))),
+		User:         user,
+		Amount:       amount,
+		Address:      address,
+		NextDue:      time.Now(),
+		History:      make([]DonationRecord, 0),
+		LanguageCode: user.Telegram.LanguageCode,
+	}
+	mutex.LockWithContext(ctx, donation.ID)
+	defer mutex.UnlockWithContext(ctx, donation.ID)
+	receipt, err := bot.payRecurringDonation(donation)
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "donationFailedMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+	runtime.IgnoreError(donation.Set(donation, bot.Bunt))
+	logger(ctx).Infof("[/donate] %s set up a monthly donation of %d sat to %s", GetUserStr(user.Telegram), amount, address)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "recurringDonationCreatedMessage"), amount, str.MarkdownEscape(address), donation.nextDueStr())+donationFeeStr(donation.LanguageCode, receipt), bot.makeRecurringDonationKeyboard(donation, receipt))
+	return ctx, nil
+}
+
+// payRecurringDonation pays a donation that is due and records it in the history.
+// It returns the stored donation for its receipt.
+func (bot *TipBot) payRecurringDonation(donation *RecurringDonation) (*Donation, error) {
+	user, err := GetUser(donation.User.Telegram, *bot)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := bot.GetUserBalance(user)
+	if err != nil {
+		return nil, err
+	}
+	if balance < donation.Amount+serviceFee(user, "donation", donation.Amount) {
+		donation.record(DonationStatusFailed)
+		return nil, fmt.Errorf("insufficient balance: %d sat", balance)
+	}
+	paymentHash, err := bot.payLightningAddress(user, donation.Address, donation.Amount, bot.donationComment(user.Telegram, true))
+	if err != nil {
+		donation.record(DonationStatusFailed)
+		return nil, err
+	}
+	donation.record(DonationStatusPaid)
+	log.Infof("[❤️ donate] %s donated %d sat to %s", GetUserStr(user.Telegram), donation.Amount, donation.Address)
+	receipt := bot.recordDonation(user, donation.Address, donation.Amount, paymentHash, true)
+	bot.collectDonationFee(user, donation.Amount, paymentHash, receipt)
+	go bot.matchDonation(receipt)
+	return receipt, nil
+}
+
+// loadRecurringDonation loads an active recurring donation of a button that only its donor may press.
+func (bot *TipBot) loadRecurringDonation(ctx intercept.Context) (*RecurringDonation, error) {
+	donation := &RecurringDonation{Base: storage.New(storage.ID(ctx.Data()))}
+	sn, err := donation.Get(donation, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	donation = sn.(*RecurringDonation)
+	if donation.User.Telegram.ID != ctx.Sender().ID {
+		return nil, errors.Create(errors.UnknownError)
+	}
+	if !donation.Active {
+		bot.tryEditMessage(ctx.Message(), i18n.Translate(donation.LanguageCode, "recurringDonationCancelledMessage"), &tb.ReplyMarkup{})
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	return donation, nil
+}
+
+// skipRecurringDonationHandler skips the next donation of a recurring donation.
+func (bot *TipBot) skipRecurringDonationHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	donation, err := bot.loadRecurringDonation(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	donation.NextDue = donation.NextDue.AddDate(0, 1, 0)
+	donation.History = append(donation.History, DonationRecord{Amount: donation.Amount, Time: time.Now(), Status: DonationStatusSkipped})
+	donation.Reminded = false
+	runtime.IgnoreError(donation.Set(donation, bot.Bunt))
+	logger(ctx).Infof("[/donate] %s skipped a donation of %s", GetUserStr(ctx.Sender()), donation.ID)
+	bot.tryEditMessage(ctx.Message(), fmt.Sprintf(i18n.Translate(donation.LanguageCode, "recurringDonationSkippedMessage"), donation.Amount, donation.nextDueStr()), bot.makeRecurringDonationKeyboard(donation, nil))
+	return ctx, nil
+}
+
+// cancelRecurringDonationHandler stops a recurring donation.
+func (bot *TipBot) cancelRecurringDonationHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	donation, err := bot.loadRecurringDonation(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	runtime.IgnoreError(donation.Inactivate(donation, bot.Bunt))
+	logger(ctx).Infof("[/donate] %s cancelled %s", GetUserStr(ctx.Sender()), donation.ID)
+	bot.tryEditMessage(ctx.Message(), i18n.Translate(donation.LanguageCode, "recurringDonationCancelledMessage"), &tb.ReplyMarkup{})
+	return ctx, nil
+}
+
+// recurringDonationsOf returns the recurring donations of a user, with the cancelled ones if all is set.
+func (bot *TipBot) recurringDonationsOf(user *tb.User, all bool) []*RecurringDonation {
+	donations := make([]*RecurringDonation, 0)
+	prefix := fmt.Sprintf("recurring-donation:%d:", user.ID)
+	bot.Bunt.Ascend("recurring-donation", func(key, value string) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return true // continue iteration
+		}
+		donation := &RecurringDonation{}
+		if err := json.Unmarshal([]byte(value), donation); err == nil && donation.Base != nil && (all || donation.Active) {
+			donations = append(donations, donation)
+		}
+		return true // continue iteration
+	})
+	return donations
+}
+
+// donationHistoryHandler is invoked on /donate history and lists the recurring donations of a user.
+func (bot *TipBot) donationHistoryHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	donations := bot.recurringDonationsOf(m.Sender, true)
+	if len(donations) == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "recurringDonationNoHistoryMessage"))
+		return ctx, nil
+	}
+	for _, donation := range donations {
+		history := ""
+		var total int64
+		for _, record := range donation.History {
+			history += fmt.Sprintf("%s: %d sat %s\n", record.Time.UTC().Format("2 Jan 06"), record.Amount, record.Status)
+			if record.Status == DonationStatusPaid {
+				total += record.Amount
+			}
+		}
+		if !donation.Active {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "recurringDonationHistoryEndedMessage"), donation.Amount, str.MarkdownEscape(donation.Address), total, history))
+			continue
+		}
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "recurringDonationHistoryMessage"), donation.Amount, str.MarkdownEscape(donation.Address), total, history, donation.nextDueStr()), bot.makeRecurringDonationKeyboard(donation, nil))
+	}
+	return ctx, nil
+}
+
+// startRecurringDonationWorker periodically reminds donors and pays the donations that are due.
+func (bot *TipBot) startRecurringDonationWorker() {
+	go func() {
+		for {
+			if bot.walletBackendAvailable() && !isShuttingDown() {
+				for _, id := range bot.dueRecurringDonations() {
+					bot.processRecurringDonation(id)
+				}
+			}
+			time.Sleep(recurringDonationCheckInterval)
+		}
+	}()
+}
+
+// dueRecurringDonations returns the ids of active recurring donations that need a reminder or are due.
+func (bot *TipBot) dueRecurringDonations() []string {
+	ids := make([]string, 0)
+	bot.Bunt.Ascend("recurring-donation", func(key, value string) bool {
+		donation := &RecurringDonation{}
+		if err := json.Unmarshal([]byte(value), donation); err == nil && donation.Base != nil && donation.Active &&
+			(time.Now().After(donation.NextDue) || (!donation.Reminded && time.Until(donation.NextDue) < recurringDonationReminder)) {
+			ids = append(ids, donation.ID)
+		}
+		return true // continue iteration
+	})
+	return ids
+}
+
+func (bot *TipBot) processRecurringDonation(id string) {
+	beginInFlight()
+	defer endInFlight()
+	// other instances of a cluster work on the same donations
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	donation := &RecurringDonation{Base: storage.New(storage.ID(id))}
+	sn, err := donation.Get(donation, bot.Bunt)
+	if err != nil {
+		log.Errorf("[recurringDonation] %s: %v", id, err)
+		return
+	}
+	donation = sn.(*RecurringDonation)
+	if !donation.Active {
+		return
+	}
+	to := donation.User.Telegram
+	switch {
+	case time.Now().After(donation.NextDue):
+		receipt, err := bot.payRecurringDonation(donation)
+		runtime.IgnoreError(donation.Set(donation, bot.Bunt))
+		if err != nil {
+			log.Warnf("[recurringDonation] donation %s of %s failed: %v", id, GetUserStr(to), err)
+			bot.trySendMessage(to, fmt.Sprintf(i18n.Translate(donation.LanguageCode, "recurringDonationFailedMessage"), donation.Amount, str.MarkdownEscape(donation.Address), str.MarkdownEscape(err.Error()), donation.nextDueStr()), bot.makeRecurringDonationKeyboard(donation, nil))
+			return
+		}
+		bot.trySendMessage(to, fmt.Sprintf(i18n.Translate(donation.LanguageCode, "recurringDonationPaidMessage"), donation.Amount, str.MarkdownEscape(donation.Address), donation.nextDueStr())+donationFeeStr(donation.LanguageCode, receipt), bot.makeRecurringDonationKeyboard(donation, receipt))
+	case !donation.Reminded:
+		donation.Reminded = true
+		runtime.IgnoreError(donation.Set(donation, bot.Bunt))
+		bot.trySendMessage(to, fmt.Sprintf(i18n.Translate(donation.LanguageCode, "recurringDonationReminderMessage"), donation.Amount, str.MarkdownEscape(donation.Address), donation.nextDueStr()), bot.makeRecurringDonationKeyboard(donation, nil))
+	}
+}
@@ -0,0 +1,140 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestRecurringDonationSkipAndCancel(t *testing.T) {
+	h := newTestHarness(t)
+	donor := &tb.User{ID: 8501, Username: "donor", FirstName: "Donor", LanguageCode: "en"}
+	user := h.newUser(donor, 0)
+	nextDue := time.Now().Add(time.Hour)
+	donation := &RecurringDonation{
+		Base:         storage.New(storage.ID("recurring-donation:8501:abcde")),
+		User:         user,
+		Amount:       5000,
+		Address:      "donations@example.com",
+		NextDue:      nextDue,
+		LanguageCode: "en",
+	}
+	if err := donation.Set(donation, h.bot.Bunt); err != nil {
+		t.Fatal(err)
+	}
+
+	h.sendMessage(donor, privateChat(donor), "/donate history")
+	history := h.lastMessage(donor.ID)
+	if !strings.Contains(history.Text(), "Monthly donation of 5000 sat") {
+		t.Fatalf("history = %q", history.Text())
+	}
+	h.pressButton(donor, history, "⏭ Skip")
+	sn, err := donation.Get(&RecurringDonation{Base: storage.New(storage.ID(donation.ID))}, h.bot.Bunt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped := sn.(*RecurringDonation); !skipped.NextDue.Equal(nextDue.AddDate(0, 1, 0)) || skipped.History[0].Status != DonationStatusSkipped {
+		t.Errorf("donation after skip = %+v", skipped)
+	}
+
+	h.pressButton(donor, h.lastMessage(donor.ID), "🚫 Cancel")
+	if donations := h.bot.recurringDonationsOf(donor, false); len(donations) != 0 {
+		t.Errorf("%d active donations after cancel, want 0", len(donations))
+	}
+	if text := h.lastMessage(donor.ID).Text(); !strings.Contains(text, "cancelled") {
+		t.Errorf("message after cancel = %q", text)
+	}
+}
+
+func TestDonorLeaderboard(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 8601, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 8602, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	aliceUser := h.newUser(alice, 0)
+	bobUser := h.newUser(bob, 0)
+	h.bot.recordDonation(aliceUser, "donations@example.com", 1000, "", false)
+	h.bot.recordDonation(aliceUser, "donations@example.com", 500, "", true)
+	h.bot.recordDonation(bobUser, "donations@example.com", 5000, "", false)
+
+	// only donors who opted in are listed
+	h.sendMessage(alice, privateChat(alice), "/set donor public")
+	h.sendMessage(alice, privateChat(alice), "/donate top")
+	text := h.lastMessage(alice.ID).Text()
+	if !strings.Contains(text, "1. @alice: 1500 sat") || strings.Contains(text, "@bob") {
+		t.Errorf("leaderboard = %q", text)
+	}
+	if !strings.Contains(text, "You donated 1500 sat") {
+		t.Errorf("leaderboard = %q", text)
+	}
+	if comment := h.bot.donationComment(bob, false); strings.Contains(comment, "bob") {
+		t.Errorf("comment of a private donor = %q", comment)
+	}
+}
+
+func TestDonationReceipt(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 8701, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 8702, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	user := h.newUser(alice, 0)
+	h.newUser(bob, 0)
+	donation := h.bot.recordDonation(user, "donations@example.com", 2100, "5f2b0c7e9a", true)
+	if donation == nil {
+		t.Fatal("donation was not stored")
+	}
+	receipt := string(h.bot.donationReceipt(donation))
+	if !strings.HasPrefix(receipt, "%PDF-") || !strings.Contains(receipt, "5f2b0c7e9a") || !strings.Contains(receipt, "2100 sat") {
+		t.Errorf("receipt = %q", receipt)
+	}
+
+	recurring := &RecurringDonation{Base: storage.New(storage.ID("recurring-donation:8701:abcde")), User: user, LanguageCode: "en"}
+	markup, _ := json.Marshal(h.bot.makeRecurringDonationKeyboard(recurring, donation))
+	message := func(chatId int64) telegramRequest {
+		return telegramRequest{Method: "sendMessage", Id: 1, Params: map[string]string{"chat_id": fmt.Sprint(chatId), "reply_markup": string(markup)}}
+	}
+	// only the donor gets the receipt
+	h.pressButton(bob, message(bob.ID), "🧾 Receipt")
+	if h.called("sendDocument", bob.ID) {
+		t.Error("receipt was sent to another user")
+	}
+	h.pressButton(alice, message(alice.ID), "🧾 Receipt")
+	if !h.called("sendDocument", alice.ID) {
+		t.Error("receipt was not sent")
+	}
+}
+
+func TestDonationMatching(t *testing.T) {
+	h := newTestHarness(t)
+	sponsor := &tb.User{ID: 8801, Username: "sponsor", FirstName: "Sponsor", LanguageCode: "en"}
+	group := &tb.Chat{ID: -8800, Type: tb.ChatGroup, Title: "charity"}
+	h.newUser(sponsor, 5000)
+
+	h.sendMessage(sponsor, group, "/donate match 1000 3d")
+	progress := h.lastMessage(group.ID)
+	if text := progress.Text(); !strings.Contains(text, "@sponsor matches donations up to 1000 sat") {
+		t.Fatalf("progress = %q", text)
+	}
+	campaigns := h.bot.activeMatchingCampaigns()
+	if len(campaigns) != 1 || campaigns[0].MessageId != progress.Id || time.Until(campaigns[0].Ends) < 71*time.Hour {
+		t.Fatalf("campaigns = %+v", campaigns)
+	}
+
+	// the campaign matches up to its cap
+	campaign := campaigns[0]
+	campaign.Matched = 800
+	if amount := campaign.matchAmount(500); amount != 200 {
+		t.Errorf("matched %d sat of 500 sat, want 200", amount)
+	}
+
+	h.sendMessage(sponsor, privateChat(sponsor), "/donate match stop")
+	if campaigns := h.bot.activeMatchingCampaigns(); len(campaigns) != 0 {
+		t.Errorf("%d active campaigns after stop, want 0", len(campaigns))
+	}
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "campaign is over") {
+		t.Errorf("progress after stop = %q", text)
+	}
+}
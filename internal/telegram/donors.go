@@ -0,0 +1,201 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/pdf"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const donorLeaderboardLength = 10
+
+var (
+	donationReceiptMenu = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnDonationReceipt  = donationReceiptMenu.Data("🧾 Receipt", "donation_receipt")
+)
+
+// Donation is a donation that a user made with /donate.
+type Donation struct {
+	ID           uint      `gorm:"primarykey"`
+	Time         time.Time `json:"time"`
+	FromId       int64     `json:"from_id" gorm:"index"`
+	FromUser     string    `json:"from_user"`
+	Amount       int64     `json:"amount"`
+	Address      string    `json:"address"`
+	Recurring    bool      `json:"recurring"`
+	PaymentHash  string    `json:"payment_hash"`
+	FiatValue    float64   `json:"fiat_value"`    // value at the time of payment
+	FiatCurrency string    `json:"fiat_currency"` // empty if there was no price
+	Fee          int64     `json:"fee"`           // service fee on top of the amount
+}
+
+// recordDonation stores a donation for the donor leaderboard and for its receipt.
+func (bot *TipBot) recordDonation(user *lnbits.User, address string, amount int64, paymentHash string, recurring bool) *Donation {
+	donation := &Donation{
+		Time:        time.Now(),
+		FromId:      user.Telegram.ID,
+		FromUser:    GetUserStr(user.Telegram),
+		Amount:      amount,
+		Address:     address,
+		Recurring:   recurring,
+		PaymentHash: paymentHash,
+	}
+	// test coins have no price
+	if internal.IsMainnet() {
+		currency := bot.getUserCurrency(user)
+		if currency == "" {
+			currency = "USD"
+		}
+		if fiat, err := SatoshisToFiat(amount, currency); err == nil {
+			donation.FiatValue, donation.FiatCurrency = fiat, currency
+		}
+	}
+	if tx := bot.DB.Transactions.Create(donation); tx.Error != nil {
+		log.Errorf("[recordDonation] could not store donation of %s: %v", GetUserStr(user.Telegram), tx.Error)
+		return nil
+	}
+	return donation
+}
+
+// donationReceipt returns the receipt of a donation as PDF document for the bookkeeping of its donor.
+func (bot *TipBot) donationReceipt(donation *Donation) []byte {
+	kind := "One-time donation"
+	if donation.Recurring {
+		kind = "Monthly donation"
+	}
+	fiat := "-"
+	if len(donation.FiatCurrency) > 0 {
+		fiat = fmt.Sprintf("%.2f %s", donation.FiatValue, donation.FiatCurrency)
+	}
+	rows := []pdf.Row{
+		{Label: "Receipt", Value: fmt.Sprintf("#%d", donation.ID)},
+		{Label: "Date", Value: donation.Time.UTC().Format("2 Jan 2006 15:04:05 MST")},
+		{Label: "Donor", Value: donation.FromUser},
+		{Label: "Recipient", Value: donation.Address},
+		{Label: "Type", Value: kind},
+		{Label: "Amount", Value: fmt.Sprintf("%d sat", donation.Amount)},
+		{Label: "Value at payment", Value: fiat},
+		{Label: "Payment hash", Value: donation.PaymentHash},
+	}
+	if donation.Fee > 0 {
+		// the fee goes to the operator of the bot, not to the recipient
+		rows = append(rows[:6], append([]pdf.Row{{Label: "Service fee", Value: fmt.Sprintf("%d sat", donation.Fee)}}, rows[6:]...)...)
+	}
+	return pdf.Document{
+		Title:  "Donation receipt",
+		Rows:   rows,
+		Footer: fmt.Sprintf("Paid over the Lightning Network with %s.", GetUserStr(bot.Telegram.Me)),
+	}.Bytes()
+}
+
+// makeDonationReceiptKeyboard returns a button that sends the receipt of a donation.
+func makeDonationReceiptKeyboard(ctx context.Context, donation *Donation) *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	if donation != nil {
+		menu.Inline(menu.Row(menu.Data(Translate(ctx, "receiptButtonMessage"), "donation_receipt", strconv.FormatUint(uint64(donation.ID), 10))))
+	}
+	return menu
+}
+
+// donationReceiptHandler sends the receipt of a donation to its donor.
+func (bot *TipBot) donationReceiptHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	id, err := strconv.ParseUint(ctx.Data(), 10, 64)
+	if err != nil {
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	donation := &Donation{}
+	if tx := bot.DB.Transactions.First(donation, id); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	if donation.FromId != c.Sender.ID {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	receipt := &tb.Document{
+		File:     tb.File{FileReader: bytes.NewReader(bot.donationReceipt(donation))},
+		FileName: fmt.Sprintf("donation-receipt-%d.pdf", donation.ID),
+		MIME:     "application/pdf",
+		Caption:  fmt.Sprintf(Translate(ctx, "donationReceiptCaption"), donation.Amount, donation.Time.UTC().Format("2 Jan 06")),
+	}
+	bot.trySendMessage(c.Sender, receipt)
+	return ctx, nil
+}
+
+// isPublicDonor returns true if the user opted in to be named as donor.
+func (bot *TipBot) isPublicDonor(user *tb.User) bool {
+	u, err := GetLnbitsUserWithSettings(user, *bot)
+	return err == nil && u.Settings.Donation.Public
+}
+
+// donationComment returns the comment of a donation, which names the donor only if they opted in.
+func (bot *TipBot) donationComment(user *tb.User, recurring bool) string {
+	kind := "Donation"
+	if recurring {
+		kind = "Monthly donation"
+	}
+	if bot.isPublicDonor(user) {
+		return fmt.Sprintf("%s from %s via bot %s", kind, GetUserStr(user), GetUserStr(bot.Telegram.Me))
+	}
+	return fmt.Sprintf("Anonymous %s via bot %s", strings.ToLower(kind), GetUserStr(bot.Telegram.Me))
+}
+
+// donationThankYou returns the thank-you message for a donation. Operators can set their
+// own template with {name} and {amount} in donation_thank_you.
+func donationThankYou(ctx intercept.Context, user *tb.User, amount int64) string {
+	template := internal.CurrentConfiguration().Bot.DonationThankYou
+	if len(template) == 0 {
+		return Translate(ctx, "donationSuccess")
+	}
+	return strings.NewReplacer("{name}", GetUserStrMd(user), "{amount}", fmt.Sprint(amount)).Replace(str.MarkdownEscape(template))
+}
+
+type donorTotal struct {
+	FromId int64
+	Total  int64
+}
+
+// donorLeaderboardHandler is invoked on /donate top and lists the donors who opted in with the largest sums.
+func (bot *TipBot) donorLeaderboardHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	totals := make([]donorTotal, 0)
+	tx := bot.DB.Transactions.Model(&Donation{}).
+		Select("from_id, sum(amount) as total").
+		Group("from_id").
+		Order("total desc").
+		Limit(donorLeaderboardLength * 5).
+		Scan(&totals)
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	leaderboard := ""
+	rank := 0
+	for _, total := range totals {
+		user, err := GetLnbitsUserWithSettings(&tb.User{ID: total.FromId}, *bot)
+		if err != nil || !user.Settings.Donation.Public || user.Telegram == nil {
+			continue
+		}
+		rank++
+		leaderboard += fmt.Sprintf("%d. %s: %d sat\n", rank, GetUserStrMd(user.Telegram), total.Total)
+		if rank == donorLeaderboardLength {
+			break
+		}
+	}
+	if rank == 0 {
+		leaderboard = Translate(ctx, "donorLeaderboardEmptyMessage") + "\n"
+	}
+	var own int64
+	bot.DB.Transactions.Model(&Donation{}).Where("from_id = ?", m.Sender.ID).Select("coalesce(sum(amount), 0)").Scan(&own)
+	bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "donorLeaderboardMessage"), leaderboard, own))
+	return ctx, nil
+}
@@ -0,0 +1,145 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	dropDateLayout = "2006-01-02"
+	dropTimeLayout = "2006-01-02 15:04 UTC"
+	// dropCheckInterval is how often the countdowns in the previews of drops are updated
+	dropCheckInterval = 5 * time.Minute
+)
+
+// DropPreview is a posted preview of a drop. The bot edits it until the drop is free.
+type DropPreview struct {
+	ID           uint      `gorm:"primarykey"`
+	CreatedAt    time.Time `json:"created_at"`
+	MediaId      uint      `json:"media_id" gorm:"index"`
+	ChatId       int64     `json:"chat_id"`
+	MessageId    int       `json:"message_id"`
+	LanguageCode string    `json:"language_code"`
+	Text         string    `json:"text"` // the text of the last edit
+}
+
+// parseDropTime splits "<title> free <when>" into the title and the time the drop is free.
+// <when> is a date like 2024-12-24 or a duration like 3d or 12h. Without "free", the time is nil.
+func parseDropTime(text string) (string, *time.Time, error) {
+	fields := strings.Fields(text)
+	if len(fields) < 2 || strings.ToLower(fields[len(fields)-2]) != "free" {
+		return strings.TrimSpace(text), nil, nil
+	}
+	when := strings.ToLower(fields[len(fields)-1])
+	var freeAt time.Time
+	if date, err := time.Parse(dropDateLayout, when); err == nil {
+		freeAt = date
+	} else if days, err := strconv.ParseUint(strings.TrimSuffix(when, "d"), 10, 64); strings.HasSuffix(when, "d") && err == nil {
+		freeAt = time.Now().Add(time.Duration(days) * 24 * time.Hour)
+	} else if d, err := time.ParseDuration(when); err == nil {
+		freeAt = time.Now().Add(d)
+	} else {
+		return "", nil, fmt.Errorf("invalid drop time %s", when)
+	}
+	if !freeAt.After(time.Now()) {
+		return "", nil, fmt.Errorf("drop time %s is in the past", when)
+	}
+	return strings.Join(fields[:len(fields)-2], " "), &freeAt, nil
+}
+
+// dropCountdownStr formats the time until a drop is free in days and hours, or hours and minutes
+// on the last day.
+func dropCountdownStr(d time.Duration) string {
+	if d < 24*time.Hour {
+		return formatPendingDuration(d)
+	}
+	d = d.Round(time.Hour)
+	return fmt.Sprintf("%dd %dh", int(d.Hours())/24, int(d.Hours())%24)
+}
+
+func (bot *TipBot) saveDropPreview(media *PaidMedia, preview *tb.Message, languageCode string, text string) {
+	dropPreview := &DropPreview{
+		CreatedAt:    time.Now(),
+		MediaId:      media.ID,
+		ChatId:       preview.Chat.ID,
+		MessageId:    preview.ID,
+		LanguageCode: languageCode,
+		Text:         text,
+	}
+	if tx := bot.DB.Transactions.Create(dropPreview); tx.Error != nil {
+		log.Errorf("[saveDropPreview] could not save preview of %d: %v", media.ID, tx.Error)
+	}
+}
+
+// startDropWorker updates the countdowns of drops and releases drops that are free.
+func (bot *TipBot) startDropWorker() {
+	go func() {
+		for {
+			if !isShuttingDown() {
+				for _, id := range bot.pendingDrops() {
+					bot.processDrop(id)
+				}
+			}
+			time.Sleep(dropCheckInterval)
+		}
+	}()
+}
+
+// pendingDrops returns the ids of active drops that were not released yet.
+func (bot *TipBot) pendingDrops() []uint {
+	ids := make([]uint, 0)
+	bot.DB.Transactions.Model(&PaidMedia{}).
+		Where("active = ? AND released = ? AND free_at IS NOT NULL", true, false).
+		Pluck("id", &ids)
+	return ids
+}
+
+func (bot *TipBot) processDrop(mediaId uint) {
+	beginInFlight()
+	defer endInFlight()
+	// other instances of a cluster work on the same drops
+	id := fmt.Sprintf("paid-media:%d", mediaId)
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	media, err := bot.loadPaidMedia(strconv.FormatUint(uint64(mediaId), 10))
+	if err != nil || media.Released {
+		return
+	}
+	seller, err := GetLnbitsUser(&tb.User{ID: media.SellerId}, *bot)
+	if err != nil {
+		log.Errorf("[processDrop] seller of %s: %v", id, err)
+		return
+	}
+	previews := make([]DropPreview, 0)
+	bot.DB.Transactions.Where("media_id = ?", media.ID).Find(&previews)
+	for _, preview := range previews {
+		text, menu := paidMediaPreview(preview.LanguageCode, media, seller.Telegram)
+		if text == preview.Text {
+			continue
+		}
+		message := &tb.StoredMessage{MessageID: strconv.Itoa(preview.MessageId), ChatID: preview.ChatId}
+		if _, err := bot.tryEditMessage(message, text, menu); err != nil {
+			continue
+		}
+		bot.DB.Transactions.Model(&preview).Update("text", text)
+	}
+	if !media.isFree() {
+		return
+	}
+	if tx := bot.DB.Transactions.Model(media).Update("released", true); tx.Error != nil {
+		log.Errorf("[processDrop] could not release %s: %v", id, tx.Error)
+		return
+	}
+	// the previews show that the drop is free, they are not edited anymore
+	bot.DB.Transactions.Where("media_id = ?", media.ID).Delete(&DropPreview{})
+	log.Infof("[⏰ drop] %s of %s is free after %d early unlocks", media.Title, GetUserStr(seller.Telegram), media.Sales)
+	bot.trySendMessage(seller.Telegram, fmt.Sprintf(i18n.Translate(seller.Telegram.LanguageCode, "ppvDropReleasedMessage"), str.MarkdownEscape(media.Title), media.Sales, media.Revenue))
+}
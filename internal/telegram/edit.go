@@ -82,3 +82,19 @@ func (bot TipBot) tryEditStack(to tb.Editable, key string, what interface{}, opt
 	editStack.Set(key, e)
 	log.Tracef("[tryEditStack] Added message %s to edit stack. len(editStack)=%d", key, len(editStack.Keys()))
 }
+
+// flushEditStack edits all messages in the editStack that were not edited yet.
+func (bot TipBot) flushEditStack() {
+	for _, k := range editStack.Keys() {
+		if e, ok := editStack.Get(k); ok {
+			editFromStack := e.(edit)
+			if !editFromStack.edited {
+				_, err := bot.tryEditMessage(editFromStack.to, editFromStack.what, editFromStack.options...)
+				if err != nil {
+					log.Errorf("[flushEditStack] Edit error: %s", err.Error())
+				}
+			}
+			editStack.Remove(k)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// escrowDefaultDays is how long users have to claim a send before it is refunded.
+	escrowDefaultDays = 7
+	escrowStartPrefix = "claim_"
+	escrowTokenLength = 16
+)
+
+// EscrowedSend holds a send to a Telegram user without a wallet in the escrow wallet until
+// the user starts the bot and claims it.
+type EscrowedSend struct {
+	*storage.Base
+	Token        string       `json:"token"`
+	Amount       int64        `json:"amount"`
+	Memo         string       `json:"memo"`
+	From         *lnbits.User `json:"from"`
+	ToUsername   string       `json:"to_username"` // without @
+	ClaimedBy    *tb.User     `json:"claimed_by,omitempty"`
+	Expires      time.Time    `json:"expires"`
+	LanguageCode string       `json:"languagecode"`
+}
+
+func escrowID(token string) string {
+	return fmt.Sprintf("escrow:%s", token)
+}
+
+// escrowDuration is how long a send waits for its receiver, bot.escrow_days in the config.
+func escrowDuration() time.Duration {
+	days := internal.Configuration.Bot.EscrowDays
+	if days <= 0 {
+		days = escrowDefaultDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// canEscrow returns true if a send to the username can wait for the user to start the bot.
+func canEscrow(username string) bool {
+	return contactUsernameRegex.MatchString(username)
+}
+
+func (escrowed *EscrowedSend) deepLink(bot *TipBot) string {
+	return bot.deepLink(escrowStartPrefix + escrowed.Token)
+}
+
+// escrowSend is invoked when the sender confirms a send to a user without a wallet. The amount
+// goes to the escrow wallet until the receiver claims it.
+func (bot *TipBot) escrowSend(ctx intercept.Context, sendData *SendData, from *lnbits.User) (intercept.Context, error) {
+	c := ctx.Callback()
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		log.Errorf("[escrowSend] %v", err)
+		bot.tryEditMessage(c.Message, i18n.Translate(sendData.LanguageCode, "sendErrorMessage"), &tb.ReplyMarkup{})
+		return ctx, err
+	}
+	escrowed := &EscrowedSend{
+		Token:        RandStringRunes(escrowTokenLength),
+		Amount:       sendData.Amount,
+		Memo:         sendData.Memo,
+		From:         from,
+		ToUsername:   strings.ToLower(sendData.ToTelegramUser),
+		Expires:      time.Now().Add(escrowDuration()),
+		LanguageCode: sendData.LanguageCode,
+	}
+	escrowed.Base = storage.New(storage.ID(escrowID(escrowed.Token)))
+	t := NewTransaction(bot, from, escrow, sendData.Amount, TransactionType("escrow"), TransactionIdempotencyKey(idempotencyKey(ctx)), TransactionContext(ctx))
+	t.Memo = fmt.Sprintf("🔒 Send from %s to @%s in escrow.", GetUserStr(from.Telegram), escrowed.ToUsername)
+	success, err := t.Send()
+	if isDuplicateOperation(err) {
+		return ctx, err
+	}
+	if !success {
+		logger(ctx).Errorf("[escrowSend] Transaction failed: %v", err)
+		bot.tryEditMessage(c.Message, i18n.Translate(sendData.LanguageCode, "sendErrorMessage"), &tb.ReplyMarkup{})
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	sendData.Inactivate(sendData, bot.Bunt)
+	runtime.IgnoreError(escrowed.Set(escrowed, bot.Bunt))
+	bot.startEscrowTimer(escrowed)
+	logger(ctx).Infof("[🔒 escrow] %s sent %d sat to @%s in escrow %s", GetUserStr(from.Telegram), escrowed.Amount, escrowed.ToUsername, escrowed.ID)
+
+	toUserStrMd := str.MarkdownEscape("@" + escrowed.ToUsername)
+	sent := fmt.Sprintf(i18n.Translate(sendData.LanguageCode, "escrowSentMessage"), escrowed.Amount, toUserStrMd, escrowed.deepLink(bot), escrowed.Expires.UTC().Format("2 Jan 06 15:04 MST"))
+	if c.Message.Private() {
+		bot.tryDeleteMessage(c.Message)
+	}
+	t.SetSenderMessage(bot.trySendMessage(c.Sender, sent, tb.NoPreview))
+	if c.Message.Private() {
+		return ctx, nil
+	}
+	// the receiver can claim it from the group
+	public := fmt.Sprintf(i18n.Translate(sendData.LanguageCode, "escrowPublicMessage"), escrowed.Amount, GetUserStrMd(from.Telegram), toUserStrMd, escrowed.deepLink(bot))
+	bot.tryEditMessage(c.Message, public, &tb.ReplyMarkup{}, tb.NoPreview)
+	return ctx, nil
+}
+
+// startEscrowHandler is invoked on /start claim_<token>, after the wallet of a new user was created.
+func (bot *TipBot) startEscrowHandler(ctx intercept.Context, token string) (intercept.Context, error) {
+	return bot.claimEscrow(ctx, LoadUser(ctx), token)
+}
+
+// claimEscrow pays an escrowed send to its receiver. Only the user with the username that the
+// sender sent to can claim it.
+func (bot *TipBot) claimEscrow(ctx intercept.Context, user *lnbits.User, token string) (intercept.Context, error) {
+	id := escrowID(token)
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	escrowed := &EscrowedSend{Base: storage.New(storage.ID(id))}
+	sn, err := escrowed.Get(escrowed, bot.Bunt)
+	if err != nil {
+		bot.trySendMessage(user.Telegram, Translate(ctx, "escrowInvalidMessage"))
+		return ctx, errors.New(errors.NotActiveError, err)
+	}
+	escrowed = sn.(*EscrowedSend)
+	if !escrowed.Active {
+		bot.trySendMessage(user.Telegram, Translate(ctx, "escrowInvalidMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if !strings.EqualFold(user.Telegram.Username, escrowed.ToUsername) {
+		bot.trySendMessage(user.Telegram, fmt.Sprintf(Translate(ctx, "escrowNotYoursMessage"), str.MarkdownEscape("@"+escrowed.ToUsername)))
+		return ctx, fmt.Errorf("%s can't claim %s", GetUserStr(user.Telegram), escrowed.ID)
+	}
+	if time.Now().After(escrowed.Expires) {
+		bot.refundEscrow(escrowed)
+		bot.trySendMessage(user.Telegram, Translate(ctx, "escrowInvalidMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		return ctx, err
+	}
+	t := NewTransaction(bot, escrow, user, escrowed.Amount, TransactionType("escrow claim"), TransactionIdempotencyKey(escrowed.ID+":claim"))
+	t.Memo = fmt.Sprintf("🔒 Send from %s to %s claimed.", GetUserStr(escrowed.From.Telegram), GetUserStr(user.Telegram))
+	success, err := t.Send()
+	if err == errDuplicateOperation {
+		// the send was paid out before
+		runtime.IgnoreError(escrowed.Inactivate(escrowed, bot.Bunt))
+		bot.trySendMessage(user.Telegram, Translate(ctx, "escrowInvalidMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if !success {
+		log.Errorf("[🔒 escrow] could not pay out %s to %s: %v", escrowed.ID, GetUserStr(user.Telegram), err)
+		bot.trySendMessage(user.Telegram, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	escrowed.ClaimedBy = user.Telegram
+	runtime.IgnoreError(escrowed.Inactivate(escrowed, bot.Bunt))
+	log.Infof("[🔒 escrow] %s claimed %s of %d sat", GetUserStr(user.Telegram), escrowed.ID, escrowed.Amount)
+
+	message := fmt.Sprintf(Translate(ctx, "sendReceivedMessage"), GetUserStrMd(escrowed.From.Telegram), escrowed.Amount)
+	if len(escrowed.Memo) > 0 {
+		message += fmt.Sprintf("\n✉️ %s", str.MarkdownEscape(escrowed.Memo))
+	}
+	bot.trySendMessage(user.Telegram, message)
+	bot.notify(escrowed.From, NotificationPayment, escrowed.Amount, fmt.Sprintf(i18n.Translate(escrowed.LanguageCode, "escrowClaimedMessage"), GetUserStrMd(user.Telegram), escrowed.Amount))
+	return ctx, nil
+}
+
+// escrowedSends returns the active escrowed sends to the username.
+func (bot *TipBot) escrowedSends(username string) []*EscrowedSend {
+	var sends []*EscrowedSend
+	if len(username) == 0 {
+		return sends
+	}
+	bot.Bunt.Ascend("escrow", func(key, value string) bool {
+		escrowed := &EscrowedSend{}
+		if err := json.Unmarshal([]byte(value), escrowed); err == nil && escrowed.Base != nil && escrowed.Active &&
+			strings.EqualFold(escrowed.ToUsername, username) {
+			sends = append(sends, escrowed)
+		}
+		return true // continue iteration
+	})
+	return sends
+}
+
+// sendEscrowClaims sends the user who started the bot the claim links of the sends that wait
+// for them.
+func (bot *TipBot) sendEscrowClaims(ctx intercept.Context) {
+	for _, escrowed := range bot.escrowedSends(ctx.Sender().Username) {
+		message := fmt.Sprintf(Translate(ctx, "escrowWaitingMessage"), GetUserStrMd(escrowed.From.Telegram), escrowed.Amount, escrowed.deepLink(bot))
+		bot.trySendMessage(ctx.Sender(), message, tb.NoPreview)
+	}
+}
+
+// refundEscrow sends the amount of an unclaimed send back to its sender.
+func (bot *TipBot) refundEscrow(escrowed *EscrowedSend) {
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		log.Errorf("[🔒 escrow] could not refund %s: %v", escrowed.ID, err)
+		return
+	}
+	from, err := GetUser(escrowed.From.Telegram, *bot)
+	if err != nil {
+		log.Errorf("[🔒 escrow] could not refund %s: %v", escrowed.ID, err)
+		return
+	}
+	t := NewTransaction(bot, escrow, from, escrowed.Amount, TransactionType("escrow refund"), TransactionIdempotencyKey(escrowed.ID+":refund"))
+	t.Memo = fmt.Sprintf("🔒 Refund of the unclaimed send of %s to @%s.", GetUserStr(from.Telegram), escrowed.ToUsername)
+	success, err := t.Send()
+	if !success {
+		// the claim expiry worker tries again
+		log.Errorf("[🔒 escrow] could not refund %s: %v", escrowed.ID, err)
+		return
+	}
+	runtime.IgnoreError(escrowed.Inactivate(escrowed, bot.Bunt))
+	log.Infof("[🔒 escrow] refunded %d sat of %s to %s", escrowed.Amount, escrowed.ID, GetUserStr(from.Telegram))
+	bot.notify(from, NotificationPayment, escrowed.Amount, fmt.Sprintf(i18n.Translate(escrowed.LanguageCode, "escrowRefundedMessage"), escrowed.Amount, str.MarkdownEscape("@"+escrowed.ToUsername)))
+}
+
+// startEscrowTimer refunds a send that was not claimed when it expires.
+func (bot *TipBot) startEscrowTimer(escrowed *EscrowedSend) {
+	time.AfterFunc(time.Until(escrowed.Expires), func() {
+		bot.expireEscrow(escrowed.ID)
+	})
+}
+
+func (bot *TipBot) expireEscrow(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	escrowed := &EscrowedSend{Base: storage.New(storage.ID(id))}
+	sn, err := escrowed.Get(escrowed, bot.Bunt)
+	if err != nil {
+		log.Errorf("[expireEscrow] %s: %v", id, err)
+		return
+	}
+	escrowed = sn.(*EscrowedSend)
+	if !escrowed.Active {
+		return
+	}
+	bot.refundEscrow(escrowed)
+}
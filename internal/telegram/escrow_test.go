@@ -0,0 +1,67 @@
+package telegram
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var testEscrowLinkRegex = regexp.MustCompile(`claim_([a-zA-Z]+)`)
+
+func TestEscrowClaim(t *testing.T) {
+	h := newTestHarness(t)
+	escrow := h.newUser(testBotUser, 0)
+	alice := &tb.User{ID: 9991, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	newbie := &tb.User{ID: 9992, Username: "newbie", FirstName: "Newbie", LanguageCode: "en"}
+	mallory := &tb.User{ID: 9993, Username: "mallory", FirstName: "Mallory", LanguageCode: "en"}
+	from := h.newUser(alice, 1000)
+	h.newUser(mallory, 0)
+
+	h.sendMessage(alice, privateChat(alice), "/send 100 @newbie welcome!")
+	confirmation := h.lastMessage(alice.ID)
+	if !strings.Contains(confirmation.Text(), "no wallet yet") {
+		t.Fatalf("confirmation = %q", confirmation.Text())
+	}
+	h.pressButton(alice, confirmation, "✅ Send")
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 100 {
+		t.Fatalf("balance of the escrow = %d, want 100", balance)
+	}
+	token := testEscrowLinkRegex.FindStringSubmatch(h.lastMessage(alice.ID).Text())
+	if token == nil {
+		t.Fatalf("message to alice = %q", h.lastMessage(alice.ID).Text())
+	}
+
+	// only newbie can claim
+	h.sendMessage(mallory, privateChat(mallory), "/start claim_"+token[1])
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 100 {
+		t.Fatalf("mallory claimed the send of newbie")
+	}
+
+	// newbie gets the claim link when starting the bot
+	h.sendMessage(newbie, privateChat(newbie), "/start")
+	if text := h.lastMessage(newbie.ID).Text(); !strings.Contains(text, "claim_"+token[1]) {
+		t.Fatalf("message to newbie = %q", text)
+	}
+	h.sendMessage(newbie, privateChat(newbie), "/start claim_"+token[1])
+	to, err := GetUser(newbie, *h.bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 100 {
+		t.Errorf("balance of newbie = %d, want 100", balance)
+	}
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "claimed your 100 sat") {
+		t.Errorf("message to alice = %q", text)
+	}
+
+	// unclaimed sends go back to the sender
+	h.sendMessage(alice, privateChat(alice), "/send 200 @latecomer")
+	h.pressButton(alice, h.lastMessage(alice.ID), "✅ Send")
+	token = testEscrowLinkRegex.FindStringSubmatch(h.lastMessage(alice.ID).Text())
+	h.bot.expireEscrow(escrowID(token[1]))
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 900 {
+		t.Errorf("balance of alice after the refund = %d, want 900", balance)
+	}
+}
@@ -43,6 +43,8 @@ type InlineFaucet struct {
 	NTaken          int            `json:"inline_faucet_ntaken"`
 	UserNeedsWallet bool           `json:"inline_faucet_userneedswallet"`
 	LanguageCode    string         `json:"languagecode"`
+	FaucetMessage   *tb.Message    `json:"inline_faucet_faucetmessage,omitempty"`
+	LinkToken       string         `json:"inline_faucet_linktoken,omitempty"`
 }
 
 func (bot TipBot) mapFaucetLanguage(ctx context.Context, command string) context.Context {
@@ -191,13 +193,20 @@ func (bot TipBot) faucetHandler(ctx intercept.Context) (intercept.Context, error
 		return ctx, err
 	}
 	fromUserStr := GetUserStr(ctx.Message().Sender)
-	mFaucet := bot.trySendMessage(ctx.Message().Chat, inlineFaucet.Message, bot.makeFaucetKeyboard(ctx, inlineFaucet.ID))
+	mFaucet := bot.trySendMessage(bot.topicOf(ctx.Message()), inlineFaucet.Message, bot.makeFaucetKeyboard(ctx, inlineFaucet.ID))
 	log.Infof("[faucet] %s created faucet %s: %d sat (%d per user)", fromUserStr, inlineFaucet.ID, inlineFaucet.Amount, inlineFaucet.PerUserAmount)
 
 	// log faucet link if possible
 	if mFaucet != nil && mFaucet.Chat != nil {
 		log.Infof("[faucet] Link: https://t.me/c/%s/%d", strconv.FormatInt(mFaucet.Chat.ID, 10)[4:], mFaucet.ID)
 	}
+	// send the creator a link to share the faucet outside of Telegram
+	if mFaucet != nil {
+		inlineFaucet.FaucetMessage = mFaucet
+		inlineFaucet.LinkToken = newPaymentLinkToken()
+		link := bot.createPaymentLink(inlineFaucet.LinkToken, PaymentLinkTypeFaucet, inlineFaucet.From, PaymentLinkTarget(inlineFaucet.ID))
+		bot.sendPaymentLink(ctx, ctx.Message().Sender, link)
+	}
 	return ctx, inlineFaucet.Set(inlineFaucet, bot.Bunt)
 }
 
@@ -241,7 +250,12 @@ func (bot TipBot) handleInlineFaucetQuery(ctx intercept.Context) (intercept.Cont
 }
 
 func (bot *TipBot) acceptInlineFaucetHandler(ctx intercept.Context) (intercept.Context, error) {
-	c := ctx.Callback()
+	return bot.acceptInlineFaucet(ctx, ctx.Callback())
+}
+
+// acceptInlineFaucet pays out the faucet in c.Data to the user in ctx.
+// c can also be a synthetic callback when the faucet is collected with a deep link.
+func (bot *TipBot) acceptInlineFaucet(ctx intercept.Context, c *tb.Callback) (intercept.Context, error) {
 	to := LoadUser(ctx)
 	tx := &InlineFaucet{Base: storage.New(storage.ID(c.Data))}
 	mutex.LockWithContext(ctx, tx.ID)
@@ -305,7 +319,7 @@ func (bot *TipBot) acceptInlineFaucetHandler(ctx intercept.Context) (intercept.C
 
 		// todo: user new get username function to get userStrings
 		transactionMemo := fmt.Sprintf("🚰 Faucet from %s to %s.", fromUserStr, toUserStr)
-		t := NewTransaction(bot, from, to, inlineFaucet.PerUserAmount, TransactionType("faucet"))
+		t := NewTransaction(bot, from, to, inlineFaucet.PerUserAmount, TransactionType("faucet"), TransactionContext(ctx))
 		t.Memo = transactionMemo
 
 		success, err := t.Send()
@@ -329,7 +343,7 @@ func (bot *TipBot) acceptInlineFaucetHandler(ctx intercept.Context) (intercept.C
 		go func() {
 			to_message := fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "inlineFaucetReceivedMessage"), fromUserStrMd, inlineFaucet.PerUserAmount)
 			ctx.Context = context.WithValue(ctx, "callback_response", to_message)
-			bot.trySendMessage(to.Telegram, to_message)
+			bot.notify(to, NotificationFaucet, inlineFaucet.PerUserAmount, to_message)
 			bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "inlineFaucetSentMessage"), inlineFaucet.PerUserAmount, toUserStrMd))
 		}()
 
@@ -382,6 +396,9 @@ func (bot *TipBot) cancelInlineFaucet(ctx context.Context, c *tb.Callback, ignor
 		}
 		log.Debugf("[faucet] Faucet %s canceled.", inlineFaucet.ID)
 		once.Remove(inlineFaucet.ID)
+		if len(inlineFaucet.LinkToken) > 0 {
+			bot.inactivatePaymentLink(inlineFaucet.LinkToken)
+		}
 	}
 	return ctx, nil
 }
@@ -395,6 +412,9 @@ func (bot *TipBot) finishFaucet(ctx context.Context, c *tb.Callback, inlineFauce
 
 	log.Debugf("[faucet] Faucet finished %s", inlineFaucet.ID)
 	once.Remove(inlineFaucet.ID)
+	if len(inlineFaucet.LinkToken) > 0 {
+		bot.inactivatePaymentLink(inlineFaucet.LinkToken)
+	}
 	// send update to faucet creator
 	if inlineFaucet.Active && inlineFaucet.From.Telegram.ID != 0 {
 		bot.trySendMessage(inlineFaucet.From.Telegram, listFaucetTakers(inlineFaucet))
@@ -419,3 +439,24 @@ func (bot *TipBot) cancelInlineFaucetHandler(ctx intercept.Context) (intercept.C
 	return ctx, err
 
 }
+
+// collectFaucetFromLink is invoked when a user opens the deep link of a faucet.
+func (bot *TipBot) collectFaucetFromLink(ctx intercept.Context, link *PaymentLink) (intercept.Context, error) {
+	inlineFaucet := &InlineFaucet{Base: storage.New(storage.ID(link.Target))}
+	fn, err := inlineFaucet.Get(inlineFaucet, bot.Bunt)
+	if err != nil || fn.(*InlineFaucet).FaucetMessage == nil {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "paymentLinkInvalidMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	inlineFaucet = fn.(*InlineFaucet)
+	ctx, err = bot.acceptInlineFaucet(ctx, &tb.Callback{Sender: ctx.Sender(), Data: inlineFaucet.ID, Message: inlineFaucet.FaucetMessage})
+	// there is no callback to answer, send the response as a message instead
+	if err != nil {
+		if response, ok := ctx.Value("callback_response").(string); ok && len(response) > 0 {
+			bot.trySendMessage(ctx.Sender(), response)
+		} else {
+			bot.trySendMessage(ctx.Sender(), Translate(ctx, "paymentLinkInactiveMessage"))
+		}
+	}
+	return ctx, err
+}
@@ -0,0 +1,100 @@
+package telegram
+
+import (
+	"fmt"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	log "github.com/sirupsen/logrus"
+)
+
+const serviceFeeTransactionType = "service fee"
+
+// serviceFeeTypes are the transfers that the operator can charge a fee on.
+var serviceFeeTypes = map[string]bool{
+	"tip":            true,
+	"tipall":         true,
+	"rain":           true,
+	"send":           true,
+	"inline send":    true,
+	"inline receive": true,
+	"donation":       true,
+}
+
+// serviceFee returns the fee that sender pays to the operator on top of a transfer of amount sat.
+func serviceFee(sender *lnbits.User, transactionType string, amount int64) int64 {
+	c := internal.Configuration.Fee
+	if c.BasisPoints <= 0 || internal.Configuration.Bot.OperatorId == 0 || !serviceFeeTypes[transactionType] {
+		return 0
+	}
+	if amount < c.FreeBelow || sender == nil || sender.Telegram == nil || sender.Telegram.ID == internal.Configuration.Bot.OperatorId {
+		return 0
+	}
+	for _, id := range c.ExemptUsers {
+		if id == sender.Telegram.ID {
+			return 0
+		}
+	}
+	fee := amount * c.BasisPoints / 10000
+	if fee < c.Min {
+		fee = c.Min
+	}
+	if c.Max > 0 && fee > c.Max {
+		fee = c.Max
+	}
+	return fee
+}
+
+// collectServiceFee moves the fee of a transfer from the sender to the wallet of the operator.
+// key is the idempotency key of the transfer, the fee is only collected once per key.
+func (bot *TipBot) collectServiceFee(from *lnbits.User, fee int64, key string) error {
+	operator, err := bot.OperatorWallet()
+	if err != nil {
+		return err
+	}
+	var opts []TransactionOption
+	opts = append(opts, TransactionType(serviceFeeTransactionType))
+	if len(key) > 0 {
+		opts = append(opts, TransactionIdempotencyKey(key+":fee"))
+	}
+	t := NewTransaction(bot, from, operator, fee, opts...)
+	t.Memo = fmt.Sprintf("🧾 Service fee of %s.", GetUserStr(from.Telegram))
+	success, err := t.Send()
+	if !success && err != errDuplicateOperation {
+		log.Errorf("[fee] could not collect %d sat from %s: %v", fee, GetUserStr(from.Telegram), err)
+		return err
+	}
+	return nil
+}
+
+// feeStr is appended to the confirmations of transfers with a service fee.
+func feeStr(languageCode string, fee int64) string {
+	if fee <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(i18n.Translate(languageCode, "serviceFeeMessage"), fee)
+}
+
+// collectDonationFee collects the service fee of a donation that was paid to a lightning
+// address and stores it with the donation. It returns the fee that was paid.
+func (bot *TipBot) collectDonationFee(user *lnbits.User, amount int64, paymentHash string, donation *Donation) int64 {
+	fee := serviceFee(user, "donation", amount)
+	if fee == 0 || bot.collectServiceFee(user, fee, "donation:"+paymentHash) != nil {
+		return 0
+	}
+	if donation != nil {
+		donation.Fee = fee
+		if tx := bot.DB.Transactions.Model(donation).Update("fee", fee); tx.Error != nil {
+			log.Errorf("[fee] could not store the fee of donation %d: %v", donation.ID, tx.Error)
+		}
+	}
+	return fee
+}
+
+func donationFeeStr(languageCode string, donation *Donation) string {
+	if donation == nil {
+		return ""
+	}
+	return feeStr(languageCode, donation.Fee)
+}
@@ -0,0 +1,70 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func setTestServiceFee(t *testing.T, operatorId int64, fee internal.FeeConfiguration) {
+	configuration := internal.Configuration
+	t.Cleanup(func() { internal.Configuration = configuration })
+	internal.Configuration.Bot.OperatorId = operatorId
+	internal.Configuration.Fee = fee
+}
+
+func TestServiceFee(t *testing.T) {
+	setTestServiceFee(t, 1, internal.FeeConfiguration{BasisPoints: 100, Min: 2, Max: 50, FreeBelow: 10, ExemptUsers: []int64{3}})
+	sender := &lnbits.User{Telegram: &tb.User{ID: 2}}
+	for _, test := range []struct {
+		user            *lnbits.User
+		transactionType string
+		amount          int64
+		fee             int64
+	}{
+		{sender, "tip", 1000, 10},
+		{sender, "tip", 100, 2},                                   // floor
+		{sender, "send", 100000, 50},                              // ceiling
+		{sender, "tip", 5, 0},                                     // free below
+		{sender, "gift", 1000, 0},                                 // no fee on this type
+		{&lnbits.User{Telegram: &tb.User{ID: 3}}, "tip", 1000, 0}, // exempt
+		{&lnbits.User{Telegram: &tb.User{ID: 1}}, "tip", 1000, 0}, // the operator
+	} {
+		if fee := serviceFee(test.user, test.transactionType, test.amount); fee != test.fee {
+			t.Errorf("serviceFee(%d, %s, %d) = %d, want %d", test.user.Telegram.ID, test.transactionType, test.amount, fee, test.fee)
+		}
+	}
+}
+
+func TestTipServiceFee(t *testing.T) {
+	h := newTestHarness(t)
+	operatorUser := &tb.User{ID: 9401, Username: "operator", FirstName: "Operator", LanguageCode: "en"}
+	sender := &tb.User{ID: 9402, Username: "sender", FirstName: "Sender", LanguageCode: "en"}
+	receiver := &tb.User{ID: 9403, Username: "receiver", FirstName: "Receiver", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9400, Type: tb.ChatGroup, Title: "group"}
+	operator := h.newUser(operatorUser, 0)
+	from := h.newUser(sender, 1000)
+	h.newUser(receiver, 0)
+	setTestServiceFee(t, operatorUser.ID, internal.FeeConfiguration{BasisPoints: 100, Min: 1})
+
+	post := h.sendMessage(receiver, group, "gm")
+	h.sendReply(sender, group, "/tip 500", post)
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 495 {
+		t.Errorf("balance of the sender = %d, want 495", balance)
+	}
+	if balance := h.lnbits.Balance(operator.Wallet.ID); balance != 5 {
+		t.Errorf("balance of the operator = %d, want 5", balance)
+	}
+	if text := h.lastMessage(sender.ID).Text(); !strings.Contains(text, "Service fee: 5 sat") {
+		t.Errorf("confirmation = %q", text)
+	}
+
+	// the balance must cover the fee as well
+	h.sendReply(sender, group, "/tip 495", post)
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 495 {
+		t.Errorf("balance of the sender = %d after a tip without balance for the fee, want 495", balance)
+	}
+}
@@ -11,7 +11,13 @@ import (
 func (bot *TipBot) fileHandler(ctx intercept.Context) (intercept.Context, error) {
 	m := ctx.Message()
 	if m.Chat.Type != tb.ChatPrivate {
-		return ctx, errors.Create(errors.NoPrivateChatError)
+		return bot.tipMediaHandler(ctx)
+	}
+	if bot.isPaidMediaCaption(m) {
+		return bot.addPaidMediaHandler(ctx)
+	}
+	if bot.isPayoutCaption(m) {
+		return bot.payoutFileHandler(ctx)
 	}
 	user := LoadUser(ctx)
 	if c := stateCallbackMessage[user.StateKey]; c != nil {
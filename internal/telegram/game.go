@@ -0,0 +1,433 @@
+package telegram
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	GameKindFlip = "flip"
+	GameKindDice = "dice"
+	// gameDuration is how long a challenge waits to be accepted before the stake is refunded
+	gameDuration = 10 * time.Minute
+	// gameDefaultLimit is the largest stake in groups that turned games on without a limit
+	gameDefaultLimit = 10000
+)
+
+var (
+	gameMenu      = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnAcceptGame = gameMenu.Data("✅ Accept", "accept_game")
+	btnCancelGame = gameMenu.Data("🚫 Cancel", "cancel_game")
+)
+
+// Game is a coin flip or a dice game of two group members. Both stakes are held in the escrow
+// wallet and the winner gets both. There is no house, a tie of the dice refunds both stakes.
+//
+// The outcome is provably fair: the bot commits to a random seed with its SHA-256 hash when the
+// challenge is posted and reveals the seed with the outcome. The outcome is the SHA-256 hash of
+// "<seed>:<game id>:<opponent id>", so neither player nor the bot can pick it after the challenge.
+type Game struct {
+	*storage.Base
+	Kind         string       `json:"kind"`
+	Amount       int64        `json:"amount"` // stake of each player
+	Challenger   *lnbits.User `json:"challenger"`
+	Opponent     *lnbits.User `json:"opponent,omitempty"` // nil if anyone can accept
+	Accepted     bool         `json:"accepted"`
+	Seed         string       `json:"seed"` // secret until the outcome is revealed
+	Commitment   string       `json:"commitment"`
+	Message      *tb.Message  `json:"message"`
+	Expires      time.Time    `json:"expires"`
+	LanguageCode string       `json:"languagecode"`
+	Refunded     []int64      `json:"refunded,omitempty"` // players whose stake was sent back
+}
+
+// gameLimit returns the largest stake of games in a group, 0 if games are turned off.
+func gameLimit(settings *GroupSettings) int64 {
+	if !settings.Games {
+		return 0
+	}
+	if settings.GameLimit > 0 {
+		return settings.GameLimit
+	}
+	return gameDefaultLimit
+}
+
+// gameOutcome returns the random outcome of a game that the players can verify with the seed.
+func gameOutcome(seed string, gameId string, opponentId int64) []byte {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%d", seed, gameId, opponentId)))
+	return hash[:]
+}
+
+// gameRolls returns the dice of the challenger and the opponent, from 1 to 6.
+func gameRolls(outcome []byte) (int64, int64) {
+	return int64(binary.BigEndian.Uint64(outcome[0:8])%6) + 1, int64(binary.BigEndian.Uint64(outcome[8:16])%6) + 1
+}
+
+func newGameSeed() (seed string, commitment string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	seed = hex.EncodeToString(b)
+	hash := sha256.Sum256([]byte(seed))
+	return seed, hex.EncodeToString(hash[:]), nil
+}
+
+func (game *Game) opponentStrMd() string {
+	if game.Opponent == nil {
+		return i18n.Translate(game.LanguageCode, "gameAnyone")
+	}
+	return GetUserStrMd(game.Opponent.Telegram)
+}
+
+func (game *Game) kindStr() string {
+	if game.Kind == GameKindDice {
+		return i18n.Translate(game.LanguageCode, "gameDice")
+	}
+	return i18n.Translate(game.LanguageCode, "gameFlip")
+}
+
+func (bot *TipBot) makeGameKeyboard(game *Game) *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	acceptButton := menu.Data(i18n.Translate(game.LanguageCode, "gameAcceptButtonMessage"), "accept_game", game.ID)
+	cancelButton := menu.Data(i18n.Translate(game.LanguageCode, "cancelButtonMessage"), "cancel_game", game.ID)
+	menu.Inline(menu.Row(acceptButton, cancelButton))
+	return menu
+}
+
+// gameHandler is invoked on /flip <amount> [@user] and /dice <amount> [@user] in groups that
+// turned games on. The stake of the challenger goes to the escrow wallet right away.
+func (bot *TipBot) gameHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Sender, Translate(ctx, "gameHelpMessage"))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	limit := gameLimit(bot.getGroupSettings(m.Chat.ID))
+	if limit == 0 {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "gameDisabledMessage"))
+		return ctx, fmt.Errorf("games are turned off in %d", m.Chat.ID)
+	}
+	args := strings.Fields(m.Text)
+	if len(args) < 2 || len(args) > 3 {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "gameHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(args[1])
+	if err != nil || amount < 1 || amount > limit {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "gameInvalidAmountMessage"), limit))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	challenger := LoadUser(ctx)
+	var opponent *lnbits.User
+	if len(args) == 3 {
+		opponent, err = GetUserByTelegramUsername(strings.TrimPrefix(args[2], "@"), *bot)
+		if err != nil || opponent.Wallet == nil {
+			bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape(args[2])))
+			return ctx, errors.Create(errors.UserNoWalletError)
+		}
+		if opponent.Telegram.ID == challenger.Telegram.ID {
+			bot.trySendMessage(bot.topicOf(m), Translate(ctx, "gameYourselfMessage"))
+			return ctx, fmt.Errorf("cannot play against yourself")
+		}
+	}
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		log.Errorf("[/%s] %v", args[0], err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	seed, commitment, err := newGameSeed()
+	if err != nil {
+		return ctx, err
+	}
+	kind := GameKindFlip
+	if bot.commandName(m.Text) == "dice" {
+		kind = GameKindDice
+	}
+	game := &Game{
+		Base:         storage.New(storage.ID(fmt.Sprintf("game:%s", RandStringRunes(10)))),
+		Kind:         kind,
+		Amount:       amount,
+		Challenger:   challenger,
+		Opponent:     opponent,
+		Seed:         seed,
+		Commitment:   commitment,
+		Expires:      time.Now().Add(gameDuration),
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	t := NewTransaction(bot, challenger, escrow, amount, TransactionType("game stake"), TransactionChat(m.Chat),
+		TransactionIdempotencyKey(fmt.Sprintf("%s:stake:%d", game.ID, challenger.Telegram.ID)), TransactionContext(ctx))
+	t.Memo = fmt.Sprintf("🎲 Stake of %s in a game.", GetUserStr(challenger.Telegram))
+	if success, err := t.Send(); !success {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "gameStakeFailedMessage"), GetUserStrMd(challenger.Telegram)))
+		logger(ctx).Warnf("[game] stake of %s failed: %v", GetUserStr(challenger.Telegram), err)
+		return ctx, err
+	}
+	text := fmt.Sprintf(i18n.Translate(game.LanguageCode, "gameChallengeMessage"), GetUserStrMd(challenger.Telegram), game.opponentStrMd(), game.kindStr(), amount, commitment)
+	game.Message = bot.trySendMessageEditable(bot.topicOf(m), text, bot.makeGameKeyboard(game))
+	if game.Message == nil {
+		bot.refundGame(game, "")
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	logger(ctx).Infof("[🎲 game] %s challenged %s to %s %s for %d sat", GetUserStr(challenger.Telegram), game.opponentStrMd(), kind, game.ID, amount)
+	bot.startGameTimer(game)
+	return ctx, game.Set(game, bot.Bunt)
+}
+
+// loadGame loads an active game.
+func (bot *TipBot) loadGame(id string) (*Game, error) {
+	game := &Game{Base: storage.New(storage.ID(id))}
+	sn, err := game.Get(game, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	game = sn.(*Game)
+	if !game.Active {
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	return game, nil
+}
+
+// acceptGameHandler is invoked when the opponent accepts a challenge. Their stake goes to the
+// escrow wallet and the outcome is revealed right away.
+func (bot *TipBot) acceptGameHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	game, err := bot.loadGame(ctx.Data())
+	if err != nil {
+		return ctx, err
+	}
+	sender := ctx.Sender()
+	if game.Accepted || time.Now().After(game.Expires) || sender.ID == game.Challenger.Telegram.ID ||
+		(game.Opponent != nil && game.Opponent.Telegram.ID != sender.ID) {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	opponent := LoadUser(ctx)
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		return ctx, err
+	}
+	t := NewTransaction(bot, opponent, escrow, game.Amount, TransactionType("game stake"), TransactionChat(game.Message.Chat),
+		TransactionIdempotencyKey(fmt.Sprintf("%s:stake:%d", game.ID, opponent.Telegram.ID)), TransactionContext(ctx))
+	t.Memo = fmt.Sprintf("🎲 Stake of %s in a game.", GetUserStr(opponent.Telegram))
+	success, err := t.Send()
+	if isDuplicateOperation(err) {
+		return ctx, err
+	}
+	if !success {
+		bot.trySendMessage(sender, fmt.Sprintf(Translate(ctx, "gameStakeFailedMessage"), GetUserStrMd(sender)))
+		logger(ctx).Warnf("[game] stake of %s in %s failed: %v", GetUserStr(sender), game.ID, err)
+		return ctx, err
+	}
+	game.Opponent = opponent
+	game.Accepted = true
+	runtime.IgnoreError(game.Set(game, bot.Bunt))
+	bot.resolveGame(game)
+	return ctx, nil
+}
+
+// resolveGame pays both stakes to the winner and reveals the seed. If the payout fails, the
+// game stays active and its expiry tries again with the same outcome.
+func (bot *TipBot) resolveGame(game *Game) {
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		log.Errorf("[game] could not resolve %s: %v", game.ID, err)
+		return
+	}
+	outcome := gameOutcome(game.Seed, game.ID, game.Opponent.Telegram.ID)
+	var winner *lnbits.User
+	result := ""
+	switch game.Kind {
+	case GameKindDice:
+		challengerRoll, opponentRoll := gameRolls(outcome)
+		result = fmt.Sprintf(i18n.Translate(game.LanguageCode, "gameDiceResultMessage"), GetUserStrMd(game.Challenger.Telegram), challengerRoll, GetUserStrMd(game.Opponent.Telegram), opponentRoll)
+		if challengerRoll > opponentRoll {
+			winner = game.Challenger
+		} else if opponentRoll > challengerRoll {
+			winner = game.Opponent
+		}
+	default:
+		// heads wins for the challenger
+		if outcome[0]%2 == 0 {
+			winner = game.Challenger
+			result = i18n.Translate(game.LanguageCode, "gameHeadsMessage")
+		} else {
+			winner = game.Opponent
+			result = i18n.Translate(game.LanguageCode, "gameTailsMessage")
+		}
+	}
+	proof := fmt.Sprintf(i18n.Translate(game.LanguageCode, "gameProofMessage"), game.Seed, game.Commitment, game.ID, game.Opponent.Telegram.ID)
+	if winner == nil {
+		bot.refundGame(game, result+"\n"+i18n.Translate(game.LanguageCode, "gameTieMessage")+proof)
+		return
+	}
+	to, err := GetUser(winner.Telegram, *bot)
+	if err != nil {
+		log.Errorf("[game] could not pay out %s: %v", game.ID, err)
+		return
+	}
+	t := NewTransaction(bot, escrow, to, 2*game.Amount, TransactionType("game payout"), TransactionChat(game.Message.Chat), TransactionIdempotencyKey(game.ID+":payout"))
+	t.Memo = fmt.Sprintf("🎲 %s won a game.", GetUserStr(winner.Telegram))
+	if success, err := t.Send(); !success {
+		// the game stays active, its expiry tries again
+		log.Errorf("[game] could not pay out %s: %v", game.ID, err)
+		return
+	}
+	runtime.IgnoreError(game.Inactivate(game, bot.Bunt))
+	log.Infof("[🎲 game] %s won %s against %s: %d sat", GetUserStr(winner.Telegram), game.ID, GetUserStr(game.loser(winner).Telegram), 2*game.Amount)
+	bot.tryEditMessage(game.Message, result+"\n"+fmt.Sprintf(i18n.Translate(game.LanguageCode, "gameWonMessage"), GetUserStrMd(winner.Telegram), 2*game.Amount)+proof, &tb.ReplyMarkup{})
+}
+
+func (game *Game) loser(winner *lnbits.User) *lnbits.User {
+	if winner.Telegram.ID == game.Challenger.Telegram.ID {
+		return game.Opponent
+	}
+	return game.Challenger
+}
+
+func (game *Game) refunded(player *lnbits.User) bool {
+	for _, id := range game.Refunded {
+		if id == player.Telegram.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// refundGame sends the stakes back and shows message, the game is over.
+func (bot *TipBot) refundGame(game *Game, message string) {
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		log.Errorf("[game] could not refund %s: %v", game.ID, err)
+		return
+	}
+	players := []*lnbits.User{game.Challenger}
+	if game.Accepted {
+		players = append(players, game.Opponent)
+	}
+	for _, player := range players {
+		if game.refunded(player) {
+			continue
+		}
+		to, err := GetUser(player.Telegram, *bot)
+		if err != nil {
+			log.Errorf("[game] could not refund %s to %s: %v", game.ID, GetUserStr(player.Telegram), err)
+			return
+		}
+		t := NewTransaction(bot, escrow, to, game.Amount, TransactionType("game refund"), TransactionIdempotencyKey(fmt.Sprintf("%s:refund:%d", game.ID, player.Telegram.ID)))
+		t.Memo = fmt.Sprintf("🎲 Refund of the stake of %s in a game.", GetUserStr(player.Telegram))
+		if success, err := t.Send(); !success {
+			// the game stays active, its expiry tries again
+			log.Errorf("[game] could not refund %s to %s: %v", game.ID, GetUserStr(player.Telegram), err)
+			return
+		}
+		game.Refunded = append(game.Refunded, player.Telegram.ID)
+		runtime.IgnoreError(game.Set(game, bot.Bunt))
+	}
+	runtime.IgnoreError(game.Inactivate(game, bot.Bunt))
+	log.Infof("[🎲 game] refunded %s to %d players", game.ID, len(players))
+	if len(message) > 0 {
+		bot.tryEditMessage(game.Message, message, &tb.ReplyMarkup{})
+	}
+}
+
+// cancelGameHandler is invoked when the challenger cancels or the opponent declines a challenge.
+func (bot *TipBot) cancelGameHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	game, err := bot.loadGame(ctx.Data())
+	if err != nil {
+		return ctx, err
+	}
+	sender := ctx.Sender()
+	if game.Accepted || (sender.ID != game.Challenger.Telegram.ID && (game.Opponent == nil || game.Opponent.Telegram.ID != sender.ID)) {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	bot.refundGame(game, fmt.Sprintf(i18n.Translate(game.LanguageCode, "gameCancelledMessage"), game.kindStr(), GetUserStrMd(sender)))
+	return ctx, nil
+}
+
+// startGameTimer refunds a challenge that nobody accepted when it expires.
+func (bot *TipBot) startGameTimer(game *Game) {
+	time.AfterFunc(time.Until(game.Expires), func() {
+		bot.expireGame(game.ID)
+	})
+}
+
+func (bot *TipBot) expireGame(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	game, err := bot.loadGame(id)
+	if err != nil {
+		return
+	}
+	if game.Accepted {
+		// the payout failed before
+		bot.resolveGame(game)
+		return
+	}
+	bot.refundGame(game, fmt.Sprintf(i18n.Translate(game.LanguageCode, "gameExpiredMessage"), game.kindStr(), GetUserStrMd(game.Challenger.Telegram)))
+}
+
+// groupSettingsGamesHandler turns games in the group on or off and sets the largest stake with
+// /groupsettings games on|off|<limit>.
+func (bot *TipBot) groupSettingsGamesHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	value, err := getArgumentFromCommand(m.Text, 2)
+	value = strings.ToLower(value)
+	if err != nil {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsHelpMessage"), strings.Join(i18n.LoadedLanguages(), ", ")))
+		return ctx, err
+	}
+	settings := bot.getGroupSettings(m.Chat.ID)
+	switch value {
+	case "on":
+		settings.Games = true
+	case "off":
+		settings.Games = false
+	default:
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 {
+			bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsHelpMessage"), strings.Join(i18n.LoadedLanguages(), ", ")))
+			return ctx, fmt.Errorf("invalid games setting %s", value)
+		}
+		settings.Games = true
+		settings.GameLimit = limit
+	}
+	if err := bot.saveGroupSettings(settings); err != nil {
+		log.Errorf("[groupSettingsGamesHandler] could not save settings of group %d: %v", m.Chat.ID, err)
+		return ctx, err
+	}
+	if settings.Games {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsGamesEnabledMessage"), gameLimit(settings)))
+	} else {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "groupSettingsGamesDisabledMessage"))
+	}
+	return ctx, nil
+}
+
+// groupGamesStr shows the largest stake of games in the group settings.
+func groupGamesStr(settings *GroupSettings) string {
+	if limit := gameLimit(settings); limit > 0 {
+		return fmt.Sprintf("≤ %d sat", limit)
+	}
+	return "-"
+}
@@ -0,0 +1,104 @@
+package telegram
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestGame(t *testing.T) {
+	h := newTestHarness(t)
+	escrow := h.newUser(testBotUser, 0)
+	alice := &tb.User{ID: 9921, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9922, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9920, Type: tb.ChatGroup, Title: "casino"}
+	challenger := h.newUser(alice, 2000)
+	opponent := h.newUser(bob, 2000)
+	h.telegram.admins = []*tb.User{alice}
+
+	// games are off until an admin turns them on
+	h.sendMessage(alice, group, "/flip 1000 @bob")
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "Games are turned off") {
+		t.Fatalf("message = %q", text)
+	}
+	h.sendMessage(alice, group, "/groupsettings games 500")
+	h.sendMessage(alice, group, "/flip 1000 @bob")
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "between 1 and 500 sat") {
+		t.Fatalf("message = %q", text)
+	}
+	h.sendMessage(alice, group, "/groupsettings games 5000")
+
+	h.sendMessage(alice, group, "/flip 1000 @bob")
+	challenge := h.lastMessage(group.ID)
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 1000 {
+		t.Fatalf("balance of the escrow = %d, want 1000", balance)
+	}
+	data := strings.Split(challenge.Buttons()[0].Data, "|")
+	game, err := h.bot.loadGame(data[len(data)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := game.Seed
+
+	// only the named opponent can accept
+	h.pressButton(alice, challenge, "✅ Accept")
+	h.pressButton(bob, challenge, "✅ Accept")
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 0 {
+		t.Errorf("balance of the escrow = %d after the game, want 0", balance)
+	}
+	winner, loser := challenger, opponent
+	if gameOutcome(seed, game.ID, bob.ID)[0]%2 == 1 {
+		winner, loser = opponent, challenger
+	}
+	if balance := h.lnbits.Balance(winner.Wallet.ID); balance != 3000 {
+		t.Errorf("balance of the winner = %d, want 3000", balance)
+	}
+	if balance := h.lnbits.Balance(loser.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of the loser = %d, want 1000", balance)
+	}
+
+	// the revealed seed matches the commitment of the challenge
+	hash := sha256.Sum256([]byte(seed))
+	if !strings.Contains(challenge.Text(), hex.EncodeToString(hash[:])) {
+		t.Errorf("challenge = %q", challenge.Text())
+	}
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, seed) || !strings.Contains(text, "wins 2000 sat") {
+		t.Errorf("result = %q", text)
+	}
+}
+
+func TestGameCancel(t *testing.T) {
+	h := newTestHarness(t)
+	escrow := h.newUser(testBotUser, 0)
+	alice := &tb.User{ID: 9931, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9932, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9930, Type: tb.ChatGroup, Title: "casino"}
+	challenger := h.newUser(alice, 1000)
+	h.newUser(bob, 1000)
+	h.telegram.admins = []*tb.User{alice}
+
+	h.sendMessage(alice, group, "/groupsettings games on")
+	h.sendMessage(alice, group, "/dice 300")
+	if balance := h.lnbits.Balance(challenger.Wallet.ID); balance != 700 {
+		t.Fatalf("balance of the challenger = %d, want 700", balance)
+	}
+	if claims := h.bot.pendingClaims(); len(claims) != 1 || claims[0].Type != ClaimTypeGame {
+		t.Errorf("pending claims = %+v, want the game", claims)
+	}
+
+	// only the challenger can cancel an open game
+	h.pressButton(bob, h.lastMessage(group.ID), "🚫 Cancel")
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 300 {
+		t.Errorf("balance of the escrow = %d, want 300", balance)
+	}
+	h.pressButton(alice, h.lastMessage(group.ID), "🚫 Cancel")
+	if balance := h.lnbits.Balance(challenger.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of the challenger = %d after the refund, want 1000", balance)
+	}
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "cancelled") {
+		t.Errorf("message = %q", text)
+	}
+}
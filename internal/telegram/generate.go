@@ -65,7 +65,7 @@ func (bot *TipBot) confirmGenerateImages(ctx intercept.Context) (intercept.Conte
 	if err != nil {
 		return ctx, err
 	}
-	invoice, err := bot.createInvoiceWithEvent(ctx, me, internal.Configuration.Generate.DallePrice, fmt.Sprintf("DALLE2 %s", GetUserStr(user.Telegram)), "", InvoiceCallbackGenerateDalle, prompt)
+	invoice, err := bot.createInvoiceWithEvent(ctx, me, internal.CurrentConfiguration().Generate.DallePrice, fmt.Sprintf("DALLE2 %s", GetUserStr(user.Telegram)), "", InvoiceCallbackGenerateDalle, prompt)
 	invoice.Payer = user
 	if err != nil {
 		return ctx, err
@@ -82,7 +82,7 @@ func (bot *TipBot) confirmGenerateImages(ctx intercept.Context) (intercept.Conte
 	bot.trySendMessage(ctx.Message().Sender, Translate(ctx, "generateDallePayInvoiceMessage"))
 
 	// invoke internal pay if enough balance
-	if balance >= internal.Configuration.Generate.DallePrice {
+	if balance >= internal.CurrentConfiguration().Generate.DallePrice {
 		m.Text = fmt.Sprintf("/pay %s", invoice.PaymentRequest)
 		return bot.payHandler(ctx)
 	}
@@ -109,7 +109,7 @@ func init() {
 		log.Printf("Dalle is disabled. No worker started.")
 		return
 	}
-	log.Printf("Starting Dalle image generation. Worker: %d, Price: %d sat", workers, internal.Configuration.Generate.DallePrice)
+	log.Printf("Starting Dalle image generation. Worker: %d, Price: %d sat", workers, internal.CurrentConfiguration().Generate.DallePrice)
 	jobChan = make(chan func(workerId int), workers)
 	for i := 0; i < workers; i++ {
 		go worker(jobChan, i)
@@ -233,24 +233,23 @@ func (bot *TipBot) dalleRefundUser(user *lnbits.User, message string) error {
 	}
 
 	// create invioce for user
-	invoice, err := user.Wallet.Invoice(
+	invoice, err := bot.Client.CreateInvoice(*user.Wallet,
 		lnbits.InvoiceParams{
 			Out:     false,
-			Amount:  int64(internal.Configuration.Generate.DallePrice),
+			Amount:  int64(internal.CurrentConfiguration().Generate.DallePrice),
 			Memo:    fmt.Sprintf("Refund DALLE2 %s", GetUserStr(user.Telegram)),
-			Webhook: internal.Configuration.Lnbits.WebhookServer},
-		bot.Client)
+			Webhook: internal.Configuration.Lnbits.WebhookServer})
 	if err != nil {
 		return err
 	}
 
 	// pay invoice
-	_, err = me.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}, bot.Client)
+	_, err = bot.Client.Pay(*me.Wallet, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest})
 	if err != nil {
 		log.Errorln(err)
 		return err
 	}
-	log.Warnf("[DALLE] refunding user %s with %d sat", GetUserStr(user.Telegram), internal.Configuration.Generate.DallePrice)
+	log.Warnf("[DALLE] refunding user %s with %d sat", GetUserStr(user.Telegram), internal.CurrentConfiguration().Generate.DallePrice)
 
 	var err_reason string
 	if len(message) > 0 {
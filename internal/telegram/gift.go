@@ -0,0 +1,330 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/pdf"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// giftDuration is how long a gift can be redeemed before it is refunded to its creator.
+	giftDuration     = 30 * 24 * time.Hour
+	giftStartPrefix  = "gift_"
+	giftCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // without 0, O, 1 and I
+	giftCodeLength   = 12
+)
+
+var (
+	giftCodeRegex = regexp.MustCompile(`^[A-Z0-9]{4}-[A-Z0-9]{4}-[A-Z0-9]{4}$`)
+	giftMenu      = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnPrintGift  = giftMenu.Data("🖨 Print", "print_gift")
+)
+
+// Gift holds Amount in the escrow wallet until someone redeems its code.
+type Gift struct {
+	*storage.Base
+	Code         string       `json:"code"`
+	Amount       int64        `json:"amount"`
+	Memo         string       `json:"memo"`
+	Creator      *lnbits.User `json:"creator"`
+	RedeemedBy   *tb.User     `json:"redeemed_by,omitempty"`
+	Expires      time.Time    `json:"expires"`
+	LanguageCode string       `json:"languagecode"`
+}
+
+func giftID(code string) string {
+	return fmt.Sprintf("gift:%s", code)
+}
+
+// newGiftCode returns a random code like ABCD-EFGH-JKLM. The code is the only thing
+// that protects the funds of a gift, so it comes from crypto/rand.
+func newGiftCode() (string, error) {
+	code := make([]byte, 0, giftCodeLength+2)
+	for i := 0; i < giftCodeLength; i++ {
+		if i > 0 && i%4 == 0 {
+			code = append(code, '-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(giftCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code = append(code, giftCodeAlphabet[n.Int64()])
+	}
+	return string(code), nil
+}
+
+// normalizeGiftCode accepts codes in lower case and without dashes.
+func normalizeGiftCode(input string) string {
+	code := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(input))
+	if len(code) != giftCodeLength {
+		return code
+	}
+	return fmt.Sprintf("%s-%s-%s", code[:4], code[4:8], code[8:])
+}
+
+func (gift *Gift) deepLink(bot *TipBot) string {
+	return bot.deepLink(giftStartPrefix + gift.Code)
+}
+
+// giftHandler is invoked on /gift <amount> [<memo>] and locks the amount behind a code
+// that anyone can redeem with /redeem <code> or by opening its link.
+func (bot *TipBot) giftHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Chat.Type != tb.ChatPrivate {
+		// the code must stay secret
+		bot.tryDeleteMessage(m)
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "giftHelpText"), Translate(ctx, "giftPrivateMessage")))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	amount, err := decodeAmountFromCommand(m.Text)
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "giftHelpText"), Translate(ctx, "giftValidAmountMessage")))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	memo := GetMemoFromCommand(m.Text, 2)
+	if runes := []rune(memo); len(runes) > 100 {
+		memo = string(runes[:100]) + "..."
+	}
+	creator := LoadUser(ctx)
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		log.Errorf("[/gift] %v", err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	code, err := newGiftCode()
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	gift := &Gift{
+		Base:         storage.New(storage.ID(giftID(code))),
+		Code:         code,
+		Amount:       amount,
+		Memo:         memo,
+		Creator:      creator,
+		Expires:      time.Now().Add(giftDuration),
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	t := NewTransaction(bot, creator, escrow, amount, TransactionType("gift"), TransactionIdempotencyKey(idempotencyKey(ctx)), TransactionContext(ctx))
+	t.Memo = fmt.Sprintf("🎁 Gift of %s.", GetUserStr(creator.Telegram))
+	success, err := t.Send()
+	if isDuplicateOperation(err) {
+		return ctx, err
+	}
+	if !success {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "giftFailedMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+	runtime.IgnoreError(gift.Set(gift, bot.Bunt))
+	bot.startGiftTimer(gift)
+	logger(ctx).Infof("[🎁 gift] %s created gift %s of %d sat", GetUserStr(creator.Telegram), gift.ID, amount)
+
+	caption := fmt.Sprintf(Translate(ctx, "giftCreatedMessage"), amount, gift.Code, gift.deepLink(bot), gift.Expires.UTC().Format("2 Jan 06 15:04 MST"))
+	qr, err := qrcode.Encode(gift.deepLink(bot), qrcode.Medium, 256)
+	if err != nil {
+		bot.trySendMessage(m.Sender, caption, bot.makeGiftKeyboard(ctx, gift), tb.NoPreview)
+		return ctx, nil
+	}
+	bot.trySendMessage(m.Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: caption}, bot.makeGiftKeyboard(ctx, gift))
+	return ctx, nil
+}
+
+func (bot *TipBot) makeGiftKeyboard(ctx context.Context, gift *Gift) *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	printButton := menu.Data(Translate(ctx, "printButtonMessage"), "print_gift", gift.ID)
+	menu.Inline(menu.Row(printButton))
+	return menu
+}
+
+// printGiftHandler sends the gift as a card to print and hand over.
+func (bot *TipBot) printGiftHandler(ctx intercept.Context) (intercept.Context, error) {
+	gift := &Gift{Base: storage.New(storage.ID(ctx.Data()))}
+	sn, err := gift.Get(gift, bot.Bunt)
+	if err != nil {
+		return ctx, err
+	}
+	gift = sn.(*Gift)
+	if gift.Creator.Telegram.ID != ctx.Sender().ID {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if !gift.Active {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "giftAlreadyRedeemedMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	card := pdf.Card{
+		Amount:       fmt.Sprintf("%d sat", gift.Amount),
+		Memo:         gift.Memo,
+		QR:           gift.deepLink(bot),
+		Code:         gift.Code,
+		Instructions: fmt.Sprintf(Translate(ctx, "giftCardInstructions"), bot.brand(), gift.Expires.UTC().Format("2 Jan 2006")),
+	}
+	file, err := pdf.Cards{Title: fmt.Sprintf("Gift %s", gift.Code), Brand: bot.brand(), Cards: []pdf.Card{card}}.Bytes()
+	if err != nil {
+		log.Errorf("[🎁 gift] could not print %s: %v", gift.ID, err)
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	bot.trySendMessage(ctx.Sender(), &tb.Document{
+		File:     tb.File{FileReader: bytes.NewReader(file)},
+		FileName: fmt.Sprintf("gift-%s.pdf", gift.Code),
+		MIME:     "application/pdf",
+	})
+	return ctx, nil
+}
+
+// redeemHandler is invoked on /redeem <code>. Users without a wallet get one.
+func (bot *TipBot) redeemHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	code, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "redeemHelpText"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if m.Chat.Type != tb.ChatPrivate {
+		bot.tryDeleteMessage(m)
+	}
+	user, err := bot.initWallet(m.Sender)
+	if err != nil {
+		log.Errorf("[/redeem] could not create wallet of %s: %v", GetUserStr(m.Sender), err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "startWalletErrorMessage"))
+		return ctx, err
+	}
+	return bot.redeemGift(ctx, user, code)
+}
+
+// startGiftHandler is invoked on /start gift_<code>, after the wallet of a new user was created.
+func (bot *TipBot) startGiftHandler(ctx intercept.Context, code string) (intercept.Context, error) {
+	return bot.redeemGift(ctx, LoadUser(ctx), code)
+}
+
+// redeemGift pays the amount of a gift to the user who redeems its code.
+func (bot *TipBot) redeemGift(ctx intercept.Context, user *lnbits.User, code string) (intercept.Context, error) {
+	code = normalizeGiftCode(code)
+	if !giftCodeRegex.MatchString(code) {
+		bot.trySendMessage(user.Telegram, Translate(ctx, "giftInvalidMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	id := giftID(code)
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	gift := &Gift{Base: storage.New(storage.ID(id))}
+	sn, err := gift.Get(gift, bot.Bunt)
+	if err != nil {
+		bot.trySendMessage(user.Telegram, Translate(ctx, "giftInvalidMessage"))
+		return ctx, errors.New(errors.NotActiveError, err)
+	}
+	gift = sn.(*Gift)
+	if !gift.Active {
+		bot.trySendMessage(user.Telegram, Translate(ctx, "giftAlreadyRedeemedMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if time.Now().After(gift.Expires) {
+		bot.refundGift(gift)
+		bot.trySendMessage(user.Telegram, Translate(ctx, "giftExpiredMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		return ctx, err
+	}
+	t := NewTransaction(bot, escrow, user, gift.Amount, TransactionType("gift redeem"), TransactionIdempotencyKey(gift.ID+":redeem"))
+	t.Memo = fmt.Sprintf("🎁 Gift of %s redeemed by %s.", GetUserStr(gift.Creator.Telegram), GetUserStr(user.Telegram))
+	success, err := t.Send()
+	if err == errDuplicateOperation {
+		// the gift was paid out to someone before
+		runtime.IgnoreError(gift.Inactivate(gift, bot.Bunt))
+		bot.trySendMessage(user.Telegram, Translate(ctx, "giftAlreadyRedeemedMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if !success {
+		// the gift stays active, the code can be redeemed again
+		log.Errorf("[🎁 gift] could not pay out %s to %s: %v", gift.ID, GetUserStr(user.Telegram), err)
+		bot.trySendMessage(user.Telegram, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	gift.RedeemedBy = user.Telegram
+	runtime.IgnoreError(gift.Inactivate(gift, bot.Bunt))
+	log.Infof("[🎁 gift] %s redeemed %s of %d sat", GetUserStr(user.Telegram), gift.ID, gift.Amount)
+
+	message := fmt.Sprintf(Translate(ctx, "giftRedeemedMessage"), gift.Amount, GetUserStrMd(gift.Creator.Telegram))
+	if len(gift.Memo) > 0 {
+		message += fmt.Sprintf("\n✉️ %s", str.MarkdownEscape(gift.Memo))
+	}
+	bot.trySendMessage(user.Telegram, message)
+	if gift.Creator.Telegram.ID != user.Telegram.ID {
+		bot.notify(gift.Creator, NotificationPayment, gift.Amount, fmt.Sprintf(i18n.Translate(gift.LanguageCode, "giftRedeemedCreatorMessage"), gift.Code, gift.Amount, GetUserStrMd(user.Telegram)))
+	}
+	return ctx, nil
+}
+
+// refundGift sends the amount of an unredeemed gift back to its creator.
+func (bot *TipBot) refundGift(gift *Gift) {
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		log.Errorf("[🎁 gift] could not refund %s: %v", gift.ID, err)
+		return
+	}
+	creator, err := GetUser(gift.Creator.Telegram, *bot)
+	if err != nil {
+		log.Errorf("[🎁 gift] could not refund %s: %v", gift.ID, err)
+		return
+	}
+	t := NewTransaction(bot, escrow, creator, gift.Amount, TransactionType("gift refund"), TransactionIdempotencyKey(gift.ID+":refund"))
+	t.Memo = fmt.Sprintf("🎁 Refund of the gift of %s.", GetUserStr(creator.Telegram))
+	success, err := t.Send()
+	if err == errDuplicateOperation {
+		// the refund went through before
+		runtime.IgnoreError(gift.Inactivate(gift, bot.Bunt))
+		return
+	}
+	if !success {
+		// the claim expiry worker tries again
+		log.Errorf("[🎁 gift] could not refund %s: %v", gift.ID, err)
+		return
+	}
+	runtime.IgnoreError(gift.Inactivate(gift, bot.Bunt))
+	log.Infof("[🎁 gift] refunded %d sat of %s to %s", gift.Amount, gift.ID, GetUserStr(creator.Telegram))
+	bot.notify(creator, NotificationPayment, gift.Amount, fmt.Sprintf(i18n.Translate(gift.LanguageCode, "giftRefundedMessage"), gift.Code, gift.Amount))
+}
+
+// startGiftTimer refunds a gift that was not redeemed when it expires.
+func (bot *TipBot) startGiftTimer(gift *Gift) {
+	time.AfterFunc(time.Until(gift.Expires), func() {
+		bot.expireGift(gift.ID)
+	})
+}
+
+func (bot *TipBot) expireGift(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	gift := &Gift{Base: storage.New(storage.ID(id))}
+	sn, err := gift.Get(gift, bot.Bunt)
+	if err != nil {
+		log.Errorf("[expireGift] %s: %v", id, err)
+		return
+	}
+	gift = sn.(*Gift)
+	if !gift.Active {
+		return
+	}
+	bot.refundGift(gift)
+}
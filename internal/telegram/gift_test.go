@@ -0,0 +1,140 @@
+package telegram
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var testGiftCodeRegex = regexp.MustCompile(`[A-Z0-9]{4}-[A-Z0-9]{4}-[A-Z0-9]{4}`)
+
+func TestGiftRedeem(t *testing.T) {
+	h := newTestHarness(t)
+	escrow := h.newUser(testBotUser, 0)
+	alice := &tb.User{ID: 9001, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	carol := &tb.User{ID: 9002, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	from := h.newUser(alice, 10000)
+
+	h.sendMessage(alice, privateChat(alice), "/gift 5000 Happy birthday!")
+	code := testGiftCodeRegex.FindString(h.lastMessage(alice.ID).Text())
+	if len(code) == 0 {
+		t.Fatalf("gift message = %q", h.lastMessage(alice.ID).Text())
+	}
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 5000 {
+		t.Errorf("balance of the escrow = %d, want 5000", balance)
+	}
+
+	// carol has no wallet yet and gets one when she redeems the gift
+	h.sendMessage(carol, privateChat(carol), "/redeem "+strings.ToLower(strings.Replace(code, "-", "", -1)))
+	to, err := GetUser(carol, *h.bot)
+	if err != nil || to.Wallet == nil {
+		t.Fatalf("carol has no wallet after redeeming: %v", err)
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 5000 {
+		t.Errorf("balance of carol = %d, want 5000", balance)
+	}
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "redeemed by @carol") {
+		t.Errorf("message to the creator = %q", text)
+	}
+	h.sendMessage(carol, privateChat(carol), "/redeem "+code)
+	if text := h.lastMessage(carol.ID).Text(); !strings.Contains(text, "already redeemed") {
+		t.Errorf("message after redeeming twice = %q", text)
+	}
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 5000 {
+		t.Errorf("balance of alice = %d, want 5000", balance)
+	}
+}
+
+func TestGiftRedeemRetry(t *testing.T) {
+	h := newTestHarness(t)
+	escrow := h.newUser(testBotUser, 0)
+	alice := &tb.User{ID: 9011, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	carol := &tb.User{ID: 9012, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	dave := &tb.User{ID: 9013, Username: "dave", FirstName: "Dave", LanguageCode: "en"}
+	h.newUser(alice, 1000)
+	h.newUser(carol, 0)
+	to := h.newUser(dave, 0)
+
+	h.sendMessage(alice, privateChat(alice), "/gift 1000")
+	code := testGiftCodeRegex.FindString(h.lastMessage(alice.ID).Text())
+	// the escrow wallet can't pay the gift
+	invoice, err := h.lnbits.ExternalInvoice(1000, "drain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.lnbits.Client().Pay(*escrow.Wallet, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}); err != nil {
+		t.Fatal(err)
+	}
+	h.sendMessage(carol, privateChat(carol), "/redeem "+code)
+	if text := h.lastMessage(carol.ID).Text(); strings.Contains(text, "redeemed") {
+		t.Fatalf("message after the failed payout = %q", text)
+	}
+
+	// the gift is still active and the next redeemer gets it
+	if err := h.lnbits.Fund(escrow.Wallet.ID, 1000); err != nil {
+		t.Fatal(err)
+	}
+	h.sendMessage(dave, privateChat(dave), "/redeem "+code)
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of dave = %d, want 1000", balance)
+	}
+	h.sendMessage(carol, privateChat(carol), "/redeem "+code)
+	if text := h.lastMessage(carol.ID).Text(); !strings.Contains(text, "already redeemed") {
+		t.Errorf("message after the gift was redeemed = %q", text)
+	}
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 0 {
+		t.Errorf("balance of the escrow = %d, want 0", balance)
+	}
+}
+
+func TestGiftRefund(t *testing.T) {
+	h := newTestHarness(t)
+	escrow := h.newUser(testBotUser, 0)
+	alice := &tb.User{ID: 9101, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	from := h.newUser(alice, 1000)
+
+	h.sendMessage(alice, privateChat(alice), "/gift 1000")
+	code := testGiftCodeRegex.FindString(h.lastMessage(alice.ID).Text())
+	gift := &Gift{Base: storage.New(storage.ID(giftID(code)))}
+	sn, err := gift.Get(gift, h.bot.Bunt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gift = sn.(*Gift)
+	gift.Expires = time.Now().Add(-time.Minute)
+	if err := gift.Set(gift, h.bot.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	h.bot.expireGift(gift.ID)
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of alice after the refund = %d, want 1000", balance)
+	}
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 0 {
+		t.Errorf("balance of the escrow = %d, want 0", balance)
+	}
+}
+
+func TestGiftPrint(t *testing.T) {
+	h := newTestHarness(t)
+	h.newUser(testBotUser, 0)
+	alice := &tb.User{ID: 9151, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9152, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	h.newUser(alice, 1000)
+	h.newUser(bob, 0)
+
+	h.sendMessage(alice, privateChat(alice), "/gift 1000")
+	gift := h.lastMessage(alice.ID)
+	h.pressButton(bob, gift, "🖨 Print")
+	if h.called("sendDocument", bob.ID) {
+		t.Error("card of the gift was sent to someone else")
+	}
+	h.pressButton(alice, gift, "🖨 Print")
+	if !h.called("sendDocument", alice.ID) {
+		t.Error("no card of the gift was sent")
+	}
+}
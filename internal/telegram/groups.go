@@ -12,6 +12,7 @@ import (
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
 
 	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/database"
 	"github.com/LightningTipBot/LightningTipBot/internal/errors"
 	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
@@ -41,7 +42,7 @@ func (jt JoinTicket) Type() EventType {
 
 func (bot *TipBot) loadGroup(groupName string) (*Group, error) {
 	group := &Group{}
-	tx := bot.DB.Groups.Where("id = ? COLLATE NOCASE", groupName).First(group)
+	tx := bot.DB.Groups.Where(database.EqualFold("id"), groupName).First(group)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
@@ -172,7 +173,7 @@ func (bot TipBot) groupRequestJoinHandler(ctx intercept.Context) (intercept.Cont
 	groupName := strings.ToLower(splits[splitIdx+1])
 
 	group := &Group{}
-	tx := bot.DB.Groups.Where("name = ? COLLATE NOCASE", groupName).First(group)
+	tx := bot.DB.Groups.Where(database.EqualFold("name"), groupName).First(group)
 	if tx.Error != nil {
 		bot.trySendMessage(ctx.Message().Chat, Translate(ctx, "groupNotFoundMessage"))
 		return ctx, fmt.Errorf("group not found")
@@ -299,7 +300,7 @@ func (bot *TipBot) groupConfirmPayButtonHandler(ctx intercept.Context) (intercep
 
 	log.Infof("[/pay] Attempting %s's invoice %s (%d sat)", GetUserStr(user.Telegram), ticketEvent.ID, ticketEvent.Group.Ticket.Price)
 	// // pay invoice
-	_, err = user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: ticketEvent.Invoice.PaymentRequest}, bot.Client)
+	_, err = bot.Client.Pay(*user.Wallet, lnbits.PaymentParams{Out: true, Bolt11: ticketEvent.Invoice.PaymentRequest})
 	if err != nil {
 		errmsg := fmt.Sprintf("[/pay] Could not pay invoice of %s: %s", GetUserStr(user.Telegram), err)
 		err = fmt.Errorf(i18n.Translate(ticketEvent.LanguageCode, "invoiceUndefinedErrorMessage"))
@@ -376,19 +377,18 @@ func (bot *TipBot) groupGetInviteLinkHandler(event Event) {
 			return
 		}
 		ticketSat = ticketEvent.Group.Ticket.Price - commissionSat
-		invoice, err := me.Wallet.Invoice(
+		invoice, err := bot.Client.CreateInvoice(*me.Wallet,
 			lnbits.InvoiceParams{
 				Out:     false,
 				Amount:  commissionSat,
 				Memo:    "🎟 Ticket commission for group " + ticketEvent.Group.Title,
-				Webhook: internal.Configuration.Lnbits.WebhookServer},
-			bot.Client)
+				Webhook: internal.Configuration.Lnbits.WebhookServer})
 		if err != nil {
 			errmsg := fmt.Sprintf("[/invoice] Could not create an invoice: %s", err.Error())
 			log.Errorln(errmsg)
 			return
 		}
-		_, err = ticketEvent.User.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}, bot.Client)
+		_, err = bot.Client.Pay(*ticketEvent.User.Wallet, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest})
 		if err != nil {
 			errmsg := fmt.Sprintf("[groupGetInviteLinkHandler] Could not pay commission of %s: %s", GetUserStr(ticketEvent.User.Telegram), err)
 			log.Errorln(errmsg)
@@ -425,7 +425,7 @@ func (bot TipBot) handleJoinTicketPayWall(ctx intercept.Context) (intercept.Cont
 }
 func (bot TipBot) removeJoinTicketPayWallHandler(ctx intercept.Context) (intercept.Context, error) {
 	groupName := strconv.FormatInt(ctx.Chat().ID, 10)
-	tx := bot.DB.Groups.Where("id = ? COLLATE NOCASE", groupName).Delete(&Group{})
+	tx := bot.DB.Groups.Where(database.EqualFold("id"), groupName).Delete(&Group{})
 	if tx.Error != nil {
 		return ctx, tx.Error
 	}
@@ -462,7 +462,7 @@ func (bot TipBot) addJoinTicketPayWallHandler(ctx intercept.Context) (intercept.
 	// check if the group with this name is already in db
 	// only if a group with this name is owned by this user, it can be overwritten
 	group := &Group{}
-	tx := bot.DB.Groups.Where("id = ? COLLATE NOCASE", groupName).First(group)
+	tx := bot.DB.Groups.Where(database.EqualFold("id"), groupName).First(group)
 	if tx.Error == nil {
 		// if it is already added, check if this user is the admin
 		if user.Telegram.ID != group.Owner.ID || group.ID != m.Chat.ID {
@@ -501,6 +501,7 @@ func (bot TipBot) addJoinTicketPayWallHandler(ctx intercept.Context) (intercept.
 	bot.DB.Groups.Save(group)
 	log.Infof("[group] Ticket of %d sat added to group %s.", group.Ticket.Price, group.Name)
 	bot.trySendMessage(m.Chat, Translate(ctx, "groupAddedMessagePublic"))
+	bot.sendGroupTicketLink(ctx, group)
 
 	return ctx, nil
 }
@@ -534,7 +535,7 @@ func (bot TipBot) addGroupHandler(ctx intercept.Context) (intercept.Context, err
 	// check if the group with this name is already in db
 	// only if a group with this name is owned by this user, it can be overwritten
 	group := &Group{}
-	tx := bot.DB.Groups.Where("name = ? COLLATE NOCASE", groupName).First(group)
+	tx := bot.DB.Groups.Where(database.EqualFold("name"), groupName).First(group)
 	if tx.Error == nil {
 		// if it is already added, check if this user is the admin
 		if user.Telegram.ID != group.Owner.ID || group.ID != m.Chat.ID {
@@ -573,6 +574,7 @@ func (bot TipBot) addGroupHandler(ctx intercept.Context) (intercept.Context, err
 	bot.DB.Groups.Save(group)
 	log.Infof("[group] Ticket of %d sat added to group %s.", group.Ticket.Price, group.Name)
 	bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "groupAddedMessagePrivate"), str.MarkdownEscape(m.Chat.Title), group.Name, group.Ticket.Price, GetUserStrMd(bot.Telegram.Me), group.Name))
+	bot.sendGroupTicketLink(ctx, group)
 
 	return ctx, nil
 }
@@ -580,13 +582,12 @@ func (bot TipBot) addGroupHandler(ctx intercept.Context) (intercept.Context, err
 // createGroupTicketInvoice produces an invoice for the group ticket with a
 // callback that then calls groupGetInviteLinkHandler upton payment
 func (bot *TipBot) createGroupTicketInvoice(ctx context.Context, payer *lnbits.User, group *Group, memo string, callback int, callbackData string) (*InvoiceEvent, error) {
-	invoice, err := group.Ticket.Creator.Wallet.Invoice(
+	invoice, err := bot.Client.CreateInvoice(*group.Ticket.Creator.Wallet,
 		lnbits.InvoiceParams{
 			Out:     false,
 			Amount:  group.Ticket.Price,
 			Memo:    memo,
-			Webhook: internal.Configuration.Lnbits.WebhookServer},
-		bot.Client)
+			Webhook: internal.Configuration.Lnbits.WebhookServer})
 	if err != nil {
 		errmsg := fmt.Sprintf("[/invoice] Could not create an invoice: %s", err.Error())
 		log.Errorln(errmsg)
@@ -607,8 +608,15 @@ func (bot *TipBot) createGroupTicketInvoice(ctx context.Context, payer *lnbits.U
 		LanguageCode: ctx.Value("publicLanguageCode").(string),
 		Payer:        payer,
 		Chat:         &tb.Chat{ID: group.ID},
+		Open:         true,
 	}
 	// add result to persistent struct
 	runtime.IgnoreError(invoiceEvent.Set(invoiceEvent, bot.Bunt))
 	return invoiceEvent, nil
 }
+
+// sendGroupTicketLink sends the group owner a deep link that opens the ticket payment for the group.
+func (bot *TipBot) sendGroupTicketLink(ctx intercept.Context, group *Group) {
+	link := bot.createPaymentLink(newPaymentLinkToken(), PaymentLinkTypeTicket, LoadUser(ctx), PaymentLinkTarget(group.Name))
+	bot.sendPaymentLink(ctx, group.Owner, link)
+}
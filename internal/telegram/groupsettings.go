@@ -0,0 +1,267 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/eko/gocache/store"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// GroupSettings holds per-chat settings that group admins can change with /groupsettings.
+type GroupSettings struct {
+	ID        int64     `json:"id" gorm:"primaryKey"`
+	Language  string    `json:"language"`
+	UpdatedAt time.Time `json:"updated"`
+	// Recap posts a weekly summary of the tips in the group
+	Recap       bool      `json:"recap"`
+	RecapSentAt time.Time `json:"recap_sent_at"`
+	// RecapTopic is the topic of a forum group that the recap covers and is posted in
+	RecapTopic int `json:"recap_topic"`
+	// Quiet reacts to tips instead of posting messages in the group
+	Quiet bool `json:"quiet"`
+	// DeleteAfter is the time in seconds after which the bot deletes its messages, 0 keeps them
+	DeleteAfter int64 `json:"delete_after"`
+	// WalletUserId is the member whose wallet receives the tips to the group, like the tips on
+	// messages of anonymous admins
+	WalletUserId int64 `json:"wallet_user_id"`
+	// Commission is the share of every tip in the group in percent that goes to the group wallet
+	Commission int64 `json:"commission"`
+	// Games allows /flip and /dice in the group, with stakes up to GameLimit sat
+	Games     bool  `json:"games"`
+	GameLimit int64 `json:"game_limit"`
+	// BirthdayTip is the tip from the group wallet to members on their birthday, BirthdayWhipRound
+	// lets the members chip in
+	BirthdayTip       int64 `json:"birthday_tip"`
+	BirthdayWhipRound bool  `json:"birthday_whip_round"`
+	// SpendApprovals is how many approvers have to approve a /spend from the group wallet, zero
+	// turns /spend off
+	SpendApprovals int64 `json:"spend_approvals"`
+}
+
+func groupSettingsCacheKey(chatID int64) string {
+	return fmt.Sprintf("group_settings_%d", chatID)
+}
+
+// getGroupSettings loads the settings of a chat. If the chat has no settings yet,
+// empty default settings are returned. The settings are a copy, changes only reach the
+// cache once saveGroupSettings saved them.
+func (bot TipBot) getGroupSettings(chatID int64) *GroupSettings {
+	if bot.Cache.StoreInterface != nil {
+		if s, err := bot.Cache.Get(groupSettingsCacheKey(chatID)); err == nil {
+			settings := *s.(*GroupSettings)
+			return &settings
+		}
+	}
+	settings := &GroupSettings{ID: chatID}
+	if bot.DB != nil {
+		bot.DB.Groups.Where("id = ?", chatID).First(settings)
+	}
+	bot.cacheGroupSettings(settings)
+	return settings
+}
+
+func (bot TipBot) saveGroupSettings(settings *GroupSettings) error {
+	saved := *settings
+	saved.UpdatedAt = time.Now()
+	tx := bot.DB.Groups.Save(&saved)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	settings.UpdatedAt = saved.UpdatedAt
+	bot.cacheGroupSettings(&saved)
+	return nil
+}
+
+// cacheGroupSettings caches a copy of the settings, so that callers can't change the cached ones.
+func (bot TipBot) cacheGroupSettings(settings *GroupSettings) {
+	if bot.Cache.StoreInterface == nil {
+		return
+	}
+	cached := *settings
+	bot.Cache.Set(groupSettingsCacheKey(settings.ID), &cached, &store.Options{Expiration: 1 * time.Hour})
+}
+
+// getGroupLanguageCode returns the language that group admins have set for public messages.
+// Returns an empty string if no language is set.
+func (bot TipBot) getGroupLanguageCode(chat *tb.Chat) string {
+	if chat == nil || chat.Type == tb.ChatPrivate {
+		return ""
+	}
+	languageCode := bot.getGroupSettings(chat.ID).Language
+	if !i18n.IsSupported(languageCode) {
+		return ""
+	}
+	return languageCode
+}
+
+// groupSettingsHandler is invoked on /groupsettings [<setting> <value>]
+func (bot *TipBot) groupSettingsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Sender, Translate(ctx, "groupSettingsOnlyInGroupMessage"))
+		return ctx, fmt.Errorf("not in group")
+	}
+	if !bot.senderIsAdmin(m) {
+		return ctx, fmt.Errorf("[groupSettingsHandler] user %s is not admin", GetUserStr(m.Sender))
+	}
+	splits := strings.Split(m.Text, " ")
+	if len(splits) == 1 {
+		settings := bot.getGroupSettings(m.Chat.ID)
+		language := "-"
+		if len(settings.Language) > 0 {
+			language = i18n.LanguageName(settings.Language)
+		}
+		deleteAfter := "-"
+		if settings.DeleteAfter > 0 {
+			deleteAfter = (time.Duration(settings.DeleteAfter) * time.Second).String()
+		}
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsMessage"), language, onOff(settings.Recap), onOff(settings.Quiet), deleteAfter, bot.groupWalletStr(settings), groupCommissionStr(settings), groupGamesStr(settings), groupBirthdaysStr(ctx, settings), bot.groupApprovalsStr(settings)))
+		return ctx, nil
+	}
+	switch strings.ToLower(splits[1]) {
+	case "language":
+		return bot.groupSettingsLanguageHandler(ctx)
+	case "recap":
+		return bot.groupSettingsRecapHandler(ctx)
+	case "quiet":
+		return bot.groupSettingsQuietHandler(ctx)
+	case "delete":
+		return bot.groupSettingsDeleteHandler(ctx)
+	case "commands":
+		return bot.groupSettingsCommandsHandler(ctx)
+	case "wallet":
+		return bot.groupSettingsWalletHandler(ctx)
+	case "commission":
+		return bot.groupSettingsCommissionHandler(ctx)
+	case "games":
+		return bot.groupSettingsGamesHandler(ctx)
+	case "birthdays":
+		return bot.groupSettingsBirthdaysHandler(ctx)
+	case "approvals":
+		return bot.groupSettingsApprovalsHandler(ctx)
+	}
+	bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsHelpMessage"), strings.Join(i18n.LoadedLanguages(), ", ")))
+	return ctx, nil
+}
+
+// groupSettingsLanguageHandler sets the language of public bot messages in a group.
+// /groupsettings language reset removes the override.
+func (bot *TipBot) groupSettingsLanguageHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	languageCode, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsHelpMessage"), strings.Join(i18n.LoadedLanguages(), ", ")))
+		return ctx, err
+	}
+	languageCode = strings.ToLower(languageCode)
+	if languageCode == "reset" {
+		languageCode = ""
+	} else if !i18n.IsSupported(languageCode) {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsHelpMessage"), strings.Join(i18n.LoadedLanguages(), ", ")))
+		return ctx, fmt.Errorf("language %s not supported", languageCode)
+	}
+	settings := bot.getGroupSettings(m.Chat.ID)
+	settings.Language = languageCode
+	err = bot.saveGroupSettings(settings)
+	if err != nil {
+		log.Errorf("[groupSettingsLanguageHandler] could not save settings of group %d: %v", m.Chat.ID, err)
+		return ctx, err
+	}
+	if len(languageCode) == 0 {
+		languageCode = "en"
+	}
+	bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(i18n.Translate(languageCode, "groupSettingsLanguageChangedMessage"), i18n.LanguageName(languageCode)))
+	return ctx, nil
+}
+
+// groupSettingsRecapHandler turns the weekly recap of a group on or off with /groupsettings recap on|off.
+func (bot *TipBot) groupSettingsRecapHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	value, err := getArgumentFromCommand(m.Text, 2)
+	value = strings.ToLower(value)
+	if err != nil || (value != "on" && value != "off") {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsHelpMessage"), strings.Join(i18n.LoadedLanguages(), ", ")))
+		return ctx, fmt.Errorf("invalid recap setting")
+	}
+	settings := bot.getGroupSettings(m.Chat.ID)
+	settings.Recap = value == "on"
+	if settings.Recap {
+		// the first recap is posted a week from now, in the topic where it was turned on
+		settings.RecapSentAt = time.Now()
+		settings.RecapTopic = bot.messageTopic(m)
+	}
+	err = bot.saveGroupSettings(settings)
+	if err != nil {
+		log.Errorf("[groupSettingsRecapHandler] could not save settings of group %d: %v", m.Chat.ID, err)
+		return ctx, err
+	}
+	if settings.Recap {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "groupSettingsRecapEnabledMessage"))
+	} else {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "groupSettingsRecapDisabledMessage"))
+	}
+	return ctx, nil
+}
+
+// groupSettingsQuietHandler turns the quiet mode of a group on or off with /groupsettings quiet on|off.
+func (bot *TipBot) groupSettingsQuietHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	value, err := getArgumentFromCommand(m.Text, 2)
+	value = strings.ToLower(value)
+	if err != nil || (value != "on" && value != "off") {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsHelpMessage"), strings.Join(i18n.LoadedLanguages(), ", ")))
+		return ctx, fmt.Errorf("invalid quiet setting")
+	}
+	settings := bot.getGroupSettings(m.Chat.ID)
+	settings.Quiet = value == "on"
+	err = bot.saveGroupSettings(settings)
+	if err != nil {
+		log.Errorf("[groupSettingsQuietHandler] could not save settings of group %d: %v", m.Chat.ID, err)
+		return ctx, err
+	}
+	if settings.Quiet {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "groupSettingsQuietEnabledMessage"))
+	} else {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "groupSettingsQuietDisabledMessage"))
+	}
+	return ctx, nil
+}
+
+// groupSettingsDeleteHandler sets the time after which the bot deletes its messages in the
+// group with /groupsettings delete <seconds|off>.
+func (bot *TipBot) groupSettingsDeleteHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	value, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsHelpMessage"), strings.Join(i18n.LoadedLanguages(), ", ")))
+		return ctx, err
+	}
+	var seconds int64
+	if strings.ToLower(value) != "off" {
+		seconds, err = strconv.ParseInt(value, 10, 64)
+		timeout := time.Duration(seconds) * time.Second
+		if err != nil || timeout < deleteAfterMin || timeout > deleteAfterMax {
+			bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsDeleteInvalidMessage"), int64(deleteAfterMin.Seconds()), int64(deleteAfterMax.Seconds())))
+			return ctx, fmt.Errorf("invalid delete timeout %s", value)
+		}
+	}
+	settings := bot.getGroupSettings(m.Chat.ID)
+	settings.DeleteAfter = seconds
+	err = bot.saveGroupSettings(settings)
+	if err != nil {
+		log.Errorf("[groupSettingsDeleteHandler] could not save settings of group %d: %v", m.Chat.ID, err)
+		return ctx, err
+	}
+	if seconds > 0 {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsDeleteEnabledMessage"), seconds))
+	} else {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "groupSettingsDeleteDisabledMessage"))
+	}
+	return ctx, nil
+}
@@ -28,7 +28,7 @@ func (bot TipBot) registerTelegramHandlers() {
 }
 
 func getDefaultBeforeInterceptor(bot TipBot) []intercept.Func {
-	return []intercept.Func{bot.idInterceptor}
+	return []intercept.Func{bot.idInterceptor, bot.topicInterceptor, bot.senderChatInterceptor}
 }
 func getDefaultDeferInterceptor(bot TipBot) []intercept.Func {
 	return []intercept.Func{bot.unlockInterceptor}
@@ -46,13 +46,16 @@ func (bot TipBot) registerHandlerWithInterceptor(h InterceptionWrapper) {
 		bot.handle(endpoint, intercept.WithHandler(h.Handler,
 			intercept.WithBefore(h.Interceptor.Before...),
 			intercept.WithAfter(h.Interceptor.After...),
-			intercept.WithDefer(h.Interceptor.OnDefer...)))
+			intercept.WithDefer(h.Interceptor.OnDefer...),
+			intercept.WithOnError(bot.recordUpdateError)))
 	}
 }
 
 // handle accepts an endpoint and handler for Telegram handler registration.
 // function will automatically register string handlers as uppercase and first letter uppercase.
 func (bot TipBot) handle(endpoint interface{}, handler tb.HandlerFunc) {
+	// the graceful shutdown waits for running handlers
+	handler = trackInFlight(handler)
 	// register the endpoint
 	bot.Telegram.Handle(endpoint, handler)
 	switch endpoint.(type) {
@@ -123,6 +126,7 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.loadReplyToInterceptor,
 					bot.lockInterceptor,
 				},
@@ -131,6 +135,38 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/tipall"},
+			Handler:   bot.tipAllHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/rain"},
+			Handler:   bot.rainHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/pay"},
 			Handler:   bot.payHandler,
@@ -140,6 +176,7 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -152,6 +189,39 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			Handler:   bot.invoiceHandler,
 			Interceptor: &Interceptor{
 
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/address"},
+			Handler:   bot.addressHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/currency"},
+			Handler:   bot.currencyHandler,
+			Interceptor: &Interceptor{
 				Before: []intercept.Func{
 					bot.requirePrivateChatInterceptor,
 					bot.localizerInterceptor,
@@ -165,7 +235,7 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{"/set"},
+			Endpoints: []interface{}{"/set", "/settings"},
 			Handler:   bot.settingHandler,
 			Interceptor: &Interceptor{
 				Before: []intercept.Func{
@@ -180,6 +250,37 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/language"},
+			Handler:   bot.languageHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnSelectLanguage},
+			Handler:   bot.selectLanguageHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/nostr"},
 			Handler:   bot.nostrHandler,
@@ -196,6 +297,94 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/alerts"},
+			Handler:   bot.alertsHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/roundup"},
+			Handler:   bot.roundUpHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/payout"},
+			Handler:   bot.payoutHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/autoforward"},
+			Handler:   bot.autoForwardHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/contacts"},
+			Handler:   bot.contactsHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/nwc"},
+			Handler:   bot.nwcHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/node"},
 			Handler:   bot.nodeHandler,
@@ -285,6 +474,7 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -301,6 +491,7 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.loadReplyToInterceptor,
 					bot.lockInterceptor,
 				},
@@ -354,6 +545,31 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{"/pending"},
+			Handler:   bot.pendingHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{"/proof"},
+			Handler:   bot.proofHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 				}},
 		},
 		{
@@ -396,6 +612,7 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -411,6 +628,7 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -419,13 +637,14 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{"/help", &btnHelpMainMenu},
-			Handler:   bot.helpHandler,
+			Endpoints: []interface{}{"/pool"},
+			Handler:   bot.poolHandler,
 			Interceptor: &Interceptor{
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
-					bot.loadUserInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -434,14 +653,14 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{"/basics"},
-			Handler:   bot.basicsHandler,
+			Endpoints: []interface{}{"/flip", "/coinflip"},
+			Handler:   bot.gameHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
-					bot.loadUserInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -450,14 +669,14 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{"/donate"},
-			Handler:   bot.donationHandler,
+			Endpoints: []interface{}{"/dice"},
+			Handler:   bot.gameHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -466,14 +685,14 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{"/advanced"},
-			Handler:   bot.advancedHelpHandler,
+			Endpoints: []interface{}{"/quiz"},
+			Handler:   bot.quizHandler,
 			Interceptor: &Interceptor{
 				Before: []intercept.Func{
-					bot.requirePrivateChatInterceptor,
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -482,14 +701,14 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{"/link"},
-			Handler:   bot.lndhubHandler,
+			Endpoints: []interface{}{"/bet"},
+			Handler:   bot.betHandler,
 			Interceptor: &Interceptor{
 				Before: []intercept.Func{
-					bot.requirePrivateChatInterceptor,
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -498,11 +717,10 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{"/api"},
-			Handler:   bot.apiHandler,
+			Endpoints: []interface{}{"/birthday"},
+			Handler:   bot.birthdayHandler,
 			Interceptor: &Interceptor{
 				Before: []intercept.Func{
-					bot.requirePrivateChatInterceptor,
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
@@ -514,11 +732,10 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{"/lnurl"},
-			Handler:   bot.lnurlHandler,
+			Endpoints: []interface{}{"/spend"},
+			Handler:   bot.spendHandler,
 			Interceptor: &Interceptor{
 				Before: []intercept.Func{
-					bot.requirePrivateChatInterceptor,
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
@@ -529,15 +746,15 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
-		// group join
 		{
-			Endpoints: []interface{}{tb.OnUserJoined, tb.OnAddedToGroup},
-			Handler:   bot.handleTelegramNewMember,
+			Endpoints: []interface{}{"/pos"},
+			Handler:   bot.posHandler,
 			Interceptor: &Interceptor{
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
-					bot.tryLoadUserInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -545,10 +762,9 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
-		// group tickets
 		{
-			Endpoints: []interface{}{"/group"},
-			Handler:   bot.groupHandler,
+			Endpoints: []interface{}{"/sell"},
+			Handler:   bot.sellHandler,
 			Interceptor: &Interceptor{
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
@@ -562,15 +778,13 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{"/join"},
-			Handler:   bot.groupRequestJoinHandler,
+			Endpoints: []interface{}{"/ppv"},
+			Handler:   bot.ppvHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
-					bot.requirePrivateChatInterceptor,
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
-					bot.startUserInterceptor,
+					bot.requireUserInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -579,14 +793,14 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{&btnPayTicket},
-			Handler:   bot.groupConfirmPayButtonHandler,
+			Endpoints: []interface{}{"/gift"},
+			Handler:   bot.giftHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
-					bot.answerCallbackInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -595,15 +809,14 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{tb.OnPhoto},
-			Handler:   bot.photoHandler,
+			Endpoints: []interface{}{"/redeem"},
+			Handler:   bot.redeemHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
-					bot.requirePrivateChatInterceptor,
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
-					bot.requireUserInterceptor,
+					bot.loadUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -612,25 +825,14 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{tb.OnDocument, tb.OnVideo, tb.OnAnimation, tb.OnVoice, tb.OnAudio, tb.OnSticker, tb.OnVideoNote},
-			Handler:   bot.fileHandler,
-			Interceptor: &Interceptor{
-
-				Before: []intercept.Func{
-					bot.requirePrivateChatInterceptor,
-					bot.logMessageInterceptor,
-					bot.loadUserInterceptor}},
-		},
-		{
-			Endpoints: []interface{}{tb.OnText},
-			Handler:   bot.anyTextHandler,
+			Endpoints: []interface{}{"/vouchers"},
+			Handler:   bot.vouchersHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
-					bot.requirePrivateChatInterceptor, // Respond to any text only in private chat
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
-					bot.loadUserInterceptor, // need to use loadUserInterceptor instead of requireUserInterceptor, because user might not be registered yet
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -639,11 +841,12 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{tb.OnQuery},
-			Handler:   bot.anyQueryHandler,
+			Endpoints: []interface{}{"/invite"},
+			Handler:   bot.inviteHandler,
 			Interceptor: &Interceptor{
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
 					bot.lockInterceptor,
 				},
@@ -653,18 +856,13 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{tb.OnInlineResult},
-			Handler:   bot.anyChosenInlineHandler,
-		},
-		{
-			Endpoints: []interface{}{&btnPay},
-			Handler:   bot.confirmPayHandler,
+			Endpoints: []interface{}{"/stats"},
+			Handler:   bot.statsHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
-					bot.answerCallbackInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -673,14 +871,13 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{&btnCancelPay},
-			Handler:   bot.cancelPaymentHandler,
+			Endpoints: []interface{}{"/admin"},
+			Handler:   bot.adminHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
 					bot.requireUserInterceptor,
-					bot.answerCallbackInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -689,13 +886,663 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{&btnSend},
+			Endpoints: []interface{}{"/help", &btnHelpMainMenu},
+			Handler:   bot.helpHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.loadUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/basics"},
+			Handler:   bot.basicsHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.loadUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/donate"},
+			Handler:   bot.donationHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/advanced"},
+			Handler:   bot.advancedHelpHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/link", "/lndhub"},
+			Handler:   bot.lndhubHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/import"},
+			Handler:   bot.importHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/api"},
+			Handler:   bot.apiHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/lnurl"},
+			Handler:   bot.lnurlHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		// group join
+		{
+			Endpoints: []interface{}{tb.OnUserJoined, tb.OnAddedToGroup},
+			Handler:   bot.handleTelegramNewMember,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.tryLoadUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		// group tickets
+		{
+			Endpoints: []interface{}{"/group"},
+			Handler:   bot.groupHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/channel"},
+			Handler:   bot.channelHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/pm"},
+			Handler:   bot.pmHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/plan"},
+			Handler:   bot.planHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/subscribe"},
+			Handler:   bot.subscribeHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/groupsettings"},
+			Handler:   bot.groupSettingsHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.loadUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/join"},
+			Handler:   bot.groupRequestJoinHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.startUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnPayTicket},
+			Handler:   bot.groupConfirmPayButtonHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{tb.OnPhoto},
+			Handler:   bot.photoHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.threadInterceptor,
+					bot.tipMediaInterceptor, // private chats and tips with photos
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.loadReplyToInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{tb.OnDocument, tb.OnVideo, tb.OnAnimation, tb.OnVoice, tb.OnAudio, tb.OnSticker, tb.OnVideoNote},
+			Handler:   bot.fileHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.threadInterceptor,
+					bot.tipMediaInterceptor, // private chats and stickers or GIFs of tips
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.loadUserInterceptor,
+					bot.loadReplyToInterceptor}},
+		},
+		{
+			Endpoints: []interface{}{tb.OnText},
+			Handler:   bot.anyTextHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.threadInterceptor,
+					bot.requirePrivateChatInterceptor, // Respond to any text only in private chat
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.loadUserInterceptor, // need to use loadUserInterceptor instead of requireUserInterceptor, because user might not be registered yet
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{tb.OnQuery},
+			Handler:   bot.anyQueryHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{tb.OnInlineResult},
+			Handler:   bot.anyChosenInlineHandler,
+		},
+		{
+			Endpoints: []interface{}{&btnPay},
+			Handler:   bot.confirmPayHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelPay},
+			Handler:   bot.cancelPaymentHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnSend},
 			Handler:   bot.confirmSendHandler,
 			Interceptor: &Interceptor{
-
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnUndoTip},
+			Handler:   bot.undoTipHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/refund"},
+			Handler:   bot.refundHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnAcceptRefund},
+			Handler:   bot.acceptRefundHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnDeclineRefund},
+			Handler:   bot.declineRefundHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelSend},
+			Handler:   bot.cancelSendHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnAcceptInlineSend},
+			Handler:   bot.acceptInlineSendHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelInlineSend},
+			Handler:   bot.cancelInlineSendHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnAcceptInlineReceive},
+			Handler:   bot.acceptInlineReceiveHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelInlineReceive},
+			Handler:   bot.cancelInlineReceiveHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnAcceptInlineFaucet},
+			Handler:   bot.acceptInlineFaucetHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.singletonCallbackInterceptor,
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelInlineFaucet},
+			Handler:   bot.cancelInlineFaucetHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnAcceptInlineTipjar},
+			Handler:   bot.acceptInlineTipjarHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelInlineTipjar},
+			Handler:   bot.cancelInlineTipjarHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnContributePool},
+			Handler:   bot.contributePoolHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelPool},
+			Handler:   bot.cancelPoolHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnAcceptGame},
+			Handler:   bot.acceptGameHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelGame},
+			Handler:   bot.cancelGameHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnAcceptBet},
+			Handler:   bot.acceptBetHandler,
+			Interceptor: &Interceptor{
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.answerCallbackInterceptor,
 					bot.lockInterceptor,
 				},
@@ -705,13 +1552,13 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{&btnCancelSend},
-			Handler:   bot.cancelSendHandler,
+			Endpoints: []interface{}{&btnCancelBet},
+			Handler:   bot.cancelBetHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.answerCallbackInterceptor,
 					bot.lockInterceptor,
 				},
@@ -721,13 +1568,13 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{&btnAcceptInlineSend},
-			Handler:   bot.acceptInlineSendHandler,
+			Endpoints: []interface{}{&btnResolveBet},
+			Handler:   bot.resolveBetHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
-					bot.loadUserInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.answerCallbackInterceptor,
 					bot.lockInterceptor,
 				},
@@ -737,63 +1584,122 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{&btnCancelInlineSend},
-			Handler:   bot.cancelInlineSendHandler,
+			Endpoints: []interface{}{&btnBirthdayTip},
+			Handler:   bot.birthdayTipHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
 					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnAnswerQuiz},
+			Handler:   bot.answerQuizHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
 					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor, // shows whether the answer counts
 				},
 			},
 		},
 		{
-			Endpoints: []interface{}{&btnAcceptInlineReceive},
-			Handler:   bot.acceptInlineReceiveHandler,
+			Endpoints: []interface{}{&btnApproveSpend},
+			Handler:   bot.approveSpendHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
-					bot.loadUserInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
 					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnRejectSpend},
+			Handler:   bot.rejectSpendHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
 					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
 				},
 			},
 		},
 		{
-			Endpoints: []interface{}{&btnCancelInlineReceive},
-			Handler:   bot.cancelInlineReceiveHandler,
+			Endpoints: []interface{}{&btnApproveOperatorPayment},
+			Handler:   bot.approveOperatorPaymentHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
 					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnRejectOperatorPayment},
+			Handler:   bot.rejectOperatorPaymentHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
 					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
 				},
 			},
 		},
 		{
-			Endpoints: []interface{}{&btnAcceptInlineFaucet},
-			Handler:   bot.acceptInlineFaucetHandler,
+			Endpoints: []interface{}{&btnConfirmAnomaly},
+			Handler:   bot.confirmAnomalyHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
-					bot.singletonCallbackInterceptor,
 					bot.localizerInterceptor,
-					bot.loadUserInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
 					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnReportAnomaly},
+			Handler:   bot.reportAnomalyHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -803,13 +1709,13 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{&btnCancelInlineFaucet},
-			Handler:   bot.cancelInlineFaucetHandler,
+			Endpoints: []interface{}{&btnConfirmPayout},
+			Handler:   bot.confirmPayoutHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.answerCallbackInterceptor,
 					bot.lockInterceptor,
 				},
@@ -819,13 +1725,13 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{&btnAcceptInlineTipjar},
-			Handler:   bot.acceptInlineTipjarHandler,
+			Endpoints: []interface{}{&btnCancelPayout},
+			Handler:   bot.cancelPayoutHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.answerCallbackInterceptor,
 					bot.lockInterceptor,
 				},
@@ -835,10 +1741,208 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			},
 		},
 		{
-			Endpoints: []interface{}{&btnCancelInlineTipjar},
-			Handler:   bot.cancelInlineTipjarHandler,
+			Endpoints: []interface{}{&btnClosePos},
+			Handler:   bot.closePosHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnUnlockPaidMedia},
+			Handler:   bot.unlockPaidMediaHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnBuyProduct},
+			Handler:   bot.buyProductHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnRefreshVouchers},
+			Handler:   bot.refreshVouchersHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelVouchers},
+			Handler:   bot.cancelVouchersHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnPrintVouchers},
+			Handler:   bot.printVouchersHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnPrintGift},
+			Handler:   bot.printGiftHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnDonationReceipt},
+			Handler:   bot.donationReceiptHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnSkipRecurringDonation},
+			Handler:   bot.skipRecurringDonationHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelRecurringDonation},
+			Handler:   bot.cancelRecurringDonationHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnRefundPaidMessage},
+			Handler:   bot.refundPaidMessageHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnSubscribePlan},
+			Handler:   bot.subscribePlanHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelSubscription},
+			Handler:   bot.cancelSubscriptionHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnConfirmWalletImport},
+			Handler:   bot.confirmImportHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelWalletImport},
+			Handler:   bot.cancelImportHandler,
 			Interceptor: &Interceptor{
-
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
 					bot.requireUserInterceptor,
@@ -858,6 +1962,7 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
 					bot.requireUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.answerCallbackInterceptor,
 					bot.lockInterceptor,
 				},
@@ -1292,6 +2397,7 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				Before: []intercept.Func{
 					bot.localizerInterceptor,
 					bot.loadUserInterceptor,
+					bot.walletBackendInterceptor,
 					bot.answerCallbackInterceptor,
 					bot.lockInterceptor,
 				},
@@ -0,0 +1,315 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits/lnbitstest"
+	limiter "github.com/LightningTipBot/LightningTipBot/internal/rate"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var testBotUser = &tb.User{ID: 999, IsBot: true, Username: "TestTipBot", FirstName: "TestTipBot"}
+
+// telegramRequest is a call of the bot to the Bot API, like sendMessage.
+type telegramRequest struct {
+	Method string
+	Params map[string]string
+	Id     int // of the message that was sent or edited
+}
+
+func (r telegramRequest) ChatId() int64 {
+	id, _ := strconv.ParseInt(r.Params["chat_id"], 10, 64)
+	return id
+}
+
+func (r telegramRequest) MessageId() int {
+	id, _ := strconv.Atoi(r.Params["message_id"])
+	return id
+}
+
+// Text returns the text of a message or the caption of a photo.
+func (r telegramRequest) Text() string {
+	if text, ok := r.Params["text"]; ok {
+		return text
+	}
+	return r.Params["caption"]
+}
+
+// Buttons returns the inline keyboard of the message.
+func (r telegramRequest) Buttons() []tb.InlineButton {
+	var markup struct {
+		InlineKeyboard [][]tb.InlineButton `json:"inline_keyboard"`
+	}
+	json.Unmarshal([]byte(r.Params["reply_markup"]), &markup)
+	buttons := []tb.InlineButton{}
+	for _, row := range markup.InlineKeyboard {
+		buttons = append(buttons, row...)
+	}
+	return buttons
+}
+
+// fakeTelegram is a Bot API server that records the calls of the bot and answers
+// them like Telegram would.
+type fakeTelegram struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	requests  []telegramRequest
+	messageId int
+	forums    map[int64]bool    // chats with topics
+	admins    []*tb.User        // admins of all groups
+	files     map[string]string // contents of the files that users sent, by file id
+}
+
+func newFakeTelegram() *fakeTelegram {
+	f := &fakeTelegram{forums: map[int64]bool{}, files: map[string]string{}}
+	f.Server = httptest.NewServer(f)
+	return f
+}
+
+func (f *fakeTelegram) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if strings.HasPrefix(request.URL.Path, "/file/") {
+		f.mutex.Lock()
+		content, ok := f.files[path.Base(request.URL.Path)]
+		f.mutex.Unlock()
+		if !ok {
+			http.NotFound(writer, request)
+			return
+		}
+		io.WriteString(writer, content)
+		return
+	}
+	params := map[string]string{}
+	if strings.HasPrefix(request.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := request.ParseMultipartForm(10 << 20); err == nil {
+			for key, values := range request.MultipartForm.Value {
+				params[key] = values[0]
+			}
+		}
+	} else {
+		raw := map[string]interface{}{}
+		json.NewDecoder(request.Body).Decode(&raw)
+		for key, value := range raw {
+			if s, ok := value.(string); ok {
+				params[key] = s
+				continue
+			}
+			b, _ := json.Marshal(value)
+			params[key] = string(b)
+		}
+	}
+	r := telegramRequest{Method: path.Base(request.URL.Path), Params: params}
+	f.mutex.Lock()
+	result := f.result(&r)
+	f.requests = append(f.requests, r)
+	f.mutex.Unlock()
+	json.NewEncoder(writer).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+// result returns the answer of Telegram to a request.
+func (f *fakeTelegram) result(r *telegramRequest) interface{} {
+	switch r.Method {
+	case "getMe":
+		return testBotUser
+	case "sendMessage", "sendPhoto", "sendDocument", "forwardMessage", "copyMessage":
+		f.messageId++
+		r.Id = f.messageId
+		return f.message(*r)
+	case "editMessageText", "editMessageCaption", "editMessageReplyMarkup":
+		if _, inline := r.Params["inline_message_id"]; inline {
+			return true
+		}
+		r.Id = r.MessageId()
+		return f.message(*r)
+	case "getChatAdministrators":
+		admins := []tb.ChatMember{}
+		for _, admin := range f.admins {
+			admins = append(admins, tb.ChatMember{User: admin, Role: tb.Administrator, Rights: tb.Rights{CanInviteUsers: true, CanRestrictMembers: true}})
+		}
+		return admins
+	case "createChatInviteLink":
+		return map[string]interface{}{"invite_link": "https://t.me/+invite", "member_limit": 1}
+	case "getChat":
+		return map[string]interface{}{"id": r.ChatId(), "type": tb.ChatSuperGroup, "is_forum": f.forums[r.ChatId()]}
+	case "getChatMember":
+		return tb.ChatMember{Role: tb.Member}
+	case "getFile":
+		return map[string]interface{}{"file_id": r.Params["file_id"], "file_path": r.Params["file_id"]}
+	case "getUserProfilePhotos":
+		return map[string]interface{}{"total_count": 0, "photos": []interface{}{}}
+	}
+	return true
+}
+
+func (f *fakeTelegram) message(r telegramRequest) map[string]interface{} {
+	chatType := tb.ChatPrivate
+	if r.ChatId() < 0 {
+		chatType = tb.ChatGroup
+	}
+	return map[string]interface{}{
+		"message_id": r.Id,
+		"date":       time.Now().Unix(),
+		"from":       testBotUser,
+		"chat":       map[string]interface{}{"id": r.ChatId(), "type": chatType},
+		"text":       r.Text(),
+	}
+}
+
+// Requests returns the calls of the bot since the last call of Requests.
+func (f *fakeTelegram) Requests() []telegramRequest {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	requests := f.requests
+	f.requests = nil
+	return requests
+}
+
+// testHarness runs updates through the registered handlers of a bot that talks to
+// a fake Telegram and a fake LNbits. Handlers run synchronously, after an update
+// was processed, all messages of the bot are recorded.
+type testHarness struct {
+	t        *testing.T
+	bot      *TipBot
+	lnbits   *lnbitstest.Server
+	telegram *fakeTelegram
+	updateId int
+	sent     []telegramRequest
+}
+
+func newTestHarness(t *testing.T) *testHarness {
+	t.Helper()
+	bot, server := newTestBot(t)
+	telegram := newFakeTelegram()
+	t.Cleanup(telegram.Close)
+	tgb, err := tb.NewBot(tb.Settings{
+		URL:         telegram.URL,
+		Token:       "test",
+		ParseMode:   tb.ModeMarkdown,
+		Synchronous: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot.Telegram = tgb
+	limiter.Start()
+	// not registerTelegramHandlers, every test has its own bot
+	for _, h := range bot.getHandler() {
+		bot.register(h)
+	}
+	return &testHarness{t: t, bot: bot, lnbits: server, telegram: telegram}
+}
+
+// newUser creates a user with a wallet that holds balance sat.
+func (h *testHarness) newUser(telegramUser *tb.User, balance int64) *lnbits.User {
+	return newTestUser(h.t, h.bot, h.lnbits, telegramUser, balance)
+}
+
+func privateChat(user *tb.User) *tb.Chat {
+	return &tb.Chat{ID: user.ID, Type: tb.ChatPrivate}
+}
+
+// process runs an update and records the calls of the bot.
+func (h *testHarness) process(update tb.Update) {
+	h.updateId++
+	update.ID = h.updateId
+	h.bot.Telegram.ProcessUpdate(update)
+	h.sent = append(h.sent, h.telegram.Requests()...)
+}
+
+// sendMessage delivers a message of from in chat.
+func (h *testHarness) sendMessage(from *tb.User, chat *tb.Chat, text string) *tb.Message {
+	return h.sendReply(from, chat, text, nil)
+}
+
+// replyToBot delivers a message of from that replies to a message of the bot.
+func (h *testHarness) replyToBot(from *tb.User, message telegramRequest, text string) *tb.Message {
+	chat := &tb.Chat{ID: message.ChatId(), Type: tb.ChatPrivate}
+	if message.ChatId() < 0 {
+		chat.Type = tb.ChatGroup
+	}
+	replyTo := &tb.Message{ID: message.Id, Sender: testBotUser, Chat: chat, Text: message.Text()}
+	return h.sendReply(from, chat, text, replyTo)
+}
+
+func (h *testHarness) sendReply(from *tb.User, chat *tb.Chat, text string, replyTo *tb.Message) *tb.Message {
+	h.telegram.mutex.Lock()
+	h.telegram.messageId++
+	id := h.telegram.messageId
+	h.telegram.mutex.Unlock()
+	message := &tb.Message{ID: id, Sender: from, Chat: chat, Text: text, Unixtime: time.Now().Unix(), ReplyTo: replyTo}
+	if strings.HasPrefix(text, "/") {
+		command := strings.Split(text, " ")[0]
+		message.Entities = []tb.MessageEntity{{Type: tb.EntityCommand, Offset: 0, Length: len(command)}}
+	}
+	h.process(tb.Update{Message: message})
+	return message
+}
+
+// sendDocument delivers a file of from in chat.
+func (h *testHarness) sendDocument(from *tb.User, chat *tb.Chat, caption string, name string, content string) *tb.Message {
+	h.telegram.mutex.Lock()
+	h.telegram.messageId++
+	id := h.telegram.messageId
+	fileId := fmt.Sprintf("file-%d", id)
+	h.telegram.files[fileId] = content
+	h.telegram.mutex.Unlock()
+	message := &tb.Message{ID: id, Sender: from, Chat: chat, Caption: caption, Unixtime: time.Now().Unix(),
+		Document: &tb.Document{File: tb.File{FileID: fileId, FileSize: int64(len(content))}, FileName: name}}
+	h.process(tb.Update{Message: message})
+	return message
+}
+
+// pressButton presses the inline button with the text under a message of the bot.
+func (h *testHarness) pressButton(from *tb.User, message telegramRequest, text string) {
+	h.t.Helper()
+	for _, button := range message.Buttons() {
+		if button.Text != text {
+			continue
+		}
+		chat := &tb.Chat{ID: message.ChatId(), Type: tb.ChatPrivate}
+		if message.ChatId() < 0 {
+			chat.Type = tb.ChatGroup
+		}
+		h.process(tb.Update{Callback: &tb.Callback{
+			ID:      fmt.Sprintf("callback-%d", h.updateId),
+			Sender:  from,
+			Message: &tb.Message{ID: message.Id, Sender: testBotUser, Chat: chat, Text: message.Text()},
+			Data:    button.Data,
+		}})
+		return
+	}
+	h.t.Fatalf("no button %q under message %q", text, message.Text())
+}
+
+// lastMessage returns the last message that the bot sent to a chat.
+func (h *testHarness) lastMessage(chatId int64) telegramRequest {
+	h.t.Helper()
+	for i := len(h.sent) - 1; i >= 0; i-- {
+		r := h.sent[i]
+		if r.ChatId() == chatId && (r.Method == "sendMessage" || r.Method == "sendPhoto" || r.Method == "editMessageText") {
+			return r
+		}
+	}
+	h.t.Fatalf("no message to chat %d", chatId)
+	return telegramRequest{}
+}
+
+// called returns true if the bot made a call of method to the chat.
+func (h *testHarness) called(method string, chatId int64) bool {
+	for _, r := range h.sent {
+		if r.Method == method && r.ChatId() == chatId {
+			return true
+		}
+	}
+	return false
+}
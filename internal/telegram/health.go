@@ -0,0 +1,71 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"gorm.io/gorm"
+)
+
+const readinessTimeout = 5 * time.Second
+
+// Readiness checks the services the bot depends on. It returns the error of every
+// check by its name, nil if the check passed.
+func (bot *TipBot) Readiness() map[string]error {
+	checks := map[string]func() error{
+		"telegram": func() error {
+			_, err := bot.Telegram.Raw("getMe", nil)
+			return err
+		},
+		"wallet": func() error {
+			if !bot.walletBackendAvailable() {
+				return lnbits.ErrBackendUnavailable
+			}
+			return lnbits.Ping(bot.Client)
+		},
+		"database": func() error {
+			for _, db := range []*gorm.DB{bot.DB.Users, bot.DB.Transactions, bot.DB.Groups} {
+				sqlDB, err := db.DB()
+				if err != nil {
+					return err
+				}
+				if err := sqlDB.Ping(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		"shutdown": func() error {
+			if isShuttingDown() {
+				return fmt.Errorf("shutting down")
+			}
+			return nil
+		},
+	}
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(checks))
+	for name, check := range checks {
+		go func(name string, check func() error) {
+			results <- result{name: name, err: check()}
+		}(name, check)
+	}
+	errs := make(map[string]error, len(checks))
+	timeout := time.After(readinessTimeout)
+	for len(errs) < len(checks) {
+		select {
+		case r := <-results:
+			errs[r.name] = r.err
+		case <-timeout:
+			for name := range checks {
+				if _, ok := errs[name]; !ok {
+					errs[name] = fmt.Errorf("timeout after %s", readinessTimeout)
+				}
+			}
+		}
+	}
+	return errs
+}
@@ -5,6 +5,10 @@ import (
 	"math/rand"
 	"strings"
 	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
 
 func init() {
@@ -28,12 +32,40 @@ func GetMemoFromCommand(command string, fromWord int) string {
 		memo = strings.SplitN(command, " ", fromWord+1)[fromWord]
 		memoMaxLen := 159
 		if len(memo) > memoMaxLen {
-			memo = memo[:memoMaxLen]
+			// don't cut a character in half
+			end := memoMaxLen
+			for end > 0 && !utf8.RuneStart(memo[end]) {
+				end--
+			}
+			memo = memo[:end]
 		}
 	}
 	return memo
 }
 
+// entityText returns the text of a message entity like a mention. Telegram counts offsets
+// and lengths in UTF-16 code units, entities outside of the text return an empty string.
+func entityText(text string, entity tb.MessageEntity) string {
+	units := utf16.Encode([]rune(text))
+	if entity.Offset < 0 || entity.Length < 0 || entity.Offset > len(units) || entity.Length > len(units)-entity.Offset {
+		return ""
+	}
+	start, end := entity.Offset, entity.Offset+entity.Length
+	// entities can't begin or end in the middle of a character with two code units
+	if start < len(units) && isLowSurrogate(units[start]) || end > start && isHighSurrogate(units[end-1]) {
+		return ""
+	}
+	return string(utf16.Decode(units[start:end]))
+}
+
+func isHighSurrogate(unit uint16) bool {
+	return unit >= 0xd800 && unit < 0xdc00
+}
+
+func isLowSurrogate(unit uint16) bool {
+	return unit >= 0xdc00 && unit < 0xe000
+}
+
 func MakeProgressbar(current int64, total int64) string {
 	MAX_BARS := 16
 	progress := math.Round((float64(current) / float64(total)) * float64(MAX_BARS))
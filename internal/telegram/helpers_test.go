@@ -0,0 +1,60 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestEntityText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		entity tb.MessageEntity
+		want   string
+	}{
+		{name: "ascii", text: "/send 100 @user", entity: tb.MessageEntity{Offset: 10, Length: 5}, want: "@user"},
+		// the banana is two UTF-16 code units but four bytes
+		{name: "emoji", text: "/send 🍌 @user", entity: tb.MessageEntity{Offset: 9, Length: 5}, want: "@user"},
+		{name: "outside", text: "/send 100", entity: tb.MessageEntity{Offset: 10, Length: 5}, want: ""},
+		{name: "negative", text: "/send 100", entity: tb.MessageEntity{Offset: -1, Length: 5}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := entityText(tt.text, tt.entity); got != tt.want {
+				t.Errorf("entityText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzEntityText(f *testing.F) {
+	f.Add("/send 100 @user", 10, 5)
+	f.Add("/send 🍌 @user", 9, 5)
+	f.Add("@", 0, 1<<62)
+	f.Fuzz(func(t *testing.T, text string, offset, length int) {
+		mention := entityText(text, tb.MessageEntity{Type: tb.EntityMention, Offset: offset, Length: length})
+		if utf8.ValidString(text) && !strings.Contains(text, mention) {
+			t.Errorf("entityText(%q, %d, %d) = %q is not part of the text", text, offset, length, mention)
+		}
+	})
+}
+
+func FuzzGetMemoFromCommand(f *testing.F) {
+	f.Add("/tip 100 thanks", 2)
+	f.Add("/send 100 @user "+strings.Repeat("🍌", 100), 3)
+	f.Fuzz(func(t *testing.T, command string, fromWord int) {
+		if fromWord < 0 {
+			return
+		}
+		memo := GetMemoFromCommand(command, fromWord)
+		if len(memo) > 159 {
+			t.Errorf("memo of %d bytes", len(memo))
+		}
+		if utf8.ValidString(command) && !utf8.ValidString(memo) {
+			t.Errorf("GetMemoFromCommand(%q) cut a character: %q", command, memo)
+		}
+	})
+}
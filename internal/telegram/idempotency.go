@@ -0,0 +1,90 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+)
+
+// IdempotencyKey marks a balance changing operation as started. The key is the primary
+// key, so an operation can only be claimed once, even if Telegram delivers an update twice.
+// The key of an operation that failed is released, so that it can be tried again.
+type IdempotencyKey struct {
+	Key       string `gorm:"primaryKey"`
+	CreatedAt time.Time
+	Done      bool // the operation completed
+}
+
+var (
+	// errDuplicateOperation is returned if an operation with the same idempotency key completed before.
+	errDuplicateOperation = errors.Create(errors.DuplicateOperationError)
+	// errOperationInProgress is returned if an operation with the same idempotency key was started
+	// but did not complete yet, or its outcome is unknown.
+	errOperationInProgress = errors.Create(errors.OperationInProgressError)
+)
+
+// isDuplicateOperation tells whether err was returned for an operation that completed or is
+// in progress already, a redelivered update that needs no answer.
+func isDuplicateOperation(err error) bool {
+	return err == errDuplicateOperation || err == errOperationInProgress
+}
+
+// idempotencyKey identifies the command invocation of a context. A redelivered message or a
+// second click on the same button by the same user results in the same key.
+func idempotencyKey(ctx intercept.Context) string {
+	if c := ctx.Callback(); c != nil {
+		return fmt.Sprintf("callback:%s:%s:%d", c.Unique, c.Data, c.Sender.ID)
+	}
+	if m := ctx.Message(); m != nil {
+		return fmt.Sprintf("message:%d:%d", m.Chat.ID, m.ID)
+	}
+	return ""
+}
+
+// claimIdempotencyKey returns errDuplicateOperation if the operation of the key completed
+// before and errOperationInProgress if it was started but did not complete. Other errors of
+// the database are returned as they are.
+func (bot *TipBot) claimIdempotencyKey(key string) error {
+	if len(key) == 0 {
+		return nil
+	}
+	tx := bot.DB.Transactions.Create(&IdempotencyKey{Key: key, CreatedAt: time.Now()})
+	if tx.Error == nil {
+		return nil
+	}
+	claimed := &IdempotencyKey{}
+	if bot.DB.Transactions.Where("key = ?", key).First(claimed).Error != nil {
+		log.Errorf("[idempotency] could not claim operation %s: %v", key, tx.Error)
+		return tx.Error
+	}
+	if claimed.Done {
+		log.Warnf("[idempotency] operation %s was already processed", key)
+		return errDuplicateOperation
+	}
+	log.Warnf("[idempotency] operation %s is in progress", key)
+	return errOperationInProgress
+}
+
+// completeIdempotencyKey marks the operation of the key as completed.
+func (bot *TipBot) completeIdempotencyKey(key string) {
+	if len(key) == 0 {
+		return
+	}
+	if tx := bot.DB.Transactions.Model(&IdempotencyKey{}).Where("key = ?", key).Update("done", true); tx.Error != nil {
+		log.Errorf("[idempotency] could not complete operation %s: %v", key, tx.Error)
+	}
+}
+
+// releaseIdempotencyKey releases the key of an operation that failed, so that it can be tried
+// again. Only call it if the operation certainly did not move any funds.
+func (bot *TipBot) releaseIdempotencyKey(key string) {
+	if len(key) == 0 {
+		return
+	}
+	if tx := bot.DB.Transactions.Where("key = ? AND done = ?", key, false).Delete(&IdempotencyKey{}); tx.Error != nil {
+		log.Errorf("[idempotency] could not release operation %s: %v", key, tx.Error)
+	}
+}
@@ -0,0 +1,275 @@
+package telegram
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	walletImportMenu       = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnConfirmWalletImport = walletImportMenu.Data("✅ Import", "confirm_import")
+	btnCancelWalletImport  = walletImportMenu.Data("🚫 Cancel", "cancel_import")
+)
+
+// WalletImport is a wallet on the LNbits server of the bot that a user wants to use
+// instead of the wallet that the bot created.
+type WalletImport struct {
+	*storage.Base
+	User         *lnbits.User  `json:"user"`
+	Wallet       lnbits.Wallet `json:"wallet"`
+	LanguageCode string        `json:"languagecode"`
+}
+
+// walletImportSource is what a user pasted to /import: the keys of a wallet or the
+// account and the wallet that it belongs to.
+type walletImportSource struct {
+	Host     string // empty for bare keys
+	UserId   string
+	WalletId string
+	Adminkey string
+}
+
+func isLnbitsKey(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 16
+}
+
+// parseWalletImport accepts the URL of an LNbits wallet, an lndhub:// string of an
+// LNbits wallet or a bare admin key.
+func parseWalletImport(input string) (walletImportSource, error) {
+	input = strings.TrimSpace(input)
+	if isLnbitsKey(input) {
+		return walletImportSource{Adminkey: input}, nil
+	}
+	if strings.HasPrefix(input, "lndhub://") {
+		// lndhub://admin:<adminkey>@<url of the lndhub extension>
+		credentials, hub, ok := strings.Cut(strings.TrimPrefix(input, "lndhub://"), "@")
+		_, key, _ := strings.Cut(credentials, ":")
+		u, err := url.Parse(hub)
+		if !ok || err != nil || !isLnbitsKey(key) {
+			return walletImportSource{}, fmt.Errorf("invalid lndhub string")
+		}
+		return walletImportSource{Host: u.Host, Adminkey: key}, nil
+	}
+	u, err := url.Parse(input)
+	if err != nil || len(u.Host) == 0 {
+		return walletImportSource{}, fmt.Errorf("invalid wallet url")
+	}
+	source := walletImportSource{Host: u.Host, UserId: u.Query().Get("usr"), WalletId: u.Query().Get("wal")}
+	if !isLnbitsKey(source.UserId) || !isLnbitsKey(source.WalletId) {
+		return walletImportSource{}, fmt.Errorf("invalid wallet url")
+	}
+	return source, nil
+}
+
+// lnbitsHosts returns the hosts under which the LNbits server of the bot is reachable.
+func lnbitsHosts() []string {
+	var hosts []string
+	for _, s := range []string{internal.Configuration.Lnbits.Url, internal.Configuration.Lnbits.LnbitsPublicUrl} {
+		if u, err := url.Parse(s); err == nil && len(u.Host) > 0 {
+			hosts = append(hosts, strings.ToLower(u.Host))
+		}
+	}
+	return hosts
+}
+
+// resolveWalletImport finds the wallet on the LNbits server of the bot.
+func (bot *TipBot) resolveWalletImport(source walletImportSource) (lnbits.Wallet, error) {
+	if len(source.Host) > 0 {
+		known := false
+		for _, host := range lnbitsHosts() {
+			known = known || host == strings.ToLower(source.Host)
+		}
+		if !known {
+			return lnbits.Wallet{}, fmt.Errorf("wallet is on %s, not on the lnbits server of the bot", source.Host)
+		}
+	}
+	client, ok := lnbits.Unwrap(bot.Client).(*lnbits.Client)
+	if !ok {
+		return lnbits.Wallet{}, fmt.Errorf("wallet backend is not lnbits")
+	}
+	if len(source.Adminkey) == 0 {
+		wallets, err := client.Wallets(lnbits.User{ID: source.UserId})
+		if err != nil {
+			return lnbits.Wallet{}, err
+		}
+		for _, wallet := range wallets {
+			if wallet.ID == source.WalletId {
+				return wallet, nil
+			}
+		}
+		return lnbits.Wallet{}, fmt.Errorf("wallet %s not found", source.WalletId)
+	}
+	// LNbits only tells the id of a wallet to its admin key
+	info, err := client.Balance(lnbits.Wallet{Inkey: source.Adminkey})
+	if err != nil {
+		return lnbits.Wallet{}, err
+	}
+	if len(info.ID) == 0 {
+		return lnbits.Wallet{}, fmt.Errorf("not an admin key")
+	}
+	// the admin key is also accepted for everything that needs the invoice key
+	return lnbits.Wallet{ID: info.ID, Name: info.Name, Balance: info.Balance, Adminkey: source.Adminkey, Inkey: source.Adminkey}, nil
+}
+
+// walletImportInUse returns true if a user of the bot has the wallet already.
+func (bot *TipBot) walletImportInUse(wallet lnbits.Wallet) bool {
+	var count int64
+	bot.DB.Users.Model(&lnbits.User{}).Where("wallet_id = ?", wallet.ID).Count(&count)
+	return count > 0
+}
+
+// importHandler is invoked on /import <wallet> and asks the user to confirm that the bot
+// uses the wallet from now on.
+func (bot *TipBot) importHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	splits := strings.Fields(m.Text)
+	if len(splits) < 2 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "importHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	// the message holds the keys of the wallet
+	bot.tryDeleteMessage(m)
+	source, err := parseWalletImport(splits[1])
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "importInvalidMessage")+"\n\n"+Translate(ctx, "importHelpMessage"))
+		return ctx, err
+	}
+	wallet, err := bot.resolveWalletImport(source)
+	if err != nil {
+		logger(ctx).Warnf("[/import] %s: %v", GetUserStr(user.Telegram), err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "importNotFoundMessage"))
+		return ctx, err
+	}
+	if wallet.ID == user.Wallet.ID {
+		bot.trySendMessage(m.Sender, Translate(ctx, "importSameWalletMessage"))
+		return ctx, fmt.Errorf("wallet is already the wallet of the user")
+	}
+	if bot.walletImportInUse(wallet) {
+		bot.trySendMessage(m.Sender, Translate(ctx, "importInUseMessage"))
+		return ctx, fmt.Errorf("wallet %s is used by another user", wallet.ID)
+	}
+	balance, err := bot.GetUserBalance(user)
+	if err != nil {
+		return ctx, err
+	}
+
+	id := fmt.Sprintf("import:%d:%s", user.Telegram.ID, RandStringRunes(5))
+	walletImport := &WalletImport{
+		Base:         storage.New(storage.ID(id)),
+		User:         user,
+		Wallet:       wallet,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	runtime.IgnoreError(walletImport.Set(walletImport, bot.Bunt))
+
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	menu.Inline(menu.Row(
+		menu.Data(Translate(ctx, "importButtonMessage"), "confirm_import", id),
+		menu.Data(Translate(ctx, "cancelButtonMessage"), "cancel_import", id),
+	))
+	message := fmt.Sprintf(Translate(ctx, "importConfirmMessage"), str.MarkdownEscape(wallet.Name), wallet.Balance/1000)
+	if balance > 0 {
+		message += fmt.Sprintf(Translate(ctx, "importMoveBalanceMessage"), balance)
+	}
+	bot.trySendMessage(m.Sender, message, menu)
+	return ctx, nil
+}
+
+// loadWalletImport loads an active wallet import of a button that only its user may press.
+func (bot *TipBot) loadWalletImport(ctx intercept.Context) (*WalletImport, error) {
+	walletImport := &WalletImport{Base: storage.New(storage.ID(ctx.Data()))}
+	sn, err := walletImport.Get(walletImport, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	walletImport = sn.(*WalletImport)
+	if walletImport.User.Telegram.ID != ctx.Sender().ID {
+		return nil, errors.Create(errors.UnknownError)
+	}
+	if !walletImport.Active {
+		bot.tryEditMessage(ctx.Message(), i18n.Translate(walletImport.LanguageCode, "importCancelledMessage"), &tb.ReplyMarkup{})
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	return walletImport, nil
+}
+
+// confirmImportHandler moves the balance of the user to the imported wallet and lets the
+// user use it from now on.
+func (bot *TipBot) confirmImportHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	walletImport, err := bot.loadWalletImport(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	runtime.IgnoreError(walletImport.Inactivate(walletImport, bot.Bunt))
+	languageCode := walletImport.LanguageCode
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if bot.walletImportInUse(walletImport.Wallet) {
+		bot.tryEditMessage(ctx.Message(), i18n.Translate(languageCode, "importInUseMessage"), &tb.ReplyMarkup{})
+		return ctx, fmt.Errorf("wallet %s is used by another user", walletImport.Wallet.ID)
+	}
+	old := *user.Wallet
+	balance, err := bot.GetUserBalance(user)
+	if err != nil {
+		return ctx, err
+	}
+	if balance > 0 {
+		// payments between wallets of the same LNbits server are free
+		invoice, err := bot.Client.CreateInvoice(walletImport.Wallet, lnbits.InvoiceParams{Amount: balance, Memo: "Wallet import"})
+		if err == nil {
+			_, err = bot.Client.Pay(old, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest})
+		}
+		if err != nil {
+			logger(ctx).Errorf("[/import] could not move the balance of %s: %v", GetUserStr(user.Telegram), err)
+			bot.tryEditMessage(ctx.Message(), i18n.Translate(languageCode, "importFailedMessage"), &tb.ReplyMarkup{})
+			return ctx, err
+		}
+	}
+	wallet := walletImport.Wallet
+	wallet.Balance = 0
+	user.Wallet = &wallet
+	if err := UpdateUserRecord(user, *bot); err != nil {
+		// the balance is in the imported wallet already, the operator has to fix this
+		logger(ctx).Errorf("[/import] could not save wallet %s of %s, the balance is there: %v", wallet.ID, GetUserStr(user.Telegram), err)
+		bot.tryEditMessage(ctx.Message(), i18n.Translate(languageCode, "importIncompleteMessage"), &tb.ReplyMarkup{})
+		return ctx, err
+	}
+	logger(ctx).Infof("[/import] %s replaced wallet %s with wallet %s and moved %d sat", GetUserStr(user.Telegram), old.ID, wallet.ID, balance)
+	bot.tryEditMessage(ctx.Message(), fmt.Sprintf(i18n.Translate(languageCode, "importSuccessMessage"), str.MarkdownEscape(wallet.Name)), &tb.ReplyMarkup{})
+	return ctx, nil
+}
+
+// cancelImportHandler keeps the wallet of the user.
+func (bot *TipBot) cancelImportHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	walletImport, err := bot.loadWalletImport(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	runtime.IgnoreError(walletImport.Inactivate(walletImport, bot.Bunt))
+	bot.tryEditMessage(ctx.Message(), i18n.Translate(walletImport.LanguageCode, "importCancelledMessage"), &tb.ReplyMarkup{})
+	return ctx, nil
+}
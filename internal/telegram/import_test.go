@@ -0,0 +1,70 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestWalletImport(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9911, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9912, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	aliceUser := h.newUser(alice, 300)
+	bobUser := h.newUser(bob, 0)
+	for _, user := range []*tb.User{alice, bob} {
+		u, _ := GetUser(user, *h.bot)
+		u.Initialized = true
+		if err := UpdateUserRecord(u, *h.bot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	configuration := internal.Configuration
+	t.Cleanup(func() { internal.Configuration = configuration })
+	internal.Configuration.Lnbits.Url = h.lnbits.URL
+
+	// alice's own wallet on the lnbits server of the bot
+	account, wallet := h.lnbits.NewUser("alice's wallet")
+	if err := h.lnbits.Fund(wallet.ID, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	// wallets of other servers and of other users are refused
+	h.sendMessage(alice, privateChat(alice), fmt.Sprintf("/import https://lnbits.example.com/wallet?usr=%s&wal=%s", account.ID, wallet.ID))
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "Couldn't find this wallet") {
+		t.Errorf("import of another server = %q", text)
+	}
+	h.sendMessage(alice, privateChat(alice), "/import "+bobUser.Wallet.Adminkey)
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "used by someone else") {
+		t.Errorf("import of bob's wallet = %q", text)
+	}
+
+	h.sendMessage(alice, privateChat(alice), fmt.Sprintf("/import %s/wallet?usr=%s&wal=%s", h.lnbits.URL, account.ID, wallet.ID))
+	if !h.called("deleteMessage", alice.ID) {
+		t.Error("the message with the wallet was not deleted")
+	}
+	confirmation := h.lastMessage(alice.ID)
+	if text := confirmation.Text(); !strings.Contains(text, "(50 sat)") || !strings.Contains(text, "300 sat will be moved") {
+		t.Fatalf("confirmation = %q", text)
+	}
+	h.pressButton(alice, confirmation, "✅ Import")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "imported") {
+		t.Errorf("message after import = %q", text)
+	}
+	imported, err := GetUser(alice, *h.bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Wallet.ID != wallet.ID || imported.ID != aliceUser.ID {
+		t.Errorf("wallet of alice = %s of account %s, want %s of account %s", imported.Wallet.ID, imported.ID, wallet.ID, aliceUser.ID)
+	}
+	if balance := h.lnbits.Balance(wallet.ID); balance != 350 {
+		t.Errorf("balance of the imported wallet = %d, want 350", balance)
+	}
+	if balance := h.lnbits.Balance(aliceUser.Wallet.ID); balance != 0 {
+		t.Errorf("balance of the old wallet = %d, want 0", balance)
+	}
+}
@@ -0,0 +1,71 @@
+package telegram
+
+import (
+	"fmt"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	log "github.com/sirupsen/logrus"
+)
+
+// KnowsWallet returns true if the wallet belongs to a user of the bot.
+func (bot *TipBot) KnowsWallet(walletID string) (bool, error) {
+	if len(walletID) == 0 {
+		return false, nil
+	}
+	var count int64
+	tx := bot.DB.Users.Model(&lnbits.User{}).Where("wallet_id = ?", walletID).Count(&count)
+	return count > 0, tx.Error
+}
+
+// handleIncomingPayment is subscribed to the wallet backend and triggers the
+// callback of the invoice event that belongs to the payment.
+func (bot *TipBot) handleIncomingPayment(payment lnbits.IncomingPayment) {
+	user := &lnbits.User{}
+	tx := bot.DB.Users.Where("wallet_id = ?", payment.WalletID).First(user)
+	if tx.Error != nil {
+		log.Errorf("[handleIncomingPayment] Error getting user: %s", tx.Error.Error())
+		return
+	}
+	logger := log.WithFields(log.Fields{"user_id": user.Telegram.ID, "payment_hash": payment.PaymentHash})
+	logger.Infoln(fmt.Sprintf("[⚡️ WebHook] User %s (%d) received invoice of %d sat.", GetUserStr(user.Telegram), user.Telegram.ID, payment.Amount/1000))
+
+	// trigger invoice events
+	txInvoiceEvent := &InvoiceEvent{Invoice: &Invoice{PaymentHash: payment.PaymentHash}}
+	// the webhook, the payment stream and the reconciler can all deliver the same payment
+	mutex.Lock(txInvoiceEvent.Key())
+	err := bot.Bunt.Get(txInvoiceEvent)
+	if err == nil && txInvoiceEvent.Settled {
+		mutex.Unlock(txInvoiceEvent.Key())
+		logger.Infoln("[handleIncomingPayment] payment was already handled")
+		return
+	}
+	if err == nil && txInvoiceEvent.Open {
+		txInvoiceEvent.Open, txInvoiceEvent.Settled = false, true
+		runtime.IgnoreError(bot.Bunt.Set(txInvoiceEvent))
+	}
+	mutex.Unlock(txInvoiceEvent.Key())
+	defer bot.scheduleAutoForward(user)
+	if err != nil {
+		logger.Errorln(err)
+	} else {
+		// invoices without amount are credited with the amount that was paid
+		if txInvoiceEvent.Amount == 0 {
+			txInvoiceEvent.Amount = payment.Amount / 1000
+		}
+		// do something with the event
+		if c := InvoiceCallback[txInvoiceEvent.Callback]; c.Function != nil {
+			if err := AssertEventType(txInvoiceEvent, c.Type); err != nil {
+				logger.Errorln(err)
+				return
+			}
+			go c.Function(txInvoiceEvent)
+			return
+		}
+	}
+
+	// fallback: send a message to the user if there is no callback for this invoice
+	bot.notify(user, NotificationPayment, payment.Amount/1000, fmt.Sprintf(i18n.Translate(user.Telegram.LanguageCode, "invoiceReceivedMessage"), payment.Amount/1000))
+}
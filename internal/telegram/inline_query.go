@@ -124,40 +124,40 @@ func (bot TipBot) commandTranslationMap(ctx context.Context, command string) con
 	// is default, we don't have to check it
 	// case "faucet":
 	// 	ctx = context.WithValue(ctx, "publicLanguageCode", "en")
-	// 	ctx = context.WithValue(ctx, "publicLocalizer", i18n.NewLocalizer(i18n.Bundle, "en"))
+	// 	ctx = context.WithValue(ctx, "publicLocalizer", i18n.NewLocalizer(i18n.CurrentBundle(), "en"))
 	case "crane", "spigot", "tap", "hydrant", "funding":
 		ctx = context.WithValue(ctx, "publicLanguageCode", "en")
-		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.Bundle, "en"))
+		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.CurrentBundle(), "en"))
 	case "zapfhahn", "spendendose":
 		ctx = context.WithValue(ctx, "publicLanguageCode", "de")
-		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.Bundle, "de"))
+		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.CurrentBundle(), "de"))
 	case "kraan", "fonds":
 		ctx = context.WithValue(ctx, "publicLanguageCode", "nl")
-		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.Bundle, "nl"))
+		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.CurrentBundle(), "nl"))
 	case "grifo":
 		ctx = context.WithValue(ctx, "publicLanguageCode", "es")
-		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.Bundle, "es"))
+		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.CurrentBundle(), "es"))
 	case "hana", "tippikulho":
 		ctx = context.WithValue(ctx, "publicLanguageCode", "fi")
-		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.Bundle, "fi"))
+		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.CurrentBundle(), "fi"))
 	case "keran":
 		ctx = context.WithValue(ctx, "publicLanguageCode", "id")
-		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.Bundle, "id"))
+		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.CurrentBundle(), "id"))
 	case "distribuzione", "salvadanaio":
 		ctx = context.WithValue(ctx, "publicLanguageCode", "it")
-		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.Bundle, "it"))
+		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.CurrentBundle(), "it"))
 	case "torneira", "cofrinho":
 		ctx = context.WithValue(ctx, "publicLanguageCode", "pt")
-		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.Bundle, "br"))
+		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.CurrentBundle(), "br"))
 	case "fici", "bagiskutusu":
 		ctx = context.WithValue(ctx, "publicLanguageCode", "tr")
-		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.Bundle, "tr"))
+		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.CurrentBundle(), "tr"))
 	case "kran", "tipdjar":
 		ctx = context.WithValue(ctx, "publicLanguageCode", "ru")
-		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.Bundle, "ru"))
+		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.CurrentBundle(), "ru"))
 	case "kohoutek":
 		ctx = context.WithValue(ctx, "publicLanguageCode", "cs")
-		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.Bundle, "cs"))
+		ctx = context.WithValue(ctx, "publicLocalizer", i18n2.NewLocalizer(i18n.CurrentBundle(), "cs"))
 	}
 	return ctx
 }
@@ -40,6 +40,7 @@ type InlineReceive struct {
 	From_SpecificUser bool         `json:"from_specific_user"`
 	Memo              string       `json:"inline_receive_memo"`
 	LanguageCode      string       `json:"languagecode"`
+	Fee               int64        `json:"fee,omitempty"` // service fee of the payer
 }
 
 func (bot TipBot) makeReceiveKeyboard(ctx context.Context, id string) *tb.ReplyMarkup {
@@ -252,7 +253,7 @@ func (bot *TipBot) sendInlineReceiveHandler(ctx intercept.Context) (intercept.Co
 
 	// todo: user new get username function to get userStrings
 	transactionMemo := fmt.Sprintf("💸 Receive from %s to %s.", fromUserStr, toUserStr)
-	t := NewTransaction(bot, from, to, inlineReceive.Amount, TransactionType("inline receive"))
+	t := NewTransaction(bot, from, to, inlineReceive.Amount, TransactionType("inline receive"), TransactionContext(ctx))
 	t.Memo = transactionMemo
 	success, err := t.Send()
 	if !success {
@@ -263,6 +264,7 @@ func (bot *TipBot) sendInlineReceiveHandler(ctx intercept.Context) (intercept.Co
 	}
 
 	log.Infof("[💸 inlineReceive] Send from %s to %s (%d sat).", fromUserStr, toUserStr, inlineReceive.Amount)
+	inlineReceive.Fee = t.Fee
 	inlineReceive.Set(inlineReceive, bot.Bunt)
 	ctx.Context, err = bot.finishInlineReceiveHandler(ctx, ctx.Callback())
 	return ctx, err
@@ -337,8 +339,8 @@ func (bot *TipBot) finishInlineReceiveHandler(ctx context.Context, c *tb.Callbac
 
 	bot.tryEditMessage(inlineReceive.Message, inlineReceive.MessageText, &tb.ReplyMarkup{})
 	// notify users
-	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), fromUserStrMd, inlineReceive.Amount))
-	bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "sendSentMessage"), inlineReceive.Amount, toUserStrMd))
+	bot.notify(to, NotificationMention, inlineReceive.Amount, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), fromUserStrMd, inlineReceive.Amount))
+	bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "sendSentMessage"), inlineReceive.Amount, toUserStrMd)+feeStr(from.Telegram.LanguageCode, inlineReceive.Fee))
 	if err != nil {
 		errmsg := fmt.Errorf("[acceptInlineReceiveHandler] Error: Receive message to %s: %s", toUserStr, err)
 		log.Warnln(errmsg)
@@ -225,9 +225,12 @@ func (bot *TipBot) acceptInlineSendHandler(ctx intercept.Context) (intercept.Con
 
 	// todo: user new get username function to get userStrings
 	transactionMemo := fmt.Sprintf("💸 Send from %s to %s.", fromUserStr, toUserStr)
-	t := NewTransaction(bot, fromUser, to, amount, TransactionType("inline send"))
+	t := NewTransaction(bot, fromUser, to, amount, TransactionType("inline send"), TransactionIdempotencyKey(idempotencyKey(ctx)), TransactionContext(ctx))
 	t.Memo = transactionMemo
 	success, err := t.Send()
+	if isDuplicateOperation(err) {
+		return ctx, err
+	}
 	if !success {
 		errMsg := fmt.Sprintf("[sendInline] Transaction failed: %s", err.Error())
 		log.Errorln(errMsg)
@@ -247,8 +250,8 @@ func (bot *TipBot) acceptInlineSendHandler(ctx intercept.Context) (intercept.Con
 	}
 	bot.tryEditMessage(c, inlineSend.Message, &tb.ReplyMarkup{})
 	// notify users
-	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), fromUserStrMd, amount))
-	bot.trySendMessage(fromUser.Telegram, fmt.Sprintf(i18n.Translate(fromUser.Telegram.LanguageCode, "sendSentMessage"), amount, toUserStrMd))
+	bot.notify(to, NotificationMention, amount, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), fromUserStrMd, amount))
+	bot.trySendMessage(fromUser.Telegram, fmt.Sprintf(i18n.Translate(fromUser.Telegram.LanguageCode, "sendSentMessage"), amount, toUserStrMd)+feeStr(fromUser.Telegram.LanguageCode, t.Fee))
 	if err != nil {
 		errmsg := fmt.Errorf("[sendInline] Error: Send message to %s: %s", toUserStr, err)
 		log.Warnln(errmsg)
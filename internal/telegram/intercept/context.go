@@ -2,7 +2,10 @@ package intercept
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/LightningTipBot/LightningTipBot/internal/reporting"
+	"github.com/LightningTipBot/LightningTipBot/internal/telemetry"
 	log "github.com/sirupsen/logrus"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
@@ -22,6 +25,7 @@ type handlerInterceptor struct {
 	before  Chain
 	after   Chain
 	onDefer Chain
+	onError func(ctx Context, err error)
 }
 type Chain []Func
 type Option func(*handlerInterceptor)
@@ -42,6 +46,14 @@ func WithDefer(chain ...Func) Option {
 	}
 }
 
+// WithOnError calls onError when the handler or the after chain fail. Failures of the before
+// chain are not handler errors, they only mean that the update is not for this handler.
+func WithOnError(onError func(ctx Context, err error)) Option {
+	return func(a *handlerInterceptor) {
+		a.onError = onError
+	}
+}
+
 func intercept(h Context, hm Chain) (Context, error) {
 
 	if hm != nil {
@@ -61,14 +73,39 @@ func WithHandler(handler Func, option ...Option) tb.HandlerFunc {
 	for _, opt := range option {
 		opt(hm)
 	}
-	return func(c tb.Context) error {
-		h := Context{TeleContext: TeleContext{Context: c}, Context: context.Background()}
-		h, err := intercept(h, hm.before)
+	return func(c tb.Context) (err error) {
+		// every update is traced, the span ends after the defer interceptors
+		spanCtx, span := telemetry.Tracer().Start(context.Background(), "telegram.update")
+		h := Context{TeleContext: TeleContext{Context: c}, Context: spanCtx}
+		defer func() {
+			if r := recover(); r != nil {
+				reporting.ReportPanic(r, reportFields(h))
+				err = fmt.Errorf("panic: %v", r)
+			} else {
+				reporting.Report(err, reportFields(h))
+			}
+			if err != nil {
+				span.RecordError(err)
+			}
+			span.End()
+		}()
+		h, err = intercept(h, hm.before)
 		if err != nil {
 			log.Traceln(err)
 			return err
 		}
 		defer intercept(h, hm.onDefer)
+		if hm.onError != nil {
+			defer func() {
+				if r := recover(); r != nil {
+					hm.onError(h, fmt.Errorf("panic: %v", r))
+					panic(r)
+				}
+				if err != nil {
+					hm.onError(h, err)
+				}
+			}()
+		}
 		h, err = hm.handler(h)
 		if err != nil {
 			log.Traceln(err)
@@ -82,3 +119,19 @@ func WithHandler(handler Func, option ...Option) tb.HandlerFunc {
 		return nil
 	}
 }
+
+// reportFields returns the ids of the update that the handler logger carries.
+// Only these fields are reported, never message texts, invoices or keys.
+func reportFields(h Context) map[string]string {
+	fields := map[string]string{}
+	entry, ok := h.Value("logger").(*log.Entry)
+	if !ok {
+		return fields
+	}
+	for _, key := range []string{"correlation_id", "user_id", "chat_id", "command"} {
+		if value, ok := entry.Data[key]; ok {
+			fields[key] = fmt.Sprint(value)
+		}
+	}
+	return fields
+}
@@ -15,7 +15,6 @@ import (
 
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
-	log "github.com/sirupsen/logrus"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
 
@@ -55,7 +54,7 @@ func (bot TipBot) unlockInterceptor(ctx intercept.Context) (intercept.Context, e
 }
 func (bot TipBot) idInterceptor(ctx intercept.Context) (intercept.Context, error) {
 	ctx.Context = context.WithValue(ctx, "uid", RandStringRunes(64))
-	return ctx, nil
+	return withLogger(ctx), nil
 }
 
 // answerCallbackInterceptor will answer the callback with the given text in the context
@@ -100,6 +99,15 @@ func (bot TipBot) requireUserInterceptor(ctx intercept.Context) (intercept.Conte
 	return ctx, errors.Create(errors.InvalidTypeError)
 }
 
+// walletBackendInterceptor stops commands that need the wallet while the wallet backend is down.
+func (bot TipBot) walletBackendInterceptor(ctx intercept.Context) (intercept.Context, error) {
+	if bot.walletBackendAvailable() {
+		return ctx, nil
+	}
+	bot.trySendMessage(ctx.Sender(), Translate(ctx, "walletUnavailableMessage"))
+	return ctx, lnbits.ErrBackendUnavailable
+}
+
 // startUserInterceptor will invoke /start if user not exists.
 func (bot TipBot) startUserInterceptor(ctx intercept.Context) (intercept.Context, error) {
 	handler, err := bot.loadUserInterceptor(ctx)
@@ -157,29 +165,36 @@ func (bot TipBot) localizerInterceptor(ctx intercept.Context) (intercept.Context
 	var userLocalizer *i18n2.Localizer
 	var publicLocalizer *i18n2.Localizer
 
-	// default language is english
-	publicLocalizer = i18n2.NewLocalizer(i18n.Bundle, "en")
-	ctx.Context = context.WithValue(ctx, "publicLanguageCode", "en")
+	// default language is english, unless the group has set its own language
+	publicLanguageCode := "en"
+	if groupLanguageCode := bot.getGroupLanguageCode(ctx.Chat()); len(groupLanguageCode) > 0 {
+		publicLanguageCode = groupLanguageCode
+	}
+	publicLocalizer = i18n2.NewLocalizer(i18n.CurrentBundle(), publicLanguageCode)
+	ctx.Context = context.WithValue(ctx, "publicLanguageCode", publicLanguageCode)
 	ctx.Context = context.WithValue(ctx, "publicLocalizer", publicLocalizer)
 
 	if ctx.Message() != nil {
-		userLocalizer = i18n2.NewLocalizer(i18n.Bundle, ctx.Message().Sender.LanguageCode)
-		ctx.Context = context.WithValue(ctx, "userLanguageCode", ctx.Message().Sender.LanguageCode)
+		languageCode := bot.getUserLanguageCode(ctx.Message().Sender)
+		userLocalizer = i18n2.NewLocalizer(i18n.CurrentBundle(), languageCode)
+		ctx.Context = context.WithValue(ctx, "userLanguageCode", languageCode)
 		ctx.Context = context.WithValue(ctx, "userLocalizer", userLocalizer)
 		if ctx.Message().Private() {
 			// in pm overwrite public localizer with user localizer
-			ctx.Context = context.WithValue(ctx, "publicLanguageCode", ctx.Message().Sender.LanguageCode)
+			ctx.Context = context.WithValue(ctx, "publicLanguageCode", languageCode)
 			ctx.Context = context.WithValue(ctx, "publicLocalizer", userLocalizer)
 		}
 		return ctx, nil
 	} else if ctx.Callback() != nil {
-		userLocalizer = i18n2.NewLocalizer(i18n.Bundle, ctx.Callback().Sender.LanguageCode)
-		ctx.Context = context.WithValue(ctx, "userLanguageCode", ctx.Callback().Sender.LanguageCode)
+		languageCode := bot.getUserLanguageCode(ctx.Callback().Sender)
+		userLocalizer = i18n2.NewLocalizer(i18n.CurrentBundle(), languageCode)
+		ctx.Context = context.WithValue(ctx, "userLanguageCode", languageCode)
 		ctx.Context = context.WithValue(ctx, "userLocalizer", userLocalizer)
 		return ctx, nil
 	} else if ctx.Query() != nil {
-		userLocalizer = i18n2.NewLocalizer(i18n.Bundle, ctx.Query().Sender.LanguageCode)
-		ctx.Context = context.WithValue(ctx, "userLanguageCode", ctx.Query().Sender.LanguageCode)
+		languageCode := bot.getUserLanguageCode(ctx.Query().Sender)
+		userLocalizer = i18n2.NewLocalizer(i18n.CurrentBundle(), languageCode)
+		ctx.Context = context.WithValue(ctx, "userLanguageCode", languageCode)
 		ctx.Context = context.WithValue(ctx, "userLocalizer", userLocalizer)
 		return ctx, nil
 	}
@@ -197,6 +212,18 @@ func (bot TipBot) requirePrivateChatInterceptor(ctx intercept.Context) (intercep
 	return ctx, errors.Create(errors.InvalidTypeError)
 }
 
+// threadInterceptor records the sender of a group message as a participant of its thread
+// and as a recent member of the group, and closes the undo of the tips it replies to.
+// It never stops the handler chain.
+func (bot TipBot) threadInterceptor(ctx intercept.Context) (intercept.Context, error) {
+	if ctx.Message() != nil && ctx.Message().Chat.Type != tb.ChatPrivate {
+		bot.recordThreadPost(ctx.Message())
+		bot.recordRecentMember(ctx.Message())
+		bot.closeRepliedTipUndos(ctx.Message())
+	}
+	return ctx, nil
+}
+
 const photoTag = "<Photo>"
 
 func (bot TipBot) logMessageInterceptor(ctx intercept.Context) (intercept.Context, error) {
@@ -207,13 +234,13 @@ func (bot TipBot) logMessageInterceptor(ctx intercept.Context) (intercept.Contex
 			if ctx.Message().IsReply() {
 				log_string = fmt.Sprintf("%s -> %s", log_string, GetUserStr(ctx.Message().ReplyTo.Sender))
 			}
-			log.Infof(log_string)
+			logger(ctx).Info(log_string)
 		} else if ctx.Message().Photo != nil {
-			log.Infof("[%s:%d %s:%d] %s", ctx.Message().Chat.Title, ctx.Message().Chat.ID, GetUserStr(ctx.Message().Sender), ctx.Message().Sender.ID, photoTag)
+			logger(ctx).Infof("[%s:%d %s:%d] %s", ctx.Message().Chat.Title, ctx.Message().Chat.ID, GetUserStr(ctx.Message().Sender), ctx.Message().Sender.ID, photoTag)
 		}
 		return ctx, nil
 	} else if ctx.Callback() != nil {
-		log.Infof("[Callback %s:%d] Data: %s", GetUserStr(ctx.Callback().Sender), ctx.Callback().Sender.ID, ctx.Callback().Data)
+		logger(ctx).Infof("[Callback %s:%d] Data: %s", GetUserStr(ctx.Callback().Sender), ctx.Callback().Sender.ID, ctx.Callback().Data)
 		return ctx, nil
 
 	}
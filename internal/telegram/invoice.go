@@ -36,13 +36,19 @@ var InvoiceCallback InvoiceEventCallback
 
 func initInvoiceEventCallbacks(bot *TipBot) {
 	InvoiceCallback = InvoiceEventCallback{
-		InvoiceCallbackGeneric:         EventHandler{Function: bot.notifyInvoiceReceivedEvent, Type: EventTypeInvoice},
-		InvoiceCallbackInlineReceive:   EventHandler{Function: bot.inlineReceiveEvent, Type: EventTypeInvoice},
-		InvoiceCallbackLNURLPayReceive: EventHandler{Function: bot.lnurlReceiveEvent, Type: EventTypeInvoice},
-		InvoiceCallbackGroupTicket:     EventHandler{Function: bot.groupGetInviteLinkHandler, Type: EventTypeInvoice},
-		InvoiceCallbackSatdressProxy:   EventHandler{Function: bot.satdressProxyRelayPaymentHandler, Type: EventTypeInvoice},
-		InvoiceCallbackGenerateDalle:   EventHandler{Function: bot.generateDalleImages, Type: EventTypeInvoice},
-		InvoiceCallbackPayJoinTicket:   EventHandler{Function: bot.stopJoinTicketTimer, Type: EventTypeInvoice},
+		InvoiceCallbackGeneric:          EventHandler{Function: bot.notifyInvoiceReceivedEvent, Type: EventTypeInvoice},
+		InvoiceCallbackInlineReceive:    EventHandler{Function: bot.inlineReceiveEvent, Type: EventTypeInvoice},
+		InvoiceCallbackLNURLPayReceive:  EventHandler{Function: bot.lnurlReceiveEvent, Type: EventTypeInvoice},
+		InvoiceCallbackGroupTicket:      EventHandler{Function: bot.groupGetInviteLinkHandler, Type: EventTypeInvoice},
+		InvoiceCallbackSatdressProxy:    EventHandler{Function: bot.satdressProxyRelayPaymentHandler, Type: EventTypeInvoice},
+		InvoiceCallbackGenerateDalle:    EventHandler{Function: bot.generateDalleImages, Type: EventTypeInvoice},
+		InvoiceCallbackPayJoinTicket:    EventHandler{Function: bot.stopJoinTicketTimer, Type: EventTypeInvoice},
+		InvoiceCallbackPoolContribution: EventHandler{Function: bot.poolContributionEvent, Type: EventTypeInvoice},
+		InvoiceCallbackPosSale:          EventHandler{Function: bot.posSaleEvent, Type: EventTypeInvoice},
+		InvoiceCallbackProductSale:      EventHandler{Function: bot.productSaleEvent, Type: EventTypeInvoice},
+		InvoiceCallbackSubscription:     EventHandler{Function: bot.subscriptionPaidEvent, Type: EventTypeInvoice},
+		InvoiceCallbackPaidMessage:      EventHandler{Function: bot.paidMessageEvent, Type: EventTypeInvoice},
+		InvoiceCallbackPaidMedia:        EventHandler{Function: bot.paidMediaEvent, Type: EventTypeInvoice},
 	}
 }
 
@@ -56,6 +62,12 @@ const (
 	InvoiceCallbackSatdressProxy
 	InvoiceCallbackGenerateDalle
 	InvoiceCallbackPayJoinTicket
+	InvoiceCallbackPoolContribution
+	InvoiceCallbackPosSale
+	InvoiceCallbackProductSale
+	InvoiceCallbackSubscription
+	InvoiceCallbackPaidMessage
+	InvoiceCallbackPaidMedia
 )
 
 const (
@@ -90,6 +102,8 @@ type InvoiceEvent struct {
 	Chat           *tb.Chat     `json:"chat,omitempty"`            // if invoice is supposed to be sent to a particular chat
 	Payer          *lnbits.User `json:"payer,omitempty"`           // if a particular user is supposed to pay this
 	UserCurrency   string       `json:"usercurrency,omitempty"`    // the currency a user selected
+	Open           bool         `json:"open,omitempty"`            // waiting for the payment, checked by the reconciler
+	Settled        bool         `json:"settled,omitempty"`         // the payment was handled
 }
 
 func (invoiceEvent InvoiceEvent) Type() EventType {
@@ -128,9 +142,13 @@ func (bot *TipBot) invoiceHandler(ctx intercept.Context) (intercept.Context, err
 		bot.tryDeleteMessage(m)
 		return ctx, errors.Create(errors.NoPrivateChatError)
 	}
+	// /invoice without an amount or /invoice any [<memo>] creates an invoice that the payer fills in
+	anyAmount := isAnyAmountInvoiceCommand(m.Text)
 	// if no amount is in the command, ask for it
 	amount, err := decodeAmountFromCommand(m.Text)
-	if (err != nil || amount < 1) && m.Chat.Type == tb.ChatPrivate {
+	if anyAmount {
+		amount = 0
+	} else if (err != nil || amount < 1) && m.Chat.Type == tb.ChatPrivate {
 		// // no amount was entered, set user state and ask fo""r amount
 		_, err = bot.askForAmount(ctx, "", "CreateInvoiceState", 0, 0, m.Text)
 		return ctx, err
@@ -139,7 +157,8 @@ func (bot *TipBot) invoiceHandler(ctx intercept.Context) (intercept.Context, err
 	// check for memo in command
 	memo := "Powered by @LightningTipBot"
 	if len(strings.Split(m.Text, " ")) > 2 {
-		memo = GetMemoFromCommand(m.Text, 2)
+		// memos can be quoted: /invoice 5000 "coffee fund"
+		memo = strings.Trim(GetMemoFromCommand(m.Text, 2), "\"“”")
 		tag := " (@LightningTipBot)"
 		memoMaxLen := 159 - len(tag)
 		if len(memo) > memoMaxLen {
@@ -156,7 +175,9 @@ func (bot *TipBot) invoiceHandler(ctx intercept.Context) (intercept.Context, err
 		currency = "BTC"
 	}
 
-	invoice, err := bot.createInvoiceWithEvent(ctx, user, amount, memo, currency, InvoiceCallbackGeneric, "")
+	// the payment link token is stored in the callback data so that the link can be deactivated when paid
+	token := newPaymentLinkToken()
+	invoice, err := bot.createInvoiceWithEvent(ctx, user, amount, memo, currency, InvoiceCallbackGeneric, token)
 	if err != nil {
 		errmsg := fmt.Sprintf("[/invoice] Could not create an invoice: %s", err.Error())
 		bot.tryEditMessage(creatingMsg, Translate(ctx, "errorTryLaterMessage"))
@@ -176,20 +197,40 @@ func (bot *TipBot) invoiceHandler(ctx intercept.Context) (intercept.Context, err
 	// deleting messages will delete the main menu.
 	//bot.tryDeleteMessage(creatingMsg)
 
+	var caption string
+	if anyAmount {
+		// the bot can't pay invoices without an amount, so there is no deep link
+		caption = fmt.Sprintf("`%s`\n\n%s", invoice.PaymentRequest, Translate(ctx, "invoiceAnyAmountMessage"))
+	} else {
+		// create a deep link that opens the payment dialog for other users
+		link := bot.createPaymentLink(token, PaymentLinkTypeInvoice, user, PaymentLinkPaymentRequest(invoice.PaymentRequest))
+		caption = fmt.Sprintf("`%s`\n\n%s", invoice.PaymentRequest, fmt.Sprintf(Translate(ctx, "invoiceShareLinkMessage"), link.DeepLink(bot)))
+	}
+
 	// send the invoice data to user
-	bot.trySendMessage(m.Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: fmt.Sprintf("`%s`", invoice.PaymentRequest)})
-	log.Printf("[/invoice] Invoice created. User: %s, amount: %d sat.", userStr, amount)
+	invoice.InvoiceMessage = bot.trySendMessage(m.Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: caption})
+	// save the message so that it can be edited when the invoice is paid
+	runtime.IgnoreError(bot.Bunt.Set(invoice))
+	logger(ctx).WithField("payment_hash", invoice.PaymentHash).Infof("[/invoice] Invoice created. User: %s, amount: %d sat.", userStr, amount)
 	return ctx, nil
 }
 
+// isAnyAmountInvoiceCommand returns true for /invoice and /invoice any [<memo>]
+func isAnyAmountInvoiceCommand(text string) bool {
+	splits := strings.Fields(text)
+	if len(splits) == 0 || !strings.HasPrefix(splits[0], "/invoice") {
+		return false
+	}
+	return len(splits) == 1 || strings.ToLower(splits[1]) == "any"
+}
+
 func (bot *TipBot) createInvoiceWithEvent(ctx context.Context, user *lnbits.User, amount int64, memo string, currency string, callback int, callbackData string) (InvoiceEvent, error) {
-	invoice, err := user.Wallet.Invoice(
+	invoice, err := bot.client(ctx).CreateInvoice(*user.Wallet,
 		lnbits.InvoiceParams{
 			Out:     false,
 			Amount:  int64(amount),
 			Memo:    memo,
-			Webhook: internal.Configuration.Lnbits.WebhookServer},
-		bot.Client)
+			Webhook: internal.Configuration.Lnbits.WebhookServer})
 	if err != nil {
 		errmsg := fmt.Sprintf("[/invoice] Could not create an invoice: %s", err.Error())
 		log.Errorln(errmsg)
@@ -205,6 +246,7 @@ func (bot *TipBot) createInvoiceWithEvent(ctx context.Context, user *lnbits.User
 		CallbackData: callbackData,
 		LanguageCode: ctx.Value("publicLanguageCode").(string),
 		UserCurrency: currency,
+		Open:         true,
 	}
 	// save invoice struct for later use
 	runtime.IgnoreError(bot.Bunt.Set(invoiceEvent))
@@ -212,7 +254,22 @@ func (bot *TipBot) createInvoiceWithEvent(ctx context.Context, user *lnbits.User
 }
 
 func (bot *TipBot) notifyInvoiceReceivedEvent(event Event) {
-	invoiceEvent := event.(*InvoiceEvent)
+	bot.notifyInvoiceReceived(event.(*InvoiceEvent), "")
+}
+
+// notifyInvoiceReceived tells the user that an invoice was paid. appendix is added
+// to the notification, for example the comment of an LNURL payment.
+func (bot *TipBot) notifyInvoiceReceived(invoiceEvent *InvoiceEvent, appendix string) {
+	// edit the invoice message and deactivate its payment link
+	if invoiceEvent.InvoiceMessage != nil {
+		_, err := bot.Telegram.EditCaption(invoiceEvent.InvoiceMessage, i18n.Translate(invoiceEvent.LanguageCode, "invoicePaidText"))
+		if err != nil {
+			log.Warnln(err.Error())
+		}
+	}
+	if invoiceEvent.Callback == InvoiceCallbackGeneric && len(invoiceEvent.CallbackData) > 0 {
+		bot.inactivatePaymentLink(invoiceEvent.CallbackData)
+	}
 	// do balance check for keyboard update
 	_, err := bot.GetUserBalance(invoiceEvent.User)
 	if err != nil {
@@ -220,18 +277,17 @@ func (bot *TipBot) notifyInvoiceReceivedEvent(event Event) {
 		log.Errorln(errmsg)
 	}
 
-	if invoiceEvent.UserCurrency == "" || strings.ToLower(invoiceEvent.UserCurrency) == "btc" {
-		bot.trySendMessage(invoiceEvent.User.Telegram, fmt.Sprintf(i18n.Translate(invoiceEvent.User.Telegram.LanguageCode, "invoiceReceivedMessage"), invoiceEvent.Amount))
-	} else {
+	message := fmt.Sprintf(i18n.Translate(invoiceEvent.User.Telegram.LanguageCode, "invoiceReceivedMessage"), invoiceEvent.Amount)
+	if invoiceEvent.UserCurrency != "" && strings.ToLower(invoiceEvent.UserCurrency) != "btc" {
 		fiatAmount, err := SatoshisToFiat(invoiceEvent.Amount, strings.ToUpper(invoiceEvent.UserCurrency))
 		if err != nil {
-			log.Errorln(err)
 			// fallback to satoshis
-			bot.trySendMessage(invoiceEvent.User.Telegram, fmt.Sprintf(i18n.Translate(invoiceEvent.User.Telegram.LanguageCode, "invoiceReceivedMessage"), invoiceEvent.Amount))
-			return
+			log.Errorln(err)
+		} else {
+			message = fmt.Sprintf(i18n.Translate(invoiceEvent.User.Telegram.LanguageCode, "invoiceReceivedCurrencyMessage"), invoiceEvent.Amount, fiatAmount, strings.ToUpper(invoiceEvent.UserCurrency))
 		}
-		bot.trySendMessage(invoiceEvent.User.Telegram, fmt.Sprintf(i18n.Translate(invoiceEvent.User.Telegram.LanguageCode, "invoiceReceivedCurrencyMessage"), invoiceEvent.Amount, fiatAmount, strings.ToUpper(invoiceEvent.UserCurrency)))
 	}
+	bot.trySendMessage(invoiceEvent.User.Telegram, message+appendix, tb.NoPreview)
 }
 
 type LNURLInvoice struct {
@@ -242,6 +298,7 @@ type LNURLInvoice struct {
 	Paid               bool         `json:"paid"`
 	PaidAt             time.Time    `json:"paid_at"`
 	From               string       `json:"from"`
+	FromName           string       `json:"from_name"`
 	Nip57Receipt       nostr.Event  `json:"nip57_receipt"`
 	Nip57ReceiptRelays []string     `json:"nip57_receipt_relays"`
 }
@@ -252,40 +309,42 @@ func (lnurlInvoice LNURLInvoice) Key() string {
 
 func (bot *TipBot) lnurlReceiveEvent(event Event) {
 	invoiceEvent := event.(*InvoiceEvent)
-	bot.notifyInvoiceReceivedEvent(invoiceEvent)
 
 	tx := &LNURLInvoice{Invoice: &Invoice{PaymentHash: invoiceEvent.PaymentHash}}
 	err := bot.Bunt.Get(tx)
+	if err != nil {
+		bot.notifyInvoiceReceived(invoiceEvent, "")
+		return
+	}
 	log.Debugf("[lnurl-p] Received invoice for %s of %d sat.", GetUserStr(invoiceEvent.User.Telegram), tx.Amount)
-	if err == nil {
-		// filter: if tx.Comment includes a URL, return if tx.Amount is less than 100 sat
-		if len(tx.Comment) > 0 && tx.Amount < 100 {
-			if strings.Contains(tx.Comment, "http") {
-				log.Debugf("[lnurl-p] Filtered LNURL comment for %s of %d sat.", GetUserStr(invoiceEvent.User.Telegram), tx.Amount)
-				return
-			}
-		}
+	if len(tx.From) > 0 {
+		bot.saveCounterparty(invoiceEvent.PaymentHash, invoiceEvent.User.Wallet.ID, CounterpartyTypeLightningAddress, tx.From)
+	}
+	comment := sanitizeComment(tx.Comment)
+	// filter: if tx.Comment includes a URL, drop it if tx.Amount is less than 100 sat
+	if len(comment) > 0 && (tx.Amount < 21 || tx.Amount < 100 && strings.Contains(comment, "http")) {
+		log.Debugf("[lnurl-p] Filtered LNURL comment for %s of %d sat.", GetUserStr(invoiceEvent.User.Telegram), tx.Amount)
+		comment = ""
+	}
 
-		if tx.Amount < 21 {
-			log.Debugf("[lnurl-p] Filtered LNURL comment for %s of %d sat.", GetUserStr(invoiceEvent.User.Telegram), tx.Amount)
-			return
-		}
+	// notify user with LNURL comment and sender Information
+	appendix := ""
+	if len(tx.FromName) > 0 && tx.FromName != tx.From {
+		// LUD-18 payer data with a name and an identifier
+		appendix += fmt.Sprintf("\nFrom *%s* (`%s`)", str.MarkdownEscape(sanitizeComment(tx.FromName)), str.MarkdownEscape(tx.From))
+	} else if len(tx.From) > 0 {
+		appendix += fmt.Sprintf("\nFrom `%s`", str.MarkdownEscape(tx.From))
+	}
+	if len(comment) > 0 {
+		appendix += fmt.Sprintf("\n✉️ %s", str.MarkdownEscape(comment))
+		bot.savePaymentComment(invoiceEvent.PaymentHash, invoiceEvent.User.Wallet.ID, comment)
+	}
+	bot.notifyInvoiceReceived(invoiceEvent, appendix)
 
-		// notify user with LNURL comment and sender Information
-		if len(tx.Comment) > 0 {
-			if len(tx.From) == 0 {
-				bot.trySendMessage(tx.User.Telegram, fmt.Sprintf("✉️ %s", str.MarkdownEscape(tx.Comment)), tb.NoPreview)
-			} else {
-				bot.trySendMessage(tx.User.Telegram, fmt.Sprintf("✉️ From `%s`: %s", tx.From, str.MarkdownEscape(tx.Comment)), tb.NoPreview)
-			}
-		} else if len(tx.From) > 0 {
-			bot.trySendMessage(tx.User.Telegram, fmt.Sprintf("From `%s`", str.MarkdownEscape(tx.From)), tb.NoPreview)
-		}
-		// send out NIP57 zap receipt
-		if len(tx.Nip57Receipt.Sig) > 0 {
-			// zapEventSerialized, _ := json.Marshal(tx.Nip57Receipt)
-			bot.trySendMessage(tx.User.Telegram, "💜 This was a zap on nostr.")
-			go bot.publishNostrEvent(tx.Nip57Receipt, tx.Nip57ReceiptRelays)
-		}
+	// send out NIP57 zap receipt
+	if len(tx.Nip57Receipt.Sig) > 0 {
+		// zapEventSerialized, _ := json.Marshal(tx.Nip57Receipt)
+		bot.trySendMessage(tx.User.Telegram, "💜 This was a zap on nostr.")
+		go bot.publishNostrEvent(tx.Nip57Receipt, tx.Nip57ReceiptRelays)
 	}
 }
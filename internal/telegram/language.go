@@ -0,0 +1,95 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/eko/gocache/store"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	languageMenu      = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnSelectLanguage = languageMenu.Data("", "select_language")
+)
+
+func languageCacheKey(u *tb.User) string {
+	return fmt.Sprintf("%d_language", u.ID)
+}
+
+// getUserLanguageCode returns the language the user has picked with /language.
+// If the user has not set a language, the language of the Telegram client is used.
+func (bot TipBot) getUserLanguageCode(u *tb.User) string {
+	if u == nil {
+		return "en"
+	}
+	if bot.Cache.StoreInterface != nil {
+		if l, err := bot.Cache.Get(languageCacheKey(u)); err == nil {
+			if languageCode := l.(string); len(languageCode) > 0 {
+				return languageCode
+			}
+			return u.LanguageCode
+		}
+	}
+	languageCode := ""
+	if bot.DB != nil {
+		user := &lnbits.User{Name: strconv.FormatInt(u.ID, 10)}
+		tx := bot.DB.Users.Preload("Settings").First(user)
+		if tx.Error == nil && user.Settings != nil && i18n.IsSupported(user.Settings.Display.Language) {
+			languageCode = user.Settings.Display.Language
+		}
+	}
+	if bot.Cache.StoreInterface != nil {
+		bot.Cache.Set(languageCacheKey(u), languageCode, &store.Options{Expiration: 1 * time.Hour})
+	}
+	if len(languageCode) > 0 {
+		return languageCode
+	}
+	return u.LanguageCode
+}
+
+func (bot *TipBot) makeLanguageKeyboard() *tb.ReplyMarkup {
+	buttons := []tb.Btn{}
+	for _, l := range i18n.LoadedLanguages() {
+		buttons = append(buttons, languageMenu.Data(i18n.LanguageName(l), btnSelectLanguage.Unique, l))
+	}
+	languageMenu.Inline(buttonWrapper(buttons, languageMenu, 2)...)
+	return languageMenu
+}
+
+// languageHandler shows an inline keyboard with all loaded languages.
+func (bot *TipBot) languageHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	languageCode := ctx.Value("userLanguageCode").(string)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(TranslateUser(ctx, "languageSelectMessage"), i18n.LanguageName(languageCode)), bot.makeLanguageKeyboard())
+	return ctx, nil
+}
+
+// selectLanguageHandler is invoked when the user picks a language from the keyboard.
+func (bot *TipBot) selectLanguageHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	languageCode := c.Data
+	if !i18n.IsSupported(languageCode) {
+		bot.tryEditMessage(c.Message, TranslateUser(ctx, "languageInvalidMessage"))
+		return ctx, fmt.Errorf("language %s not supported", languageCode)
+	}
+	user, err := GetLnbitsUserWithSettings(c.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	user.Settings.Display.Language = languageCode
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[selectLanguageHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	bot.Cache.Set(languageCacheKey(c.Sender), languageCode, &store.Options{Expiration: 1 * time.Hour})
+	// the new language is used right away
+	bot.tryEditMessage(c.Message, fmt.Sprintf(i18n.Translate(languageCode, "languageChangedMessage"), i18n.LanguageName(languageCode)))
+	return ctx, nil
+}
@@ -3,6 +3,7 @@ package telegram
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
@@ -16,6 +17,9 @@ import (
 
 func (bot *TipBot) lndhubHandler(ctx intercept.Context) (intercept.Context, error) {
 	m := ctx.Message()
+	if splits := strings.Fields(m.Text); len(splits) > 1 && strings.ToLower(splits[1]) == "revoke" {
+		return bot.lndhubRevokeHandler(ctx)
+	}
 	if internal.Configuration.Lnbits.LnbitsPublicUrl == "" {
 		bot.trySendMessage(m.Sender, Translate(ctx, "couldNotLinkMessage"))
 		return ctx, fmt.Errorf("invalid configuration")
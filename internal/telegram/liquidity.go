@@ -0,0 +1,181 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// defaultLiquidityCheckInterval is the time between two checks of the liquidity. Every check
+// asks the backend for the balances of all wallets.
+const defaultLiquidityCheckInterval = time.Hour
+
+const (
+	liquidityProblemReserve  = "reserve"
+	liquidityProblemOutbound = "outbound"
+	liquidityProblemInbound  = "inbound"
+)
+
+var (
+	adminLiquidityMessage          = "*Liquidity*\n🏦 Reserve: %s\n👛 Balances of users and the operator: %d sat\n📡 Channels: %s"
+	adminChannelsMessage           = "%d active, %d sat outbound, %d sat inbound"
+	adminLiquidityLowMessage       = "🚨 *Liquidity is running low*\n%s"
+	adminLiquidityRecoveredMessage = "✅ *Liquidity recovered*"
+	liquidityReserveMessage        = "🏦 The reserve of %d sat covers %.2fx of the balances of %d sat, the minimum is %.2fx."
+	liquidityOutboundMessage       = "📤 The node can send %d sat, the minimum is %d sat."
+	liquidityInboundMessage        = "📥 The node can receive %d sat, the minimum is %d sat."
+)
+
+// LiquidityStatus is the solvency of the bot and the capacity of the channels of its node.
+type LiquidityStatus struct {
+	Solvency       *Solvency
+	Liquidity      lnbits.Liquidity // msat, only set if LiquidityKnown
+	LiquidityKnown bool
+}
+
+// LiquidityProblem is a limit of the liquidity configuration that the status is below.
+type LiquidityProblem struct {
+	Kind    string
+	Message string
+}
+
+// LiquidityStatus asks the backend for its reserve and the channels of its node.
+func (bot *TipBot) LiquidityStatus() (*LiquidityStatus, error) {
+	solvency, err := bot.Solvency()
+	if err != nil {
+		return nil, err
+	}
+	s := &LiquidityStatus{Solvency: solvency}
+	if reporter, ok := lnbits.Unwrap(bot.Client).(lnbits.LiquidityReporter); ok {
+		liquidity, err := reporter.Liquidity()
+		if err != nil {
+			log.Errorf("[LiquidityStatus] could not get the liquidity: %v", err)
+		} else {
+			s.Liquidity, s.LiquidityKnown = liquidity, true
+		}
+	}
+	return s, nil
+}
+
+// Problems returns the limits of config that the status is below. Limits that the backend
+// can't report on are not checked.
+func (s LiquidityStatus) Problems(config internal.LiquidityConfiguration) []LiquidityProblem {
+	var problems []LiquidityProblem
+	balances := s.Solvency.Liabilities + s.Solvency.Operator
+	if config.MinReserveRatio > 0 && s.Solvency.ReserveKnown && balances > 0 {
+		ratio := float64(s.Solvency.Reserve) / float64(balances)
+		if ratio < config.MinReserveRatio {
+			problems = append(problems, LiquidityProblem{liquidityProblemReserve,
+				fmt.Sprintf(liquidityReserveMessage, s.Solvency.Reserve, ratio, balances, config.MinReserveRatio)})
+		}
+	}
+	if s.LiquidityKnown {
+		if outbound := s.Liquidity.Outbound / 1000; config.MinOutbound > 0 && outbound < config.MinOutbound {
+			problems = append(problems, LiquidityProblem{liquidityProblemOutbound,
+				fmt.Sprintf(liquidityOutboundMessage, outbound, config.MinOutbound)})
+		}
+		if inbound := s.Liquidity.Inbound / 1000; config.MinInbound > 0 && inbound < config.MinInbound {
+			problems = append(problems, LiquidityProblem{liquidityProblemInbound,
+				fmt.Sprintf(liquidityInboundMessage, inbound, config.MinInbound)})
+		}
+	}
+	return problems
+}
+
+func (s LiquidityStatus) String() string {
+	reserve := "unknown"
+	if s.Solvency.ReserveKnown {
+		reserve = fmt.Sprintf("%d sat", s.Solvency.Reserve)
+	}
+	channels := "unknown"
+	if s.LiquidityKnown {
+		channels = fmt.Sprintf(adminChannelsMessage, s.Liquidity.Channels, s.Liquidity.Outbound/1000, s.Liquidity.Inbound/1000)
+	}
+	return fmt.Sprintf(adminLiquidityMessage, reserve, s.Solvency.Liabilities+s.Solvency.Operator, channels)
+}
+
+// alertAdminChat sends a message to the admin chat of the liquidity configuration or, if
+// there is none, to the operator.
+func (bot *TipBot) alertAdminChat(message string) {
+	if chatId := internal.Configuration.Liquidity.AdminChatId; chatId != 0 {
+		bot.trySendMessage(&tb.Chat{ID: chatId}, message)
+		return
+	}
+	bot.alertOperator(message)
+}
+
+// checkLiquidity alerts the admin chat if the liquidity fell below a limit that it was not
+// below at the last check, alerted are the kinds of problems of the last check. Once all
+// problems are gone, it tells the admin chat that the liquidity recovered.
+func (bot *TipBot) checkLiquidity(alerted map[string]bool) map[string]bool {
+	status, err := bot.LiquidityStatus()
+	if err != nil {
+		log.Errorf("[checkLiquidity] %v", err)
+		return alerted
+	}
+	problems := status.Problems(internal.Configuration.Liquidity)
+	current := make(map[string]bool, len(problems))
+	isNew := false
+	var messages []string
+	for _, problem := range problems {
+		current[problem.Kind] = true
+		isNew = isNew || !alerted[problem.Kind]
+		messages = append(messages, problem.Message)
+	}
+	switch {
+	case isNew:
+		log.Warnf("[checkLiquidity] liquidity is running low: %s", strings.Join(messages, " "))
+		bot.alertAdminChat(fmt.Sprintf(adminLiquidityLowMessage, status.String()) + "\n\n" + strings.Join(messages, "\n"))
+	case len(problems) == 0 && len(alerted) > 0:
+		bot.alertAdminChat(adminLiquidityRecoveredMessage + "\n" + status.String())
+	}
+	return current
+}
+
+// startLiquidityWorker periodically checks the liquidity, if the operator set a limit.
+func (bot *TipBot) startLiquidityWorker() {
+	config := internal.Configuration.Liquidity
+	if config.MinReserveRatio <= 0 && config.MinOutbound <= 0 && config.MinInbound <= 0 {
+		return
+	}
+	interval := defaultLiquidityCheckInterval
+	if config.CheckInterval > 0 {
+		interval = time.Duration(config.CheckInterval) * time.Minute
+	}
+	go func() {
+		alerted := map[string]bool{}
+		for {
+			if bot.walletBackendAvailable() && !isShuttingDown() {
+				alerted = bot.checkLiquidity(alerted)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// adminLiquidityHandler sends the current liquidity to the operator.
+func (bot *TipBot) adminLiquidityHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	wait := bot.trySendMessageEditable(m.Sender, adminStatsWaitMessage)
+	status, err := bot.LiquidityStatus()
+	if err != nil {
+		log.Errorf("[adminLiquidityHandler] %v", err)
+		bot.tryEditMessage(wait, fmt.Sprintf("🚫 %v", err))
+		return ctx, err
+	}
+	message := status.String()
+	for i, problem := range status.Problems(internal.Configuration.Liquidity) {
+		if i == 0 {
+			message += "\n"
+		}
+		message += "\n" + problem.Message
+	}
+	bot.tryEditMessage(wait, message)
+	return ctx, nil
+}
@@ -0,0 +1,61 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// fakeNode reports a reserve and channels like a node backend.
+type fakeNode struct {
+	lnbits.WalletBackend
+	reserve   int64
+	liquidity lnbits.Liquidity
+}
+
+func (n *fakeNode) Reserve() (int64, error)              { return n.reserve, nil }
+func (n *fakeNode) Liquidity() (lnbits.Liquidity, error) { return n.liquidity, nil }
+
+func TestLiquidityAlerts(t *testing.T) {
+	h := newTestHarness(t)
+	config := internal.Configuration.Liquidity
+	t.Cleanup(func() { internal.Configuration.Liquidity = config })
+	admins := &tb.Chat{ID: -9990, Type: tb.ChatGroup, Title: "admins"}
+	internal.Configuration.Liquidity = internal.LiquidityConfiguration{AdminChatId: admins.ID, MinReserveRatio: 1, MinInbound: 500}
+	node := &fakeNode{WalletBackend: h.bot.Client, reserve: 2_000_000, liquidity: lnbits.Liquidity{Outbound: 2_000_000, Inbound: 1_000_000, Channels: 2}}
+	h.bot.Client = node
+	h.newUser(&tb.User{ID: 9991, Username: "alice", FirstName: "Alice", LanguageCode: "en"}, 1000)
+
+	alerted := h.bot.checkLiquidity(map[string]bool{})
+	if len(alerted) != 0 || h.called("sendMessage", admins.ID) {
+		t.Fatalf("alert while the liquidity is fine: %v", alerted)
+	}
+
+	// the reserve of 500 sat covers half of the balances
+	node.reserve = 500_000
+	alerted = h.bot.checkLiquidity(alerted)
+	if text := h.lastMessage(admins.ID).Text(); !alerted[liquidityProblemReserve] || !strings.Contains(text, "covers 0.50x") {
+		t.Fatalf("alert = %q", text)
+	}
+	// no second alert for the same problem
+	h.sent = nil
+	alerted = h.bot.checkLiquidity(alerted)
+	if h.called("sendMessage", admins.ID) {
+		t.Errorf("alerted twice")
+	}
+	// a new problem alerts again
+	node.liquidity.Inbound = 100_000
+	alerted = h.bot.checkLiquidity(alerted)
+	if text := h.lastMessage(admins.ID).Text(); !strings.Contains(text, "can receive 100 sat") {
+		t.Errorf("alert = %q", text)
+	}
+
+	node.reserve, node.liquidity.Inbound = 2_000_000, 1_000_000
+	alerted = h.bot.checkLiquidity(alerted)
+	if text := h.lastMessage(admins.ID).Text(); len(alerted) != 0 || !strings.Contains(text, "recovered") {
+		t.Errorf("recovery = %q", text)
+	}
+}
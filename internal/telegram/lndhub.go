@@ -0,0 +1,112 @@
+package telegram
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// lndhubAccessTokenDuration is how long an access token of an LNDHub client is valid.
+// Clients get a new one with their refresh token.
+const lndhubAccessTokenDuration = 24 * time.Hour
+
+var errLndhubBadAuth = fmt.Errorf("invalid or revoked token")
+
+// LndhubSession is a login of an LNDHub client. Its tokens are random and only their
+// hashes are stored. /lndhub revoke ends all sessions of a user.
+type LndhubSession struct {
+	ID            uint      `gorm:"primarykey"`
+	CreatedAt     time.Time `json:"created_at"`
+	UserId        int64     `json:"user_id" gorm:"index"`
+	AccessHash    string    `json:"access_hash" gorm:"uniqueIndex"`
+	RefreshHash   string    `json:"refresh_hash" gorm:"uniqueIndex"`
+	AccessExpires time.Time `json:"access_expires"`
+	Revoked       bool      `json:"revoked"`
+}
+
+func hashLndhubToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// newLndhubTokens sets new tokens on the session and returns them.
+func newLndhubTokens(session *LndhubSession) (access, refresh string, err error) {
+	if access, err = randomHex(32); err != nil {
+		return "", "", err
+	}
+	if refresh, err = randomHex(32); err != nil {
+		return "", "", err
+	}
+	session.AccessHash = hashLndhubToken(access)
+	session.RefreshHash = hashLndhubToken(refresh)
+	session.AccessExpires = time.Now().Add(lndhubAccessTokenDuration)
+	return access, refresh, nil
+}
+
+// CreateLndhubSession logs an LNDHub client in to the wallet of user.
+func (bot *TipBot) CreateLndhubSession(user *lnbits.User) (access, refresh string, err error) {
+	session := &LndhubSession{UserId: user.Telegram.ID}
+	if access, refresh, err = newLndhubTokens(session); err != nil {
+		return "", "", err
+	}
+	if tx := bot.DB.Users.Create(session); tx.Error != nil {
+		return "", "", tx.Error
+	}
+	return access, refresh, nil
+}
+
+// RefreshLndhubSession replaces both tokens of the session of a refresh token.
+func (bot *TipBot) RefreshLndhubSession(refreshToken string) (access, refresh string, err error) {
+	session := &LndhubSession{}
+	if tx := bot.DB.Users.Where("refresh_hash = ? AND revoked = ?", hashLndhubToken(refreshToken), false).First(session); tx.Error != nil {
+		return "", "", errLndhubBadAuth
+	}
+	if _, err := bot.lndhubSessionUser(session); err != nil {
+		return "", "", err
+	}
+	if access, refresh, err = newLndhubTokens(session); err != nil {
+		return "", "", err
+	}
+	if tx := bot.DB.Users.Save(session); tx.Error != nil {
+		return "", "", tx.Error
+	}
+	return access, refresh, nil
+}
+
+// LndhubUser returns the user of an access token.
+func (bot *TipBot) LndhubUser(accessToken string) (*lnbits.User, error) {
+	session := &LndhubSession{}
+	if tx := bot.DB.Users.Where("access_hash = ? AND revoked = ?", hashLndhubToken(accessToken), false).First(session); tx.Error != nil {
+		return nil, errLndhubBadAuth
+	}
+	if time.Now().After(session.AccessExpires) {
+		return nil, errLndhubBadAuth
+	}
+	return bot.lndhubSessionUser(session)
+}
+
+func (bot *TipBot) lndhubSessionUser(session *LndhubSession) (*lnbits.User, error) {
+	user, err := GetLnbitsUserWithSettings(&tb.User{ID: session.UserId}, *bot)
+	if err != nil || user.Wallet == nil || user.Banned {
+		return nil, errLndhubBadAuth
+	}
+	return user, nil
+}
+
+// lndhubRevokeHandler is invoked on /lndhub revoke and logs all LNDHub clients of the user out.
+func (bot *TipBot) lndhubRevokeHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	tx := bot.DB.Users.Model(&LndhubSession{}).Where("user_id = ? AND revoked = ?", user.Telegram.ID, false).Update("revoked", true)
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	log.Infof("[/lndhub] %s revoked %d sessions", GetUserStr(user.Telegram), tx.RowsAffected)
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "lndhubRevokedMessage"), tx.RowsAffected))
+	return ctx, nil
+}
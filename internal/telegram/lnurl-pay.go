@@ -209,6 +209,12 @@ func (bot *TipBot) lnurlPayHandlerSend(ctx intercept.Context) (intercept.Context
 
 	// store success action in context for printing after the payHandler
 	ctx.Context = context.WithValue(ctx, "SuccessAction", lnurlPayState.LNURLPayValues.SuccessAction)
+	// store the receiver for the transaction history
+	counterparty := lnurlPayState.LNURLPayParams.Metadata.LightningAddress
+	if len(counterparty) == 0 {
+		counterparty = callbackUrl.Host
+	}
+	ctx.Context = context.WithValue(ctx, "Counterparty", counterparty)
 
 	m.Text = fmt.Sprintf("/pay %s", response2.PR)
 	return bot.payHandler(ctx)
@@ -252,3 +258,62 @@ func (bot *TipBot) sendToLightningAddress(ctx intercept.Context, address string,
 	}
 	return bot.lnurlHandler(ctx)
 }
+
+// payLightningAddress pays amount sat from the wallet of user to a lightning address
+// without any dialog, for payouts that the bot makes on behalf of a user. It returns
+// the payment hash of the paid invoice.
+func (bot *TipBot) payLightningAddress(user *lnbits.User, address string, amount int64, comment string) (paymentHash string, err error) {
+	return bot.payLightningAddressWithParams(user, address, amount, comment, lnbits.PaymentParams{Out: true})
+}
+
+// payLightningAddressWithParams is payLightningAddress with the parameters of the payment.
+func (bot *TipBot) payLightningAddressWithParams(user *lnbits.User, address string, amount int64, comment string, pay lnbits.PaymentParams) (paymentHash string, err error) {
+	_, params, err := bot.HandleLNURL(address)
+	if err != nil {
+		return "", err
+	}
+	payParams, ok := params.(lnurl.LNURLPayParams)
+	if !ok {
+		return "", fmt.Errorf("%s is not a lightning address", address)
+	}
+	if amount*1000 < payParams.MinSendable || amount*1000 > payParams.MaxSendable {
+		return "", fmt.Errorf("%d sat are not between %d and %d sat", amount, payParams.MinSendable/1000, payParams.MaxSendable/1000)
+	}
+	callbackUrl, err := url.Parse(payParams.Callback)
+	if err != nil {
+		return "", err
+	}
+	client, err := network.GetClientForScheme(callbackUrl)
+	if err != nil {
+		return "", err
+	}
+	qs := callbackUrl.Query()
+	qs.Set("amount", strconv.FormatInt(amount*1000, 10)) // msat
+	if len(comment) > 0 && payParams.CommentAllowed > 0 {
+		if len(comment) > int(payParams.CommentAllowed) {
+			comment = comment[:payParams.CommentAllowed]
+		}
+		qs.Set("comment", comment)
+	}
+	callbackUrl.RawQuery = qs.Encode()
+	res, err := client.Get(callbackUrl.String())
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	var values lnurl.LNURLPayValues
+	json.Unmarshal(body, &values)
+	if values.Status == "ERROR" || len(values.PR) < 1 {
+		return "", fmt.Errorf("error in LNURLPayValues: %s", values.Reason)
+	}
+	pay.Bolt11 = values.PR
+	invoice, err := bot.Client.Pay(*user.Wallet, pay)
+	if err != nil {
+		return "", err
+	}
+	return invoice.PaymentHash, nil
+}
@@ -258,13 +258,12 @@ func (bot *TipBot) confirmWithdrawHandler(ctx intercept.Context) (intercept.Cont
 
 	// generate an invoice and add the pr to the request
 	// generate invoice
-	invoice, err := user.Wallet.Invoice(
+	invoice, err := bot.Client.CreateInvoice(*user.Wallet,
 		lnbits.InvoiceParams{
 			Out:     false,
 			Amount:  int64(lnurlWithdrawState.Amount) / 1000,
 			Memo:    "Withdraw",
-			Webhook: internal.Configuration.Lnbits.WebhookServer},
-		bot.Client)
+			Webhook: internal.Configuration.Lnbits.WebhookServer})
 	if err != nil {
 		errmsg := fmt.Sprintf("[lnurlWithdrawHandlerWithdraw] Could not create an invoice: %s", err.Error())
 		log.Errorln(errmsg)
@@ -0,0 +1,61 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const correlationIdLength = 12
+
+// withLogger stores a log entry in the context that carries the correlation id
+// and the user, chat and command of the update.
+func withLogger(ctx intercept.Context) intercept.Context {
+	correlationId := RandStringRunes(correlationIdLength)
+	fields := log.Fields{"correlation_id": correlationId}
+	if sender := ctx.Sender(); sender != nil {
+		fields["user_id"] = sender.ID
+	}
+	if chat := ctx.Chat(); chat != nil {
+		fields["chat_id"] = chat.ID
+	}
+	if command := commandOf(ctx); len(command) > 0 {
+		fields["command"] = command
+	}
+	// the span of the update is named after the command and can be found by the same fields
+	span := trace.SpanFromContext(ctx)
+	if command, ok := fields["command"].(string); ok {
+		span.SetName(command)
+	}
+	for key, value := range fields {
+		span.SetAttributes(attribute.String(key, fmt.Sprint(value)))
+	}
+	ctx.Context = context.WithValue(ctx, "correlation_id", correlationId)
+	ctx.Context = context.WithValue(ctx, "logger", log.WithFields(fields))
+	return ctx
+}
+
+// commandOf returns the command of a message or the unique name of a button.
+func commandOf(ctx intercept.Context) string {
+	if c := ctx.Callback(); c != nil {
+		return c.Unique
+	}
+	if m := ctx.Message(); m != nil && strings.HasPrefix(m.Text, "/") {
+		return strings.ToLower(strings.Split(strings.Fields(m.Text)[0], "@")[0])
+	}
+	return ""
+}
+
+// logger returns the log entry of the update that ctx handles. All lines that are
+// logged with it can be found by their correlation_id.
+func logger(ctx context.Context) *log.Entry {
+	if entry, ok := ctx.Value("logger").(*log.Entry); ok {
+		return entry
+	}
+	return log.NewEntry(log.StandardLogger())
+}
@@ -0,0 +1,176 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// adminLookupLength is the number of transactions that /admin lookup lists.
+	adminLookupLength = 20
+	// adminLookupErrorsLength is the number of errors of updates that /admin lookup lists.
+	adminLookupErrorsLength = 5
+)
+
+var (
+	adminLookupMessage             = "*%s (%d)*\n👛 Wallet %s: %s\n🕐 Created %s, started the bot: %t, banned: %t\n⏳ Pending: %s"
+	adminLookupPendingMessage      = "%d incoming, %d outgoing"
+	adminLookupTransactionsMessage = "\n\n*Transactions*"
+	adminLookupEntryMessage        = "\n%s `%s` %s %s → %s: %d sat"
+	adminLookupErrorsMessage       = "\n\n*Errors*"
+	adminLookupErrorMessage        = "\n`%s` %s: %s (%s)"
+	adminLookupNotFoundMessage     = "🚫 There is no user %s."
+	adminLookupPaymentMessage      = "*Payment* `%s`"
+	adminLookupWalletMessage       = "\n👛 %s: %s"
+	adminLookupInvoiceMessage      = "\n🧾 Invoice of %s: %s"
+	adminLookupOutgoingMessage     = "\n📤 In flight from %s since %s, %d sat"
+	adminLookupNoPaymentMessage    = "🚫 The bot knows no payment %s."
+)
+
+// adminLookupHandler is invoked on /admin lookup <@user|payment hash>. Support sees the wallet,
+// the transactions and the errors of a user, or everything the bot knows about a payment.
+func (bot *TipBot) adminLookupHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	splits := strings.Fields(m.Text)
+	if len(splits) < 3 {
+		bot.trySendMessage(m.Sender, bot.adminHelp(operatorRole(m.Sender.ID)))
+		return ctx, fmt.Errorf("no user or payment given")
+	}
+	if hash := strings.ToLower(splits[2]); isPaymentHash(hash) {
+		bot.trySendMessage(m.Sender, bot.lookupPayment(hash))
+		return ctx, nil
+	}
+	user, err := GetUserByTelegramUsername(strings.TrimPrefix(splits[2], "@"), *bot)
+	if err != nil || user.Telegram == nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(adminLookupNotFoundMessage, str.MarkdownEscape(splits[2])))
+		return ctx, fmt.Errorf("user %s not found", splits[2])
+	}
+	message, err := bot.lookupUser(user)
+	if err != nil {
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, message)
+	return ctx, nil
+}
+
+// lookupUser describes the wallet, the last transactions and the last errors of the user.
+func (bot *TipBot) lookupUser(user *lnbits.User) (string, error) {
+	wallet, balance, pending := "none", "no wallet", "unknown"
+	if user.Wallet != nil {
+		wallet = user.Wallet.ID
+		if b, err := bot.GetUserBalance(user); err == nil {
+			balance = fmt.Sprintf("%d sat", b)
+		} else {
+			balance = fmt.Sprintf("balance unknown (%v)", err)
+		}
+		if incoming, outgoing, err := bot.getPendingPayments(user); err == nil {
+			pending = fmt.Sprintf(adminLookupPendingMessage, len(incoming), len(outgoing))
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, adminLookupMessage, str.MarkdownEscape(GetUserStr(user.Telegram)), user.Telegram.ID,
+		str.MarkdownEscape(wallet), str.MarkdownEscape(balance), user.CreatedAt.UTC().Format("2006-01-02"), user.Initialized, user.Banned, pending)
+
+	var transactions []Transaction
+	tx := bot.DB.Transactions.Where("from_id = ? OR to_id = ?", user.Telegram.ID, user.Telegram.ID).
+		Order("id desc").Limit(adminLookupLength).Find(&transactions)
+	if tx.Error != nil {
+		return "", tx.Error
+	}
+	if len(transactions) > 0 {
+		b.WriteString(adminLookupTransactionsMessage)
+	}
+	for _, t := range transactions {
+		b.WriteString(transactionLookupEntry(t))
+	}
+
+	errs, err := bot.updateErrors(user.Telegram.ID, adminLookupErrorsLength)
+	if err != nil {
+		return "", err
+	}
+	if len(errs) > 0 {
+		b.WriteString(adminLookupErrorsMessage)
+	}
+	for _, e := range errs {
+		fmt.Fprintf(&b, adminLookupErrorMessage, e.CreatedAt.UTC().Format("2006-01-02 15:04"), str.MarkdownEscape(e.Command),
+			str.MarkdownEscape(e.Error), str.MarkdownEscape(e.CorrelationId))
+	}
+	return b.String(), nil
+}
+
+func transactionLookupEntry(t Transaction) string {
+	status := "✅"
+	if !t.Success {
+		status = "🚫"
+	}
+	return fmt.Sprintf(adminLookupEntryMessage, status, t.Time.UTC().Format("2006-01-02 15:04"), str.MarkdownEscape(t.Type),
+		str.MarkdownEscape(t.FromUser), str.MarkdownEscape(t.ToUser), t.Amount)
+}
+
+// lookupPayment describes what the bot knows about a payment: the transactions that paid it,
+// the wallets that sent or received it, the invoice that the bot created for it and whether
+// it is still in flight.
+func (bot *TipBot) lookupPayment(hash string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, adminLookupPaymentMessage, hash)
+	found := false
+
+	var transactions []Transaction
+	bot.DB.Transactions.Where("invoice_payment_hash = ?", hash).Find(&transactions)
+	for _, t := range transactions {
+		b.WriteString(transactionLookupEntry(t))
+		found = true
+	}
+
+	var counterparties []PaymentCounterparty
+	bot.DB.Transactions.Where("payment_hash = ?", hash).Find(&counterparties)
+	for _, c := range counterparties {
+		found = true
+		user := &lnbits.User{}
+		if tx := bot.DB.Users.Where("wallet_id = ?", c.WalletID).First(user); tx.Error != nil || user.Telegram == nil || user.Wallet == nil {
+			fmt.Fprintf(&b, adminLookupWalletMessage, str.MarkdownEscape(c.WalletID), "unknown wallet")
+			continue
+		}
+		status := "unknown"
+		if payment, err := bot.Client.Payment(*user.Wallet, hash); err != nil {
+			log.Warnf("[lookupPayment] could not get payment %s of %s: %v", hash, GetUserStr(user.Telegram), err)
+		} else if payment.Paid {
+			status = fmt.Sprintf("paid, %d sat, fee %d msat", payment.Details.Amount/1000, payment.Details.Fee)
+		} else if payment.Details.Pending {
+			status = "pending"
+		} else {
+			status = "not paid"
+		}
+		fmt.Fprintf(&b, adminLookupWalletMessage, str.MarkdownEscape(GetUserStr(user.Telegram)),
+			str.MarkdownEscape(fmt.Sprintf("%s, %s %s", status, c.Type, c.Name)))
+	}
+
+	invoiceEvent := &InvoiceEvent{Invoice: &Invoice{PaymentHash: hash}}
+	if err := bot.Bunt.Get(invoiceEvent); err == nil && invoiceEvent.User != nil {
+		found = true
+		status := "waiting for the payment"
+		if invoiceEvent.Settled {
+			status = "paid and handled"
+		} else if !invoiceEvent.Open {
+			status = "created"
+		}
+		fmt.Fprintf(&b, adminLookupInvoiceMessage, str.MarkdownEscape(GetUserStr(invoiceEvent.User.Telegram)), status)
+	}
+
+	outgoing := &OutgoingPayment{PaymentHash: hash}
+	if err := bot.Bunt.Get(outgoing); err == nil && outgoing.User != nil {
+		found = true
+		fmt.Fprintf(&b, adminLookupOutgoingMessage, str.MarkdownEscape(GetUserStr(outgoing.User.Telegram)),
+			outgoing.StartedAt.UTC().Format("2006-01-02 15:04"), outgoing.Amount)
+	}
+
+	if !found {
+		return fmt.Sprintf(adminLookupNoPaymentMessage, hash)
+	}
+	return b.String()
+}
@@ -0,0 +1,49 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestAdminLookup(t *testing.T) {
+	h := newTestHarness(t)
+	support := &tb.User{ID: 9521, Username: "support", FirstName: "Support", LanguageCode: "en"}
+	customer := &tb.User{ID: 9522, Username: "customer", FirstName: "Customer", LanguageCode: "en"}
+	friend := &tb.User{ID: 9523, Username: "friend", FirstName: "Friend", LanguageCode: "en"}
+	h.newUser(support, 0)
+	h.newUser(customer, 100)
+	h.newUser(friend, 0)
+	config := internal.Configuration.Bot
+	t.Cleanup(func() { internal.Configuration.Bot = config })
+	internal.Configuration.Bot.Operators = map[int64]string{support.ID: "support"}
+
+	h.sendMessage(customer, privateChat(customer), "/send 50 @friend")
+	h.pressButton(customer, h.lastMessage(customer.ID), "✅ Send")
+	// the balance is too low
+	h.sendMessage(customer, privateChat(customer), "/send 500 @friend")
+	h.pressButton(customer, h.lastMessage(customer.ID), "✅ Send")
+
+	h.sendMessage(support, privateChat(support), "/admin lookup @customer")
+	text := h.lastMessage(support.ID).Text()
+	for _, want := range []string{"@customer (9522)", "50 sat", "@customer → @friend: 50 sat", "Errors"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("lookup does not contain %q: %q", want, text)
+		}
+	}
+
+	transaction := &Transaction{}
+	if tx := h.bot.DB.Transactions.Where("from_id = ? AND success = ?", customer.ID, true).First(transaction); tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	h.sendMessage(support, privateChat(support), "/admin lookup "+transaction.Invoice.PaymentHash)
+	if text := h.lastMessage(support.ID).Text(); !strings.Contains(text, "@customer → @friend: 50 sat") || !strings.Contains(text, "@friend: paid") {
+		t.Errorf("payment lookup = %q", text)
+	}
+	h.sendMessage(support, privateChat(support), "/admin lookup "+strings.Repeat("ab", 32))
+	if text := h.lastMessage(support.ID).Text(); !strings.Contains(text, "knows no payment") {
+		t.Errorf("unknown payment = %q", text)
+	}
+}
@@ -0,0 +1,693 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/database"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// migrators returns the versioned migrations of all databases and key/value stores.
+// Version 1 of every scope is the schema from before migrations were versioned. Changes
+// of a model or of the items in a store are added as a new version of their scope.
+func migrators(dbs *Databases, bunt, shopBunt storage.Store) []*database.Migrator {
+	return []*database.Migrator{
+		database.NewMigrator(dbs.Users, "users",
+			database.Migration{
+				Version:     1,
+				Description: "users and address names",
+				Up: func() error {
+					if err := ColumnMigrationTasks(dbs.Users); err != nil {
+						return err
+					}
+					return dbs.Users.AutoMigrate(&lnbits.User{}, &database.AddressName{})
+				},
+			},
+			database.Migration{
+				Version:     2,
+				Description: "payment confirmation setting",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&lnbits.Settings{})
+				},
+				Down: func() error {
+					return dbs.Users.Migrator().DropColumn(&lnbits.Settings{}, "payment_confirm_above")
+				},
+			},
+			database.Migration{
+				Version:     3,
+				Description: "maximum routing fee setting",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&lnbits.Settings{})
+				},
+				Down: func() error {
+					if err := dbs.Users.Migrator().DropColumn(&lnbits.Settings{}, "payment_max_fee_percent"); err != nil {
+						return err
+					}
+					return dbs.Users.Migrator().DropColumn(&lnbits.Settings{}, "payment_max_fee_sat")
+				},
+			},
+			database.Migration{
+				Version:     4,
+				Description: "public donor setting",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&lnbits.Settings{})
+				},
+				Down: func() error {
+					return dbs.Users.Migrator().DropColumn(&lnbits.Settings{}, "donation_public")
+				},
+			},
+			database.Migration{
+				Version:     5,
+				Description: "notification settings and digests",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&lnbits.Settings{}, &Notification{})
+				},
+				Down: func() error {
+					for _, column := range []string{"notification_min_tip", "notification_mute_faucet", "notification_mute_mentions", "notification_digest"} {
+						if err := dbs.Users.Migrator().DropColumn(&lnbits.Settings{}, column); err != nil {
+							return err
+						}
+					}
+					return dbs.Users.Migrator().DropTable(&Notification{})
+				},
+			},
+			database.Migration{
+				Version:     6,
+				Description: "nostr wallet connect connections",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&NwcConnection{}, &NwcRequest{})
+				},
+				Down: func() error {
+					return dbs.Users.Migrator().DropTable(&NwcConnection{}, &NwcRequest{})
+				},
+			},
+			database.Migration{
+				Version:     7,
+				Description: "history of telegram usernames",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&database.UsernameChange{})
+				},
+				Down: func() error {
+					return dbs.Users.Migrator().DropTable(&database.UsernameChange{})
+				},
+			},
+			database.Migration{
+				Version:     8,
+				Description: "unit of the amounts that users see",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&lnbits.Settings{})
+				},
+				Down: func() error {
+					return dbs.Users.Migrator().DropColumn(&lnbits.Settings{}, "display_unit")
+				},
+			},
+			database.Migration{
+				Version:     9,
+				Description: "anonymous tips",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&lnbits.Settings{})
+				},
+				Down: func() error {
+					return dbs.Users.Migrator().DropColumn(&lnbits.Settings{}, "tip_anonymous")
+				},
+			},
+			database.Migration{
+				Version:     10,
+				Description: "address book",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&Contact{})
+				},
+				Down: func() error {
+					return dbs.Users.Migrator().DropTable(&Contact{})
+				},
+			},
+			database.Migration{
+				Version:     11,
+				Description: "auto-forward",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&lnbits.Settings{})
+				},
+				Down: func() error {
+					for _, column := range []string{"autoforward_address", "autoforward_threshold"} {
+						if err := dbs.Users.Migrator().DropColumn(&lnbits.Settings{}, column); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			},
+			database.Migration{
+				Version:     12,
+				Description: "balance alerts",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&lnbits.Settings{})
+				},
+				Down: func() error {
+					for _, column := range []string{"alert_balance_below", "alert_payment_above", "alert_daily_outflow_above"} {
+						if err := dbs.Users.Migrator().DropColumn(&lnbits.Settings{}, column); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			},
+			database.Migration{
+				Version:     13,
+				Description: "audit log",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&AuditEntry{})
+				},
+				Down: func() error {
+					return dbs.Users.Migrator().DropTable(&AuditEntry{})
+				},
+			},
+			database.Migration{
+				Version:     14,
+				Description: "paid messages",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&lnbits.Settings{})
+				},
+				Down: func() error {
+					return dbs.Users.Migrator().DropColumn(&lnbits.Settings{}, "pm_price")
+				},
+			},
+			database.Migration{
+				Version:     15,
+				Description: "birthdays",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&lnbits.Settings{})
+				},
+				Down: func() error {
+					if err := dbs.Users.Migrator().DropColumn(&lnbits.Settings{}, "birthday_month"); err != nil {
+						return err
+					}
+					return dbs.Users.Migrator().DropColumn(&lnbits.Settings{}, "birthday_day")
+				},
+			},
+			database.Migration{
+				Version:     16,
+				Description: "round-up donations",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&lnbits.Settings{})
+				},
+				Down: func() error {
+					if err := dbs.Users.Migrator().DropColumn(&lnbits.Settings{}, "roundup_step"); err != nil {
+						return err
+					}
+					return dbs.Users.Migrator().DropColumn(&lnbits.Settings{}, "roundup_address")
+				},
+			},
+			database.Migration{
+				Version:     17,
+				Description: "pauses of wallets with suspicious activity",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&WalletPause{})
+				},
+				Down: func() error {
+					return dbs.Users.Migrator().DropTable(&WalletPause{})
+				},
+			},
+			database.Migration{
+				Version:     18,
+				Description: "sessions of lndhub clients",
+				Up: func() error {
+					return dbs.Users.AutoMigrate(&LndhubSession{})
+				},
+				Down: func() error {
+					return dbs.Users.Migrator().DropTable(&LndhubSession{})
+				},
+			},
+		),
+		database.NewMigrator(dbs.Transactions, "transactions",
+			database.Migration{
+				Version:     1,
+				Description: "transactions, counterparties and idempotency keys",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&Transaction{}, &PaymentCounterparty{}, &IdempotencyKey{})
+				},
+			},
+			database.Migration{
+				Version:     2,
+				Description: "preimages of payments",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&PaymentCounterparty{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropColumn(&PaymentCounterparty{}, "preimage")
+				},
+			},
+			database.Migration{
+				Version:     3,
+				Description: "messages of transactions for refunds",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&Transaction{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropColumn(&Transaction{}, "sender_message_id")
+				},
+			},
+			database.Migration{
+				Version:     4,
+				Description: "donations for the donor leaderboard",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&Donation{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropTable(&Donation{})
+				},
+			},
+			database.Migration{
+				Version:     5,
+				Description: "payment details of donations for receipts",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&Donation{})
+				},
+				Down: func() error {
+					for _, column := range []string{"payment_hash", "fiat_value", "fiat_currency"} {
+						if err := dbs.Transactions.Migrator().DropColumn(&Donation{}, column); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			},
+			database.Migration{
+				Version:     6,
+				Description: "products and sales of /sell",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&Product{}, &ProductSale{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropTable(&Product{}, &ProductSale{})
+				},
+			},
+			database.Migration{
+				Version:     7,
+				Description: "referrals of /invite",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&Referral{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropTable(&Referral{})
+				},
+			},
+			database.Migration{
+				Version:     8,
+				Description: "service fee of transactions and donations",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&Transaction{}, &Donation{})
+				},
+				Down: func() error {
+					if err := dbs.Transactions.Migrator().DropColumn(&Transaction{}, "fee"); err != nil {
+						return err
+					}
+					return dbs.Transactions.Migrator().DropColumn(&Donation{}, "fee")
+				},
+			},
+			database.Migration{
+				Version:     9,
+				Description: "balances imported from other tip bots",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&ImportedBalance{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropTable(&ImportedBalance{})
+				},
+			},
+			database.Migration{
+				Version:     10,
+				Description: "forum topics of transactions",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&Transaction{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropColumn(&Transaction{}, "topic")
+				},
+			},
+			database.Migration{
+				Version:     11,
+				Description: "channel posts of tips in the comments",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&Transaction{})
+				},
+				Down: func() error {
+					if err := dbs.Transactions.Migrator().DropColumn(&Transaction{}, "channel_id"); err != nil {
+						return err
+					}
+					return dbs.Transactions.Migrator().DropColumn(&Transaction{}, "channel_post")
+				},
+			},
+			database.Migration{
+				Version:     12,
+				Description: "anonymous tips",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&Transaction{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropColumn(&Transaction{}, "anonymous")
+				},
+			},
+			database.Migration{
+				Version:     13,
+				Description: "log of auto-forwards",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&Forward{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropTable(&Forward{})
+				},
+			},
+			database.Migration{
+				Version:     14,
+				Description: "errors of updates",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&UpdateError{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropTable(&UpdateError{})
+				},
+			},
+			database.Migration{
+				Version:     15,
+				Description: "paid messages",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&PaidMessage{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropTable(&PaidMessage{})
+				},
+			},
+			database.Migration{
+				Version:     16,
+				Description: "pay-per-view media and their sales",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&PaidMedia{}, &PaidMediaSale{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropTable(&PaidMedia{}, &PaidMediaSale{})
+				},
+			},
+			database.Migration{
+				Version:     17,
+				Description: "drops of paid media",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&PaidMedia{}, &DropPreview{})
+				},
+				Down: func() error {
+					if err := dbs.Transactions.Migrator().DropTable(&DropPreview{}); err != nil {
+						return err
+					}
+					if err := dbs.Transactions.Migrator().DropColumn(&PaidMedia{}, "free_at"); err != nil {
+						return err
+					}
+					return dbs.Transactions.Migrator().DropColumn(&PaidMedia{}, "released")
+				},
+			},
+			database.Migration{
+				Version:     18,
+				Description: "encryption of paid media",
+				Up: func() error {
+					if err := dbs.Transactions.AutoMigrate(&PaidMedia{}); err != nil {
+						return err
+					}
+					return sealLegacyPaidMedia(dbs.Transactions)
+				},
+				Down: func() error {
+					if err := unsealPaidMedia(dbs.Transactions); err != nil {
+						return err
+					}
+					return dbs.Transactions.Migrator().DropColumn(&PaidMedia{}, "content_key")
+				},
+			},
+			database.Migration{
+				Version:     19,
+				Description: "donation matching campaigns",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&MatchingCampaign{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropTable(&MatchingCampaign{})
+				},
+			},
+			database.Migration{
+				Version:     20,
+				Description: "round-ups of outgoing payments",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&RoundUp{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropTable(&RoundUp{})
+				},
+			},
+			database.Migration{
+				Version:     21,
+				Description: "operator payments and their approvals",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&OperatorPayment{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropTable(&OperatorPayment{})
+				},
+			},
+			database.Migration{
+				Version:     22,
+				Description: "completion of idempotency keys",
+				Up: func() error {
+					if err := dbs.Transactions.AutoMigrate(&IdempotencyKey{}); err != nil {
+						return err
+					}
+					// the outcome of the operations before is unknown, they stay claimed
+					return dbs.Transactions.Model(&IdempotencyKey{}).Where("1 = 1").Update("done", true).Error
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropColumn(&IdempotencyKey{}, "done")
+				},
+			},
+			database.Migration{
+				Version:     23,
+				Description: "language of operator payments",
+				Up: func() error {
+					return dbs.Transactions.AutoMigrate(&OperatorPayment{})
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropColumn(&OperatorPayment{}, "language_code")
+				},
+			},
+			database.Migration{
+				Version:     24,
+				Description: "round-ups that were paid out without a recorded donation",
+				Up: func() error {
+					if err := dbs.Transactions.AutoMigrate(&RoundUp{}); err != nil {
+						return err
+					}
+					return dbs.Transactions.Model(&RoundUp{}).Where("donation_id <> ?", 0).Update("paid_out", true).Error
+				},
+				Down: func() error {
+					return dbs.Transactions.Migrator().DropColumn(&RoundUp{}, "paid_out")
+				},
+			},
+		),
+		database.NewMigrator(dbs.Groups, "groups",
+			database.Migration{
+				Version:     1,
+				Description: "groups and group settings",
+				Up: func() error {
+					return dbs.Groups.AutoMigrate(&Group{}, &GroupSettings{})
+				},
+			},
+			database.Migration{
+				Version:     2,
+				Description: "weekly recap of groups",
+				Up: func() error {
+					return dbs.Groups.AutoMigrate(&GroupSettings{})
+				},
+				Down: func() error {
+					if err := dbs.Groups.Migrator().DropColumn(&GroupSettings{}, "recap"); err != nil {
+						return err
+					}
+					return dbs.Groups.Migrator().DropColumn(&GroupSettings{}, "recap_sent_at")
+				},
+			},
+			database.Migration{
+				Version:     3,
+				Description: "quiet mode of groups",
+				Up: func() error {
+					return dbs.Groups.AutoMigrate(&GroupSettings{})
+				},
+				Down: func() error {
+					return dbs.Groups.Migrator().DropColumn(&GroupSettings{}, "quiet")
+				},
+			},
+			database.Migration{
+				Version:     4,
+				Description: "automatic deletion of bot messages in groups",
+				Up: func() error {
+					return dbs.Groups.AutoMigrate(&GroupSettings{})
+				},
+				Down: func() error {
+					return dbs.Groups.Migrator().DropColumn(&GroupSettings{}, "delete_after")
+				},
+			},
+			database.Migration{
+				Version:     5,
+				Description: "forum topics: recap topic and topic settings",
+				Up: func() error {
+					return dbs.Groups.AutoMigrate(&GroupSettings{}, &TopicSettings{})
+				},
+				Down: func() error {
+					if err := dbs.Groups.Migrator().DropTable(&TopicSettings{}); err != nil {
+						return err
+					}
+					return dbs.Groups.Migrator().DropColumn(&GroupSettings{}, "recap_topic")
+				},
+			},
+			database.Migration{
+				Version:     6,
+				Description: "channels with comments and their authors",
+				Up: func() error {
+					return dbs.Groups.AutoMigrate(&Channel{}, &ChannelAuthor{})
+				},
+				Down: func() error {
+					return dbs.Groups.Migrator().DropTable(&Channel{}, &ChannelAuthor{})
+				},
+			},
+			database.Migration{
+				Version:     7,
+				Description: "group wallet for tips to anonymous admins",
+				Up: func() error {
+					return dbs.Groups.AutoMigrate(&GroupSettings{})
+				},
+				Down: func() error {
+					return dbs.Groups.Migrator().DropColumn(&GroupSettings{}, "wallet_user_id")
+				},
+			},
+			database.Migration{
+				Version:     8,
+				Description: "group commission on tips",
+				Up: func() error {
+					return dbs.Groups.AutoMigrate(&GroupSettings{})
+				},
+				Down: func() error {
+					return dbs.Groups.Migrator().DropColumn(&GroupSettings{}, "commission")
+				},
+			},
+			database.Migration{
+				Version:     9,
+				Description: "subscription plans and subscriptions",
+				Up: func() error {
+					return dbs.Groups.AutoMigrate(&SubscriptionPlan{}, &Subscription{})
+				},
+				Down: func() error {
+					return dbs.Groups.Migrator().DropTable(&SubscriptionPlan{}, &Subscription{})
+				},
+			},
+			database.Migration{
+				Version:     10,
+				Description: "games in groups",
+				Up: func() error {
+					return dbs.Groups.AutoMigrate(&GroupSettings{})
+				},
+				Down: func() error {
+					if err := dbs.Groups.Migrator().DropColumn(&GroupSettings{}, "games"); err != nil {
+						return err
+					}
+					return dbs.Groups.Migrator().DropColumn(&GroupSettings{}, "game_limit")
+				},
+			},
+			database.Migration{
+				Version:     11,
+				Description: "birthday celebrations in groups",
+				Up: func() error {
+					return dbs.Groups.AutoMigrate(&GroupSettings{}, &BirthdayMember{})
+				},
+				Down: func() error {
+					if err := dbs.Groups.Migrator().DropTable(&BirthdayMember{}); err != nil {
+						return err
+					}
+					if err := dbs.Groups.Migrator().DropColumn(&GroupSettings{}, "birthday_tip"); err != nil {
+						return err
+					}
+					return dbs.Groups.Migrator().DropColumn(&GroupSettings{}, "birthday_whip_round")
+				},
+			},
+			database.Migration{
+				Version:     12,
+				Description: "approvals of spending from group wallets",
+				Up: func() error {
+					return dbs.Groups.AutoMigrate(&GroupSettings{}, &SpendApprover{}, &SpendProposal{}, &SpendVote{})
+				},
+				Down: func() error {
+					if err := dbs.Groups.Migrator().DropTable(&SpendVote{}, &SpendProposal{}, &SpendApprover{}); err != nil {
+						return err
+					}
+					return dbs.Groups.Migrator().DropColumn(&GroupSettings{}, "spend_approvals")
+				},
+			},
+		),
+		database.NewMigrator(dbs.Users, "bunt",
+			database.Migration{
+				Version:     1,
+				Description: "key/value state before versioning",
+				Up:          func() error { return nil },
+			},
+		),
+		database.NewMigrator(dbs.Users, "shop_bunt",
+			database.Migration{
+				Version:     1,
+				Description: "shops before versioning",
+				Up:          func() error { return nil },
+			},
+		),
+	}
+}
+
+// migrateDatabases migrates all scopes to their latest version if target is empty or "latest".
+// A target like "users:3" migrates a single scope up or down to the given version.
+func migrateDatabases(dbs *Databases, bunt, shopBunt storage.Store, target string) error {
+	scope, version := "", -1
+	if target != "" && target != "latest" {
+		parts := strings.Split(target, ":")
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid migration target %s, use latest or <scope>:<version>", target)
+		}
+		v, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid migration version %s", parts[1])
+		}
+		scope, version = parts[0], v
+	}
+	found := false
+	for _, migrator := range migrators(dbs, bunt, shopBunt) {
+		if scope != "" && migrator.Scope != scope {
+			continue
+		}
+		found = true
+		v := version
+		if scope == "" {
+			v = migrator.Latest()
+		}
+		if err := migrator.Migrate(v); err != nil {
+			return err
+		}
+		current, _ := migrator.Version()
+		log.Infof("[Migrate] %s is at version %d", migrator.Scope, current)
+	}
+	if !found {
+		return fmt.Errorf("unknown migration scope %s", scope)
+	}
+	return nil
+}
+
+// RunMigrations opens the databases and migrates them to target, see migrateDatabases.
+// It is used by the --migrate flag.
+func RunMigrations(target string) error {
+	dbs := openDatabases()
+	bunt := createBunt(internal.Configuration.Database.BuntDbPath, "bunt")
+	shopBunt := createBunt(internal.Configuration.Database.ShopBuntDbPath, "shop_bunt")
+	return migrateDatabases(dbs, bunt, shopBunt, target)
+}
@@ -2,6 +2,7 @@ package telegram
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"strings"
 	"time"
@@ -17,7 +18,8 @@ var (
 	nosterRegisterMessage       = "📖 Add your nostr pubkey for zap receipts"
 	nostrInfoMessage            = "💜 *Your nostr information*\n\nYour pubkey: `%s`"
 	nostrInfoLNAddrMessage      = "Your Lightning address: `%s`"
-	nostrHelpMessage            = "⚙️ *Nostr commands:*\n`/nostr add <pubkey>` ✅ Add your nostr pubkey.\n`/nostr help` 📖 Show help."
+	nostrInfoNip05Message       = "Your NIP-05 identifier: `%s`"
+	nostrHelpMessage            = "⚙️ *Nostr commands:*\n`/nostr set <npub>` ✅ Set your nostr pubkey, it is verified as NIP-05 identifier with your Lightning address.\n`/nostr help` 📖 Show help."
 	nostrAddedMessage           = "✅ *Nostr pubkey added.*"
 	nostrPrivateKeyErrorMessage = "🚫 This is not your public key but your private key! Very dangerous! Try again with your npub..."
 	nostrPublicKeyErrorMessage  = "🚫 There was an error decoding your public key."
@@ -107,7 +109,7 @@ func (bot *TipBot) nostrHandler(ctx intercept.Context) (intercept.Context, error
 		return bot.getNostrHandler(ctx)
 	} else if len(splits) > 1 {
 		switch strings.ToLower(splits[1]) {
-		case "add":
+		case "add", "set":
 			return bot.addNostrPubkeyHandler(ctx)
 		case "help":
 			return bot.nostrHelpHandler(ctx)
@@ -143,6 +145,11 @@ func (bot *TipBot) addNostrPubkeyHandler(ctx intercept.Context) (intercept.Conte
 		}
 		nostrKeyInput = pubkey.(string)
 	}
+	if key, err := hex.DecodeString(nostrKeyInput); err != nil || len(key) != 32 {
+		bot.trySendMessage(ctx.Message().Sender, nostrPublicKeyErrorMessage)
+		return ctx, fmt.Errorf("invalid nostr pubkey")
+	}
+	nostrKeyInput = strings.ToLower(nostrKeyInput)
 
 	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
 	if err != nil {
@@ -186,6 +193,7 @@ func (bot *TipBot) getNostrHandler(ctx intercept.Context) (intercept.Context, er
 		dynamicHelpMessage += "\n\n" + fmt.Sprintf(nostrInfoMessage, pubkeyBech32)
 		if lnaddr, _ := bot.UserGetLightningAddress(user); len(lnaddr) > 0 {
 			dynamicHelpMessage += "\n\n" + fmt.Sprintf(nostrInfoLNAddrMessage, lnaddr)
+			dynamicHelpMessage += "\n" + fmt.Sprintf(nostrInfoNip05Message, lnaddr)
 		}
 		bot.trySendMessage(m.Sender, dynamicHelpMessage)
 	}
@@ -0,0 +1,146 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// NotificationType is the kind of a notification, users can mute some kinds.
+type NotificationType string
+
+const (
+	NotificationTip     NotificationType = "tip"
+	NotificationFaucet  NotificationType = "faucet"
+	NotificationMention NotificationType = "mention" // payments to the user that were made in a group
+	NotificationPayment NotificationType = "payment"
+)
+
+const (
+	notificationDigestInterval      = 24 * time.Hour
+	notificationDigestCheckInterval = time.Hour
+	notificationDigestMaxEntries    = 20
+)
+
+// Notification is a message to a user who gets the notifications as daily digest.
+type Notification struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	UserId    int64     `json:"user_id" gorm:"index"`
+	Type      string    `json:"type"`
+	Text      string    `json:"text"`
+}
+
+// notificationSettings loads the notification settings of a user.
+func (bot *TipBot) notificationSettings(to *lnbits.User) lnbits.NotificationSettings {
+	user, err := GetLnbitsUserWithSettings(to.Telegram, *bot)
+	if err != nil {
+		return lnbits.NotificationSettings{}
+	}
+	return user.Settings.Notification
+}
+
+func notificationMuted(settings lnbits.NotificationSettings, notificationType NotificationType, amount int64) bool {
+	switch notificationType {
+	case NotificationTip:
+		return amount < settings.MinTip
+	case NotificationFaucet:
+		return settings.MuteFaucet
+	case NotificationMention:
+		return settings.MuteMentions
+	}
+	return false
+}
+
+// notifiesInstantly returns true if the user wants to get the notification right away.
+func (bot *TipBot) notifiesInstantly(to *lnbits.User, notificationType NotificationType, amount int64) bool {
+	settings := bot.notificationSettings(to)
+	return !settings.Digest && !notificationMuted(settings, notificationType, amount)
+}
+
+// notify sends a notification to a user unless the user muted it. Users who turned on the
+// digest get it with the next digest instead.
+func (bot *TipBot) notify(to *lnbits.User, notificationType NotificationType, amount int64, message string, options ...interface{}) {
+	if to == nil || to.Telegram == nil {
+		return
+	}
+	settings := bot.notificationSettings(to)
+	if notificationMuted(settings, notificationType, amount) {
+		log.Debugf("[notify] %s muted the %s notification", GetUserStr(to.Telegram), notificationType)
+		return
+	}
+	if settings.Digest {
+		notification := &Notification{UserId: to.Telegram.ID, Type: string(notificationType), Text: message}
+		tx := bot.DB.Users.Create(notification)
+		if tx.Error == nil {
+			return
+		}
+		// rather send it now than lose it
+		log.Errorf("[notify] could not queue notification for %s: %v", GetUserStr(to.Telegram), tx.Error)
+	}
+	bot.trySendMessage(to.Telegram, message, options...)
+}
+
+// startNotificationDigestWorker periodically sends the digests that are due.
+func (bot *TipBot) startNotificationDigestWorker() {
+	go func() {
+		for {
+			if !isShuttingDown() {
+				bot.sendNotificationDigests(time.Now())
+			}
+			time.Sleep(notificationDigestCheckInterval)
+		}
+	}()
+}
+
+// sendNotificationDigests sends a digest to every user whose oldest notification is a day old.
+func (bot *TipBot) sendNotificationDigests(now time.Time) {
+	var ids []int64
+	tx := bot.DB.Users.Model(&Notification{}).Group("user_id").
+		Having("MIN(created_at) <= ?", now.Add(-notificationDigestInterval)).Pluck("user_id", &ids)
+	if tx.Error != nil {
+		log.Errorf("[notificationDigest] %v", tx.Error)
+		return
+	}
+	for _, id := range ids {
+		bot.sendNotificationDigest(id)
+	}
+}
+
+// sendNotificationDigest sends all pending notifications of a user in one message.
+func (bot *TipBot) sendNotificationDigest(id int64) {
+	// other instances of a cluster send the same digests
+	key := fmt.Sprintf("notification-digest:%d", id)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	var notifications []Notification
+	tx := bot.DB.Users.Where("user_id = ?", id).Order("created_at").Find(&notifications)
+	if tx.Error != nil || len(notifications) == 0 {
+		return
+	}
+	if tx := bot.DB.Users.Delete(&notifications); tx.Error != nil {
+		log.Errorf("[notificationDigest] could not delete the notifications of %d: %v", id, tx.Error)
+		return
+	}
+	user, err := GetLnbitsUser(&tb.User{ID: id}, *bot)
+	if err != nil || user.Telegram == nil {
+		log.Errorf("[notificationDigest] could not load user %d: %v", id, err)
+		return
+	}
+	var entries []string
+	for i, notification := range notifications {
+		if i == notificationDigestMaxEntries {
+			entries = append(entries, fmt.Sprintf(i18n.Translate(user.Telegram.LanguageCode, "notificationDigestMoreMessage"), len(notifications)-i))
+			break
+		}
+		entries = append(entries, notification.Text)
+	}
+	log.Infof("[notificationDigest] sending %d notifications to %s", len(notifications), GetUserStr(user.Telegram))
+	bot.trySendMessage(user.Telegram, fmt.Sprintf(i18n.Translate(user.Telegram.LanguageCode, "notificationDigestMessage"), strings.Join(entries, "\n\n")), tb.NoPreview)
+}
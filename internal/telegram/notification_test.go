@@ -0,0 +1,74 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// tipNotifications returns the number of messages with tip notifications that the bot sent to a user.
+func (h *testHarness) tipNotifications(user *tb.User) int {
+	count := 0
+	for _, r := range h.sent {
+		if r.ChatId() == user.ID && strings.Contains(r.Text(), "has tipped you") {
+			count++
+		}
+	}
+	return count
+}
+
+func TestNotificationMinTip(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9801, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9802, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9800, Type: tb.ChatGroup, Title: "group"}
+	h.newUser(alice, 1000)
+	to := h.newUser(bob, 0)
+
+	h.sendMessage(bob, privateChat(bob), "/settings notifications tips 100")
+	post := h.sendMessage(bob, group, "gm")
+	h.sendReply(alice, group, "/tip 50", post)
+	if count := h.tipNotifications(bob); count != 0 {
+		t.Errorf("bob got %d notifications of a tip below his minimum", count)
+	}
+	h.sendReply(alice, group, "/tip 100", post)
+	if count := h.tipNotifications(bob); count != 1 {
+		t.Errorf("bob got %d notifications, want 1", count)
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 150 {
+		t.Errorf("balance of bob = %d, want 150", balance)
+	}
+}
+
+func TestNotificationDigest(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9811, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9812, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9810, Type: tb.ChatGroup, Title: "group"}
+	h.newUser(alice, 1000)
+	h.newUser(bob, 0)
+
+	h.sendMessage(bob, privateChat(bob), "/set notifications digest on")
+	post := h.sendMessage(bob, group, "gm")
+	h.sendReply(alice, group, "/tip 100", post)
+	h.sendReply(alice, group, "/tip 200", post)
+	if count := h.tipNotifications(bob); count != 0 {
+		t.Fatalf("bob got %d instant notifications with the digest on", count)
+	}
+
+	h.bot.sendNotificationDigests(time.Now())
+	if count := h.tipNotifications(bob); count != 0 {
+		t.Fatalf("digest was sent before a day passed")
+	}
+	h.bot.sendNotificationDigests(time.Now().Add(25 * time.Hour))
+	text := h.lastMessage(bob.ID).Text()
+	if !strings.Contains(text, "daily digest") || strings.Count(text, "has tipped you") != 2 {
+		t.Errorf("digest = %q", text)
+	}
+	h.bot.sendNotificationDigests(time.Now().Add(50 * time.Hour))
+	if count := h.tipNotifications(bob); count != 1 {
+		t.Errorf("digest was sent %d times, want once", count)
+	}
+}
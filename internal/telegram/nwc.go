@@ -0,0 +1,212 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/nbd-wtf/go-nostr"
+	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// nwcDefaultBudget is the daily budget in sat of connections created without one.
+	nwcDefaultBudget = 10000
+	// nwcBudgetPeriod is the period that the budget of a connection applies to.
+	nwcBudgetPeriod = 24 * time.Hour
+	// nwcMaxConnections is the number of active connections a user can have.
+	nwcMaxConnections = 10
+	nwcNameMaxLength  = 32
+)
+
+// NwcConnection lets a Nostr client use the wallet of a user with Nostr Wallet Connect
+// (NIP-47). The client signs its requests with the secret of the connection, only the
+// public key of the secret is stored.
+type NwcConnection struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	UserId    int64     `json:"user_id" gorm:"index"`
+	Name      string    `json:"name"`
+	Pubkey    string    `json:"pubkey" gorm:"uniqueIndex"`
+	Budget    int64     `json:"budget"` // sat per nwcBudgetPeriod
+	Revoked   bool      `json:"revoked"`
+}
+
+// NwcRequest is a request of a connection that was handled. Requests are only handled
+// once, even if they arrive again after the relay reconnected or at another instance of
+// a cluster. Amount is the part of the budget that a payment uses.
+type NwcRequest struct {
+	ID           string    `gorm:"primarykey"` // id of the nostr event
+	CreatedAt    time.Time `json:"created_at" gorm:"index"`
+	ConnectionId uint      `json:"connection_id" gorm:"index"`
+	Method       string    `json:"method"`
+	Amount       int64     `json:"amount"`
+	PaymentHash  string    `json:"payment_hash"`
+}
+
+// nwcConnections returns the active connections of the user.
+func (bot *TipBot) nwcConnections(userId int64) ([]NwcConnection, error) {
+	var connections []NwcConnection
+	tx := bot.DB.Users.Where("user_id = ? AND revoked = ?", userId, false).Order("id").Find(&connections)
+	return connections, tx.Error
+}
+
+// nwcSpent returns how much of its budget the connection used in the current period.
+func (bot *TipBot) nwcSpent(connection *NwcConnection, now time.Time) int64 {
+	var spent int64
+	bot.DB.Users.Model(&NwcRequest{}).Where("connection_id = ? AND created_at > ?", connection.ID, now.Add(-nwcBudgetPeriod)).
+		Select("COALESCE(SUM(amount), 0)").Scan(&spent)
+	return spent
+}
+
+// nwcConnectionString returns the connection string that a Nostr client needs to use
+// the wallet with the secret of a connection.
+func nwcConnectionString(secret string) (string, error) {
+	pubkey, err := nostr.GetPublicKey(internal.Configuration.Nostr.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("nostr+walletconnect://%s?relay=%s&secret=%s", pubkey, url.QueryEscape(internal.Configuration.Nostr.WalletConnectRelay), secret), nil
+}
+
+func nwcEnabled() bool {
+	return len(internal.Configuration.Nostr.PrivateKey) > 0 && len(internal.Configuration.Nostr.WalletConnectRelay) > 0
+}
+
+// nwcHandler is invoked on /nwc.
+func (bot *TipBot) nwcHandler(ctx intercept.Context) (intercept.Context, error) {
+	if !nwcEnabled() {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "nwcDisabledMessage"))
+		return ctx, fmt.Errorf("nostr wallet connect is not configured")
+	}
+	splits := strings.Fields(ctx.Message().Text)
+	if len(splits) > 1 {
+		switch strings.ToLower(splits[1]) {
+		case "new":
+			return bot.nwcNewHandler(ctx, splits[2:])
+		case "revoke":
+			return bot.nwcRevokeHandler(ctx, splits[2:])
+		case "help":
+			bot.trySendMessage(ctx.Sender(), Translate(ctx, "nwcHelpMessage"))
+			return ctx, nil
+		}
+	}
+	return bot.nwcListHandler(ctx)
+}
+
+// nwcListHandler lists the active connections of the user.
+func (bot *TipBot) nwcListHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	connections, err := bot.nwcConnections(user.Telegram.ID)
+	if err != nil {
+		return ctx, err
+	}
+	if len(connections) == 0 {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "nwcNoConnectionsMessage")+"\n\n"+Translate(ctx, "nwcHelpMessage"))
+		return ctx, nil
+	}
+	now := time.Now()
+	message := Translate(ctx, "nwcConnectionsMessage")
+	for _, connection := range connections {
+		message += fmt.Sprintf(Translate(ctx, "nwcConnectionMessage"), connection.ID, str.MarkdownEscape(connection.Name), bot.nwcSpent(&connection, now), connection.Budget)
+	}
+	bot.trySendMessage(ctx.Sender(), message+"\n\n"+Translate(ctx, "nwcHelpMessage"))
+	return ctx, nil
+}
+
+// nwcNewHandler is invoked on /nwc new [<budget>] [<name>] and creates a connection.
+func (bot *TipBot) nwcNewHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	connections, err := bot.nwcConnections(user.Telegram.ID)
+	if err != nil {
+		return ctx, err
+	}
+	if len(connections) >= nwcMaxConnections {
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "nwcTooManyConnectionsMessage"), nwcMaxConnections))
+		return ctx, fmt.Errorf("too many nwc connections")
+	}
+	budget := int64(nwcDefaultBudget)
+	if len(args) > 0 {
+		if amount, err := GetAmount(args[0]); err == nil {
+			if amount <= 0 {
+				bot.trySendMessage(ctx.Sender(), Translate(ctx, "nwcInvalidBudgetMessage"))
+				return ctx, fmt.Errorf("invalid nwc budget")
+			}
+			budget = amount
+			args = args[1:]
+		}
+	}
+	name := strings.Join(args, " ")
+	if len([]rune(name)) > nwcNameMaxLength {
+		name = string([]rune(name)[:nwcNameMaxLength])
+	}
+	if len(name) == 0 {
+		name = fmt.Sprintf("Nostr %d", len(connections)+1)
+	}
+
+	secret := nostr.GeneratePrivateKey()
+	pubkey, err := nostr.GetPublicKey(secret)
+	if err != nil {
+		return ctx, err
+	}
+	connectionString, err := nwcConnectionString(secret)
+	if err != nil {
+		log.Errorf("[/nwc] invalid nostr private key: %v", err)
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "nwcDisabledMessage"))
+		return ctx, err
+	}
+	connection := &NwcConnection{UserId: user.Telegram.ID, Name: name, Pubkey: pubkey, Budget: budget}
+	if tx := bot.DB.Users.Create(connection); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	log.Infof("[/nwc] %s created connection %d with a budget of %d sat", GetUserStr(user.Telegram), connection.ID, budget)
+
+	qr, err := qrcode.Encode(connectionString, qrcode.Medium, 256)
+	if err != nil {
+		log.Errorf("[/nwc] Failed to create QR code: %v", err)
+		return ctx, err
+	}
+	infomsg := bot.trySendMessageEditable(ctx.Sender(), fmt.Sprintf(Translate(ctx, "nwcCreatedMessage"), connection.ID, str.MarkdownEscape(name), budget))
+	qrmsg := bot.trySendMessage(ctx.Sender(), &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: fmt.Sprintf("`%s`", connectionString)})
+	// auto delete
+	go func() {
+		time.Sleep(time.Second * 60)
+		bot.tryDeleteMessage(qrmsg)
+		bot.tryEditMessage(infomsg, Translate(ctx, "nwcHiddenMessage"), tb.Silent)
+	}()
+	return ctx, nil
+}
+
+// nwcRevokeHandler is invoked on /nwc revoke <id>. Requests of a revoked connection are
+// refused right away.
+func (bot *TipBot) nwcRevokeHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	if len(args) == 0 {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "nwcHelpMessage"))
+		return ctx, fmt.Errorf("no nwc connection given")
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(args[0], "#"), 10, 64)
+	if err != nil {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "nwcNotFoundMessage"))
+		return ctx, err
+	}
+	tx := bot.DB.Users.Model(&NwcConnection{}).Where("id = ? AND user_id = ? AND revoked = ?", id, user.Telegram.ID, false).Update("revoked", true)
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "nwcNotFoundMessage"))
+		return ctx, fmt.Errorf("nwc connection %d not found", id)
+	}
+	log.Infof("[/nwc] %s revoked connection %d", GetUserStr(user.Telegram), id)
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "nwcRevokedMessage"), id))
+	return ctx, nil
+}
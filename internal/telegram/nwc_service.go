@@ -0,0 +1,395 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// event kinds of NIP-47
+const (
+	nwcKindInfo     = 13194
+	nwcKindRequest  = 23194
+	nwcKindResponse = 23195
+)
+
+const (
+	// nwcSessionDuration is how long a subscription to the relay is used before it is
+	// renewed, so that a connection that died silently doesn't go unnoticed for long.
+	nwcSessionDuration   = 30 * time.Minute
+	nwcReconnectInterval = 10 * time.Second
+	// nwcRequestMaxAge is the age of requests that are still handled. Older requests are
+	// not answered, the client gave up on them.
+	nwcRequestMaxAge = 2 * time.Minute
+)
+
+// error codes of NIP-47
+const (
+	nwcErrorRateLimited         = "RATE_LIMITED"
+	nwcErrorNotImplemented      = "NOT_IMPLEMENTED"
+	nwcErrorInsufficientBalance = "INSUFFICIENT_BALANCE"
+	nwcErrorQuotaExceeded       = "QUOTA_EXCEEDED"
+	nwcErrorRestricted          = "RESTRICTED"
+	nwcErrorUnauthorized        = "UNAUTHORIZED"
+	nwcErrorInternal            = "INTERNAL"
+	nwcErrorPaymentFailed       = "PAYMENT_FAILED"
+	nwcErrorNotFound            = "NOT_FOUND"
+	nwcErrorOther               = "OTHER"
+)
+
+var nwcMethods = []string{"pay_invoice", "get_balance", "make_invoice", "lookup_invoice", "get_info"}
+
+type nwcPayload struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type nwcResponse struct {
+	ResultType string      `json:"result_type"`
+	Error      *nwcError   `json:"error,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+}
+
+type nwcError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// nwcTransaction is an invoice or a payment as NIP-47 describes it, amounts are in msat.
+type nwcTransaction struct {
+	Type        string `json:"type"`
+	Invoice     string `json:"invoice,omitempty"`
+	Description string `json:"description,omitempty"`
+	Preimage    string `json:"preimage,omitempty"`
+	PaymentHash string `json:"payment_hash"`
+	Amount      int64  `json:"amount"`
+	FeesPaid    int64  `json:"fees_paid"`
+	CreatedAt   int64  `json:"created_at"`
+	ExpiresAt   int64  `json:"expires_at,omitempty"`
+	SettledAt   int64  `json:"settled_at,omitempty"`
+}
+
+func nwcFail(method string, code string, message string) *nwcResponse {
+	return &nwcResponse{ResultType: method, Error: &nwcError{Code: code, Message: message}}
+}
+
+func nwcResult(method string, result interface{}) *nwcResponse {
+	return &nwcResponse{ResultType: method, Result: result}
+}
+
+// startNwcWorker answers the Nostr Wallet Connect requests on the configured relay.
+func (bot *TipBot) startNwcWorker() {
+	if !nwcEnabled() {
+		return
+	}
+	go func() {
+		for {
+			if !isShuttingDown() {
+				if err := bot.serveNwc(); err != nil {
+					log.Errorf("[nwc] %v", err)
+				}
+			}
+			time.Sleep(nwcReconnectInterval)
+		}
+	}()
+}
+
+// serveNwc subscribes to the requests to the wallet service for one session.
+func (bot *TipBot) serveNwc() error {
+	pk := internal.Configuration.Nostr.PrivateKey
+	pubkey, err := nostr.GetPublicKey(pk)
+	if err != nil {
+		return fmt.Errorf("invalid nostr private key: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), nwcSessionDuration)
+	defer cancel()
+	relay, err := nostr.RelayConnect(ctx, internal.Configuration.Nostr.WalletConnectRelay)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+
+	// forget the requests that can't arrive again
+	bot.DB.Users.Where("created_at < ?", time.Now().Add(-2*nwcBudgetPeriod)).Delete(&NwcRequest{})
+
+	// tell clients which methods the wallet service supports
+	info := nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: time.Now(),
+		Kind:      nwcKindInfo,
+		Tags:      nostr.Tags{},
+		Content:   strings.Join(nwcMethods, " "),
+	}
+	info.Sign(pk)
+	relay.Publish(ctx, info)
+
+	since := time.Now().Add(-nwcRequestMaxAge)
+	sub := relay.Subscribe(ctx, nostr.Filters{nostr.Filter{
+		Kinds: []int{nwcKindRequest},
+		Tags:  nostr.TagMap{"p": []string{pubkey}},
+		Since: &since,
+	}})
+	defer sub.Unsub()
+	log.Debugf("[nwc] listening on %s", internal.Configuration.Nostr.WalletConnectRelay)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return fmt.Errorf("subscription to %s closed", internal.Configuration.Nostr.WalletConnectRelay)
+			}
+			go bot.handleNwcEvent(ctx, relay, ev)
+		}
+	}
+}
+
+// handleNwcEvent decrypts a request, handles it and publishes the encrypted response.
+func (bot *TipBot) handleNwcEvent(ctx context.Context, relay *nostr.Relay, ev *nostr.Event) {
+	if ev.Kind != nwcKindRequest || time.Since(ev.CreatedAt) > nwcRequestMaxAge {
+		return
+	}
+	if ok, err := ev.CheckSignature(); !ok || err != nil {
+		return
+	}
+	pk := internal.Configuration.Nostr.PrivateKey
+	secret, err := nip04.ComputeSharedSecret(pk, ev.PubKey)
+	if err != nil {
+		return
+	}
+	content, err := nip04.Decrypt(ev.Content, secret)
+	if err != nil {
+		log.Debugf("[nwc] could not decrypt request %s: %v", ev.ID, err)
+		return
+	}
+	request := nwcPayload{}
+	if err := json.Unmarshal([]byte(content), &request); err != nil {
+		log.Debugf("[nwc] invalid request %s: %v", ev.ID, err)
+		return
+	}
+	response := bot.handleNwcRequest(ev.ID, ev.PubKey, request)
+	if response == nil {
+		return
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+	encrypted, err := nip04.Encrypt(string(body), secret)
+	if err != nil {
+		return
+	}
+	pubkey, _ := nostr.GetPublicKey(pk)
+	reply := nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: time.Now(),
+		Kind:      nwcKindResponse,
+		Tags:      nostr.Tags{nostr.Tag{"p", ev.PubKey}, nostr.Tag{"e", ev.ID}},
+		Content:   encrypted,
+	}
+	reply.Sign(pk)
+	relay.Publish(ctx, reply)
+}
+
+// handleNwcRequest handles the request with the given event id of the client with the
+// given pubkey. It returns nil if the request was handled before.
+func (bot *TipBot) handleNwcRequest(id string, pubkey string, request nwcPayload) *nwcResponse {
+	connection := &NwcConnection{}
+	if tx := bot.DB.Users.Where("pubkey = ? AND revoked = ?", pubkey, false).First(connection); tx.Error != nil {
+		return nwcFail(request.Method, nwcErrorUnauthorized, "no wallet is connected with this key")
+	}
+	// the relay can deliver a request again and every instance of a cluster receives it
+	if tx := bot.DB.Users.Create(&NwcRequest{ID: id, ConnectionId: connection.ID, Method: request.Method}); tx.Error != nil {
+		log.Debugf("[nwc] request %s was already handled", id)
+		return nil
+	}
+	user, err := GetLnbitsUser(&tb.User{ID: connection.UserId}, *bot)
+	if err != nil || user.Wallet == nil || user.Telegram == nil {
+		return nwcFail(request.Method, nwcErrorInternal, "wallet not found")
+	}
+	if user.Banned {
+		return nwcFail(request.Method, nwcErrorRestricted, "wallet is banned")
+	}
+	if !bot.walletBackendAvailable() {
+		return nwcFail(request.Method, nwcErrorRateLimited, "wallet is not available, try again later")
+	}
+	switch request.Method {
+	case "pay_invoice":
+		return bot.nwcPayInvoice(connection, user, id, request.Params)
+	case "get_balance":
+		balance, err := bot.GetUserBalance(user)
+		if err != nil {
+			return nwcFail(request.Method, nwcErrorInternal, "could not fetch balance")
+		}
+		return nwcResult(request.Method, map[string]int64{"balance": balance * 1000})
+	case "make_invoice":
+		return bot.nwcMakeInvoice(user, request.Params)
+	case "lookup_invoice":
+		return bot.nwcLookupInvoice(user, request.Params)
+	case "get_info":
+		return nwcResult(request.Method, map[string]interface{}{"alias": "LightningTipBot", "network": internal.Configuration.Bot.Network, "methods": nwcMethods})
+	}
+	return nwcFail(request.Method, nwcErrorNotImplemented, fmt.Sprintf("method %s is not supported", request.Method))
+}
+
+// nwcPayInvoice pays an invoice if the budget of the connection allows it.
+func (bot *TipBot) nwcPayInvoice(connection *NwcConnection, user *lnbits.User, id string, params json.RawMessage) *nwcResponse {
+	const method = "pay_invoice"
+	var p struct {
+		Invoice string `json:"invoice"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nwcFail(method, nwcErrorOther, "invalid params")
+	}
+	bolt11, err := decodepay.Decodepay(p.Invoice)
+	if err != nil {
+		return nwcFail(method, nwcErrorOther, "invalid invoice")
+	}
+	if bolt11.MSatoshi == 0 {
+		return nwcFail(method, nwcErrorOther, "invoices without amount are not supported")
+	}
+	amount := bolt11.MSatoshi / 1000
+
+	// reserve the amount, concurrent requests of the connection see it right away
+	key := fmt.Sprintf("nwc-connection:%d", connection.ID)
+	mutex.Lock(key)
+	if bot.nwcSpent(connection, time.Now())+amount > connection.Budget {
+		mutex.Unlock(key)
+		return nwcFail(method, nwcErrorQuotaExceeded, "budget of the connection exceeded")
+	}
+	if balance, err := bot.GetUserBalance(user); err == nil && balance < amount {
+		mutex.Unlock(key)
+		return nwcFail(method, nwcErrorInsufficientBalance, "not enough balance")
+	}
+	bot.DB.Users.Model(&NwcRequest{ID: id}).Updates(NwcRequest{Amount: amount, PaymentHash: bolt11.PaymentHash})
+	mutex.Unlock(key)
+
+	logger := log.WithFields(log.Fields{"user_id": user.Telegram.ID, "payment_hash": bolt11.PaymentHash})
+	logger.Infof("[nwc] %s pays invoice of %d sat with connection %d", GetUserStr(user.Telegram), amount, connection.ID)
+	// the payment is tracked until its outcome is known, see reconcile.go
+	outgoing := bot.trackOutgoingPayment(user, p.Invoice, amount, user.Telegram.LanguageCode)
+	invoice, err := bot.Client.Pay(*user.Wallet, lnbits.PaymentParams{Out: true, Bolt11: p.Invoice})
+	if err != nil && !isPaymentRefused(err) && outgoing != nil {
+		// the payment may still go through, it counts against the budget and the
+		// reconciler tells the user how it ended
+		outgoing.Waiting = true
+		bot.Bunt.Set(outgoing)
+		logger.Warnf("[nwc] outcome of the payment of %s is unknown: %v", GetUserStr(user.Telegram), err)
+		return nwcFail(method, nwcErrorOther, "payment is in flight")
+	}
+	if err != nil {
+		bot.untrackOutgoingPayment(outgoing)
+		bot.DB.Users.Model(&NwcRequest{ID: id}).Update("amount", 0)
+		logger.Errorf("[nwc] payment of %s failed: %v", GetUserStr(user.Telegram), err)
+		return nwcFail(method, nwcErrorPaymentFailed, "payment failed")
+	}
+	payment, err := bot.Client.Payment(*user.Wallet, invoice.PaymentHash)
+	if err != nil || !payment.Paid || len(strings.Trim(payment.Preimage, "0")) == 0 {
+		// the payment is still in flight, the reconciler keeps an eye on it
+		logger.Warnf("[nwc] no preimage of payment of %s", GetUserStr(user.Telegram))
+		return nwcFail(method, nwcErrorOther, "payment is in flight")
+	}
+	bot.untrackOutgoingPayment(outgoing)
+	bot.savePreimage(invoice.PaymentHash, user.Wallet.ID, payment.Preimage)
+	fee := payment.Details.Fee
+	if fee < 0 {
+		fee = -fee
+	}
+	bot.DB.Users.Model(&NwcRequest{ID: id}).Update("amount", amount+fee/1000)
+	bot.notify(user, NotificationPayment, amount, fmt.Sprintf(i18n.Translate(user.Telegram.LanguageCode, "nwcPaidMessage"), amount, str.MarkdownEscape(connection.Name)))
+	return nwcResult(method, map[string]interface{}{"preimage": payment.Preimage, "fees_paid": fee})
+}
+
+// nwcMakeInvoice creates an invoice, the user is notified when it is paid.
+func (bot *TipBot) nwcMakeInvoice(user *lnbits.User, params json.RawMessage) *nwcResponse {
+	const method = "make_invoice"
+	var p struct {
+		Amount      int64  `json:"amount"` // msat
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nwcFail(method, nwcErrorOther, "invalid params")
+	}
+	if p.Amount < 1000 {
+		return nwcFail(method, nwcErrorOther, "invalid amount")
+	}
+	ctx := context.WithValue(context.Background(), "publicLanguageCode", user.Telegram.LanguageCode)
+	event, err := bot.createInvoiceWithEvent(ctx, user, p.Amount/1000, p.Description, "", InvoiceCallbackGeneric, "")
+	if err != nil {
+		return nwcFail(method, nwcErrorInternal, "could not create invoice")
+	}
+	transaction := nwcTransaction{
+		Type:        "incoming",
+		Invoice:     event.PaymentRequest,
+		Description: p.Description,
+		PaymentHash: event.PaymentHash,
+		Amount:      event.Amount * 1000,
+		CreatedAt:   time.Now().Unix(),
+	}
+	if bolt11, err := decodepay.Decodepay(event.PaymentRequest); err == nil {
+		transaction.CreatedAt = int64(bolt11.CreatedAt)
+		transaction.ExpiresAt = int64(bolt11.CreatedAt + bolt11.Expiry)
+	}
+	return nwcResult(method, transaction)
+}
+
+// nwcLookupInvoice returns an invoice or a payment of the wallet.
+func (bot *TipBot) nwcLookupInvoice(user *lnbits.User, params json.RawMessage) *nwcResponse {
+	const method = "lookup_invoice"
+	var p struct {
+		PaymentHash string `json:"payment_hash"`
+		Invoice     string `json:"invoice"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nwcFail(method, nwcErrorOther, "invalid params")
+	}
+	if len(p.PaymentHash) == 0 {
+		bolt11, err := decodepay.Decodepay(p.Invoice)
+		if err != nil {
+			return nwcFail(method, nwcErrorOther, "invalid invoice")
+		}
+		p.PaymentHash = bolt11.PaymentHash
+	}
+	payment, err := bot.Client.Payment(*user.Wallet, p.PaymentHash)
+	if err != nil || len(payment.Details.PaymentHash) == 0 {
+		return nwcFail(method, nwcErrorNotFound, "invoice not found")
+	}
+	details := payment.Details
+	transaction := nwcTransaction{
+		Type:        "incoming",
+		Invoice:     details.Bolt11,
+		Description: details.Memo,
+		PaymentHash: details.PaymentHash,
+		Amount:      details.Amount,
+		FeesPaid:    details.Fee,
+		CreatedAt:   int64(details.Time),
+	}
+	if details.Amount < 0 {
+		transaction.Type = "outgoing"
+		transaction.Amount = -details.Amount
+	}
+	if transaction.FeesPaid < 0 {
+		transaction.FeesPaid = -transaction.FeesPaid
+	}
+	if bolt11, err := decodepay.Decodepay(details.Bolt11); err == nil {
+		transaction.ExpiresAt = int64(bolt11.CreatedAt + bolt11.Expiry)
+	}
+	if payment.Paid {
+		transaction.Preimage = payment.Preimage
+		transaction.SettledAt = int64(details.Time)
+	}
+	return nwcResult(method, transaction)
+}
@@ -0,0 +1,96 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/nbd-wtf/go-nostr"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestNostrWalletConnect(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9901, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	aliceUser := h.newUser(alice, 1000)
+	aliceUser.Initialized = true
+	if err := UpdateUserRecord(aliceUser, *h.bot); err != nil {
+		t.Fatal(err)
+	}
+	configuration := internal.Configuration
+	t.Cleanup(func() { internal.Configuration = configuration })
+	internal.Configuration.Nostr.PrivateKey = nostr.GeneratePrivateKey()
+	internal.Configuration.Nostr.WalletConnectRelay = "wss://relay.example.com"
+
+	h.sendMessage(alice, privateChat(alice), "/nwc new 50 Damus")
+	caption := strings.Trim(h.lastMessage(alice.ID).Text(), "`")
+	connectionString, err := url.Parse(caption)
+	if err != nil || connectionString.Scheme != "nostr+walletconnect" {
+		t.Fatalf("connection string = %q, %v", caption, err)
+	}
+	if relay := connectionString.Query().Get("relay"); relay != "wss://relay.example.com" {
+		t.Errorf("relay = %q", relay)
+	}
+	pubkey, err := nostr.GetPublicKey(connectionString.Query().Get("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection := &NwcConnection{}
+	if tx := h.bot.DB.Users.Where("pubkey = ?", pubkey).First(connection); tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	if connection.UserId != alice.ID || connection.Budget != 50 || connection.Name != "Damus" {
+		t.Errorf("connection = %+v", connection)
+	}
+
+	request := func(id string, method string, params interface{}) *nwcResponse {
+		body, _ := json.Marshal(params)
+		return h.bot.handleNwcRequest(id, pubkey, nwcPayload{Method: method, Params: body})
+	}
+	if response := request("balance", "get_balance", nil); response.Error != nil || response.Result.(map[string]int64)["balance"] != 1000000 {
+		t.Errorf("get_balance = %+v", response)
+	}
+
+	// payments within the budget go through
+	invoice, err := h.lnbits.ExternalInvoice(30, "zap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := request("pay-1", "pay_invoice", map[string]string{"invoice": invoice.PaymentRequest})
+	if response.Error != nil || len(response.Result.(map[string]interface{})["preimage"].(string)) == 0 {
+		t.Fatalf("pay_invoice = %+v", response)
+	}
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "30 sat") || !strings.Contains(text, "Damus") {
+		t.Errorf("payment notification = %q", text)
+	}
+	// a request is only handled once
+	if response := request("pay-1", "pay_invoice", map[string]string{"invoice": invoice.PaymentRequest}); response != nil {
+		t.Errorf("repeated pay_invoice = %+v", response)
+	}
+	// the budget is spent
+	invoice, err = h.lnbits.ExternalInvoice(30, "zap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response := request("pay-2", "pay_invoice", map[string]string{"invoice": invoice.PaymentRequest}); response.Error == nil || response.Error.Code != nwcErrorQuotaExceeded {
+		t.Errorf("pay_invoice over budget = %+v", response)
+	}
+	if balance, _ := h.bot.GetUserBalance(aliceUser); balance != 970 {
+		t.Errorf("balance = %d, want 970", balance)
+	}
+
+	h.sendMessage(alice, privateChat(alice), "/nwc")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "30 of 50 sat") {
+		t.Errorf("connections = %q", text)
+	}
+
+	// revoked connections are refused
+	h.sendMessage(alice, privateChat(alice), fmt.Sprintf("/nwc revoke %d", connection.ID))
+	if response := request("balance-2", "get_balance", nil); response.Error == nil || response.Error.Code != nwcErrorUnauthorized {
+		t.Errorf("get_balance after revoke = %+v", response)
+	}
+}
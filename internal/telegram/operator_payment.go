@@ -0,0 +1,360 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	AuditActionOperatorPayment       = "operator payment"
+	AuditActionOperatorPaymentReview = "operator payment review"
+
+	// operatorPaymentDefaultExpiry is how long a payment waits for a second admin if the
+	// configuration sets no expiry
+	operatorPaymentDefaultExpiry = 24 * time.Hour
+	// operatorPaymentCheckInterval is how often payments that ran out are expired
+	operatorPaymentCheckInterval = 10 * time.Minute
+
+	OperatorPaymentPending  = "pending"
+	OperatorPaymentPaid     = "paid"
+	OperatorPaymentRejected = "rejected"
+	OperatorPaymentExpired  = "expired"
+	OperatorPaymentFailed   = "failed"
+	// OperatorPaymentUnknown is a confirmed payment whose transfer may have gone through, the
+	// worker sets its final status once the outcome is known
+	OperatorPaymentUnknown = "unknown"
+)
+
+var (
+	operatorPaymentMenu       = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnApproveOperatorPayment = operatorPaymentMenu.Data("✅ Confirm", "approve_operator_payment")
+	btnRejectOperatorPayment  = operatorPaymentMenu.Data("❌ Reject", "reject_operator_payment")
+)
+
+// OperatorPayment is a payment from the wallet of a user that an operator made with /admin pay.
+// Payments from wallets of users and payments above the approval limit of the configuration
+// wait until a second admin confirms them, so that a single compromised operator account can't
+// drain wallets.
+type OperatorPayment struct {
+	ID           uint      `gorm:"primarykey"`
+	CreatedAt    time.Time `json:"created_at"`
+	RequesterId  int64     `json:"requester_id"`
+	Requester    string    `json:"requester"`
+	FromId       int64     `json:"from_id" gorm:"index"`
+	From         string    `json:"from"`
+	Recipient    string    `json:"recipient"`    // @username or lightning address
+	RecipientId  int64     `json:"recipient_id"` // zero for lightning addresses
+	Amount       int64     `json:"amount"`
+	Memo         string    `json:"memo"`
+	Status       string    `json:"status" gorm:"index"`
+	Reason       string    `json:"reason"` // why the payment failed
+	Expires      time.Time `json:"expires"`
+	Reviewer     string    `json:"reviewer"` // the second admin, empty below the approval limit
+	DecidedAt    time.Time `json:"decided_at"`
+	ChatId       int64     `json:"chat_id"`
+	MessageId    int       `json:"message_id"`
+	LanguageCode string    `json:"language_code"` // of the requester
+}
+
+// operatorPaymentNeedsApproval tells whether a payment of amount from the wallet of a user needs
+// a second admin. Only small payments from the wallets of the bot and of the operator don't.
+func (bot *TipBot) operatorPaymentNeedsApproval(fromId int64, amount int64) bool {
+	if !bot.isBotOwnedUser(fromId) {
+		return true
+	}
+	return amount > internal.Configuration.OperatorPayments.ApprovalAbove
+}
+
+func operatorPaymentExpiry() time.Duration {
+	if minutes := internal.Configuration.OperatorPayments.ApprovalExpiry; minutes > 0 {
+		return time.Duration(minutes) * time.Minute
+	}
+	return operatorPaymentDefaultExpiry
+}
+
+// operatorPaymentChat is where a second admin confirms payments: the admin chat of the
+// liquidity configuration or, if there is none, the operator.
+func operatorPaymentChat() int64 {
+	if chatId := internal.Configuration.Liquidity.AdminChatId; chatId != 0 {
+		return chatId
+	}
+	return internal.Configuration.Bot.OperatorId
+}
+
+// operatorPaymentStr shows a payment and its status.
+func operatorPaymentStr(payment *OperatorPayment) string {
+	translate := func(key string) string { return i18n.Translate(payment.LanguageCode, key) }
+	text := fmt.Sprintf(translate("operatorPaymentMessage"), payment.ID, str.MarkdownEscape(payment.Requester), payment.Amount,
+		str.MarkdownEscape(payment.From), str.MarkdownEscape(payment.Recipient))
+	if len(payment.Memo) > 0 {
+		text += fmt.Sprintf(translate("operatorPaymentMemoMessage"), str.MarkdownEscape(payment.Memo))
+	}
+	switch payment.Status {
+	case OperatorPaymentPending:
+		text += fmt.Sprintf(translate("operatorPaymentPendingMessage"), payment.Expires.UTC().Format(dropTimeLayout))
+	case OperatorPaymentPaid:
+		text += fmt.Sprintf(translate("operatorPaymentPaidMessage"), str.MarkdownEscape(payment.Reviewer))
+	case OperatorPaymentRejected:
+		text += fmt.Sprintf(translate("operatorPaymentRejectedMessage"), str.MarkdownEscape(payment.Reviewer))
+	case OperatorPaymentExpired:
+		text += translate("operatorPaymentExpiredMessage")
+	case OperatorPaymentFailed:
+		text += fmt.Sprintf(translate("operatorPaymentFailedMessage"), str.MarkdownEscape(payment.Reviewer), str.MarkdownEscape(payment.Reason))
+	case OperatorPaymentUnknown:
+		text += fmt.Sprintf(translate("operatorPaymentUnknownMessage"), str.MarkdownEscape(payment.Reviewer))
+	}
+	return text
+}
+
+func makeOperatorPaymentKeyboard(payment *OperatorPayment) *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	id := strconv.FormatUint(uint64(payment.ID), 10)
+	menu.Inline(menu.Row(
+		menu.Data(i18n.Translate(payment.LanguageCode, "operatorPaymentConfirmButtonMessage"), btnApproveOperatorPayment.Unique, id),
+		menu.Data(i18n.Translate(payment.LanguageCode, "operatorPaymentRejectButtonMessage"), btnRejectOperatorPayment.Unique, id)))
+	return menu
+}
+
+// adminPayHandler is invoked on /admin pay <@from> <amount> <@user|lightning address> [memo].
+// Payments from wallets of users and payments above the approval limit are posted to the admin
+// chat and only paid once a second admin confirmed them there.
+func (bot *TipBot) adminPayHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	splits := strings.Fields(m.Text)
+	help := fmt.Sprintf(Translate(ctx, "adminPayHelpMessage"), internal.Configuration.OperatorPayments.ApprovalAbove)
+	if len(splits) < 5 {
+		bot.trySendMessage(m.Sender, help)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(splits[3])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, help)
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	from, err := GetUserByTelegramUsername(strings.TrimPrefix(splits[2], "@"), *bot)
+	if err != nil || from.Telegram == nil || from.Wallet == nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "adminPayNotFoundMessage"), str.MarkdownEscape(splits[2])))
+		return ctx, fmt.Errorf("user %s not found", splits[2])
+	}
+	payment := &OperatorPayment{
+		CreatedAt:    time.Now(),
+		RequesterId:  m.Sender.ID,
+		Requester:    GetUserStr(m.Sender),
+		FromId:       from.Telegram.ID,
+		From:         GetUserStr(from.Telegram),
+		Amount:       amount,
+		Memo:         strings.Join(splits[5:], " "),
+		Status:       OperatorPaymentPending,
+		Expires:      time.Now().Add(operatorPaymentExpiry()),
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	if recipient := splits[4]; isLightningAddress(recipient) {
+		payment.Recipient = strings.ToLower(recipient)
+	} else {
+		to, err := GetUserByTelegramUsername(strings.TrimPrefix(recipient, "@"), *bot)
+		if err != nil || to.Telegram == nil || to.Wallet == nil {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "adminPayNotFoundMessage"), str.MarkdownEscape(recipient)))
+			return ctx, fmt.Errorf("user %s not found", recipient)
+		}
+		payment.Recipient, payment.RecipientId = GetUserStr(to.Telegram), to.Telegram.ID
+	}
+
+	needsApproval := bot.operatorPaymentNeedsApproval(from.Telegram.ID, amount)
+	if needsApproval {
+		payment.ChatId = operatorPaymentChat()
+		if payment.ChatId == 0 || payment.ChatId == m.Sender.ID {
+			bot.trySendMessage(m.Sender, Translate(ctx, "adminPayNoSecondAdminMessage"))
+			return ctx, fmt.Errorf("no second admin for the payment of %s", GetUserStr(m.Sender))
+		}
+	}
+	if tx := bot.DB.Transactions.Create(payment); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	bot.Audit(auditActor(m.Sender), AuditActionOperatorPayment, auditActor(from.Telegram),
+		fmt.Sprintf("#%d: %d sat to %s, approval needed: %t", payment.ID, amount, payment.Recipient, needsApproval))
+	if !needsApproval {
+		bot.executeOperatorPayment(payment)
+		bot.trySendMessage(m.Sender, operatorPaymentStr(payment))
+		return ctx, nil
+	}
+	message := bot.trySendMessage(&tb.Chat{ID: payment.ChatId}, operatorPaymentStr(payment), makeOperatorPaymentKeyboard(payment))
+	if message != nil {
+		payment.MessageId = message.ID
+		bot.DB.Transactions.Save(payment)
+	}
+	log.Infof("[adminPay] %s requested payment #%d of %d sat from %s", GetUserStr(m.Sender), payment.ID, amount, payment.From)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "adminPayRequestedMessage"), payment.ID, payment.Expires.UTC().Format(dropTimeLayout)))
+	return ctx, nil
+}
+
+// approveOperatorPaymentHandler is invoked when a second admin confirms a payment.
+func (bot *TipBot) approveOperatorPaymentHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.reviewOperatorPayment(ctx, true)
+}
+
+// rejectOperatorPaymentHandler is invoked when a second admin rejects a payment.
+func (bot *TipBot) rejectOperatorPaymentHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.reviewOperatorPayment(ctx, false)
+}
+
+// reviewOperatorPayment pays or rejects a pending payment. Only admins of the bot other than
+// the one who requested the payment decide on it.
+func (bot *TipBot) reviewOperatorPayment(ctx intercept.Context, approve bool) (intercept.Context, error) {
+	id, err := strconv.ParseUint(ctx.Data(), 10, 64)
+	if err != nil {
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	sender := ctx.Sender()
+	if operatorRole(sender.ID) < RoleAdmin {
+		bot.Audit(auditActor(sender), AuditActionAdminDenied, fmt.Sprintf("operator payment #%d", id), operatorRole(sender.ID).String())
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "operatorPaymentNotAdminMessage"))
+		return ctx, fmt.Errorf("%s is no admin", GetUserStr(sender))
+	}
+	key := fmt.Sprintf("operator-payment:%d", id)
+	mutex.LockWithContext(ctx, key)
+	defer mutex.UnlockWithContext(ctx, key)
+	payment := &OperatorPayment{}
+	if tx := bot.DB.Transactions.First(payment, id); tx.Error != nil || payment.Status != OperatorPaymentPending {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if sender.ID == payment.RequesterId {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "operatorPaymentRequesterMessage"))
+		return ctx, fmt.Errorf("%s can't review their own payment #%d", GetUserStr(sender), payment.ID)
+	}
+	if time.Now().After(payment.Expires) {
+		bot.decideOperatorPayment(payment, OperatorPaymentExpired)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	payment.Reviewer = GetUserStr(sender)
+	bot.Audit(auditActor(sender), AuditActionOperatorPaymentReview, fmt.Sprintf("#%d", payment.ID), fmt.Sprintf("confirmed: %t", approve))
+	if approve {
+		bot.executeOperatorPayment(payment)
+	} else {
+		bot.decideOperatorPayment(payment, OperatorPaymentRejected)
+	}
+	ctx.Context = context.WithValue(ctx, "callback_response", fmt.Sprintf(Translate(ctx, "operatorPaymentDecidedMessage"), payment.ID, payment.Status))
+	bot.trySendMessage(&tb.User{ID: payment.RequesterId}, fmt.Sprintf(i18n.Translate(payment.LanguageCode, "adminPayResultMessage"), payment.ID, payment.Status))
+	return ctx, nil
+}
+
+// decideOperatorPayment stores the final status of a payment and shows it in the admin chat.
+func (bot *TipBot) decideOperatorPayment(payment *OperatorPayment, status string) {
+	payment.Status, payment.DecidedAt = status, time.Now()
+	if tx := bot.DB.Transactions.Save(payment); tx.Error != nil {
+		log.Errorf("[operatorPayment] could not save payment %d: %v", payment.ID, tx.Error)
+	}
+	log.Infof("[operatorPayment] payment #%d of %d sat from %s is %s", payment.ID, payment.Amount, payment.From, status)
+	if payment.MessageId != 0 {
+		message := &tb.StoredMessage{MessageID: strconv.Itoa(payment.MessageId), ChatID: payment.ChatId}
+		bot.tryEditMessage(message, operatorPaymentStr(payment), &tb.ReplyMarkup{})
+	}
+}
+
+// executeOperatorPayment pays a payment from the wallet of the user.
+func (bot *TipBot) executeOperatorPayment(payment *OperatorPayment) {
+	fail := func(err error) {
+		payment.Reason = err.Error()
+		bot.decideOperatorPayment(payment, OperatorPaymentFailed)
+	}
+	from, err := GetLnbitsUser(&tb.User{ID: payment.FromId}, *bot)
+	if err != nil || from.Wallet == nil {
+		fail(fmt.Errorf("no wallet"))
+		return
+	}
+	memo := fmt.Sprintf("Operator payment #%d.", payment.ID)
+	if len(payment.Memo) > 0 {
+		memo = payment.Memo
+	}
+	if payment.RecipientId == 0 {
+		if _, err := bot.payLightningAddress(from, payment.Recipient, payment.Amount, memo); err != nil {
+			fail(err)
+			return
+		}
+		bot.decideOperatorPayment(payment, OperatorPaymentPaid)
+		return
+	}
+	to, err := GetLnbitsUser(&tb.User{ID: payment.RecipientId}, *bot)
+	if err != nil || to.Wallet == nil {
+		fail(fmt.Errorf("no wallet"))
+		return
+	}
+	t := NewTransaction(bot, from, to, payment.Amount, TransactionType("operator payment"),
+		TransactionIdempotencyKey(fmt.Sprintf("operator-payment:%d", payment.ID)))
+	t.Memo = memo
+	success, err := t.Send()
+	switch {
+	case success || err == errDuplicateOperation:
+		bot.decideOperatorPayment(payment, OperatorPaymentPaid)
+	case err == errOperationInProgress:
+		// the transfer may have gone through, it must not be paid again
+		payment.Reason = err.Error()
+		bot.decideOperatorPayment(payment, OperatorPaymentUnknown)
+	default:
+		fail(err)
+	}
+}
+
+// startOperatorPaymentWorker expires the payments that no second admin decided on in time and
+// resolves the payments whose outcome is unknown.
+func (bot *TipBot) startOperatorPaymentWorker() {
+	go func() {
+		for {
+			if !isShuttingDown() {
+				bot.expireOperatorPayments(time.Now())
+				bot.resolveOperatorPayments()
+			}
+			time.Sleep(operatorPaymentCheckInterval)
+		}
+	}()
+}
+
+// resolveOperatorPayments sets the final status of the payments whose outcome was unknown once
+// their transfer completed or was released.
+func (bot *TipBot) resolveOperatorPayments() {
+	payments := make([]OperatorPayment, 0)
+	bot.DB.Transactions.Where("status = ?", OperatorPaymentUnknown).Find(&payments)
+	for _, payment := range payments {
+		key := fmt.Sprintf("operator-payment:%d", payment.ID)
+		mutex.Lock(key)
+		if tx := bot.DB.Transactions.First(&payment, payment.ID); tx.Error == nil && payment.Status == OperatorPaymentUnknown {
+			claimed := &IdempotencyKey{}
+			switch tx := bot.DB.Transactions.Where("key = ?", key).Limit(1).Find(claimed); {
+			case tx.Error != nil:
+				log.Errorf("[operatorPayment] could not check payment %d: %v", payment.ID, tx.Error)
+			case tx.RowsAffected == 0:
+				// the transfer failed and released its key
+				payment.Reason = "the transfer did not go through"
+				bot.decideOperatorPayment(&payment, OperatorPaymentFailed)
+			case claimed.Done:
+				bot.decideOperatorPayment(&payment, OperatorPaymentPaid)
+			}
+		}
+		mutex.Unlock(key)
+	}
+}
+
+// expireOperatorPayments expires the payments that no second admin decided on in time.
+func (bot *TipBot) expireOperatorPayments(now time.Time) {
+	payments := make([]OperatorPayment, 0)
+	bot.DB.Transactions.Where("status = ? AND expires < ?", OperatorPaymentPending, now).Find(&payments)
+	for _, payment := range payments {
+		key := fmt.Sprintf("operator-payment:%d", payment.ID)
+		mutex.Lock(key)
+		if tx := bot.DB.Transactions.First(&payment, payment.ID); tx.Error == nil && payment.Status == OperatorPaymentPending {
+			bot.decideOperatorPayment(&payment, OperatorPaymentExpired)
+		}
+		mutex.Unlock(key)
+	}
+}
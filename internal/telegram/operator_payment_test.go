@@ -0,0 +1,126 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestOperatorPaymentApproval(t *testing.T) {
+	h := newTestHarness(t)
+	owner := &tb.User{ID: 9931, Username: "owner", FirstName: "Owner", LanguageCode: "en"}
+	admin := &tb.User{ID: 9932, Username: "admin", FirstName: "Admin", LanguageCode: "en"}
+	support := &tb.User{ID: 9933, Username: "support", FirstName: "Support", LanguageCode: "en"}
+	alice := &tb.User{ID: 9934, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9935, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	admins := &tb.Chat{ID: -9930, Type: tb.ChatGroup, Title: "admins"}
+	operator := h.newUser(owner, 1000)
+	h.newUser(admin, 0)
+	h.newUser(support, 0)
+	from := h.newUser(alice, 5000)
+	to := h.newUser(bob, 0)
+	bot, liquidity, payments := internal.Configuration.Bot, internal.Configuration.Liquidity, internal.Configuration.OperatorPayments
+	t.Cleanup(func() {
+		internal.Configuration.Bot, internal.Configuration.Liquidity, internal.Configuration.OperatorPayments = bot, liquidity, payments
+	})
+	internal.Configuration.Bot.OperatorId = owner.ID
+	internal.Configuration.Bot.Operators = map[int64]string{admin.ID: "admin", support.ID: "support"}
+	internal.Configuration.OperatorPayments = internal.OperatorPaymentConfiguration{ApprovalAbove: 1000}
+
+	// small payments from the wallet of the operator are paid right away
+	h.sendMessage(owner, privateChat(owner), "/admin pay @owner 500 @bob refund")
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 500 {
+		t.Fatalf("balance of bob = %d, want 500", balance)
+	}
+	if balance := h.lnbits.Balance(operator.Wallet.ID); balance != 500 {
+		t.Fatalf("balance of the operator = %d, want 500", balance)
+	}
+
+	// without an admin chat, the owner has nobody who could confirm, even small payments
+	// from the wallets of users
+	h.sendMessage(owner, privateChat(owner), "/admin pay @alice 500 @bob")
+	if text := h.lastMessage(owner.ID).Text(); !strings.Contains(text, "needs a second admin") {
+		t.Fatalf("message = %q", text)
+	}
+	h.sendMessage(owner, privateChat(owner), "/admin pay @alice 2000 @bob")
+	if text := h.lastMessage(owner.ID).Text(); !strings.Contains(text, "needs a second admin") {
+		t.Fatalf("message = %q", text)
+	}
+
+	internal.Configuration.Liquidity.AdminChatId = admins.ID
+	h.sendMessage(owner, privateChat(owner), "/admin pay @alice 2000 @bob")
+	if text := h.lastMessage(admins.ID).Text(); !strings.Contains(text, "2000 sat from @alice to @bob") {
+		t.Fatalf("request = %q", text)
+	}
+	// neither the requester nor support can confirm
+	h.pressButton(owner, h.lastMessage(admins.ID), "✅ Confirm")
+	h.pressButton(support, h.lastMessage(admins.ID), "✅ Confirm")
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 500 {
+		t.Fatalf("balance of bob = %d before a second admin confirmed, want 500", balance)
+	}
+	h.pressButton(admin, h.lastMessage(admins.ID), "✅ Confirm")
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 2500 {
+		t.Errorf("balance of bob = %d, want 2500", balance)
+	}
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 3000 {
+		t.Errorf("balance of alice = %d, want 3000", balance)
+	}
+	if text := h.lastMessage(admins.ID).Text(); !strings.Contains(text, "Confirmed by @admin and paid") {
+		t.Errorf("request = %q", text)
+	}
+
+	h.sendMessage(admin, privateChat(admin), "/admin pay @alice 1500 @bob")
+	h.pressButton(owner, h.lastMessage(admins.ID), "❌ Reject")
+	if text := h.lastMessage(admins.ID).Text(); !strings.Contains(text, "Rejected by @owner") {
+		t.Errorf("request = %q", text)
+	}
+
+	h.sendMessage(admin, privateChat(admin), "/admin pay @alice 1500 @bob")
+	h.bot.expireOperatorPayments(time.Now().Add(operatorPaymentDefaultExpiry + time.Minute))
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if text := h.lastMessage(admins.ID).Text(); !strings.Contains(text, "Expired") {
+		t.Errorf("request = %q", text)
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 2500 {
+		t.Errorf("balance of bob = %d after a rejection and an expiry, want 2500", balance)
+	}
+
+	// a transfer that may have gone through is not failed, so that nobody pays it again
+	payment := &OperatorPayment{CreatedAt: time.Now(), RequesterId: owner.ID, FromId: alice.ID, From: "@alice", Recipient: "@bob", RecipientId: bob.ID,
+		Amount: 100, Status: OperatorPaymentPending, Expires: time.Now().Add(time.Hour), LanguageCode: "en"}
+	if tx := h.bot.DB.Transactions.Create(payment); tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	key := fmt.Sprintf("operator-payment:%d", payment.ID)
+	if err := h.bot.claimIdempotencyKey(key); err != nil {
+		t.Fatal(err)
+	}
+	h.bot.executeOperatorPayment(payment)
+	if payment.Status != OperatorPaymentUnknown {
+		t.Fatalf("status = %q while the transfer is in progress, want %q", payment.Status, OperatorPaymentUnknown)
+	}
+	h.bot.resolveOperatorPayments()
+	h.bot.completeIdempotencyKey(key)
+	h.bot.resolveOperatorPayments()
+	if tx := h.bot.DB.Transactions.First(payment, payment.ID); tx.Error != nil || payment.Status != OperatorPaymentPaid {
+		t.Errorf("status = %q, %v after the transfer completed, want %q", payment.Status, tx.Error, OperatorPaymentPaid)
+	}
+
+	entries, err := h.bot.AuditLog(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reviews := 0
+	for _, entry := range entries {
+		if entry.Action == AuditActionOperatorPaymentReview {
+			reviews++
+		}
+	}
+	if reviews != 2 {
+		t.Errorf("%d reviews in the audit log, want 2", reviews)
+	}
+}
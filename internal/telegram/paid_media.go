@@ -0,0 +1,413 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	PaidMediaKindPhoto     = "photo"
+	PaidMediaKindVideo     = "video"
+	PaidMediaKindAnimation = "animation"
+	PaidMediaKindDocument  = "document"
+)
+
+var (
+	paidMediaMenu      = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnUnlockPaidMedia = paidMediaMenu.Data("🔓 Unlock", "unlock_paid_media")
+)
+
+// PaidMedia is a photo, video or file that buyers unlock with an invoice of its seller.
+type PaidMedia struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	SellerId  int64     `json:"seller_id" gorm:"index"`
+	Title     string    `json:"title"`
+	Kind      string    `json:"kind"`
+	FileId    string    `json:"-"` // encrypted with the content key, see paywall.go
+	Price     int64     `json:"price"`
+	Sales     int64     `json:"sales"`
+	Revenue   int64     `json:"revenue"`
+	Active    bool      `json:"active"`
+	// ContentKey is the key of the file id, encrypted with the paywall key
+	ContentKey string `json:"-"`
+	// FreeAt is set for drops, media that everyone gets for free after this time
+	FreeAt   *time.Time `json:"free_at" gorm:"index"`
+	Released bool       `json:"released"`
+}
+
+// PaidMediaSale is a paid unlock of a paid media. Buyers unlock a media once.
+type PaidMediaSale struct {
+	ID          uint      `gorm:"primarykey"`
+	Time        time.Time `json:"time"`
+	MediaId     uint      `json:"media_id" gorm:"index"`
+	SellerId    int64     `json:"seller_id" gorm:"index"`
+	BuyerId     int64     `json:"buyer_id" gorm:"index"`
+	Amount      int64     `json:"amount"`
+	PaymentHash string    `json:"payment_hash"`
+}
+
+func helpPaidMediaUsage(ctx intercept.Context, errormsg string) string {
+	return fmt.Sprintf(Translate(ctx, "ppvHelpText"), errormsg)
+}
+
+// loadPaidMedia loads a paid media by the ID in a command or button.
+func (bot *TipBot) loadPaidMedia(id string) (*PaidMedia, error) {
+	mediaId, err := strconv.ParseUint(strings.TrimPrefix(id, "#"), 10, 64)
+	if err != nil {
+		return nil, errors.Create(errors.InvalidSyntaxError)
+	}
+	media := &PaidMedia{}
+	if tx := bot.DB.Transactions.First(media, mediaId); tx.Error != nil {
+		return nil, tx.Error
+	}
+	return media, nil
+}
+
+// paidMediaOf returns the kind and file of the media in m.
+func paidMediaOf(m *tb.Message) (kind string, fileId string) {
+	switch {
+	case m.Photo != nil:
+		return PaidMediaKindPhoto, m.Photo.FileID
+	case m.Video != nil:
+		return PaidMediaKindVideo, m.Video.FileID
+	case m.Animation != nil:
+		return PaidMediaKindAnimation, m.Animation.FileID
+	case m.Document != nil:
+		return PaidMediaKindDocument, m.Document.FileID
+	}
+	return "", ""
+}
+
+// paidMediaKindStr names the kind of a paid media, like "📷 Photo".
+func paidMediaKindStr(languageCode string, kind string) string {
+	switch kind {
+	case PaidMediaKindVideo:
+		return i18n.Translate(languageCode, "ppvVideo")
+	case PaidMediaKindAnimation:
+		return i18n.Translate(languageCode, "ppvAnimation")
+	case PaidMediaKindDocument:
+		return i18n.Translate(languageCode, "ppvDocument")
+	}
+	return i18n.Translate(languageCode, "ppvPhoto")
+}
+
+// isFree returns true if everyone gets the media for free.
+func (media *PaidMedia) isFree() bool {
+	return media.Released || (media.FreeAt != nil && !time.Now().Before(*media.FreeAt))
+}
+
+// sendable decrypts the media to send it to a buyer.
+func (media *PaidMedia) sendable() (interface{}, error) {
+	fileId, err := media.fileId()
+	if err != nil {
+		return nil, err
+	}
+	file := tb.File{FileID: fileId}
+	caption := fmt.Sprintf("🔓 %s", media.Title)
+	switch media.Kind {
+	case PaidMediaKindVideo:
+		return &tb.Video{File: file, Caption: caption}, nil
+	case PaidMediaKindAnimation:
+		return &tb.Animation{File: file, Caption: caption}, nil
+	case PaidMediaKindDocument:
+		return &tb.Document{File: file, Caption: caption}, nil
+	}
+	return &tb.Photo{File: file, Caption: caption}, nil
+}
+
+// sendPaidMedia sends the media to a user who may see it.
+func (bot *TipBot) sendPaidMedia(to *tb.User, media *PaidMedia) error {
+	what, err := media.sendable()
+	if err != nil {
+		log.Errorf("[sendPaidMedia] could not open %d: %v", media.ID, err)
+		bot.trySendMessage(to, i18n.Translate(to.LanguageCode, "errorTryLaterMessage"))
+		return err
+	}
+	bot.trySendMessage(to, what)
+	return nil
+}
+
+// isPaidMediaCaption returns true if the caption of m adds it as a paid media, like a photo with /ppv 500.
+func (bot *TipBot) isPaidMediaCaption(m *tb.Message) bool {
+	return m.Private() && bot.commandName(m.Caption) == "ppv"
+}
+
+// ppvHandler is invoked on /ppv. Sellers list their paid media with /ppv and remove one with
+// /ppv remove <id>. /ppv <id> shows the preview of a paid media in any chat.
+func (bot *TipBot) ppvHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	arg, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		arg = "list"
+	}
+	if _, err := strconv.ParseUint(strings.TrimPrefix(arg, "#"), 10, 64); err == nil {
+		return bot.showPaidMediaHandler(ctx, arg)
+	}
+	if m.Chat.Type != tb.ChatPrivate {
+		bot.tryDeleteMessage(m)
+		bot.trySendMessage(m.Sender, helpPaidMediaUsage(ctx, Translate(ctx, "ppvPrivateMessage")))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	switch strings.ToLower(arg) {
+	case "list":
+		return bot.listPaidMediaHandler(ctx)
+	case "remove":
+		return bot.removePaidMediaHandler(ctx)
+	}
+	bot.trySendMessage(m.Sender, helpPaidMediaUsage(ctx, ""))
+	return ctx, errors.Create(errors.InvalidSyntaxError)
+}
+
+// addPaidMediaHandler is invoked on a photo, video or file in the private chat with the caption
+// /ppv <price> [<title>] [free <date>]. It adds the media and shows its preview. Media with a
+// date are drops that everyone gets for free after it.
+func (bot *TipBot) addPaidMediaHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if user == nil || user.Wallet == nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "ppvNoWalletMessage"))
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if _, err := paywallKey(); err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "ppvNotAvailableMessage"))
+		return ctx, err
+	}
+	kind, fileId := paidMediaOf(m)
+	args := strings.SplitN(strings.TrimSpace(m.Caption), " ", 3)
+	if len(kind) == 0 || len(args) < 2 {
+		bot.trySendMessage(m.Sender, helpPaidMediaUsage(ctx, ""))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	price, err := GetAmount(args[1])
+	if err != nil || price < 1 {
+		bot.trySendMessage(m.Sender, helpPaidMediaUsage(ctx, Translate(ctx, "ppvInvalidPriceMessage")))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	title := paidMediaKindStr(ctx.Value("publicLanguageCode").(string), kind)
+	rest := ""
+	if len(args) == 3 {
+		rest = args[2]
+	}
+	rest, freeAt, err := parseDropTime(rest)
+	if err != nil {
+		bot.trySendMessage(m.Sender, helpPaidMediaUsage(ctx, Translate(ctx, "ppvInvalidDropMessage")))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if len(rest) > 0 {
+		title = rest
+	}
+	if runes := []rune(title); len(runes) > productTitleMaxLength {
+		title = string(runes[:productTitleMaxLength]) + "..."
+	}
+	media := &PaidMedia{
+		CreatedAt: time.Now(),
+		SellerId:  m.Sender.ID,
+		Title:     title,
+		Kind:      kind,
+		Price:     price,
+		Active:    true,
+		FreeAt:    freeAt,
+	}
+	if err := sealPaidMedia(media, fileId); err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	if tx := bot.DB.Transactions.Create(media); tx.Error != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, tx.Error
+	}
+	logger(ctx).Infof("[/ppv] %s added %s %d %s for %d sat", GetUserStr(m.Sender), kind, media.ID, title, price)
+	added := fmt.Sprintf(Translate(ctx, "ppvAddedMessage"), str.MarkdownEscape(title), price, media.ID)
+	if freeAt != nil {
+		added += fmt.Sprintf(Translate(ctx, "ppvDropAddedMessage"), freeAt.UTC().Format(dropTimeLayout))
+	}
+	bot.trySendMessage(m.Sender, added)
+	bot.sendPaidMediaPreview(ctx, m.Chat, media, user)
+	return ctx, nil
+}
+
+// paidMediaPreview returns the preview of a paid media with a button to unlock it. The
+// preview doesn't contain the media, buyers get it in the private chat.
+func paidMediaPreview(languageCode string, media *PaidMedia, seller *tb.User) (string, *tb.ReplyMarkup) {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	id := strconv.FormatUint(uint64(media.ID), 10)
+	kind := paidMediaKindStr(languageCode, media.Kind)
+	if media.isFree() {
+		menu.Inline(menu.Row(menu.Data(i18n.Translate(languageCode, "ppvFreeButtonMessage"), "unlock_paid_media", id)))
+		return fmt.Sprintf(i18n.Translate(languageCode, "ppvFreePreviewMessage"), str.MarkdownEscape(media.Title), kind, GetUserStrMd(seller)), menu
+	}
+	menu.Inline(menu.Row(menu.Data(fmt.Sprintf(i18n.Translate(languageCode, "ppvUnlockButtonMessage"), media.Price), "unlock_paid_media", id)))
+	if media.FreeAt != nil {
+		return fmt.Sprintf(i18n.Translate(languageCode, "ppvDropPreviewMessage"), str.MarkdownEscape(media.Title), kind, GetUserStrMd(seller), dropCountdownStr(time.Until(*media.FreeAt)), media.Price), menu
+	}
+	return fmt.Sprintf(i18n.Translate(languageCode, "ppvPreviewMessage"), str.MarkdownEscape(media.Title), kind, GetUserStrMd(seller), media.Price), menu
+}
+
+// sendPaidMediaPreview posts the preview of a paid media. The bot keeps the countdown in the
+// previews of drops up to date.
+func (bot *TipBot) sendPaidMediaPreview(ctx intercept.Context, to tb.Recipient, media *PaidMedia, seller *lnbits.User) {
+	languageCode := ctx.Value("publicLanguageCode").(string)
+	text, menu := paidMediaPreview(languageCode, media, seller.Telegram)
+	preview := bot.trySendMessage(to, text, menu)
+	if preview != nil && media.FreeAt != nil && !media.isFree() {
+		bot.saveDropPreview(media, preview, languageCode, text)
+	}
+}
+
+// showPaidMediaHandler is invoked on /ppv <id> and shows the preview of the paid media.
+func (bot *TipBot) showPaidMediaHandler(ctx intercept.Context, id string) (intercept.Context, error) {
+	m := ctx.Message()
+	media, err := bot.loadPaidMedia(id)
+	if err != nil || !media.Active {
+		bot.trySendMessage(m.Sender, helpPaidMediaUsage(ctx, Translate(ctx, "ppvUnknownMessage")))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	seller, err := GetLnbitsUser(&tb.User{ID: media.SellerId}, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	bot.sendPaidMediaPreview(ctx, bot.topicOf(m), media, seller)
+	return ctx, nil
+}
+
+// listPaidMediaHandler lists the active paid media of the seller with their sales.
+func (bot *TipBot) listPaidMediaHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	medias := make([]PaidMedia, 0)
+	bot.DB.Transactions.Where("seller_id = ? AND active = ?", m.Sender.ID, true).Order("id").Find(&medias)
+	if len(medias) == 0 {
+		bot.trySendMessage(m.Sender, helpPaidMediaUsage(ctx, Translate(ctx, "ppvNoMediaMessage")))
+		return ctx, nil
+	}
+	list := ""
+	for _, media := range medias {
+		list += fmt.Sprintf(Translate(ctx, "ppvListEntry"), media.ID, str.MarkdownEscape(media.Title), media.Price, media.Sales, media.Revenue)
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "ppvListMessage"), list))
+	return ctx, nil
+}
+
+// removePaidMediaHandler is invoked on /ppv remove <id>. Buyers who unlocked it keep their copy.
+func (bot *TipBot) removePaidMediaHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	id, _ := getArgumentFromCommand(m.Text, 2)
+	media, err := bot.loadPaidMedia(id)
+	if err != nil || !media.Active || media.SellerId != m.Sender.ID {
+		bot.trySendMessage(m.Sender, helpPaidMediaUsage(ctx, Translate(ctx, "ppvUnknownMessage")))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if tx := bot.DB.Transactions.Model(media).Update("active", false); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "ppvRemovedMessage"), str.MarkdownEscape(media.Title)))
+	return ctx, nil
+}
+
+// hasUnlocked returns true if the user may see the paid media without paying for it.
+func (bot *TipBot) hasUnlocked(media *PaidMedia, userId int64) bool {
+	if media.SellerId == userId {
+		return true
+	}
+	var count int64
+	bot.DB.Transactions.Model(&PaidMediaSale{}).Where("media_id = ? AND buyer_id = ?", media.ID, userId).Count(&count)
+	return count > 0
+}
+
+// unlockPaidMediaHandler sends the media to users who unlocked it before and an invoice of the
+// seller to everyone else, in the private chat.
+func (bot *TipBot) unlockPaidMediaHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	media, err := bot.loadPaidMedia(ctx.Data())
+	if err != nil || !media.Active {
+		bot.trySendMessage(c.Sender, Translate(ctx, "ppvUnknownMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if media.isFree() || bot.hasUnlocked(media, c.Sender.ID) {
+		return ctx, bot.sendPaidMedia(c.Sender, media)
+	}
+	seller, err := GetLnbitsUser(&tb.User{ID: media.SellerId}, *bot)
+	if err != nil || seller.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	memo := fmt.Sprintf("🔓 %s from %s", media.Title, GetUserStr(seller.Telegram))
+	invoice, err := bot.createInvoiceWithEvent(ctx, seller, media.Price, memo, "", InvoiceCallbackPaidMedia, strconv.FormatUint(uint64(media.ID), 10))
+	if err != nil {
+		bot.trySendMessage(c.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	qr, err := qrcode.Encode(invoice.PaymentRequest, qrcode.Medium, 256)
+	if err != nil {
+		bot.trySendMessage(c.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	caption := fmt.Sprintf(Translate(ctx, "ppvInvoiceMessage"), str.MarkdownEscape(media.Title), media.Price, invoice.PaymentRequest)
+	invoice.Payer = LoadUser(ctx)
+	invoice.InvoiceMessage = bot.trySendMessage(c.Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: caption})
+	runtime.IgnoreError(bot.Bunt.Set(invoice))
+	logger(ctx).WithField("payment_hash", invoice.PaymentHash).Infof("[/ppv] %s wants to unlock %d of %s", GetUserStr(c.Sender), media.ID, GetUserStr(seller.Telegram))
+	return ctx, nil
+}
+
+// paidMediaEvent is invoked when the invoice of a paid media is paid. It counts the sale and
+// sends the media to the buyer.
+func (bot *TipBot) paidMediaEvent(event Event) {
+	invoiceEvent := event.(*InvoiceEvent)
+	id := fmt.Sprintf("paid-media:%s", invoiceEvent.CallbackData)
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	media, err := bot.loadPaidMedia(invoiceEvent.CallbackData)
+	if err != nil {
+		log.Errorf("[paidMediaEvent] %s: %v", id, err)
+		return
+	}
+	if invoiceEvent.Payer == nil || invoiceEvent.Payer.Telegram == nil {
+		log.Errorf("[paidMediaEvent] %s was paid without a buyer", id)
+		return
+	}
+	buyer := invoiceEvent.Payer.Telegram
+	sale := &PaidMediaSale{
+		Time:        time.Now(),
+		MediaId:     media.ID,
+		SellerId:    media.SellerId,
+		BuyerId:     buyer.ID,
+		Amount:      invoiceEvent.Amount,
+		PaymentHash: invoiceEvent.PaymentHash,
+	}
+	if tx := bot.DB.Transactions.Create(sale); tx.Error != nil {
+		log.Errorf("[paidMediaEvent] could not store sale of %d: %v", media.ID, tx.Error)
+	}
+	media.Sales++
+	media.Revenue += invoiceEvent.Amount
+	if tx := bot.DB.Transactions.Model(media).Updates(map[string]interface{}{"sales": media.Sales, "revenue": media.Revenue}); tx.Error != nil {
+		log.Errorf("[paidMediaEvent] could not update %d: %v", media.ID, tx.Error)
+	}
+
+	seller := invoiceEvent.User
+	// label the payment in /transactions
+	bot.saveCounterparty(invoiceEvent.PaymentHash, seller.Wallet.ID, CounterpartyTypeTelegram, GetUserStr(buyer))
+	bot.savePaymentComment(invoiceEvent.PaymentHash, seller.Wallet.ID, fmt.Sprintf("🔓 Unlock: %s", media.Title))
+	log.Infof("[🔓 ppv] %s unlocked %s of %s for %d sat", GetUserStr(buyer), media.Title, GetUserStr(seller.Telegram), invoiceEvent.Amount)
+
+	if invoiceEvent.InvoiceMessage != nil {
+		if _, err := bot.Telegram.EditCaption(invoiceEvent.InvoiceMessage, i18n.Translate(invoiceEvent.LanguageCode, "invoicePaidText")); err != nil {
+			log.Warnln(err.Error())
+		}
+	}
+	bot.sendPaidMedia(buyer, media)
+	bot.trySendMessage(seller.Telegram, fmt.Sprintf(i18n.Translate(seller.Telegram.LanguageCode, "ppvSoldMessage"), GetUserStrMd(buyer), str.MarkdownEscape(media.Title), invoiceEvent.Amount, media.Sales))
+}
@@ -0,0 +1,167 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// setTestPaywallKey configures the key that encrypts paid media for a test.
+func setTestPaywallKey(t *testing.T) {
+	key := internal.Configuration.Bot.PaywallKeyBytes
+	t.Cleanup(func() { internal.Configuration.Bot.PaywallKeyBytes = key })
+	internal.Configuration.Bot.PaywallKeyBytes = bytes.Repeat([]byte{7}, 32)
+}
+
+func TestPaidMedia(t *testing.T) {
+	h := newTestHarness(t)
+	setTestPaywallKey(t)
+	seller := &tb.User{ID: 9901, Username: "photographer", FirstName: "Photographer", LanguageCode: "en"}
+	buyer := &tb.User{ID: 9902, Username: "collector", FirstName: "Collector", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9900, Type: tb.ChatGroup, Title: "gallery"}
+	to := h.newUser(seller, 0)
+	from := h.newUser(buyer, 1000)
+
+	h.process(tb.Update{Message: &tb.Message{
+		ID:       1,
+		Sender:   seller,
+		Chat:     privateChat(seller),
+		Unixtime: time.Now().Unix(),
+		Photo:    &tb.Photo{File: tb.File{FileID: "sunset-photo"}},
+		Caption:  "/ppv 300 Sunset",
+	}})
+	if text := h.lastMessage(seller.ID).Text(); !strings.Contains(text, "🔒 *Sunset*") {
+		t.Fatalf("preview = %q", text)
+	}
+	media := &PaidMedia{}
+	if tx := h.bot.DB.Transactions.Where("seller_id = ?", seller.ID).First(media); tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	if media.FileId == "sunset-photo" || len(media.ContentKey) == 0 {
+		t.Fatalf("the media is not encrypted: %+v", media)
+	}
+
+	// the preview doesn't contain the media, the buyer gets it after paying
+	h.sendMessage(seller, group, fmt.Sprintf("/ppv %d", media.ID))
+	if h.called("sendPhoto", group.ID) {
+		t.Fatal("the media was sent to the group")
+	}
+	preview := h.lastMessage(group.ID)
+	h.pressButton(buyer, preview, "🔓 Unlock for 300 sat")
+	parts := strings.Split(h.lastMessage(buyer.ID).Text(), "`")
+	if len(parts) < 3 {
+		t.Fatalf("invoice message = %q", h.lastMessage(buyer.ID).Text())
+	}
+	paid, err := h.bot.Client.Pay(*from.Wallet, lnbits.PaymentParams{Out: true, Bolt11: parts[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &InvoiceEvent{Invoice: &Invoice{PaymentHash: paid.PaymentHash}}
+	if err := h.bot.Bunt.Get(event); err != nil {
+		t.Fatal(err)
+	}
+	h.bot.paidMediaEvent(event)
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if r := h.lastMessage(buyer.ID); r.Params["photo"] != "sunset-photo" {
+		t.Fatalf("message after unlocking = %+v", r)
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 300 {
+		t.Errorf("balance of the seller = %d, want 300", balance)
+	}
+	if text := h.lastMessage(seller.ID).Text(); !strings.Contains(text, "@collector unlocked") {
+		t.Errorf("message to the seller = %q", text)
+	}
+
+	// buyers unlock a media once
+	h.sent = nil
+	h.pressButton(buyer, preview, "🔓 Unlock for 300 sat")
+	if r := h.lastMessage(buyer.ID); r.Params["photo"] != "sunset-photo" {
+		t.Errorf("message after unlocking again = %+v", r)
+	}
+	h.sendMessage(seller, privateChat(seller), "/ppv")
+	if text := h.lastMessage(seller.ID).Text(); !strings.Contains(text, "1 sales, 300 sat earned") {
+		t.Errorf("list = %q", text)
+	}
+}
+
+func TestPaidMediaDrop(t *testing.T) {
+	h := newTestHarness(t)
+	setTestPaywallKey(t)
+	seller := &tb.User{ID: 9911, Username: "musician", FirstName: "Musician", LanguageCode: "en"}
+	fan := &tb.User{ID: 9912, Username: "listener", FirstName: "Listener", LanguageCode: "en"}
+	h.newUser(seller, 0)
+	h.newUser(fan, 0)
+
+	h.process(tb.Update{Message: &tb.Message{
+		ID:       1,
+		Sender:   seller,
+		Chat:     privateChat(seller),
+		Unixtime: time.Now().Unix(),
+		Photo:    &tb.Photo{File: tb.File{FileID: "cover-photo"}},
+		Caption:  "/ppv 300 Album cover free 2d",
+	}})
+	preview := h.lastMessage(seller.ID)
+	if text := preview.Text(); !strings.Contains(text, "*Album cover*") || !strings.Contains(text, "Free in 2d 0h") {
+		t.Fatalf("preview = %q", text)
+	}
+	media := &PaidMedia{}
+	if tx := h.bot.DB.Transactions.Where("seller_id = ?", seller.ID).First(media); tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+
+	// the countdown is updated until the drop is free
+	h.bot.DB.Transactions.Model(media).Update("free_at", time.Now().Add(5*time.Hour))
+	h.bot.processDrop(media.ID)
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if text := h.lastMessage(seller.ID).Text(); !strings.Contains(text, "Free in 5h 0m") {
+		t.Fatalf("preview after the countdown = %q", text)
+	}
+	h.bot.DB.Transactions.Model(media).Update("free_at", time.Now().Add(-time.Minute))
+	h.bot.processDrop(media.ID)
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if text := h.lastMessage(seller.ID).Text(); !strings.Contains(text, "free for everyone now") {
+		t.Fatalf("message after the release = %q", text)
+	}
+	h.bot.DB.Transactions.First(media, media.ID)
+	if !media.Released {
+		t.Error("the drop was not released")
+	}
+
+	// everyone gets the media for free
+	h.pressButton(fan, preview, "🔓 Unlock for 300 sat")
+	if r := h.lastMessage(fan.ID); r.Params["photo"] != "cover-photo" {
+		t.Errorf("message after unlocking a free drop = %+v", r)
+	}
+}
+
+func TestSealLegacyPaidMedia(t *testing.T) {
+	h := newTestHarness(t)
+	key := internal.Configuration.Bot.PaywallKeyBytes
+	t.Cleanup(func() { internal.Configuration.Bot.PaywallKeyBytes = key })
+	internal.Configuration.Bot.PaywallKeyBytes = nil
+	media := &PaidMedia{CreatedAt: time.Now(), SellerId: 9911, Title: "legacy", FileId: "legacy-photo", Price: 100, Active: true}
+	if tx := h.bot.DB.Transactions.Create(media); tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+
+	// without a key the media stay as they are and are sealed on a later start
+	if err := sealLegacyPaidMedia(h.bot.DB.Transactions); err != nil {
+		t.Fatal(err)
+	}
+	setTestPaywallKey(t)
+	if err := sealLegacyPaidMedia(h.bot.DB.Transactions); err != nil {
+		t.Fatal(err)
+	}
+	if tx := h.bot.DB.Transactions.First(media, media.ID); tx.Error != nil || len(media.ContentKey) == 0 || media.FileId == "legacy-photo" {
+		t.Fatalf("media = %+v, %v, want it sealed", media, tx.Error)
+	}
+	if fileId, err := media.fileId(); err != nil || fileId != "legacy-photo" {
+		t.Errorf("file id = %q, %v", fileId, err)
+	}
+}
@@ -0,0 +1,314 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	paidMessageMaxLength             = 1000
+	paidMessageRefundTransactionType = "paid message refund"
+)
+
+const (
+	PaidMessageStatusPending   = "pending"
+	PaidMessageStatusDelivered = "delivered"
+	PaidMessageStatusRefunded  = "refunded"
+)
+
+var (
+	paidMessageMenu      = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnRefundPaidMessage = paidMessageMenu.Data("↩️ Refund", "refund_paid_message")
+)
+
+// PaidMessage is a message that a stranger paid for to reach a user with /pm.
+type PaidMessage struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	FromId    int64     `json:"from_id" gorm:"index"`
+	ToId      int64     `json:"to_id" gorm:"index"`
+	Amount    int64     `json:"amount"`
+	Text      string    `json:"text"`
+	Status    string    `json:"status"`
+	// DeliveredMessageId is the message of the bot to the recipient, they answer by replying to it
+	DeliveredMessageId int  `json:"delivered_message_id" gorm:"index"`
+	Replied            bool `json:"replied"`
+}
+
+func (bot *TipBot) loadPaidMessage(id string) (*PaidMessage, error) {
+	messageId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, errors.Create(errors.InvalidSyntaxError)
+	}
+	message := &PaidMessage{}
+	if tx := bot.DB.Transactions.First(message, messageId); tx.Error != nil {
+		return nil, tx.Error
+	}
+	return message, nil
+}
+
+// pmHandler is invoked on /pm. /pm on <price> and /pm off turn the gate of the user on and off,
+// /pm @user <message> sends a paid message and replying /pm <answer> to a paid message answers it.
+func (bot *TipBot) pmHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	args := strings.Fields(m.Text)
+	if len(args) > 1 && strings.HasPrefix(args[1], "@") {
+		return bot.sendPaidMessageHandler(ctx)
+	}
+	if m.ReplyTo != nil && len(args) > 1 {
+		return bot.answerPaidMessageHandler(ctx)
+	}
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	if len(args) == 1 {
+		bot.trySendMessage(m.Sender, paidMessageSettingsStr(ctx, user.Settings.PaidMessage)+"\n\n"+Translate(ctx, "pmHelpMessage"))
+		return ctx, nil
+	}
+	switch strings.ToLower(args[1]) {
+	case "on":
+		if len(args) != 3 {
+			break
+		}
+		price, err := GetAmount(args[2])
+		if err != nil || price < 1 {
+			break
+		}
+		user.Settings.PaidMessage.Price = price
+	case "off":
+		user.Settings.PaidMessage.Price = 0
+	default:
+		bot.trySendMessage(m.Sender, Translate(ctx, "pmHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if strings.ToLower(args[1]) == "on" && user.Settings.PaidMessage.Price == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "pmHelpMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	if err := UpdateUserRecord(user, *bot); err != nil {
+		log.Errorf("[/pm] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, paidMessageSettingsStr(ctx, user.Settings.PaidMessage))
+	return ctx, nil
+}
+
+func paidMessageSettingsStr(ctx intercept.Context, settings lnbits.PaidMessageSettings) string {
+	if settings.Price <= 0 {
+		return Translate(ctx, "pmOffMessage")
+	}
+	return fmt.Sprintf(Translate(ctx, "pmOnMessage"), settings.Price)
+}
+
+// isContactOf returns whether the recipient saved the sender in their contacts.
+func (bot *TipBot) isContactOf(recipient *lnbits.User, sender *tb.User) bool {
+	if len(sender.Username) == 0 {
+		return false
+	}
+	var count int64
+	bot.DB.Users.Model(&Contact{}).Where("user_id = ? AND lower(address) = ?", recipient.Telegram.ID, "@"+strings.ToLower(sender.Username)).Count(&count)
+	return count > 0
+}
+
+// sendPaidMessageHandler is invoked on /pm @user <message>. If the recipient charges for
+// messages, the sender gets an invoice of the recipient and the message is delivered once it
+// is paid. Contacts of the recipient reach them for free.
+func (bot *TipBot) sendPaidMessageHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	args := strings.SplitN(m.Text, " ", 3)
+	if len(args) < 3 || len(strings.TrimSpace(args[2])) == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "pmHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	text := strings.TrimSpace(args[2])
+	if runes := []rune(text); len(runes) > paidMessageMaxLength {
+		text = string(runes[:paidMessageMaxLength]) + "..."
+	}
+	recipient, err := GetUserByTelegramUsername(strings.TrimPrefix(args[1], "@"), *bot)
+	if err == nil {
+		recipient, err = GetLnbitsUserWithSettings(recipient.Telegram, *bot)
+	}
+	if err != nil || recipient.Settings.PaidMessage.Price <= 0 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "pmNotAcceptedMessage"), str.MarkdownEscape(args[1])))
+		return ctx, fmt.Errorf("%s does not accept paid messages", args[1])
+	}
+	if recipient.Telegram.ID == m.Sender.ID {
+		bot.trySendMessage(m.Sender, Translate(ctx, "pmYourselfMessage"))
+		return ctx, fmt.Errorf("cannot message yourself")
+	}
+	message := &PaidMessage{
+		CreatedAt: time.Now(),
+		FromId:    m.Sender.ID,
+		ToId:      recipient.Telegram.ID,
+		Amount:    recipient.Settings.PaidMessage.Price,
+		Text:      text,
+		Status:    PaidMessageStatusPending,
+	}
+	if bot.isContactOf(recipient, m.Sender) {
+		message.Amount = 0
+	}
+	if tx := bot.DB.Transactions.Create(message); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	if message.Amount == 0 {
+		bot.deliverPaidMessage(message, LoadUser(ctx), recipient)
+		return ctx, nil
+	}
+	memo := fmt.Sprintf("✉️ Message to %s", GetUserStr(recipient.Telegram))
+	invoice, err := bot.createInvoiceWithEvent(ctx, recipient, message.Amount, memo, "", InvoiceCallbackPaidMessage, strconv.FormatUint(uint64(message.ID), 10))
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	qr, err := qrcode.Encode(invoice.PaymentRequest, qrcode.Medium, 256)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	caption := fmt.Sprintf(Translate(ctx, "pmInvoiceMessage"), GetUserStrMd(recipient.Telegram), message.Amount, invoice.PaymentRequest)
+	invoice.Payer = LoadUser(ctx)
+	invoice.InvoiceMessage = bot.trySendMessage(m.Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: caption})
+	runtime.IgnoreError(bot.Bunt.Set(invoice))
+	logger(ctx).Infof("[/pm] %s wants to message %s for %d sat", GetUserStr(m.Sender), GetUserStr(recipient.Telegram), message.Amount)
+	return ctx, nil
+}
+
+// paidMessageEvent is invoked when the invoice of a paid message is paid and delivers it.
+func (bot *TipBot) paidMessageEvent(event Event) {
+	invoiceEvent := event.(*InvoiceEvent)
+	id := fmt.Sprintf("paid-message:%s", invoiceEvent.CallbackData)
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	message, err := bot.loadPaidMessage(invoiceEvent.CallbackData)
+	if err != nil {
+		log.Errorf("[paidMessageEvent] %s: %v", id, err)
+		return
+	}
+	if message.Status != PaidMessageStatusPending {
+		return
+	}
+	if invoiceEvent.InvoiceMessage != nil {
+		if _, err := bot.Telegram.EditCaption(invoiceEvent.InvoiceMessage, i18n.Translate(invoiceEvent.LanguageCode, "invoicePaidText")); err != nil {
+			log.Warnln(err.Error())
+		}
+	}
+	sender := invoiceEvent.Payer
+	if sender == nil || sender.Telegram == nil {
+		if sender, err = GetLnbitsUser(&tb.User{ID: message.FromId}, *bot); err != nil {
+			log.Errorf("[paidMessageEvent] sender of %s: %v", id, err)
+			return
+		}
+	}
+	// label the payment in /transactions
+	bot.saveCounterparty(invoiceEvent.PaymentHash, invoiceEvent.User.Wallet.ID, CounterpartyTypeTelegram, GetUserStr(sender.Telegram))
+	bot.savePaymentComment(invoiceEvent.PaymentHash, invoiceEvent.User.Wallet.ID, "✉️ Paid message")
+	bot.deliverPaidMessage(message, sender, invoiceEvent.User)
+}
+
+// deliverPaidMessage sends a paid message to its recipient and tells the sender.
+func (bot *TipBot) deliverPaidMessage(message *PaidMessage, sender, recipient *lnbits.User) {
+	language := recipient.Telegram.LanguageCode
+	text := fmt.Sprintf(i18n.Translate(language, "pmReceivedMessage"), GetUserStrMd(sender.Telegram), message.Amount, str.MarkdownEscape(message.Text))
+	delivered := bot.trySendMessage(recipient.Telegram, text)
+	if delivered == nil {
+		log.Errorf("[paidMessage] could not deliver message %d to %s", message.ID, GetUserStr(recipient.Telegram))
+		return
+	}
+	message.Status = PaidMessageStatusDelivered
+	message.DeliveredMessageId = delivered.ID
+	if tx := bot.DB.Transactions.Save(message); tx.Error != nil {
+		log.Errorf("[paidMessage] could not save message %d: %v", message.ID, tx.Error)
+	}
+	log.Infof("[✉️ pm] %s messaged %s for %d sat", GetUserStr(sender.Telegram), GetUserStr(recipient.Telegram), message.Amount)
+	bot.trySendMessage(sender.Telegram, fmt.Sprintf(i18n.Translate(sender.Telegram.LanguageCode, "pmDeliveredMessage"), GetUserStrMd(recipient.Telegram)))
+}
+
+// answerPaidMessageHandler is invoked when the recipient of a paid message replies /pm <answer>
+// to it. The answer goes to the sender, and the recipient may refund the message.
+func (bot *TipBot) answerPaidMessageHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	message := &PaidMessage{}
+	tx := bot.DB.Transactions.Where("to_id = ? AND delivered_message_id = ?", m.Sender.ID, m.ReplyTo.ID).First(message)
+	if tx.Error != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "pmHelpMessage"))
+		return ctx, tx.Error
+	}
+	answer := strings.TrimSpace(strings.SplitN(m.Text, " ", 2)[1])
+	if runes := []rune(answer); len(runes) > paidMessageMaxLength {
+		answer = string(runes[:paidMessageMaxLength]) + "..."
+	}
+	sender, err := GetLnbitsUser(&tb.User{ID: message.FromId}, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	bot.trySendMessage(sender.Telegram, fmt.Sprintf(i18n.Translate(sender.Telegram.LanguageCode, "pmAnswerMessage"), GetUserStrMd(m.Sender), str.MarkdownEscape(answer)))
+	message.Replied = true
+	if tx := bot.DB.Transactions.Save(message); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	if message.Amount == 0 || message.Status == PaidMessageStatusRefunded {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "pmAnsweredMessage"), GetUserStrMd(sender.Telegram)))
+		return ctx, nil
+	}
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	refundButton := menu.Data(fmt.Sprintf(Translate(ctx, "pmRefundButtonMessage"), message.Amount), "refund_paid_message", strconv.FormatUint(uint64(message.ID), 10))
+	menu.Inline(menu.Row(refundButton))
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "pmAnsweredMessage"), GetUserStrMd(sender.Telegram)), menu)
+	return ctx, nil
+}
+
+// refundPaidMessageHandler is invoked when the recipient of an answered paid message presses
+// refund. The price of the message goes back to its sender.
+func (bot *TipBot) refundPaidMessageHandler(ctx intercept.Context) (intercept.Context, error) {
+	id := fmt.Sprintf("paid-message:%s", ctx.Data())
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	message, err := bot.loadPaidMessage(ctx.Data())
+	if err != nil {
+		return ctx, err
+	}
+	if message.ToId != ctx.Sender().ID || !message.Replied {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if message.Status != PaidMessageStatusDelivered {
+		bot.tryEditMessage(ctx.Message(), Translate(ctx, "pmRefundedMessage"), &tb.ReplyMarkup{})
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	sender, err := GetLnbitsUser(&tb.User{ID: message.FromId}, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	t := NewTransaction(bot, LoadUser(ctx), sender, message.Amount, TransactionType(paidMessageRefundTransactionType), TransactionIdempotencyKey(id+":refund"), TransactionContext(ctx))
+	t.Memo = fmt.Sprintf("↩️ Refund of a message to %s.", GetUserStr(ctx.Sender()))
+	success, err := t.Send()
+	if !success {
+		if isDuplicateOperation(err) {
+			return ctx, err
+		}
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "pmRefundFailedMessage"), str.MarkdownEscape(fmt.Sprint(err))))
+		return ctx, err
+	}
+	message.Status = PaidMessageStatusRefunded
+	if tx := bot.DB.Transactions.Model(message).Update("status", message.Status); tx.Error != nil {
+		log.Errorf("[refundPaidMessage] could not save %s: %v", id, tx.Error)
+	}
+	logger(ctx).Infof("[✉️ pm] %s refunded %d sat to %s", GetUserStr(ctx.Sender()), message.Amount, GetUserStr(sender.Telegram))
+	bot.tryEditMessage(ctx.Message(), Translate(ctx, "pmRefundedMessage"), &tb.ReplyMarkup{})
+	bot.trySendMessage(sender.Telegram, fmt.Sprintf(i18n.Translate(sender.Telegram.LanguageCode, "pmRefundReceivedMessage"), GetUserStrMd(ctx.Sender()), message.Amount))
+	return ctx, nil
+}
@@ -0,0 +1,59 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestPaidMessage(t *testing.T) {
+	h := newTestHarness(t)
+	celebrity := &tb.User{ID: 9801, Username: "celebrity", FirstName: "Celebrity", LanguageCode: "en"}
+	fan := &tb.User{ID: 9802, Username: "fan", FirstName: "Fan", LanguageCode: "en"}
+	to := h.newUser(celebrity, 0)
+	from := h.newUser(fan, 1000)
+
+	h.sendMessage(fan, privateChat(fan), "/pm @celebrity hi")
+	if text := h.lastMessage(fan.ID).Text(); !strings.Contains(text, "doesn't accept paid messages") {
+		t.Fatalf("message without gate = %q", text)
+	}
+
+	h.sendMessage(celebrity, privateChat(celebrity), "/pm on 500")
+	h.sendMessage(fan, privateChat(fan), "/pm @celebrity big fan of your work")
+	parts := strings.Split(h.lastMessage(fan.ID).Text(), "`")
+	if len(parts) < 3 {
+		t.Fatalf("invoice message = %q", h.lastMessage(fan.ID).Text())
+	}
+	paid, err := h.bot.Client.Pay(*from.Wallet, lnbits.PaymentParams{Out: true, Bolt11: parts[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &InvoiceEvent{Invoice: &Invoice{PaymentHash: paid.PaymentHash}}
+	if err := h.bot.Bunt.Get(event); err != nil {
+		t.Fatal(err)
+	}
+	h.bot.paidMessageEvent(event)
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	delivered := h.lastMessage(celebrity.ID)
+	if !strings.Contains(delivered.Text(), "big fan of your work") {
+		t.Fatalf("delivered message = %q", delivered.Text())
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 500 {
+		t.Errorf("balance of the recipient = %d, want 500", balance)
+	}
+
+	// the recipient answers and refunds the message
+	h.replyToBot(celebrity, delivered, "/pm thank you")
+	if text := h.lastMessage(fan.ID).Text(); !strings.Contains(text, "thank you") {
+		t.Errorf("answer = %q", text)
+	}
+	h.pressButton(celebrity, h.lastMessage(celebrity.ID), "↩️ Refund 500 sat")
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of the sender after the refund = %d, want 1000", balance)
+	}
+	if text := h.lastMessage(fan.ID).Text(); !strings.Contains(text, "refunded your message") {
+		t.Errorf("message after the refund = %q", text)
+	}
+}
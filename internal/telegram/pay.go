@@ -3,8 +3,11 @@ package telegram
 import (
 	"context"
 	"fmt"
+	"math"
 	"strings"
 
+	"github.com/LightningTipBot/LightningTipBot/internal"
+
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
 
 	"github.com/LightningTipBot/LightningTipBot/internal/errors"
@@ -19,11 +22,13 @@ import (
 	"github.com/LightningTipBot/LightningTipBot/internal/str"
 	lnurl "github.com/fiatjaf/go-lnurl"
 	decodepay "github.com/fiatjaf/ln-decodepay"
-	log "github.com/sirupsen/logrus"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
 
 var (
+	// errPaymentNeedsConfirmation is returned for payments of API clients that wait for the confirmation of the user.
+	errPaymentNeedsConfirmation = fmt.Errorf("confirm the payment in Telegram")
+
 	paymentConfirmationMenu = &tb.ReplyMarkup{ResizeKeyboard: true}
 	btnCancelPay            = paymentConfirmationMenu.Data("🚫 Cancel", "cancel_pay")
 	btnPay                  = paymentConfirmationMenu.Data("✅ Pay", "confirm_pay")
@@ -39,16 +44,18 @@ func helpPayInvoiceUsage(ctx context.Context, errormsg string) string {
 
 type PayData struct {
 	*storage.Base
-	From            *lnbits.User         `json:"from"`
-	Invoice         string               `json:"invoice"`
-	Hash            string               `json:"hash"`
-	Proof           string               `json:"proof"`
-	Memo            string               `json:"memo"`
-	Message         string               `json:"message"`
-	Amount          int64                `json:"amount"`
-	LanguageCode    string               `json:"languagecode"`
-	SuccessAction   *lnurl.SuccessAction `json:"successAction"`
-	TelegramMessage *tb.Message          `json:"telegrammessage"`
+	From             *lnbits.User         `json:"from"`
+	Invoice          string               `json:"invoice"`
+	Hash             string               `json:"hash"`
+	Proof            string               `json:"proof"`
+	Memo             string               `json:"memo"`
+	Message          string               `json:"message"`
+	Amount           int64                `json:"amount"`
+	LanguageCode     string               `json:"languagecode"`
+	SuccessAction    *lnurl.SuccessAction `json:"successAction"`
+	TelegramMessage  *tb.Message          `json:"telegrammessage"`
+	Counterparty     string               `json:"counterparty"`
+	CounterpartyType string               `json:"counterparty_type"`
 }
 
 // payHandler invoked on "/pay lnbc..." command
@@ -70,7 +77,7 @@ func (bot *TipBot) payHandler(ctx intercept.Context) (intercept.Context, error)
 		NewMessage(ctx.Message(), WithDuration(0, bot))
 		bot.trySendMessage(ctx.Sender(), helpPayInvoiceUsage(ctx, Translate(ctx, "invalidInvoiceHelpMessage")))
 		errmsg := fmt.Sprintf("[/pay] Error: Could not getArgumentFromCommand: %s", err.Error())
-		log.Errorln(errmsg)
+		logger(ctx).Errorln(errmsg)
 		return ctx, errors.New(errors.InvalidSyntaxError, err)
 	}
 	paymentRequest = strings.ToLower(paymentRequest)
@@ -82,7 +89,7 @@ func (bot *TipBot) payHandler(ctx intercept.Context) (intercept.Context, error)
 	if err != nil {
 		bot.trySendMessage(ctx.Sender(), helpPayInvoiceUsage(ctx, Translate(ctx, "invalidInvoiceHelpMessage")))
 		errmsg := fmt.Sprintf("[/pay] Error: Could not decode invoice: %s", err.Error())
-		log.Errorln(errmsg)
+		logger(ctx).Errorln(errmsg)
 		return ctx, errors.New(errors.InvalidSyntaxError, err)
 	}
 	amount := int64(bolt11.MSatoshi / 1000)
@@ -90,7 +97,7 @@ func (bot *TipBot) payHandler(ctx intercept.Context) (intercept.Context, error)
 	if amount <= 0 {
 		bot.trySendMessage(ctx.Sender(), Translate(ctx, "invoiceNoAmountMessage"))
 		errmsg := fmt.Sprint("[/pay] Error: invoice without amount")
-		log.Warnln(errmsg)
+		logger(ctx).Warnln(errmsg)
 		return ctx, errors.Create(errors.InvalidAmountError)
 	}
 
@@ -99,7 +106,7 @@ func (bot *TipBot) payHandler(ctx intercept.Context) (intercept.Context, error)
 	if err != nil {
 		NewMessage(ctx.Message(), WithDuration(0, bot))
 		errmsg := fmt.Sprintf("[/pay] Error: Could not get user balance: %s", err.Error())
-		log.Errorln(errmsg)
+		logger(ctx).Errorln(errmsg)
 		bot.trySendMessage(ctx.Sender(), Translate(ctx, "errorTryLaterMessage"))
 		return ctx, errors.New(errors.GetBalanceError, err)
 	}
@@ -114,43 +121,57 @@ func (bot *TipBot) payHandler(ctx intercept.Context) (intercept.Context, error)
 		bot.trySendMessage(ctx.Sender(), Translate(ctx, "feeReserveMessage"))
 	}
 
-	confirmText := fmt.Sprintf(Translate(ctx, "confirmPayInvoiceMessage"), amount)
-	if len(bolt11.Description) > 0 {
-		confirmText = confirmText + fmt.Sprintf(Translate(ctx, "confirmPayAppendMemo"), str.MarkdownEscape(bolt11.Description))
+	// the lightning address is set by the lnurl handler, otherwise we use the node of the invoice
+	counterparty, counterpartyType := bolt11.Payee, CounterpartyTypeNode
+	if lnaddr, ok := ctx.Value("Counterparty").(string); ok && len(lnaddr) > 0 {
+		counterparty, counterpartyType = lnaddr, CounterpartyTypeLightningAddress
 	}
 
-	log.Infof("[/pay] Invoice entered. User: %s, amount: %d sat.", userStr, amount)
+	languageCode := ctx.Value("publicLanguageCode").(string)
+	confirmText, feeExceedsLimit := bot.paymentConfirmation(user, languageCode, bolt11, counterparty, counterpartyType)
+	settings := bot.paymentSettings(user)
+
+	logger(ctx).WithField("payment_hash", bolt11.PaymentHash).Infof("[/pay] Invoice entered. User: %s, amount: %d sat.", userStr, amount)
 
 	// object that holds all information about the send payment
 	id := fmt.Sprintf("pay:%d-%d-%s", ctx.Sender().ID, amount, RandStringRunes(5))
 
-	// // // create inline buttons
-	payButton := paymentConfirmationMenu.Data(Translate(ctx, "payButtonMessage"), "confirm_pay", id)
-	cancelButton := paymentConfirmationMenu.Data(Translate(ctx, "cancelButtonMessage"), "cancel_pay", id)
-
-	paymentConfirmationMenu.Inline(
-		paymentConfirmationMenu.Row(
-			payButton,
-			cancelButton),
-	)
-	payMessage := bot.trySendMessageEditable(ctx.Chat(), confirmText, paymentConfirmationMenu)
 	// read successaction
 	sa, ok := ctx.Value("SuccessAction").(*lnurl.SuccessAction)
 	if !ok {
 		sa = &lnurl.SuccessAction{}
 	}
-
 	payData := &PayData{
-		Base:            storage.New(storage.ID(id)),
-		From:            user,
-		Invoice:         paymentRequest,
-		Amount:          int64(amount),
-		Memo:            bolt11.Description,
-		Message:         confirmText,
-		LanguageCode:    ctx.Value("publicLanguageCode").(string),
-		SuccessAction:   sa,
-		TelegramMessage: payMessage,
+		Base:             storage.New(storage.ID(id)),
+		From:             user,
+		Invoice:          paymentRequest,
+		Amount:           int64(amount),
+		Memo:             bolt11.Description,
+		Message:          confirmText,
+		LanguageCode:     languageCode,
+		SuccessAction:    sa,
+		Counterparty:     counterparty,
+		CounterpartyType: counterpartyType,
 	}
+
+	// small payments are sent right away if the user turned off their confirmation,
+	// unless the fee may exceed the limit of the user
+	if amount <= settings.ConfirmAbove && !feeExceedsLimit {
+		payData.TelegramMessage = bot.trySendMessageEditable(ctx.Chat(), confirmText)
+		if payData.TelegramMessage == nil {
+			return ctx, errors.Create(errors.UnknownError)
+		}
+		runtime.IgnoreError(payData.Set(payData, bot.Bunt))
+		mutex.LockWithContext(ctx, payData.ID)
+		defer mutex.UnlockWithContext(ctx, payData.ID)
+		if err := bot.claimIdempotencyKey(idempotencyKey(ctx)); err != nil {
+			return ctx, err
+		}
+		logger(ctx).Infof("[/pay] Skipping the confirmation of %s's invoice %s (%d sat)", userStr, payData.ID, payData.Amount)
+		return bot.payInvoice(ctx, payData, payData.TelegramMessage)
+	}
+
+	payData.TelegramMessage = bot.askPaymentConfirmation(ctx.Chat(), payData, feeExceedsLimit)
 	// add result to persistent struct
 	runtime.IgnoreError(payData.Set(payData, bot.Bunt))
 
@@ -158,6 +179,140 @@ func (bot *TipBot) payHandler(ctx intercept.Context) (intercept.Context, error)
 	return ctx, nil
 }
 
+// paymentConfirmation describes an invoice before it is paid and tells whether its routing
+// fee may exceed the limit of the user.
+func (bot *TipBot) paymentConfirmation(user *lnbits.User, languageCode string, bolt11 decodepay.Bolt11, counterparty, counterpartyType string) (string, bool) {
+	amount := bolt11.MSatoshi / 1000
+	confirmText := fmt.Sprintf(i18n.Translate(languageCode, "confirmPayInvoiceMessage"), amount) + bot.fiatAmount(user, amount)
+	confirmText = confirmText + fmt.Sprintf(i18n.Translate(languageCode, "confirmPayAppendDestination"), str.MarkdownEscape(shortCounterparty(counterparty, counterpartyType)))
+	fee := estimateRoutingFee(amount)
+	maxFee, limited := maxRoutingFee(bot.paymentSettings(user), amount)
+	feeExceedsLimit := limited && fee > maxFee
+	confirmText = confirmText + fmt.Sprintf(i18n.Translate(languageCode, "confirmPayAppendFee"), fee)
+	if feeExceedsLimit {
+		confirmText = confirmText + fmt.Sprintf(i18n.Translate(languageCode, "confirmPayAppendFeeLimit"), maxFee)
+	}
+	if len(bolt11.Description) > 0 {
+		confirmText = confirmText + fmt.Sprintf(i18n.Translate(languageCode, "confirmPayAppendMemo"), str.MarkdownEscape(bolt11.Description))
+	}
+	return confirmText, feeExceedsLimit
+}
+
+// askPaymentConfirmation sends the confirmation of payData with the buttons to pay or cancel.
+func (bot *TipBot) askPaymentConfirmation(to tb.Recipient, payData *PayData, feeExceedsLimit bool) *tb.Message {
+	payButtonText := i18n.Translate(payData.LanguageCode, "payButtonMessage")
+	if feeExceedsLimit {
+		payButtonText = i18n.Translate(payData.LanguageCode, "payAnywayButtonMessage")
+	}
+	payButton := paymentConfirmationMenu.Data(payButtonText, "confirm_pay", payData.ID)
+	cancelButton := paymentConfirmationMenu.Data(i18n.Translate(payData.LanguageCode, "cancelButtonMessage"), "cancel_pay", payData.ID)
+
+	paymentConfirmationMenu.Inline(
+		paymentConfirmationMenu.Row(
+			payButton,
+			cancelButton),
+	)
+	return bot.trySendMessageEditable(to, payData.Message, paymentConfirmationMenu)
+}
+
+// PayInvoiceFromAPI pays an invoice for a client of the API with the checks of /pay. Payments
+// that the user has to confirm are sent to Telegram for confirmation and are not paid right away.
+func (bot *TipBot) PayInvoiceFromAPI(user *lnbits.User, paymentRequest string) (lnbits.Invoice, error) {
+	bolt11, err := decodepay.Decodepay(paymentRequest)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	amount := bolt11.MSatoshi / 1000
+	if amount <= 0 {
+		return lnbits.Invoice{}, fmt.Errorf("invoices without amount are not supported")
+	}
+	languageCode := user.Telegram.LanguageCode
+	confirmText, feeExceedsLimit := bot.paymentConfirmation(user, languageCode, bolt11, bolt11.Payee, CounterpartyTypeNode)
+	if amount > bot.paymentSettings(user).ConfirmAbove || feeExceedsLimit {
+		payData := &PayData{
+			Base:             storage.New(storage.ID(fmt.Sprintf("pay:%d-%d-%s", user.Telegram.ID, amount, RandStringRunes(5)))),
+			From:             user,
+			Invoice:          paymentRequest,
+			Amount:           amount,
+			Memo:             bolt11.Description,
+			Message:          confirmText,
+			LanguageCode:     languageCode,
+			SuccessAction:    &lnurl.SuccessAction{},
+			Counterparty:     bolt11.Payee,
+			CounterpartyType: CounterpartyTypeNode,
+		}
+		payData.TelegramMessage = bot.askPaymentConfirmation(user.Telegram, payData, feeExceedsLimit)
+		if payData.TelegramMessage == nil {
+			return lnbits.Invoice{}, fmt.Errorf("could not ask for the confirmation of the payment")
+		}
+		runtime.IgnoreError(payData.Set(payData, bot.Bunt))
+		SetUserState(user, bot, lnbits.UserStateConfirmPayment, paymentRequest)
+		return lnbits.Invoice{}, errPaymentNeedsConfirmation
+	}
+
+	// the payment is tracked until its outcome is known, see reconcile.go
+	outgoing := bot.trackOutgoingPayment(user, paymentRequest, amount, languageCode)
+	invoice, err := bot.Client.Pay(*user.Wallet, lnbits.PaymentParams{Out: true, Bolt11: paymentRequest, UserInitiated: true})
+	if err != nil && !isPaymentRefused(err) && outgoing != nil {
+		// the payment may still go through, the reconciler tells the user how it ended
+		outgoing.Waiting = true
+		bot.Bunt.Set(outgoing)
+		return invoice, err
+	}
+	if err != nil {
+		bot.untrackOutgoingPayment(outgoing)
+		return invoice, err
+	}
+	bot.saveCounterparty(invoice.PaymentHash, user.Wallet.ID, CounterpartyTypeNode, bolt11.Payee)
+	if payment, err := bot.Client.Payment(*user.Wallet, invoice.PaymentHash); err == nil && payment.Paid {
+		bot.savePreimage(invoice.PaymentHash, user.Wallet.ID, payment.Preimage)
+		bot.untrackOutgoingPayment(outgoing)
+	}
+	return invoice, nil
+}
+
+// paymentSettings returns the settings of the user for outgoing payments.
+func (bot *TipBot) paymentSettings(user *lnbits.User) lnbits.PaymentSettings {
+	if user.Settings != nil {
+		return user.Settings.Payment
+	}
+	userWithSettings, err := GetLnbitsUserWithSettings(user.Telegram, *bot)
+	if err != nil {
+		return lnbits.PaymentSettings{}
+	}
+	return userWithSettings.Settings.Payment
+}
+
+// maxRoutingFee returns the routing fee in sat that the user accepts for a payment of
+// amount sat and false if the user has no limit.
+func maxRoutingFee(settings lnbits.PaymentSettings, amount int64) (int64, bool) {
+	switch {
+	case settings.MaxFeeSat > 0:
+		return settings.MaxFeeSat, true
+	case settings.MaxFeePercent > 0:
+		return int64(float64(amount) * settings.MaxFeePercent / 100), true
+	}
+	return 0, false
+}
+
+// estimateRoutingFee returns the routing fee in sat that a payment of amount sat costs at most.
+func estimateRoutingFee(amount int64) int64 {
+	config := internal.CurrentConfiguration().Node
+	fee := int64(math.Ceil(float64(amount) * config.FeeLimitPercent / 100))
+	if fee < config.FeeLimitMinSat {
+		fee = config.FeeLimitMinSat
+	}
+	return fee
+}
+
+// shortCounterparty shortens the public key of a node for the confirmation message.
+func shortCounterparty(counterparty, counterpartyType string) string {
+	if counterpartyType == CounterpartyTypeNode && len(counterparty) > 16 {
+		return counterparty[:8] + "…" + counterparty[len(counterparty)-8:]
+	}
+	return counterparty
+}
+
 // confirmPayHandler when user clicked pay on payment confirmation
 func (bot *TipBot) confirmPayHandler(ctx intercept.Context) (intercept.Context, error) {
 	tx := &PayData{Base: storage.New(storage.ID(ctx.Data()))}
@@ -166,7 +321,7 @@ func (bot *TipBot) confirmPayHandler(ctx intercept.Context) (intercept.Context,
 	sn, err := tx.Get(tx, bot.Bunt)
 	// immediatelly set intransaction to block duplicate calls
 	if err != nil {
-		log.Errorf("[confirmPayHandler] %s", err.Error())
+		logger(ctx).Errorf("[confirmPayHandler] %s", err.Error())
 		return ctx, err
 	}
 	payData := sn.(*PayData)
@@ -176,19 +331,25 @@ func (bot *TipBot) confirmPayHandler(ctx intercept.Context) (intercept.Context,
 		return ctx, errors.Create(errors.UnknownError)
 	}
 	if !payData.Active {
-		log.Errorf("[confirmPayHandler] send not active anymore")
+		logger(ctx).Errorf("[confirmPayHandler] send not active anymore")
 		bot.tryEditMessage(ctx.Message(), i18n.Translate(payData.LanguageCode, "errorTryLaterMessage"), &tb.ReplyMarkup{})
 		bot.tryDeleteMessage(ctx.Message())
 		return ctx, errors.Create(errors.NotActiveError)
 	}
-	defer payData.Set(payData, bot.Bunt)
+	// only pay once per confirmation, even if the callback is delivered twice
+	if err := bot.claimIdempotencyKey(idempotencyKey(ctx)); err != nil {
+		return ctx, err
+	}
+	return bot.payInvoice(ctx, payData, ctx.Message())
+}
 
-	// remove buttons from confirmation message
-	// bot.tryEditMessage(handler.Message(), MarkdownEscape(payData.Message), &tb.ReplyMarkup{})
+// payInvoice pays the invoice of payData and shows the progress in message.
+func (bot *TipBot) payInvoice(ctx intercept.Context, payData *PayData, message *tb.Message) (intercept.Context, error) {
+	defer payData.Set(payData, bot.Bunt)
 
 	user := LoadUser(ctx)
 	if user.Wallet == nil {
-		bot.tryDeleteMessage(ctx.Message())
+		bot.tryDeleteMessage(message)
 		return ctx, errors.Create(errors.UserNoWalletError)
 	}
 
@@ -198,65 +359,120 @@ func (bot *TipBot) confirmPayHandler(ctx intercept.Context) (intercept.Context,
 	userStr := GetUserStr(ctx.Sender())
 
 	// update button text
-	bot.tryEditMessage(
-		ctx.Message(),
-		payData.Message,
-		&tb.ReplyMarkup{
-			InlineKeyboard: [][]tb.InlineButton{
-				{tb.InlineButton{Unique: "attempt_payment", Text: i18n.Translate(payData.LanguageCode, "lnurlGettingUserMessage")}},
-			},
+	attemptingMenu := &tb.ReplyMarkup{
+		InlineKeyboard: [][]tb.InlineButton{
+			{tb.InlineButton{Unique: "attempt_payment", Text: i18n.Translate(payData.LanguageCode, "lnurlGettingUserMessage")}},
 		},
-	)
+	}
+	bot.tryEditMessage(message, payData.Message, attemptingMenu)
 
-	log.Infof("[/pay] Attempting %s's invoice %s (%d sat)", userStr, payData.ID, payData.Amount)
+	// show how many parts of a multi-part payment arrived
+	var progress lnbits.PaymentProgress
+	reportProgress := func(p lnbits.PaymentProgress) {
+		if p.Parts < 2 || p == progress {
+			return
+		}
+		progress = p
+		bot.tryEditMessage(message, payData.Message+fmt.Sprintf(i18n.Translate(payData.LanguageCode, "paymentPartsMessage"), p.Parts, p.ArrivedMsat/1000, payData.Amount), attemptingMenu)
+	}
+
+	logger(ctx).Infof("[/pay] Attempting %s's invoice %s (%d sat)", userStr, payData.ID, payData.Amount)
+	// the payment is tracked until its outcome is known, see reconcile.go
+	outgoing := bot.trackOutgoingPayment(user, payData.Invoice, payData.Amount, payData.LanguageCode)
 	// pay invoice
-	invoice, err := user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: payData.Invoice}, bot.Client)
+	invoice, err := bot.client(ctx).Pay(*user.Wallet, lnbits.PaymentParams{Out: true, Bolt11: payData.Invoice, Progress: reportProgress, UserInitiated: true})
+	if _, refused := err.(lnbits.Error); err != nil && !refused && !isRetryablePaymentError(err) && outgoing != nil {
+		// the payment may still go through, the reconciler tells the user how it ended
+		logger(ctx).Warnf("[/pay] outcome of the payment of %s is unknown: %s", userStr, err)
+		outgoing.Waiting = true
+		bot.Bunt.Set(outgoing)
+		bot.tryEditMessage(message, fmt.Sprintf(i18n.Translate(payData.LanguageCode, "reconcilePaymentStuckMessage"), payData.Amount), &tb.ReplyMarkup{})
+		return ctx, nil
+	}
+	if err != nil {
+		// failed payments and queued retries are resolved without the reconciler
+		bot.untrackOutgoingPayment(outgoing)
+	}
+	if err != nil && isRetryablePaymentError(err) {
+		logger(ctx).Errorf("[/pay] Could not pay invoice of %s, will retry: %s", userStr, err)
+		if err := bot.enqueuePaymentRetry(payData, err); err == nil {
+			bot.tryEditMessage(message, i18n.Translate(payData.LanguageCode, "paymentRetryQueuedMessage"), &tb.ReplyMarkup{})
+			return ctx, nil
+		}
+	}
 	if err != nil {
 		errmsg := fmt.Sprintf("[/pay] Could not pay invoice of %s: %s", userStr, err)
 		err = fmt.Errorf(i18n.Translate(payData.LanguageCode, "invoiceUndefinedErrorMessage"))
-		bot.tryEditMessage(ctx.Message(), fmt.Sprintf(i18n.Translate(payData.LanguageCode, "invoicePaymentFailedMessage"), err.Error()), &tb.ReplyMarkup{})
+		bot.tryEditMessage(message, fmt.Sprintf(i18n.Translate(payData.LanguageCode, "invoicePaymentFailedMessage"), err.Error()), &tb.ReplyMarkup{})
 		// verbose error message, turned off for now
 		// if len(err.Error()) == 0 {
 		// 	err = fmt.Errorf(i18n.Translate(payData.LanguageCode, "invoiceUndefinedErrorMessage"))
 		// }
 		// bot.tryEditMessage(c.Message, fmt.Sprintf(i18n.Translate(payData.LanguageCode, "invoicePaymentFailedMessage"), str.MarkdownEscape(err.Error())), &tb.ReplyMarkup{})
-		log.Errorln(errmsg)
+		logger(ctx).Errorln(errmsg)
 		return ctx, err
 	}
 	payData.Hash = invoice.PaymentHash
+	bot.saveCounterparty(invoice.PaymentHash, user.Wallet.ID, payData.CounterpartyType, payData.Counterparty)
+	// the preimage proves that the payment arrived
+	payData.Proof, err = bot.paymentPreimage(ctx, *user.Wallet, invoice.PaymentHash)
+	if err != nil {
+		// the payment is still in flight, the reconciler keeps an eye on it
+		logger(ctx).Warnf("[/pay] no preimage of invoice %s: %v", payData.ID, err)
+	} else {
+		bot.untrackOutgoingPayment(outgoing)
+	}
 
 	// do balance check for keyboard update
 	_, err = bot.GetUserBalance(user)
 	if err != nil {
 		errmsg := fmt.Sprintf("could not get balance of user %s", userStr)
-		log.Errorln(errmsg)
+		logger(ctx).Errorln(errmsg)
 	}
 
-	if ctx.Message().Private() {
+	if message.Private() {
 		// if the command was invoked in private chat
 		// the edit below was cool, but we need to pop up the keyboard again
 		// bot.tryEditMessage(c.Message, i18n.Translate(payData.LanguageCode, "invoicePaidMessage"), &tb.ReplyMarkup{})
-		bot.tryDeleteMessage(ctx.Message())
-		bot.trySendMessage(ctx.Sender(), i18n.Translate(payData.LanguageCode, "invoicePaidMessage"))
+		bot.tryDeleteMessage(message)
+		bot.trySendMessage(ctx.Sender(), paidMessage(payData))
 	} else {
 		// if the command was invoked in group chat
-		bot.trySendMessage(ctx.Sender(), i18n.Translate(payData.LanguageCode, "invoicePaidMessage"))
-		bot.tryEditMessage(ctx.Message(), fmt.Sprintf(i18n.Translate(payData.LanguageCode, "invoicePublicPaidMessage"), userStr), &tb.ReplyMarkup{})
+		bot.trySendMessage(ctx.Sender(), paidMessage(payData))
+		bot.tryEditMessage(message, fmt.Sprintf(i18n.Translate(payData.LanguageCode, "invoicePublicPaidMessage"), userStr), &tb.ReplyMarkup{})
 	}
 
-	// display LNURL success action if present
-	sa := payData.SuccessAction
+	bot.sendSuccessAction(ctx.Sender(), payData.SuccessAction)
+
+	if progress.Parts > 1 {
+		logger(ctx).Infof("[/pay] Invoice %s was paid in %d parts, %d failed", payData.ID, progress.Parts, progress.Failed)
+	}
+	logger(ctx).WithField("payment_hash", payData.Hash).Infof("[⚡️ pay] User %s paid invoice %s (%d sat)", userStr, payData.ID, payData.Amount)
+	return ctx, nil
+}
+
+// paidMessage tells the payer that the payment was sent and shows the proof of payment.
+func paidMessage(payData *PayData) string {
+	message := i18n.Translate(payData.LanguageCode, "invoicePaidMessage")
+	if len(payData.Proof) > 0 {
+		message = message + fmt.Sprintf(i18n.Translate(payData.LanguageCode, "invoicePaidProofMessage"), payData.Proof)
+	}
+	return message
+}
+
+// sendSuccessAction displays the LNURL success action of a payment if present
+func (bot *TipBot) sendSuccessAction(to *tb.User, sa *lnurl.SuccessAction) {
+	if sa == nil {
+		return
+	}
 	if sa.Tag == "message" && len(sa.Message) > 0 {
-		bot.trySendMessage(ctx.Sender(), fmt.Sprintf("✉️: `%s`", sa.Message))
+		bot.trySendMessage(to, fmt.Sprintf("✉️: `%s`", sa.Message))
 	} else if sa.Tag == "url" && len(sa.URL) > 0 {
-		bot.trySendMessage(ctx.Sender(), fmt.Sprintf("🔗: %s", str.MarkdownEscape(sa.URL)), tb.NoPreview)
+		bot.trySendMessage(to, fmt.Sprintf("🔗: %s", str.MarkdownEscape(sa.URL)), tb.NoPreview)
 		if len(sa.Description) > 0 {
-			bot.trySendMessage(ctx.Sender(), fmt.Sprintf("✉️: %s", sa.Description))
+			bot.trySendMessage(to, fmt.Sprintf("✉️: %s", sa.Description))
 		}
 	}
-
-	log.Infof("[⚡️ pay] User %s paid invoice %s (%d sat)", userStr, payData.ID, payData.Amount)
-	return ctx, nil
 }
 
 // cancelPaymentHandler invoked when user clicked cancel on payment confirmation
@@ -270,7 +486,7 @@ func (bot *TipBot) cancelPaymentHandler(ctx intercept.Context) (intercept.Contex
 	// immediatelly set intransaction to block duplicate calls
 	sn, err := tx.Get(tx, bot.Bunt)
 	if err != nil {
-		log.Errorf("[cancelPaymentHandler] %s", err.Error())
+		logger(ctx).Errorf("[cancelPaymentHandler] %s", err.Error())
 		return ctx, err
 	}
 	payData := sn.(*PayData)
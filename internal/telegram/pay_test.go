@@ -0,0 +1,140 @@
+package telegram
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestPayFlow(t *testing.T) {
+	h := newTestHarness(t)
+	payer := &tb.User{ID: 4001, Username: "payer", FirstName: "Payer", LanguageCode: "en"}
+	from := h.newUser(payer, 1000)
+	invoice, err := h.lnbits.ExternalInvoice(100, "coffee")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.sendMessage(payer, privateChat(payer), "/pay "+invoice.PaymentRequest)
+	confirmation := h.lastMessage(payer.ID)
+	for _, want := range []string{"100 sat", "Routing fee: up to 10 sat", "coffee"} {
+		if !strings.Contains(confirmation.Text(), want) {
+			t.Errorf("confirmation %q does not contain %q", confirmation.Text(), want)
+		}
+	}
+	if h.lnbits.Paid(invoice.PaymentHash) {
+		t.Fatal("invoice was paid before the confirmation")
+	}
+
+	h.pressButton(payer, confirmation, "✅ Pay")
+	if !h.lnbits.Paid(invoice.PaymentHash) {
+		t.Fatal("invoice was not paid")
+	}
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 900 {
+		t.Errorf("balance of payer = %d, want 900", balance)
+	}
+
+	// the preimage is shown as proof of payment and can be looked up later
+	paid := h.lastMessage(payer.ID).Text()
+	fields := strings.Split(paid, "`")
+	if !strings.Contains(paid, "Proof of payment") || len(fields) < 3 {
+		t.Fatalf("paid message = %q", paid)
+	}
+	preimage, err := hex.DecodeString(fields[len(fields)-2])
+	if hash := sha256.Sum256(preimage); err != nil || hex.EncodeToString(hash[:]) != invoice.PaymentHash {
+		t.Errorf("proof %q does not match the payment hash", fields[len(fields)-2])
+	}
+	h.sendMessage(payer, privateChat(payer), "/proof "+invoice.PaymentHash)
+	if text := h.lastMessage(payer.ID).Text(); !strings.Contains(text, fields[len(fields)-2]) {
+		t.Errorf("/proof = %q", text)
+	}
+}
+
+func TestPayWithoutConfirmation(t *testing.T) {
+	h := newTestHarness(t)
+	payer := &tb.User{ID: 5001, Username: "payer", FirstName: "Payer", LanguageCode: "en"}
+	from := h.newUser(payer, 1000)
+	h.sendMessage(payer, privateChat(payer), "/set confirm 50")
+
+	small, err := h.lnbits.ExternalInvoice(50, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.sendMessage(payer, privateChat(payer), "/pay "+small.PaymentRequest)
+	if !h.lnbits.Paid(small.PaymentHash) {
+		t.Error("invoice up to the limit was not paid without confirmation")
+	}
+
+	large, err := h.lnbits.ExternalInvoice(51, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.sendMessage(payer, privateChat(payer), "/pay "+large.PaymentRequest)
+	if h.lnbits.Paid(large.PaymentHash) {
+		t.Error("invoice above the limit was paid without confirmation")
+	}
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 950 {
+		t.Errorf("balance of payer = %d, want 950", balance)
+	}
+}
+
+func TestPayFeeLimit(t *testing.T) {
+	h := newTestHarness(t)
+	payer := &tb.User{ID: 6001, Username: "payer", FirstName: "Payer", LanguageCode: "en"}
+	h.newUser(payer, 2000)
+	h.sendMessage(payer, privateChat(payer), "/set confirm 1000")
+	h.sendMessage(payer, privateChat(payer), "/set maxfee 0.5%")
+
+	invoice, err := h.lnbits.ExternalInvoice(1000, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.sendMessage(payer, privateChat(payer), "/pay "+invoice.PaymentRequest)
+	if h.lnbits.Paid(invoice.PaymentHash) {
+		t.Fatal("invoice above the fee limit was paid without an override")
+	}
+	confirmation := h.lastMessage(payer.ID)
+	if !strings.Contains(confirmation.Text(), "limit of 5 sat") {
+		t.Errorf("confirmation = %q", confirmation.Text())
+	}
+	h.pressButton(payer, confirmation, "⚠️ Pay anyway")
+	if !h.lnbits.Paid(invoice.PaymentHash) {
+		t.Error("invoice was not paid after the override")
+	}
+}
+
+func TestPayInvoiceFromAPI(t *testing.T) {
+	h := newTestHarness(t)
+	payer := &tb.User{ID: 5101, Username: "payer", FirstName: "Payer", LanguageCode: "en"}
+	h.newUser(payer, 1000)
+	h.sendMessage(payer, privateChat(payer), "/set confirm 50")
+	user, err := GetLnbitsUserWithSettings(payer, *h.bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	small, _ := h.lnbits.ExternalInvoice(50, "")
+	if _, err := h.bot.PayInvoiceFromAPI(user, small.PaymentRequest); err != nil {
+		t.Fatal(err)
+	}
+	if !h.lnbits.Paid(small.PaymentHash) {
+		t.Error("invoice up to the limit was not paid")
+	}
+
+	// larger payments wait for the confirmation in Telegram
+	large, _ := h.lnbits.ExternalInvoice(51, "")
+	if _, err := h.bot.PayInvoiceFromAPI(user, large.PaymentRequest); err != errPaymentNeedsConfirmation {
+		t.Fatalf("err = %v, want a confirmation", err)
+	}
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if h.lnbits.Paid(large.PaymentHash) {
+		t.Fatal("invoice above the limit was paid without confirmation")
+	}
+	h.pressButton(payer, h.lastMessage(payer.ID), "✅ Pay")
+	if !h.lnbits.Paid(large.PaymentHash) {
+		t.Error("confirmed invoice was not paid")
+	}
+}
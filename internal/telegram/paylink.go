@@ -0,0 +1,194 @@
+package telegram
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	paymentLinkStartPrefix   = "pay_"
+	paymentLinkDefaultExpiry = 30 * 24 * time.Hour
+)
+
+const (
+	PaymentLinkTypeInvoice = "invoice"
+	PaymentLinkTypeFaucet  = "faucet"
+	PaymentLinkTypeTicket  = "ticket"
+	PaymentLinkTypePool    = "pool"
+)
+
+var paymentLinkTokenRegex = regexp.MustCompile(`^[a-zA-Z]{16}$`)
+
+// PaymentLink points to a payment request, a faucet, a group ticket or a pool with a short token.
+// The token is used in t.me deep links because the start parameter is limited to 64 characters.
+type PaymentLink struct {
+	*storage.Base
+	Token          string       `json:"token"`
+	Type           string       `json:"type"`
+	PaymentRequest string       `json:"payment_request,omitempty"` // for invoices
+	Target         string       `json:"target,omitempty"`          // faucet id, group name or pool id
+	User           *lnbits.User `json:"user"`                      // the user that is being paid or that created the link
+	ExpiresAt      time.Time    `json:"expires_at"`
+}
+
+type PaymentLinkOption func(link *PaymentLink)
+
+func PaymentLinkExpiry(expiresAt time.Time) PaymentLinkOption {
+	return func(link *PaymentLink) {
+		link.ExpiresAt = expiresAt
+	}
+}
+
+func PaymentLinkPaymentRequest(paymentRequest string) PaymentLinkOption {
+	return func(link *PaymentLink) {
+		link.PaymentRequest = paymentRequest
+		// invoice links expire with the invoice
+		if bolt11, err := decodepay.Decodepay(paymentRequest); err == nil {
+			link.ExpiresAt = time.Unix(int64(bolt11.CreatedAt+bolt11.Expiry), 0)
+		}
+	}
+}
+
+func PaymentLinkTarget(target string) PaymentLinkOption {
+	return func(link *PaymentLink) {
+		link.Target = target
+	}
+}
+
+func paymentLinkID(token string) string {
+	return fmt.Sprintf("paylink:%s", token)
+}
+
+func newPaymentLinkToken() string {
+	return RandStringRunes(16)
+}
+
+func (link PaymentLink) expired() bool {
+	return !link.ExpiresAt.IsZero() && time.Now().After(link.ExpiresAt)
+}
+
+// createPaymentLink stores a payment link of a type under token.
+func (bot *TipBot) createPaymentLink(token string, linkType string, user *lnbits.User, opts ...PaymentLinkOption) *PaymentLink {
+	link := &PaymentLink{
+		Base:      storage.New(storage.ID(paymentLinkID(token))),
+		Token:     token,
+		Type:      linkType,
+		User:      user,
+		ExpiresAt: time.Now().Add(paymentLinkDefaultExpiry),
+	}
+	for _, opt := range opts {
+		opt(link)
+	}
+	runtime.IgnoreError(link.Set(link, bot.Bunt))
+	return link
+}
+
+// inactivatePaymentLink is called when the payment request of a link was paid.
+func (bot *TipBot) inactivatePaymentLink(token string) {
+	link := &PaymentLink{Base: storage.New(storage.ID(paymentLinkID(token)))}
+	sn, err := link.Get(link, bot.Bunt)
+	if err != nil {
+		return
+	}
+	link = sn.(*PaymentLink)
+	runtime.IgnoreError(link.Inactivate(link, bot.Bunt))
+}
+
+// deepLink returns the t.me link that opens the bot with a start parameter.
+func (bot *TipBot) deepLink(startParameter string) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s", bot.Telegram.Me.Username, startParameter)
+}
+
+// brand is the name of the bot that is printed on cards.
+func (bot *TipBot) brand() string {
+	return "@" + bot.Telegram.Me.Username
+}
+
+func (link PaymentLink) DeepLink(bot *TipBot) string {
+	return bot.deepLink(paymentLinkStartPrefix + link.Token)
+}
+
+// sendPaymentLink sends the deep link of a faucet or ticket to its creator so it can be shared outside of Telegram.
+func (bot *TipBot) sendPaymentLink(ctx intercept.Context, to *tb.User, link *PaymentLink) {
+	bot.trySendMessage(to, fmt.Sprintf(Translate(ctx, "paymentLinkShareMessage"), link.DeepLink(bot), link.ExpiresAt.UTC().Format("2 Jan 06 15:04 MST")), tb.NoPreview)
+}
+
+// startPaymentLinkHandler is invoked on /start pay_<token> and opens the payment dialog.
+func (bot *TipBot) startPaymentLinkHandler(ctx intercept.Context, token string) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	if !paymentLinkTokenRegex.MatchString(token) {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "paymentLinkInvalidMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	link := &PaymentLink{Base: storage.New(storage.ID(paymentLinkID(token)))}
+	sn, err := link.Get(link, bot.Bunt)
+	if err != nil {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "paymentLinkInvalidMessage"))
+		return ctx, errors.New(errors.NotActiveError, err)
+	}
+	link = sn.(*PaymentLink)
+	if !link.Active {
+		if link.Type == PaymentLinkTypeFaucet || link.Type == PaymentLinkTypeTicket || link.Type == PaymentLinkTypePool {
+			bot.trySendMessage(ctx.Sender(), Translate(ctx, "paymentLinkInactiveMessage"))
+		} else {
+			bot.trySendMessage(ctx.Sender(), Translate(ctx, "paymentLinkPaidMessage"))
+		}
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if link.expired() {
+		runtime.IgnoreError(link.Inactivate(link, bot.Bunt))
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "paymentLinkExpiredMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if link.User != nil && user != nil && link.User.ID == user.ID && link.Type != PaymentLinkTypeTicket && link.Type != PaymentLinkTypePool {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "sendYourselfMessage"))
+		return ctx, errors.Create(errors.SelfPaymentError)
+	}
+	log.Infof("[startPaymentLinkHandler] %s opened %s link %s", GetUserStr(ctx.Sender()), link.Type, token)
+	switch link.Type {
+	case PaymentLinkTypeFaucet:
+		return bot.collectFaucetFromLink(ctx, link)
+	case PaymentLinkTypeTicket:
+		ctx.Message().Text = fmt.Sprintf("/join %s", link.Target)
+		return bot.groupRequestJoinHandler(ctx)
+	case PaymentLinkTypePool:
+		return bot.showPoolFromLink(ctx, link)
+	default:
+		ctx.Message().Text = fmt.Sprintf("/pay %s", link.PaymentRequest)
+		return bot.payHandler(ctx)
+	}
+}
+
+// startParameterHandler dispatches the parameter of /start <parameter> deep links.
+// Returns false if the parameter is unknown.
+func (bot *TipBot) startParameterHandler(ctx intercept.Context, parameter string) (intercept.Context, bool, error) {
+	switch {
+	case strings.HasPrefix(parameter, paymentLinkStartPrefix):
+		ctx, err := bot.startPaymentLinkHandler(ctx, strings.TrimPrefix(parameter, paymentLinkStartPrefix))
+		return ctx, true, err
+	case strings.HasPrefix(parameter, giftStartPrefix):
+		ctx, err := bot.startGiftHandler(ctx, strings.TrimPrefix(parameter, giftStartPrefix))
+		return ctx, true, err
+	case strings.HasPrefix(parameter, escrowStartPrefix):
+		ctx, err := bot.startEscrowHandler(ctx, strings.TrimPrefix(parameter, escrowStartPrefix))
+		return ctx, true, err
+	case strings.HasPrefix(parameter, referralStartPrefix):
+		ctx, err := bot.startReferralHandler(ctx, strings.TrimPrefix(parameter, referralStartPrefix))
+		return ctx, true, err
+	case strings.HasPrefix(parameter, subscribeStartPrefix):
+		ctx, err := bot.startSubscribeHandler(ctx, strings.TrimPrefix(parameter, subscribeStartPrefix))
+		return ctx, true, err
+	}
+	return ctx, false, nil
+}
@@ -0,0 +1,453 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/LightningTipBot/LightningTipBot/pkg/lightning"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	payoutMaxFileSize = 1 << 20
+	payoutMaxRows     = 200
+	// payoutMaxErrors is how many invalid rows are listed when a file is rejected
+	payoutMaxErrors = 10
+	// payoutProgressRows is how often the progress of a running payout is shown
+	payoutProgressRows = 10
+
+	PayoutRowPaid    = "paid"
+	PayoutRowFailed  = "failed"
+	PayoutRowPending = "pending" // a payment to a lightning address is in flight
+)
+
+var (
+	payoutMenu       = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnConfirmPayout = payoutMenu.Data("✅ Pay", "confirm_payout")
+	btnCancelPayout  = payoutMenu.Data("🚫 Cancel", "cancel_payout")
+)
+
+// PayoutRow is a payment of a payout to a user of the bot or to a lightning address.
+type PayoutRow struct {
+	Line        int    `json:"line"` // line in the file
+	Recipient   string `json:"recipient"`
+	Amount      int64  `json:"amount"`
+	Memo        string `json:"memo"`
+	UserId      int64  `json:"user_id,omitempty"` // the recipient without looking up the username
+	Address     bool   `json:"address"`           // the recipient is a lightning address
+	Fee         int64  `json:"fee"`               // service fee of a payment to a user
+	Status      string `json:"status"`
+	Reason      string `json:"reason"`
+	PaymentHash string `json:"payment_hash"`
+}
+
+// Payout is a batch of payments from a CSV file that the user uploaded, for community rewards
+// or payroll. It is paid row by row after the user confirmed the summary. /rain pays out to the
+// recent members of a group the same way.
+type Payout struct {
+	*storage.Base
+	From         *lnbits.User `json:"from"`
+	Type         string       `json:"type"` // transaction type of the payments to users
+	FileName     string       `json:"file_name"`
+	Rows         []PayoutRow  `json:"rows"`
+	Total        int64        `json:"total"`
+	Fees         int64        `json:"fees"`
+	Started      bool         `json:"started"`
+	Message      *tb.Message  `json:"message"`
+	LanguageCode string       `json:"languagecode"`
+}
+
+// parsePayout reads a payout file with the columns recipient, amount and optionally memo. A
+// recipient is a @username or a lightning address. A first line that has no amount is a header.
+// All invalid lines are returned as errors.
+func parsePayout(r io.Reader) ([]PayoutRow, []error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, []error{err}
+	}
+	rows := make([]PayoutRow, 0, len(records))
+	errs := make([]error, 0)
+	for i, record := range records {
+		if len(record) == 1 && len(strings.TrimSpace(record[0])) == 0 {
+			continue
+		}
+		if len(record) < 2 {
+			errs = append(errs, fmt.Errorf("line %d: want recipient,amount[,memo]", i+1))
+			continue
+		}
+		amount, err := GetAmount(strings.TrimSpace(record[1]))
+		if err != nil {
+			if i == 0 {
+				// header
+				continue
+			}
+			errs = append(errs, fmt.Errorf("line %d: invalid amount %q", i+1, record[1]))
+			continue
+		}
+		if amount < 1 {
+			errs = append(errs, fmt.Errorf("line %d: invalid amount %q", i+1, record[1]))
+			continue
+		}
+		recipient := strings.TrimSpace(record[0])
+		row := PayoutRow{Line: i + 1, Recipient: recipient, Amount: amount}
+		if lightning.IsLightningAddress(recipient) {
+			row.Recipient, row.Address = strings.ToLower(recipient), true
+		} else if len(strings.TrimPrefix(recipient, "@")) == 0 || strings.ContainsAny(recipient, " \t") {
+			errs = append(errs, fmt.Errorf("line %d: invalid recipient %q", i+1, recipient))
+			continue
+		} else {
+			row.Recipient = "@" + strings.TrimPrefix(recipient, "@")
+		}
+		if len(record) > 2 {
+			row.Memo = strings.TrimSpace(strings.Join(record[2:], ","))
+		}
+		rows = append(rows, row)
+	}
+	if len(rows)+len(errs) > payoutMaxRows {
+		return nil, []error{fmt.Errorf("more than %d payments", payoutMaxRows)}
+	}
+	if len(rows) == 0 && len(errs) == 0 {
+		errs = append(errs, fmt.Errorf("no payments"))
+	}
+	return rows, errs
+}
+
+// isPayoutCaption returns true if a file was sent to the bot with the caption /payout.
+func (bot *TipBot) isPayoutCaption(m *tb.Message) bool {
+	return m.Private() && m.Document != nil && bot.commandName(m.Caption) == "payout"
+}
+
+// payoutHandler is invoked on /payout and explains how to upload a payout file.
+func (bot *TipBot) payoutHandler(ctx intercept.Context) (intercept.Context, error) {
+	bot.trySendMessage(ctx.Message().Sender, Translate(ctx, "payoutHelpMessage"))
+	return ctx, nil
+}
+
+// payoutFileHandler is invoked on a CSV file with the caption /payout. It validates all rows and
+// shows the total with fees before anything is paid.
+func (bot *TipBot) payoutFileHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if user == nil || user.Wallet == nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "payoutNoWalletMessage"))
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if m.Document.FileSize > payoutMaxFileSize {
+		bot.trySendMessage(m.Sender, Translate(ctx, "payoutFileTooLargeMessage"))
+		return ctx, fmt.Errorf("payout file of %d bytes", m.Document.FileSize)
+	}
+	reader, err := bot.Telegram.File(&m.Document.File)
+	if err != nil {
+		log.Errorf("[payout] could not download file: %v", err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	defer reader.Close()
+	rows, errs := parsePayout(io.LimitReader(reader, payoutMaxFileSize))
+	// the recipients must have a wallet, the sender can't pay themselves
+	for i, row := range rows {
+		if row.Address {
+			continue
+		}
+		to, err := GetUserByTelegramUsername(strings.TrimPrefix(row.Recipient, "@"), *bot)
+		if err != nil || to.Wallet == nil {
+			errs = append(errs, fmt.Errorf("line %d: %s has no wallet", row.Line, row.Recipient))
+			continue
+		}
+		if to.Telegram.ID == user.Telegram.ID {
+			errs = append(errs, fmt.Errorf("line %d: you can't pay yourself", row.Line))
+			continue
+		}
+		rows[i].Fee = serviceFee(user, "send", row.Amount)
+	}
+	if len(errs) > 0 {
+		lines := make([]string, 0, payoutMaxErrors)
+		for i, err := range errs {
+			if i == payoutMaxErrors {
+				lines = append(lines, fmt.Sprintf(Translate(ctx, "payoutMoreErrorsMessage"), len(errs)-payoutMaxErrors))
+				break
+			}
+			lines = append(lines, str.MarkdownEscape(err.Error()))
+		}
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "payoutInvalidMessage"), strings.Join(lines, "\n")))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	payout := &Payout{
+		Base:         storage.New(storage.ID(fmt.Sprintf("payout:%s", RandStringRunes(10)))),
+		From:         user,
+		Type:         "send",
+		FileName:     m.Document.FileName,
+		Rows:         rows,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	addresses := 0
+	for _, row := range rows {
+		payout.Total += row.Amount
+		payout.Fees += row.Fee
+		if row.Address {
+			addresses++
+		}
+	}
+	text := fmt.Sprintf(Translate(ctx, "payoutSummaryMessage"), len(rows), payout.Total, payout.Fees, payout.Total+payout.Fees)
+	if addresses > 0 {
+		text += fmt.Sprintf(Translate(ctx, "payoutRoutingFeesMessage"), addresses)
+	}
+	if balance, err := bot.GetUserBalance(user); err == nil && balance < payout.Total+payout.Fees {
+		bot.trySendMessage(m.Sender, text+fmt.Sprintf(Translate(ctx, "payoutBalanceTooLowMessage"), balance))
+		return ctx, errors.Create(errors.BalanceToLowError)
+	}
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	confirmButton := menu.Data(Translate(ctx, "payoutConfirmButtonMessage"), "confirm_payout", payout.ID)
+	cancelButton := menu.Data(Translate(ctx, "cancelButtonMessage"), "cancel_payout", payout.ID)
+	menu.Inline(menu.Row(confirmButton, cancelButton))
+	payout.Message = bot.trySendMessageEditable(m.Sender, text, menu)
+	logger(ctx).Infof("[📋 payout] %s uploaded %s with %d payments of %d sat", GetUserStr(user.Telegram), payout.ID, len(rows), payout.Total)
+	return ctx, payout.Set(payout, bot.Bunt)
+}
+
+// loadPayout loads a payout of the sender that was not started yet.
+func (bot *TipBot) loadPayout(ctx intercept.Context) (*Payout, error) {
+	payout := &Payout{Base: storage.New(storage.ID(ctx.Data()))}
+	sn, err := payout.Get(payout, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	payout = sn.(*Payout)
+	if !payout.Active || payout.Started {
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	if payout.From.Telegram.ID != ctx.Sender().ID {
+		return nil, errors.Create(errors.UnknownError)
+	}
+	return payout, nil
+}
+
+// cancelPayoutHandler is invoked when the user cancels a payout before it started.
+func (bot *TipBot) cancelPayoutHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	payout, err := bot.loadPayout(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	bot.tryEditMessage(payout.Message, Translate(ctx, "payoutCancelledMessage"), &tb.ReplyMarkup{})
+	return ctx, payout.Inactivate(payout, bot.Bunt)
+}
+
+// confirmPayoutHandler is invoked when the user confirms a payout. The payout is paid in the
+// background and can't be confirmed again after it started.
+func (bot *TipBot) confirmPayoutHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	payout, err := bot.loadPayout(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	payout.Started = true
+	if err := payout.Set(payout, bot.Bunt); err != nil {
+		return ctx, err
+	}
+	logger(ctx).Infof("[📋 payout] %s started %s", GetUserStr(payout.From.Telegram), payout.ID)
+	bot.tryEditMessage(payout.Message, payout.progressStr(0), &tb.ReplyMarkup{})
+	go bot.runPayout(payout.ID)
+	return ctx, nil
+}
+
+// startPayoutWorker resumes the payouts that were interrupted by a restart.
+func (bot *TipBot) startPayoutWorker() {
+	go func() {
+		for _, id := range bot.startedPayouts() {
+			bot.runPayout(id)
+		}
+	}()
+}
+
+func (bot *TipBot) startedPayouts() []string {
+	ids := []string{}
+	bot.Bunt.Ascend("payout", func(key, value string) bool {
+		payout := &Payout{}
+		err := json.Unmarshal([]byte(value), payout)
+		if err == nil && payout.Base != nil && payout.Active && payout.Started {
+			ids = append(ids, payout.ID)
+		}
+		return true // continue iteration
+	})
+	return ids
+}
+
+// runPayout pays the open rows of a started payout and sends the results to its sender.
+func (bot *TipBot) runPayout(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	payout := &Payout{Base: storage.New(storage.ID(id))}
+	sn, err := payout.Get(payout, bot.Bunt)
+	if err != nil {
+		log.Errorf("[payout] could not load %s: %v", id, err)
+		return
+	}
+	payout = sn.(*Payout)
+	if !payout.Active || !payout.Started {
+		return
+	}
+	from, err := GetUser(payout.From.Telegram, *bot)
+	if err != nil {
+		log.Errorf("[payout] could not load the sender of %s: %v", id, err)
+		return
+	}
+	if err := bot.executePayout(payout, from); err != nil {
+		log.Errorf("[payout] could not finish %s: %v", id, err)
+		return
+	}
+	if payout.Message != nil {
+		bot.tryEditMessage(payout.Message, payout.resultStr(), &tb.ReplyMarkup{})
+	}
+	// a /rain reports its result in the group
+	if len(payout.FileName) == 0 {
+		return
+	}
+	results, err := payoutResults(payout)
+	if err != nil {
+		log.Errorf("[payout] could not write the results of %s: %v", payout.ID, err)
+		return
+	}
+	bot.trySendMessage(from.Telegram, &tb.Document{
+		File:     tb.File{FileReader: bytes.NewReader(results)},
+		FileName: fmt.Sprintf("%s-results.csv", strings.TrimSuffix(payout.FileName, ".csv")),
+		MIME:     "text/csv",
+	})
+}
+
+// executePayout pays the open rows of a payout from the wallet of from. The status of every row
+// is stored after it was paid, so that a resumed payout pays no row twice.
+func (bot *TipBot) executePayout(payout *Payout, from *lnbits.User) error {
+	payout.Started = true
+	if err := payout.Set(payout, bot.Bunt); err != nil {
+		return err
+	}
+	for i := range payout.Rows {
+		row := &payout.Rows[i]
+		switch row.Status {
+		case PayoutRowPaid, PayoutRowFailed:
+			continue
+		case PayoutRowPending:
+			// the payment to the lightning address was interrupted and may have been sent
+			row.Status, row.Reason = PayoutRowFailed, "interrupted, check your transactions before paying again"
+		default:
+			bot.payPayoutRow(payout, from, row)
+		}
+		runtime.IgnoreError(payout.Set(payout, bot.Bunt))
+		if payout.Message != nil && (i+1)%payoutProgressRows == 0 && i+1 < len(payout.Rows) {
+			bot.tryEditMessage(payout.Message, payout.progressStr(i+1))
+		}
+	}
+	return payout.Inactivate(payout, bot.Bunt)
+}
+
+// payPayoutRow pays a row of a payout and records its status.
+func (bot *TipBot) payPayoutRow(payout *Payout, from *lnbits.User, row *PayoutRow) {
+	memo := row.Memo
+	if len(memo) == 0 {
+		memo = fmt.Sprintf("📋 Payout of %s.", GetUserStr(from.Telegram))
+	}
+	if row.Address {
+		// payments to lightning addresses have no idempotency key, a pending row is not
+		// paid again when the payout is resumed
+		row.Status = PayoutRowPending
+		runtime.IgnoreError(payout.Set(payout, bot.Bunt))
+		paymentHash, err := bot.payLightningAddressWithParams(from, row.Recipient, row.Amount, memo, lnbits.PaymentParams{Out: true, Batch: true})
+		if err != nil {
+			row.Status, row.Reason = PayoutRowFailed, err.Error()
+			return
+		}
+		row.Status, row.PaymentHash = PayoutRowPaid, paymentHash
+		return
+	}
+	var to *lnbits.User
+	var err error
+	if row.UserId != 0 {
+		to, err = GetUser(&tb.User{ID: row.UserId}, *bot)
+	} else {
+		to, err = GetUserByTelegramUsername(strings.TrimPrefix(row.Recipient, "@"), *bot)
+	}
+	if err != nil || to.Wallet == nil {
+		row.Status, row.Reason = PayoutRowFailed, "no wallet"
+		return
+	}
+	t := NewTransaction(bot, from, to, row.Amount, TransactionType(payout.Type),
+		TransactionIdempotencyKey(fmt.Sprintf("%s:%d", payout.ID, row.Line)), TransactionBatch())
+	t.Memo = memo
+	success, err := t.Send()
+	if err == errDuplicateOperation {
+		// paid before the payout was interrupted, the recipient was notified then
+		row.Status = PayoutRowPaid
+		return
+	}
+	if !success {
+		row.Status, row.Reason = PayoutRowFailed, err.Error()
+		return
+	}
+	row.Status, row.PaymentHash = PayoutRowPaid, t.Invoice.PaymentHash
+	if payout.Type == rainTransactionType {
+		bot.notify(to, NotificationTip, row.Amount, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "rainReceivedMessage"),
+			GetUserStrMd(from.Telegram), row.Amount)+bot.fiatAmount(to, row.Amount))
+		return
+	}
+	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "payoutReceivedMessage"),
+		GetUserStrMd(from.Telegram), row.Amount, str.MarkdownEscape(row.Memo)))
+}
+
+func (payout *Payout) progressStr(done int) string {
+	return fmt.Sprintf(i18n.Translate(payout.LanguageCode, "payoutProgressMessage"), done, len(payout.Rows))
+}
+
+// resultStr counts the payments that were paid and that failed.
+func (payout *Payout) resultStr() string {
+	var paid, failed int
+	var paidAmount, failedAmount int64
+	for _, row := range payout.Rows {
+		if row.Status == PayoutRowPaid {
+			paid++
+			paidAmount += row.Amount
+		} else {
+			failed++
+			failedAmount += row.Amount
+		}
+	}
+	text := fmt.Sprintf(i18n.Translate(payout.LanguageCode, "payoutDoneMessage"), paid, paidAmount)
+	if failed > 0 {
+		text += fmt.Sprintf(i18n.Translate(payout.LanguageCode, "payoutFailedRowsMessage"), failed, failedAmount)
+	}
+	return text
+}
+
+// payoutResults returns the rows of a payout with their status as CSV.
+func payoutResults(payout *Payout) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	writer := csv.NewWriter(buf)
+	records := [][]string{{"line", "recipient", "amount", "memo", "status", "reason", "payment_hash"}}
+	for _, row := range payout.Rows {
+		records = append(records, []string{strconv.Itoa(row.Line), row.Recipient, strconv.FormatInt(row.Amount, 10), row.Memo,
+			row.Status, row.Reason, row.PaymentHash})
+	}
+	if err := writer.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,113 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// finishPayouts waits for the payouts that run in the background.
+func (h *testHarness) finishPayouts() {
+	ids := []string{}
+	h.bot.Bunt.Ascend("payout", func(key, value string) bool {
+		ids = append(ids, key)
+		return true
+	})
+	for _, id := range ids {
+		h.bot.runPayout(id)
+	}
+	h.sent = append(h.sent, h.telegram.Requests()...)
+}
+
+func TestPayout(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9941, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9942, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	carol := &tb.User{ID: 9943, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	from := h.newUser(alice, 5000)
+	toBob := h.newUser(bob, 0)
+	toCarol := h.newUser(carol, 0)
+
+	// nothing is paid if a line is invalid
+	h.sendDocument(alice, privateChat(alice), "/payout", "rewards.csv", "recipient,amount,memo\n@bob,1000\n@nobody,500\ncarol,abc\n")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "line 3") || !strings.Contains(text, "line 4") || strings.Contains(text, "line 2") {
+		t.Fatalf("message = %q", text)
+	}
+
+	h.sendDocument(alice, privateChat(alice), "/payout", "rewards.csv", "recipient,amount,memo\n@bob,1000,Thanks for the artwork\ncarol,1500\n")
+	summary := h.lastMessage(alice.ID)
+	if text := summary.Text(); !strings.Contains(text, "2 payments: 2500 sat") {
+		t.Fatalf("summary = %q", text)
+	}
+	if balance := h.lnbits.Balance(toBob.Wallet.ID); balance != 0 {
+		t.Fatalf("balance of bob = %d before the payout was confirmed, want 0", balance)
+	}
+	h.pressButton(alice, summary, "✅ Pay")
+	h.finishPayouts()
+	if balance := h.lnbits.Balance(toBob.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of bob = %d, want 1000", balance)
+	}
+	if balance := h.lnbits.Balance(toCarol.Wallet.ID); balance != 1500 {
+		t.Errorf("balance of carol = %d, want 1500", balance)
+	}
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "2 payments of 2500 sat were paid") {
+		t.Errorf("result = %q", text)
+	}
+	if !h.called("sendDocument", alice.ID) {
+		t.Errorf("no results file")
+	}
+
+	// a payout is only paid once
+	h.pressButton(alice, summary, "✅ Pay")
+	h.finishPayouts()
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 2500 {
+		t.Errorf("balance of alice = %d, want 2500", balance)
+	}
+}
+
+func TestPayoutResume(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9941, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9942, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	carol := &tb.User{ID: 9943, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	from := h.newUser(alice, 5000)
+	toBob := h.newUser(bob, 0)
+	toCarol := h.newUser(carol, 0)
+
+	// a restart interrupted the payout after it paid bob
+	payout := &Payout{
+		Base:         storage.New(storage.ID("payout:resume")),
+		From:         from,
+		Type:         "send",
+		FileName:     "rewards.csv",
+		Started:      true,
+		LanguageCode: "en",
+		Rows: []PayoutRow{
+			{Line: 2, Recipient: "@bob", UserId: bob.ID, Amount: 1000, Status: PayoutRowPaid},
+			{Line: 3, Recipient: "@carol", UserId: carol.ID, Amount: 1500},
+		},
+		Total: 2500,
+	}
+	if err := payout.Set(payout, h.bot.Bunt); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range h.bot.startedPayouts() {
+		h.bot.runPayout(id)
+	}
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if balance := h.lnbits.Balance(toBob.Wallet.ID); balance != 0 {
+		t.Errorf("balance of bob = %d, the paid row was paid again", balance)
+	}
+	if balance := h.lnbits.Balance(toCarol.Wallet.ID); balance != 1500 {
+		t.Errorf("balance of carol = %d, want 1500", balance)
+	}
+	if !h.called("sendDocument", alice.ID) {
+		t.Errorf("no results file")
+	}
+	if ids := h.bot.startedPayouts(); len(ids) != 0 {
+		t.Errorf("started payouts = %v after the payout was resumed", ids)
+	}
+}
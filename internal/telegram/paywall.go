@@ -0,0 +1,146 @@
+package telegram
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Paid media are encrypted at rest. Every media has its own content key that encrypts its
+// Telegram file id, and the content key is stored encrypted with the paywall key of the
+// configuration. A copy of the databases alone doesn't reveal paid media, the bot only opens
+// a media to send it to its seller and to buyers who paid for it.
+
+var errPaywallNotConfigured = fmt.Errorf("no paywall_key configured")
+
+func paywallKey() ([]byte, error) {
+	if len(internal.Configuration.Bot.PaywallKeyBytes) == 0 {
+		return nil, errPaywallNotConfigured
+	}
+	return internal.Configuration.Bot.PaywallKeyBytes, nil
+}
+
+// paywallSeal encrypts plaintext with AES-GCM and returns the nonce and ciphertext in base64.
+func paywallSeal(key, plaintext []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// paywallOpen decrypts the output of paywallSeal.
+func paywallOpen(key []byte, sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("sealed data too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+// sealPaidMedia encrypts the file id of a media with a new content key.
+func sealPaidMedia(media *PaidMedia, fileId string) error {
+	key, err := paywallKey()
+	if err != nil {
+		return err
+	}
+	contentKey := make([]byte, 32)
+	if _, err := rand.Read(contentKey); err != nil {
+		return err
+	}
+	sealedFileId, err := paywallSeal(contentKey, []byte(fileId))
+	if err != nil {
+		return err
+	}
+	sealedKey, err := paywallSeal(key, contentKey)
+	if err != nil {
+		return err
+	}
+	media.FileId, media.ContentKey = sealedFileId, sealedKey
+	return nil
+}
+
+// fileId decrypts the file id of the media. Media from before the encryption have no
+// content key until the paywall key is configured and the migration sealed them.
+func (media *PaidMedia) fileId() (string, error) {
+	if len(media.ContentKey) == 0 {
+		return media.FileId, nil
+	}
+	key, err := paywallKey()
+	if err != nil {
+		return "", err
+	}
+	contentKey, err := paywallOpen(key, media.ContentKey)
+	if err != nil {
+		return "", err
+	}
+	fileId, err := paywallOpen(contentKey, media.FileId)
+	if err != nil {
+		return "", err
+	}
+	return string(fileId), nil
+}
+
+// sealLegacyPaidMedia encrypts the paid media that were stored unencrypted, before the
+// encryption or while no paywall_key was configured. It runs on every start.
+func sealLegacyPaidMedia(db *gorm.DB) error {
+	medias := make([]PaidMedia, 0)
+	db.Where("content_key = ? OR content_key IS NULL", "").Find(&medias)
+	if len(medias) == 0 {
+		return nil
+	}
+	if _, err := paywallKey(); err != nil {
+		log.Warnf("[paywall] %d paid media stay unencrypted until a paywall_key is configured", len(medias))
+		return nil
+	}
+	for _, media := range medias {
+		if err := sealPaidMedia(&media, media.FileId); err != nil {
+			return err
+		}
+		if tx := db.Model(&media).Updates(map[string]interface{}{"file_id": media.FileId, "content_key": media.ContentKey}); tx.Error != nil {
+			return tx.Error
+		}
+	}
+	return nil
+}
+
+// unsealPaidMedia stores the file ids of all paid media in plain text again.
+func unsealPaidMedia(db *gorm.DB) error {
+	medias := make([]PaidMedia, 0)
+	db.Where("content_key <> ?", "").Find(&medias)
+	for _, media := range medias {
+		fileId, err := media.fileId()
+		if err != nil {
+			return err
+		}
+		if tx := db.Model(&media).Updates(map[string]interface{}{"file_id": fileId, "content_key": ""}); tx.Error != nil {
+			return tx.Error
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	log "github.com/sirupsen/logrus"
+)
+
+// pendingMaxEntries is the number of invoices and of payments listed by /pending.
+const pendingMaxEntries = 10
+
+// PendingPayment is an invoice of the user that is not paid yet or an outgoing payment
+// that is still in flight.
+type PendingPayment struct {
+	lnbits.Payment
+	Expires time.Time // zero for outgoing payments and invoices that don't decode
+}
+
+// Sat is the absolute amount of the payment in sat.
+func (p PendingPayment) Sat() int64 {
+	if p.Amount < 0 {
+		return -p.Amount / 1000
+	}
+	return p.Amount / 1000
+}
+
+// getPendingPayments returns the unsettled invoices of the user that have not expired yet
+// and the outgoing payments that are still in flight, the newest first.
+func (bot *TipBot) getPendingPayments(user *lnbits.User) (incoming []PendingPayment, outgoing []PendingPayment, err error) {
+	payments, err := bot.Client.Payments(*user.Wallet)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range payments {
+		if !p.Pending {
+			continue
+		}
+		if p.Amount < 0 {
+			outgoing = append(outgoing, PendingPayment{Payment: p})
+			continue
+		}
+		pending := PendingPayment{Payment: p}
+		if bolt11, err := decodepay.Decodepay(p.Bolt11); err == nil {
+			pending.Expires = time.Unix(int64(bolt11.CreatedAt+bolt11.Expiry), 0)
+			if pending.Expires.Before(time.Now()) {
+				// invoice expired
+				continue
+			}
+		}
+		incoming = append(incoming, pending)
+	}
+	return incoming, outgoing, nil
+}
+
+// formatPendingDuration formats a duration in hours and minutes.
+func formatPendingDuration(d time.Duration) string {
+	if d < time.Minute {
+		return "<1m"
+	}
+	d = d.Round(time.Minute)
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// pendingHandler is invoked on /pending and lists the unpaid invoices and the payments in
+// flight of the user.
+func (bot *TipBot) pendingHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	incoming, outgoing, err := bot.getPendingPayments(user)
+	if err != nil {
+		log.Errorf("[/pending] Error fetching %s's pending payments: %s", GetUserStr(ctx.Sender()), err)
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "balanceErrorMessage"))
+		return ctx, err
+	}
+	if len(incoming) == 0 && len(outgoing) == 0 {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "pendingNothingMessage"))
+		return ctx, nil
+	}
+	all := append(append(lnbits.Payments{}, paymentsOf(incoming)...), paymentsOf(outgoing)...)
+	counterparties, _ := bot.loadCounterparties(user.Wallet, all)
+	now := time.Now()
+	message := Translate(ctx, "pendingMessage")
+	if len(incoming) > 0 {
+		message += Translate(ctx, "pendingIncomingMessage")
+		for i, p := range incoming {
+			if i == pendingMaxEntries {
+				message += fmt.Sprintf(Translate(ctx, "pendingMoreMessage"), len(incoming)-i)
+				break
+			}
+			expires := "?"
+			if !p.Expires.IsZero() {
+				expires = formatPendingDuration(p.Expires.Sub(now))
+			}
+			message += fmt.Sprintf(Translate(ctx, "pendingInvoiceMessage"), p.Sat(), expires)
+			message += pendingDetails(p, counterparties)
+		}
+	}
+	if len(outgoing) > 0 {
+		message += Translate(ctx, "pendingOutgoingMessage")
+		for i, p := range outgoing {
+			if i == pendingMaxEntries {
+				message += fmt.Sprintf(Translate(ctx, "pendingMoreMessage"), len(outgoing)-i)
+				break
+			}
+			since := formatPendingDuration(now.Sub(time.Unix(int64(p.Time), 0)))
+			message += fmt.Sprintf(Translate(ctx, "pendingPaymentMessage"), p.Sat(), since)
+			message += pendingDetails(p, counterparties)
+		}
+		message += Translate(ctx, "pendingInFlightMessage")
+	}
+	bot.trySendMessage(ctx.Sender(), message)
+	return ctx, nil
+}
+
+func paymentsOf(pending []PendingPayment) lnbits.Payments {
+	payments := lnbits.Payments{}
+	for _, p := range pending {
+		payments = append(payments, p.Payment)
+	}
+	return payments
+}
+
+// pendingDetails returns the counterparty and the memo of a pending payment.
+func pendingDetails(p PendingPayment, counterparties map[string]string) string {
+	details := ""
+	if counterparty, ok := counterparties[p.PaymentHash]; ok {
+		if p.Amount < 0 {
+			details += fmt.Sprintf(" → `%s`", str.MarkdownEscape(counterparty))
+		} else {
+			details += fmt.Sprintf(" ← `%s`", str.MarkdownEscape(counterparty))
+		}
+	}
+	memo := p.Memo
+	if len([]rune(memo)) > 50 {
+		memo = string([]rune(memo)[:50]) + "..."
+	}
+	if len(memo) > 0 {
+		details += fmt.Sprintf("\n   ✉️ %s", str.MarkdownEscape(memo))
+	}
+	return details
+}
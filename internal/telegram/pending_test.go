@@ -0,0 +1,39 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestPendingPayments(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9701, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	user := h.newUser(alice, 1000)
+
+	h.sendMessage(alice, privateChat(alice), "/pending")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "no pending payments") {
+		t.Errorf("/pending without pending payments = %q", text)
+	}
+
+	if _, err := h.bot.Client.CreateInvoice(*user.Wallet, lnbits.InvoiceParams{Amount: 210, Memo: "coffee"}); err != nil {
+		t.Fatal(err)
+	}
+	h.sendMessage(alice, privateChat(alice), "/pending")
+	text := h.lastMessage(alice.ID).Text()
+	if !strings.Contains(text, "210 sat, expires in") || !strings.Contains(text, "coffee") {
+		t.Errorf("/pending = %q, want the unpaid invoice", text)
+	}
+
+	user.Initialized = true
+	if err := UpdateUserRecord(user, *h.bot); err != nil {
+		t.Fatal(err)
+	}
+	h.sendMessage(alice, privateChat(alice), "/balance")
+	text = h.lastMessage(alice.ID).Text()
+	if !strings.Contains(text, "Pending incoming: 210 sat") || !strings.Contains(text, "/pending") {
+		t.Errorf("/balance = %q, want the pending invoice", text)
+	}
+}
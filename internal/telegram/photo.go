@@ -44,11 +44,14 @@ func TryRecognizeQrCode(img image.Image) (*gozxing.Result, error) {
 func (bot *TipBot) photoHandler(ctx intercept.Context) (intercept.Context, error) {
 	m := ctx.Message()
 	if m.Chat.Type != tb.ChatPrivate {
-		return ctx, errors.Create(errors.NoPrivateChatError)
+		return bot.tipMediaHandler(ctx)
 	}
 	if m.Photo == nil {
 		return ctx, errors.Create(errors.NoPhotoError)
 	}
+	if bot.isPaidMediaCaption(m) {
+		return bot.addPaidMediaHandler(ctx)
+	}
 	user := LoadUser(ctx)
 	if c := stateCallbackMessage[user.StateKey]; c != nil {
 		ctx, err := c(ctx)
@@ -0,0 +1,624 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	lnurl "github.com/fiatjaf/go-lnurl"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const poolDuration = 7 * 24 * time.Hour
+
+var (
+	poolMenu          = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnContributePool = poolMenu.Data("⚡️", "contribute_pool")
+	btnCancelPool     = poolMenu.Data("🚫", "cancel_pool")
+	// /pool "pizza night" 100000 [@recipient|name@domain.com], with straight or curly quotes
+	poolCommandRegex = regexp.MustCompile(`^/\S+\s+["“”]([^"“”]+)["“”]\s+(\S+)(?:\s+(\S+))?\s*$`)
+)
+
+// PoolContribution is the sum that a user contributed to a pool. Contributions from outside
+// of Telegram have no user but the name that the payer entered.
+type PoolContribution struct {
+	User     *tb.User `json:"user,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	Amount   int64    `json:"amount"`
+	Refunded bool     `json:"refunded,omitempty"`
+}
+
+// Contributor returns the name of the contributor.
+func (c PoolContribution) Contributor() string {
+	if c.User != nil {
+		return GetUserStr(c.User)
+	}
+	if len(c.Name) > 0 {
+		return c.Name
+	}
+	return "anonymous"
+}
+
+// PoolUpdate is a message of the creator of a pool to its contributors.
+type PoolUpdate struct {
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+// Pool collects contributions of group members toward a goal. The contributions are held
+// in the wallet of the bot until the goal is reached and paid out to the recipient, or
+// refunded if the pool is cancelled or expires. Outsiders contribute to a pool through its
+// public page, their contributions can not be refunded and are paid out in any case.
+type Pool struct {
+	*storage.Base
+	Title            string              `json:"title"`
+	Goal             int64               `json:"goal"`
+	Collected        int64               `json:"collected"`
+	Creator          *lnbits.User        `json:"creator"`
+	Recipient        *lnbits.User        `json:"recipient"`
+	RecipientAddress string              `json:"recipient_address,omitempty"` // lightning address instead of the recipient
+	Contributions    []*PoolContribution `json:"contributions"`
+	Updates          []*PoolUpdate       `json:"updates"`
+	Token            string              `json:"token"` // of the payment link of the pool
+	Message          *tb.Message         `json:"message"`
+	Expires          time.Time           `json:"expires"`
+	LanguageCode     string              `json:"languagecode"`
+	Cancelled        bool                `json:"cancelled,omitempty"`
+	Settled          bool                `json:"settled,omitempty"` // the outside contributions of a refunded pool were paid out
+}
+
+// poolContributionAmounts returns the amounts of the contribution buttons of a pool.
+func poolContributionAmounts(goal int64) []int64 {
+	amounts := make([]int64, 0)
+	for _, divisor := range []int64{100, 20, 10} {
+		amount := goal / divisor
+		if amount < 1 {
+			amount = 1
+		}
+		if len(amounts) == 0 || amounts[len(amounts)-1] != amount {
+			amounts = append(amounts, amount)
+		}
+	}
+	return amounts
+}
+
+func (pool *Pool) contributors() int {
+	return len(pool.Contributions)
+}
+
+// addContribution adds the contribution of a Telegram user or, if user is nil, of an outsider.
+func (pool *Pool) addContribution(user *tb.User, name string, amount int64) {
+	pool.Collected += amount
+	for _, c := range pool.Contributions {
+		if user != nil && c.User != nil && c.User.ID == user.ID {
+			c.Amount += amount
+			return
+		}
+	}
+	pool.Contributions = append(pool.Contributions, &PoolContribution{User: user, Name: name, Amount: amount})
+}
+
+func (pool *Pool) recipientStrMd() string {
+	if len(pool.RecipientAddress) > 0 {
+		return str.MarkdownEscape(pool.RecipientAddress)
+	}
+	return GetUserStrMd(pool.Recipient.Telegram)
+}
+
+// poolText returns the message of an active pool with its progress bar.
+func (bot *TipBot) poolText(pool *Pool) string {
+	text := fmt.Sprintf(i18n.Translate(pool.LanguageCode, "poolMessage"),
+		str.MarkdownEscape(pool.Title),
+		GetUserStrMd(pool.Creator.Telegram),
+		pool.recipientStrMd(),
+		pool.Goal,
+		pool.Collected,
+		pool.contributors(),
+		MakeProgressbar(pool.Collected, pool.Goal),
+		pool.Expires.UTC().Format("2 Jan 06 15:04 MST"),
+	)
+	if len(pool.Token) > 0 {
+		text += fmt.Sprintf(i18n.Translate(pool.LanguageCode, "poolAppendLinks"), pool.DeepLink(bot), poolPageURL(pool.Token))
+	}
+	return text
+}
+
+func (bot *TipBot) makePoolKeyboard(pool *Pool) *tb.ReplyMarkup {
+	poolMenu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	buttons := make([]tb.Btn, 0)
+	for _, amount := range poolContributionAmounts(pool.Goal) {
+		buttons = append(buttons, poolMenu.Data(fmt.Sprintf("⚡️ %d", amount), "contribute_pool", pool.ID, fmt.Sprint(amount)))
+	}
+	cancelButton := poolMenu.Data(i18n.Translate(pool.LanguageCode, "cancelButtonMessage"), "cancel_pool", pool.ID)
+	poolMenu.Inline(poolMenu.Row(buttons...), poolMenu.Row(cancelButton))
+	return poolMenu
+}
+
+// EscrowWallet returns the user of the bot, whose wallet holds the contributions of pools
+// and the funds of gifts until they are redeemed.
+func (bot *TipBot) EscrowWallet() (*lnbits.User, error) {
+	user, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return nil, err
+	}
+	if user.Wallet == nil {
+		return nil, fmt.Errorf("bot has no wallet")
+	}
+	return user, nil
+}
+
+func isLightningAddress(address string) bool {
+	_, _, ok := lnurl.ParseInternetIdentifier(address)
+	return ok && strings.Contains(address, "@")
+}
+
+// DeepLink returns the t.me link that shows the pool in a private chat with the bot.
+func (pool Pool) DeepLink(bot *TipBot) string {
+	return bot.deepLink(paymentLinkStartPrefix + pool.Token)
+}
+
+// poolPageURL returns the public page of a pool where outsiders can contribute.
+func poolPageURL(token string) string {
+	return fmt.Sprintf("%s/pool/%s", internal.Configuration.Bot.LNURLHostName, token)
+}
+
+// poolHandler is invoked on /pool "<title>" <goal> [@recipient|lightning address] in a group.
+func (bot *TipBot) poolHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if arg, err := getArgumentFromCommand(m.Text, 1); err == nil && strings.ToLower(arg) == "update" {
+		return bot.poolUpdateHandler(ctx)
+	}
+	if m.Private() {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "poolHelpText"), Translate(ctx, "poolHelpPoolInGroup")))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	matches := poolCommandRegex.FindStringSubmatch(m.Text)
+	if matches == nil || len(strings.TrimSpace(matches[1])) == 0 {
+		NewMessage(m, WithDuration(0, bot))
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "poolHelpText"), ""))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	goal, err := GetAmount(matches[2])
+	if err != nil || goal < 1 {
+		NewMessage(m, WithDuration(0, bot))
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "poolHelpText"), Translate(ctx, "poolInvalidAmountMessage")))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	creator := LoadUser(ctx)
+	recipient := creator
+	recipientAddress := ""
+	switch {
+	case strings.HasPrefix(matches[3], "@"):
+		recipient, err = GetUserByTelegramUsername(strings.TrimPrefix(matches[3], "@"), *bot)
+		if err != nil {
+			NewMessage(m, WithDuration(0, bot))
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape(matches[3])))
+			return ctx, errors.Create(errors.UserNoWalletError)
+		}
+	case isLightningAddress(matches[3]):
+		recipientAddress = strings.ToLower(matches[3])
+	case len(matches[3]) > 0:
+		NewMessage(m, WithDuration(0, bot))
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "poolHelpText"), ""))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if _, err := bot.EscrowWallet(); err != nil {
+		log.Errorf("[/pool] %v", err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+
+	title := strings.TrimSpace(matches[1])
+	if runes := []rune(title); len(runes) > 100 {
+		title = string(runes[:100]) + "..."
+	}
+	pool := &Pool{
+		Base:             storage.New(storage.ID(fmt.Sprintf("pool:%s", RandStringRunes(10)))),
+		Title:            title,
+		Goal:             goal,
+		Creator:          creator,
+		Recipient:        recipient,
+		RecipientAddress: recipientAddress,
+		Contributions:    make([]*PoolContribution, 0),
+		Updates:          make([]*PoolUpdate, 0),
+		Token:            newPaymentLinkToken(),
+		Expires:          time.Now().Add(poolDuration),
+		LanguageCode:     ctx.Value("publicLanguageCode").(string),
+	}
+	bot.createPaymentLink(pool.Token, PaymentLinkTypePool, creator, PaymentLinkTarget(pool.ID), PaymentLinkExpiry(pool.Expires))
+	pool.Message = bot.trySendMessageEditable(bot.topicOf(m), bot.poolText(pool), bot.makePoolKeyboard(pool), tb.NoPreview)
+	if pool.Message == nil {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	bot.tryDeleteMessage(m)
+	logger(ctx).Infof("[/pool] %s created pool %s for %s: %d sat", GetUserStr(creator.Telegram), pool.ID, pool.recipientStrMd(), goal)
+	bot.startPoolTimer(pool)
+	return ctx, pool.Set(pool, bot.Bunt)
+}
+
+// loadPool loads an active pool.
+func (bot *TipBot) loadPool(id string) (*Pool, error) {
+	pool := &Pool{Base: storage.New(storage.ID(id))}
+	sn, err := pool.Get(pool, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	pool = sn.(*Pool)
+	if !pool.Active {
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	return pool, nil
+}
+
+// contributePoolHandler is invoked when a group member clicks a contribution button.
+func (bot *TipBot) contributePoolHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	data := strings.Split(c.Data, "|")
+	if len(data) != 2 {
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	mutex.LockWithContext(ctx, data[0])
+	defer mutex.UnlockWithContext(ctx, data[0])
+	pool, err := bot.loadPool(data[0])
+	if err != nil {
+		return ctx, err
+	}
+	if pool.Cancelled {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if pool.Collected >= pool.Goal {
+		// the payout failed before
+		bot.payoutPool(pool)
+		return ctx, nil
+	}
+	if time.Now().After(pool.Expires) {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	amount, err := GetAmount(data[1])
+	if err != nil || amount < 1 {
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	// the last contribution fills the pool up to its goal
+	if remaining := pool.Goal - pool.Collected; amount > remaining {
+		amount = remaining
+	}
+	from := LoadUser(ctx)
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		return ctx, err
+	}
+	t := NewTransaction(bot, from, escrow, amount, TransactionType("pool"), TransactionChat(pool.Message.Chat), TransactionTopic(bot.messageTopic(pool.Message)),
+		TransactionIdempotencyKey(fmt.Sprintf("%s:%s", idempotencyKey(ctx), c.ID)), TransactionContext(ctx))
+	t.Memo = fmt.Sprintf("🎯 Contribution of %s to the pool %s.", GetUserStr(from.Telegram), pool.Title)
+	success, err := t.Send()
+	if isDuplicateOperation(err) {
+		return ctx, err
+	}
+	if !success {
+		bot.trySendMessage(from.Telegram, Translate(ctx, "sendErrorMessage"))
+		logger(ctx).Warnf("[pool] contribution of %s to %s failed: %v", GetUserStr(from.Telegram), pool.ID, err)
+		return ctx, err
+	}
+	pool.addContribution(from.Telegram, "", amount)
+	logger(ctx).Infof("[🎯 pool] %s contributed %d sat to %s (%d/%d sat)", GetUserStr(from.Telegram), amount, pool.ID, pool.Collected, pool.Goal)
+	bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "poolContributedMessage"), amount, str.MarkdownEscape(pool.Title)))
+	if c.Message != nil && c.Message.Chat.ID != pool.Message.Chat.ID {
+		// contribution from the deep link of the pool
+		bot.tryEditMessage(c.Message, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "poolContributedMessage"), amount, str.MarkdownEscape(pool.Title)), &tb.ReplyMarkup{})
+	}
+	return ctx, bot.updatePool(pool)
+}
+
+// updatePool pays out a pool that reached its goal or shows its progress.
+func (bot *TipBot) updatePool(pool *Pool) error {
+	if pool.Collected >= pool.Goal {
+		bot.payoutPool(pool)
+		return nil
+	}
+	bot.tryEditMessage(pool.Message, bot.poolText(pool), bot.makePoolKeyboard(pool), tb.NoPreview)
+	return pool.Set(pool, bot.Bunt)
+}
+
+// settlePool pays amount of the contributions of a pool to its recipient.
+func (bot *TipBot) settlePool(pool *Pool, amount int64, idempotencyKey string) error {
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		return err
+	}
+	if len(pool.RecipientAddress) > 0 {
+		_, err := bot.payLightningAddress(escrow, pool.RecipientAddress, amount, pool.Title)
+		return err
+	}
+	recipient, err := GetUser(pool.Recipient.Telegram, *bot)
+	if err != nil {
+		return err
+	}
+	t := NewTransaction(bot, escrow, recipient, amount, TransactionType("pool payout"), TransactionIdempotencyKey(idempotencyKey))
+	t.Memo = fmt.Sprintf("🎯 Payout of the pool %s to %s.", pool.Title, GetUserStr(recipient.Telegram))
+	success, err := t.Send()
+	if !success {
+		return err
+	}
+	bot.notify(recipient, NotificationPayment, amount, fmt.Sprintf(i18n.Translate(recipient.Telegram.LanguageCode, "poolPayoutMessage"), amount, str.MarkdownEscape(pool.Title))+bot.fiatAmount(recipient, amount))
+	return nil
+}
+
+// payoutPool pays the collected amount of a pool that reached its goal to the recipient.
+func (bot *TipBot) payoutPool(pool *Pool) {
+	err := bot.settlePool(pool, pool.Collected, pool.ID+":payout")
+	// errDuplicateOperation means that the payout went through before
+	if err != nil && err != errDuplicateOperation {
+		// the pool stays active, the next contribution or the claim expiry worker tries again
+		log.Errorf("[pool] could not pay out %s: %v", pool.ID, err)
+		runtime.IgnoreError(pool.Set(pool, bot.Bunt))
+		return
+	}
+	runtime.IgnoreError(pool.Inactivate(pool, bot.Bunt))
+	bot.inactivatePaymentLink(pool.Token)
+	log.Infof("[🎯 pool] paid out %d sat of %s to %s", pool.Collected, pool.ID, pool.recipientStrMd())
+	bot.tryEditMessage(pool.Message, fmt.Sprintf(i18n.Translate(pool.LanguageCode, "poolCompletedMessage"),
+		str.MarkdownEscape(pool.Title), pool.Collected, pool.contributors(), pool.recipientStrMd()), &tb.ReplyMarkup{})
+	bot.trySendMessage(pool.Creator.Telegram, listPoolContributors(pool))
+}
+
+// refundPool sends every contribution of a Telegram user back. Contributions from outside
+// of Telegram are paid to the recipient. The pool stays active until all refunds went
+// through, the claim expiry worker tries the failed ones again.
+func (bot *TipBot) refundPool(pool *Pool, message string) {
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		log.Errorf("[pool] could not refund %s: %v", pool.ID, err)
+		return
+	}
+	bot.inactivatePaymentLink(pool.Token)
+	var refunded, unrefundable int64
+	failed := false
+	for _, c := range pool.Contributions {
+		if c.User == nil {
+			unrefundable += c.Amount
+			continue
+		}
+		if c.Refunded {
+			refunded += c.Amount
+			continue
+		}
+		to, err := GetUser(c.User, *bot)
+		if err != nil {
+			log.Errorf("[pool] could not refund %d sat of %s to %s: %v", c.Amount, pool.ID, GetUserStr(c.User), err)
+			failed = true
+			continue
+		}
+		t := NewTransaction(bot, escrow, to, c.Amount, TransactionType("pool refund"), TransactionIdempotencyKey(fmt.Sprintf("%s:refund:%d", pool.ID, c.User.ID)))
+		t.Memo = fmt.Sprintf("🎯 Refund of the pool %s to %s.", pool.Title, GetUserStr(c.User))
+		success, err := t.Send()
+		if !success && err != errDuplicateOperation {
+			log.Errorf("[pool] could not refund %d sat of %s to %s: %v", c.Amount, pool.ID, GetUserStr(c.User), err)
+			failed = true
+			continue
+		}
+		c.Refunded = true
+		refunded += c.Amount
+		if success {
+			bot.trySendMessage(c.User, fmt.Sprintf(i18n.Translate(c.User.LanguageCode, "poolRefundedMessage"), c.Amount, str.MarkdownEscape(pool.Title)))
+		}
+	}
+	if unrefundable > 0 && !pool.Settled {
+		if err := bot.settlePool(pool, unrefundable, pool.ID+":settle"); err != nil && err != errDuplicateOperation {
+			log.Errorf("[pool] could not pay out %d sat of outside contributions of %s: %v", unrefundable, pool.ID, err)
+			failed = true
+		} else {
+			pool.Settled = true
+		}
+	}
+	if failed {
+		// no more contributions, the pool counts as expired until the worker refunded it
+		if now := time.Now(); pool.Expires.After(now) {
+			pool.Expires = now
+		}
+		runtime.IgnoreError(pool.Set(pool, bot.Bunt))
+		return
+	}
+	runtime.IgnoreError(pool.Inactivate(pool, bot.Bunt))
+	log.Infof("[🎯 pool] refunded %d sat of %s to %d contributors", refunded, pool.ID, pool.contributors())
+	bot.tryEditMessage(pool.Message, fmt.Sprintf(i18n.Translate(pool.LanguageCode, message), str.MarkdownEscape(pool.Title), refunded, pool.contributors()), &tb.ReplyMarkup{})
+}
+
+func listPoolContributors(pool *Pool) string {
+	list := fmt.Sprintf("🎯 *Pool summary*\n\nTitle: %s\nGoal: %d sat\nCollected: %d sat\nContributors: %d\n\n", str.MarkdownEscape(pool.Title), pool.Goal, pool.Collected, pool.contributors())
+	list += "```\n"
+	for _, c := range pool.Contributions {
+		list += fmt.Sprintf("%s: %d sat\n", c.Contributor(), c.Amount)
+	}
+	list += "```"
+	return list
+}
+
+// cancelPoolHandler is invoked when the creator of a pool cancels it.
+func (bot *TipBot) cancelPoolHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	pool, err := bot.loadPool(ctx.Data())
+	if err != nil {
+		return ctx, err
+	}
+	if pool.Creator.Telegram.ID != ctx.Sender().ID {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	pool.Cancelled = true
+	bot.refundPool(pool, "poolCancelledMessage")
+	return ctx, nil
+}
+
+// poolByMessage returns the active pool that is shown in message m.
+func (bot *TipBot) poolByMessage(m *tb.Message) (*Pool, error) {
+	var found *Pool
+	bot.Bunt.Ascend("pool", func(key, value string) bool {
+		pool := &Pool{}
+		if err := json.Unmarshal([]byte(value), pool); err == nil && pool.Base != nil && pool.Active && pool.Message != nil &&
+			pool.Message.Chat.ID == m.Chat.ID && pool.Message.ID == m.ID {
+			found = pool
+			return false // stop iteration
+		}
+		return true // continue iteration
+	})
+	if found == nil {
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	return found, nil
+}
+
+// poolUpdateHandler is invoked on /pool update <text> as a reply to a pool. The update of the
+// creator is posted in the group, sent to all contributors and shown on the public page.
+func (bot *TipBot) poolUpdateHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	text := strings.TrimSpace(GetMemoFromCommand(m.Text, 2))
+	if m.ReplyTo == nil || len(text) == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "poolUpdateHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	found, err := bot.poolByMessage(m.ReplyTo)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "poolUpdateHelpMessage"))
+		return ctx, err
+	}
+	mutex.LockWithContext(ctx, found.ID)
+	defer mutex.UnlockWithContext(ctx, found.ID)
+	pool, err := bot.loadPool(found.ID)
+	if err != nil {
+		return ctx, err
+	}
+	if pool.Creator.Telegram.ID != m.Sender.ID {
+		NewMessage(m, WithDuration(0, bot))
+		bot.trySendMessage(m.Sender, Translate(ctx, "poolUpdateNotCreatorMessage"))
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if runes := []rune(text); len(runes) > 500 {
+		text = string(runes[:500])
+	}
+	pool.Updates = append(pool.Updates, &PoolUpdate{Text: text, Time: time.Now()})
+	if err := pool.Set(pool, bot.Bunt); err != nil {
+		return ctx, err
+	}
+	logger(ctx).Infof("[/pool] %s posted an update to %s", GetUserStr(m.Sender), pool.ID)
+	bot.tryReplyMessage(pool.Message, fmt.Sprintf(i18n.Translate(pool.LanguageCode, "poolUpdateMessage"), str.MarkdownEscape(pool.Title), str.MarkdownEscape(text)))
+	for _, c := range pool.Contributions {
+		if c.User != nil && c.User.ID != m.Sender.ID {
+			bot.trySendMessage(c.User, fmt.Sprintf(i18n.Translate(c.User.LanguageCode, "poolUpdateMessage"), str.MarkdownEscape(pool.Title), str.MarkdownEscape(text)))
+		}
+	}
+	return ctx, nil
+}
+
+// showPoolFromLink shows a pool to a user who opened its deep link.
+func (bot *TipBot) showPoolFromLink(ctx intercept.Context, link *PaymentLink) (intercept.Context, error) {
+	pool, err := bot.loadPool(link.Target)
+	if err != nil {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "paymentLinkInactiveMessage"))
+		return ctx, err
+	}
+	bot.trySendMessage(ctx.Sender(), bot.poolText(pool), bot.makePoolKeyboard(pool), tb.NoPreview)
+	return ctx, nil
+}
+
+// PoolByToken returns the pool of a payment link, also if the pool has ended.
+func (bot *TipBot) PoolByToken(token string) (*Pool, error) {
+	if !paymentLinkTokenRegex.MatchString(token) {
+		return nil, fmt.Errorf("invalid token %s", token)
+	}
+	link := &PaymentLink{Base: storage.New(storage.ID(paymentLinkID(token)))}
+	sn, err := link.Get(link, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	link = sn.(*PaymentLink)
+	if link.Type != PaymentLinkTypePool {
+		return nil, fmt.Errorf("link %s is not a pool", token)
+	}
+	pool := &Pool{Base: storage.New(storage.ID(link.Target))}
+	sn, err = pool.Get(pool, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	return sn.(*Pool), nil
+}
+
+// PoolInvoiceCallbackData returns the callback data of an invoice that an outsider pays to
+// contribute to a pool. The name of the payer is shown as the contributor.
+func PoolInvoiceCallbackData(pool *Pool, name string) string {
+	if runes := []rune(name); len(runes) > 64 {
+		name = string(runes[:64])
+	}
+	return fmt.Sprintf("%s|%s", pool.ID, name)
+}
+
+// poolContributionEvent is invoked when an outsider paid an invoice of the public page of a pool.
+func (bot *TipBot) poolContributionEvent(event Event) {
+	invoiceEvent := event.(*InvoiceEvent)
+	data := strings.SplitN(invoiceEvent.CallbackData, "|", 2)
+	id, name := data[0], ""
+	if len(data) == 2 {
+		name = data[1]
+	}
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	pool := &Pool{Base: storage.New(storage.ID(id))}
+	sn, err := pool.Get(pool, bot.Bunt)
+	if err != nil {
+		log.Errorf("[poolContributionEvent] %s: %v", id, err)
+		return
+	}
+	pool = sn.(*Pool)
+	if !pool.Active || pool.Cancelled || time.Now().After(pool.Expires) {
+		// the pool ended while the invoice was open, the contribution goes to the recipient
+		if err := bot.settlePool(pool, invoiceEvent.Amount, invoiceEvent.PaymentHash); err != nil {
+			log.Errorf("[poolContributionEvent] could not pay out %d sat of %s: %v", invoiceEvent.Amount, id, err)
+		}
+		return
+	}
+	pool.addContribution(nil, name, invoiceEvent.Amount)
+	log.Infof("[🎯 pool] %s contributed %d sat to %s from the public page (%d/%d sat)", pool.Contributions[len(pool.Contributions)-1].Contributor(), invoiceEvent.Amount, pool.ID, pool.Collected, pool.Goal)
+	runtime.IgnoreError(bot.updatePool(pool))
+}
+
+// startPoolTimer refunds the contributions of a pool that did not reach its goal when it expires.
+func (bot *TipBot) startPoolTimer(pool *Pool) {
+	time.AfterFunc(time.Until(pool.Expires), func() {
+		bot.expirePool(pool.ID)
+	})
+}
+
+func (bot *TipBot) expirePool(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	pool := &Pool{Base: storage.New(storage.ID(id))}
+	sn, err := pool.Get(pool, bot.Bunt)
+	if err != nil {
+		log.Errorf("[expirePool] %s: %v", id, err)
+		return
+	}
+	pool = sn.(*Pool)
+	if !pool.Active {
+		return
+	}
+	if pool.Cancelled {
+		bot.refundPool(pool, "poolCancelledMessage")
+		return
+	}
+	if pool.Collected >= pool.Goal {
+		bot.payoutPool(pool)
+		return
+	}
+	bot.refundPool(pool, "poolExpiredMessage")
+}
@@ -0,0 +1,151 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestPoolPayout(t *testing.T) {
+	h := newTestHarness(t)
+	escrow := h.newUser(testBotUser, 0)
+	creator := &tb.User{ID: 8201, Username: "creator", FirstName: "Creator", LanguageCode: "en"}
+	alice := &tb.User{ID: 8202, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 8203, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	group := &tb.Chat{ID: -8200, Type: tb.ChatGroup, Title: "group"}
+	to := h.newUser(creator, 0)
+	h.newUser(alice, 1000)
+	h.newUser(bob, 1000)
+
+	h.sendMessage(creator, group, `/pool "pizza night" 1000`)
+	pool := h.lastMessage(group.ID)
+	if !strings.Contains(pool.Text(), "pizza night") {
+		t.Fatalf("pool message = %q", pool.Text())
+	}
+	for i := 0; i < 5; i++ {
+		h.pressButton(alice, h.lastMessage(group.ID), "⚡️ 100")
+	}
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 500 {
+		t.Errorf("balance of the pool = %d, want 500", balance)
+	}
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "500 sat from 1 contributors") {
+		t.Errorf("pool message = %q", text)
+	}
+	for i := 0; i < 5; i++ {
+		h.pressButton(bob, h.lastMessage(group.ID), "⚡️ 100")
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of the recipient = %d, want 1000", balance)
+	}
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 0 {
+		t.Errorf("balance of the pool = %d after the payout, want 0", balance)
+	}
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "goal was reached") {
+		t.Errorf("pool message = %q", text)
+	}
+}
+
+func TestPoolCancel(t *testing.T) {
+	h := newTestHarness(t)
+	escrow := h.newUser(testBotUser, 0)
+	creator := &tb.User{ID: 8301, Username: "creator", FirstName: "Creator", LanguageCode: "en"}
+	alice := &tb.User{ID: 8302, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	group := &tb.Chat{ID: -8300, Type: tb.ChatGroup, Title: "group"}
+	h.newUser(creator, 0)
+	from := h.newUser(alice, 1000)
+
+	h.sendMessage(creator, group, `/pool "pizza night" 1000 @alice`)
+	h.pressButton(alice, h.lastMessage(group.ID), "⚡️ 50")
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 950 {
+		t.Fatalf("balance of the contributor = %d, want 950", balance)
+	}
+
+	// only the creator can cancel the pool
+	h.pressButton(alice, h.lastMessage(group.ID), "🚫 Cancel")
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 50 {
+		t.Errorf("balance of the pool = %d, want 50", balance)
+	}
+	h.pressButton(creator, h.lastMessage(group.ID), "🚫 Cancel")
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of the contributor = %d after the refund, want 1000", balance)
+	}
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "cancelled") {
+		t.Errorf("pool message = %q", text)
+	}
+}
+
+func TestPoolUpdate(t *testing.T) {
+	h := newTestHarness(t)
+	h.newUser(testBotUser, 0)
+	creator := &tb.User{ID: 8401, Username: "creator", FirstName: "Creator", LanguageCode: "en"}
+	alice := &tb.User{ID: 8402, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	group := &tb.Chat{ID: -8400, Type: tb.ChatGroup, Title: "group"}
+	h.newUser(creator, 0)
+	h.newUser(alice, 1000)
+
+	h.sendMessage(creator, group, `/pool "pizza night" 1000`)
+	pool := h.lastMessage(group.ID)
+	h.pressButton(alice, pool, "⚡️ 100")
+
+	// only the creator can post updates
+	h.replyToBot(alice, pool, "/pool update free pizza")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "Only the creator") {
+		t.Errorf("message to alice = %q", text)
+	}
+	h.replyToBot(creator, pool, "/pool update the pizza is ordered")
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "the pizza is ordered") {
+		t.Errorf("update in the group = %q", text)
+	}
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "the pizza is ordered") {
+		t.Errorf("update to the contributor = %q", text)
+	}
+}
+
+func TestPoolRefundRetry(t *testing.T) {
+	h := newTestHarness(t)
+	escrow := h.newUser(testBotUser, 0)
+	creator := &tb.User{ID: 8311, Username: "creator", FirstName: "Creator", LanguageCode: "en"}
+	alice := &tb.User{ID: 8312, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	group := &tb.Chat{ID: -8310, Type: tb.ChatGroup, Title: "group"}
+	h.newUser(creator, 0)
+	from := h.newUser(alice, 1000)
+
+	h.sendMessage(creator, group, `/pool "pizza night" 1000 @alice`)
+	h.pressButton(alice, h.lastMessage(group.ID), "⚡️ 50")
+	// the escrow wallet can't pay the refund
+	invoice, err := h.lnbits.ExternalInvoice(50, "drain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.lnbits.Client().Pay(*escrow.Wallet, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}); err != nil {
+		t.Fatal(err)
+	}
+	h.pressButton(creator, h.lastMessage(group.ID), "🚫 Cancel")
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 950 {
+		t.Fatalf("balance of the contributor = %d after the failed refund, want 950", balance)
+	}
+	if text := h.lastMessage(group.ID).Text(); strings.Contains(text, "cancelled") {
+		t.Errorf("pool message = %q before the refund", text)
+	}
+
+	// the claim expiry worker refunds the pool once the escrow wallet has the funds
+	if err := h.lnbits.Fund(escrow.Wallet.ID, 50); err != nil {
+		t.Fatal(err)
+	}
+	h.bot.expireClaims(time.Now().Add(time.Minute))
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of the contributor = %d after the retry, want 1000", balance)
+	}
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "cancelled") {
+		t.Errorf("pool message = %q", text)
+	}
+	// a refunded pool is not refunded again
+	h.bot.expireClaims(time.Now().Add(time.Minute))
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 0 {
+		t.Errorf("balance of the escrow wallet = %d, want 0", balance)
+	}
+}
@@ -0,0 +1,278 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	posMenu     = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnClosePos = posMenu.Data("🚫 Close", "close_pos")
+)
+
+// PosSale is a paid invoice of a register.
+type PosSale struct {
+	Amount      int64     `json:"amount"`
+	Fiat        float64   `json:"fiat"`
+	Currency    string    `json:"currency"`
+	PaymentHash string    `json:"payment_hash"`
+	Time        time.Time `json:"time"`
+}
+
+// PosRegister is the register of a merchant in /pos mode. Every amount that the merchant
+// enters becomes an invoice. The register keeps the sales of the current day.
+type PosRegister struct {
+	*storage.Base
+	User         *lnbits.User `json:"user"`
+	Currency     string       `json:"currency"` // empty for satoshis
+	Day          string       `json:"day"`
+	Sales        []PosSale    `json:"sales"`
+	LanguageCode string       `json:"languagecode"`
+}
+
+func posRegisterId(user *tb.User) string {
+	return fmt.Sprintf("pos:%d", user.ID)
+}
+
+func posDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// addSale adds a sale to the tally of the day. The tally starts over every day.
+func (register *PosRegister) addSale(sale PosSale) {
+	if day := posDay(sale.Time); register.Day != day {
+		register.Day = day
+		register.Sales = make([]PosSale, 0)
+	}
+	register.Sales = append(register.Sales, sale)
+}
+
+// parseAmount parses an amount in the currency of the register and returns it in satoshis.
+func (register *PosRegister) parseAmount(text string) (amount int64, fiat float64, err error) {
+	if register.Currency == "" {
+		amount, err = GetAmount(strings.TrimSpace(text))
+		return amount, 0, err
+	}
+	fiat, err = strconv.ParseFloat(strings.Replace(strings.TrimSpace(text), ",", ".", -1), 64)
+	if err != nil || fiat <= 0 {
+		return 0, 0, fmt.Errorf("invalid amount %s", text)
+	}
+	amount, err = FiatToSatoshis(fiat, register.Currency)
+	return amount, fiat, err
+}
+
+// posAmountStr formats an amount in the currency of the register.
+func posAmountStr(amount int64, fiat float64, currency string) string {
+	if currency == "" {
+		return fmt.Sprintf("%d sat", amount)
+	}
+	return fmt.Sprintf("%.2f %s (%d sat)", fiat, currency, amount)
+}
+
+// tallyStr sums up the sales of the current day.
+func (register *PosRegister) tallyStr() string {
+	if register.Day != posDay(time.Now()) {
+		return i18n.Translate(register.LanguageCode, "posNoSalesMessage")
+	}
+	var total int64
+	fiat := make(map[string]float64)
+	for _, sale := range register.Sales {
+		total += sale.Amount
+		if sale.Currency != "" {
+			fiat[sale.Currency] += sale.Fiat
+		}
+	}
+	currencies := make([]string, 0, len(fiat))
+	for currency := range fiat {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	totalStr := fmt.Sprintf("%d sat", total)
+	for _, currency := range currencies {
+		totalStr += fmt.Sprintf(" ≈ %.2f %s", fiat[currency], currency)
+	}
+	return fmt.Sprintf(i18n.Translate(register.LanguageCode, "posTallyMessage"), len(register.Sales), totalStr)
+}
+
+func (bot *TipBot) makePosKeyboard(ctx context.Context, register *PosRegister) *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	closeButton := menu.Data(Translate(ctx, "closeButtonMessage"), "close_pos", register.ID)
+	menu.Inline(menu.Row(closeButton))
+	return menu
+}
+
+// loadPosRegister loads the register of a user. err is set if the user never opened one.
+func (bot *TipBot) loadPosRegister(user *tb.User) (*PosRegister, error) {
+	register := &PosRegister{Base: storage.New(storage.ID(posRegisterId(user)))}
+	sn, err := register.Get(register, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	return sn.(*PosRegister), nil
+}
+
+// posHandler is invoked on /pos [<currency>] and opens the register of a merchant. Afterwards,
+// every amount the merchant sends in the private chat creates an invoice. /pos tally shows the
+// sales of the day.
+func (bot *TipBot) posHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Chat.Type != tb.ChatPrivate {
+		bot.tryDeleteMessage(m)
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	id := posRegisterId(user.Telegram)
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	register, err := bot.loadPosRegister(user.Telegram)
+	if err != nil {
+		register = &PosRegister{Base: storage.New(storage.ID(id)), Sales: make([]PosSale, 0)}
+	}
+	register.User = user
+	register.LanguageCode = ctx.Value("publicLanguageCode").(string)
+
+	arg, _ := getArgumentFromCommand(m.Text, 1)
+	if strings.ToLower(arg) == "tally" {
+		bot.trySendMessage(m.Sender, register.tallyStr())
+		return ctx, nil
+	}
+	register.Currency = bot.getUserCurrency(user)
+	if len(arg) > 0 {
+		currency, err := parseCurrency(arg)
+		if err != nil {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "posHelpText"), Translate(ctx, "posInvalidCurrencyMessage")))
+			return ctx, err
+		}
+		if currency == "BTC" {
+			currency = ""
+		}
+		register.Currency = currency
+	}
+	register.Active = true
+	runtime.IgnoreError(register.Set(register, bot.Bunt))
+	SetUserState(user, bot, lnbits.UserStatePos, register.ID)
+	currency := register.Currency
+	if currency == "" {
+		currency = "sat"
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "posOpenMessage"), currency, register.tallyStr()), bot.makePosKeyboard(ctx, register))
+	logger(ctx).Infof("[/pos] %s opened the register in %s", GetUserStr(user.Telegram), currency)
+	return ctx, nil
+}
+
+// posEnterAmountHandler is invoked in anyTextHandler while the register of a user is open and
+// creates an invoice for the amount that the merchant entered.
+func (bot *TipBot) posEnterAmountHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	register, err := bot.loadPosRegister(user.Telegram)
+	if err != nil || !register.Active {
+		ResetUserState(user, bot)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	amount, fiat, err := register.parseAmount(m.Text)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "posInvalidAmountMessage"))
+		return ctx, err
+	}
+	amountStr := posAmountStr(amount, fiat, register.Currency)
+	memo := fmt.Sprintf("%s at %s", amountStr, GetUserStr(user.Telegram))
+	invoice, err := bot.createInvoiceWithEvent(ctx, user, amount, memo, register.Currency, InvoiceCallbackPosSale,
+		fmt.Sprintf("%s|%s", register.ID, strconv.FormatFloat(fiat, 'f', 2, 64)))
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	qr, err := qrcode.Encode(invoice.PaymentRequest, qrcode.Medium, 256)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	caption := fmt.Sprintf(Translate(ctx, "posInvoiceMessage"), amountStr, invoice.PaymentRequest)
+	invoice.InvoiceMessage = bot.trySendMessage(m.Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: caption})
+	// save the message so that it can be edited when the invoice is paid
+	runtime.IgnoreError(bot.Bunt.Set(invoice))
+	logger(ctx).WithField("payment_hash", invoice.PaymentHash).Infof("[/pos] %s charged %s", GetUserStr(user.Telegram), amountStr)
+	return ctx, nil
+}
+
+// posSaleEvent is invoked when an invoice of a register is paid. The merchant gets a
+// notification with sound, so that they know without looking that the customer has paid.
+func (bot *TipBot) posSaleEvent(event Event) {
+	invoiceEvent := event.(*InvoiceEvent)
+	data := strings.SplitN(invoiceEvent.CallbackData, "|", 2)
+	id := data[0]
+	var fiat float64
+	if len(data) == 2 {
+		fiat, _ = strconv.ParseFloat(data[1], 64)
+	}
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	register := &PosRegister{Base: storage.New(storage.ID(id))}
+	sn, err := register.Get(register, bot.Bunt)
+	if err != nil {
+		log.Errorf("[posSaleEvent] %s: %v", id, err)
+		return
+	}
+	register = sn.(*PosRegister)
+	register.addSale(PosSale{
+		Amount:      invoiceEvent.Amount,
+		Fiat:        fiat,
+		Currency:    invoiceEvent.UserCurrency,
+		PaymentHash: invoiceEvent.PaymentHash,
+		Time:        time.Now(),
+	})
+	runtime.IgnoreError(register.Set(register, bot.Bunt))
+
+	amountStr := posAmountStr(invoiceEvent.Amount, fiat, invoiceEvent.UserCurrency)
+	if invoiceEvent.InvoiceMessage != nil {
+		if _, err := bot.Telegram.EditCaption(invoiceEvent.InvoiceMessage, fmt.Sprintf(i18n.Translate(register.LanguageCode, "posInvoicePaidMessage"), amountStr)); err != nil {
+			log.Warnln(err.Error())
+		}
+	}
+	// do balance check for keyboard update
+	if _, err := bot.GetUserBalance(invoiceEvent.User); err != nil {
+		log.Errorf("[posSaleEvent] could not get balance of user %s", GetUserStr(invoiceEvent.User.Telegram))
+	}
+	log.Infof("[🏪 pos] %s received %s", GetUserStr(invoiceEvent.User.Telegram), amountStr)
+	bot.trySendMessage(invoiceEvent.User.Telegram, fmt.Sprintf(i18n.Translate(register.LanguageCode, "posSaleMessage"), amountStr, register.tallyStr()))
+}
+
+// closePosHandler closes the register. Amounts that the merchant sends are no longer invoices.
+func (bot *TipBot) closePosHandler(ctx intercept.Context) (intercept.Context, error) {
+	if ctx.Data() != posRegisterId(ctx.Sender()) {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	register, err := bot.loadPosRegister(ctx.Sender())
+	if err != nil {
+		return ctx, err
+	}
+	runtime.IgnoreError(register.Inactivate(register, bot.Bunt))
+	user := LoadUser(ctx)
+	if user.StateKey == lnbits.UserStatePos {
+		ResetUserState(user, bot)
+	}
+	bot.tryEditMessage(ctx.Message(), fmt.Sprintf(Translate(ctx, "posClosedMessage"), register.tallyStr()), &tb.ReplyMarkup{})
+	return ctx, nil
+}
@@ -0,0 +1,55 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestPosSale(t *testing.T) {
+	h := newTestHarness(t)
+	merchant := &tb.User{ID: 8801, Username: "merchant", FirstName: "Merchant", LanguageCode: "en"}
+	customer := &tb.User{ID: 8802, Username: "customer", FirstName: "Customer", LanguageCode: "en"}
+	to := h.newUser(merchant, 0)
+	from := h.newUser(customer, 5000)
+
+	h.sendMessage(merchant, privateChat(merchant), "/pos sat")
+	register := h.lastMessage(merchant.ID)
+	if !strings.Contains(register.Text(), "Register open") {
+		t.Fatalf("register message = %q", register.Text())
+	}
+	h.sendMessage(merchant, privateChat(merchant), "2100")
+	invoice := h.lastMessage(merchant.ID)
+	parts := strings.Split(invoice.Text(), "`")
+	if len(parts) < 3 || !strings.Contains(invoice.Text(), "2100 sat") {
+		t.Fatalf("invoice message = %q", invoice.Text())
+	}
+	paid, err := h.bot.Client.Pay(*from.Wallet, lnbits.PaymentParams{Out: true, Bolt11: parts[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &InvoiceEvent{Invoice: &Invoice{PaymentHash: paid.PaymentHash}}
+	if err := h.bot.Bunt.Get(event); err != nil {
+		t.Fatal(err)
+	}
+	h.bot.posSaleEvent(event)
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 2100 {
+		t.Errorf("balance of the merchant = %d, want 2100", balance)
+	}
+	if text := h.lastMessage(merchant.ID).Text(); !strings.Contains(text, "Paid:* 2100 sat") || !strings.Contains(text, "Sales today: 1") {
+		t.Errorf("sale message = %q", text)
+	}
+
+	h.pressButton(merchant, register, "🚫 Close")
+	if text := h.lastMessage(merchant.ID).Text(); !strings.Contains(text, "Register closed") {
+		t.Errorf("message after close = %q", text)
+	}
+	// amounts are no longer invoices
+	h.sendMessage(merchant, privateChat(merchant), "1000")
+	if text := h.lastMessage(merchant.ID).Text(); strings.Contains(text, "1000 sat") {
+		t.Errorf("closed register created an invoice: %q", text)
+	}
+}
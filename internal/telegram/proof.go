@@ -0,0 +1,76 @@
+package telegram
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+)
+
+// savePreimage stores the preimage of a payment of a wallet as its proof of payment.
+func (bot *TipBot) savePreimage(paymentHash string, walletID string, preimage string) {
+	if len(paymentHash) == 0 || len(walletID) == 0 || len(preimage) == 0 {
+		return
+	}
+	record := &PaymentCounterparty{PaymentHash: paymentHash, WalletID: walletID}
+	tx := bot.DB.Transactions.Where(record).
+		Assign(PaymentCounterparty{Preimage: preimage}).
+		Attrs(PaymentCounterparty{CreatedAt: time.Now()}).
+		FirstOrCreate(record)
+	if tx.Error != nil {
+		log.Errorf("[savePreimage] could not save preimage of payment %s: %v", paymentHash, tx.Error)
+	}
+}
+
+// paymentPreimage returns the preimage of a paid payment of the wallet. Preimages that were
+// not stored when the payment was made are fetched from the wallet backend.
+func (bot *TipBot) paymentPreimage(ctx context.Context, wallet lnbits.Wallet, paymentHash string) (string, error) {
+	record := &PaymentCounterparty{}
+	tx := bot.DB.Transactions.Where("wallet_id = ? AND payment_hash = ?", wallet.ID, paymentHash).First(record)
+	if tx.Error == nil && len(record.Preimage) > 0 {
+		return record.Preimage, nil
+	}
+	payment, err := bot.client(ctx).Payment(wallet, paymentHash)
+	if err != nil {
+		return "", err
+	}
+	if !payment.Paid || len(strings.Trim(payment.Preimage, "0")) == 0 {
+		return "", fmt.Errorf("payment %s is not paid", paymentHash)
+	}
+	bot.savePreimage(paymentHash, wallet.ID, payment.Preimage)
+	return payment.Preimage, nil
+}
+
+// isPaymentHash returns true if s is a hex encoded sha256 hash.
+func isPaymentHash(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
+
+// proofHandler is invoked on /proof <payment hash> and returns the preimage of a payment.
+func (bot *TipBot) proofHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	paymentHash, err := getArgumentFromCommand(ctx.Message().Text, 1)
+	paymentHash = strings.ToLower(paymentHash)
+	if err != nil || !isPaymentHash(paymentHash) {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "proofHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	preimage, err := bot.paymentPreimage(ctx, *user.Wallet, paymentHash)
+	if err != nil {
+		logger(ctx).Warnf("[/proof] no preimage of payment %s: %v", paymentHash, err)
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "proofNotFoundMessage"))
+		return ctx, err
+	}
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "proofMessage"), paymentHash, preimage))
+	return ctx, nil
+}
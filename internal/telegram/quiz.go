@@ -0,0 +1,429 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// quizQuestionDuration is how long a question of a quiz takes answers
+	quizQuestionDuration = 30 * time.Second
+	// quizMinAnswerTime is the time a human needs at least to read a question. Faster answers
+	// come from bots and don't count.
+	quizMinAnswerTime = 2 * time.Second
+	quizMaxQuestions  = 20
+	quizMaxOptions    = 6
+)
+
+// quizPrizeWeights are the shares of the fastest correct answers in the prize of a question, in
+// sixths of the prize. Shares nobody won go back to the host at the end of the quiz.
+var quizPrizeWeights = []int64{3, 2, 1}
+
+var (
+	quizMenu      = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnAnswerQuiz = quizMenu.Data("answer", "answer_quiz")
+)
+
+type QuizQuestion struct {
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+	Answer   int      `json:"answer"` // index of the correct option
+}
+
+type QuizAnswer struct {
+	User    *tb.User  `json:"user"`
+	Option  int       `json:"option"`
+	Time    time.Time `json:"time"`
+	TooFast bool      `json:"too_fast"`
+}
+
+type QuizPrize struct {
+	User     *tb.User `json:"user"`
+	Question int      `json:"question"`
+	Amount   int64    `json:"amount"`
+}
+
+// Quiz is a trivia game of a host in a group. The host writes the questions in a private chat
+// and starts the quiz in a group, where the prize pool goes to the escrow wallet. The bot asks
+// the questions one by one and the fastest correct answers of every question win its prize.
+type Quiz struct {
+	*storage.Base
+	Host         *lnbits.User   `json:"host"`
+	Pot          int64          `json:"pot"`
+	Paid         int64          `json:"paid"` // prizes paid out of the pot
+	Questions    []QuizQuestion `json:"questions"`
+	Started      bool           `json:"started"`
+	Current      int            `json:"current"` // index of the open question
+	AskedAt      time.Time      `json:"asked_at"`
+	Answers      []QuizAnswer   `json:"answers"` // answers to the open question in their order
+	Prizes       []QuizPrize    `json:"prizes"`
+	Message      *tb.Message    `json:"message"` // the open question
+	Expires      time.Time      `json:"expires"`
+	LanguageCode string         `json:"languagecode"`
+}
+
+// parseQuizQuestions parses lines of "question | correct answer | wrong answer | ...". The
+// options are shuffled, so the correct answer isn't always the first.
+func parseQuizQuestions(lines []string) ([]QuizQuestion, error) {
+	questions := make([]QuizQuestion, 0)
+	for _, line := range lines {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) < 3 || len(fields) > quizMaxOptions+1 {
+			return nil, fmt.Errorf("invalid question %q", line)
+		}
+		question := QuizQuestion{Question: strings.TrimSpace(fields[0])}
+		for _, option := range fields[1:] {
+			if option = strings.TrimSpace(option); len(option) == 0 {
+				return nil, fmt.Errorf("empty option in %q", line)
+			}
+			question.Options = append(question.Options, option)
+		}
+		if len(question.Question) == 0 {
+			return nil, fmt.Errorf("empty question in %q", line)
+		}
+		rand.Shuffle(len(question.Options), func(i, j int) {
+			question.Options[i], question.Options[j] = question.Options[j], question.Options[i]
+			if question.Answer == i {
+				question.Answer = j
+			} else if question.Answer == j {
+				question.Answer = i
+			}
+		})
+		questions = append(questions, question)
+	}
+	if len(questions) == 0 || len(questions) > quizMaxQuestions {
+		return nil, fmt.Errorf("a quiz has 1 to %d questions", quizMaxQuestions)
+	}
+	return questions, nil
+}
+
+// questionPrize is the prize of a question, the remainder of the pot goes to the last question.
+func (quiz *Quiz) questionPrize(index int) int64 {
+	prize := quiz.Pot / int64(len(quiz.Questions))
+	if index == len(quiz.Questions)-1 {
+		prize += quiz.Pot % int64(len(quiz.Questions))
+	}
+	return prize
+}
+
+func (quiz *Quiz) questionStr(index int) string {
+	return fmt.Sprintf(i18n.Translate(quiz.LanguageCode, "quizQuestionMessage"), index+1, len(quiz.Questions), str.MarkdownEscape(quiz.Questions[index].Question))
+}
+
+func (bot *TipBot) makeQuizKeyboard(quiz *Quiz) *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	buttons := make([]tb.Btn, 0)
+	for i, option := range quiz.Questions[quiz.Current].Options {
+		buttons = append(buttons, menu.Data(option, "answer_quiz", quiz.ID, strconv.Itoa(quiz.Current), strconv.Itoa(i)))
+	}
+	menu.Inline(buttonWrapper(buttons, menu, 2)...)
+	return menu
+}
+
+// quizHandler is invoked on /quiz. In a private chat, /quiz <pot> and a question on every further
+// line creates a quiz. In a group, the host starts the quiz with /quiz <id>.
+func (bot *TipBot) quizHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		return bot.createQuizHandler(ctx)
+	}
+	id, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "quizHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	id = "quiz:" + id
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	quiz, err := bot.loadQuiz(id)
+	host := LoadUser(ctx)
+	if err != nil || quiz.Started || quiz.Host.Telegram.ID != host.Telegram.ID {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "quizNotFoundMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		log.Errorf("[/quiz] %v", err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	t := NewTransaction(bot, host, escrow, quiz.Pot, TransactionType("quiz pot"), TransactionChat(m.Chat),
+		TransactionIdempotencyKey(fmt.Sprintf("%s:pot:%d", quiz.ID, m.ID)), TransactionContext(ctx))
+	t.Memo = fmt.Sprintf("🧠 Prize pool of a quiz of %s.", GetUserStr(host.Telegram))
+	if success, err := t.Send(); !success {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "quizFundFailedMessage"), quiz.Pot))
+		logger(ctx).Warnf("[quiz] prize pool of %s failed: %v", GetUserStr(host.Telegram), err)
+		return ctx, err
+	}
+	quiz.Started = true
+	quiz.LanguageCode = ctx.Value("publicLanguageCode").(string)
+	// the quiz expires when all questions should have been asked, with a question to spare
+	quiz.Expires = time.Now().Add(time.Duration(len(quiz.Questions)+1) * quizQuestionDuration)
+	logger(ctx).Infof("[🧠 quiz] %s started %s with %d questions for %d sat", GetUserStr(host.Telegram), quiz.ID, len(quiz.Questions), quiz.Pot)
+	bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(i18n.Translate(quiz.LanguageCode, "quizStartedMessage"), GetUserStrMd(host.Telegram), len(quiz.Questions), quiz.Pot, int(quizQuestionDuration.Seconds())))
+	bot.askQuizQuestion(quiz, bot.topicOf(m))
+	return ctx, nil
+}
+
+// createQuizHandler creates a quiz from /quiz <pot> with a question on every further line.
+func (bot *TipBot) createQuizHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	lines := strings.Split(m.Text, "\n")
+	args := strings.Fields(lines[0])
+	if len(args) != 2 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "quizHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	questions, err := parseQuizQuestions(lines[1:])
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "quizHelpMessage"))
+		return ctx, err
+	}
+	pot, err := GetAmount(args[1])
+	if err != nil || pot < int64(len(questions)) {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "quizInvalidPotMessage"), len(questions)))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	id := RandStringRunes(10)
+	quiz := &Quiz{
+		Base:         storage.New(storage.ID("quiz:" + id)),
+		Host:         LoadUser(ctx),
+		Pot:          pot,
+		Questions:    questions,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	if err := quiz.Set(quiz, bot.Bunt); err != nil {
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "quizCreatedMessage"), len(questions), pot, id))
+	return ctx, nil
+}
+
+// loadQuiz loads an active quiz.
+func (bot *TipBot) loadQuiz(id string) (*Quiz, error) {
+	quiz := &Quiz{Base: storage.New(storage.ID(id))}
+	sn, err := quiz.Get(quiz, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	quiz = sn.(*Quiz)
+	if !quiz.Active {
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	return quiz, nil
+}
+
+// askQuizQuestion posts the open question and closes it after quizQuestionDuration.
+func (bot *TipBot) askQuizQuestion(quiz *Quiz, to tb.Recipient) {
+	quiz.Answers = make([]QuizAnswer, 0)
+	quiz.Message = bot.trySendMessageEditable(to, quiz.questionStr(quiz.Current), bot.makeQuizKeyboard(quiz))
+	if quiz.Message == nil {
+		bot.finishQuiz(quiz)
+		return
+	}
+	quiz.AskedAt = time.Now()
+	runtime.IgnoreError(quiz.Set(quiz, bot.Bunt))
+	id, index := quiz.ID, quiz.Current
+	time.AfterFunc(quizQuestionDuration, func() {
+		bot.quizQuestionTimeout(id, index)
+	})
+}
+
+// answerQuizHandler is invoked when a member answers the open question. Every member answers a
+// question once.
+func (bot *TipBot) answerQuizHandler(ctx intercept.Context) (intercept.Context, error) {
+	data := strings.Split(ctx.Data(), "|")
+	if len(data) != 3 {
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	index, err := strconv.Atoi(data[1])
+	if err != nil {
+		return ctx, err
+	}
+	option, err := strconv.Atoi(data[2])
+	if err != nil {
+		return ctx, err
+	}
+	mutex.LockWithContext(ctx, data[0])
+	defer mutex.UnlockWithContext(ctx, data[0])
+	quiz, err := bot.loadQuiz(data[0])
+	if err != nil || !quiz.Started || quiz.Current != index {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "quizClosedMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	sender := ctx.Sender()
+	if sender.ID == quiz.Host.Telegram.ID {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "quizHostMessage"))
+		return ctx, fmt.Errorf("the host cannot answer")
+	}
+	for _, answer := range quiz.Answers {
+		if answer.User.ID == sender.ID {
+			ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "quizAlreadyAnsweredMessage"))
+			return ctx, fmt.Errorf("already answered")
+		}
+	}
+	answer := QuizAnswer{User: sender, Option: option, Time: time.Now()}
+	answer.TooFast = answer.Time.Sub(quiz.AskedAt) < quizMinAnswerTime
+	quiz.Answers = append(quiz.Answers, answer)
+	if err := quiz.Set(quiz, bot.Bunt); err != nil {
+		return ctx, err
+	}
+	if answer.TooFast {
+		logger(ctx).Warnf("[quiz] %s answered %s after %s", GetUserStr(sender), quiz.ID, answer.Time.Sub(quiz.AskedAt))
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "quizTooFastMessage"))
+		return ctx, nil
+	}
+	ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "quizAnsweredMessage"))
+	return ctx, nil
+}
+
+// quizQuestionTimeout closes the question with index and asks the next one.
+func (bot *TipBot) quizQuestionTimeout(id string, index int) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	quiz, err := bot.loadQuiz(id)
+	if err != nil || !quiz.Started || quiz.Current != index {
+		return
+	}
+	to := bot.topicOf(quiz.Message)
+	bot.closeQuizQuestion(quiz)
+	if quiz.Current < len(quiz.Questions) {
+		bot.askQuizQuestion(quiz, to)
+		return
+	}
+	bot.finishQuiz(quiz)
+}
+
+// closeQuizQuestion pays the prize of the open question to its fastest correct answers and shows
+// the correct answer.
+func (bot *TipBot) closeQuizQuestion(quiz *Quiz) {
+	question := quiz.Questions[quiz.Current]
+	prize := quiz.questionPrize(quiz.Current)
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		log.Errorf("[quiz] could not pay the prizes of %s: %v", quiz.ID, err)
+	}
+	result := ""
+	winners := 0
+	for _, answer := range quiz.Answers {
+		if escrow == nil || winners == len(quizPrizeWeights) {
+			break
+		}
+		if answer.TooFast || answer.Option != question.Answer {
+			continue
+		}
+		amount := prize * quizPrizeWeights[winners] / 6
+		winners++
+		to, err := GetUser(answer.User, *bot)
+		if err != nil || amount == 0 {
+			continue
+		}
+		t := NewTransaction(bot, escrow, to, amount, TransactionType("quiz prize"), TransactionChat(quiz.Message.Chat),
+			TransactionIdempotencyKey(fmt.Sprintf("%s:prize:%d:%d", quiz.ID, quiz.Current, answer.User.ID)))
+		t.Memo = fmt.Sprintf("🧠 Prize of %s in a quiz.", GetUserStr(answer.User))
+		if success, err := t.Send(); !success && err != errDuplicateOperation {
+			// the prize goes back to the host with the rest of the pot
+			log.Errorf("[quiz] could not pay the prize of %s to %s: %v", quiz.ID, GetUserStr(answer.User), err)
+			continue
+		}
+		quiz.Paid += amount
+		quiz.Prizes = append(quiz.Prizes, QuizPrize{User: answer.User, Question: quiz.Current, Amount: amount})
+		result += fmt.Sprintf(i18n.Translate(quiz.LanguageCode, "quizWinnerMessage"), GetUserStrMd(answer.User), amount)
+	}
+	if winners == 0 {
+		result = i18n.Translate(quiz.LanguageCode, "quizNoWinnersMessage")
+	}
+	text := quiz.questionStr(quiz.Current) + fmt.Sprintf(i18n.Translate(quiz.LanguageCode, "quizAnswerMessage"), str.MarkdownEscape(question.Options[question.Answer])) + result
+	bot.tryEditMessage(quiz.Message, text, &tb.ReplyMarkup{})
+	quiz.Current++
+	quiz.Answers = make([]QuizAnswer, 0)
+	runtime.IgnoreError(quiz.Set(quiz, bot.Bunt))
+}
+
+// finishQuiz refunds the prizes nobody won to the host and shows the winners of the quiz.
+func (bot *TipBot) finishQuiz(quiz *Quiz) {
+	if rest := quiz.Pot - quiz.Paid; rest > 0 {
+		escrow, err := bot.EscrowWallet()
+		if err != nil {
+			log.Errorf("[quiz] could not refund %s: %v", quiz.ID, err)
+			return
+		}
+		to, err := GetUser(quiz.Host.Telegram, *bot)
+		if err != nil {
+			log.Errorf("[quiz] could not refund %s: %v", quiz.ID, err)
+			return
+		}
+		t := NewTransaction(bot, escrow, to, rest, TransactionType("quiz refund"), TransactionIdempotencyKey(quiz.ID+":refund"))
+		t.Memo = fmt.Sprintf("🧠 Refund of the prizes nobody won in a quiz of %s.", GetUserStr(quiz.Host.Telegram))
+		if success, err := t.Send(); !success && err != errDuplicateOperation {
+			// the quiz stays active, its expiry tries again
+			log.Errorf("[quiz] could not refund %s: %v", quiz.ID, err)
+			return
+		}
+	}
+	runtime.IgnoreError(quiz.Inactivate(quiz, bot.Bunt))
+	log.Infof("[🧠 quiz] %s is over, %d of %d sat won", quiz.ID, quiz.Paid, quiz.Pot)
+
+	// the prizes of every winner in the order of the leaderboard
+	winners := make([]QuizPrize, 0)
+	for _, prize := range quiz.Prizes {
+		found := false
+		for i := range winners {
+			if winners[i].User.ID == prize.User.ID {
+				winners[i].Amount += prize.Amount
+				found = true
+			}
+		}
+		if !found {
+			winners = append(winners, QuizPrize{User: prize.User, Amount: prize.Amount})
+		}
+	}
+	sort.SliceStable(winners, func(i, j int) bool { return winners[i].Amount > winners[j].Amount })
+	text := fmt.Sprintf(i18n.Translate(quiz.LanguageCode, "quizFinishedMessage"), GetUserStrMd(quiz.Host.Telegram))
+	for _, winner := range winners {
+		text += fmt.Sprintf(i18n.Translate(quiz.LanguageCode, "quizWinnerMessage"), GetUserStrMd(winner.User), winner.Amount)
+	}
+	if len(winners) == 0 {
+		text += i18n.Translate(quiz.LanguageCode, "quizNoWinnersMessage")
+	}
+	if rest := quiz.Pot - quiz.Paid; rest > 0 {
+		text += fmt.Sprintf(i18n.Translate(quiz.LanguageCode, "quizRefundedMessage"), rest)
+	}
+	if quiz.Message != nil {
+		bot.trySendMessage(bot.topicOf(quiz.Message), text)
+	}
+}
+
+// expireQuiz ends a quiz whose timers were lost. The open question is closed, the questions that
+// were not asked yet are skipped.
+func (bot *TipBot) expireQuiz(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	quiz, err := bot.loadQuiz(id)
+	if err != nil || !quiz.Started {
+		return
+	}
+	if quiz.Message != nil && quiz.Current < len(quiz.Questions) {
+		bot.closeQuizQuestion(quiz)
+	}
+	bot.finishQuiz(quiz)
+}
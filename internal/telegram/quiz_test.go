@@ -0,0 +1,76 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestQuiz(t *testing.T) {
+	h := newTestHarness(t)
+	escrow := h.newUser(testBotUser, 0)
+	host := &tb.User{ID: 9941, Username: "host", FirstName: "Host", LanguageCode: "en"}
+	alice := &tb.User{ID: 9942, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9943, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9940, Type: tb.ChatGroup, Title: "trivia"}
+	from := h.newUser(host, 1000)
+	winner := h.newUser(alice, 0)
+	h.newUser(bob, 0)
+
+	h.sendMessage(host, privateChat(host), "/quiz 600\nSmallest unit of bitcoin? | Satoshi | Bit | Wei\nBlock time in minutes? | 10 | 1 | 60")
+	text := h.lastMessage(host.ID).Text()
+	start := text[strings.Index(text, "`")+1 : strings.LastIndex(text, "`")]
+	if !strings.HasPrefix(start, "/quiz ") {
+		t.Fatalf("message = %q", text)
+	}
+	h.sendMessage(host, group, start)
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 600 {
+		t.Fatalf("balance of the escrow = %d, want 600", balance)
+	}
+	if claims := h.bot.pendingClaims(); len(claims) != 1 || claims[0].Type != ClaimTypeQuiz {
+		t.Errorf("pending claims = %+v, want the quiz", claims)
+	}
+	id := "quiz:" + strings.TrimPrefix(start, "/quiz ")
+	question := h.lastMessage(group.ID)
+	if !strings.Contains(question.Text(), "Smallest unit of bitcoin?") {
+		t.Fatalf("question = %q", question.Text())
+	}
+
+	// answers right after the question are too fast for humans
+	h.pressButton(bob, question, "Satoshi")
+	quiz, err := h.bot.loadQuiz(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quiz.Answers) != 1 || !quiz.Answers[0].TooFast {
+		t.Fatalf("answers = %+v", quiz.Answers)
+	}
+	quiz.AskedAt = time.Now().Add(-5 * time.Second)
+	if err := quiz.Set(quiz, h.bot.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	h.pressButton(alice, question, "Satoshi")
+	h.bot.quizQuestionTimeout(id, 0)
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if balance := h.lnbits.Balance(winner.Wallet.ID); balance != 150 {
+		t.Errorf("balance of the winner = %d, want 150", balance)
+	}
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "Block time in minutes?") {
+		t.Fatalf("second question = %q", text)
+	}
+
+	// nobody answers the last question, the rest of the pot goes back to the host
+	h.bot.quizQuestionTimeout(id, 1)
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 850 {
+		t.Errorf("balance of the host = %d, want 850", balance)
+	}
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 0 {
+		t.Errorf("balance of the escrow = %d after the quiz, want 0", balance)
+	}
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "is over") || !strings.Contains(text, "+150 sat") {
+		t.Errorf("message after the quiz = %q", text)
+	}
+}
@@ -0,0 +1,186 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/eko/gocache/store"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	rainTransactionType = "rain"
+	// rainDefaultWindow is how recently members must have posted to get rain without a window
+	rainDefaultWindow = 24 * time.Hour
+	// rainMaxWindow is how long the bot remembers the members who posted in a group
+	rainMaxWindow = 7 * 24 * time.Hour
+	// rainMaxRecipients caps the number of members that a single /rain pays
+	rainMaxRecipients = 100
+)
+
+// RecentMembers are the members who recently posted in a group, the most recent poster first.
+type RecentMembers struct {
+	Members []ThreadPoster `json:"members"`
+}
+
+func recentMembersCacheKey(chatID int64) string {
+	return fmt.Sprintf("recent-members-%d", chatID)
+}
+
+// recordRecentMember remembers the sender of a group message as a recent member of the group.
+func (bot *TipBot) recordRecentMember(m *tb.Message) {
+	if bot.Cache.StoreInterface == nil || m.Sender == nil || m.Sender.IsBot {
+		return
+	}
+	key := recentMembersCacheKey(m.Chat.ID)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	updated := RecentMembers{Members: []ThreadPoster{{User: m.Sender, PostedAt: time.Now()}}}
+	for _, member := range bot.recentMembers(m.Chat.ID).Members {
+		if member.User.ID != m.Sender.ID && time.Since(member.PostedAt) < rainMaxWindow && len(updated.Members) < rainMaxRecipients {
+			updated.Members = append(updated.Members, member)
+		}
+	}
+	bot.Cache.Set(key, updated, &store.Options{Expiration: rainMaxWindow})
+}
+
+func (bot *TipBot) recentMembers(chatID int64) RecentMembers {
+	if members, err := bot.Cache.Get(recentMembersCacheKey(chatID)); err == nil {
+		return members.(RecentMembers)
+	}
+	return RecentMembers{}
+}
+
+// rainRecipients returns the members who posted in a group within window, without the sender
+// and the excluded usernames.
+func (bot *TipBot) rainRecipients(chatID int64, window time.Duration, senderID int64, excluded map[string]bool) []*tb.User {
+	users := make([]*tb.User, 0)
+	for _, member := range bot.recentMembers(chatID).Members {
+		if member.User.ID == senderID || member.User.IsBot || time.Since(member.PostedAt) > window ||
+			excluded[strings.ToLower(member.User.Username)] {
+			continue
+		}
+		users = append(users, member.User)
+	}
+	return users
+}
+
+// parseRainWindow parses a window like 2h, 30m or 3d.
+func parseRainWindow(arg string) (time.Duration, error) {
+	arg = strings.ToLower(arg)
+	if days, err := strconv.ParseUint(strings.TrimSuffix(arg, "d"), 10, 64); strings.HasSuffix(arg, "d") && err == nil {
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(arg)
+}
+
+// rainHandler is invoked on /rain <amount> [<window>] [-@user ...] in groups. The amount is split
+// between the members who posted in the group within the window, 24 hours without one. Members
+// with a - in front of their username get nothing.
+func (bot *TipBot) rainHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Sender, Translate(ctx, "rainHelpMessage"))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	args := strings.Fields(m.Text)
+	if len(args) < 2 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "rainHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(args[1])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "rainHelpMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	window := rainDefaultWindow
+	excluded := map[string]bool{}
+	for _, arg := range args[2:] {
+		if strings.HasPrefix(arg, "-@") {
+			excluded[strings.ToLower(strings.TrimPrefix(arg, "-@"))] = true
+			continue
+		}
+		if window, err = parseRainWindow(arg); err != nil || window <= 0 || window > rainMaxWindow {
+			bot.trySendMessage(m.Sender, Translate(ctx, "rainHelpMessage"))
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+	}
+	from := LoadUser(ctx)
+	recipients := bot.rainRecipients(m.Chat.ID, window, from.Telegram.ID, excluded)
+	if len(recipients) == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "rainNoMembersMessage"))
+		return ctx, errors.Create(errors.NoReplyMessageError)
+	}
+	perUser := amount / int64(len(recipients))
+	if perUser < 1 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "rainAmountTooSmallMessage"), len(recipients)))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	total := perUser*int64(len(recipients)) + serviceFee(from, rainTransactionType, perUser)*int64(len(recipients))
+	balance, err := bot.GetUserBalance(from)
+	if err != nil {
+		return ctx, err
+	}
+	if balance < total {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "insufficientFundsMessage"), balance, total))
+		return ctx, errors.Create(errors.BalanceToLowError)
+	}
+
+	// a redelivered command pays the same rows of the same payout
+	payout := &Payout{
+		Base:         storage.New(storage.ID(fmt.Sprintf("payout:rain:%d:%d", m.Chat.ID, m.ID))),
+		From:         from,
+		Type:         rainTransactionType,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	members := make([]*lnbits.User, 0, len(recipients))
+	for _, recipient := range recipients {
+		to, exists := bot.UserExists(recipient)
+		if !exists {
+			if to, err = bot.CreateWalletForTelegramUser(recipient); err != nil {
+				logger(ctx).Errorf("[/rain] could not create wallet for %s: %v", GetUserStr(recipient), err)
+				continue
+			}
+		}
+		members = append(members, to)
+		payout.Rows = append(payout.Rows, PayoutRow{
+			Line:      len(payout.Rows) + 1,
+			Recipient: GetUserStr(recipient),
+			UserId:    recipient.ID,
+			Amount:    perUser,
+			Memo:      fmt.Sprintf("🌧 Rain from %s in %s.", GetUserStr(from.Telegram), m.Chat.Title),
+		})
+		payout.Total += perUser
+	}
+	if err := bot.executePayout(payout, from); err != nil {
+		return ctx, err
+	}
+
+	paid, failed := make([]string, 0), 0
+	for i, row := range payout.Rows {
+		if row.Status == PayoutRowPaid {
+			paid = append(paid, GetUserStrMd(members[i].Telegram))
+		} else {
+			failed++
+		}
+	}
+	if len(paid) == 0 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf("%s: %s", Translate(ctx, "tipErrorMessage"), Translate(ctx, "tipUndefinedErrorMsg")))
+		return ctx, fmt.Errorf("no payment of /rain succeeded")
+	}
+	sent := perUser * int64(len(paid))
+	logger(ctx).Infof("[🌧 rain] %s paid %d members %d sat each (%d sat).", GetUserStr(from.Telegram), len(paid), perUser, sent)
+	bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "rainSummaryMessage"), GetUserStrMd(from.Telegram), sent, len(paid), perUser, strings.Join(paid, ", ")))
+	if failed > 0 {
+		bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "rainPartialMessage"), failed))
+	}
+	return ctx, nil
+}
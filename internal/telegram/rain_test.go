@@ -0,0 +1,44 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestRain(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9931, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9932, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	carol := &tb.User{ID: 9933, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	dave := &tb.User{ID: 9934, Username: "dave", FirstName: "Dave", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9930, Type: tb.ChatGroup, Title: "rainforest"}
+	from := h.newUser(alice, 1000)
+	toBob := h.newUser(bob, 0)
+	toCarol := h.newUser(carol, 0)
+
+	h.sendMessage(alice, group, "/rain 1000")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "Nobody else posted") {
+		t.Fatalf("message = %q", text)
+	}
+
+	h.sendMessage(bob, group, "gm")
+	h.sendMessage(carol, group, "gm")
+	h.sendMessage(dave, group, "gm")
+	// dave is excluded, the sender gets nothing
+	h.sendMessage(alice, group, "gm")
+	h.sendMessage(alice, group, "/rain 1001 1h -@dave")
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "rain 1000 sat on 2 members (500 sat each)") {
+		t.Fatalf("summary = %q", text)
+	}
+	if balance := h.lnbits.Balance(toBob.Wallet.ID); balance != 500 {
+		t.Errorf("balance of bob = %d, want 500", balance)
+	}
+	if balance := h.lnbits.Balance(toCarol.Wallet.ID); balance != 500 {
+		t.Errorf("balance of carol = %d, want 500", balance)
+	}
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 0 {
+		t.Errorf("balance of alice = %d, want 0", balance)
+	}
+}
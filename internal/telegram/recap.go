@@ -0,0 +1,90 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	groupRecapInterval      = 7 * 24 * time.Hour
+	groupRecapCheckInterval = time.Hour
+)
+
+// startGroupRecapWorker periodically posts the weekly recap in groups that turned it on.
+func (bot *TipBot) startGroupRecapWorker() {
+	go func() {
+		for {
+			if !isShuttingDown() {
+				bot.postGroupRecaps(time.Now())
+			}
+			time.Sleep(groupRecapCheckInterval)
+		}
+	}()
+}
+
+// postGroupRecaps posts the recap in all groups whose last recap is a week old.
+func (bot *TipBot) postGroupRecaps(now time.Time) {
+	var due []GroupSettings
+	tx := bot.DB.Groups.Where("recap = ? AND recap_sent_at <= ?", true, now.Add(-groupRecapInterval)).Find(&due)
+	if tx.Error != nil {
+		log.Errorf("[groupRecap] %v", tx.Error)
+		return
+	}
+	for _, settings := range due {
+		bot.postGroupRecap(settings.ID, now)
+	}
+}
+
+func (bot *TipBot) postGroupRecap(chatID int64, now time.Time) {
+	// other instances of a cluster post the same recaps
+	key := fmt.Sprintf("group-recap:%d", chatID)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	settings := &GroupSettings{}
+	if tx := bot.DB.Groups.Where("id = ?", chatID).First(settings); tx.Error != nil {
+		return
+	}
+	if !settings.Recap || now.Sub(settings.RecapSentAt) < groupRecapInterval {
+		return
+	}
+	from := settings.RecapSentAt
+	if now.Sub(from) > 2*groupRecapInterval {
+		// the bot was not running for a while, only look at the last week
+		from = now.Add(-groupRecapInterval)
+	}
+	recap, err := bot.GroupRecap(chatID, settings.RecapTopic, from, now)
+	if err != nil {
+		log.Errorf("[groupRecap] group %d: %v", chatID, err)
+		return
+	}
+	settings.RecapSentAt = now
+	if err := bot.saveGroupSettings(settings); err != nil {
+		log.Errorf("[groupRecap] could not save settings of group %d: %v", chatID, err)
+		return
+	}
+	// a week without tips is not worth a post
+	if recap.Tips == 0 {
+		return
+	}
+	chat := &tb.Chat{ID: chatID, Type: tb.ChatGroup}
+	var to tb.Recipient = chat
+	if settings.RecapTopic != 0 {
+		to = Topic{Chat: chat, ID: settings.RecapTopic}
+	}
+	languageCode := bot.getGroupLanguageCode(chat)
+	if len(languageCode) == 0 {
+		languageCode = "en"
+	}
+	message := fmt.Sprintf(i18n.Translate(languageCode, "groupRecapMessage"), recap.Volume, recap.Tips, recap.Tippers)
+	if len(recap.TopTipper) > 0 {
+		message += fmt.Sprintf(i18n.Translate(languageCode, "groupRecapGenerousMessage"), str.MarkdownEscape(recap.TopTipper), recap.TopVolume)
+	}
+	log.Infof("[groupRecap] posting the recap of group %d", chatID)
+	bot.trySendMessage(to, message, keepMessage)
+}
@@ -0,0 +1,80 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestGroupRecap(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9701, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9702, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	carol := &tb.User{ID: 9703, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9700, Type: tb.ChatGroup, Title: "group"}
+	h.newUser(alice, 1000)
+	h.newUser(bob, 1000)
+	h.newUser(carol, 0)
+
+	post := h.sendMessage(carol, group, "gm")
+	h.sendReply(alice, group, "/tip 300", post)
+	h.sendReply(bob, group, "/tip 100", post)
+	h.sendReply(bob, group, "/tip 100", post)
+
+	settings := h.bot.getGroupSettings(group.ID)
+	settings.Recap = true
+	settings.RecapSentAt = time.Now().Add(-6 * 24 * time.Hour)
+	if err := h.bot.saveGroupSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	h.bot.postGroupRecaps(time.Now())
+	if text := h.lastMessage(group.ID).Text(); strings.Contains(text, "Weekly recap") {
+		t.Errorf("recap was posted before a week passed: %q", text)
+	}
+
+	h.bot.postGroupRecaps(time.Now().Add(24 * time.Hour))
+	text := h.lastMessage(group.ID).Text()
+	for _, want := range []string{"500 sat were tipped in 3 tips by 2 members", "Most generous: @alice with 300 sat"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("recap does not contain %q: %q", want, text)
+		}
+	}
+	h.bot.postGroupRecaps(time.Now().Add(25 * time.Hour))
+	count := 0
+	for _, r := range h.sent {
+		if r.ChatId() == group.ID && strings.Contains(r.Text(), "Weekly recap") {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("recap was posted %d times, want once", count)
+	}
+}
+
+func TestGroupQuietMode(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9751, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9752, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9750, Type: tb.ChatGroup, Title: "group"}
+	h.newUser(alice, 1000)
+	to := h.newUser(bob, 0)
+
+	settings := h.bot.getGroupSettings(group.ID)
+	settings.Quiet = true
+	if err := h.bot.saveGroupSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	post := h.sendMessage(bob, group, "gm")
+	h.sendReply(alice, group, "/tip 100", post)
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 100 {
+		t.Errorf("balance of bob = %d, want 100", balance)
+	}
+	if h.called("sendMessage", group.ID) {
+		t.Errorf("bot posted in a quiet group: %q", h.lastMessage(group.ID).Text())
+	}
+	if !h.called("setMessageReaction", group.ID) {
+		t.Error("no reaction on the tipped message")
+	}
+}
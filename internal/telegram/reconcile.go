@@ -0,0 +1,203 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	reconcileInterval = 5 * time.Minute
+	// reconcileGrace is the time the webhook and the payment itself get before the
+	// reconciler looks at them.
+	reconcileGrace = 2 * time.Minute
+	// reconcileWindow is how long after its expiry an invoice is still checked.
+	reconcileWindow = 2 * time.Hour
+	// reconcileStuckAfter is when the operator is told about a payment in flight.
+	reconcileStuckAfter = time.Hour
+)
+
+var (
+	reconcileUncreditedMessage = "🧾 Reconciler: invoice `%s` of %s (%d sat) was paid but not credited. It is settled now."
+	reconcileStuckMessage      = "⚠️ Reconciler: payment `%s` of %s (%d sat) is in flight for %s."
+	reconcileResolvedMessage   = "🧾 Reconciler: payment `%s` of %s (%d sat) was stuck and has %s."
+)
+
+// OutgoingPayment is a payment of a user whose outcome is not known yet. It is kept
+// until the payment is paid or failed, so that payments that are stuck in flight or
+// were interrupted by a restart are resolved by the reconciler.
+type OutgoingPayment struct {
+	PaymentHash  string       `json:"payment_hash"`
+	User         *lnbits.User `json:"user"`
+	Amount       int64        `json:"amount"`
+	LanguageCode string       `json:"languagecode"`
+	StartedAt    time.Time    `json:"started_at"`
+	Alerted      bool         `json:"alerted"` // the operator was told that the payment is stuck
+	Waiting      bool         `json:"waiting"` // the user was told to wait for the outcome
+}
+
+func (p OutgoingPayment) Key() string {
+	return fmt.Sprintf("outgoing-payment:%s", p.PaymentHash)
+}
+
+// trackOutgoingPayment records a payment of the user before it is sent.
+func (bot *TipBot) trackOutgoingPayment(user *lnbits.User, bolt11 string, amount int64, languageCode string) *OutgoingPayment {
+	invoice, err := decodepay.Decodepay(bolt11)
+	if err != nil {
+		return nil
+	}
+	payment := &OutgoingPayment{PaymentHash: invoice.PaymentHash, User: user, Amount: amount, LanguageCode: languageCode, StartedAt: time.Now()}
+	if err := bot.Bunt.Set(payment); err != nil {
+		log.Errorf("[reconcile] could not track payment %s: %v", payment.PaymentHash, err)
+		return nil
+	}
+	return payment
+}
+
+// untrackOutgoingPayment forgets a payment whose outcome is known.
+func (bot *TipBot) untrackOutgoingPayment(payment *OutgoingPayment) {
+	if payment == nil {
+		return
+	}
+	bot.Bunt.Delete(payment.Key(), payment)
+}
+
+// startReconcileWorker periodically compares the invoices and payments of the bot with
+// the state of the wallet backend.
+func (bot *TipBot) startReconcileWorker() {
+	go func() {
+		for {
+			if bot.walletBackendAvailable() && !isShuttingDown() {
+				bot.reconcile(time.Now())
+			}
+			time.Sleep(reconcileInterval)
+		}
+	}()
+}
+
+func (bot *TipBot) reconcile(now time.Time) {
+	var invoices []*InvoiceEvent
+	bot.Bunt.Ascend("invoice", func(key, value string) bool {
+		event := &InvoiceEvent{}
+		if err := json.Unmarshal([]byte(value), event); err != nil || event.Invoice == nil || !event.Open || event.Settled {
+			return true
+		}
+		bolt11, err := decodepay.Decodepay(event.PaymentRequest)
+		if err != nil {
+			return true
+		}
+		created := time.Unix(int64(bolt11.CreatedAt), 0)
+		expires := time.Unix(int64(bolt11.CreatedAt+bolt11.Expiry), 0)
+		if now.Sub(created) >= reconcileGrace && now.Before(expires.Add(reconcileWindow)) {
+			invoices = append(invoices, event)
+		}
+		return true // continue iteration
+	})
+	for _, event := range invoices {
+		bot.reconcileInvoice(event)
+	}
+
+	var payments []*OutgoingPayment
+	bot.Bunt.Ascend("outgoing-payment", func(key, value string) bool {
+		payment := &OutgoingPayment{}
+		if err := json.Unmarshal([]byte(value), payment); err == nil && payment.User != nil && now.Sub(payment.StartedAt) >= reconcileGrace {
+			payments = append(payments, payment)
+		}
+		return true // continue iteration
+	})
+	for _, payment := range payments {
+		bot.reconcileOutgoingPayment(payment, now)
+	}
+}
+
+// reconcileInvoice settles an invoice that was paid but whose payment never arrived at
+// the bot, for example because the webhook got lost.
+func (bot *TipBot) reconcileInvoice(event *InvoiceEvent) {
+	if event.User == nil || event.User.Wallet == nil {
+		return
+	}
+	payment, err := bot.Client.Payment(*event.User.Wallet, event.PaymentHash)
+	if err != nil || !payment.Paid {
+		return
+	}
+	amount := payment.Details.Amount
+	if amount == 0 {
+		amount = event.Amount * 1000
+	}
+	log.WithFields(log.Fields{"payment_hash": event.PaymentHash}).Warnf("[reconcile] invoice of %s (%d sat) was paid but not credited", GetUserStr(event.User.Telegram), amount/1000)
+	bot.handleIncomingPayment(lnbits.IncomingPayment{
+		WalletID:    event.User.Wallet.ID,
+		PaymentHash: event.PaymentHash,
+		Amount:      amount,
+		Memo:        event.Memo,
+	})
+	bot.alertOperator(fmt.Sprintf(reconcileUncreditedMessage, event.PaymentHash, GetUserStrMd(event.User.Telegram), amount/1000))
+}
+
+// reconcileOutgoingPayment resolves a payment that is paid or failed by now and tells the
+// operator about payments that are stuck in flight.
+func (bot *TipBot) reconcileOutgoingPayment(p *OutgoingPayment, now time.Time) {
+	// other instances of a cluster reconcile the same payments
+	mutex.Lock(p.Key())
+	defer mutex.Unlock(p.Key())
+	if err := bot.Bunt.Get(p); err != nil || p.User == nil || p.User.Wallet == nil {
+		return
+	}
+	logger := log.WithFields(log.Fields{"user_id": p.User.Telegram.ID, "payment_hash": p.PaymentHash})
+	payment, err := bot.Client.Payment(*p.User.Wallet, p.PaymentHash)
+	if _, unknown := err.(lnbits.Error); unknown && now.Sub(p.StartedAt) >= reconcileStuckAfter {
+		// the backend never received the payment
+		payment, err = lnbits.LNbitsPayment{}, nil
+	}
+	if err != nil {
+		logger.Errorf("[reconcile] could not check payment: %v", err)
+		return
+	}
+	switch {
+	case payment.Paid:
+		bot.untrackOutgoingPayment(p)
+		if !p.Alerted && !p.Waiting {
+			// the payment went through while the bot was waiting for it
+			return
+		}
+		logger.Infof("[reconcile] stuck payment of %s (%d sat) was paid", GetUserStr(p.User.Telegram), p.Amount)
+		bot.trySendMessage(p.User.Telegram, fmt.Sprintf(i18n.Translate(p.LanguageCode, "reconcilePaymentSentMessage"), p.Amount))
+		if p.Alerted {
+			bot.alertOperator(fmt.Sprintf(reconcileResolvedMessage, p.PaymentHash, GetUserStrMd(p.User.Telegram), p.Amount, "been paid"))
+		}
+	case payment.Details.Pending:
+		if p.Alerted || now.Sub(p.StartedAt) < reconcileStuckAfter {
+			return
+		}
+		logger.Warnf("[reconcile] payment of %s (%d sat) is stuck in flight", GetUserStr(p.User.Telegram), p.Amount)
+		p.Alerted = true
+		bot.Bunt.Set(p)
+		bot.trySendMessage(p.User.Telegram, fmt.Sprintf(i18n.Translate(p.LanguageCode, "reconcilePaymentStuckMessage"), p.Amount))
+		bot.alertOperator(fmt.Sprintf(reconcileStuckMessage, p.PaymentHash, GetUserStrMd(p.User.Telegram), p.Amount, formatPendingDuration(now.Sub(p.StartedAt))))
+	default:
+		// failed payments are not charged by the wallet backend
+		bot.untrackOutgoingPayment(p)
+		logger.Infof("[reconcile] payment of %s (%d sat) failed", GetUserStr(p.User.Telegram), p.Amount)
+		bot.trySendMessage(p.User.Telegram, fmt.Sprintf(i18n.Translate(p.LanguageCode, "reconcilePaymentFailedMessage"), p.Amount))
+		if p.Alerted {
+			bot.alertOperator(fmt.Sprintf(reconcileResolvedMessage, p.PaymentHash, GetUserStrMd(p.User.Telegram), p.Amount, "failed"))
+		}
+	}
+}
+
+// alertOperator sends a message to the operator of the bot, if there is one.
+func (bot *TipBot) alertOperator(message string) {
+	if internal.Configuration.Bot.OperatorId == 0 {
+		log.Warnf("[alertOperator] no operator configured: %s", message)
+		return
+	}
+	bot.trySendMessage(&tb.User{ID: internal.Configuration.Bot.OperatorId}, message)
+}
@@ -0,0 +1,82 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestReconcile(t *testing.T) {
+	h := newTestHarness(t)
+	operatorUser := &tb.User{ID: 9801, Username: "operator", FirstName: "Operator", LanguageCode: "en"}
+	alice := &tb.User{ID: 9802, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9803, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	h.newUser(operatorUser, 0)
+	aliceUser := h.newUser(alice, 1000)
+	bobUser := h.newUser(bob, 0)
+	setTestServiceFee(t, operatorUser.ID, internal.FeeConfiguration{})
+
+	// an invoice of bob is paid, but its webhook never arrives
+	invoice, err := h.bot.Client.CreateInvoice(*bobUser.Wallet, lnbits.InvoiceParams{Amount: 100, Memo: "lost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &InvoiceEvent{
+		Invoice:      &Invoice{PaymentHash: invoice.PaymentHash, PaymentRequest: invoice.PaymentRequest, Amount: 100, Memo: "lost"},
+		User:         bobUser,
+		Callback:     InvoiceCallbackGeneric,
+		LanguageCode: "en",
+		Open:         true,
+	}
+	if err := h.bot.Bunt.Set(event); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.bot.Client.Pay(*aliceUser.Wallet, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}); err != nil {
+		t.Fatal(err)
+	}
+	// a payment of alice went through, but the bot was restarted before it knew
+	external, err := h.lnbits.ExternalInvoice(21, "coffee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outgoing := h.bot.trackOutgoingPayment(aliceUser, external.PaymentRequest, 21, "en")
+	if _, err := h.bot.Client.Pay(*aliceUser.Wallet, lnbits.PaymentParams{Out: true, Bolt11: external.PaymentRequest}); err != nil {
+		t.Fatal(err)
+	}
+
+	h.bot.reconcile(time.Now().Add(reconcileGrace))
+	h.sent = append(h.sent, h.telegram.Requests()...)
+
+	settled := &InvoiceEvent{Invoice: &Invoice{PaymentHash: invoice.PaymentHash}}
+	if err := h.bot.Bunt.Get(settled); err != nil || !settled.Settled || settled.Open {
+		t.Errorf("invoice event = %+v, %v, want settled", settled, err)
+	}
+	if text := h.lastMessage(operatorUser.ID).Text(); !strings.Contains(text, "paid but not credited") {
+		t.Errorf("operator alert = %q", text)
+	}
+	if err := h.bot.Bunt.Get(outgoing); err == nil {
+		t.Error("paid outgoing payment is still tracked")
+	}
+
+	// the payment is handled only once
+	alerts := operatorAlerts(h, operatorUser.ID)
+	h.bot.reconcile(time.Now().Add(reconcileGrace))
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if n := operatorAlerts(h, operatorUser.ID); n != alerts {
+		t.Errorf("second reconciliation sent %d alerts", n-alerts)
+	}
+}
+
+func operatorAlerts(h *testHarness, operatorId int64) int {
+	n := 0
+	for _, r := range h.sent {
+		if r.Method == "sendMessage" && r.ChatId() == operatorId {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,148 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	referralStartPrefix     = "ref_"
+	referralTransactionType = "referral bonus"
+)
+
+// Referral is a user who joined through the invite link of another user. The referrer gets
+// the bonus when the invitee made a first transaction.
+type Referral struct {
+	ID         uint       `gorm:"primarykey"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReferrerId int64      `json:"referrer_id" gorm:"index"`
+	InviteeId  int64      `json:"invitee_id" gorm:"uniqueIndex"`
+	Invitee    string     `json:"invitee"`
+	Bonus      int64      `json:"bonus"`
+	RewardedAt *time.Time `json:"rewarded_at"`
+}
+
+func referralsEnabled() bool {
+	return internal.Configuration.Referral.Bonus > 0 && internal.Configuration.Bot.OperatorId != 0
+}
+
+// OperatorWallet returns the user of the operator, whose wallet pays referral bonuses.
+func (bot *TipBot) OperatorWallet() (*lnbits.User, error) {
+	if internal.Configuration.Bot.OperatorId == 0 {
+		return nil, fmt.Errorf("no operator configured")
+	}
+	user, err := GetLnbitsUser(&tb.User{ID: internal.Configuration.Bot.OperatorId}, *bot)
+	if err != nil {
+		return nil, err
+	}
+	if user.Wallet == nil {
+		return nil, fmt.Errorf("operator has no wallet")
+	}
+	return user, nil
+}
+
+// inviteHandler is invoked on /invite and shows the invite link of the user and how many
+// users joined through it.
+func (bot *TipBot) inviteHandler(ctx intercept.Context) (intercept.Context, error) {
+	if !referralsEnabled() {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "inviteDisabledMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	user := LoadUser(ctx)
+	var invited, rewarded int64
+	var earned struct{ Total int64 }
+	bot.DB.Transactions.Model(&Referral{}).Where("referrer_id = ?", user.Telegram.ID).Count(&invited)
+	bot.DB.Transactions.Model(&Referral{}).Where("referrer_id = ? AND rewarded_at IS NOT NULL AND bonus > 0", user.Telegram.ID).Count(&rewarded)
+	bot.DB.Transactions.Model(&Referral{}).Select("COALESCE(SUM(bonus), 0) AS total").Where("referrer_id = ? AND rewarded_at IS NOT NULL", user.Telegram.ID).Scan(&earned)
+	link := bot.deepLink(referralStartPrefix + user.AnonID)
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "inviteMessage"),
+		internal.Configuration.Referral.Bonus, internal.Configuration.Referral.MinTransaction, link, invited, rewarded, earned.Total), tb.NoPreview)
+	return ctx, nil
+}
+
+// startReferralHandler is invoked on /start ref_<anon id> and remembers who invited the user.
+// Only users who start the bot for the first time can be invited.
+func (bot *TipBot) startReferralHandler(ctx intercept.Context, anonId string) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	if newUser, ok := ctx.Value("newUser").(bool); !ok || !newUser {
+		log.Debugf("[referral] %s is not a new user", GetUserStr(user.Telegram))
+		return bot.balanceHandler(ctx)
+	}
+	if !referralsEnabled() {
+		return ctx, nil
+	}
+	referrer := &lnbits.User{}
+	if tx := bot.DB.Users.Where("anon_id = ?", anonId).First(referrer); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	if referrer.Telegram == nil || referrer.Telegram.ID == user.Telegram.ID || referrer.Banned {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	referral := &Referral{ReferrerId: referrer.Telegram.ID, InviteeId: user.Telegram.ID, Invitee: GetUserStr(user.Telegram)}
+	if tx := bot.DB.Transactions.Create(referral); tx.Error != nil {
+		// the invitee was already referred
+		return ctx, tx.Error
+	}
+	log.Infof("[referral] %s was invited by %s", GetUserStr(user.Telegram), GetUserStr(referrer.Telegram))
+	bot.trySendMessage(referrer.Telegram, fmt.Sprintf(i18n.Translate(referrer.Telegram.LanguageCode, "inviteJoinedMessage"),
+		GetUserStrMd(user.Telegram), internal.Configuration.Referral.Bonus))
+	return ctx, nil
+}
+
+// rewardReferral pays the bonus to the referrer after the first transaction of an invitee.
+// Transactions to the referrer and below the minimum amount don't count, and the bonuses
+// of a referrer are limited per day and in total.
+func (bot *TipBot) rewardReferral(t *Transaction) {
+	if !referralsEnabled() || t.Type == referralTransactionType || t.Type == serviceFeeTransactionType {
+		return
+	}
+	referral := &Referral{}
+	tx := bot.DB.Transactions.Where("invitee_id = ? AND rewarded_at IS NULL", t.FromId).Limit(1).Find(referral)
+	if tx.Error != nil || tx.RowsAffected == 0 {
+		return
+	}
+	if t.Amount < internal.Configuration.Referral.MinTransaction || t.ToId == referral.ReferrerId {
+		return
+	}
+	now := time.Now()
+	var today, total int64
+	bot.DB.Transactions.Model(&Referral{}).Where("referrer_id = ? AND bonus > 0 AND rewarded_at > ?", referral.ReferrerId, now.Add(-24*time.Hour)).Count(&today)
+	bot.DB.Transactions.Model(&Referral{}).Where("referrer_id = ? AND bonus > 0", referral.ReferrerId).Count(&total)
+	maxTotal := int64(internal.Configuration.Referral.MaxTotal)
+	if today >= int64(internal.Configuration.Referral.MaxPerDay) || (maxTotal > 0 && total >= maxTotal) {
+		log.Warnf("[referral] %d reached the limit of bonuses, no bonus for %s", referral.ReferrerId, referral.Invitee)
+		bot.DB.Transactions.Model(referral).Updates(map[string]interface{}{"rewarded_at": now, "bonus": 0})
+		return
+	}
+	operator, err := bot.OperatorWallet()
+	if err != nil {
+		log.Errorf("[referral] %v", err)
+		return
+	}
+	referrer, err := GetLnbitsUser(&tb.User{ID: referral.ReferrerId}, *bot)
+	if err != nil || referrer.Wallet == nil {
+		log.Errorf("[referral] could not load referrer %d: %v", referral.ReferrerId, err)
+		return
+	}
+	bonus := internal.Configuration.Referral.Bonus
+	reward := NewTransaction(bot, operator, referrer, bonus, TransactionType(referralTransactionType), TransactionIdempotencyKey(fmt.Sprintf("referral:%d", referral.ID)))
+	reward.Memo = fmt.Sprintf("🎉 Referral bonus for inviting %s.", referral.Invitee)
+	success, err := reward.Send()
+	if !success && err != errDuplicateOperation {
+		// the next transaction of the invitee tries again
+		log.Errorf("[referral] could not pay the bonus of %d: %v", referral.ID, err)
+		return
+	}
+	bot.DB.Transactions.Model(referral).Updates(map[string]interface{}{"rewarded_at": now, "bonus": bonus})
+	log.Infof("[referral] paid %d sat to %s for inviting %s", bonus, GetUserStr(referrer.Telegram), referral.Invitee)
+	bot.notify(referrer, NotificationPayment, bonus, fmt.Sprintf(i18n.Translate(referrer.Telegram.LanguageCode, "inviteRewardMessage"), bonus, str.MarkdownEscape(referral.Invitee)))
+}
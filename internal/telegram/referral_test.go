@@ -0,0 +1,77 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestReferralBonus(t *testing.T) {
+	h := newTestHarness(t)
+	operatorUser := &tb.User{ID: 9301, Username: "operator", FirstName: "Operator", LanguageCode: "en"}
+	alice := &tb.User{ID: 9302, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9303, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	carol := &tb.User{ID: 9304, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	operator := h.newUser(operatorUser, 10000)
+	referrer := h.newUser(alice, 0)
+	recipient := h.newUser(carol, 0)
+
+	configuration := internal.Configuration
+	t.Cleanup(func() { internal.Configuration = configuration })
+	internal.Configuration.Bot.OperatorId = operatorUser.ID
+	internal.Configuration.Referral = internal.ReferralConfiguration{Bonus: 500, MinTransaction: 100, MaxPerDay: 10}
+
+	h.sendMessage(alice, privateChat(alice), "/invite")
+	text := h.lastMessage(alice.ID).Text()
+	if !strings.Contains(text, "start=ref_"+referrer.AnonID) {
+		t.Fatalf("invite message = %q", text)
+	}
+	h.sendMessage(bob, privateChat(bob), "/start ref_"+referrer.AnonID)
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "joined through your invite link") {
+		t.Errorf("message to the referrer = %q", text)
+	}
+	invitee, err := GetUser(bob, *h.bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.lnbits.Fund(invitee.Wallet.ID, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	// transactions to the referrer and below the minimum don't count
+	for _, transaction := range []*Transaction{
+		NewTransaction(h.bot, invitee, referrer, 200),
+		NewTransaction(h.bot, invitee, recipient, 50),
+	} {
+		if success, err := transaction.Send(); !success {
+			t.Fatal(err)
+		}
+	}
+	if balance := h.lnbits.Balance(operator.Wallet.ID); balance != 10000 {
+		t.Errorf("bonus was paid too early, balance of the operator = %d", balance)
+	}
+	if success, err := NewTransaction(h.bot, invitee, recipient, 100).Send(); !success {
+		t.Fatal(err)
+	}
+	if balance := h.lnbits.Balance(referrer.Wallet.ID); balance != 700 {
+		t.Errorf("balance of the referrer = %d, want 700", balance)
+	}
+	// the bonus is paid once
+	if success, err := NewTransaction(h.bot, invitee, recipient, 100).Send(); !success {
+		t.Fatal(err)
+	}
+	if balance := h.lnbits.Balance(operator.Wallet.ID); balance != 9500 {
+		t.Errorf("balance of the operator = %d, want 9500", balance)
+	}
+
+	// existing users can't be invited
+	h.sendMessage(carol, privateChat(carol), "/start")
+	h.sendMessage(carol, privateChat(carol), "/start ref_"+referrer.AnonID)
+	var referrals int64
+	h.bot.DB.Transactions.Model(&Referral{}).Count(&referrals)
+	if referrals != 1 {
+		t.Errorf("referrals = %d, want 1", referrals)
+	}
+}
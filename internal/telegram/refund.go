@@ -0,0 +1,159 @@
+package telegram
+
+import (
+	"fmt"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	refundMenu          = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnAcceptRefund     = refundMenu.Data("✅ Accept", "accept_refund")
+	btnDeclineRefund    = refundMenu.Data("🚫 Decline", "decline_refund")
+	refundableTransfers = []string{"tip", "send"}
+)
+
+// RefundRequest is the request of the sender of a tip or send to get the funds back.
+// The receiver of the transaction decides whether to refund it.
+type RefundRequest struct {
+	*storage.Base
+	TransactionID uint         `json:"transaction_id"`
+	From          *lnbits.User `json:"from"` // sender of the transaction who asks for the refund
+	To            *lnbits.User `json:"to"`   // receiver of the transaction
+	Amount        int64        `json:"amount"`
+	Type          string       `json:"type"`
+	LanguageCode  string       `json:"languagecode"`
+}
+
+func refundRequestID(transactionID uint) string {
+	return fmt.Sprintf("refund:%d", transactionID)
+}
+
+// refundHandler is invoked on /refund as a reply to the message that confirmed a tip or send
+// to its sender. It asks the receiver to send the funds back.
+func (bot *TipBot) refundHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.ReplyTo == nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "refundHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	t := &Transaction{}
+	tx := bot.DB.Transactions.
+		Where("from_id = ? AND sender_message_id = ? AND success = ?", m.Sender.ID, m.ReplyTo.ID, true).
+		Where("type IN ?", refundableTransfers).
+		First(t)
+	if tx.Error != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "refundHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	// only one refund can be requested per transaction
+	request := &RefundRequest{Base: storage.New(storage.ID(refundRequestID(t.ID)))}
+	mutex.LockWithContext(ctx, request.ID)
+	defer mutex.UnlockWithContext(ctx, request.ID)
+	if _, err := request.Get(request, bot.Bunt); err == nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "refundAlreadyRequestedMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	from := LoadUser(ctx)
+	to, err := GetLnbitsUser(&tb.User{ID: t.ToId}, *bot)
+	if err != nil || to.Wallet == nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "refundUserUnreachableMessage"))
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	request.TransactionID = t.ID
+	request.From = from
+	request.To = to
+	request.Amount = t.Amount
+	request.Type = t.Type
+	request.LanguageCode = to.Telegram.LanguageCode
+
+	message := fmt.Sprintf(i18n.Translate(request.LanguageCode, "refundRequestMessage"), GetUserStrMd(from.Telegram), t.Amount, t.Type)
+	if bot.trySendMessageEditable(to.Telegram, message, refundRequestMenu(request)) == nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "refundUserUnreachableMessage"))
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	runtime.IgnoreError(request.Set(request, bot.Bunt))
+	logger(ctx).Infof("[/refund] %s asked %s to refund %d sat", GetUserStr(from.Telegram), GetUserStr(to.Telegram), t.Amount)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "refundRequestedMessage"), GetUserStrMd(to.Telegram)))
+	return ctx, nil
+}
+
+// refundRequestMenu returns the buttons with which the receiver answers a refund request.
+func refundRequestMenu(request *RefundRequest) *tb.ReplyMarkup {
+	acceptButton := refundMenu.Data(i18n.Translate(request.LanguageCode, "acceptButtonMessage"), "accept_refund", request.ID)
+	declineButton := refundMenu.Data(i18n.Translate(request.LanguageCode, "denyButtonMessage"), "decline_refund", request.ID)
+	refundMenu.Inline(refundMenu.Row(acceptButton, declineButton))
+	return refundMenu
+}
+
+// loadRefundRequest loads the active refund request of a button that only the receiver may press.
+func (bot *TipBot) loadRefundRequest(ctx intercept.Context) (*RefundRequest, error) {
+	request := &RefundRequest{Base: storage.New(storage.ID(ctx.Data()))}
+	sn, err := request.Get(request, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	request = sn.(*RefundRequest)
+	if request.To.Telegram.ID != ctx.Sender().ID {
+		return nil, errors.Create(errors.UnknownError)
+	}
+	if !request.Active {
+		bot.tryEditMessage(ctx.Message(), i18n.Translate(request.LanguageCode, "refundNotActiveMessage"), &tb.ReplyMarkup{})
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	return request, nil
+}
+
+// acceptRefundHandler is invoked when the receiver accepts a refund request and sends the funds back.
+func (bot *TipBot) acceptRefundHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	request, err := bot.loadRefundRequest(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	to := LoadUser(ctx)
+	from, err := GetLnbitsUser(request.From.Telegram, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	t := NewTransaction(bot, to, from, request.Amount, TransactionType("refund"), TransactionIdempotencyKey(request.ID), TransactionContext(ctx))
+	t.Memo = fmt.Sprintf("↩️ Refund from %s to %s.", GetUserStr(to.Telegram), GetUserStr(from.Telegram))
+	success, err := t.Send()
+	if err == errOperationInProgress {
+		return ctx, err
+	}
+	// a refund that was sent before only has to be closed
+	if !success && err != errDuplicateOperation {
+		logger(ctx).Warnf("[refund] could not refund %s: %v", request.ID, err)
+		// the request stays open, so that the receiver can try again
+		bot.tryEditMessage(ctx.Message(), fmt.Sprintf(i18n.Translate(request.LanguageCode, "refundFailedMessage"), request.Amount), refundRequestMenu(request))
+		return ctx, err
+	}
+	runtime.IgnoreError(request.Inactivate(request, bot.Bunt))
+	logger(ctx).Infof("[↩️ refund] %s refunded %d sat to %s", GetUserStr(to.Telegram), request.Amount, GetUserStr(from.Telegram))
+	bot.tryEditMessage(ctx.Message(), fmt.Sprintf(i18n.Translate(request.LanguageCode, "refundAcceptedMessage"), request.Amount, GetUserStrMd(from.Telegram)), &tb.ReplyMarkup{})
+	bot.notify(from, NotificationPayment, request.Amount, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "refundReceivedMessage"), GetUserStrMd(to.Telegram), request.Amount)+bot.fiatAmount(from, request.Amount))
+	return ctx, nil
+}
+
+// declineRefundHandler is invoked when the receiver declines a refund request.
+func (bot *TipBot) declineRefundHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	request, err := bot.loadRefundRequest(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	runtime.IgnoreError(request.Inactivate(request, bot.Bunt))
+	bot.tryEditMessage(ctx.Message(), i18n.Translate(request.LanguageCode, "refundDeclinedMessage"), &tb.ReplyMarkup{})
+	bot.trySendMessage(request.From.Telegram, fmt.Sprintf(i18n.Translate(request.From.Telegram.LanguageCode, "refundRequestDeclinedMessage"), GetUserStrMd(request.To.Telegram), request.Amount))
+	return ctx, nil
+}
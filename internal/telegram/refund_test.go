@@ -0,0 +1,83 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestRefundFlow(t *testing.T) {
+	h := newTestHarness(t)
+	sender := &tb.User{ID: 7001, Username: "sender", FirstName: "Sender", LanguageCode: "en"}
+	receiver := &tb.User{ID: 7002, Username: "receiver", FirstName: "Receiver", LanguageCode: "en"}
+	from := h.newUser(sender, 1000)
+	to := h.newUser(receiver, 0)
+
+	h.sendMessage(sender, privateChat(sender), "/send 100 @receiver")
+	h.pressButton(sender, h.lastMessage(sender.ID), "✅ Send")
+	sent := h.lastMessage(sender.ID)
+	h.replyToBot(sender, sent, "/refund")
+
+	request := h.lastMessage(receiver.ID)
+	if !strings.Contains(request.Text(), "send back the 100 sat") {
+		t.Fatalf("refund request = %q", request.Text())
+	}
+	// a second request for the same payment is refused
+	h.replyToBot(sender, sent, "/refund")
+	if h.lastMessage(receiver.ID).Id != request.Id {
+		t.Error("asked the receiver twice")
+	}
+
+	h.pressButton(receiver, request, "Accept")
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of sender = %d, want 1000", balance)
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 0 {
+		t.Errorf("balance of receiver = %d, want 0", balance)
+	}
+	if text := h.lastMessage(sender.ID).Text(); !strings.Contains(text, "refunded 100 sat") {
+		t.Errorf("last message to sender = %q", text)
+	}
+
+	// the request can only be accepted once
+	h.pressButton(receiver, request, "Accept")
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of sender = %d after accepting twice, want 1000", balance)
+	}
+}
+
+func TestRefundRetry(t *testing.T) {
+	h := newTestHarness(t)
+	sender := &tb.User{ID: 7003, Username: "sender", FirstName: "Sender", LanguageCode: "en"}
+	receiver := &tb.User{ID: 7004, Username: "receiver", FirstName: "Receiver", LanguageCode: "en"}
+	other := &tb.User{ID: 7005, Username: "other", FirstName: "Other", LanguageCode: "en"}
+	from := h.newUser(sender, 1000)
+	to := h.newUser(receiver, 0)
+	h.newUser(other, 0)
+
+	h.sendMessage(sender, privateChat(sender), "/send 100 @receiver")
+	h.pressButton(sender, h.lastMessage(sender.ID), "✅ Send")
+	h.replyToBot(sender, h.lastMessage(sender.ID), "/refund")
+	request := h.lastMessage(receiver.ID)
+
+	// the receiver spent the sats already, the refund fails
+	h.sendMessage(receiver, privateChat(receiver), "/send 100 @other")
+	h.pressButton(receiver, h.lastMessage(receiver.ID), "✅ Send")
+	h.pressButton(receiver, request, "Accept")
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 900 {
+		t.Fatalf("balance of sender = %d after the failed refund, want 900", balance)
+	}
+
+	// the request stays open and can be accepted again
+	if err := h.lnbits.Fund(to.Wallet.ID, 100); err != nil {
+		t.Fatal(err)
+	}
+	h.pressButton(receiver, request, "Accept")
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of sender = %d after the retry, want 1000", balance)
+	}
+	if text := h.lastMessage(sender.ID).Text(); !strings.Contains(text, "refunded 100 sat") {
+		t.Errorf("last message to sender = %q", text)
+	}
+}
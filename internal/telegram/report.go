@@ -0,0 +1,252 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+	"gorm.io/gorm"
+)
+
+var (
+	adminHelpMessage        = "📖 Operator commands for your role %s:\n"
+	adminDeniedMessage      = "🚫 Your role %s can't use /admin %s."
+	adminStatsWaitMessage   = "⏳ Computing the report..."
+	adminStatsPeriodMessage = "*%s*\n👥 Active users: %d (%d new)\n🏅 Tips: %d (%d sat)\n💸 Transactions: %d (%d sat)\n🧾 Fees collected: %d sat\n🚫 Failed: %d (%.1f%%)"
+	adminStatsGroupsMessage = "\n🏆 Top groups:"
+	adminStatsGroupMessage  = "\n%d. %s: %d sat in %d transactions"
+	adminSolvencyMessage    = "*Solvency*\n🏦 Reserve: %s\n👛 Balances of users: %d sat in %d wallets\n🧾 Operator wallet: %d sat"
+	adminReserveMessage     = "%d sat (%s)"
+)
+
+// reportTopGroups is the number of groups with the most volume in a report.
+const reportTopGroups = 5
+
+// Report is the activity of the bot in a period.
+type Report struct {
+	From, To     time.Time
+	ActiveUsers  int64 // users who sent at least one transaction
+	NewUsers     int64
+	Tips         int64
+	TipVolume    int64
+	Transactions int64
+	Volume       int64
+	Fees         int64
+	Failed       int64
+	TopGroups    []GroupVolume
+}
+
+// FailureRate is the share of failed transactions in percent.
+func (r Report) FailureRate() float64 {
+	if r.Transactions+r.Failed == 0 {
+		return 0
+	}
+	return 100 * float64(r.Failed) / float64(r.Transactions+r.Failed)
+}
+
+// GroupVolume is the sum of all transactions in a group.
+type GroupVolume struct {
+	ChatID   int64
+	ChatName string
+	Count    int64
+	Volume   int64
+}
+
+// Solvency compares the funds of the backend with the balances of all wallets.
+type Solvency struct {
+	Reserve      int64 // sat, only set if ReserveKnown
+	ReserveKnown bool
+	Liabilities  int64 // sat, the balances of all users but the operator
+	Wallets      int64
+	Operator     int64 // sat, the balance of the operator, which holds the fees
+}
+
+// Report computes the activity between from and to from the transactions.
+func (bot *TipBot) Report(from, to time.Time) (*Report, error) {
+	r := &Report{From: from, To: to}
+	period := func() *gorm.DB {
+		return bot.DB.Transactions.Model(&Transaction{}).Where("time >= ? AND time < ?", from, to)
+	}
+	var totals struct {
+		Count  int64
+		Volume int64
+	}
+	// service fees and referral bonuses are not activity of the users
+	tx := period().Select("COUNT(*) AS count, COALESCE(SUM(amount), 0) AS volume").
+		Where("success = ? AND type NOT IN ?", true, []string{serviceFeeTransactionType, referralTransactionType}).Scan(&totals)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	r.Transactions, r.Volume = totals.Count, totals.Volume
+	period().Select("COUNT(*) AS count, COALESCE(SUM(amount), 0) AS volume").
+		Where("success = ? AND type IN ?", true, []string{"tip", "tipall"}).Scan(&totals)
+	r.Tips, r.TipVolume = totals.Count, totals.Volume
+	period().Select("COUNT(*) AS count, COALESCE(SUM(amount), 0) AS volume").
+		Where("success = ? AND type = ?", true, serviceFeeTransactionType).Scan(&totals)
+	r.Fees = totals.Volume
+	period().Where("success = ?", false).Count(&r.Failed)
+	period().Where("success = ? AND type NOT IN ?", true, []string{serviceFeeTransactionType, referralTransactionType}).
+		Distinct("from_id").Count(&r.ActiveUsers)
+	bot.DB.Users.Model(&lnbits.User{}).Where("created_at >= ? AND created_at < ?", from, to).Count(&r.NewUsers)
+	period().Select("chat_id, MAX(chat_name) AS chat_name, COUNT(*) AS count, SUM(amount) AS volume").
+		Where("success = ? AND chat_id < 0", true).
+		Group("chat_id").Order("volume desc").Limit(reportTopGroups).Scan(&r.TopGroups)
+	return r, nil
+}
+
+// GroupRecap is the tipping activity in a group in a period.
+type GroupRecap struct {
+	Tips      int64
+	Volume    int64
+	Tippers   int64
+	TopTipper string // the member who tipped the most
+	TopVolume int64
+}
+
+// GroupRecap computes the tips in the group between from and to. A topic other than 0 only
+// counts the tips in that topic of a forum group.
+func (bot *TipBot) GroupRecap(chatID int64, topic int, from, to time.Time) (*GroupRecap, error) {
+	r := &GroupRecap{}
+	tips := func() *gorm.DB {
+		tx := bot.DB.Transactions.Model(&Transaction{}).
+			Where("chat_id = ? AND success = ? AND type IN ? AND time >= ? AND time < ?", chatID, true, []string{"tip", "tipall"}, from, to)
+		if topic != 0 {
+			tx = tx.Where("topic = ?", topic)
+		}
+		return tx
+	}
+	var totals struct {
+		Count  int64
+		Volume int64
+	}
+	if tx := tips().Select("COUNT(*) AS count, COALESCE(SUM(amount), 0) AS volume").Scan(&totals); tx.Error != nil {
+		return nil, tx.Error
+	}
+	r.Tips, r.Volume = totals.Count, totals.Volume
+	tips().Distinct("from_id").Count(&r.Tippers)
+	var top struct {
+		FromUser string
+		Volume   int64
+	}
+	tips().Select("MAX(from_user) AS from_user, SUM(amount) AS volume").Group("from_id").Order("volume desc").Limit(1).Scan(&top)
+	r.TopTipper, r.TopVolume = top.FromUser, top.Volume
+	return r, nil
+}
+
+// Solvency sums up the balances of all wallets and asks the backend for its reserve.
+func (bot *TipBot) Solvency() (*Solvency, error) {
+	s := &Solvency{}
+	var users []lnbits.User
+	tx := bot.DB.Users.Where("wallet_id != ''").FindInBatches(&users, 100, func(tx *gorm.DB, batch int) error {
+		for _, user := range users {
+			wallet, err := bot.Client.Balance(*user.Wallet)
+			if err != nil {
+				return err
+			}
+			if user.Telegram != nil && user.Telegram.ID == internal.Configuration.Bot.OperatorId {
+				s.Operator = wallet.Balance / 1000
+				continue
+			}
+			s.Liabilities += wallet.Balance / 1000
+			s.Wallets++
+		}
+		return nil
+	})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if reporter, ok := lnbits.Unwrap(bot.Client).(lnbits.ReserveReporter); ok {
+		reserve, err := reporter.Reserve()
+		if err != nil {
+			log.Errorf("[Solvency] could not get the reserve: %v", err)
+		} else {
+			s.Reserve, s.ReserveKnown = reserve/1000, true
+		}
+	}
+	return s, nil
+}
+
+func (r Report) String(title string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, adminStatsPeriodMessage, title, r.ActiveUsers, r.NewUsers, r.Tips, r.TipVolume,
+		r.Transactions, r.Volume, r.Fees, r.Failed, r.FailureRate())
+	if len(r.TopGroups) > 0 {
+		b.WriteString(adminStatsGroupsMessage)
+		for i, group := range r.TopGroups {
+			fmt.Fprintf(&b, adminStatsGroupMessage, i+1, str.MarkdownEscape(group.ChatName), group.Volume, group.Count)
+		}
+	}
+	return b.String()
+}
+
+func (s Solvency) String() string {
+	reserve := "unknown"
+	if s.ReserveKnown {
+		status := "✅ covered"
+		if s.Reserve < s.Liabilities+s.Operator {
+			status = fmt.Sprintf("⚠️ %d sat short", s.Liabilities+s.Operator-s.Reserve)
+		}
+		reserve = fmt.Sprintf(adminReserveMessage, s.Reserve, status)
+	}
+	return fmt.Sprintf(adminSolvencyMessage, reserve, s.Liabilities, s.Wallets, s.Operator)
+}
+
+// adminHandler is invoked on /admin <command> by the operator of the bot.
+func (bot *TipBot) adminHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	role := operatorRole(m.Sender.ID)
+	if role == RoleNone || m.Chat.Type != tb.ChatPrivate {
+		bot.Audit(auditActor(m.Sender), AuditActionAdminDenied, "", m.Text)
+		return ctx, fmt.Errorf("[adminHandler] user %s is not an operator", GetUserStr(m.Sender))
+	}
+	name, err := getArgumentFromCommand(m.Text, 1)
+	for _, command := range bot.adminCommands() {
+		if err != nil || command.name != strings.ToLower(name) {
+			continue
+		}
+		if role < command.role {
+			bot.Audit(auditActor(m.Sender), AuditActionAdminDenied, role.String(), m.Text)
+			bot.trySendMessage(m.Sender, fmt.Sprintf(adminDeniedMessage, role, command.name))
+			return ctx, fmt.Errorf("[adminHandler] %s with role %s can't use %s", GetUserStr(m.Sender), role, command.name)
+		}
+		bot.Audit(auditActor(m.Sender), AuditActionAdminCommand, role.String(), m.Text)
+		return command.handler(ctx)
+	}
+	bot.trySendMessage(m.Sender, bot.adminHelp(role))
+	return ctx, nil
+}
+
+// adminStatsHandler sends the activity of the last day and week and the solvency of the bot.
+func (bot *TipBot) adminStatsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	wait := bot.trySendMessageEditable(m.Sender, adminStatsWaitMessage)
+	now := time.Now()
+	var sections []string
+	for _, period := range []struct {
+		title    string
+		duration time.Duration
+	}{{"Last 24 hours", 24 * time.Hour}, {"Last 7 days", 7 * 24 * time.Hour}} {
+		report, err := bot.Report(now.Add(-period.duration), now)
+		if err != nil {
+			log.Errorf("[adminStatsHandler] %v", err)
+			bot.tryEditMessage(wait, fmt.Sprintf("🚫 %v", err))
+			return ctx, err
+		}
+		sections = append(sections, report.String(period.title))
+	}
+	solvency, err := bot.Solvency()
+	if err != nil {
+		log.Errorf("[adminStatsHandler] %v", err)
+		bot.tryEditMessage(wait, fmt.Sprintf("🚫 %v", err))
+		return ctx, err
+	}
+	sections = append(sections, solvency.String())
+	bot.tryEditMessage(wait, strings.Join(sections, "\n\n"))
+	return ctx, nil
+}
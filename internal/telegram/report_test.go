@@ -0,0 +1,76 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestAdminStats(t *testing.T) {
+	h := newTestHarness(t)
+	operatorUser := &tb.User{ID: 9501, Username: "operator", FirstName: "Operator", LanguageCode: "en"}
+	sender := &tb.User{ID: 9502, Username: "sender", FirstName: "Sender", LanguageCode: "en"}
+	receiver := &tb.User{ID: 9503, Username: "receiver", FirstName: "Receiver", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9500, Type: tb.ChatGroup, Title: "Tippers"}
+	h.newUser(operatorUser, 0)
+	h.newUser(sender, 1000)
+	h.newUser(receiver, 0)
+	setTestServiceFee(t, operatorUser.ID, internal.FeeConfiguration{BasisPoints: 100, Min: 1})
+
+	post := h.sendMessage(receiver, group, "gm")
+	h.sendReply(sender, group, "/tip 500", post)
+
+	h.sendMessage(sender, privateChat(sender), "/admin stats")
+	if h.called("sendMessage", sender.ID) && strings.Contains(h.lastMessage(sender.ID).Text(), "Active users") {
+		t.Error("someone else than the operator got the report")
+	}
+	h.sendMessage(operatorUser, privateChat(operatorUser), "/admin stats")
+	text := h.lastMessage(operatorUser.ID).Text()
+	for _, want := range []string{"Tips: 1 (500 sat)", "Fees collected: 5 sat", "Tippers: 500 sat", "Balances of users: 995 sat in 2 wallets", "Operator wallet: 5 sat"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("report does not contain %q: %q", want, text)
+		}
+	}
+}
+
+func TestAdminRoles(t *testing.T) {
+	h := newTestHarness(t)
+	owner := &tb.User{ID: 9511, Username: "owner", FirstName: "Owner", LanguageCode: "en"}
+	support := &tb.User{ID: 9512, Username: "support", FirstName: "Support", LanguageCode: "en"}
+	viewer := &tb.User{ID: 9513, Username: "viewer", FirstName: "Viewer", LanguageCode: "en"}
+	customer := &tb.User{ID: 9514, Username: "customer", FirstName: "Customer", LanguageCode: "en"}
+	for _, user := range []*tb.User{owner, support, viewer} {
+		h.newUser(user, 0)
+	}
+	h.newUser(customer, 1000)
+	config := internal.Configuration.Bot
+	t.Cleanup(func() { internal.Configuration.Bot = config })
+	internal.Configuration.Bot.OperatorId = owner.ID
+	internal.Configuration.Bot.Operators = map[int64]string{support.ID: "support", viewer.ID: "read-only"}
+	h.sendMessage(customer, privateChat(customer), "/send 100 @owner")
+	h.pressButton(customer, h.lastMessage(customer.ID), "✅ Send")
+
+	// support can look up transactions, but not read the audit log
+	h.sendMessage(support, privateChat(support), "/admin lookup @customer")
+	if text := h.lastMessage(support.ID).Text(); !strings.Contains(text, "@customer → @owner: 100 sat") {
+		t.Errorf("lookup = %q", text)
+	}
+	h.sendMessage(support, privateChat(support), "/admin audit")
+	if text := h.lastMessage(support.ID).Text(); !strings.Contains(text, "role support can't use /admin audit") {
+		t.Errorf("audit as support = %q", text)
+	}
+	h.sendMessage(viewer, privateChat(viewer), "/admin lookup @customer")
+	if text := h.lastMessage(viewer.ID).Text(); !strings.Contains(text, "role read-only can't") {
+		t.Errorf("lookup as read-only = %q", text)
+	}
+	h.sendMessage(viewer, privateChat(viewer), "/admin")
+	if text := h.lastMessage(viewer.ID).Text(); !strings.Contains(text, "/admin stats") || strings.Contains(text, "/admin lookup") {
+		t.Errorf("help for read-only = %q", text)
+	}
+	h.sendMessage(owner, privateChat(owner), "/admin audit")
+	if text := h.lastMessage(owner.ID).Text(); !strings.Contains(text, "admin command denied") {
+		t.Errorf("audit log = %q", text)
+	}
+}
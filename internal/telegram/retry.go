@@ -0,0 +1,209 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	paymentRetryMaxAttempts = 6
+	paymentRetryBaseDelay   = 30 * time.Second
+	paymentRetryInterval    = 10 * time.Second
+)
+
+// PaymentRetry is an outgoing payment that failed temporarily. It is kept in bunt
+// so that it survives restarts and is retried with exponential backoff.
+type PaymentRetry struct {
+	PayData     *PayData  `json:"paydata"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error"`
+}
+
+func (r PaymentRetry) Key() string {
+	return fmt.Sprintf("payment-retry:%s", r.PayData.ID)
+}
+
+// isRetryablePaymentError returns true for errors where trying again later might succeed.
+// The backend must have refused the payment or never received it, errors that leave the
+// outcome open are not retried.
+func isRetryablePaymentError(err error) bool {
+	lnbitsErr, ok := err.(lnbits.Error)
+	if !ok {
+		return isTransientTransportError(err)
+	}
+	detail := strings.ToLower(lnbitsErr.Detail)
+	for _, permanent := range []string{"insufficient", "balance", "expired", "already", "invalid", "amountless", "own invoice"} {
+		if strings.Contains(detail, permanent) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPaymentRefused returns true if the payment certainly was not sent, because the backend
+// refused it or never received it.
+func isPaymentRefused(err error) bool {
+	if _, ok := err.(lnbits.Error); ok {
+		return true
+	}
+	return isTransientTransportError(err)
+}
+
+// isTransientTransportError returns true if the request could not reach the wallet backend
+// at all. A timeout is not transient, the backend may have sent the payment.
+func isTransientTransportError(err error) bool {
+	if errors.Is(err, lnbits.ErrBackendUnavailable) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// enqueuePaymentRetry schedules a failed payment for another attempt.
+func (bot *TipBot) enqueuePaymentRetry(payData *PayData, err error) error {
+	retry := &PaymentRetry{
+		PayData:     payData,
+		Attempts:    1,
+		NextAttempt: time.Now().Add(paymentRetryBaseDelay),
+		LastError:   err.Error(),
+	}
+	log.WithFields(log.Fields{"user_id": payData.From.Telegram.ID, "payment_id": payData.ID}).Infof("[paymentRetry] queued payment %s of %s: %s", payData.ID, GetUserStr(payData.From.Telegram), err)
+	return bot.Bunt.Set(retry)
+}
+
+// startPaymentRetryWorker periodically retries all payments in the queue that are due.
+func (bot *TipBot) startPaymentRetryWorker() {
+	go func() {
+		for {
+			// queued payments wait until the wallet backend is back and
+			// stay queued for the next start during a shutdown
+			if bot.walletBackendAvailable() && !isShuttingDown() {
+				for _, retry := range bot.duePaymentRetries() {
+					bot.retryPayment(retry)
+				}
+			}
+			time.Sleep(paymentRetryInterval)
+		}
+	}()
+}
+
+func (bot *TipBot) duePaymentRetries() []*PaymentRetry {
+	retries := []*PaymentRetry{}
+	bot.Bunt.Ascend("payment-retry", func(key, value string) bool {
+		retry := &PaymentRetry{}
+		err := json.Unmarshal([]byte(value), retry)
+		if err == nil && retry.PayData != nil && time.Now().After(retry.NextAttempt) {
+			retries = append(retries, retry)
+		}
+		return true // continue iteration
+	})
+	return retries
+}
+
+func (bot *TipBot) retryPayment(retry *PaymentRetry) {
+	beginInFlight()
+	defer endInFlight()
+	if isShuttingDown() {
+		return
+	}
+	// other instances of a cluster work on the same queue
+	mutex.Lock(retry.Key())
+	defer mutex.Unlock(retry.Key())
+	if err := bot.Bunt.Get(retry); err != nil || time.Now().Before(retry.NextAttempt) {
+		return
+	}
+	payData := retry.PayData
+	user, err := GetUser(payData.From.Telegram, *bot)
+	if err != nil || user.Wallet == nil {
+		bot.finishPaymentRetry(retry, fmt.Errorf("could not load user"))
+		return
+	}
+	// the last attempt might have gone through even though it returned an error
+	payment, err := bot.Client.Payment(*user.Wallet, retry.paymentHash())
+	if _, unknown := err.(lnbits.Error); err != nil && !unknown {
+		// without the status, paying again could pay twice
+		log.WithFields(log.Fields{"user_id": user.Telegram.ID, "payment_id": payData.ID}).Warnf("[paymentRetry] could not check payment %s: %v", payData.ID, err)
+		retry.NextAttempt = time.Now().Add(paymentRetryBaseDelay)
+		bot.Bunt.Set(retry)
+		return
+	}
+	if err == nil {
+		if payment.Paid {
+			bot.finishPaymentRetry(retry, nil)
+			return
+		}
+		if payment.Details.Pending {
+			// wait for the payment to resolve without counting an attempt
+			retry.NextAttempt = time.Now().Add(paymentRetryBaseDelay)
+			bot.Bunt.Set(retry)
+			return
+		}
+	}
+	if bolt11, err := decodepay.Decodepay(payData.Invoice); err == nil && time.Unix(int64(bolt11.CreatedAt+bolt11.Expiry), 0).Before(time.Now()) {
+		bot.finishPaymentRetry(retry, fmt.Errorf("invoice expired"))
+		return
+	}
+	log.WithFields(log.Fields{"user_id": user.Telegram.ID, "payment_id": payData.ID}).Infof("[paymentRetry] attempt %d for payment %s of %s", retry.Attempts+1, payData.ID, GetUserStr(user.Telegram))
+	_, err = bot.Client.Pay(*user.Wallet, lnbits.PaymentParams{Out: true, Bolt11: payData.Invoice})
+	if err == nil {
+		bot.finishPaymentRetry(retry, nil)
+		return
+	}
+	retry.schedule(err)
+	if !isRetryablePaymentError(err) || retry.Attempts >= paymentRetryMaxAttempts {
+		bot.finishPaymentRetry(retry, err)
+		return
+	}
+	bot.Bunt.Set(retry)
+}
+
+// schedule counts a failed attempt and doubles the delay until the next one.
+func (r *PaymentRetry) schedule(err error) {
+	r.Attempts++
+	r.NextAttempt = time.Now().Add(paymentRetryBaseDelay << (r.Attempts - 1))
+	r.LastError = err.Error()
+}
+
+func (r *PaymentRetry) paymentHash() string {
+	if len(r.PayData.Hash) > 0 {
+		return r.PayData.Hash
+	}
+	bolt11, err := decodepay.Decodepay(r.PayData.Invoice)
+	if err != nil {
+		return ""
+	}
+	return bolt11.PaymentHash
+}
+
+// finishPaymentRetry removes a payment from the queue and tells the user how it ended.
+func (bot *TipBot) finishPaymentRetry(retry *PaymentRetry, err error) {
+	payData := retry.PayData
+	bot.Bunt.Delete(retry.Key(), retry)
+	if err != nil {
+		log.WithFields(log.Fields{"user_id": payData.From.Telegram.ID, "payment_id": payData.ID}).Errorf("[paymentRetry] payment %s of %s failed after %d attempts: %v", payData.ID, GetUserStr(payData.From.Telegram), retry.Attempts, err)
+		bot.trySendMessage(payData.From.Telegram, fmt.Sprintf(i18n.Translate(payData.LanguageCode, "paymentRetryFailedMessage"), retry.Attempts))
+		return
+	}
+	payData.Hash = retry.paymentHash()
+	bot.saveCounterparty(payData.Hash, payData.From.Wallet.ID, payData.CounterpartyType, payData.Counterparty)
+	payData.Proof, _ = bot.paymentPreimage(context.Background(), *payData.From.Wallet, payData.Hash)
+	bot.trySendMessage(payData.From.Telegram, paidMessage(payData))
+	bot.sendSuccessAction(payData.From.Telegram, payData.SuccessAction)
+	log.WithFields(log.Fields{"user_id": payData.From.Telegram.ID, "payment_id": payData.ID, "payment_hash": payData.Hash}).Infof("[⚡️ pay] User %s paid invoice %s (%d sat) after %d attempts", GetUserStr(payData.From.Telegram), payData.ID, payData.Amount, retry.Attempts)
+}
@@ -0,0 +1,35 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+)
+
+func TestIsRetryablePaymentError(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{lnbits.Error{Detail: "Payment failed: no route"}, true},
+		{lnbits.Error{Detail: "Insufficient balance."}, false},
+		{lnbits.ErrBackendUnavailable, true},
+		{&url.Error{Op: "Post", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, true},
+		{&url.Error{Op: "Post", Err: &net.DNSError{Err: "no such host", IsTemporary: true}}, true},
+		// the request reached the backend, the payment may have been sent
+		{&url.Error{Op: "Post", Err: io.EOF}, false},
+		{&url.Error{Op: "Post", Err: context.DeadlineExceeded}, false},
+		{&url.Error{Op: "Post", Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}, false},
+		{errors.New("wallet is paused"), false},
+	} {
+		if got := isRetryablePaymentError(test.err); got != test.want {
+			t.Errorf("isRetryablePaymentError(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
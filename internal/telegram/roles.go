@@ -0,0 +1,92 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+)
+
+// Role is what an operator of the bot may do with /admin. Every role may do everything that
+// the roles below it may do.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleReadOnly
+	RoleSupport
+	RoleAdmin
+	RoleOwner
+)
+
+var roleNames = map[Role]string{
+	RoleReadOnly: "read-only",
+	RoleSupport:  "support",
+	RoleAdmin:    "admin",
+	RoleOwner:    "owner",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return "none"
+}
+
+// ParseRole returns the role with the name, RoleNone for unknown names.
+func ParseRole(name string) Role {
+	for role, roleName := range roleNames {
+		if strings.EqualFold(name, roleName) {
+			return role
+		}
+	}
+	return RoleNone
+}
+
+// operatorRole returns the role of the Telegram user. The operator is the owner, the other
+// roles are set in the operators section of the config.
+func operatorRole(userId int64) Role {
+	if operator := internal.Configuration.Bot.OperatorId; operator != 0 && userId == operator {
+		return RoleOwner
+	}
+	return ParseRole(internal.Configuration.Bot.Operators[userId])
+}
+
+// isBotOwnedUser tells whether the wallet of the Telegram user belongs to the bot or to its
+// operator rather than to a user.
+func (bot *TipBot) isBotOwnedUser(userId int64) bool {
+	return userId == bot.Telegram.Me.ID || userId == internal.Configuration.Bot.OperatorId
+}
+
+// adminCommand is a subcommand of /admin and the role that it needs.
+type adminCommand struct {
+	name    string
+	role    Role
+	help    string
+	handler func(ctx intercept.Context) (intercept.Context, error)
+}
+
+// adminCommands are the subcommands of /admin in the order of the help.
+func (bot *TipBot) adminCommands() []adminCommand {
+	return []adminCommand{
+		{"stats", RoleReadOnly, "`/admin stats` 📊 Activity of the last day and week, fees and solvency.", bot.adminStatsHandler},
+		{"claims", RoleReadOnly, "`/admin claims` 🔒 Gifts, pools, vouchers and tips that wait to be claimed.", bot.adminClaimsHandler},
+		{"liquidity", RoleReadOnly, "`/admin liquidity` 📡 Reserve and channels of the node.", bot.adminLiquidityHandler},
+		{"lookup", RoleSupport, "`/admin lookup <@user|payment hash>` 🔎 Wallet, transactions and errors of a user or a payment.", bot.adminLookupHandler},
+		{"unpause", RoleSupport, "`/admin unpause <@user>` ▶️ Lift the pause of a wallet with suspicious activity.", bot.adminUnpauseHandler},
+		{"pay", RoleAdmin, "`/admin pay <@from> <amount> <@user|address> [memo]` 💸 Pay from the wallet of a user, large payments need a second admin.", bot.adminPayHandler},
+		{"audit", RoleAdmin, "`/admin audit [count]` 📜 The last privileged operations.", bot.adminAuditHandler},
+	}
+}
+
+// adminHelp lists the subcommands of /admin that the role may use.
+func (bot *TipBot) adminHelp(role Role) string {
+	help := fmt.Sprintf(adminHelpMessage, role)
+	for _, command := range bot.adminCommands() {
+		if role >= command.role {
+			help += "\n" + command.help
+		}
+	}
+	return help
+}
@@ -0,0 +1,196 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/eko/gocache/store"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// roundUpPayoutInterval is how long round-ups accumulate before they are donated
+	roundUpPayoutInterval = 7 * 24 * time.Hour
+	roundUpCheckInterval  = time.Hour
+	// roundUpRetryInterval is how long a failed payout waits before it is tried again
+	roundUpRetryInterval = 24 * time.Hour
+)
+
+// RoundUp is the difference between an outgoing payment and the next multiple of the round-up
+// step of the user. Round-ups are donated to the address of the user every week.
+type RoundUp struct {
+	ID          uint      `gorm:"primarykey"`
+	Time        time.Time `json:"time"`
+	UserId      int64     `json:"user_id" gorm:"index"`
+	PaymentHash string    `json:"payment_hash"` // the rounded up payment
+	Amount      int64     `json:"amount"`
+	DonationId  uint      `json:"donation_id" gorm:"index"` // zero until the donation was recorded
+	PaidOut     bool      `json:"paid_out" gorm:"index"`    // the round-up was donated
+}
+
+// roundUpAmount returns the difference between amount and the next multiple of step.
+func roundUpAmount(amount, step int64) int64 {
+	if step <= 1 {
+		return 0
+	}
+	return (step - amount%step) % step
+}
+
+// roundUpPayment is called after every outgoing payment and records the round-up of users who
+// turned it on. Only the payments that users made themselves are rounded up, not the fees,
+// deposits and payouts of the bot, round-up donations included.
+func (bot *TipBot) roundUpPayment(payment lnbits.OutgoingPayment) {
+	if !payment.UserInitiated {
+		return
+	}
+	user := &lnbits.User{}
+	tx := bot.DB.Users.Preload("Settings").Where("wallet_id = ?", payment.WalletID).First(user)
+	if tx.Error != nil || user.Telegram == nil || user.Settings == nil || user.Settings.RoundUp.Step == 0 {
+		return
+	}
+	bolt11, err := decodepay.Decodepay(payment.Bolt11)
+	if err != nil {
+		return
+	}
+	amount := roundUpAmount(bolt11.MSatoshi/1000, user.Settings.RoundUp.Step)
+	if amount == 0 {
+		return
+	}
+	roundUp := &RoundUp{Time: time.Now(), UserId: user.Telegram.ID, PaymentHash: payment.PaymentHash, Amount: amount}
+	if tx := bot.DB.Transactions.Create(roundUp); tx.Error != nil {
+		log.Errorf("[roundUp] could not record the round-up of %s: %v", GetUserStr(user.Telegram), tx.Error)
+	}
+}
+
+// pendingRoundUps returns the sum and the number of the round-ups of the user that were not
+// donated yet.
+func (bot *TipBot) pendingRoundUps(userId int64) (sum int64, count int64) {
+	bot.DB.Transactions.Model(&RoundUp{}).Where("user_id = ? AND paid_out = ?", userId, false).Count(&count)
+	bot.DB.Transactions.Model(&RoundUp{}).Where("user_id = ? AND paid_out = ?", userId, false).Select("COALESCE(SUM(amount), 0)").Scan(&sum)
+	return sum, count
+}
+
+func roundUpMessage(ctx intercept.Context, settings lnbits.RoundUpSettings, pending int64) string {
+	if settings.Step == 0 {
+		return Translate(ctx, "roundUpOffMessage")
+	}
+	return fmt.Sprintf(Translate(ctx, "roundUpOnMessage"), settings.Step, str.MarkdownEscape(settings.Address), pending)
+}
+
+// roundUpHandler is invoked on /roundup <step> <lightning address> and /roundup off.
+func (bot *TipBot) roundUpHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	splits := strings.Fields(m.Text)
+	if len(splits) < 2 {
+		pending, _ := bot.pendingRoundUps(user.Telegram.ID)
+		bot.trySendMessage(m.Sender, roundUpMessage(ctx, user.Settings.RoundUp, pending)+"\n\n"+Translate(ctx, "roundUpHelpMessage"))
+		return ctx, nil
+	}
+	if strings.ToLower(splits[1]) == "off" {
+		// round-ups that were not donated yet are still paid out
+		user.Settings.RoundUp.Step = 0
+	} else {
+		step, err := GetAmount(splits[1])
+		if err != nil || step < 2 || len(splits) != 3 || !isLightningAddress(splits[2]) {
+			bot.trySendMessage(m.Sender, Translate(ctx, "roundUpHelpMessage"))
+			return ctx, fmt.Errorf("invalid round-up command")
+		}
+		user.Settings.RoundUp = lnbits.RoundUpSettings{Step: step, Address: strings.ToLower(splits[2])}
+	}
+	if err := UpdateUserRecord(user, *bot); err != nil {
+		log.Errorf("[/roundup] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	pending, _ := bot.pendingRoundUps(user.Telegram.ID)
+	bot.trySendMessage(m.Sender, roundUpMessage(ctx, user.Settings.RoundUp, pending))
+	return ctx, nil
+}
+
+// startRoundUpWorker donates the round-ups of users once a week.
+func (bot *TipBot) startRoundUpWorker() {
+	go func() {
+		for {
+			if bot.walletBackendAvailable() && !isShuttingDown() {
+				for _, userId := range bot.dueRoundUps(time.Now()) {
+					bot.payOutRoundUps(userId)
+				}
+			}
+			time.Sleep(roundUpCheckInterval)
+		}
+	}()
+}
+
+// dueRoundUps returns the users whose oldest round-up that was not donated is a week old.
+func (bot *TipBot) dueRoundUps(now time.Time) []int64 {
+	userIds := make([]int64, 0)
+	bot.DB.Transactions.Model(&RoundUp{}).Where("paid_out = ? AND time <= ?", false, now.Add(-roundUpPayoutInterval)).
+		Distinct("user_id").Pluck("user_id", &userIds)
+	return userIds
+}
+
+func roundUpRetryCacheKey(userId int64) string {
+	return fmt.Sprintf("roundup_retry_%d", userId)
+}
+
+// payOutRoundUps donates the round-ups of the user that were not donated yet and sends a summary.
+func (bot *TipBot) payOutRoundUps(userId int64) {
+	beginInFlight()
+	defer endInFlight()
+	// other instances of a cluster pay out the same round-ups
+	key := fmt.Sprintf("roundup:%d", userId)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	if _, err := bot.Cache.Get(roundUpRetryCacheKey(userId)); err == nil {
+		return
+	}
+	user, err := GetLnbitsUserWithSettings(&tb.User{ID: userId}, *bot)
+	if err != nil || user.Wallet == nil {
+		return
+	}
+	roundUps := make([]RoundUp, 0)
+	bot.DB.Transactions.Where("user_id = ? AND paid_out = ?", userId, false).Find(&roundUps)
+	var sum int64
+	for _, roundUp := range roundUps {
+		sum += roundUp.Amount
+	}
+	address := user.Settings.RoundUp.Address
+	if sum == 0 || len(address) == 0 {
+		return
+	}
+	language := user.Telegram.LanguageCode
+	paymentHash, err := bot.payLightningAddress(user, address, sum, bot.donationComment(user.Telegram, false))
+	if err != nil {
+		log.Warnf("[roundUp] could not donate %d sat of %s: %v", sum, GetUserStr(user.Telegram), err)
+		bot.Cache.Set(roundUpRetryCacheKey(userId), true, &store.Options{Expiration: roundUpRetryInterval})
+		bot.trySendMessage(user.Telegram, fmt.Sprintf(i18n.Translate(language, "roundUpFailedMessage"), sum, str.MarkdownEscape(address)))
+		return
+	}
+	// the round-ups are paid out even if the donation can't be recorded
+	ids := make([]uint, 0, len(roundUps))
+	for _, roundUp := range roundUps {
+		ids = append(ids, roundUp.ID)
+	}
+	if tx := bot.DB.Transactions.Model(&RoundUp{}).Where("id IN ?", ids).Update("paid_out", true); tx.Error != nil {
+		log.Errorf("[roundUp] could not mark the round-ups of %s as paid out: %v", GetUserStr(user.Telegram), tx.Error)
+	}
+	donation := bot.recordDonation(user, address, sum, paymentHash, true)
+	bot.collectDonationFee(user, sum, paymentHash, donation)
+	if donation != nil {
+		bot.DB.Transactions.Model(&RoundUp{}).Where("id IN ?", ids).Update("donation_id", donation.ID)
+		go bot.matchDonation(donation)
+	}
+	log.Infof("[❤️ roundUp] %s donated %d sat of %d round-ups to %s", GetUserStr(user.Telegram), sum, len(roundUps), address)
+	bot.trySendMessage(user.Telegram, fmt.Sprintf(i18n.Translate(language, "roundUpSummaryMessage"), len(roundUps), sum, str.MarkdownEscape(address)))
+}
@@ -0,0 +1,57 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestRoundUp(t *testing.T) {
+	h := newTestHarness(t)
+	payments := lnbits.NewPaymentObserver(h.bot.Client)
+	payments.OnPayment(h.bot.roundUpPayment)
+	h.bot.Client = payments
+	alice := &tb.User{ID: 9981, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9982, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	from := h.newUser(alice, 1000)
+	to := h.newUser(bob, 0)
+
+	h.sendMessage(alice, privateChat(alice), "/roundup 100 charity@example.com")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "next 100 sat") || !strings.Contains(text, "charity@example.com") {
+		t.Fatalf("message = %q", text)
+	}
+	send := func(text string) {
+		h.sendMessage(alice, privateChat(alice), text)
+		h.pressButton(alice, h.lastMessage(alice.ID), "✅ Send")
+	}
+	send("/send 130 @bob")
+	send("/send 200 @bob")
+	send("/send 21 @bob")
+	if sum, count := h.bot.pendingRoundUps(alice.ID); sum != 149 || count != 2 {
+		t.Fatalf("pending round-ups = %d sat in %d, want 149 sat in 2", sum, count)
+	}
+	if users := h.bot.dueRoundUps(time.Now()); len(users) != 0 {
+		t.Errorf("due round-ups = %v before a week passed", users)
+	}
+	if users := h.bot.dueRoundUps(time.Now().Add(roundUpPayoutInterval)); len(users) != 1 || users[0] != alice.ID {
+		t.Errorf("due round-ups = %v after a week, want [%d]", users, alice.ID)
+	}
+
+	// transfers that the bot makes from the wallet of alice are not rounded up
+	if success, err := NewTransaction(h.bot, from, to, 30, TransactionType("escrow")).Send(); !success {
+		t.Fatalf("transfer: %v", err)
+	}
+	if sum, _ := h.bot.pendingRoundUps(alice.ID); sum != 149 {
+		t.Errorf("pending round-ups = %d sat after a transfer of the bot, want 149", sum)
+	}
+
+	// the change that was collected is still donated after round-ups are turned off
+	h.sendMessage(alice, privateChat(alice), "/roundup off")
+	send("/send 1 @bob")
+	if sum, _ := h.bot.pendingRoundUps(alice.ID); sum != 149 {
+		t.Errorf("pending round-ups = %d sat after turning them off, want 149", sum)
+	}
+}
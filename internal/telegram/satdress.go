@@ -509,7 +509,7 @@ func (bot *TipBot) satdressProxyRelayPaymentHandler(event Event) {
 	log.Infof("[node:proxy] Retrieved invoice for payment of user %s backend %s. Paying...", GetUserStr(user.Telegram), user.Settings.Node.NodeType)
 
 	// pay invoice
-	invoice, err := user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: getInvoiceParams.PR}, bot.Client)
+	invoice, err := bot.Client.Pay(*user.Wallet, lnbits.PaymentParams{Out: true, Bolt11: getInvoiceParams.PR})
 	if err != nil {
 		errmsg := fmt.Sprintf("[/pay] Could not pay invoice of %s: %s", GetUserStr(user.Telegram), err)
 		// err = fmt.Errorf(i18n.Translate(payData.LanguageCode, "invoiceUndefinedErrorMessage"))
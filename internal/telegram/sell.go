@@ -0,0 +1,362 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// productUnlimitedStock is the stock of products that never sell out.
+	productUnlimitedStock = -1
+	salesHistoryLength    = 10
+	productTitleMaxLength = 100
+)
+
+var (
+	sellAddRegex  = regexp.MustCompile(`^/\S+\s+add\s+["“”]([^"“”]+)["“”]\s+(\S+)(?:\s+(\d+))?\s*$`)
+	productMenu   = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnBuyProduct = productMenu.Data("🛒 Buy", "buy_product")
+)
+
+// Product is an item of the catalog of a seller that buyers pay with an invoice.
+type Product struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	SellerId  int64     `json:"seller_id" gorm:"index"`
+	Title     string    `json:"title"`
+	Price     int64     `json:"price"`
+	Stock     int64     `json:"stock"` // productUnlimitedStock if the product never sells out
+	Sold      int64     `json:"sold"`
+	Active    bool      `json:"active"`
+}
+
+// ProductSale is a paid purchase of a product.
+type ProductSale struct {
+	ID          uint      `gorm:"primarykey"`
+	Time        time.Time `json:"time"`
+	ProductId   uint      `json:"product_id" gorm:"index"`
+	SellerId    int64     `json:"seller_id" gorm:"index"`
+	BuyerId     int64     `json:"buyer_id"`
+	Buyer       string    `json:"buyer"`
+	Title       string    `json:"title"`
+	Amount      int64     `json:"amount"`
+	PaymentHash string    `json:"payment_hash"`
+}
+
+func (product *Product) soldOut() bool {
+	return product.Stock == 0
+}
+
+func (product *Product) stockStr() string {
+	if product.Stock == productUnlimitedStock {
+		return "∞"
+	}
+	return strconv.FormatInt(product.Stock, 10)
+}
+
+func helpSellUsage(ctx intercept.Context, errormsg string) string {
+	return fmt.Sprintf(Translate(ctx, "sellHelpText"), errormsg)
+}
+
+// loadProduct loads a product by the ID in a command or button.
+func (bot *TipBot) loadProduct(id string) (*Product, error) {
+	productId, err := strconv.ParseUint(strings.TrimPrefix(id, "#"), 10, 64)
+	if err != nil {
+		return nil, errors.Create(errors.InvalidSyntaxError)
+	}
+	product := &Product{}
+	if tx := bot.DB.Transactions.First(product, productId); tx.Error != nil {
+		return nil, tx.Error
+	}
+	return product, nil
+}
+
+// loadOwnProduct loads a product of the sender of a command.
+func (bot *TipBot) loadOwnProduct(ctx intercept.Context, id string) (*Product, error) {
+	product, err := bot.loadProduct(id)
+	if err != nil || !product.Active || product.SellerId != ctx.Sender().ID {
+		bot.trySendMessage(ctx.Sender(), helpSellUsage(ctx, Translate(ctx, "sellUnknownProductMessage")))
+		return nil, errors.Create(errors.InvalidSyntaxError)
+	}
+	return product, nil
+}
+
+// sellHandler is invoked on /sell. Sellers manage their catalog in the private chat with
+// /sell add, /sell stock, /sell remove and /sell sales. /sell <id> shows a product in any
+// chat with a button to buy it.
+func (bot *TipBot) sellHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	arg, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		arg = "list"
+	}
+	if _, err := strconv.ParseUint(strings.TrimPrefix(arg, "#"), 10, 64); err == nil {
+		return bot.showProductHandler(ctx, arg)
+	}
+	if m.Chat.Type != tb.ChatPrivate {
+		bot.tryDeleteMessage(m)
+		bot.trySendMessage(m.Sender, helpSellUsage(ctx, Translate(ctx, "sellPrivateMessage")))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	switch strings.ToLower(arg) {
+	case "add":
+		return bot.addProductHandler(ctx)
+	case "list":
+		return bot.listProductsHandler(ctx)
+	case "stock":
+		return bot.productStockHandler(ctx)
+	case "remove":
+		return bot.removeProductHandler(ctx)
+	case "sales":
+		return bot.salesHandler(ctx)
+	}
+	bot.trySendMessage(m.Sender, helpSellUsage(ctx, ""))
+	return ctx, errors.Create(errors.InvalidSyntaxError)
+}
+
+// addProductHandler is invoked on /sell add "<title>" <price> [<stock>].
+func (bot *TipBot) addProductHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	matches := sellAddRegex.FindStringSubmatch(m.Text)
+	if matches == nil || len(strings.TrimSpace(matches[1])) == 0 {
+		bot.trySendMessage(m.Sender, helpSellUsage(ctx, ""))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	price, err := GetAmount(matches[2])
+	if err != nil || price < 1 {
+		bot.trySendMessage(m.Sender, helpSellUsage(ctx, Translate(ctx, "sellInvalidPriceMessage")))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	stock := int64(productUnlimitedStock)
+	if len(matches[3]) > 0 {
+		stock, _ = strconv.ParseInt(matches[3], 10, 64)
+	}
+	title := strings.TrimSpace(matches[1])
+	if runes := []rune(title); len(runes) > productTitleMaxLength {
+		title = string(runes[:productTitleMaxLength]) + "..."
+	}
+	product := &Product{
+		CreatedAt: time.Now(),
+		SellerId:  m.Sender.ID,
+		Title:     title,
+		Price:     price,
+		Stock:     stock,
+		Active:    true,
+	}
+	if tx := bot.DB.Transactions.Create(product); tx.Error != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, tx.Error
+	}
+	logger(ctx).Infof("[/sell] %s added product %d %s for %d sat", GetUserStr(m.Sender), product.ID, title, price)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "sellProductAddedMessage"), str.MarkdownEscape(title), price, product.stockStr(), product.ID, product.ID))
+	return ctx, nil
+}
+
+// listProductsHandler lists the active products of the seller.
+func (bot *TipBot) listProductsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	products := make([]Product, 0)
+	bot.DB.Transactions.Where("seller_id = ? AND active = ?", m.Sender.ID, true).Order("id").Find(&products)
+	if len(products) == 0 {
+		bot.trySendMessage(m.Sender, helpSellUsage(ctx, Translate(ctx, "sellNoProductsMessage")))
+		return ctx, nil
+	}
+	list := ""
+	for _, product := range products {
+		list += fmt.Sprintf(Translate(ctx, "sellProductListEntry"), product.ID, str.MarkdownEscape(product.Title), product.Price, product.stockStr(), product.Sold)
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "sellProductListMessage"), list))
+	return ctx, nil
+}
+
+// productStockHandler is invoked on /sell stock <id> <stock>.
+func (bot *TipBot) productStockHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	id, _ := getArgumentFromCommand(m.Text, 2)
+	product, err := bot.loadOwnProduct(ctx, id)
+	if err != nil {
+		return ctx, err
+	}
+	arg, _ := getArgumentFromCommand(m.Text, 3)
+	stock, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || stock < 0 {
+		bot.trySendMessage(m.Sender, helpSellUsage(ctx, Translate(ctx, "sellInvalidStockMessage")))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if tx := bot.DB.Transactions.Model(product).Update("stock", stock); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "sellStockUpdatedMessage"), str.MarkdownEscape(product.Title), stock))
+	return ctx, nil
+}
+
+// removeProductHandler is invoked on /sell remove <id>. Sales of the product remain in the history.
+func (bot *TipBot) removeProductHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	id, _ := getArgumentFromCommand(m.Text, 2)
+	product, err := bot.loadOwnProduct(ctx, id)
+	if err != nil {
+		return ctx, err
+	}
+	if tx := bot.DB.Transactions.Model(product).Update("active", false); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "sellProductRemovedMessage"), str.MarkdownEscape(product.Title)))
+	return ctx, nil
+}
+
+// salesHandler is invoked on /sell sales and lists the latest sales of the seller.
+func (bot *TipBot) salesHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	sales := make([]ProductSale, 0)
+	bot.DB.Transactions.Where("seller_id = ?", m.Sender.ID).Order("id desc").Limit(salesHistoryLength).Find(&sales)
+	if len(sales) == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "sellNoSalesMessage"))
+		return ctx, nil
+	}
+	var total int64
+	bot.DB.Transactions.Model(&ProductSale{}).Where("seller_id = ?", m.Sender.ID).Select("coalesce(sum(amount), 0)").Scan(&total)
+	history := ""
+	for _, sale := range sales {
+		history += fmt.Sprintf("%s: %s, %d sat, %s\n", sale.Time.UTC().Format("2 Jan 06"), str.MarkdownEscape(sale.Title), sale.Amount, str.MarkdownEscape(sale.Buyer))
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "sellSalesMessage"), history, total))
+	return ctx, nil
+}
+
+// showProductHandler is invoked on /sell <id> and shows the product with a button to buy it.
+func (bot *TipBot) showProductHandler(ctx intercept.Context, id string) (intercept.Context, error) {
+	m := ctx.Message()
+	product, err := bot.loadProduct(id)
+	if err != nil || !product.Active {
+		bot.trySendMessage(m.Sender, helpSellUsage(ctx, Translate(ctx, "sellUnknownProductMessage")))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	seller, err := GetLnbitsUserWithSettings(&tb.User{ID: product.SellerId}, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	buyButton := menu.Data(Translate(ctx, "sellBuyButtonMessage"), "buy_product", strconv.FormatUint(uint64(product.ID), 10))
+	menu.Inline(menu.Row(buyButton))
+	bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "sellProductMessage"), str.MarkdownEscape(product.Title), product.Price, GetUserStrMd(seller.Telegram)), menu)
+	return ctx, nil
+}
+
+// buyProductHandler sends the buyer of a product an invoice of its seller in the private chat.
+func (bot *TipBot) buyProductHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	product, err := bot.loadProduct(ctx.Data())
+	if err != nil || !product.Active {
+		bot.trySendMessage(c.Sender, Translate(ctx, "sellUnknownProductMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if product.soldOut() {
+		bot.trySendMessage(c.Sender, fmt.Sprintf(Translate(ctx, "sellSoldOutMessage"), str.MarkdownEscape(product.Title)))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	seller, err := GetLnbitsUserWithSettings(&tb.User{ID: product.SellerId}, *bot)
+	if err != nil || seller.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	buyer := LoadUser(ctx)
+	token := newPaymentLinkToken()
+	memo := fmt.Sprintf("%s from %s", product.Title, GetUserStr(seller.Telegram))
+	invoice, err := bot.createInvoiceWithEvent(ctx, seller, product.Price, memo, "", InvoiceCallbackProductSale, fmt.Sprintf("%d|%s", product.ID, token))
+	if err != nil {
+		bot.trySendMessage(c.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	qr, err := qrcode.Encode(invoice.PaymentRequest, qrcode.Medium, 256)
+	if err != nil {
+		bot.trySendMessage(c.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	link := bot.createPaymentLink(token, PaymentLinkTypeInvoice, seller, PaymentLinkPaymentRequest(invoice.PaymentRequest))
+	caption := fmt.Sprintf(Translate(ctx, "sellInvoiceMessage"), str.MarkdownEscape(product.Title), product.Price, invoice.PaymentRequest, link.DeepLink(bot))
+	invoice.Payer = buyer
+	invoice.InvoiceMessage = bot.trySendMessage(c.Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: caption})
+	runtime.IgnoreError(bot.Bunt.Set(invoice))
+	logger(ctx).WithField("payment_hash", invoice.PaymentHash).Infof("[/sell] %s wants to buy product %d of %s", GetUserStr(c.Sender), product.ID, GetUserStr(seller.Telegram))
+	return ctx, nil
+}
+
+// productSaleEvent is invoked when the invoice of a product is paid. It counts the sale, stores it
+// in the sales history and sends the seller the contact of the buyer.
+func (bot *TipBot) productSaleEvent(event Event) {
+	invoiceEvent := event.(*InvoiceEvent)
+	data := strings.SplitN(invoiceEvent.CallbackData, "|", 2)
+	if len(data) == 2 {
+		bot.inactivatePaymentLink(data[1])
+	}
+	id := fmt.Sprintf("product:%s", data[0])
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	product, err := bot.loadProduct(data[0])
+	if err != nil {
+		log.Errorf("[productSaleEvent] %s: %v", id, err)
+		return
+	}
+	oversold := product.soldOut()
+	if product.Stock > 0 {
+		product.Stock--
+	}
+	product.Sold++
+	if tx := bot.DB.Transactions.Model(product).Updates(map[string]interface{}{"stock": product.Stock, "sold": product.Sold}); tx.Error != nil {
+		log.Errorf("[productSaleEvent] could not update product %d: %v", product.ID, tx.Error)
+	}
+
+	seller := invoiceEvent.User
+	buyerStr, buyerStrMd := i18n.Translate(seller.Telegram.LanguageCode, "sellUnknownBuyer"), ""
+	sale := &ProductSale{
+		Time:        time.Now(),
+		ProductId:   product.ID,
+		SellerId:    product.SellerId,
+		Title:       product.Title,
+		Amount:      invoiceEvent.Amount,
+		PaymentHash: invoiceEvent.PaymentHash,
+	}
+	if invoiceEvent.Payer != nil && invoiceEvent.Payer.Telegram != nil {
+		sale.BuyerId = invoiceEvent.Payer.Telegram.ID
+		buyerStr, buyerStrMd = GetUserStr(invoiceEvent.Payer.Telegram), GetUserStrMd(invoiceEvent.Payer.Telegram)
+	}
+	sale.Buyer = buyerStr
+	if tx := bot.DB.Transactions.Create(sale); tx.Error != nil {
+		log.Errorf("[productSaleEvent] could not store sale of product %d: %v", product.ID, tx.Error)
+	}
+	// label the payment in /transactions
+	bot.saveCounterparty(invoiceEvent.PaymentHash, seller.Wallet.ID, CounterpartyTypeTelegram, buyerStr)
+	bot.savePaymentComment(invoiceEvent.PaymentHash, seller.Wallet.ID, fmt.Sprintf("🛒 Sale: %s", product.Title))
+	log.Infof("[🛒 sell] %s sold %s to %s for %d sat", GetUserStr(seller.Telegram), product.Title, buyerStr, invoiceEvent.Amount)
+
+	if invoiceEvent.InvoiceMessage != nil {
+		if _, err := bot.Telegram.EditCaption(invoiceEvent.InvoiceMessage, i18n.Translate(invoiceEvent.LanguageCode, "invoicePaidText")); err != nil {
+			log.Warnln(err.Error())
+		}
+	}
+	if len(buyerStrMd) == 0 {
+		buyerStrMd = str.MarkdownEscape(buyerStr)
+	}
+	message := fmt.Sprintf(i18n.Translate(seller.Telegram.LanguageCode, "sellSoldMessage"), str.MarkdownEscape(product.Title), invoiceEvent.Amount, buyerStrMd, product.stockStr())
+	if oversold {
+		message += i18n.Translate(seller.Telegram.LanguageCode, "sellOversoldMessage")
+	}
+	bot.trySendMessage(seller.Telegram, message)
+	if sale.BuyerId != 0 {
+		bot.trySendMessage(invoiceEvent.Payer.Telegram, fmt.Sprintf(i18n.Translate(invoiceEvent.LanguageCode, "sellBoughtMessage"), str.MarkdownEscape(product.Title), GetUserStrMd(seller.Telegram)))
+	}
+}
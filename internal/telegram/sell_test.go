@@ -0,0 +1,67 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestSellProduct(t *testing.T) {
+	h := newTestHarness(t)
+	seller := &tb.User{ID: 8901, Username: "seller", FirstName: "Seller", LanguageCode: "en"}
+	buyer := &tb.User{ID: 8902, Username: "buyer", FirstName: "Buyer", LanguageCode: "en"}
+	group := &tb.Chat{ID: -8900, Type: tb.ChatGroup, Title: "group"}
+	to := h.newUser(seller, 0)
+	from := h.newUser(buyer, 50000)
+
+	h.sendMessage(seller, privateChat(seller), `/sell add "Sticker pack" 21000 1`)
+	if text := h.lastMessage(seller.ID).Text(); !strings.Contains(text, "Sticker pack") {
+		t.Fatalf("message after add = %q", text)
+	}
+	product := &Product{}
+	if tx := h.bot.DB.Transactions.Where("seller_id = ?", seller.ID).First(product); tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+
+	h.sendMessage(seller, group, fmt.Sprintf("/sell %d", product.ID))
+	h.pressButton(buyer, h.lastMessage(group.ID), "🛒 Buy")
+	invoice := h.lastMessage(buyer.ID)
+	parts := strings.Split(invoice.Text(), "`")
+	if len(parts) < 3 {
+		t.Fatalf("invoice message = %q", invoice.Text())
+	}
+	paid, err := h.bot.Client.Pay(*from.Wallet, lnbits.PaymentParams{Out: true, Bolt11: parts[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &InvoiceEvent{Invoice: &Invoice{PaymentHash: paid.PaymentHash}}
+	if err := h.bot.Bunt.Get(event); err != nil {
+		t.Fatal(err)
+	}
+	h.bot.productSaleEvent(event)
+	h.sent = append(h.sent, h.telegram.Requests()...)
+
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 21000 {
+		t.Errorf("balance of the seller = %d, want 21000", balance)
+	}
+	if text := h.lastMessage(seller.ID).Text(); !strings.Contains(text, "Sold:") || !strings.Contains(text, "@buyer") {
+		t.Errorf("message to the seller = %q", text)
+	}
+	h.bot.DB.Transactions.First(product, product.ID)
+	if product.Stock != 0 || product.Sold != 1 {
+		t.Errorf("product after the sale = %+v", product)
+	}
+
+	// the product is sold out
+	h.pressButton(buyer, h.lastMessage(group.ID), "🛒 Buy")
+	if text := h.lastMessage(buyer.ID).Text(); !strings.Contains(text, "sold out") {
+		t.Errorf("message after buying a sold out product = %q", text)
+	}
+	h.sendMessage(seller, privateChat(seller), "/sell sales")
+	if text := h.lastMessage(seller.ID).Text(); !strings.Contains(text, "Total: 21000 sat") {
+		t.Errorf("sales = %q", text)
+	}
+}
@@ -20,7 +20,6 @@ import (
 
 	"github.com/LightningTipBot/LightningTipBot/internal/str"
 	"github.com/LightningTipBot/LightningTipBot/pkg/lightning"
-	log "github.com/sirupsen/logrus"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
 
@@ -55,6 +54,7 @@ type SendData struct {
 	Message        string       `json:"message"`
 	Amount         int64        `json:"amount"`
 	LanguageCode   string       `json:"languagecode"`
+	Escrow         bool         `json:"escrow"` // the receiver has no wallet yet
 }
 
 // sendHandler invoked on "/send 123 @user" command
@@ -76,6 +76,9 @@ func (bot *TipBot) sendHandler(ctx intercept.Context) (intercept.Context, error)
 
 	}
 
+	// /send 1000 bestie sends to a contact of the address book
+	bot.replaceContact(user, ctx.Message())
+
 	if ok, errstr := bot.SendCheckSyntax(ctx, ctx.Message()); !ok {
 		bot.trySendMessage(ctx.Message().Sender, helpSendUsage(ctx, errstr))
 		NewMessage(ctx.Message(), WithDuration(0, bot))
@@ -109,7 +112,7 @@ func (bot *TipBot) sendHandler(ctx intercept.Context) (intercept.Context, error)
 			// lightning address, send to that address
 			ctx, err = bot.sendToLightningAddress(ctx, arg, amount)
 			if err != nil {
-				log.Errorln(err.Error())
+				logger(ctx).Errorln(err.Error())
 				return ctx, err
 			}
 			return ctx, err
@@ -133,7 +136,7 @@ func (bot *TipBot) sendHandler(ctx intercept.Context) (intercept.Context, error)
 	// ASSUME INTERNAL SEND TO TELEGRAM USER
 	if err != nil || amount < 1 {
 		errmsg := fmt.Sprintf("[/send] Error: Send amount not valid.")
-		log.Warnln(errmsg)
+		logger(ctx).Warnln(errmsg)
 		// immediately delete if the amount is bullshit
 		NewMessage(ctx.Message(), WithDuration(0, bot))
 		bot.trySendMessage(ctx.Sender(), helpSendUsage(ctx, Translate(ctx, "sendValidAmountMessage")))
@@ -149,12 +152,12 @@ func (bot *TipBot) sendHandler(ctx intercept.Context) (intercept.Context, error)
 
 	// check for user in command, accepts user mention or plain username without @
 	if len(ctx.Message().Entities) > 1 && ctx.Message().Entities[1].Type == "mention" {
-		toUserStrMention = ctx.Message().Text[ctx.Message().Entities[1].Offset : ctx.Message().Entities[1].Offset+ctx.Message().Entities[1].Length]
+		toUserStrMention = entityText(ctx.Message().Text, ctx.Message().Entities[1])
 		toUserStrWithoutAt = strings.TrimPrefix(toUserStrMention, "@")
 	} else {
 		toUserStrWithoutAt, err = getArgumentFromCommand(ctx.Message().Text, 2)
 		if err != nil {
-			log.Errorln(err.Error())
+			logger(ctx).Errorln(err.Error())
 			return ctx, err
 		}
 		toUserStrWithoutAt = strings.TrimPrefix(toUserStrWithoutAt, "@")
@@ -166,7 +169,13 @@ func (bot *TipBot) sendHandler(ctx intercept.Context) (intercept.Context, error)
 		return ctx, errors.Create(errors.InvalidSyntaxError)
 	}
 
-	toUserDb, err := GetUserByTelegramUsername(toUserStrWithoutAt, *bot)
+	toUserDb, usernameChange, err := findUserByUsername(toUserStrWithoutAt, *bot)
+	escrow := false
+	if err != nil && canEscrow(toUserStrWithoutAt) {
+		// the receiver gets the amount when they start the bot
+		escrow, err = true, nil
+		toUserDb = &lnbits.User{Telegram: &tb.User{Username: toUserStrWithoutAt}}
+	}
 	if err != nil {
 		NewMessage(ctx.Message(), WithDuration(0, bot))
 		// cut username if it's too long
@@ -182,11 +191,30 @@ func (bot *TipBot) sendHandler(ctx intercept.Context) (intercept.Context, error)
 		return ctx, errors.Create(errors.SelfPaymentError)
 	}
 
+	usernameNotice := ""
+	if usernameChange != nil {
+		if usernameChange.UserID == toUserDb.ID {
+			// the recipient renamed, pay to the current username
+			renamed := "@" + toUserDb.Telegram.Username
+			usernameNotice = fmt.Sprintf(Translate(ctx, "sendUsernameRenamedMessage"), str.MarkdownEscape(toUserStrMention), str.MarkdownEscape(renamed))
+			toUserStrMention = renamed
+			toUserStrWithoutAt = toUserDb.Telegram.Username
+		} else {
+			usernameNotice = fmt.Sprintf(Translate(ctx, "sendUsernameChangedHandsMessage"), str.MarkdownEscape(toUserStrMention))
+		}
+	}
+
 	// entire text of the inline object
-	confirmText := fmt.Sprintf(Translate(ctx, "confirmSendMessage"), str.MarkdownEscape(toUserStrMention), amount)
+	confirmText := fmt.Sprintf(Translate(ctx, "confirmSendMessage"), str.MarkdownEscape(toUserStrMention), amount) + usernameNotice
+	if ctx.Message().Private() {
+		confirmText = confirmText + bot.fiatAmount(user, amount) + feeStr(ctx.Value("publicLanguageCode").(string), serviceFee(user, "send", amount))
+	}
 	if len(sendMemo) > 0 {
 		confirmText = confirmText + fmt.Sprintf(Translate(ctx, "confirmSendAppendMemo"), str.MarkdownEscape(sendMemo))
 	}
+	if escrow {
+		confirmText = confirmText + fmt.Sprintf(Translate(ctx, "confirmSendEscrowMessage"), str.MarkdownEscape(toUserStrMention), int64(escrowDuration().Hours()/24))
+	}
 	// object that holds all information about the send payment
 	id := fmt.Sprintf("send-%d-%d-%s", ctx.Message().Sender.ID, amount, RandStringRunes(5))
 	sendData := &SendData{
@@ -198,6 +226,7 @@ func (bot *TipBot) sendHandler(ctx intercept.Context) (intercept.Context, error)
 		Memo:           sendMemo,
 		Message:        confirmText,
 		LanguageCode:   ctx.Value("publicLanguageCode").(string),
+		Escrow:         escrow,
 	}
 	// save persistent struct
 	runtime.IgnoreError(sendData.Set(sendData, bot.Bunt))
@@ -205,7 +234,7 @@ func (bot *TipBot) sendHandler(ctx intercept.Context) (intercept.Context, error)
 	sendDataJson, err := json.Marshal(sendData)
 	if err != nil {
 		NewMessage(ctx.Message(), WithDuration(0, bot))
-		log.Printf("[/send] Error: %s\n", err.Error())
+		logger(ctx).Printf("[/send] Error: %s\n", err.Error())
 		bot.trySendMessage(ctx.Message().Sender, fmt.Sprint(Translate(ctx, "errorTryLaterMessage")))
 		return ctx, err
 	}
@@ -247,7 +276,7 @@ func (bot *TipBot) keyboardSendHandler(ctx intercept.Context) (intercept.Context
 	// set LNURLPayParams in the state of the user
 	stateDataJson, err := json.Marshal(enterUserStateData)
 	if err != nil {
-		log.Errorln(err)
+		logger(ctx).Errorln(err)
 		return ctx, err
 	}
 	SetUserState(user, bot, lnbits.UserEnterUser, string(stateDataJson))
@@ -265,7 +294,7 @@ func (bot *TipBot) keyboardSendHandler(ctx intercept.Context) (intercept.Context
 	// this is suboptimal because Telegram.Send is not rate limited etc. but it's the only way to send a custom keyboard for now
 	_, err = bot.Telegram.Send(user.Telegram, Translate(ctx, "enterUserMessage"), sendToMenu)
 	if err != nil {
-		log.Errorln(err.Error())
+		logger(ctx).Errorln(err.Error())
 	}
 	return ctx, nil
 }
@@ -277,7 +306,7 @@ func (bot *TipBot) confirmSendHandler(ctx intercept.Context) (intercept.Context,
 	defer mutex.UnlockWithContext(ctx, tx.ID)
 	sn, err := tx.Get(tx, bot.Bunt)
 	if err != nil {
-		log.Errorf("[acceptSendHandler] %s", err.Error())
+		logger(ctx).Errorf("[acceptSendHandler] %s", err.Error())
 		return ctx, err
 	}
 	sendData := sn.(*SendData)
@@ -286,7 +315,7 @@ func (bot *TipBot) confirmSendHandler(ctx intercept.Context) (intercept.Context,
 		return ctx, errors.Create(errors.UnknownError)
 	}
 	if !sendData.Active {
-		log.Errorf("[acceptSendHandler] send not active anymore")
+		logger(ctx).Errorf("[acceptSendHandler] send not active anymore")
 		// bot.tryDeleteMessage(c.Message)
 		return ctx, errors.Create(errors.NotActiveError)
 	}
@@ -299,6 +328,9 @@ func (bot *TipBot) confirmSendHandler(ctx intercept.Context) (intercept.Context,
 	// log.Debug("[send] Callback: %s", c.Data)
 	from := LoadUser(ctx)
 	ResetUserState(from, bot) // we don't need to check the statekey anymore like we did earlier
+	if sendData.Escrow {
+		return bot.escrowSend(ctx, sendData, from)
+	}
 
 	// information about the send
 	toId := sendData.ToTelegramId
@@ -309,7 +341,7 @@ func (bot *TipBot) confirmSendHandler(ctx intercept.Context) (intercept.Context,
 	// we can now get the wallets of both users
 	to, err := GetLnbitsUser(&tb.User{ID: toId, Username: toUserStrWithoutAt}, *bot)
 	if err != nil {
-		log.Errorln(err.Error())
+		logger(ctx).Errorln(err.Error())
 		bot.tryDeleteMessage(ctx.Callback().Message)
 		return ctx, err
 	}
@@ -319,38 +351,51 @@ func (bot *TipBot) confirmSendHandler(ctx intercept.Context) (intercept.Context,
 	fromUserStr := GetUserStr(from.Telegram)
 
 	transactionMemo := fmt.Sprintf("💸 Send from %s to %s.", fromUserStr, toUserStr)
-	t := NewTransaction(bot, from, to, amount, TransactionType("send"))
+	t := NewTransaction(bot, from, to, amount, TransactionType("send"), TransactionIdempotencyKey(idempotencyKey(ctx)), TransactionContext(ctx),
+		TransactionUserInitiated())
 	t.Memo = transactionMemo
 
 	success, err := t.Send()
+	if isDuplicateOperation(err) {
+		return ctx, err
+	}
 	if !success || err != nil {
 		// bot.trySendMessage(c.Sender, sendErrorMessage)
 		errmsg := fmt.Sprintf("[/send] Error: Transaction failed. %s", err.Error())
-		log.Errorln(errmsg)
+		logger(ctx).Errorln(errmsg)
 		bot.tryEditMessage(ctx.Callback().Message, i18n.Translate(sendData.LanguageCode, "sendErrorMessage"), &tb.ReplyMarkup{})
 		return ctx, errors.Create(errors.UnknownError)
 	}
 	sendData.Inactivate(sendData, bot.Bunt)
 
-	log.Infof("[💸 send] Send from %s to %s (%d sat).", fromUserStr, toUserStr, amount)
+	logger(ctx).Infof("[💸 send] Send from %s to %s (%d sat).", fromUserStr, toUserStr, amount)
 
 	// notify to user
-	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), fromUserStrMd, amount))
+	notificationType := NotificationPayment
+	if !ctx.Callback().Message.Private() {
+		notificationType = NotificationMention
+	}
+	bot.notify(to, notificationType, amount, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), fromUserStrMd, amount)+bot.fiatAmount(to, amount))
 	// bot.trySendMessage(from.Telegram, fmt.Sprintf(Translate(ctx, "sendSentMessage"), amount, toUserStrMd))
 	if ctx.Callback().Message.Private() {
 		// if the command was invoked in private chat
 		// the edit below was cool, but we need to get rid of the replymarkup inline keyboard thingy for the main menu to pop up
 		// bot.tryEditMessage(c.Message, fmt.Sprintf(i18n.Translate(sendData.LanguageCode, "sendSentMessage"), amount, toUserStrMd), &tb.ReplyMarkup{})
 		bot.tryDeleteMessage(ctx.Callback().Message)
-		bot.trySendMessage(ctx.Callback().Sender, fmt.Sprintf(i18n.Translate(sendData.LanguageCode, "sendSentMessage"), amount, toUserStrMd))
+		t.SetSenderMessage(bot.trySendMessage(ctx.Callback().Sender, fmt.Sprintf(i18n.Translate(sendData.LanguageCode, "sendSentMessage"), amount, toUserStrMd)+bot.fiatAmount(from, amount)+feeStr(sendData.LanguageCode, t.Fee)))
 	} else {
 		// if the command was invoked in group chat
-		bot.trySendMessage(ctx.Callback().Sender, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "sendSentMessage"), amount, toUserStrMd))
-		bot.tryEditMessage(ctx.Callback().Message, fmt.Sprintf(i18n.Translate(sendData.LanguageCode, "sendPublicSentMessage"), amount, fromUserStrMd, toUserStrMd), &tb.ReplyMarkup{})
+		t.SetSenderMessage(bot.trySendMessage(ctx.Callback().Sender, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "sendSentMessage"), amount, toUserStrMd)+bot.fiatAmount(from, amount)+feeStr(from.Telegram.LanguageCode, t.Fee)))
+		if bot.getGroupSettings(ctx.Callback().Message.Chat.ID).Quiet {
+			bot.tryDeleteMessage(ctx.Callback().Message)
+		} else {
+			bot.tryEditMessage(ctx.Callback().Message, fmt.Sprintf(i18n.Translate(sendData.LanguageCode, "sendPublicSentMessage"), amount, fromUserStrMd, toUserStrMd), &tb.ReplyMarkup{})
+			bot.scheduleDeletion(ctx.Callback().Message)
+		}
 	}
 	// send memo if it was present
 	if len(sendMemo) > 0 {
-		bot.trySendMessage(to.Telegram, fmt.Sprintf("✉️ %s", str.MarkdownEscape(sendMemo)))
+		bot.notify(to, notificationType, amount, fmt.Sprintf("✉️ %s", str.MarkdownEscape(sendMemo)))
 	}
 
 	return ctx, nil
@@ -367,7 +412,7 @@ func (bot *TipBot) cancelSendHandler(ctx intercept.Context) (intercept.Context,
 	defer mutex.UnlockWithContext(ctx, tx.ID)
 	sn, err := tx.Get(tx, bot.Bunt)
 	if err != nil {
-		log.Errorf("[acceptSendHandler] %s", err.Error())
+		logger(ctx).Errorf("[acceptSendHandler] %s", err.Error())
 		return ctx, err
 	}
 
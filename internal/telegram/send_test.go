@@ -0,0 +1,76 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestSendFlow(t *testing.T) {
+	h := newTestHarness(t)
+	sender := &tb.User{ID: 1001, Username: "sender", FirstName: "Sender", LanguageCode: "en"}
+	receiver := &tb.User{ID: 1002, Username: "receiver", FirstName: "Receiver", LanguageCode: "en"}
+	from := h.newUser(sender, 1000)
+	to := h.newUser(receiver, 0)
+
+	h.sendMessage(sender, privateChat(sender), "/send 100 @receiver")
+	confirmation := h.lastMessage(sender.ID)
+	if !strings.Contains(confirmation.Text(), "100 sat") {
+		t.Fatalf("confirmation = %q", confirmation.Text())
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 0 {
+		t.Fatalf("receiver got %d sat before the confirmation", balance)
+	}
+
+	h.pressButton(sender, confirmation, "✅ Send")
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 900 {
+		t.Errorf("balance of sender = %d, want 900", balance)
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 100 {
+		t.Errorf("balance of receiver = %d, want 100", balance)
+	}
+	if text := h.lastMessage(sender.ID).Text(); !strings.Contains(text, "100 sat sent") {
+		t.Errorf("last message to sender = %q", text)
+	}
+	if text := h.lastMessage(receiver.ID).Text(); !strings.Contains(text, "sent you 100 sat") {
+		t.Errorf("last message to receiver = %q", text)
+	}
+
+	// the confirmation can not be used twice
+	h.pressButton(sender, confirmation, "✅ Send")
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 100 {
+		t.Errorf("balance of receiver = %d after pressing send twice, want 100", balance)
+	}
+}
+
+func TestSendFlowCancel(t *testing.T) {
+	h := newTestHarness(t)
+	sender := &tb.User{ID: 2001, Username: "sender", FirstName: "Sender", LanguageCode: "en"}
+	receiver := &tb.User{ID: 2002, Username: "receiver", FirstName: "Receiver", LanguageCode: "en"}
+	from := h.newUser(sender, 1000)
+	h.newUser(receiver, 0)
+
+	h.sendMessage(sender, privateChat(sender), "/send 100 @receiver")
+	h.pressButton(sender, h.lastMessage(sender.ID), "🚫 Cancel")
+	if text := h.lastMessage(sender.ID).Text(); !strings.Contains(text, "Send cancelled") {
+		t.Errorf("last message to sender = %q", text)
+	}
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of sender = %d, want 1000", balance)
+	}
+}
+
+func TestSendFlowUnknownUser(t *testing.T) {
+	h := newTestHarness(t)
+	sender := &tb.User{ID: 3001, Username: "sender", FirstName: "Sender", LanguageCode: "en"}
+	from := h.newUser(sender, 1000)
+
+	h.sendMessage(sender, privateChat(sender), "/send 100 @nobody")
+	if buttons := h.lastMessage(sender.ID).Buttons(); len(buttons) > 0 {
+		t.Errorf("confirmation for a user without wallet: %+v", buttons)
+	}
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of sender = %d, want 1000", balance)
+	}
+}
@@ -2,16 +2,51 @@ package telegram
 
 import (
 	"fmt"
+	"math"
+	"net/url"
+	"strconv"
 	"strings"
 
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
 	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
 
 var (
-	settingsHelpMessage = "📖 Change user settings\n\n`/set unit <BTC|USD|EUR|GBP>` 💶 Change your default currency."
+	settingsHelpMessage = "📖 Change user settings\n\n`/set unit <BTC|USD|EUR|GBP|...>` 💶 Change your default currency.\n`/set display <sat|btc|bits>` ₿ Show amounts in sat, BTC or bits.\n`/set success <message|url|reset> [<value>]` 🎉 Change what wallets show after paying your lightning address.\n`/set confirm <amount|all>` ✅ Send payments up to this amount without confirmation.\n`/set maxfee <percent%|amount|off>` ⛽️ Limit the routing fee of your payments.\n`/set donor <public|private>` ❤️ Show your name to donation recipients and on the donor leaderboard.\n`/set tips <public|anonymous>` 🕶 Show your name to the receivers of your tips.\n`/set notifications` 🔔 Choose which notifications you get."
+
+	confirmPaymentsHelpMessage    = "📖 Payments ask for your confirmation before they are sent.\n\n`/set confirm <amount>` ⚡️ Send payments up to this amount without confirmation.\n`/set confirm all` ✅ Confirm all payments."
+	confirmPaymentsCurrentMessage = "✅ Payments above %d sat ask for confirmation."
+	confirmPaymentsAllMessage     = "✅ All payments ask for confirmation."
+
+	maxFeeHelpMessage    = "📖 Payments whose routing fee may exceed your limit ask you to pay anyway.\n\n`/set maxfee <percent>%` ⛽️ Limit the fee to a share of the amount, for example `/set maxfee 0.5%`.\n`/set maxfee <amount>` ⛽️ Limit the fee to an amount, for example `/set maxfee 20`.\n`/set maxfee off` 🗑 Remove the limit."
+	maxFeePercentMessage = "⛽️ Your routing fee limit is %s%% of the amount."
+	maxFeeAmountMessage  = "⛽️ Your routing fee limit is %d sat."
+	maxFeeNoLimitMessage = "⛽️ You have no routing fee limit."
+
+	donorHelpMessage    = "📖 Donations are anonymous unless you make them public.\n\n`/set donor public` ❤️ Show your name to donation recipients and on the donor leaderboard (`/donate top`).\n`/set donor private` 🕶 Donate anonymously."
+	donorPublicMessage  = "❤️ Your donations are public."
+	donorPrivateMessage = "🕶 Your donations are anonymous."
+
+	tipsHelpMessage      = "📖 Receivers of your tips see your name unless you tip anonymously with `/tip <amount> anon`.\n\n`/set tips anonymous` 🕶 Tip anonymously by default, `/tip <amount> public` shows your name.\n`/set tips public` 👤 Show your name to the receivers of your tips."
+	tipsAnonymousMessage = "🕶 Your tips are anonymous."
+	tipsPublicMessage    = "👤 Receivers of your tips see your name."
+
+	notificationsHelpMessage    = "📖 Choose which notifications you get.\n\n`/set notifications tips <amount|all>` 🏅 Mute tips below an amount.\n`/set notifications faucet <on|off>` 🚰 Notify faucet claims.\n`/set notifications mentions <on|off>` 👥 Notify payments that were sent to you in groups.\n`/set notifications digest <on|off>` 📬 Get one digest per day instead of instant messages."
+	notificationsCurrentMessage = "🔔 Tips: %s\n🚰 Faucets: %s\n👥 Group payments: %s\n📬 Daily digest: %s"
+	notificationsTipsAllMessage = "all"
+	notificationsTipsMinMessage = "from %d sat"
+
+	successActionHelpMessage    = "📖 Wallets show a message or open a link after paying your lightning address.\n\n`/set success message <text>` 💬 Show a message (max. %d characters).\n`/set success url <https://...>` 🔗 Show a link. The message is used as its description.\n`/set success reset` 🗑 Use the default message."
+	successActionCurrentMessage = "🎉 Message: %s\n🔗 URL: %s"
+	successActionInvalidMessage = "🚫 Invalid value. Messages can have at most %d characters and URLs must start with https://."
+	successActionChangedMessage = "✅ Your success action has been updated."
 )
 
+// successMessageMaxLength is the maximum length of a successAction message or description (LUD-09)
+const successMessageMaxLength = 144
+
 func (bot *TipBot) settingHandler(ctx intercept.Context) (intercept.Context, error) {
 	m := ctx.Message()
 	splits := strings.Split(m.Text, " ")
@@ -21,6 +56,20 @@ func (bot *TipBot) settingHandler(ctx intercept.Context) (intercept.Context, err
 		switch strings.ToLower(splits[1]) {
 		case "unit":
 			return bot.addFiatCurrency(ctx)
+		case "display":
+			return bot.setUnitHandler(ctx)
+		case "success":
+			return bot.setSuccessActionHandler(ctx)
+		case "confirm":
+			return bot.setConfirmPaymentsHandler(ctx)
+		case "maxfee":
+			return bot.setMaxFeeHandler(ctx)
+		case "donor":
+			return bot.setDonorHandler(ctx)
+		case "tips":
+			return bot.setTipsHandler(ctx)
+		case "notifications":
+			return bot.setNotificationsHandler(ctx)
 		case "help":
 			return bot.nostrHelpHandler(ctx)
 		}
@@ -30,39 +79,307 @@ func (bot *TipBot) settingHandler(ctx intercept.Context) (intercept.Context, err
 
 func (bot *TipBot) addFiatCurrency(ctx intercept.Context) (intercept.Context, error) {
 	m := ctx.Message()
-	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
-	if err != nil {
-		return ctx, err
-	}
-
+	user := LoadUser(ctx)
 	splits := strings.Split(m.Text, " ")
 	splitlen := len(splits)
 	if splitlen < 3 {
 		// display users current fiat currency
-		currentCurrency := strings.ToUpper(user.Settings.Display.DisplayCurrency)
+		currentCurrency := bot.getUserCurrency(user)
 		if currentCurrency == "" {
 			currentCurrency = "BTC"
 		}
 		bot.trySendMessage(ctx.Message().Sender, fmt.Sprintf("🌍 Your current default currency is `%s`", currentCurrency))
 		return ctx, nil
 	}
-	currencyInput := splits[2]
-	// convert to lowercase and check if in [usd, eur, gbp, btc, sat]
-	currencyInput = strings.ToLower(currencyInput)
-	if currencyInput != "usd" && currencyInput != "eur" && currencyInput != "gbp" && currencyInput != "btc" && currencyInput != "sat" {
-		bot.trySendMessage(ctx.Message().Sender, "🚫 Invalid currency. Please use one of the following: `BTC`, `USD`, `EUR`")
-		return ctx, fmt.Errorf("invalid currency")
+	currencyInput, err := parseCurrency(splits[2])
+	if err != nil {
+		bot.trySendMessage(ctx.Message().Sender, fmt.Sprintf(Translate(ctx, "currencyInvalidMessage"), strings.Join(availableCurrencies(), ", ")))
+		return ctx, err
 	}
-	if currencyInput == "sat" {
-		currencyInput = "BTC"
+	err = bot.setUserCurrency(user, currencyInput)
+	if err != nil {
+		log.Errorf("[addFiatCurrency] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	bot.trySendMessage(ctx.Message().Sender, "✅ Your default currency has been updated.")
+	return ctx, nil
+}
+
+// setSuccessActionHandler is invoked on /set success [message <text>|url <url>|reset]
+func (bot *TipBot) setSuccessActionHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	splits := strings.SplitN(m.Text, " ", 4)
+	if len(splits) < 3 {
+		message, successURL := "-", "-"
+		if len(user.Settings.LNURL.SuccessMessage) > 0 {
+			message = str.MarkdownEscape(user.Settings.LNURL.SuccessMessage)
+		}
+		if len(user.Settings.LNURL.SuccessURL) > 0 {
+			successURL = str.MarkdownEscape(user.Settings.LNURL.SuccessURL)
+		}
+		bot.trySendMessage(m.Sender, fmt.Sprintf(successActionHelpMessage, successMessageMaxLength)+"\n\n"+fmt.Sprintf(successActionCurrentMessage, message, successURL), tb.NoPreview)
+		return ctx, nil
+	}
+	value := ""
+	if len(splits) == 4 {
+		value = strings.TrimSpace(splits[3])
+	}
+	switch strings.ToLower(splits[2]) {
+	case "message":
+		if len(value) == 0 || len([]rune(value)) > successMessageMaxLength {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(successActionInvalidMessage, successMessageMaxLength))
+			return ctx, fmt.Errorf("invalid success message")
+		}
+		user.Settings.LNURL.SuccessMessage = value
+	case "url":
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme != "https" || len(u.Host) == 0 {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(successActionInvalidMessage, successMessageMaxLength))
+			return ctx, fmt.Errorf("invalid success url")
+		}
+		user.Settings.LNURL.SuccessURL = u.String()
+	case "reset":
+		user.Settings.LNURL.SuccessMessage = ""
+		user.Settings.LNURL.SuccessURL = ""
+	default:
+		bot.trySendMessage(m.Sender, fmt.Sprintf(successActionHelpMessage, successMessageMaxLength))
+		return ctx, nil
 	}
-	// save node in db
-	user.Settings.Display.DisplayCurrency = currencyInput
 	err = UpdateUserRecord(user, *bot)
 	if err != nil {
-		log.Errorf("[registerNodeHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		log.Errorf("[setSuccessActionHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
 		return ctx, err
 	}
-	bot.trySendMessage(ctx.Message().Sender, "✅ Your default currency has been updated.")
+	bot.trySendMessage(m.Sender, successActionChangedMessage)
+	return ctx, nil
+}
+
+// setConfirmPaymentsHandler is invoked on /set confirm [<amount>|all]
+func (bot *TipBot) setConfirmPaymentsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	currentMessage := func() string {
+		if user.Settings.Payment.ConfirmAbove > 0 {
+			return fmt.Sprintf(confirmPaymentsCurrentMessage, user.Settings.Payment.ConfirmAbove)
+		}
+		return confirmPaymentsAllMessage
+	}
+	splits := strings.Split(m.Text, " ")
+	if len(splits) < 3 {
+		bot.trySendMessage(m.Sender, confirmPaymentsHelpMessage+"\n\n"+currentMessage())
+		return ctx, nil
+	}
+	if strings.ToLower(splits[2]) == "all" {
+		user.Settings.Payment.ConfirmAbove = 0
+	} else {
+		amount, err := GetAmount(splits[2])
+		if err != nil {
+			bot.trySendMessage(m.Sender, confirmPaymentsHelpMessage)
+			return ctx, err
+		}
+		user.Settings.Payment.ConfirmAbove = amount
+	}
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[setConfirmPaymentsHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, currentMessage())
+	return ctx, nil
+}
+
+// setMaxFeeHandler is invoked on /set maxfee [<percent>%|<amount>|off]
+func (bot *TipBot) setMaxFeeHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	currentMessage := func() string {
+		switch {
+		case user.Settings.Payment.MaxFeeSat > 0:
+			return fmt.Sprintf(maxFeeAmountMessage, user.Settings.Payment.MaxFeeSat)
+		case user.Settings.Payment.MaxFeePercent > 0:
+			return fmt.Sprintf(maxFeePercentMessage, strconv.FormatFloat(user.Settings.Payment.MaxFeePercent, 'f', -1, 64))
+		}
+		return maxFeeNoLimitMessage
+	}
+	splits := strings.Split(m.Text, " ")
+	if len(splits) < 3 {
+		bot.trySendMessage(m.Sender, maxFeeHelpMessage+"\n\n"+currentMessage())
+		return ctx, nil
+	}
+	value := strings.ToLower(splits[2])
+	switch {
+	case value == "off":
+		user.Settings.Payment.MaxFeePercent, user.Settings.Payment.MaxFeeSat = 0, 0
+	case strings.HasSuffix(value, "%"):
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || math.IsNaN(percent) || percent <= 0 || percent > 100 {
+			bot.trySendMessage(m.Sender, maxFeeHelpMessage)
+			return ctx, fmt.Errorf("invalid fee limit %s", value)
+		}
+		user.Settings.Payment.MaxFeePercent, user.Settings.Payment.MaxFeeSat = percent, 0
+	default:
+		amount, err := GetAmount(value)
+		if err != nil {
+			bot.trySendMessage(m.Sender, maxFeeHelpMessage)
+			return ctx, err
+		}
+		user.Settings.Payment.MaxFeePercent, user.Settings.Payment.MaxFeeSat = 0, amount
+	}
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[setMaxFeeHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, currentMessage())
+	return ctx, nil
+}
+
+// setDonorHandler is invoked on /set donor [public|private]
+func (bot *TipBot) setDonorHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	currentMessage := func() string {
+		if user.Settings.Donation.Public {
+			return donorPublicMessage
+		}
+		return donorPrivateMessage
+	}
+	splits := strings.Split(m.Text, " ")
+	if len(splits) < 3 {
+		bot.trySendMessage(m.Sender, donorHelpMessage+"\n\n"+currentMessage())
+		return ctx, nil
+	}
+	switch strings.ToLower(splits[2]) {
+	case "public":
+		user.Settings.Donation.Public = true
+	case "private":
+		user.Settings.Donation.Public = false
+	default:
+		bot.trySendMessage(m.Sender, donorHelpMessage)
+		return ctx, nil
+	}
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[setDonorHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, currentMessage())
+	return ctx, nil
+}
+
+// setTipsHandler is invoked on /set tips [public|anonymous]
+func (bot *TipBot) setTipsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	currentMessage := func() string {
+		if user.Settings.Tip.Anonymous {
+			return tipsAnonymousMessage
+		}
+		return tipsPublicMessage
+	}
+	splits := strings.Split(m.Text, " ")
+	if len(splits) < 3 {
+		bot.trySendMessage(m.Sender, tipsHelpMessage+"\n\n"+currentMessage())
+		return ctx, nil
+	}
+	switch strings.ToLower(splits[2]) {
+	case "anonymous", "anon":
+		user.Settings.Tip.Anonymous = true
+	case "public":
+		user.Settings.Tip.Anonymous = false
+	default:
+		bot.trySendMessage(m.Sender, tipsHelpMessage)
+		return ctx, nil
+	}
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[setTipsHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, currentMessage())
+	return ctx, nil
+}
+
+func onOff(on bool) string {
+	if on {
+		return "✅"
+	}
+	return "❌"
+}
+
+// setNotificationsHandler is invoked on /set notifications [tips <amount|all>|faucet|mentions|digest <on|off>]
+func (bot *TipBot) setNotificationsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	settings := &user.Settings.Notification
+	currentMessage := func() string {
+		tips := notificationsTipsAllMessage
+		if settings.MinTip > 0 {
+			tips = fmt.Sprintf(notificationsTipsMinMessage, settings.MinTip)
+		}
+		return fmt.Sprintf(notificationsCurrentMessage, tips, onOff(!settings.MuteFaucet), onOff(!settings.MuteMentions), onOff(settings.Digest))
+	}
+	splits := strings.Split(m.Text, " ")
+	if len(splits) < 4 {
+		bot.trySendMessage(m.Sender, notificationsHelpMessage+"\n\n"+currentMessage())
+		return ctx, nil
+	}
+	key, value := strings.ToLower(splits[2]), strings.ToLower(splits[3])
+	if key != "tips" && value != "on" && value != "off" {
+		bot.trySendMessage(m.Sender, notificationsHelpMessage)
+		return ctx, fmt.Errorf("invalid notification setting %s", value)
+	}
+	wasDigest := settings.Digest
+	switch key {
+	case "tips":
+		if value == "all" {
+			settings.MinTip = 0
+			break
+		}
+		amount, err := GetAmount(value)
+		if err != nil {
+			bot.trySendMessage(m.Sender, notificationsHelpMessage)
+			return ctx, err
+		}
+		settings.MinTip = amount
+	case "faucet":
+		settings.MuteFaucet = value == "off"
+	case "mentions":
+		settings.MuteMentions = value == "off"
+	case "digest":
+		settings.Digest = value == "on"
+	default:
+		bot.trySendMessage(m.Sender, notificationsHelpMessage)
+		return ctx, nil
+	}
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[setNotificationsHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, currentMessage())
+	if wasDigest && !settings.Digest {
+		// deliver what was collected for the digest
+		bot.sendNotificationDigest(user.Telegram.ID)
+	}
 	return ctx, nil
 }
@@ -855,7 +855,7 @@ func (bot *TipBot) shopConfirmBuyHandler(ctx intercept.Context) (intercept.Conte
 		return ctx, errors.Create(errors.InvalidAmountError)
 	}
 	transactionMemo := fmt.Sprintf("🛍 Shop from %s.", toUserStr)
-	t := NewTransaction(bot, from, to, amount, TransactionType("shop"))
+	t := NewTransaction(bot, from, to, amount, TransactionType("shop"), TransactionContext(ctx))
 	t.Memo = transactionMemo
 
 	success, err := t.Send()
@@ -873,7 +873,7 @@ func (bot *TipBot) shopConfirmBuyHandler(ctx intercept.Context) (intercept.Conte
 		shopItemTitle = fmt.Sprintf("%s", item.Title)
 	}
 	ctx.Context = context.WithValue(ctx, "callback_response", "🛍 Purchase successful.")
-	bot.trySendMessage(to.Telegram, fmt.Sprintf("🛍 Someone bought `%s` from your shop `%s` for `%d sat`.", str.MarkdownEscape(shopItemTitle), str.MarkdownEscape(shop.Title), amount))
+	bot.notify(to, NotificationPayment, amount, fmt.Sprintf("🛍 Someone bought `%s` from your shop `%s` for `%d sat`.", str.MarkdownEscape(shopItemTitle), str.MarkdownEscape(shop.Title), amount))
 	bot.trySendMessage(from.Telegram, fmt.Sprintf("🛍 You bought `%s` from %s's shop `%s` for `%d sat`.", str.MarkdownEscape(shopItemTitle), toUserStrMd, str.MarkdownEscape(shop.Title), amount))
 	log.Infof("[🛍 shop] %s bought from %s shop: %s item: %s  for %d sat.", toUserStr, GetUserStr(to.Telegram), shop.Title, shopItemTitle, amount)
 	bot.shopSendItemFilesToUser(ctx, user, itemID)
@@ -971,7 +971,7 @@ func (bot *TipBot) shopsHandler(ctx intercept.Context) (intercept.Context, error
 
 		// check for user in command, accepts user mention or plain username without @
 		if len(m.Entities) > 1 && m.Entities[1].Type == "mention" {
-			toUserStrMention = m.Text[m.Entities[1].Offset : m.Entities[1].Offset+m.Entities[1].Length]
+			toUserStrMention = entityText(m.Text, m.Entities[1])
 			toUserStrWithoutAt = strings.TrimPrefix(toUserStrMention, "@")
 		} else {
 			var err error
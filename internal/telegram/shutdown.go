@@ -0,0 +1,60 @@
+package telegram
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// shutdownTimeout is how long the shutdown waits for handlers and payments to finish.
+const shutdownTimeout = 30 * time.Second
+
+var (
+	// inFlight counts the updates and payments that are currently handled
+	inFlight     int64
+	shuttingDown int32
+)
+
+func beginInFlight() {
+	atomic.AddInt64(&inFlight, 1)
+}
+
+func endInFlight() {
+	atomic.AddInt64(&inFlight, -1)
+}
+
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+// trackInFlight makes the shutdown wait for the handler to return.
+func trackInFlight(handler tb.HandlerFunc) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		beginInFlight()
+		defer endInFlight()
+		return handler(c)
+	}
+}
+
+// GracefulShutdown stops receiving updates and waits until all handlers, payments and
+// locks are done. Queued payment retries and user states are persisted and resumed after
+// the restart. Pending message edits are sent before the bot exits.
+func (bot *TipBot) GracefulShutdown() {
+	log.Infof("[shutdown] Graceful shutdown (timeout=%s).", shutdownTimeout)
+	atomic.StoreInt32(&shuttingDown, 1)
+	bot.Telegram.Stop()
+	deadline := time.Now().Add(shutdownTimeout)
+	for atomic.LoadInt64(&inFlight) > 0 || !mutex.IsEmpty() {
+		if time.Now().After(deadline) {
+			log.Warnf("[shutdown] Graceful shutdown timeout reached with %d updates in flight. Forcing shutdown.", atomic.LoadInt64(&inFlight))
+			break
+		}
+		log.Tracef("[shutdown] Waiting for %d updates in flight...", atomic.LoadInt64(&inFlight))
+		time.Sleep(100 * time.Millisecond)
+	}
+	bot.flushEditStack()
+	log.Infof("[shutdown] Graceful shutdown successful.")
+}
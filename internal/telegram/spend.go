@@ -0,0 +1,452 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/LightningTipBot/LightningTipBot/pkg/lightning"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// spendProposalDuration is how long approvers have to decide on a proposal
+	spendProposalDuration = 48 * time.Hour
+	// spendCheckInterval is how often proposals that ran out are expired
+	spendCheckInterval = 10 * time.Minute
+	// spendLogLength is how many proposals /spend log shows
+	spendLogLength = 10
+
+	SpendPending  = "pending"
+	SpendPaid     = "paid"
+	SpendRejected = "rejected"
+	SpendExpired  = "expired"
+	SpendFailed   = "failed"
+)
+
+var (
+	spendMenu       = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnApproveSpend = spendMenu.Data("✅ Approve", "approve_spend")
+	btnRejectSpend  = spendMenu.Data("❌ Reject", "reject_spend")
+)
+
+// SpendApprover is a member who approves spending from the group wallet.
+type SpendApprover struct {
+	ChatId int64 `json:"chat_id" gorm:"primaryKey;autoIncrement:false"`
+	UserId int64 `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+}
+
+// SpendProposal is a payment from the group wallet that waits for the approvers of the group.
+// Proposals are kept after they were decided, as the decision log of the group.
+type SpendProposal struct {
+	ID           uint      `gorm:"primarykey"`
+	CreatedAt    time.Time `json:"created_at"`
+	ChatId       int64     `json:"chat_id" gorm:"index"`
+	ProposerId   int64     `json:"proposer_id"`
+	Proposer     string    `json:"proposer"`
+	Recipient    string    `json:"recipient"`    // @username or lightning address
+	RecipientId  int64     `json:"recipient_id"` // zero for lightning addresses
+	Amount       int64     `json:"amount"`
+	Memo         string    `json:"memo"`
+	Required     int64     `json:"required"` // approvals that the proposal needs
+	Status       string    `json:"status" gorm:"index"`
+	Reason       string    `json:"reason"` // why the payment failed
+	Expires      time.Time `json:"expires"`
+	DecidedAt    time.Time `json:"decided_at"`
+	MessageId    int       `json:"message_id"`
+	LanguageCode string    `json:"language_code"`
+}
+
+// SpendVote is the decision of an approver on a proposal.
+type SpendVote struct {
+	ProposalId uint      `json:"proposal_id" gorm:"primaryKey;autoIncrement:false"`
+	UserId     int64     `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	User       string    `json:"user"`
+	Approve    bool      `json:"approve"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (bot *TipBot) spendApprovers(chatId int64) []SpendApprover {
+	approvers := make([]SpendApprover, 0)
+	bot.DB.Groups.Where("chat_id = ?", chatId).Find(&approvers)
+	return approvers
+}
+
+func (bot *TipBot) isSpendApprover(chatId int64, userId int64) bool {
+	var count int64
+	bot.DB.Groups.Model(&SpendApprover{}).Where("chat_id = ? AND user_id = ?", chatId, userId).Count(&count)
+	return count > 0
+}
+
+func (bot *TipBot) spendVotes(proposalId uint) []SpendVote {
+	votes := make([]SpendVote, 0)
+	bot.DB.Groups.Where("proposal_id = ?", proposalId).Order("created_at").Find(&votes)
+	return votes
+}
+
+// proposalStr shows a proposal with its votes and its status.
+func (bot *TipBot) proposalStr(proposal *SpendProposal) string {
+	text := fmt.Sprintf(i18n.Translate(proposal.LanguageCode, "spendProposalMessage"), proposal.ID, str.MarkdownEscape(proposal.Proposer),
+		proposal.Amount, str.MarkdownEscape(proposal.Recipient))
+	if len(proposal.Memo) > 0 {
+		text += fmt.Sprintf(i18n.Translate(proposal.LanguageCode, "spendMemoMessage"), str.MarkdownEscape(proposal.Memo))
+	}
+	approvals := int64(0)
+	for _, vote := range bot.spendVotes(proposal.ID) {
+		if vote.Approve {
+			approvals++
+			text += fmt.Sprintf(i18n.Translate(proposal.LanguageCode, "spendApprovedByMessage"), str.MarkdownEscape(vote.User))
+		} else {
+			text += fmt.Sprintf(i18n.Translate(proposal.LanguageCode, "spendRejectedByMessage"), str.MarkdownEscape(vote.User))
+		}
+	}
+	switch proposal.Status {
+	case SpendPending:
+		text += fmt.Sprintf(i18n.Translate(proposal.LanguageCode, "spendPendingMessage"), approvals, proposal.Required,
+			proposal.Expires.UTC().Format(dropTimeLayout))
+	case SpendPaid:
+		text += i18n.Translate(proposal.LanguageCode, "spendPaidMessage")
+	case SpendRejected:
+		text += i18n.Translate(proposal.LanguageCode, "spendRejectedMessage")
+	case SpendExpired:
+		text += i18n.Translate(proposal.LanguageCode, "spendExpiredMessage")
+	case SpendFailed:
+		text += fmt.Sprintf(i18n.Translate(proposal.LanguageCode, "spendFailedMessage"), str.MarkdownEscape(proposal.Reason))
+	}
+	return text
+}
+
+func makeSpendKeyboard(proposal *SpendProposal) *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	id := strconv.FormatUint(uint64(proposal.ID), 10)
+	approveButton := menu.Data(i18n.Translate(proposal.LanguageCode, "spendApproveButtonMessage"), "approve_spend", id)
+	rejectButton := menu.Data(i18n.Translate(proposal.LanguageCode, "spendRejectButtonMessage"), "reject_spend", id)
+	menu.Inline(menu.Row(approveButton, rejectButton))
+	return menu
+}
+
+// updateProposalMessage shows the current state of a proposal, with buttons while it is pending.
+func (bot *TipBot) updateProposalMessage(proposal *SpendProposal) {
+	if proposal.MessageId == 0 {
+		return
+	}
+	message := &tb.StoredMessage{MessageID: strconv.Itoa(proposal.MessageId), ChatID: proposal.ChatId}
+	if proposal.Status == SpendPending {
+		bot.tryEditMessage(message, bot.proposalStr(proposal), makeSpendKeyboard(proposal))
+		return
+	}
+	bot.tryEditMessage(message, bot.proposalStr(proposal), &tb.ReplyMarkup{})
+}
+
+// spendHandler is invoked on /spend <amount> @user|<lightning address> [<memo>] in groups. The
+// group wallet pays when enough approvers of the group approved. /spend log shows the last
+// proposals of the group.
+func (bot *TipBot) spendHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Sender, Translate(ctx, "spendHelpMessage"))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	args := strings.Fields(m.Text)
+	if len(args) == 2 && strings.ToLower(args[1]) == "log" {
+		return bot.spendLogHandler(ctx)
+	}
+	if len(args) < 3 {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "spendHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	settings := bot.getGroupSettings(m.Chat.ID)
+	if settings.WalletUserId == 0 || settings.SpendApprovals == 0 {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "spendNotConfiguredMessage"))
+		return ctx, fmt.Errorf("group %d has no spending approvals", m.Chat.ID)
+	}
+	if !bot.isSpendApprover(m.Chat.ID, m.Sender.ID) && !bot.senderIsAdmin(m) {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "spendNotAllowedMessage"))
+		return ctx, fmt.Errorf("%s can't propose spending in %d", GetUserStr(m.Sender), m.Chat.ID)
+	}
+	amount, err := GetAmount(args[1])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "spendHelpMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	proposal := &SpendProposal{
+		CreatedAt:    time.Now(),
+		ChatId:       m.Chat.ID,
+		ProposerId:   m.Sender.ID,
+		Proposer:     GetUserStr(m.Sender),
+		Amount:       amount,
+		Memo:         strings.Join(args[3:], " "),
+		Required:     settings.SpendApprovals,
+		Status:       SpendPending,
+		Expires:      time.Now().Add(spendProposalDuration),
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	if lightning.IsLightningAddress(args[2]) {
+		proposal.Recipient = strings.ToLower(args[2])
+	} else {
+		to, err := GetUserByTelegramUsername(strings.TrimPrefix(args[2], "@"), *bot)
+		if err != nil || to.Wallet == nil {
+			bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape(args[2])))
+			return ctx, errors.Create(errors.UserNoWalletError)
+		}
+		proposal.Recipient, proposal.RecipientId = GetUserStr(to.Telegram), to.Telegram.ID
+	}
+	if tx := bot.DB.Groups.Create(proposal); tx.Error != nil {
+		log.Errorf("[/spend] could not store the proposal of %s: %v", GetUserStr(m.Sender), tx.Error)
+		return ctx, tx.Error
+	}
+	if message := bot.trySendMessage(bot.topicOf(m), bot.proposalStr(proposal), makeSpendKeyboard(proposal), keepMessage); message != nil {
+		proposal.MessageId = message.ID
+		bot.DB.Groups.Model(proposal).Update("message_id", message.ID)
+	}
+	logger(ctx).Infof("[👛 spend] %s proposed to spend %d sat of the group wallet of %d on %s", GetUserStr(m.Sender), amount, m.Chat.ID, proposal.Recipient)
+	return ctx, nil
+}
+
+// spendLogHandler shows the last proposals of the group with their decisions.
+func (bot *TipBot) spendLogHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	proposals := make([]SpendProposal, 0)
+	bot.DB.Groups.Where("chat_id = ?", m.Chat.ID).Order("id DESC").Limit(spendLogLength).Find(&proposals)
+	if len(proposals) == 0 {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "spendLogEmptyMessage"))
+		return ctx, nil
+	}
+	entries := make([]string, 0, len(proposals))
+	for _, proposal := range proposals {
+		votes := make([]string, 0)
+		for _, vote := range bot.spendVotes(proposal.ID) {
+			mark := "❌"
+			if vote.Approve {
+				mark = "✅"
+			}
+			votes = append(votes, mark+" "+str.MarkdownEscape(vote.User))
+		}
+		entry := fmt.Sprintf(Translate(ctx, "spendLogEntryMessage"), proposal.ID, proposal.CreatedAt.UTC().Format("2006-01-02"), proposal.Amount,
+			str.MarkdownEscape(proposal.Recipient), str.MarkdownEscape(proposal.Proposer), proposal.Status)
+		if len(votes) > 0 {
+			entry += " (" + strings.Join(votes, ", ") + ")"
+		}
+		entries = append(entries, entry)
+	}
+	bot.trySendMessage(bot.topicOf(m), Translate(ctx, "spendLogMessage")+"\n"+strings.Join(entries, "\n"))
+	return ctx, nil
+}
+
+// approveSpendHandler is invoked when an approver approves a proposal.
+func (bot *TipBot) approveSpendHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.voteSpend(ctx, true)
+}
+
+// rejectSpendHandler is invoked when an approver rejects a proposal.
+func (bot *TipBot) rejectSpendHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.voteSpend(ctx, false)
+}
+
+// voteSpend records the vote of an approver. The proposal is paid as soon as it has enough
+// approvals and rejected as soon as it can't get enough anymore.
+func (bot *TipBot) voteSpend(ctx intercept.Context, approve bool) (intercept.Context, error) {
+	id, err := strconv.ParseUint(ctx.Data(), 10, 64)
+	if err != nil {
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	key := fmt.Sprintf("spend:%d", id)
+	mutex.LockWithContext(ctx, key)
+	defer mutex.UnlockWithContext(ctx, key)
+	proposal := &SpendProposal{}
+	if tx := bot.DB.Groups.First(proposal, id); tx.Error != nil || proposal.Status != SpendPending {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if time.Now().After(proposal.Expires) {
+		bot.decideSpend(proposal, SpendExpired)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	sender := ctx.Sender()
+	if !bot.isSpendApprover(proposal.ChatId, sender.ID) {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "spendNotApproverMessage"))
+		return ctx, fmt.Errorf("%s is no approver of %d", GetUserStr(sender), proposal.ChatId)
+	}
+	vote := &SpendVote{ProposalId: proposal.ID, UserId: sender.ID, User: GetUserStr(sender), Approve: approve, CreatedAt: time.Now()}
+	if tx := bot.DB.Groups.Clauses(clause.OnConflict{UpdateAll: true}).Create(vote); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	logger(ctx).Infof("[👛 spend] %s voted %t on proposal %d", GetUserStr(sender), approve, proposal.ID)
+	ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "spendVotedMessage"))
+	var approvals, rejections int64
+	for _, vote := range bot.spendVotes(proposal.ID) {
+		if vote.Approve {
+			approvals++
+		} else {
+			rejections++
+		}
+	}
+	switch {
+	case approvals >= proposal.Required:
+		bot.executeSpend(proposal)
+	case rejections > int64(len(bot.spendApprovers(proposal.ChatId)))-proposal.Required:
+		bot.decideSpend(proposal, SpendRejected)
+	default:
+		bot.updateProposalMessage(proposal)
+	}
+	return ctx, nil
+}
+
+// decideSpend stores the final status of a proposal and shows it.
+func (bot *TipBot) decideSpend(proposal *SpendProposal, status string) {
+	proposal.Status, proposal.DecidedAt = status, time.Now()
+	if tx := bot.DB.Groups.Save(proposal); tx.Error != nil {
+		log.Errorf("[spend] could not save proposal %d: %v", proposal.ID, tx.Error)
+	}
+	log.Infof("[👛 spend] proposal %d of %d is %s", proposal.ID, proposal.ChatId, status)
+	bot.updateProposalMessage(proposal)
+}
+
+// executeSpend pays an approved proposal from the group wallet.
+func (bot *TipBot) executeSpend(proposal *SpendProposal) {
+	settings := bot.getGroupSettings(proposal.ChatId)
+	wallet, err := GetLnbitsUser(&tb.User{ID: settings.WalletUserId}, *bot)
+	if settings.WalletUserId == 0 || err != nil || wallet.Wallet == nil {
+		proposal.Reason = "no group wallet"
+		bot.decideSpend(proposal, SpendFailed)
+		return
+	}
+	memo := fmt.Sprintf("👛 Group spend #%d.", proposal.ID)
+	if len(proposal.Memo) > 0 {
+		memo = proposal.Memo
+	}
+	if proposal.RecipientId == 0 {
+		if _, err := bot.payLightningAddress(wallet, proposal.Recipient, proposal.Amount, memo); err != nil {
+			proposal.Reason = err.Error()
+			bot.decideSpend(proposal, SpendFailed)
+			return
+		}
+		bot.decideSpend(proposal, SpendPaid)
+		return
+	}
+	to, err := GetLnbitsUser(&tb.User{ID: proposal.RecipientId}, *bot)
+	if err != nil || to.Wallet == nil {
+		proposal.Reason = "no wallet"
+		bot.decideSpend(proposal, SpendFailed)
+		return
+	}
+	t := NewTransaction(bot, wallet, to, proposal.Amount, TransactionType("group spend"), TransactionChat(&tb.Chat{ID: proposal.ChatId}),
+		TransactionIdempotencyKey(fmt.Sprintf("spend:%d", proposal.ID)))
+	t.Memo = memo
+	success, err := t.Send()
+	if isDuplicateOperation(err) {
+		// an earlier attempt sent the payment or is still sending it, the proposal is only
+		// marked as paid after a send that went through
+		log.Warnf("[spend] proposal %d was already sent: %v", proposal.ID, err)
+		return
+	}
+	if !success {
+		proposal.Reason = err.Error()
+		bot.decideSpend(proposal, SpendFailed)
+		return
+	}
+	bot.decideSpend(proposal, SpendPaid)
+	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "spendReceivedMessage"), proposal.Amount))
+}
+
+// startSpendProposalWorker expires the proposals that were not decided in time.
+func (bot *TipBot) startSpendProposalWorker() {
+	go func() {
+		for {
+			if !isShuttingDown() {
+				bot.expireSpendProposals(time.Now())
+			}
+			time.Sleep(spendCheckInterval)
+		}
+	}()
+}
+
+func (bot *TipBot) expireSpendProposals(now time.Time) {
+	proposals := make([]SpendProposal, 0)
+	bot.DB.Groups.Where("status = ? AND expires < ?", SpendPending, now).Find(&proposals)
+	for _, proposal := range proposals {
+		key := fmt.Sprintf("spend:%d", proposal.ID)
+		mutex.Lock(key)
+		// a vote may have decided the proposal in the meantime
+		if tx := bot.DB.Groups.First(&proposal, proposal.ID); tx.Error == nil && proposal.Status == SpendPending {
+			bot.decideSpend(&proposal, SpendExpired)
+		}
+		mutex.Unlock(key)
+	}
+}
+
+// groupSettingsApprovalsHandler sets who approves spending from the group wallet with
+// /groupsettings approvals <count> @user ... and turns spending off with /groupsettings approvals off.
+func (bot *TipBot) groupSettingsApprovalsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	args := strings.Fields(m.Text)
+	if len(args) < 3 {
+		bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsHelpMessage"), strings.Join(i18n.LoadedLanguages(), ", ")))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	settings := bot.getGroupSettings(m.Chat.ID)
+	approvers := make([]*lnbits.User, 0)
+	if strings.ToLower(args[2]) == "off" {
+		settings.SpendApprovals = 0
+	} else {
+		required, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil || required < 1 || required > int64(len(args)-3) {
+			bot.trySendMessage(bot.topicOf(m), Translate(ctx, "groupSettingsApprovalsInvalidMessage"))
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+		if settings.WalletUserId == 0 {
+			bot.trySendMessage(bot.topicOf(m), Translate(ctx, "groupSettingsCommissionNoWalletMessage"))
+			return ctx, fmt.Errorf("group %d has no group wallet", m.Chat.ID)
+		}
+		seen := map[int64]bool{}
+		for _, arg := range args[3:] {
+			user, err := GetUserByTelegramUsername(strings.TrimPrefix(arg, "@"), *bot)
+			if err != nil {
+				bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape(arg)))
+				return ctx, err
+			}
+			if !seen[user.Telegram.ID] {
+				seen[user.Telegram.ID] = true
+				approvers = append(approvers, user)
+			}
+		}
+		if required > int64(len(approvers)) {
+			bot.trySendMessage(bot.topicOf(m), Translate(ctx, "groupSettingsApprovalsInvalidMessage"))
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+		settings.SpendApprovals = required
+	}
+	bot.DB.Groups.Where("chat_id = ?", m.Chat.ID).Delete(&SpendApprover{})
+	for _, approver := range approvers {
+		bot.DB.Groups.Create(&SpendApprover{ChatId: m.Chat.ID, UserId: approver.Telegram.ID})
+	}
+	if err := bot.saveGroupSettings(settings); err != nil {
+		log.Errorf("[groupSettingsApprovalsHandler] could not save settings of group %d: %v", m.Chat.ID, err)
+		return ctx, err
+	}
+	bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "groupSettingsApprovalsMessage"), bot.groupApprovalsStr(settings)))
+	return ctx, nil
+}
+
+// groupApprovalsStr shows who approves spending from the group wallet in the group settings.
+func (bot *TipBot) groupApprovalsStr(settings *GroupSettings) string {
+	if settings.SpendApprovals == 0 {
+		return "-"
+	}
+	names := make([]string, 0)
+	for _, approver := range bot.spendApprovers(settings.ID) {
+		if user, err := GetLnbitsUser(&tb.User{ID: approver.UserId}, *bot); err == nil && user.Telegram != nil {
+			names = append(names, GetUserStrMd(user.Telegram))
+		}
+	}
+	return fmt.Sprintf("%d/%d: %s", settings.SpendApprovals, len(names), strings.Join(names, ", "))
+}
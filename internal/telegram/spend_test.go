@@ -0,0 +1,96 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestSpendApprovals(t *testing.T) {
+	h := newTestHarness(t)
+	admin := &tb.User{ID: 9921, Username: "admin", FirstName: "Admin", LanguageCode: "en"}
+	treasurer := &tb.User{ID: 9922, Username: "treasurer", FirstName: "Treasurer", LanguageCode: "en"}
+	bob := &tb.User{ID: 9923, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	carol := &tb.User{ID: 9924, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	erin := &tb.User{ID: 9925, Username: "erin", FirstName: "Erin", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9920, Type: tb.ChatGroup, Title: "club"}
+	h.newUser(admin, 0)
+	wallet := h.newUser(treasurer, 1000)
+	h.newUser(bob, 0)
+	h.newUser(carol, 0)
+	to := h.newUser(erin, 0)
+	h.telegram.admins = []*tb.User{admin}
+
+	h.sendMessage(admin, group, "/spend 300 @erin snacks")
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "no approvers") {
+		t.Fatalf("message = %q", text)
+	}
+	h.sendMessage(admin, group, "/groupsettings wallet @treasurer")
+	h.sendMessage(admin, group, "/groupsettings approvals 2 @bob @carol @treasurer")
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "2/3") {
+		t.Fatalf("message = %q", text)
+	}
+
+	h.sendMessage(admin, group, "/spend 300 @erin snacks")
+	// only approvers decide, one approval is not enough
+	h.pressButton(admin, h.lastMessage(group.ID), "✅ Approve")
+	h.pressButton(bob, h.lastMessage(group.ID), "✅ Approve")
+	h.pressButton(carol, h.lastMessage(group.ID), "❌ Reject")
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 0 {
+		t.Fatalf("balance of erin = %d before the proposal was approved, want 0", balance)
+	}
+	h.pressButton(treasurer, h.lastMessage(group.ID), "✅ Approve")
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 300 {
+		t.Errorf("balance of erin = %d, want 300", balance)
+	}
+	if balance := h.lnbits.Balance(wallet.Wallet.ID); balance != 700 {
+		t.Errorf("balance of the group wallet = %d, want 700", balance)
+	}
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "Approved and paid") {
+		t.Errorf("proposal = %q", text)
+	}
+
+	// two rejections leave too few approvers
+	h.sendMessage(bob, group, "/spend 500 @erin")
+	h.pressButton(bob, h.lastMessage(group.ID), "❌ Reject")
+	h.pressButton(carol, h.lastMessage(group.ID), "❌ Reject")
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "Rejected") {
+		t.Errorf("proposal = %q", text)
+	}
+
+	h.sendMessage(carol, group, "/spend 100 @erin")
+	h.bot.expireSpendProposals(time.Now().Add(spendProposalDuration + time.Minute))
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "Expired") {
+		t.Errorf("proposal = %q", text)
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 300 {
+		t.Errorf("balance of erin = %d after a rejection and an expiry, want 300", balance)
+	}
+
+	h.sendMessage(bob, group, "/spend log")
+	text := h.lastMessage(group.ID).Text()
+	if !strings.Contains(text, "#1") || !strings.Contains(text, "paid") || !strings.Contains(text, "rejected") || !strings.Contains(text, "expired") {
+		t.Errorf("log = %q", text)
+	}
+
+	// a proposal whose payment an earlier attempt started is not marked as paid again
+	proposal := &SpendProposal{ChatId: group.ID, Recipient: "@erin", RecipientId: erin.ID, Amount: 50, Status: SpendPending, Expires: time.Now().Add(time.Hour)}
+	if tx := h.bot.DB.Groups.Create(proposal); tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	if err := h.bot.claimIdempotencyKey(fmt.Sprintf("spend:%d", proposal.ID)); err != nil {
+		t.Fatal(err)
+	}
+	h.bot.executeSpend(proposal)
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if proposal.Status != SpendPending {
+		t.Errorf("status = %q without a send, want %q", proposal.Status, SpendPending)
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 300 {
+		t.Errorf("balance of erin = %d, want 300", balance)
+	}
+}
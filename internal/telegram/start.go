@@ -5,6 +5,7 @@ import (
 	stderrors "errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
@@ -29,6 +30,8 @@ func (bot TipBot) startHandler(ctx intercept.Context) (intercept.Context, error)
 	// WILL RESULT IN AN ENDLESS LOOP OTHERWISE
 	// bot.helpHandler(m)
 	log.Printf("[⭐️ /start] New user: %s (%d)\n", GetUserStr(ctx.Sender()), ctx.Sender().ID)
+	existingUser := LoadUser(ctx)
+	newUser := existingUser == nil || existingUser.Wallet == nil || !existingUser.Initialized
 	walletCreationMsg := bot.trySendMessageEditable(ctx.Sender(), Translate(ctx, "startSettingWalletMessage"))
 	user, err := bot.initWallet(ctx.Sender())
 	if err != nil {
@@ -38,14 +41,32 @@ func (bot TipBot) startHandler(ctx intercept.Context) (intercept.Context, error)
 	}
 	bot.tryDeleteMessage(walletCreationMsg)
 	ctx.Context = context.WithValue(ctx, "user", user)
+	ctx.Context = context.WithValue(ctx, "newUser", newUser)
+
+	// deep links like t.me/<bot>?start=pay_<token> skip the welcome messages for existing users
+	startParameter := ctx.Message().Payload
+	if len(startParameter) > 0 && !newUser {
+		if ctx, ok, err := bot.startParameterHandler(ctx, startParameter); ok {
+			return ctx, err
+		}
+	}
+
 	bot.helpHandler(ctx)
 	bot.trySendMessage(ctx.Sender(), Translate(ctx, "startWalletReadyMessage"))
 	bot.balanceHandler(ctx)
+	// sends that waited for the user, unless the user opened the claim link of one
+	if !strings.HasPrefix(startParameter, escrowStartPrefix) {
+		bot.sendEscrowClaims(ctx)
+	}
 
 	// send the user a warning about the fact that they need to set a username
 	if len(ctx.Sender().Username) == 0 {
 		bot.trySendMessage(ctx.Sender(), Translate(ctx, "startNoUsernameMessage"), tb.NoPreview)
 	}
+	if len(startParameter) > 0 && newUser {
+		ctx, _, err = bot.startParameterHandler(ctx, startParameter)
+		return ctx, err
+	}
 	return ctx, nil
 }
 
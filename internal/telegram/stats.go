@@ -0,0 +1,145 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/chart"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+	"gorm.io/gorm"
+)
+
+const (
+	statsMonths         = 6 // months in the chart
+	statsCounterparties = 3
+)
+
+var (
+	statsSentColor     = color.RGBA{0xf7, 0x93, 0x1a, 0xff}
+	statsReceivedColor = color.RGBA{0x4c, 0xaf, 0x50, 0xff}
+)
+
+// UserStats are the lifetime statistics of a user.
+type UserStats struct {
+	Sent, SentCount         int64
+	Received, ReceivedCount int64
+	BiggestTip              *Transaction
+	Counterparties          []Counterparty
+	Months                  []MonthStats // oldest first
+}
+
+// Counterparty is a user who was tipped by the user.
+type Counterparty struct {
+	ToId   int64
+	ToUser string
+	Count  int64
+	Volume int64
+}
+
+type MonthStats struct {
+	Month    time.Time
+	Sent     int64
+	Received int64
+}
+
+// UserStats computes the statistics of the user with the Telegram id from the transactions.
+func (bot *TipBot) UserStats(id int64, now time.Time) (*UserStats, error) {
+	s := &UserStats{}
+	transactions := func() *gorm.DB {
+		// service fees are not transactions that the user made
+		return bot.DB.Transactions.Model(&Transaction{}).Where("success = ? AND type != ?", true, serviceFeeTransactionType)
+	}
+	var totals struct {
+		Count  int64
+		Volume int64
+	}
+	tx := transactions().Select("COUNT(*) AS count, COALESCE(SUM(amount), 0) AS volume").Where("from_id = ?", id).Scan(&totals)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	s.Sent, s.SentCount = totals.Volume, totals.Count
+	transactions().Select("COUNT(*) AS count, COALESCE(SUM(amount), 0) AS volume").Where("to_id = ?", id).Scan(&totals)
+	s.Received, s.ReceivedCount = totals.Volume, totals.Count
+	tip := &Transaction{}
+	tx = transactions().Where("from_id = ? AND type IN ?", id, []string{"tip", "tipall"}).Order("amount desc").Limit(1).Find(tip)
+	if tx.Error == nil && tx.RowsAffected > 0 {
+		s.BiggestTip = tip
+	}
+	transactions().Select("to_id, MAX(to_user) AS to_user, COUNT(*) AS count, SUM(amount) AS volume").
+		Where("from_id = ? AND to_id != ? AND type IN ?", id, id, []string{"tip", "tipall"}).
+		Group("to_id").Order("volume desc").Limit(statsCounterparties).Scan(&s.Counterparties)
+
+	first := time.Date(now.Year(), now.Month()-statsMonths+1, 1, 0, 0, 0, 0, now.Location())
+	for i := 0; i < statsMonths; i++ {
+		s.Months = append(s.Months, MonthStats{Month: first.AddDate(0, i, 0)})
+	}
+	var recent []Transaction
+	transactions().Select("time, amount, from_id, to_id").Where("(from_id = ? OR to_id = ?) AND time >= ?", id, id, first).Find(&recent)
+	for _, t := range recent {
+		month := t.Time.In(now.Location())
+		i := (month.Year()-first.Year())*12 + int(month.Month()-first.Month())
+		if i < 0 || i >= statsMonths {
+			continue
+		}
+		if t.FromId == id {
+			s.Months[i].Sent += t.Amount
+		}
+		if t.ToId == id {
+			s.Months[i].Received += t.Amount
+		}
+	}
+	return s, nil
+}
+
+// Chart draws the sent and received amounts per month.
+func (s UserStats) Chart() ([]byte, error) {
+	sent := chart.Series{Color: statsSentColor}
+	received := chart.Series{Color: statsReceivedColor}
+	for _, month := range s.Months {
+		sent.Values = append(sent.Values, month.Sent)
+		received.Values = append(received.Values, month.Received)
+	}
+	return chart.Bars{Width: 600, Height: 300, Series: []chart.Series{sent, received}}.PNG()
+}
+
+// statsHandler is invoked on /stats and sends the statistics of the user with a chart of the
+// last months.
+func (bot *TipBot) statsHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	stats, err := bot.UserStats(user.Telegram.ID, time.Now())
+	if err != nil {
+		log.Errorf("[statsHandler] %v", err)
+		return ctx, err
+	}
+	biggestTip := Translate(ctx, "statsNoTipMessage")
+	if stats.BiggestTip != nil {
+		biggestTip = fmt.Sprintf(Translate(ctx, "statsBiggestTipMessage"), stats.BiggestTip.Amount, str.MarkdownEscape(stats.BiggestTip.ToUser))
+	}
+	message := fmt.Sprintf(Translate(ctx, "statsMessage"), stats.Sent, stats.SentCount, stats.Received, stats.ReceivedCount, biggestTip)
+	if len(stats.Counterparties) > 0 {
+		message += Translate(ctx, "statsCounterpartiesMessage")
+		for i, counterparty := range stats.Counterparties {
+			message += fmt.Sprintf(Translate(ctx, "statsCounterpartyMessage"), i+1, str.MarkdownEscape(counterparty.ToUser), counterparty.Volume, counterparty.Count)
+		}
+	}
+	bot.trySendMessage(ctx.Sender(), message)
+
+	image, err := stats.Chart()
+	if err != nil {
+		log.Errorf("[statsHandler] could not draw the chart: %v", err)
+		return ctx, err
+	}
+	var months []string
+	for _, month := range stats.Months {
+		months = append(months, fmt.Sprintf(Translate(ctx, "statsMonthMessage"), month.Month.Format("2006-01"), month.Sent, month.Received))
+	}
+	caption := fmt.Sprintf(Translate(ctx, "statsChartMessage"), strings.Join(months, "\n"))
+	bot.trySendMessage(ctx.Sender(), &tb.Photo{File: tb.File{FileReader: bytes.NewReader(image)}, Caption: caption})
+	return ctx, nil
+}
@@ -0,0 +1,51 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestUserStats(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9601, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9602, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	carol := &tb.User{ID: 9603, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9600, Type: tb.ChatGroup, Title: "group"}
+	h.newUser(alice, 1000)
+	h.newUser(bob, 0)
+	h.newUser(carol, 0)
+
+	post := h.sendMessage(bob, group, "gm")
+	h.sendReply(alice, group, "/tip 100", post)
+	h.sendReply(alice, group, "/tip 300", post)
+	post = h.sendMessage(carol, group, "gm")
+	h.sendReply(alice, group, "/tip 200", post)
+
+	stats, err := h.bot.UserStats(alice.ID, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sent != 600 || stats.SentCount != 3 {
+		t.Errorf("sent = %d in %d, want 600 in 3", stats.Sent, stats.SentCount)
+	}
+	if stats.BiggestTip == nil || stats.BiggestTip.Amount != 300 {
+		t.Errorf("biggest tip = %+v, want 300", stats.BiggestTip)
+	}
+	if len(stats.Counterparties) != 2 || stats.Counterparties[0].ToId != bob.ID || stats.Counterparties[0].Volume != 400 {
+		t.Errorf("counterparties = %+v", stats.Counterparties)
+	}
+	if month := stats.Months[len(stats.Months)-1]; month.Sent != 600 {
+		t.Errorf("sent this month = %d, want 600", month.Sent)
+	}
+
+	h.sendMessage(alice, privateChat(alice), "/stats")
+	if !h.called("sendPhoto", alice.ID) {
+		t.Error("no chart was sent")
+	}
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, time.Now().Format("2006-01")+": 📤 600 sat") {
+		t.Errorf("caption of the chart = %q", text)
+	}
+}
@@ -0,0 +1,549 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	subscribeStartPrefix = "sub_"
+	// subscriptionReminder is how long before a subscription runs out its subscriber gets the
+	// invoice for the next period.
+	subscriptionReminder      = 3 * 24 * time.Hour
+	subscriptionGracePeriod   = 24 * time.Hour
+	subscriptionCheckInterval = 10 * time.Minute
+)
+
+var (
+	subscriptionMenu      = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnSubscribePlan      = subscriptionMenu.Data("📅 Subscribe", "subscribe_plan")
+	btnCancelSubscription = subscriptionMenu.Data("🚫 Cancel", "cancel_subscription")
+)
+
+// subscriptionPeriods are the billing periods of plans.
+var subscriptionPeriods = map[string]func(time.Time) time.Time{
+	"weekly":  func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+	"monthly": func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+	"yearly":  func(t time.Time) time.Time { return t.AddDate(1, 0, 0) },
+}
+
+// SubscriptionPlan is a paid membership of a group or a channel. Subscribers pay Price to the
+// creator of the plan every period.
+type SubscriptionPlan struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	ChatId    int64     `json:"chat_id" gorm:"index"`
+	ChatTitle string    `json:"chat_title"`
+	CreatorId int64     `json:"creator_id"`
+	Period    string    `json:"period"`
+	Price     int64     `json:"price"`
+	Active    bool      `json:"active"`
+}
+
+// Subscription is the membership of a user in the chat of a plan. The user stays in the chat
+// until PaidUntil and the grace period after it.
+type Subscription struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	PlanId    uint      `json:"plan_id" gorm:"index"`
+	ChatId    int64     `json:"chat_id" gorm:"index"`
+	UserId    int64     `json:"user_id" gorm:"index"`
+	PaidUntil time.Time `json:"paid_until"`
+	// Reminded is set once the invoice for the next period was sent
+	Reminded bool `json:"reminded"`
+	// Cancelled subscriptions are not renewed, they run out at PaidUntil
+	Cancelled    bool   `json:"cancelled"`
+	Active       bool   `json:"active"`
+	LanguageCode string `json:"language_code"`
+}
+
+func (plan *SubscriptionPlan) renew(paidUntil time.Time) time.Time {
+	if paidUntil.Before(time.Now()) {
+		paidUntil = time.Now()
+	}
+	return subscriptionPeriods[plan.Period](paidUntil)
+}
+
+func (s *Subscription) paidUntilStr() string {
+	return s.PaidUntil.UTC().Format("2 Jan 06 15:04 MST")
+}
+
+func (bot *TipBot) loadSubscriptionPlan(id string) (*SubscriptionPlan, error) {
+	planId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, errors.Create(errors.InvalidSyntaxError)
+	}
+	plan := &SubscriptionPlan{}
+	if tx := bot.DB.Groups.First(plan, planId); tx.Error != nil {
+		return nil, tx.Error
+	}
+	return plan, nil
+}
+
+func (bot *TipBot) loadSubscription(id string) (*Subscription, error) {
+	subscriptionId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, errors.Create(errors.InvalidSyntaxError)
+	}
+	subscription := &Subscription{}
+	if tx := bot.DB.Groups.First(subscription, subscriptionId); tx.Error != nil {
+		return nil, tx.Error
+	}
+	return subscription, nil
+}
+
+// subscriptionChat is the chat that /plan manages: the channel if the command replies to a
+// post of a channel in its comments, the group otherwise.
+func subscriptionChat(m *tb.Message) *tb.Chat {
+	if _, _, ok := channelPost(m.ReplyTo); ok {
+		return m.ReplyTo.SenderChat
+	}
+	return m.Chat
+}
+
+// planHandler is invoked on /plan [add <period> <price>|remove <period>] in a group or in the
+// comments of a channel post. Admins of the chat sell memberships with plans, the payments
+// go to the admin who added the plan.
+func (bot *TipBot) planHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Sender, Translate(ctx, "planHelpMessage"))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	chat := subscriptionChat(m)
+	if !bot.isAdmin(chat, m.Sender) {
+		return ctx, fmt.Errorf("[/plan] %s is not admin of %d", GetUserStr(m.Sender), chat.ID)
+	}
+	args := strings.Fields(m.Text)
+	if len(args) == 1 {
+		return bot.listPlansHandler(ctx, chat)
+	}
+	switch strings.ToLower(args[1]) {
+	case "add":
+		return bot.addPlanHandler(ctx, chat)
+	case "remove":
+		return bot.removePlanHandler(ctx, chat)
+	}
+	bot.trySendMessage(bot.topicOf(m), Translate(ctx, "planHelpMessage"))
+	return ctx, errors.Create(errors.InvalidSyntaxError)
+}
+
+// addPlanHandler is invoked on /plan add <weekly|monthly|yearly> <price>. Adding a plan with the
+// period of an existing plan changes its price from the next renewal on.
+func (bot *TipBot) addPlanHandler(ctx intercept.Context, chat *tb.Chat) (intercept.Context, error) {
+	m := ctx.Message()
+	args := strings.Fields(m.Text)
+	if len(args) != 4 || subscriptionPeriods[strings.ToLower(args[2])] == nil {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "planHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	price, err := GetAmount(args[3])
+	if err != nil || price < 1 {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "planHelpMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	// the bot invites subscribers and removes them when their subscription runs out
+	if !bot.isAdminAndCanInviteUsers(chat, bot.Telegram.Me) {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "groupBotIsNotAdminMessage"))
+		return ctx, fmt.Errorf("bot is not admin of %d", chat.ID)
+	}
+	period := strings.ToLower(args[2])
+	plan := &SubscriptionPlan{}
+	bot.DB.Groups.Where("chat_id = ? AND period = ? AND active = ?", chat.ID, period, true).First(plan)
+	plan.ChatId = chat.ID
+	plan.ChatTitle = chat.Title
+	plan.CreatorId = m.Sender.ID
+	plan.Period = period
+	plan.Price = price
+	plan.Active = true
+	if plan.ID == 0 {
+		plan.CreatedAt = time.Now()
+	}
+	if tx := bot.DB.Groups.Save(plan); tx.Error != nil {
+		log.Errorf("[/plan] could not save plan of %d: %v", chat.ID, tx.Error)
+		return ctx, tx.Error
+	}
+	logger(ctx).Infof("[/plan] %s added a %s plan for %d sat to %s (%d)", GetUserStr(m.Sender), period, price, chat.Title, chat.ID)
+	bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "planAddedMessage"), period, price, bot.subscribeLink(chat.ID)), tb.NoPreview)
+	return ctx, nil
+}
+
+// removePlanHandler is invoked on /plan remove <period>. Subscribers of the plan keep their
+// membership until it runs out, it is not renewed.
+func (bot *TipBot) removePlanHandler(ctx intercept.Context, chat *tb.Chat) (intercept.Context, error) {
+	m := ctx.Message()
+	period, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "planHelpMessage"))
+		return ctx, err
+	}
+	tx := bot.DB.Groups.Model(&SubscriptionPlan{}).Where("chat_id = ? AND period = ? AND active = ?", chat.ID, strings.ToLower(period), true).Update("active", false)
+	if tx.Error != nil {
+		return ctx, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "planNotFoundMessage"))
+		return ctx, fmt.Errorf("no %s plan in %d", period, chat.ID)
+	}
+	bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "planRemovedMessage"), strings.ToLower(period)))
+	return ctx, nil
+}
+
+// listPlansHandler lists the plans of the chat and how many members subscribed to them.
+func (bot *TipBot) listPlansHandler(ctx intercept.Context, chat *tb.Chat) (intercept.Context, error) {
+	m := ctx.Message()
+	plans := bot.subscriptionPlans(chat.ID)
+	if len(plans) == 0 {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "planHelpMessage"))
+		return ctx, nil
+	}
+	list := ""
+	for _, plan := range plans {
+		var subscribers int64
+		bot.DB.Groups.Model(&Subscription{}).Where("plan_id = ? AND active = ?", plan.ID, true).Count(&subscribers)
+		list += fmt.Sprintf(Translate(ctx, "planListEntry"), plan.Period, plan.Price, subscribers)
+	}
+	bot.trySendMessage(bot.topicOf(m), fmt.Sprintf(Translate(ctx, "planListMessage"), list, bot.subscribeLink(chat.ID)), tb.NoPreview)
+	return ctx, nil
+}
+
+func (bot *TipBot) subscriptionPlans(chatId int64) []SubscriptionPlan {
+	plans := make([]SubscriptionPlan, 0)
+	bot.DB.Groups.Where("chat_id = ? AND active = ?", chatId, true).Order("price").Find(&plans)
+	return plans
+}
+
+// subscribeLink is the deep link that shows the plans of a chat in the private chat.
+func (bot *TipBot) subscribeLink(chatId int64) string {
+	return bot.deepLink(subscribeStartPrefix + strconv.FormatInt(chatId, 10))
+}
+
+// subscribeHandler is invoked on /subscribe [<chat id>]. With a chat, it shows the plans of the
+// chat with buttons to subscribe, without it lists the subscriptions of the user.
+func (bot *TipBot) subscribeHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	arg, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		return bot.listSubscriptionsHandler(ctx)
+	}
+	return bot.showPlansHandler(ctx, arg)
+}
+
+func (bot *TipBot) startSubscribeHandler(ctx intercept.Context, chatId string) (intercept.Context, error) {
+	return bot.showPlansHandler(ctx, chatId)
+}
+
+// showPlansHandler sends the plans of a chat with a button for each.
+func (bot *TipBot) showPlansHandler(ctx intercept.Context, chatId string) (intercept.Context, error) {
+	sender := ctx.Sender()
+	id, err := strconv.ParseInt(chatId, 10, 64)
+	plans := bot.subscriptionPlans(id)
+	if err != nil || len(plans) == 0 {
+		bot.trySendMessage(sender, Translate(ctx, "planNotFoundMessage"))
+		return ctx, fmt.Errorf("no plans for %s", chatId)
+	}
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	rows := make([]tb.Row, 0, len(plans))
+	for _, plan := range plans {
+		label := fmt.Sprintf(Translate(ctx, "subscribeButtonMessage"), plan.Period, plan.Price)
+		rows = append(rows, menu.Row(menu.Data(label, "subscribe_plan", strconv.FormatUint(uint64(plan.ID), 10))))
+	}
+	menu.Inline(rows...)
+	bot.trySendMessage(sender, fmt.Sprintf(Translate(ctx, "subscribePlansMessage"), str.MarkdownEscape(plans[0].ChatTitle)), menu)
+	return ctx, nil
+}
+
+// subscribePlanHandler is invoked when a user presses the button of a plan. It sends the
+// invoice of the first period, the subscription starts once it is paid.
+func (bot *TipBot) subscribePlanHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	plan, err := bot.loadSubscriptionPlan(ctx.Data())
+	if err != nil || !plan.Active {
+		bot.trySendMessage(c.Sender, Translate(ctx, "planNotFoundMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	subscription := &Subscription{}
+	tx := bot.DB.Groups.Where("chat_id = ? AND user_id = ? AND active = ?", plan.ChatId, c.Sender.ID, true).First(subscription)
+	if tx.Error == nil && !subscription.Cancelled {
+		bot.trySendMessage(c.Sender, fmt.Sprintf(Translate(ctx, "subscriptionExistsMessage"), str.MarkdownEscape(plan.ChatTitle), subscription.paidUntilStr()))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if tx.Error != nil {
+		subscription = &Subscription{CreatedAt: time.Now(), ChatId: plan.ChatId, UserId: c.Sender.ID}
+	}
+	// a cancelled subscription continues with the new plan
+	subscription.PlanId = plan.ID
+	subscription.Cancelled = false
+	subscription.LanguageCode = c.Sender.LanguageCode
+	if tx := bot.DB.Groups.Save(subscription); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	if err := bot.sendSubscriptionInvoice(subscription, plan); err != nil {
+		bot.trySendMessage(c.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	logger(ctx).Infof("[/subscribe] %s wants to subscribe to the %s plan of %d", GetUserStr(c.Sender), plan.Period, plan.ChatId)
+	return ctx, nil
+}
+
+// sendSubscriptionInvoice sends the subscriber an invoice of the creator of the plan for the
+// next period of the subscription.
+func (bot *TipBot) sendSubscriptionInvoice(subscription *Subscription, plan *SubscriptionPlan) error {
+	creator, err := GetLnbitsUser(&tb.User{ID: plan.CreatorId}, *bot)
+	if err != nil || creator.Wallet == nil {
+		return fmt.Errorf("creator %d of plan %d has no wallet", plan.CreatorId, plan.ID)
+	}
+	subscriber, err := GetLnbitsUser(&tb.User{ID: subscription.UserId}, *bot)
+	if err != nil {
+		return err
+	}
+	memo := fmt.Sprintf("📅 %s subscription of %s", plan.Period, plan.ChatTitle)
+	ctx := context.WithValue(context.Background(), "publicLanguageCode", subscription.LanguageCode)
+	invoice, err := bot.createInvoiceWithEvent(ctx, creator, plan.Price, memo, "", InvoiceCallbackSubscription, strconv.FormatUint(uint64(subscription.ID), 10))
+	if err != nil {
+		return err
+	}
+	qr, err := qrcode.Encode(invoice.PaymentRequest, qrcode.Medium, 256)
+	if err != nil {
+		return err
+	}
+	caption := fmt.Sprintf(i18n.Translate(subscription.LanguageCode, "subscriptionInvoiceMessage"), str.MarkdownEscape(plan.ChatTitle), plan.Period, plan.Price, invoice.PaymentRequest)
+	invoice.Payer = subscriber
+	invoice.InvoiceMessage = bot.trySendMessage(subscriber.Telegram, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: caption})
+	runtime.IgnoreError(bot.Bunt.Set(invoice))
+	return nil
+}
+
+// subscriptionPaidEvent is invoked when the invoice of a subscription is paid. It extends the
+// subscription by a period and invites new and returning subscribers to the chat.
+func (bot *TipBot) subscriptionPaidEvent(event Event) {
+	invoiceEvent := event.(*InvoiceEvent)
+	id := fmt.Sprintf("subscription:%s", invoiceEvent.CallbackData)
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	subscription, err := bot.loadSubscription(invoiceEvent.CallbackData)
+	if err != nil {
+		log.Errorf("[subscriptionPaidEvent] %s: %v", id, err)
+		return
+	}
+	plan, err := bot.loadSubscriptionPlan(strconv.FormatUint(uint64(subscription.PlanId), 10))
+	if err != nil {
+		log.Errorf("[subscriptionPaidEvent] plan of %s: %v", id, err)
+		return
+	}
+	invite := !subscription.Active
+	subscription.PaidUntil = plan.renew(subscription.PaidUntil)
+	subscription.Active = true
+	subscription.Reminded = false
+	if tx := bot.DB.Groups.Save(subscription); tx.Error != nil {
+		log.Errorf("[subscriptionPaidEvent] could not save %s: %v", id, tx.Error)
+		return
+	}
+	subscriber := invoiceEvent.Payer
+	creator := invoiceEvent.User
+	if subscriber == nil || subscriber.Telegram == nil {
+		subscriber = &lnbits.User{Telegram: &tb.User{ID: subscription.UserId}}
+	}
+	// label the payment in /transactions
+	bot.saveCounterparty(invoiceEvent.PaymentHash, creator.Wallet.ID, CounterpartyTypeTelegram, GetUserStr(subscriber.Telegram))
+	bot.savePaymentComment(invoiceEvent.PaymentHash, creator.Wallet.ID, fmt.Sprintf("📅 Subscription: %s", plan.ChatTitle))
+	log.Infof("[📅 subscription] %s paid %d sat for the %s plan of %s until %s", GetUserStr(subscriber.Telegram), invoiceEvent.Amount, plan.Period, plan.ChatTitle, subscription.paidUntilStr())
+
+	if invoiceEvent.InvoiceMessage != nil {
+		if _, err := bot.Telegram.EditCaption(invoiceEvent.InvoiceMessage, i18n.Translate(invoiceEvent.LanguageCode, "invoicePaidText")); err != nil {
+			log.Warnln(err.Error())
+		}
+	}
+	message := fmt.Sprintf(i18n.Translate(subscription.LanguageCode, "subscriptionRenewedMessage"), str.MarkdownEscape(plan.ChatTitle), subscription.paidUntilStr())
+	if invite {
+		link, err := bot.createInviteLink(plan.ChatId, fmt.Sprintf("%s subscription of %s", GetUserStr(bot.Telegram.Me), GetUserStr(subscriber.Telegram)))
+		if err != nil {
+			log.Errorf("[subscriptionPaidEvent] could not create an invite link to %d: %v", plan.ChatId, err)
+			bot.alertOperator(fmt.Sprintf("🚨 Subscription %d was paid, but the bot could not invite %s to %s: %v", subscription.ID, GetUserStr(subscriber.Telegram), plan.ChatTitle, err))
+		}
+		message = fmt.Sprintf(i18n.Translate(subscription.LanguageCode, "subscriptionStartedMessage"), str.MarkdownEscape(plan.ChatTitle), subscription.paidUntilStr(), link)
+	}
+	bot.trySendMessage(subscriber.Telegram, message, bot.makeSubscriptionKeyboard(subscription))
+	bot.trySendMessage(creator.Telegram, fmt.Sprintf(i18n.Translate(creator.Telegram.LanguageCode, "subscriptionPaidCreatorMessage"), GetUserStrMd(subscriber.Telegram), invoiceEvent.Amount, plan.Period, str.MarkdownEscape(plan.ChatTitle)))
+}
+
+// createInviteLink creates an invite link to the chat that only one user can join with.
+func (bot *TipBot) createInviteLink(chatId int64, name string) (string, error) {
+	params := map[string]interface{}{
+		"chat_id":      chatId,
+		"name":         name,
+		"member_limit": 1,
+	}
+	data, err := bot.Telegram.Raw("createChatInviteLink", params)
+	if err != nil {
+		return "", err
+	}
+	var resp ChatInviteLink
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", err
+	}
+	return resp.Result.InviteLink, nil
+}
+
+func (bot *TipBot) makeSubscriptionKeyboard(subscription *Subscription) *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	if subscription.Cancelled {
+		return menu
+	}
+	cancelButton := menu.Data(i18n.Translate(subscription.LanguageCode, "cancelButtonMessage"), "cancel_subscription", strconv.FormatUint(uint64(subscription.ID), 10))
+	menu.Inline(menu.Row(cancelButton))
+	return menu
+}
+
+// listSubscriptionsHandler lists the active subscriptions of the user.
+func (bot *TipBot) listSubscriptionsHandler(ctx intercept.Context) (intercept.Context, error) {
+	sender := ctx.Sender()
+	subscriptions := make([]Subscription, 0)
+	bot.DB.Groups.Where("user_id = ? AND active = ?", sender.ID, true).Order("paid_until").Find(&subscriptions)
+	if len(subscriptions) == 0 {
+		bot.trySendMessage(sender, Translate(ctx, "subscriptionNoneMessage"))
+		return ctx, nil
+	}
+	for i := range subscriptions {
+		subscription := &subscriptions[i]
+		plan, err := bot.loadSubscriptionPlan(strconv.FormatUint(uint64(subscription.PlanId), 10))
+		if err != nil {
+			continue
+		}
+		key := "subscriptionEntryMessage"
+		if subscription.Cancelled || !plan.Active {
+			key = "subscriptionEndingEntryMessage"
+		}
+		bot.trySendMessage(sender, fmt.Sprintf(Translate(ctx, key), str.MarkdownEscape(plan.ChatTitle), plan.Period, plan.Price, subscription.paidUntilStr()), bot.makeSubscriptionKeyboard(subscription))
+	}
+	return ctx, nil
+}
+
+// cancelSubscriptionHandler stops the renewal of a subscription. The subscriber stays in the
+// chat until the paid period is over.
+func (bot *TipBot) cancelSubscriptionHandler(ctx intercept.Context) (intercept.Context, error) {
+	id := fmt.Sprintf("subscription:%s", ctx.Data())
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	subscription, err := bot.loadSubscription(ctx.Data())
+	if err != nil {
+		return ctx, err
+	}
+	if subscription.UserId != ctx.Sender().ID {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	subscription.Cancelled = true
+	if tx := bot.DB.Groups.Save(subscription); tx.Error != nil {
+		return ctx, tx.Error
+	}
+	logger(ctx).Infof("[/subscribe] %s cancelled subscription %d", GetUserStr(ctx.Sender()), subscription.ID)
+	bot.tryEditMessage(ctx.Message(), fmt.Sprintf(i18n.Translate(subscription.LanguageCode, "subscriptionCancelledMessage"), subscription.paidUntilStr()), &tb.ReplyMarkup{})
+	return ctx, nil
+}
+
+// startSubscriptionWorker periodically sends the invoices of subscriptions that run out soon
+// and removes the members whose subscription ran out.
+func (bot *TipBot) startSubscriptionWorker() {
+	go func() {
+		for {
+			if bot.walletBackendAvailable() && !isShuttingDown() {
+				for _, id := range bot.dueSubscriptions() {
+					bot.processSubscription(id)
+				}
+			}
+			time.Sleep(subscriptionCheckInterval)
+		}
+	}()
+}
+
+// dueSubscriptions returns the ids of active subscriptions that need a reminder or ran out.
+func (bot *TipBot) dueSubscriptions() []uint {
+	ids := make([]uint, 0)
+	bot.DB.Groups.Model(&Subscription{}).
+		Where("active = ? AND (paid_until < ? OR (reminded = ? AND paid_until < ?))", true, time.Now().Add(-subscriptionGracePeriod), false, time.Now().Add(subscriptionReminder)).
+		Pluck("id", &ids)
+	return ids
+}
+
+func (bot *TipBot) processSubscription(subscriptionId uint) {
+	beginInFlight()
+	defer endInFlight()
+	// other instances of a cluster work on the same subscriptions
+	id := fmt.Sprintf("subscription:%d", subscriptionId)
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	subscription, err := bot.loadSubscription(strconv.FormatUint(uint64(subscriptionId), 10))
+	if err != nil {
+		log.Errorf("[subscription] %s: %v", id, err)
+		return
+	}
+	if !subscription.Active {
+		return
+	}
+	plan, err := bot.loadSubscriptionPlan(strconv.FormatUint(uint64(subscription.PlanId), 10))
+	if err != nil {
+		log.Errorf("[subscription] plan of %s: %v", id, err)
+		return
+	}
+	subscriber, err := GetLnbitsUser(&tb.User{ID: subscription.UserId}, *bot)
+	if err != nil || subscriber.Telegram == nil {
+		subscriber = &lnbits.User{Telegram: &tb.User{ID: subscription.UserId}}
+	}
+	switch {
+	case time.Now().After(subscription.PaidUntil.Add(subscriptionGracePeriod)):
+		subscription.Active = false
+		if tx := bot.DB.Groups.Save(subscription); tx.Error != nil {
+			log.Errorf("[subscription] could not save %s: %v", id, tx.Error)
+			return
+		}
+		bot.removeSubscriber(plan.ChatId, subscriber.Telegram)
+		log.Infof("[📅 subscription] subscription %d of %d to %s ran out", subscription.ID, subscription.UserId, plan.ChatTitle)
+		bot.trySendMessage(subscriber.Telegram, fmt.Sprintf(i18n.Translate(subscription.LanguageCode, "subscriptionEndedMessage"), str.MarkdownEscape(plan.ChatTitle), bot.subscribeLink(plan.ChatId)), tb.NoPreview)
+		if creator, err := GetLnbitsUser(&tb.User{ID: plan.CreatorId}, *bot); err == nil {
+			bot.trySendMessage(creator.Telegram, fmt.Sprintf(i18n.Translate(creator.Telegram.LanguageCode, "subscriptionEndedCreatorMessage"), GetUserStrMd(subscriber.Telegram), str.MarkdownEscape(plan.ChatTitle)))
+		}
+	case !subscription.Reminded:
+		subscription.Reminded = true
+		if tx := bot.DB.Groups.Save(subscription); tx.Error != nil {
+			log.Errorf("[subscription] could not save %s: %v", id, tx.Error)
+			return
+		}
+		if subscription.Cancelled || !plan.Active {
+			bot.trySendMessage(subscriber.Telegram, fmt.Sprintf(i18n.Translate(subscription.LanguageCode, "subscriptionEndingMessage"), str.MarkdownEscape(plan.ChatTitle), subscription.paidUntilStr()))
+			return
+		}
+		bot.trySendMessage(subscriber.Telegram, fmt.Sprintf(i18n.Translate(subscription.LanguageCode, "subscriptionReminderMessage"), str.MarkdownEscape(plan.ChatTitle), subscription.paidUntilStr()), bot.makeSubscriptionKeyboard(subscription))
+		if err := bot.sendSubscriptionInvoice(subscription, plan); err != nil {
+			log.Errorf("[subscription] could not send the invoice of %s: %v", id, err)
+		}
+	}
+}
+
+// removeSubscriber removes a user from a chat. The user is unbanned right away, a new
+// subscription invites them again.
+func (bot *TipBot) removeSubscriber(chatId int64, user *tb.User) {
+	chat := &tb.Chat{ID: chatId}
+	if err := bot.Telegram.Ban(chat, &tb.ChatMember{User: user}); err != nil {
+		log.Errorf("[subscription] could not remove %d from %d: %v", user.ID, chatId, err)
+		return
+	}
+	if err := bot.Telegram.Unban(chat, user, true); err != nil {
+		log.Warnf("[subscription] could not unban %d in %d: %v", user.ID, chatId, err)
+	}
+}
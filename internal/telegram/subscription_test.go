@@ -0,0 +1,90 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestSubscription(t *testing.T) {
+	h := newTestHarness(t)
+	creator := &tb.User{ID: 9701, Username: "creator", FirstName: "Creator", LanguageCode: "en"}
+	member := &tb.User{ID: 9702, Username: "member", FirstName: "Member", LanguageCode: "en"}
+	group := &tb.Chat{ID: -9700, Type: tb.ChatSuperGroup, Title: "club"}
+	to := h.newUser(creator, 0)
+	from := h.newUser(member, 5000)
+
+	// only admins sell memberships
+	h.sendMessage(member, group, "/plan add monthly 1000")
+	if plans := h.bot.subscriptionPlans(group.ID); len(plans) != 0 {
+		t.Fatalf("a member added plans: %+v", plans)
+	}
+	h.telegram.admins = []*tb.User{creator, testBotUser}
+	h.sendMessage(creator, group, "/plan add monthly 1000")
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "?start=sub_-9700") {
+		t.Fatalf("message after adding a plan = %q", text)
+	}
+
+	// the member pays the invoice of the first month and gets an invite link
+	pay := func() {
+		t.Helper()
+		parts := strings.Split(h.lastMessage(member.ID).Text(), "`")
+		if len(parts) < 3 {
+			t.Fatalf("invoice message = %q", h.lastMessage(member.ID).Text())
+		}
+		paid, err := h.bot.Client.Pay(*from.Wallet, lnbits.PaymentParams{Out: true, Bolt11: parts[1]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		event := &InvoiceEvent{Invoice: &Invoice{PaymentHash: paid.PaymentHash}}
+		if err := h.bot.Bunt.Get(event); err != nil {
+			t.Fatal(err)
+		}
+		h.bot.subscriptionPaidEvent(event)
+		h.sent = append(h.sent, h.telegram.Requests()...)
+	}
+	h.sendMessage(member, privateChat(member), "/subscribe -9700")
+	h.pressButton(member, h.lastMessage(member.ID), "📅 monthly: 1000 sat")
+	pay()
+	if text := h.lastMessage(member.ID).Text(); !strings.Contains(text, "https://t.me/+invite") {
+		t.Fatalf("message after subscribing = %q", text)
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of the creator = %d, want 1000", balance)
+	}
+	subscription := &Subscription{}
+	h.bot.DB.Groups.Where("user_id = ?", member.ID).First(subscription)
+	if !subscription.Active || time.Until(subscription.PaidUntil) < 27*24*time.Hour {
+		t.Fatalf("subscription = %+v", subscription)
+	}
+
+	// shortly before it runs out, the member gets the invoice of the next month
+	h.bot.DB.Groups.Model(subscription).Update("paid_until", time.Now().Add(time.Hour))
+	h.bot.processSubscription(subscription.ID)
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	pay()
+	if text := h.lastMessage(member.ID).Text(); !strings.Contains(text, "now runs until") {
+		t.Fatalf("message after renewing = %q", text)
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 2000 {
+		t.Errorf("balance of the creator = %d, want 2000", balance)
+	}
+
+	// subscriptions that ran out are removed from the chat
+	h.bot.DB.Groups.Model(subscription).Update("paid_until", time.Now().Add(-2*subscriptionGracePeriod))
+	h.bot.processSubscription(subscription.ID)
+	h.sent = append(h.sent, h.telegram.Requests()...)
+	if !h.called("banChatMember", group.ID) {
+		t.Error("the member was not removed")
+	}
+	h.bot.DB.Groups.First(subscription, subscription.ID)
+	if subscription.Active {
+		t.Error("the subscription is still active")
+	}
+	if text := h.lastMessage(member.ID).Text(); !strings.Contains(text, "ran out") {
+		t.Errorf("message after running out = %q", text)
+	}
+}
@@ -43,16 +43,22 @@ func (bot TipBot) trySendMessage(to tb.Recipient, what interface{}, options ...i
 		return
 	}
 	log.Tracef("[trySendMessage] chatId: %d", chatId)
-	msg, err = bot.Telegram.Send(to, what, bot.appendMainMenu(chatId, to, options)...)
+	options, keep := withoutKeep(topicOptions(to, options))
+	msg, err = bot.Telegram.Send(to, bot.labelAmounts(chatId, what), bot.appendMainMenu(chatId, to, options)...)
 	if err != nil {
 		log.Warnln(err.Error())
+		return
+	}
+	if !keep && !hasButtons(options) {
+		bot.scheduleDeletion(msg)
 	}
 	return
 }
 
 func (bot TipBot) trySendMessageEditable(to tb.Recipient, what interface{}, options ...interface{}) (msg *tb.Message) {
 	rate.CheckLimit(to)
-	msg, err := bot.Telegram.Send(to, what, options...)
+	chatId, _ := bot.getChatIdFromRecipient(to)
+	msg, err := bot.Telegram.Send(to, bot.labelAmounts(chatId, what), topicOptions(to, options)...)
 	if err != nil {
 		log.Warnln(err.Error())
 	}
@@ -61,9 +67,14 @@ func (bot TipBot) trySendMessageEditable(to tb.Recipient, what interface{}, opti
 
 func (bot TipBot) tryReplyMessage(to *tb.Message, what interface{}, options ...interface{}) (msg *tb.Message) {
 	rate.CheckLimit(to)
-	msg, err := bot.Telegram.Reply(to, what, bot.appendMainMenu(to.Chat.ID, to, options)...)
+	options, keep := withoutKeep(options)
+	msg, err := bot.Telegram.Reply(to, bot.labelAmounts(to.Chat.ID, what), bot.appendMainMenu(to.Chat.ID, to, options)...)
 	if err != nil {
 		log.Warnln(err.Error())
+		return
+	}
+	if !keep && !hasButtons(options) {
+		bot.scheduleDeletion(msg)
 	}
 	return
 }
@@ -78,13 +89,29 @@ func (bot TipBot) tryEditMessage(to tb.Editable, what interface{}, options ...in
 
 	_, chatId := to.MessageSig()
 	log.Tracef("[tryEditMessage] sig: %s, chatId: %d", sig, chatId)
-	msg, err = bot.Telegram.Edit(to, what, options...)
+	msg, err = bot.Telegram.Edit(to, bot.labelAmounts(chatId, what), options...)
 	if err != nil {
 		log.Warnln(err.Error())
 	}
 	return
 }
 
+// tryReact sets an emoji reaction on a message. Quiet groups get reactions instead of replies.
+func (bot TipBot) tryReact(msg *tb.Message, emoji string) {
+	if msg == nil || msg.Chat == nil {
+		return
+	}
+	rate.CheckLimit(strconv.FormatInt(msg.Chat.ID, 10))
+	params := map[string]interface{}{
+		"chat_id":    strconv.FormatInt(msg.Chat.ID, 10),
+		"message_id": strconv.Itoa(msg.ID),
+		"reaction":   []map[string]string{{"type": "emoji", "emoji": emoji}},
+	}
+	if _, err := bot.Telegram.Raw("setMessageReaction", params); err != nil {
+		log.Warnf("[tryReact] %s", err.Error())
+	}
+}
+
 func (bot TipBot) tryDeleteMessage(msg tb.Editable) {
 	if !allowedToPerformAction(bot, msg, isAdminAndCanDelete) {
 		return
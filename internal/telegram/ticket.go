@@ -11,11 +11,11 @@ import (
 	"github.com/LightningTipBot/LightningTipBot/internal/errors"
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
 	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
 	"github.com/LightningTipBot/LightningTipBot/internal/storage"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
 	log "github.com/sirupsen/logrus"
 	"github.com/skip2/go-qrcode"
-	"github.com/tidwall/buntdb"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
 
@@ -54,13 +54,12 @@ func (bot *TipBot) handleTelegramNewMember(ctx intercept.Context) (intercept.Con
 		Ticket: group.Ticket,
 	}
 	// group owner creates invoice
-	invoice, err := ownerUser.Wallet.Invoice(
+	invoice, err := bot.Client.CreateInvoice(*ownerUser.Wallet,
 		lnbits.InvoiceParams{
 			Out:     false,
 			Amount:  ticket.Ticket.Price,
 			Memo:    ticket.Ticket.Memo,
-			Webhook: internal.Configuration.Lnbits.WebhookServer},
-		bot.Client)
+			Webhook: internal.Configuration.Lnbits.WebhookServer})
 	if err != nil {
 		errmsg := fmt.Sprintf("[handleTelegramNewMember] Could not create an invoice: %s", err.Error())
 		log.Errorln(errmsg)
@@ -82,6 +81,7 @@ func (bot *TipBot) handleTelegramNewMember(ctx intercept.Context) (intercept.Con
 			Callback:     InvoiceCallbackPayJoinTicket,
 			CallbackData: "",
 			LanguageCode: ctx.Value("publicLanguageCode").(string),
+			Open:         true,
 		},
 		Group: group,
 		Base:  storage.New(storage.ID(fmt.Sprintf("ticket-event:%s", id))),
@@ -145,6 +145,8 @@ func (bot *TipBot) handleTelegramNewMember(ctx intercept.Context) (intercept.Con
 func (bot *TipBot) stopJoinTicketTimer(event Event) {
 	ev := event.(*InvoiceEvent)
 	ticket := JoinTicket{Sender: ev.Payer.Telegram, Message: ev.Message}
+	mutex.Lock(ticket.Key())
+	defer mutex.Unlock(ticket.Key())
 	err := bot.Bunt.Get(&ticket)
 	if err != nil {
 		log.Errorf("[stopJoinTicketTimer] %v", err)
@@ -156,17 +158,16 @@ func (bot *TipBot) stopJoinTicketTimer(event Event) {
 			log.Errorf("[stopJoinTicketTimer] %v", err)
 			return
 		}
-		invoice, err := me.Wallet.Invoice(
+		invoice, err := bot.Client.CreateInvoice(*me.Wallet,
 			lnbits.InvoiceParams{
 				Out:    false,
 				Amount: commission,
-				Memo:   fmt.Sprintf("Ticket %d", ticket.Message.Chat.ID)},
-			bot.Client)
+				Memo:   fmt.Sprintf("Ticket %d", ticket.Message.Chat.ID)})
 		if err != nil {
 			log.Errorf("[stopJoinTicketTimer] %v", err)
 			return
 		}
-		_, err = ticket.Ticket.Creator.Wallet.Pay(lnbits.PaymentParams{Bolt11: invoice.PaymentRequest, Out: true}, bot.Client)
+		_, err = bot.Client.Pay(*ticket.Ticket.Creator.Wallet, lnbits.PaymentParams{Bolt11: invoice.PaymentRequest, Out: true})
 		if err != nil {
 			log.Errorf("[stopJoinTicketTimer] %v", err)
 			return
@@ -196,7 +197,12 @@ func (bot *TipBot) startTicketCallbackFunctionTimer(ticket JoinTicket) {
 		runtime.WithTimer(time.NewTimer(ticketDuration)))
 	// run the ticket callback function
 	t.Do(func() {
-		// ticket expired
+		// ticket expired. with several instances, the ticket may have been paid on another one
+		mutex.Lock(ticket.Key())
+		defer mutex.Unlock(ticket.Key())
+		if exists, err := bot.Bunt.Exists(&ticket); err != nil || !exists {
+			return
+		}
 		member, err := bot.Telegram.ChatMemberOf(ticket.Message.Chat, ticket.Sender)
 		if err != nil {
 			log.Errorln("🧨 could not fetch / ban chat member")
@@ -217,17 +223,14 @@ func (bot *TipBot) startTicketCallbackFunctionTimer(ticket JoinTicket) {
 
 // restartPersistedTickets kicks of all ticket timers
 func (bot *TipBot) restartPersistedTickets() {
-	bot.Bunt.View(func(tx *buntdb.Tx) error {
-		err := tx.Ascend("join-ticket", func(key, value string) bool {
-			ticket := JoinTicket{}
-			err := json.Unmarshal([]byte(value), &ticket)
-			if err != nil {
-				return true
-			}
-			bot.startTicketCallbackFunctionTimer(ticket)
-			return true // continue iteration
-		})
-		return err
+	bot.Bunt.Ascend("join-ticket", func(key, value string) bool {
+		ticket := JoinTicket{}
+		err := json.Unmarshal([]byte(value), &ticket)
+		if err != nil {
+			return true
+		}
+		bot.startTicketCallbackFunctionTimer(ticket)
+		return true // continue iteration
 	})
 }
 func getTicketCommission(ticket *Ticket) int64 {
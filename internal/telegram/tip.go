@@ -13,10 +13,13 @@ import (
 	"github.com/LightningTipBot/LightningTipBot/internal/str"
 
 	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
-	log "github.com/sirupsen/logrus"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
 
+// tipReaction is the reaction on tipped messages in quiet groups.
+const tipReaction = "⚡"
+
 func helpTipUsage(ctx context.Context, errormsg string) string {
 	if len(errormsg) > 0 {
 		return fmt.Sprintf(Translate(ctx, "tipHelpText"), fmt.Sprintf("%s", errormsg))
@@ -63,7 +66,7 @@ func (bot *TipBot) tipHandler(ctx intercept.Context) (intercept.Context, error)
 		// immediately delete if the amount is bullshit
 		NewMessage(m, WithDuration(0, bot))
 		bot.trySendMessage(m.Sender, helpTipUsage(ctx, Translate(ctx, "tipValidAmountMessage")))
-		log.Warnln(errmsg)
+		logger(ctx).Warnln(errmsg)
 		return ctx, errors.Create(errors.InvalidAmountError)
 	}
 
@@ -75,6 +78,31 @@ func (bot *TipBot) tipHandler(ctx intercept.Context) (intercept.Context, error)
 	from := LoadUser(ctx)
 	to := LoadReplyToUser(ctx)
 
+	// tips on channel posts in the comments go to the channel
+	var channel *Channel
+	channelId, channelPostId, isChannelPost := channelPost(m.ReplyTo)
+	if isChannelPost {
+		channel, err = bot.getChannel(channelId)
+		if err != nil {
+			NewMessage(m, WithDuration(0, bot))
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "channelNotTippableMessage"), str.MarkdownEscape(m.ReplyTo.SenderChat.Title)))
+			return ctx, fmt.Errorf("[/tip] channel %d is not linked", channelId)
+		}
+		to, err = bot.channelTipRecipient(channel, m.ReplyTo)
+		if err != nil {
+			logger(ctx).Errorf("[/tip] could not load recipient of channel %d: %v", channelId, err)
+			return ctx, err
+		}
+	} else if postedAsChat(m.ReplyTo) {
+		// anonymous admins and users who post as their channel
+		to, err = bot.senderChatTipRecipient(ctx, m.ReplyTo)
+		if err != nil {
+			NewMessage(m, WithDuration(0, bot))
+			logger(ctx).Warnf("[/tip] %v", err)
+			return ctx, err
+		}
+	}
+
 	if from.Telegram.ID == to.Telegram.ID {
 		NewMessage(m, WithDuration(0, bot))
 		bot.trySendMessage(m.Sender, Translate(ctx, "tipYourselfMessage"))
@@ -87,11 +115,11 @@ func (bot *TipBot) tipHandler(ctx intercept.Context) (intercept.Context, error)
 	fromUserStr := GetUserStr(from.Telegram)
 
 	if _, exists := bot.UserExists(to.Telegram); !exists {
-		log.Infof("[/tip] User %s has no wallet.", toUserStr)
+		logger(ctx).Infof("[/tip] User %s has no wallet.", toUserStr)
 		to, err = bot.CreateWalletForTelegramUser(to.Telegram)
 		if err != nil {
 			errmsg := fmt.Errorf("[/tip] Error: Could not create wallet for %s", toUserStr)
-			log.Errorln(errmsg)
+			logger(ctx).Errorln(errmsg)
 			return ctx, fmt.Errorf("could not create wallet for %s", toUserStr)
 		}
 	}
@@ -105,38 +133,102 @@ func (bot *TipBot) tipHandler(ctx intercept.Context) (intercept.Context, error)
 			tipMemo = tipMemo + "..."
 		}
 	}
+	anonymous, tipMemo := bot.tipAnonymity(from, tipMemo)
 
 	// todo: user new get username function to get userStrings
 	transactionMemo := fmt.Sprintf("🏅 Tip from %s to %s.", fromUserStr, toUserStr)
-	t := NewTransaction(bot, from, to, amount, TransactionType("tip"), TransactionChat(m.Chat))
+	tipper := m.Sender
+	if anonymous {
+		// the memo shows up in the wallet of the receiver
+		transactionMemo = fmt.Sprintf("🏅 Anonymous tip to %s.", toUserStr)
+		tipper = anonymousTipper
+	}
+	opts := []TransactionOption{TransactionType("tip"), TransactionChat(m.Chat), TransactionTopic(bot.messageTopic(m)), TransactionAnonymous(anonymous), TransactionIdempotencyKey(idempotencyKey(ctx)), TransactionContext(ctx), TransactionUserInitiated()}
+	if isChannelPost {
+		opts = append(opts, TransactionChannelPost(channelId, channelPostId))
+	}
+	// the group keeps its commission, the receiver gets the rest of the tip
+	commission, groupWallet := bot.groupCommission(m.Chat, from, to, amount)
+	t := NewTransaction(bot, from, to, amount-commission, opts...)
 	t.Memo = transactionMemo
 	success, err := t.Send()
+	if isDuplicateOperation(err) {
+		return ctx, err
+	}
 	if !success {
 		NewMessage(m, WithDuration(0, bot))
 		bot.trySendMessage(m.Sender, fmt.Sprintf("%s: %s", Translate(ctx, "tipErrorMessage"), Translate(ctx, "tipUndefinedErrorMsg")))
 		errMsg := fmt.Sprintf("[/tip] Transaction failed: %s", err.Error())
-		log.Warnln(errMsg)
+		logger(ctx).Warnln(errMsg)
 		return ctx, err
 	}
 
-	// update tooltip if necessary
-	messageHasTip := tipTooltipHandler(m, bot, amount, to.Initialized)
+	if commission > 0 && bot.collectGroupCommission(from, groupWallet, commission, m.Chat, anonymous, idempotencyKey(ctx)) != nil {
+		commission = 0
+	}
+
+	// update tooltip if necessary, quiet groups only get a reaction on the tipped message
+	messageHasTip := false
+	if bot.getGroupSettings(m.Chat.ID).Quiet {
+		bot.tryReact(m.ReplyTo, tipReaction)
+	} else {
+		messageHasTip = tipTooltipHandler(m, bot, tipper, amount, to.Initialized)
+	}
 
-	log.Infof("[💸 tip] Tip from %s to %s (%d sat).", fromUserStr, toUserStr, amount)
+	logger(ctx).Infof("[💸 tip] Tip from %s to %s (%d sat).", fromUserStr, toUserStr, amount)
 
 	// notify users
-	bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "tipSentMessage"), amount, toUserStrMd))
+	bot.sendTipConfirmation(t, m, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "tipSentMessage"), amount, toUserStrMd)+bot.fiatAmount(from, amount)+commissionStr(from.Telegram.LanguageCode, commission)+feeStr(from.Telegram.LanguageCode, t.Fee))
 
 	// forward tipped message to user once
-	if !messageHasTip {
+	if !messageHasTip && bot.notifiesInstantly(to, NotificationTip, amount) {
 		bot.tryForwardMessage(to.Telegram, m.ReplyTo, tb.Silent)
 	}
-	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "tipReceivedMessage"), fromUserStrMd, amount))
-
+	received := fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "tipReceivedMessage"), fromUserStrMd, t.Amount) + bot.fiatAmount(to, t.Amount)
+	if anonymous {
+		received = fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "tipReceivedAnonymousMessage"), t.Amount) + bot.fiatAmount(to, t.Amount)
+	}
+	if isChannelPost {
+		received += fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "channelTipReceivedMessage"), channelPostLink(channel, channelPostId))
+	}
+	// the comment of the tip comes with the notification
 	if len(tipMemo) > 0 {
-		bot.trySendMessage(to.Telegram, fmt.Sprintf("✉️ %s", str.MarkdownEscape(tipMemo)))
+		received += fmt.Sprintf("\n✉️ %s", str.MarkdownEscape(tipMemo))
+	}
+	bot.notify(to, NotificationTip, t.Amount, received)
+
+	// stickers and GIFs of the tip go to the receiver
+	media := TipMedia{To: to.Telegram, From: from.Telegram, Amount: amount, Anonymous: anonymous}
+	if hasTipMedia(m) {
+		bot.sendTipMedia(media, m)
+	} else {
+		bot.expectTipMedia(m, to, amount, anonymous)
 	}
 	// delete the tip message after a few seconds, this is default behaviour
-	NewMessage(m, WithDuration(time.Second*time.Duration(internal.Configuration.Telegram.MessageDisposeDuration), bot))
+	dispose := time.Second * time.Duration(internal.CurrentConfiguration().Telegram.MessageDisposeDuration)
+	if anonymous {
+		// the command shows who tipped
+		dispose = 0
+	}
+	NewMessage(m, WithDuration(dispose, bot))
 	return ctx, nil
 }
+
+// tipAnonymity returns whether a tip is anonymous and its memo without the keyword. Tips that
+// start their memo with anon are anonymous, tips with public are not. Other tips follow the
+// setting of the sender.
+func (bot *TipBot) tipAnonymity(from *lnbits.User, memo string) (anonymous bool, rest string) {
+	keyword := strings.ToLower(strings.SplitN(memo, " ", 2)[0])
+	rest = strings.TrimSpace(strings.TrimPrefix(memo, strings.SplitN(memo, " ", 2)[0]))
+	switch keyword {
+	case "anon", "anonymous", "🕶":
+		return true, rest
+	case "public":
+		return false, rest
+	}
+	user, err := GetLnbitsUserWithSettings(from.Telegram, *bot)
+	if err != nil {
+		return false, memo
+	}
+	return user.Settings.Tip.Anonymous, memo
+}
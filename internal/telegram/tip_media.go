@@ -0,0 +1,98 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/eko/gocache/store"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// tipMediaWindow is how long after a tip the sender can add a sticker or GIF to it.
+const tipMediaWindow = time.Minute
+
+// TipMedia is a tip that waits for the sticker or GIF of its sender.
+type TipMedia struct {
+	To        *tb.User `json:"to"`
+	From      *tb.User `json:"from"`
+	Amount    int64    `json:"amount"`
+	Anonymous bool     `json:"anonymous"`
+}
+
+func tipMediaCacheKey(chatId int64, senderId int64) string {
+	return fmt.Sprintf("tip-media-%d-%d", chatId, senderId)
+}
+
+// hasTipMedia returns true if m has media that the receiver of a tip can get.
+func hasTipMedia(m *tb.Message) bool {
+	return m.Sticker != nil || m.Animation != nil || m.Photo != nil || m.Video != nil
+}
+
+// isTipCaption returns true if the caption of m is a tip command, like a GIF with /tip 500.
+func (bot *TipBot) isTipCaption(m *tb.Message) bool {
+	return m.IsReply() && bot.commandName(m.Caption) == "tip"
+}
+
+// expectTipMedia lets the sender of a tip add a sticker or GIF to it in the next minute.
+func (bot *TipBot) expectTipMedia(m *tb.Message, to *lnbits.User, amount int64, anonymous bool) {
+	if m.Private() {
+		return
+	}
+	media := TipMedia{To: to.Telegram, From: m.Sender, Amount: amount, Anonymous: anonymous}
+	bot.Cache.Set(tipMediaCacheKey(m.Chat.ID, m.Sender.ID), media, &store.Options{Expiration: tipMediaWindow})
+}
+
+// sendTipMedia copies the media of a tip to the receiver. Copies don't show the sender like
+// forwards do, anonymous tips stay anonymous.
+func (bot *TipBot) sendTipMedia(media TipMedia, m *tb.Message) {
+	if _, err := bot.Telegram.Copy(media.To, m, tb.Silent); err != nil {
+		log.Warnf("[sendTipMedia] could not copy media to %s: %v", GetUserStr(media.To), err)
+		return
+	}
+	message := fmt.Sprintf(i18n.Translate(media.To.LanguageCode, "tipMediaMessage"), GetUserStrMd(media.From), media.Amount)
+	if media.Anonymous {
+		message = fmt.Sprintf(i18n.Translate(media.To.LanguageCode, "tipMediaAnonymousMessage"), media.Amount)
+	}
+	bot.trySendMessage(media.To, message)
+}
+
+// tipMediaInterceptor lets media in private chats pass. In groups, only media that tip or that
+// belong to a tip pass.
+func (bot TipBot) tipMediaInterceptor(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m == nil {
+		return ctx, fmt.Errorf("[tipMediaInterceptor] no message")
+	}
+	if m.Private() || bot.isTipCaption(m) {
+		return ctx, nil
+	}
+	if m.Sticker != nil || m.Animation != nil {
+		if _, err := bot.Cache.Get(tipMediaCacheKey(m.Chat.ID, m.Sender.ID)); err == nil {
+			return ctx, nil
+		}
+	}
+	return ctx, fmt.Errorf("[tipMediaInterceptor] no tip media")
+}
+
+// tipMediaHandler handles media in groups: tips with a caption like /tip 500 and stickers or
+// GIFs that the sender of a tip sends right after it.
+func (bot *TipBot) tipMediaHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if bot.isTipCaption(m) {
+		m.Text = m.Caption
+		return bot.tipHandler(ctx)
+	}
+	key := tipMediaCacheKey(m.Chat.ID, m.Sender.ID)
+	cached, err := bot.Cache.Get(key)
+	if err != nil {
+		return ctx, err
+	}
+	// one sticker per tip
+	bot.Cache.Delete(key)
+	bot.sendTipMedia(cached.(TipMedia), m)
+	return ctx, nil
+}
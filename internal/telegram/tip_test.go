@@ -0,0 +1,264 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestTipUndo(t *testing.T) {
+	h := newTestHarness(t)
+	sender := &tb.User{ID: 8001, Username: "sender", FirstName: "Sender", LanguageCode: "en"}
+	receiver := &tb.User{ID: 8002, Username: "receiver", FirstName: "Receiver", LanguageCode: "en"}
+	group := &tb.Chat{ID: -8000, Type: tb.ChatGroup, Title: "group"}
+	from := h.newUser(sender, 1000)
+	to := h.newUser(receiver, 0)
+
+	post := h.sendMessage(receiver, group, "gm")
+	h.sendReply(sender, group, "/tip 100", post)
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 100 {
+		t.Fatalf("balance of receiver = %d, want 100", balance)
+	}
+	confirmation := h.lastMessage(sender.ID)
+	if !strings.Contains(confirmation.Text(), "100 sat sent") {
+		t.Fatalf("confirmation = %q", confirmation.Text())
+	}
+
+	h.pressButton(sender, confirmation, "↩️ Undo")
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of sender = %d, want 1000", balance)
+	}
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 0 {
+		t.Errorf("balance of receiver = %d, want 0", balance)
+	}
+	if text := h.lastMessage(receiver.ID).Text(); !strings.Contains(text, "took back the tip") {
+		t.Errorf("last message to receiver = %q", text)
+	}
+
+	// a tip can only be taken back once
+	h.pressButton(sender, confirmation, "↩️ Undo")
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of sender = %d after undoing twice, want 1000", balance)
+	}
+}
+
+func TestTipUndoClosedByReceiver(t *testing.T) {
+	h := newTestHarness(t)
+	payments := lnbits.NewPaymentObserver(h.bot.Client)
+	payments.OnPayment(h.bot.closeSpentTipUndos)
+	h.bot.Client = payments
+	sender := &tb.User{ID: 8011, Username: "sender", FirstName: "Sender", LanguageCode: "en"}
+	receiver := &tb.User{ID: 8012, Username: "receiver", FirstName: "Receiver", LanguageCode: "en"}
+	carol := &tb.User{ID: 8013, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	group := &tb.Chat{ID: -8010, Type: tb.ChatGroup, Title: "group"}
+	from := h.newUser(sender, 1000)
+	to := h.newUser(receiver, 0)
+	h.newUser(carol, 0)
+
+	// the receiver replies to the tip
+	post := h.sendMessage(receiver, group, "gm")
+	tip := h.sendReply(sender, group, "/tip 100", post)
+	confirmation := h.lastMessage(sender.ID)
+	h.sendReply(receiver, group, "thanks!", tip)
+	h.pressButton(sender, confirmation, "↩️ Undo")
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 100 {
+		t.Errorf("balance of receiver = %d after a reply to the tip, want 100", balance)
+	}
+
+	// the receiver forwards the tip
+	post = h.sendMessage(receiver, group, "gn")
+	h.sendReply(sender, group, "/tip 50", post)
+	confirmation = h.lastMessage(sender.ID)
+	h.sendMessage(receiver, privateChat(receiver), "/send 20 @carol")
+	h.pressButton(receiver, h.lastMessage(receiver.ID), "✅ Send")
+	h.pressButton(sender, confirmation, "↩️ Undo")
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 130 {
+		t.Errorf("balance of receiver = %d after forwarding the tip, want 130", balance)
+	}
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 850 {
+		t.Errorf("balance of sender = %d, want 850", balance)
+	}
+}
+
+func TestTipAll(t *testing.T) {
+	h := newTestHarness(t)
+	sender := &tb.User{ID: 8101, Username: "sender", FirstName: "Sender", LanguageCode: "en"}
+	alice := &tb.User{ID: 8102, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 8103, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	carol := &tb.User{ID: 8104, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	group := &tb.Chat{ID: -8100, Type: tb.ChatGroup, Title: "group"}
+	from := h.newUser(sender, 1000)
+	toAlice := h.newUser(alice, 0)
+	toBob := h.newUser(bob, 0)
+	toCarol := h.newUser(carol, 0)
+
+	root := h.sendMessage(alice, group, "what do you think?")
+	reply := h.sendReply(bob, group, "great idea", root)
+	h.sendReply(carol, group, "+1", reply)
+	h.sendReply(sender, group, "agreed", root)
+	// carol posted in another thread
+	h.sendMessage(carol, group, "off topic")
+
+	h.sendReply(sender, group, "/tipall 90", reply)
+	for _, user := range []*lnbits.User{toAlice, toBob, toCarol} {
+		if balance := h.lnbits.Balance(user.Wallet.ID); balance != 30 {
+			t.Errorf("balance of %s = %d, want 30", user.Telegram.Username, balance)
+		}
+	}
+	if text := h.lastMessage(group.ID).Text(); !strings.Contains(text, "90 sat to 3 participants") {
+		t.Errorf("summary = %q", text)
+	}
+
+	h.sendReply(sender, group, "/tipall 10 each", root)
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 880 {
+		t.Errorf("balance of sender = %d, want 880", balance)
+	}
+}
+
+func TestAnonymousTip(t *testing.T) {
+	h := newTestHarness(t)
+	sender := &tb.User{ID: 8201, Username: "sender", FirstName: "Sender", LanguageCode: "en"}
+	receiver := &tb.User{ID: 8202, Username: "receiver", FirstName: "Receiver", LanguageCode: "en"}
+	group := &tb.Chat{ID: -8200, Type: tb.ChatGroup, Title: "group"}
+	h.newUser(sender, 1000)
+	to := h.newUser(receiver, 0)
+
+	revealed := func() bool {
+		for _, request := range h.sent {
+			if (request.ChatId() == receiver.ID || request.ChatId() == group.ID) && strings.Contains(request.Text(), "sender") {
+				return true
+			}
+		}
+		return false
+	}
+	post := h.sendMessage(receiver, group, "gm")
+	h.sendReply(sender, group, "/tip 100 anon thanks!", post)
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 100 {
+		t.Fatalf("balance of receiver = %d, want 100", balance)
+	}
+	if revealed() {
+		t.Error("the anonymous tip revealed the sender")
+	}
+	if !h.called("sendMessage", receiver.ID) || !strings.Contains(h.lastMessage(receiver.ID).Text(), "thanks!") {
+		t.Errorf("memo = %q", h.lastMessage(receiver.ID).Text())
+	}
+	// the operator still knows who tipped
+	tip := &Transaction{}
+	if tx := h.bot.DB.Transactions.Where("to_id = ? AND type = ?", receiver.ID, "tip").First(tip); tx.Error != nil || !tip.Anonymous || tip.FromId != sender.ID {
+		t.Errorf("transaction = %+v, %v", tip, tx.Error)
+	}
+	// the transaction history of the receiver doesn't name the sender
+	var counterparties int64
+	h.bot.DB.Transactions.Model(&PaymentCounterparty{}).Where("wallet_id = ? AND name LIKE ?", to.Wallet.ID, "%sender%").Count(&counterparties)
+	if counterparties != 0 {
+		t.Errorf("%d counterparties of the receiver name the sender", counterparties)
+	}
+
+	// anonymous by default, public on request
+	h.sendMessage(sender, privateChat(sender), "/set tips anonymous")
+	h.sendReply(sender, group, "/tip 10", post)
+	if revealed() {
+		t.Error("the default anonymous tip revealed the sender")
+	}
+	h.sendReply(sender, group, "/tip 10 public", post)
+	if !revealed() {
+		t.Error("the public tip didn't name the sender")
+	}
+}
+
+func TestTipMedia(t *testing.T) {
+	h := newTestHarness(t)
+	sender := &tb.User{ID: 8211, Username: "sender", FirstName: "Sender", LanguageCode: "en"}
+	receiver := &tb.User{ID: 8212, Username: "receiver", FirstName: "Receiver", LanguageCode: "en"}
+	other := &tb.User{ID: 8213, Username: "other", FirstName: "Other", LanguageCode: "en"}
+	group := &tb.Chat{ID: -8210, Type: tb.ChatGroup, Title: "group"}
+	h.newUser(sender, 1000)
+	h.newUser(receiver, 0)
+	h.newUser(other, 0)
+	sticker := func(from *tb.User) {
+		h.process(tb.Update{Message: &tb.Message{ID: 8219, Sender: from, Chat: group, Sticker: &tb.Sticker{File: tb.File{FileID: "sticker"}}}})
+	}
+
+	post := h.sendMessage(receiver, group, "gm")
+	h.sendReply(sender, group, "/tip 100 great thread!", post)
+	if text := h.lastMessage(receiver.ID).Text(); !strings.Contains(text, "great thread!") {
+		t.Errorf("notification = %q", text)
+	}
+	// stickers of others are no tip media
+	sticker(other)
+	if h.called("copyMessage", receiver.ID) {
+		t.Fatal("copied the sticker of another user")
+	}
+	sticker(sender)
+	if !h.called("copyMessage", receiver.ID) {
+		t.Fatal("the sticker of the tip was not copied")
+	}
+	if text := h.lastMessage(receiver.ID).Text(); !strings.Contains(text, "100 sat") {
+		t.Errorf("media message = %q", text)
+	}
+	// one sticker per tip
+	copies := len(h.sent)
+	sticker(sender)
+	for _, request := range h.sent[copies:] {
+		if request.Method == "copyMessage" {
+			t.Error("copied a second sticker")
+		}
+	}
+}
+
+func TestGroupCommission(t *testing.T) {
+	h := newTestHarness(t)
+	sender := &tb.User{ID: 8401, Username: "sender", FirstName: "Sender", LanguageCode: "en"}
+	receiver := &tb.User{ID: 8402, Username: "receiver", FirstName: "Receiver", LanguageCode: "en"}
+	treasurer := &tb.User{ID: 8403, Username: "treasurer", FirstName: "Treasurer", LanguageCode: "en"}
+	group := &tb.Chat{ID: -8400, Type: tb.ChatSuperGroup, Title: "group"}
+	anonymous := &tb.User{ID: 1087968824, Username: "GroupAnonymousBot", FirstName: "Group", IsBot: true}
+	from := h.newUser(sender, 1000)
+	to := h.newUser(receiver, 0)
+	wallet := h.newUser(treasurer, 0)
+	id := 8410
+	groupSettings := func(text string) {
+		id++
+		h.process(tb.Update{Message: &tb.Message{ID: id, Chat: group, Sender: anonymous, SenderChat: group, Text: text,
+			Entities: []tb.MessageEntity{{Type: tb.EntityCommand, Length: 14}}}})
+	}
+
+	// the commission needs a group wallet
+	groupSettings("/groupsettings commission 5")
+	if settings := h.bot.getGroupSettings(group.ID); settings.Commission != 0 {
+		t.Fatalf("commission without group wallet = %d", settings.Commission)
+	}
+	groupSettings("/groupsettings wallet @treasurer")
+	groupSettings("/groupsettings commission 50")
+	if settings := h.bot.getGroupSettings(group.ID); settings.Commission != 0 {
+		t.Fatalf("commission above the maximum = %d", settings.Commission)
+	}
+	groupSettings("/groupsettings commission 5")
+	if settings := h.bot.getGroupSettings(group.ID); settings.Commission != 5 {
+		t.Fatalf("commission = %d, want 5", settings.Commission)
+	}
+
+	post := h.sendMessage(receiver, group, "gm")
+	h.sendReply(sender, group, "/tip 100", post)
+	if balance := h.lnbits.Balance(to.Wallet.ID); balance != 95 {
+		t.Errorf("balance of receiver = %d, want 95", balance)
+	}
+	if balance := h.lnbits.Balance(wallet.Wallet.ID); balance != 5 {
+		t.Errorf("balance of group wallet = %d, want 5", balance)
+	}
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 900 {
+		t.Errorf("balance of sender = %d, want 900", balance)
+	}
+	if text := h.lastMessage(sender.ID).Text(); !strings.Contains(text, "5 sat of it went to the group wallet") {
+		t.Errorf("confirmation = %q", text)
+	}
+
+	// tips to the group wallet pay no commission
+	post = h.sendMessage(treasurer, group, "giveaway")
+	h.sendReply(sender, group, "/tip 100", post)
+	if balance := h.lnbits.Balance(wallet.Wallet.ID); balance != 105 {
+		t.Errorf("balance of group wallet = %d, want 105", balance)
+	}
+}
@@ -0,0 +1,219 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	tipUndoMenu = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnUndoTip  = tipUndoMenu.Data("↩️ Undo", "undo_tip")
+)
+
+// TipUndo lets the sender of a tip take it back until Expires.
+type TipUndo struct {
+	*storage.Base
+	TransactionID uint         `json:"transaction_id"`
+	From          *lnbits.User `json:"from"`
+	To            *lnbits.User `json:"to"`
+	Amount        int64        `json:"amount"`
+	Message       string       `json:"message"` // confirmation of the tip without the button
+	Expires       time.Time    `json:"expires"`
+	LanguageCode  string       `json:"languagecode"`
+	Anonymous     bool         `json:"anonymous"`  // the receiver doesn't know the sender
+	MessageID     int          `json:"message_id"` // confirmation in the private chat of the sender
+	ChatID        int64        `json:"chat_id"`
+	TipMessages   []int        `json:"tip_messages"` // the tip command and its tooltip, a reply to them closes the undo
+}
+
+// tipUndoWindow returns the time in which a tip can be undone, zero if undo is turned off.
+func tipUndoWindow() time.Duration {
+	if internal.CurrentConfiguration().Bot.TipUndoWindow <= 0 {
+		return 0
+	}
+	return time.Duration(internal.CurrentConfiguration().Bot.TipUndoWindow) * time.Second
+}
+
+// sendTipConfirmation confirms the tip of the command m to its sender. During the undo
+// window, the confirmation has a button to take the tip back.
+func (bot *TipBot) sendTipConfirmation(t *Transaction, m *tb.Message, message string) {
+	window := tipUndoWindow()
+	if window == 0 {
+		t.SetSenderMessage(bot.trySendMessage(t.From.Telegram, message))
+		return
+	}
+	undo := &TipUndo{
+		Base:          storage.New(storage.ID(fmt.Sprintf("undo_tip:%d", t.ID))),
+		TransactionID: t.ID,
+		From:          t.From,
+		To:            t.To,
+		Amount:        t.Amount,
+		Message:       message,
+		Expires:       time.Now().Add(window),
+		LanguageCode:  t.From.Telegram.LanguageCode,
+		Anonymous:     t.Anonymous,
+		ChatID:        m.Chat.ID,
+		TipMessages:   []int{m.ID},
+	}
+	if m.ReplyTo != nil {
+		if exists, ttt := tipTooltipExists(m, bot); exists && ttt.Message.Message != nil {
+			undo.TipMessages = append(undo.TipMessages, ttt.Message.Message.ID)
+		}
+	}
+	undoButton := tipUndoMenu.Data(i18n.Translate(undo.LanguageCode, "undoButtonMessage"), "undo_tip", undo.ID)
+	tipUndoMenu.Inline(tipUndoMenu.Row(undoButton))
+	msg := bot.trySendMessageEditable(t.From.Telegram, message, tipUndoMenu)
+	if msg == nil {
+		return
+	}
+	t.SetSenderMessage(msg)
+	undo.MessageID = msg.ID
+	runtime.IgnoreError(undo.Set(undo, bot.Bunt))
+	// remove the button after the window, a click after a restart is checked against Expires
+	time.AfterFunc(window, func() {
+		bot.closeTipUndo(undo.ID)
+	})
+}
+
+// closeTipUndo removes the undo button from the confirmation of a tip.
+func (bot *TipBot) closeTipUndo(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	undo := &TipUndo{Base: storage.New(storage.ID(id))}
+	sn, err := undo.Get(undo, bot.Bunt)
+	if err != nil {
+		log.Errorf("[closeTipUndo] %s: %v", id, err)
+		return
+	}
+	undo = sn.(*TipUndo)
+	if !undo.Active {
+		return
+	}
+	runtime.IgnoreError(undo.Inactivate(undo, bot.Bunt))
+	bot.tryEditMessage(&tb.Message{ID: undo.MessageID, Chat: &tb.Chat{ID: undo.From.Telegram.ID}}, undo.Message, &tb.ReplyMarkup{})
+}
+
+// openTipUndos returns the tips to a user that can still be undone. With a chatId,
+// only the tips in that chat.
+func (bot *TipBot) openTipUndos(userId int64, chatId int64) []*TipUndo {
+	window := tipUndoWindow()
+	if window == 0 {
+		return nil
+	}
+	var ids []uint
+	tx := bot.DB.Transactions.Model(&Transaction{}).Where("to_id = ? AND type = ? AND success = ? AND time > ?", userId, "tip", true, time.Now().Add(-window))
+	if chatId != 0 {
+		tx = tx.Where("chat_id = ?", chatId)
+	}
+	if err := tx.Pluck("id", &ids).Error; err != nil {
+		log.Errorf("[openTipUndos] could not load the tips to %d: %v", userId, err)
+		return nil
+	}
+	undos := make([]*TipUndo, 0)
+	for _, id := range ids {
+		undo := &TipUndo{Base: storage.New(storage.ID(fmt.Sprintf("undo_tip:%d", id)))}
+		sn, err := undo.Get(undo, bot.Bunt)
+		if err != nil {
+			continue
+		}
+		undo = sn.(*TipUndo)
+		if undo.Active && time.Now().Before(undo.Expires) {
+			undos = append(undos, undo)
+		}
+	}
+	return undos
+}
+
+// closeSpentTipUndos closes the undo of the tips to the wallet of a payment. Once the
+// receiver pays, the tip may be spent or forwarded. Only payments of the receiver count,
+// the refund of another undo doesn't close the rest.
+func (bot *TipBot) closeSpentTipUndos(payment lnbits.OutgoingPayment) {
+	if !payment.UserInitiated || tipUndoWindow() == 0 {
+		return
+	}
+	user := &lnbits.User{}
+	if tx := bot.DB.Users.Where("wallet_id = ?", payment.WalletID).First(user); tx.Error != nil || user.Telegram == nil {
+		return
+	}
+	for _, undo := range bot.openTipUndos(user.Telegram.ID, 0) {
+		bot.closeTipUndo(undo.ID)
+	}
+}
+
+// closeRepliedTipUndos closes the undo of a tip once its receiver replies to the tip
+// command or its tooltip.
+func (bot *TipBot) closeRepliedTipUndos(m *tb.Message) {
+	if m.Sender == nil || m.ReplyTo == nil || tipUndoWindow() == 0 {
+		return
+	}
+	for _, undo := range bot.openTipUndos(m.Sender.ID, m.Chat.ID) {
+		for _, id := range undo.TipMessages {
+			if id == m.ReplyTo.ID {
+				bot.closeTipUndo(undo.ID)
+				break
+			}
+		}
+	}
+}
+
+// undoTipHandler is invoked when the sender of a tip clicks undo. The tip is sent back
+// in a single payment, which fails if the receiver already spent it.
+func (bot *TipBot) undoTipHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	undo := &TipUndo{Base: storage.New(storage.ID(ctx.Data()))}
+	sn, err := undo.Get(undo, bot.Bunt)
+	if err != nil {
+		logger(ctx).Errorf("[undoTipHandler] %s", err.Error())
+		return ctx, err
+	}
+	undo = sn.(*TipUndo)
+	if undo.From.Telegram.ID != ctx.Sender().ID {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if !undo.Active || time.Now().After(undo.Expires) {
+		runtime.IgnoreError(undo.Inactivate(undo, bot.Bunt))
+		bot.tryEditMessage(ctx.Message(), undo.Message, &tb.ReplyMarkup{})
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	runtime.IgnoreError(undo.Inactivate(undo, bot.Bunt))
+
+	from := LoadUser(ctx)
+	to, err := GetLnbitsUser(undo.To.Telegram, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	t := NewTransaction(bot, to, from, undo.Amount, TransactionType("tip undo"), TransactionIdempotencyKey(undo.ID), TransactionContext(ctx))
+	t.Memo = fmt.Sprintf("↩️ Undo of the tip from %s to %s.", GetUserStr(from.Telegram), GetUserStr(to.Telegram))
+	if undo.Anonymous {
+		t.Memo = fmt.Sprintf("↩️ Undo of the anonymous tip to %s.", GetUserStr(to.Telegram))
+	}
+	success, err := t.Send()
+	if isDuplicateOperation(err) {
+		return ctx, err
+	}
+	if !success {
+		logger(ctx).Warnf("[undoTipHandler] could not undo tip %d: %v", undo.TransactionID, err)
+		bot.tryEditMessage(ctx.Message(), undo.Message+i18n.Translate(undo.LanguageCode, "tipUndoFailedMessage"), &tb.ReplyMarkup{})
+		return ctx, err
+	}
+	logger(ctx).Infof("[↩️ tip] %s took back the tip of %d sat to %s", GetUserStr(from.Telegram), undo.Amount, GetUserStr(to.Telegram))
+	bot.tryEditMessage(ctx.Message(), fmt.Sprintf(i18n.Translate(undo.LanguageCode, "tipUndoneMessage"), undo.Amount, GetUserStrMd(to.Telegram)), &tb.ReplyMarkup{})
+	if undo.Anonymous {
+		bot.notify(to, NotificationTip, undo.Amount, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "tipUndoneAnonymousReceiverMessage"), undo.Amount))
+	} else {
+		bot.notify(to, NotificationTip, undo.Amount, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "tipUndoneReceiverMessage"), GetUserStrMd(from.Telegram), undo.Amount))
+	}
+	return ctx, nil
+}
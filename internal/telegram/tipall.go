@@ -0,0 +1,210 @@
+package telegram
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/eko/gocache/store"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// threadExpiration is how long posts in a thread count as recent.
+	threadExpiration = 24 * time.Hour
+	// tipAllMaxRecipients caps the number of users that a single /tipall pays.
+	tipAllMaxRecipients = 25
+)
+
+// ThreadPoster is a user who posted in a thread of a group.
+type ThreadPoster struct {
+	User     *tb.User  `json:"user"`
+	PostedAt time.Time `json:"posted_at"`
+}
+
+// ThreadPosters are the recent posters of a thread, a thread is a message and all replies to it.
+type ThreadPosters struct {
+	Posters []ThreadPoster `json:"posters"`
+}
+
+func threadRootCacheKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("thread-%d-%d", chatID, messageID)
+}
+
+func threadPostersCacheKey(chatID int64, rootID int) string {
+	return fmt.Sprintf("thread-posters-%d-%d", chatID, rootID)
+}
+
+// threadRoot returns the first message of the thread that m belongs to. Messages that
+// the bot has not seen start a thread of their own.
+func (bot *TipBot) threadRoot(m *tb.Message) int {
+	if root, err := bot.Cache.Get(threadRootCacheKey(m.Chat.ID, m.ID)); err == nil {
+		return root.(int)
+	}
+	return m.ID
+}
+
+// recordThreadPost remembers the sender of a group message as a poster of its thread.
+func (bot *TipBot) recordThreadPost(m *tb.Message) {
+	if bot.Cache.StoreInterface == nil || m.Sender == nil || m.Sender.IsBot {
+		return
+	}
+	root := m.ID
+	if m.ReplyTo != nil {
+		root = bot.threadRoot(m.ReplyTo)
+	}
+	bot.Cache.Set(threadRootCacheKey(m.Chat.ID, m.ID), root, &store.Options{Expiration: threadExpiration})
+
+	key := threadPostersCacheKey(m.Chat.ID, root)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	posters := bot.threadPosters(m.Chat.ID, root)
+	updated := ThreadPosters{Posters: []ThreadPoster{{User: m.Sender, PostedAt: time.Now()}}}
+	for _, poster := range posters.Posters {
+		if poster.User.ID != m.Sender.ID {
+			updated.Posters = append(updated.Posters, poster)
+		}
+	}
+	bot.Cache.Set(key, updated, &store.Options{Expiration: threadExpiration})
+}
+
+// threadPosters returns the posters of a thread, the most recent poster first.
+func (bot *TipBot) threadPosters(chatID int64, root int) ThreadPosters {
+	if posters, err := bot.Cache.Get(threadPostersCacheKey(chatID, root)); err == nil {
+		return posters.(ThreadPosters)
+	}
+	return ThreadPosters{}
+}
+
+// threadParticipants returns the users who recently posted in the thread of m, without
+// the user with the excluded ID and without bots. At most tipAllMaxRecipients users
+// are returned, the most recent posters first.
+func (bot *TipBot) threadParticipants(m *tb.Message, excludeID int64) []*tb.User {
+	posters := bot.threadPosters(m.Chat.ID, bot.threadRoot(m)).Posters
+	// the replied message counts even if it was posted before the bot saw the thread
+	if m.Sender != nil {
+		posters = append(posters, ThreadPoster{User: m.Sender, PostedAt: time.Unix(m.Unixtime, 0)})
+	}
+	sort.SliceStable(posters, func(i, j int) bool {
+		return posters[i].PostedAt.After(posters[j].PostedAt)
+	})
+	seen := map[int64]bool{excludeID: true}
+	users := make([]*tb.User, 0)
+	for _, poster := range posters {
+		if seen[poster.User.ID] || poster.User.IsBot || time.Since(poster.PostedAt) > threadExpiration {
+			continue
+		}
+		seen[poster.User.ID] = true
+		users = append(users, poster.User)
+		if len(users) == tipAllMaxRecipients {
+			break
+		}
+	}
+	return users
+}
+
+func helpTipAllUsage(ctx intercept.Context, errormsg string) string {
+	return fmt.Sprintf(Translate(ctx, "tipAllHelpText"), errormsg, tipAllMaxRecipients)
+}
+
+// tipAllHandler is invoked on /tipall <amount> [each] as a reply in a group thread. The amount
+// is split between everyone who recently posted in the thread, with each it is paid to every one.
+func (bot *TipBot) tipAllHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Chat.Type == tb.ChatPrivate || !m.IsReply() {
+		bot.trySendMessage(m.Sender, helpTipAllUsage(ctx, Translate(ctx, "tipAllDidYouReplyMessage")))
+		return ctx, errors.Create(errors.NoReplyMessageError)
+	}
+	amount, err := decodeAmountFromCommand(m.Text)
+	if err != nil || amount < 1 {
+		NewMessage(m, WithDuration(0, bot))
+		bot.trySendMessage(m.Sender, helpTipAllUsage(ctx, Translate(ctx, "tipValidAmountMessage")))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	each := false
+	if mode, err := getArgumentFromCommand(m.Text, 2); err == nil {
+		each = strings.ToLower(mode) == "each"
+	}
+
+	from := LoadUser(ctx)
+	recipients := bot.threadParticipants(m.ReplyTo, from.Telegram.ID)
+	if len(recipients) == 0 {
+		NewMessage(m, WithDuration(0, bot))
+		bot.trySendMessage(m.Sender, Translate(ctx, "tipAllNoParticipantsMessage"))
+		return ctx, errors.Create(errors.NoReplyMessageError)
+	}
+	perUser := amount
+	if !each {
+		perUser = amount / int64(len(recipients))
+	}
+	if perUser < 1 {
+		NewMessage(m, WithDuration(0, bot))
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "tipAllAmountTooSmallMessage"), len(recipients)))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	total := perUser * int64(len(recipients))
+	balance, err := bot.GetUserBalance(from)
+	if err != nil {
+		return ctx, err
+	}
+	if balance < total {
+		NewMessage(m, WithDuration(0, bot))
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "insufficientFundsMessage"), balance, total))
+		return ctx, errors.Create(errors.BalanceToLowError)
+	}
+
+	fromUserStr := GetUserStr(from.Telegram)
+	fromUserStrMd := GetUserStrMd(from.Telegram)
+	tipped := make([]string, 0)
+	for _, recipient := range recipients {
+		toUserStr := GetUserStr(recipient)
+		to, exists := bot.UserExists(recipient)
+		if !exists {
+			var err error
+			to, err = bot.CreateWalletForTelegramUser(recipient)
+			if err != nil {
+				logger(ctx).Errorf("[/tipall] could not create wallet for %s: %v", toUserStr, err)
+				continue
+			}
+		}
+		t := NewTransaction(bot, from, to, perUser, TransactionType("tipall"), TransactionChat(m.Chat), TransactionTopic(bot.messageTopic(m)),
+			TransactionIdempotencyKey(fmt.Sprintf("%s:%d", idempotencyKey(ctx), to.Telegram.ID)), TransactionContext(ctx))
+		t.Memo = fmt.Sprintf("🏅 Thread tip from %s to %s.", fromUserStr, toUserStr)
+		success, err := t.Send()
+		if err == errDuplicateOperation {
+			tipped = append(tipped, GetUserStrMd(to.Telegram))
+			continue
+		}
+		if !success {
+			logger(ctx).Warnf("[/tipall] tip from %s to %s failed: %v", fromUserStr, toUserStr, err)
+			continue
+		}
+		tipped = append(tipped, GetUserStrMd(to.Telegram))
+		bot.notify(to, NotificationTip, perUser, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "tipAllReceivedMessage"), fromUserStrMd, perUser)+bot.fiatAmount(to, perUser))
+	}
+	if len(tipped) == 0 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf("%s: %s", Translate(ctx, "tipErrorMessage"), Translate(ctx, "tipUndefinedErrorMsg")))
+		return ctx, fmt.Errorf("no tip of /tipall succeeded")
+	}
+	sent := perUser * int64(len(tipped))
+	logger(ctx).Infof("[💸 tipall] %s tipped %d users of a thread %d sat each (%d sat).", fromUserStr, len(tipped), perUser, sent)
+
+	names := strings.Join(tipped, ", ")
+	if bot.getGroupSettings(m.Chat.ID).Quiet {
+		bot.tryReact(m.ReplyTo, tipReaction)
+	} else {
+		bot.tryReplyMessage(m.ReplyTo, fmt.Sprintf(Translate(ctx, "tipAllSummaryMessage"), fromUserStrMd, sent, len(tipped), perUser, names))
+	}
+	bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "tipAllSentMessage"), sent, len(tipped), perUser, names)+bot.fiatAmount(from, sent))
+	if len(tipped) < len(recipients) {
+		bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "tipAllPartialMessage"), len(recipients)-len(tipped)))
+	}
+	NewMessage(m, WithDuration(time.Second*time.Duration(internal.CurrentConfiguration().Telegram.MessageDisposeDuration), bot))
+	return ctx, nil
+}
@@ -189,7 +189,7 @@ func (bot TipBot) tipjarHandler(ctx intercept.Context) (intercept.Context, error
 		return ctx, err
 	}
 	toUserStr := GetUserStr(m.Sender)
-	bot.trySendMessage(m.Chat, inlineTipjar.Message, bot.makeTipjarKeyboard(ctx, inlineTipjar))
+	bot.trySendMessage(bot.topicOf(m), inlineTipjar.Message, bot.makeTipjarKeyboard(ctx, inlineTipjar))
 	log.Infof("[tipjar] %s created tipjar %s: %d sat (%d per user)", toUserStr, inlineTipjar.ID, inlineTipjar.Amount, inlineTipjar.PerUserAmount)
 	return ctx, inlineTipjar.Set(inlineTipjar, bot.Bunt)
 }
@@ -280,7 +280,7 @@ func (bot *TipBot) acceptInlineTipjarHandler(ctx intercept.Context) (intercept.C
 
 		// todo: user new get username function to get userStrings
 		transactionMemo := fmt.Sprintf("🍯 Tipjar from %s to %s.", fromUserStr, toUserStr)
-		t := NewTransaction(bot, from, to, inlineTipjar.PerUserAmount, TransactionType("tipjar"))
+		t := NewTransaction(bot, from, to, inlineTipjar.PerUserAmount, TransactionType("tipjar"), TransactionContext(ctx))
 		t.Memo = transactionMemo
 
 		success, err := t.Send()
@@ -296,7 +296,7 @@ func (bot *TipBot) acceptInlineTipjarHandler(ctx intercept.Context) (intercept.C
 		inlineTipjar.From = append(inlineTipjar.From, from)
 		inlineTipjar.GivenAmount = inlineTipjar.GivenAmount + inlineTipjar.PerUserAmount
 
-		bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "inlineTipjarReceivedMessage"), fromUserStrMd, inlineTipjar.PerUserAmount))
+		bot.notify(to, NotificationTip, inlineTipjar.PerUserAmount, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "inlineTipjarReceivedMessage"), fromUserStrMd, inlineTipjar.PerUserAmount))
 		bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "inlineTipjarSentMessage"), inlineTipjar.PerUserAmount, toUserStrMd))
 		if err != nil {
 			errmsg := fmt.Errorf("[tipjar] Error: Send message to %s: %s", toUserStr, err)
@@ -8,7 +8,6 @@ import (
 	"time"
 
 	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
-	"github.com/tidwall/buntdb"
 	"github.com/tidwall/gjson"
 
 	log "github.com/sirupsen/logrus"
@@ -22,8 +21,12 @@ const (
 	tooltipMultipleTipsMessage = "%s (%d tips by %s)"
 	tooltipSingleTipMessage    = "%s (by %s)"
 	tooltipTipAmountMessage    = "🏅 %d sat"
+	tooltipAnonymousMessage    = "🕶 anonymous"
 )
 
+// anonymousTipper stands in for the senders of anonymous tips in tooltips.
+var anonymousTipper = &tb.User{ID: 0}
+
 type TipTooltip struct {
 	Message
 	ID        string     `json:"id"`
@@ -87,7 +90,10 @@ func (ttt TipTooltip) getUpdatedTipTooltipMessage(botUserName string, notInitial
 func getTippersString(tippers []*tb.User) string {
 	var tippersStr string
 	for _, uniqueUser := range tippers {
-		userStr := GetUserStrMd(uniqueUser)
+		userStr := tooltipAnonymousMessage
+		if uniqueUser.ID != anonymousTipper.ID {
+			userStr = GetUserStrMd(uniqueUser)
+		}
 		tippersStr += fmt.Sprintf("%s, ", userStr)
 	}
 	// get rid of the trailing comma
@@ -116,36 +122,36 @@ func tipTooltipExists(m *tb.Message, bot *TipBot) (bool, *TipTooltip) {
 }
 
 // tipTooltipHandler function to update the tooltip below a tipped message. either updates or creates initial tip tool tip
-func tipTooltipHandler(m *tb.Message, bot *TipBot, amount int64, initializedWallet bool) (hasTip bool) {
+// tipper is the sender of the tip or anonymousTipper.
+func tipTooltipHandler(m *tb.Message, bot *TipBot, tipper *tb.User, amount int64, initializedWallet bool) (hasTip bool) {
 	// todo: this crashes if the tooltip message (maybe also the original tipped message) was deleted in the mean time!!! need to check for existence!
 	hasTip, ttt := tipTooltipExists(m, bot)
 	log.Debugf("[tip] %s has tip: %t", ttt.ID, hasTip)
 	if hasTip {
 		// update the tooltip with new tippers
-		err := ttt.updateTooltip(bot, m.Sender, amount, !initializedWallet)
+		err := ttt.updateTooltip(bot, tipper, amount, !initializedWallet)
 		if err != nil {
 			log.Errorln(err)
 			// could not update the message (return false to )
 			return false
 		}
 	} else {
-		newToolTip(m, bot, amount, initializedWallet)
+		newToolTip(m, bot, tipper, amount, initializedWallet)
 	}
 	// first call will return false, every following call will return true
 	return hasTip
 }
 
-func newToolTip(m *tb.Message, bot *TipBot, amount int64, initializedWallet bool) {
+func newToolTip(m *tb.Message, bot *TipBot, tipper *tb.User, amount int64, initializedWallet bool) {
 	tipmsg := fmt.Sprintf(tooltipTipAmountMessage, amount)
-	userStr := GetUserStrMd(m.Sender)
-	tipmsg = fmt.Sprintf(tooltipSingleTipMessage, tipmsg, userStr)
+	tipmsg = fmt.Sprintf(tooltipSingleTipMessage, tipmsg, getTippersString([]*tb.User{tipper}))
 
 	if !initializedWallet {
 		tipmsg = tipmsg + fmt.Sprintf("\n%s", fmt.Sprintf(tooltipChatWithBotMessage, GetUserStrMd(bot.Telegram.Me)))
 	}
-	msg := bot.tryReplyMessage(m.ReplyTo, tipmsg, tb.Silent)
+	msg := bot.tryReplyMessage(m.ReplyTo, tipmsg, tb.Silent, keepMessage)
 	message := NewTipTooltip(msg, TipAmount(amount), Tips(1))
-	message.Tippers = appendUinqueUsersToSlice(message.Tippers, m.Sender)
+	message.Tippers = appendUinqueUsersToSlice(message.Tippers, tipper)
 	runtime.IgnoreError(bot.Bunt.Set(message))
 	log.Debugf("[newToolTip]: New reply message: %d (Bunt: %s)", msg.ID, message.Key())
 }
@@ -163,23 +169,20 @@ func (ttt *TipTooltip) updateTooltip(bot *TipBot, user *tb.User, amount int64, n
 
 // tipTooltipInitializedHandler is called when the user initializes the wallet
 func tipTooltipInitializedHandler(user *tb.User, bot TipBot) {
-	runtime.IgnoreError(bot.Bunt.View(func(tx *buntdb.Tx) error {
-		err := tx.Ascend(MessageOrderedByReplyToFrom, func(key, value string) bool {
-			replyToUserId := gjson.Get(value, MessageOrderedByReplyToFrom)
-			if replyToUserId.String() == strconv.FormatInt(user.ID, 10) {
-				log.Debugln("[tipTooltipInitializedHandler] loading persistent tip tool tip messages")
-				ttt := &TipTooltip{}
-				err := json.Unmarshal([]byte(value), ttt)
-				if err != nil {
-					log.Errorln(err)
-				}
-				// edit to remove the "chat with bot" message
-				ttt.editTooltip(&bot, false)
+	runtime.IgnoreError(bot.Bunt.Ascend(MessageOrderedByReplyToFrom, func(key, value string) bool {
+		replyToUserId := gjson.Get(value, MessageOrderedByReplyToFrom)
+		if replyToUserId.String() == strconv.FormatInt(user.ID, 10) {
+			log.Debugln("[tipTooltipInitializedHandler] loading persistent tip tool tip messages")
+			ttt := &TipTooltip{}
+			err := json.Unmarshal([]byte(value), ttt)
+			if err != nil {
+				log.Errorln(err)
 			}
+			// edit to remove the "chat with bot" message
+			ttt.editTooltip(&bot, false)
+		}
 
-			return true
-		})
-		return err
+		return true
 	}))
 }
 
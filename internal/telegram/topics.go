@@ -0,0 +1,253 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/eko/gocache/store"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// topicExpiration is how long the bot remembers the topic of a message.
+	topicExpiration = 7 * 24 * time.Hour
+	// allCommands disables all commands of the bot in a topic
+	allCommands = "all"
+)
+
+// Topic is a topic of a forum group. Messages that are sent to a topic reply to the message
+// that created the topic, Telegram shows them in the topic.
+type Topic struct {
+	Chat *tb.Chat
+	ID   int
+}
+
+func (t Topic) Recipient() string {
+	return t.Chat.Recipient()
+}
+
+// topicOptions makes a message to a topic a reply to the message that created the topic.
+func topicOptions(to tb.Recipient, options []interface{}) []interface{} {
+	topic, ok := to.(Topic)
+	if !ok || topic.ID == 0 {
+		return options
+	}
+	// options that come later, like the keyboard, are added to these
+	return append([]interface{}{&tb.SendOptions{ReplyTo: &tb.Message{ID: topic.ID, Chat: topic.Chat}, AllowWithoutReply: true}}, options...)
+}
+
+// TopicSettings are the settings of a topic of a forum group.
+type TopicSettings struct {
+	ChatID int64 `json:"chat_id" gorm:"primaryKey"`
+	Topic  int   `json:"topic" gorm:"primaryKey"`
+	// DisabledCommands are the commands that the bot ignores in the topic, separated by commas
+	DisabledCommands string    `json:"disabled_commands"`
+	UpdatedAt        time.Time `json:"updated"`
+}
+
+func topicCacheKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("topic-%d-%d", chatID, messageID)
+}
+
+func forumCacheKey(chatID int64) string {
+	return fmt.Sprintf("forum-%d", chatID)
+}
+
+// isForum returns true if the chat is a forum group with topics.
+func (bot *TipBot) isForum(chat *tb.Chat) bool {
+	if chat == nil || chat.Type != tb.ChatSuperGroup {
+		return false
+	}
+	if forum, err := bot.Cache.Get(forumCacheKey(chat.ID)); err == nil {
+		return forum.(bool)
+	}
+	data, err := bot.Telegram.Raw("getChat", map[string]string{"chat_id": strconv.FormatInt(chat.ID, 10)})
+	if err != nil {
+		log.Warnf("[isForum] %v", err)
+		return false
+	}
+	var response struct {
+		Result struct {
+			IsForum bool `json:"is_forum"`
+		} `json:"result"`
+	}
+	json.Unmarshal(data, &response)
+	bot.Cache.Set(forumCacheKey(chat.ID), response.Result.IsForum, &store.Options{Expiration: time.Hour})
+	return response.Result.IsForum
+}
+
+// messageTopic returns the topic of a message in a forum group. 0 is the general topic.
+func (bot *TipBot) messageTopic(m *tb.Message) int {
+	if m == nil || !bot.isForum(m.Chat) {
+		return 0
+	}
+	if topic, err := bot.Cache.Get(topicCacheKey(m.Chat.ID, m.ID)); err == nil {
+		return topic.(int)
+	}
+	topic := 0
+	if m.ReplyTo != nil {
+		// messages in a topic reply to the message that created the topic, unless they
+		// reply to another message of the topic
+		topic = m.ReplyTo.ID
+		if known, err := bot.Cache.Get(topicCacheKey(m.Chat.ID, m.ReplyTo.ID)); err == nil {
+			topic = known.(int)
+		}
+	}
+	bot.Cache.Set(topicCacheKey(m.Chat.ID, m.ID), topic, &store.Options{Expiration: topicExpiration})
+	return topic
+}
+
+// topicOf returns where messages about m go: the topic of m in forum groups, the chat of m
+// otherwise.
+func (bot *TipBot) topicOf(m *tb.Message) tb.Recipient {
+	if topic := bot.messageTopic(m); topic != 0 {
+		return Topic{Chat: m.Chat, ID: topic}
+	}
+	return m.Chat
+}
+
+func topicSettingsCacheKey(chatID int64, topic int) string {
+	return fmt.Sprintf("topic_settings_%d_%d", chatID, topic)
+}
+
+// getTopicSettings loads the settings of a topic, topics without settings get empty ones.
+func (bot *TipBot) getTopicSettings(chatID int64, topic int) *TopicSettings {
+	if s, err := bot.Cache.Get(topicSettingsCacheKey(chatID, topic)); err == nil {
+		return s.(*TopicSettings)
+	}
+	settings := &TopicSettings{ChatID: chatID, Topic: topic}
+	bot.DB.Groups.Where("chat_id = ? AND topic = ?", chatID, topic).First(settings)
+	bot.Cache.Set(topicSettingsCacheKey(chatID, topic), settings, &store.Options{Expiration: time.Hour})
+	return settings
+}
+
+func (bot *TipBot) saveTopicSettings(settings *TopicSettings) error {
+	settings.UpdatedAt = time.Now()
+	if tx := bot.DB.Groups.Save(settings); tx.Error != nil {
+		return tx.Error
+	}
+	bot.Cache.Set(topicSettingsCacheKey(settings.ChatID, settings.Topic), settings, &store.Options{Expiration: time.Hour})
+	return nil
+}
+
+// commandDisabled returns true if the admins turned off the command in the topic.
+func (s *TopicSettings) commandDisabled(command string) bool {
+	for _, disabled := range strings.Split(s.DisabledCommands, ",") {
+		if disabled == allCommands || disabled == command {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	commandNames     map[string]string
+	commandNamesOnce sync.Once
+)
+
+// commandName returns the name of the command of a message without slash and bot name. Aliases
+// like /t are resolved to the first endpoint of their handler.
+func (bot *TipBot) commandName(text string) string {
+	if !strings.HasPrefix(text, "/") {
+		return ""
+	}
+	commandNamesOnce.Do(func() {
+		commandNames = map[string]string{}
+		for _, h := range bot.getHandler() {
+			name := ""
+			for _, endpoint := range h.Endpoints {
+				if e, ok := endpoint.(string); ok && strings.HasPrefix(e, "/") {
+					if name == "" {
+						name = strings.TrimPrefix(e, "/")
+					}
+					commandNames[strings.TrimPrefix(e, "/")] = name
+				}
+			}
+		}
+	})
+	command := strings.ToLower(strings.SplitN(strings.Fields(text)[0], "@", 2)[0][1:])
+	if name, ok := commandNames[command]; ok {
+		return name
+	}
+	return command
+}
+
+// topicInterceptor remembers the topic of group messages and stops commands that the admins
+// turned off in the topic. /groupsettings always works, it turns commands back on.
+func (bot TipBot) topicInterceptor(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m == nil || m.Chat == nil || m.Chat.Type != tb.ChatSuperGroup || len(m.Text) == 0 {
+		return ctx, nil
+	}
+	topic := bot.messageTopic(m)
+	command := bot.commandName(m.Text)
+	if command == "" || command == "groupsettings" {
+		return ctx, nil
+	}
+	if bot.getTopicSettings(m.Chat.ID, topic).commandDisabled(command) {
+		return ctx, fmt.Errorf("[topicInterceptor] /%s is turned off in topic %d of group %d", command, topic, m.Chat.ID)
+	}
+	return ctx, nil
+}
+
+// groupSettingsCommandsHandler turns commands of the bot in the current topic on or off with
+// /groupsettings commands on|off [<command> ...]. Without commands, all commands are affected.
+func (bot *TipBot) groupSettingsCommandsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	args := strings.Fields(m.Text)
+	if len(args) < 3 || (strings.ToLower(args[2]) != "on" && strings.ToLower(args[2]) != "off") {
+		bot.trySendMessage(bot.topicOf(m), Translate(ctx, "groupSettingsCommandsHelpMessage"))
+		return ctx, fmt.Errorf("invalid commands setting")
+	}
+	enable := strings.ToLower(args[2]) == "on"
+	var commands []string
+	for _, arg := range args[3:] {
+		commands = append(commands, bot.commandName("/"+strings.TrimPrefix(arg, "/")))
+	}
+	settings := bot.getTopicSettings(m.Chat.ID, bot.messageTopic(m))
+	var disabled []string
+	switch {
+	case len(commands) == 0 && enable:
+		// all commands are on
+	case len(commands) == 0:
+		disabled = []string{allCommands}
+	default:
+		for _, command := range strings.Split(settings.DisabledCommands, ",") {
+			if len(command) > 0 && !contains(commands, command) {
+				disabled = append(disabled, command)
+			}
+		}
+		if !enable {
+			disabled = append(disabled, commands...)
+		}
+	}
+	settings.DisabledCommands = strings.Join(disabled, ",")
+	if err := bot.saveTopicSettings(settings); err != nil {
+		log.Errorf("[groupSettingsCommandsHandler] could not save settings of topic %d of group %d: %v", settings.Topic, m.Chat.ID, err)
+		return ctx, err
+	}
+	message := Translate(ctx, "groupSettingsCommandsOnMessage")
+	if len(disabled) > 0 {
+		message = fmt.Sprintf(Translate(ctx, "groupSettingsCommandsOffMessage"), "/"+strings.Join(disabled, ", /"))
+		if contains(disabled, allCommands) {
+			message = Translate(ctx, "groupSettingsCommandsAllOffMessage")
+		}
+	}
+	bot.trySendMessage(bot.topicOf(m), message)
+	return ctx, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
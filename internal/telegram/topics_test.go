@@ -0,0 +1,61 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestForumTopics(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9941, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9942, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	h.newUser(alice, 1000)
+	h.newUser(bob, 0)
+	forum := &tb.Chat{ID: -9940, Type: tb.ChatSuperGroup, Title: "forum"}
+	h.telegram.forums[forum.ID] = true
+	h.telegram.admins = []*tb.User{alice}
+	// the message that created the topic
+	topic := &tb.Message{ID: 77, Chat: forum}
+
+	// faucets are posted in the topic of the command
+	h.sendReply(alice, forum, "/faucet 100 10", topic)
+	faucet := h.lastMessage(forum.ID)
+	if !strings.Contains(faucet.Text(), "faucet") || faucet.Params["reply_to_message_id"] != "77" {
+		t.Fatalf("faucet = %+v", faucet)
+	}
+
+	// tips count for the topic of the tipped message
+	post := h.sendReply(bob, forum, "gm", topic)
+	h.sendReply(alice, forum, "/tip 10", post)
+	if recap, err := h.bot.GroupRecap(forum.ID, 77, time.Now().Add(-time.Hour), time.Now().Add(time.Hour)); err != nil || recap.Tips != 1 || recap.Volume != 10 {
+		t.Errorf("recap of the topic = %+v, %v", recap, err)
+	}
+	if recap, err := h.bot.GroupRecap(forum.ID, 78, time.Now().Add(-time.Hour), time.Now().Add(time.Hour)); err != nil || recap.Tips != 0 {
+		t.Errorf("recap of another topic = %+v, %v", recap, err)
+	}
+
+	// commands can be turned off per topic
+	h.sendReply(alice, forum, "/groupsettings commands off faucet", topic)
+	if text := h.lastMessage(forum.ID).Text(); !strings.Contains(text, "/faucet") {
+		t.Errorf("confirmation = %q", text)
+	}
+	sent := len(h.sent)
+	h.sendReply(alice, forum, "/spigot 100 10", topic)
+	for _, r := range h.sent[sent:] {
+		if r.Method == "sendMessage" && r.ChatId() == forum.ID {
+			t.Errorf("faucet in a topic without faucets: %q", r.Text())
+		}
+	}
+	// the general topic still has faucets
+	h.sendMessage(alice, forum, "/faucet 100 10")
+	if faucet := h.lastMessage(forum.ID); faucet.Params["reply_to_message_id"] != "" {
+		t.Errorf("faucet in the general topic = %+v", faucet)
+	}
+	h.sendReply(alice, forum, "/groupsettings commands on", topic)
+	if settings := h.bot.getTopicSettings(forum.ID, 77); settings.DisabledCommands != "" {
+		t.Errorf("disabled commands = %q", settings.DisabledCommands)
+	}
+}
@@ -1,6 +1,7 @@
 package telegram
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -11,26 +12,38 @@ import (
 )
 
 type Transaction struct {
-	ID           uint           `gorm:"primarykey"`
-	Time         time.Time      `json:"time"`
-	Bot          *TipBot        `gorm:"-"`
-	From         *lnbits.User   `json:"from" gorm:"-"`
-	To           *lnbits.User   `json:"to" gorm:"-"`
-	FromId       int64          `json:"from_id" `
-	ToId         int64          `json:"to_id" `
-	FromUser     string         `json:"from_user"`
-	ToUser       string         `json:"to_user"`
-	Type         string         `json:"type"`
-	Amount       int64          `json:"amount"`
-	ChatID       int64          `json:"chat_id"`
-	ChatName     string         `json:"chat_name"`
-	Memo         string         `json:"memo"`
-	Success      bool           `json:"success"`
-	FromWallet   string         `json:"from_wallet"`
-	ToWallet     string         `json:"to_wallet"`
-	FromLNbitsID string         `json:"from_lnbits"`
-	ToLNbitsID   string         `json:"to_lnbits"`
-	Invoice      lnbits.Invoice `gorm:"embedded;embeddedPrefix:invoice_"`
+	ID             uint           `gorm:"primarykey"`
+	Time           time.Time      `json:"time"`
+	Bot            *TipBot        `gorm:"-"`
+	From           *lnbits.User   `json:"from" gorm:"-"`
+	To             *lnbits.User   `json:"to" gorm:"-"`
+	FromId         int64          `json:"from_id" `
+	ToId           int64          `json:"to_id" `
+	FromUser       string         `json:"from_user"`
+	ToUser         string         `json:"to_user"`
+	Type           string         `json:"type"`
+	Amount         int64          `json:"amount"`
+	ChatID         int64          `json:"chat_id"`
+	ChatName       string         `json:"chat_name"`
+	Topic          int            `json:"topic"` // topic of a forum group
+	ChannelID      int64          `json:"channel_id" gorm:"index"`
+	ChannelPost    int            `json:"channel_post"` // post of the channel that was tipped in the comments
+	Anonymous      bool           `json:"anonymous"`    // the receiver doesn't see the sender
+	Memo           string         `json:"memo"`
+	Success        bool           `json:"success"`
+	FromWallet     string         `json:"from_wallet"`
+	ToWallet       string         `json:"to_wallet"`
+	FromLNbitsID   string         `json:"from_lnbits"`
+	ToLNbitsID     string         `json:"to_lnbits"`
+	Invoice        lnbits.Invoice `gorm:"embedded;embeddedPrefix:invoice_"`
+	IdempotencyKey string         `json:"idempotency_key" gorm:"index"`
+	// the service fee that the sender paid on top of the amount
+	Fee int64 `json:"fee"`
+	// the message that confirmed the transaction in the private chat of the sender
+	SenderMessageID int `json:"sender_message_id"`
+	ctx             context.Context
+	batch           bool
+	userInitiated   bool
 }
 
 type TransactionOption func(t *Transaction)
@@ -42,12 +55,63 @@ func TransactionChat(chat *tb.Chat) TransactionOption {
 	}
 }
 
+// TransactionTopic records the topic of a forum group that the transaction happened in.
+func TransactionTopic(topic int) TransactionOption {
+	return func(t *Transaction) {
+		t.Topic = topic
+	}
+}
+
+// TransactionChannelPost attributes a tip in the comments of a channel to the post.
+func TransactionChannelPost(channelID int64, post int) TransactionOption {
+	return func(t *Transaction) {
+		t.ChannelID = channelID
+		t.ChannelPost = post
+	}
+}
+
+// TransactionAnonymous hides the sender from the receiver. The transaction still records the sender.
+func TransactionAnonymous(anonymous bool) TransactionOption {
+	return func(t *Transaction) {
+		t.Anonymous = anonymous
+	}
+}
+
 func TransactionType(transactionType string) TransactionOption {
 	return func(t *Transaction) {
 		t.Type = transactionType
 	}
 }
 
+// TransactionIdempotencyKey makes sure that the transaction is only sent once per key.
+func TransactionIdempotencyKey(key string) TransactionOption {
+	return func(t *Transaction) {
+		t.IdempotencyKey = key
+	}
+}
+
+// TransactionBatch marks the transaction as part of a batch that the sender confirmed as a
+// whole, the anomaly checks skip it.
+func TransactionBatch() TransactionOption {
+	return func(t *Transaction) {
+		t.batch = true
+	}
+}
+
+// TransactionUserInitiated marks a transaction that the user made with /tip or /send.
+func TransactionUserInitiated() TransactionOption {
+	return func(t *Transaction) {
+		t.userInitiated = true
+	}
+}
+
+// TransactionContext traces the transaction as part of the update in ctx.
+func TransactionContext(ctx context.Context) TransactionOption {
+	return func(t *Transaction) {
+		t.ctx = ctx
+	}
+}
+
 func NewTransaction(bot *TipBot, from *lnbits.User, to *lnbits.User, amount int64, opts ...TransactionOption) *Transaction {
 	t := &Transaction{
 		Bot:      bot,
@@ -61,6 +125,7 @@ func NewTransaction(bot *TipBot, from *lnbits.User, to *lnbits.User, amount int6
 		Memo:     "Powered by @LightningTipBot",
 		Time:     time.Now(),
 		Success:  false,
+		ctx:      context.Background(),
 	}
 	for _, opt := range opts {
 		opt(t)
@@ -70,20 +135,77 @@ func NewTransaction(bot *TipBot, from *lnbits.User, to *lnbits.User, amount int6
 }
 
 func (t *Transaction) Send() (success bool, err error) {
+	// a redelivered command must not move the funds again
+	if err = t.Bot.claimIdempotencyKey(t.IdempotencyKey); err != nil {
+		return false, err
+	}
+	fee := serviceFee(t.From, t.Type, t.Amount)
+	if fee > 0 {
+		// the sender needs the balance for the fee as well
+		if balance, err := t.Bot.GetUserBalance(t.From); err == nil && balance < t.Amount+fee {
+			log.Warnf("Balance of user %s too low for the amount and the fee", t.FromUser)
+			t.Bot.releaseIdempotencyKey(t.IdempotencyKey)
+			return false, fmt.Errorf("balance too low.")
+		}
+	}
 	success, err = t.SendTransaction(t.Bot, t.From, t.To, t.Amount, t.Memo)
+	unknown := false
+	if !success && len(t.Invoice.PaymentHash) > 0 {
+		// the invoice may be paid although Pay or the balance update afterwards failed
+		payment, checkErr := t.Bot.client(t.ctx).Payment(*t.To.Wallet, t.Invoice.PaymentHash)
+		switch {
+		case checkErr != nil:
+			log.Errorf("[Send] outcome of the transfer %s to %s is unknown: %v", t.FromUser, t.ToUser, checkErr)
+			unknown = true
+		case payment.Paid:
+			success, err = true, nil
+		}
+	}
+	if success {
+		t.Bot.completeIdempotencyKey(t.IdempotencyKey)
+	} else if !unknown {
+		// a retry must be able to send the funds
+		t.Bot.releaseIdempotencyKey(t.IdempotencyKey)
+	}
 	if success {
 		t.Success = success
+		// both users see each other in their transaction history, the receiver of an
+		// anonymous transaction doesn't see the sender
+		t.Bot.saveCounterparty(t.Invoice.PaymentHash, t.From.Wallet.ID, CounterpartyTypeTelegram, t.ToUser)
+		if !t.Anonymous {
+			t.Bot.saveCounterparty(t.Invoice.PaymentHash, t.To.Wallet.ID, CounterpartyTypeTelegram, t.FromUser)
+		}
+		if fee > 0 && t.Bot.collectServiceFee(t.From, fee, t.IdempotencyKey) == nil {
+			t.Fee = fee
+		}
 	}
 
 	// save transaction to db
-	tx := t.Bot.DB.Transactions.Save(t)
+	tx := t.Bot.DB.Transactions.WithContext(t.ctx).Save(t)
 	if tx.Error != nil {
-		errMsg := fmt.Sprintf("Error: Could not log transaction: %s", err.Error())
-		log.Errorln(errMsg)
+		// err is nil after a successful transfer
+		log.Errorf("Error: Could not log transaction: %v", tx.Error)
+	}
+	if success {
+		t.Bot.rewardReferral(t)
+		t.Bot.scheduleAutoForward(t.To)
 	}
 	return success, err
 }
 
+// SetSenderMessage remembers the message that confirmed the transaction to its sender,
+// who can reply to it with /refund.
+func (t *Transaction) SetSenderMessage(msg *tb.Message) {
+	if msg == nil || t.ID == 0 {
+		return
+	}
+	t.SenderMessageID = msg.ID
+	tx := t.Bot.DB.Transactions.WithContext(t.ctx).Model(t).Update("sender_message_id", msg.ID)
+	if tx.Error != nil {
+		log.Errorf("[SetSenderMessage] could not update transaction %d: %v", t.ID, tx.Error)
+	}
+}
+
 func (t *Transaction) SendTransaction(bot *TipBot, from *lnbits.User, to *lnbits.User, amount int64, memo string) (bool, error) {
 	fromUserStr := GetUserStr(from.Telegram)
 	toUserStr := GetUserStr(to.Telegram)
@@ -109,12 +231,11 @@ func (t *Transaction) SendTransaction(bot *TipBot, from *lnbits.User, to *lnbits
 	t.ToLNbitsID = to.ID
 
 	// generate invoice
-	invoice, err := to.Wallet.Invoice(
+	invoice, err := bot.client(t.ctx).CreateInvoice(*to.Wallet,
 		lnbits.InvoiceParams{
 			Amount: int64(amount),
 			Out:    false,
-			Memo:   memo},
-		bot.Client)
+			Memo:   memo})
 	if err != nil {
 		errmsg := fmt.Sprintf("[Send] Error: Could not create invoice for user %s", toUserStr)
 		log.Errorln(errmsg)
@@ -122,7 +243,8 @@ func (t *Transaction) SendTransaction(bot *TipBot, from *lnbits.User, to *lnbits
 	}
 	t.Invoice = invoice
 	// pay invoice
-	_, err = from.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}, bot.Client)
+	_, err = bot.client(t.ctx).Pay(*from.Wallet, lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest, Recipient: to.Wallet.ID, Batch: t.batch,
+		UserInitiated: t.userInitiated})
 	if err != nil {
 		errmsg := fmt.Sprintf("[Send] Payment failed (%s to %s of %d sat): %s", fromUserStr, toUserStr, amount, err.Error())
 		log.Warnf(errmsg)
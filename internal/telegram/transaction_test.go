@@ -0,0 +1,78 @@
+package telegram
+
+import (
+	"testing"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestTransactionSend(t *testing.T) {
+	bot, server := newTestBot(t)
+	from := newTestUser(t, bot, server, &tb.User{ID: 101, Username: "sender"}, 1000)
+	to := newTestUser(t, bot, server, &tb.User{ID: 102, Username: "receiver"}, 0)
+
+	success, err := NewTransaction(bot, from, to, 100, TransactionType("tip")).Send()
+	if !success || err != nil {
+		t.Fatalf("Send() = %v, %v", success, err)
+	}
+	if balance := server.Balance(from.Wallet.ID); balance != 900 {
+		t.Errorf("balance of sender = %d, want 900", balance)
+	}
+	if balance := server.Balance(to.Wallet.ID); balance != 100 {
+		t.Errorf("balance of receiver = %d, want 100", balance)
+	}
+	var count int64
+	bot.DB.Transactions.Model(&Transaction{}).Where("success = ?", true).Count(&count)
+	if count != 1 {
+		t.Errorf("%d successful transactions logged, want 1", count)
+	}
+}
+
+func TestTransactionSendBalanceTooLow(t *testing.T) {
+	bot, server := newTestBot(t)
+	from := newTestUser(t, bot, server, &tb.User{ID: 201, Username: "sender"}, 50)
+	to := newTestUser(t, bot, server, &tb.User{ID: 202, Username: "receiver"}, 0)
+
+	success, err := NewTransaction(bot, from, to, 100, TransactionType("send")).Send()
+	if success || err == nil {
+		t.Fatalf("Send() = %v, %v, want a failure", success, err)
+	}
+	if balance := server.Balance(from.Wallet.ID); balance != 50 {
+		t.Errorf("balance of sender = %d, want 50", balance)
+	}
+}
+
+func TestTransactionSendIdempotent(t *testing.T) {
+	bot, server := newTestBot(t)
+	from := newTestUser(t, bot, server, &tb.User{ID: 301, Username: "sender"}, 1000)
+	to := newTestUser(t, bot, server, &tb.User{ID: 302, Username: "receiver"}, 0)
+
+	for i := 0; i < 2; i++ {
+		NewTransaction(bot, from, to, 100, TransactionIdempotencyKey("tip:1")).Send()
+	}
+	if balance := server.Balance(to.Wallet.ID); balance != 100 {
+		t.Errorf("balance of receiver = %d, want 100 after a duplicate tip", balance)
+	}
+}
+
+func TestTransactionSendRetry(t *testing.T) {
+	bot, server := newTestBot(t)
+	from := newTestUser(t, bot, server, &tb.User{ID: 401, Username: "sender"}, 1000)
+	to := newTestUser(t, bot, server, &tb.User{ID: 402, Username: "receiver"}, 0)
+
+	server.SetUnavailable(true)
+	if success, err := NewTransaction(bot, from, to, 100, TransactionIdempotencyKey("payout:1")).Send(); success || err == nil {
+		t.Fatalf("Send() = %v, %v while LNbits is unavailable, want a failure", success, err)
+	}
+	server.SetUnavailable(false)
+	// the failed transfer does not block the retry
+	if success, err := NewTransaction(bot, from, to, 100, TransactionIdempotencyKey("payout:1")).Send(); !success || err != nil {
+		t.Fatalf("retry: Send() = %v, %v", success, err)
+	}
+	if success, err := NewTransaction(bot, from, to, 100, TransactionIdempotencyKey("payout:1")).Send(); success || err != errDuplicateOperation {
+		t.Errorf("duplicate: Send() = %v, %v, want errDuplicateOperation", success, err)
+	}
+	if balance := server.Balance(to.Wallet.ID); balance != 100 {
+		t.Errorf("balance of receiver = %d, want 100", balance)
+	}
+}
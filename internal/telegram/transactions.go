@@ -2,6 +2,8 @@ package telegram
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
@@ -13,13 +15,15 @@ import (
 )
 
 type TransactionsList struct {
-	ID           string          `json:"id"`
-	User         *lnbits.User    `json:"from"`
-	Payments     lnbits.Payments `json:"payments"`
-	LanguageCode string          `json:"languagecode"`
-	CurrentPage  int             `json:"currentpage"`
-	MaxPages     int             `json:"maxpages"`
-	TxPerPage    int             `json:"txperpage"`
+	ID             string            `json:"id"`
+	User           *lnbits.User      `json:"from"`
+	Payments       lnbits.Payments   `json:"payments"`
+	Counterparties map[string]string `json:"counterparties"`
+	Comments       map[string]string `json:"comments"`
+	LanguageCode   string            `json:"languagecode"`
+	CurrentPage    int               `json:"currentpage"`
+	MaxPages       int               `json:"maxpages"`
+	TxPerPage      int               `json:"txperpage"`
 }
 
 func (txlist *TransactionsList) printTransactions(ctx intercept.Context) string {
@@ -53,6 +57,13 @@ func (txlist *TransactionsList) printTransactions(ctx intercept.Context) string
 		timestr := time.Unix(int64(p.Time), 0).UTC().Format("2 Jan 06 15:04")
 		txstr += fmt.Sprintf("` %s`", timestr)
 		txstr += fmt.Sprintf("` %+d sat`", p.Amount/1000)
+		if counterparty, ok := txlist.Counterparties[p.PaymentHash]; ok {
+			if p.Amount < 0 {
+				txstr += fmt.Sprintf(" → `%s`", str.MarkdownEscape(counterparty))
+			} else {
+				txstr += fmt.Sprintf(" ← `%s`", str.MarkdownEscape(counterparty))
+			}
+		}
 		if p.Fee > 0 {
 			fee := p.Fee
 			if fee < 1000 {
@@ -68,6 +79,12 @@ func (txlist *TransactionsList) printTransactions(ctx intercept.Context) string
 		if len(memo) > 0 {
 			txstr += fmt.Sprintf("\n✉️ %s", str.MarkdownEscape(memo))
 		}
+		if comment, ok := txlist.Comments[p.PaymentHash]; ok {
+			if len([]rune(comment)) > memo_maxlen {
+				comment = string([]rune(comment)[:memo_maxlen]) + "..."
+			}
+			txstr += fmt.Sprintf("\n💬 %s", str.MarkdownEscape(comment))
+		}
 		txstr += "\n"
 	}
 	txstr += fmt.Sprintf("\nShowing %d transactions. Page %d of %d.", len(payments), txlist.CurrentPage+1, txlist.MaxPages)
@@ -104,23 +121,135 @@ func (bot *TipBot) makeTransactionsKeyboard(ctx intercept.Context, txlist Transa
 	return transactionsMeno
 }
 
+const transactionsDateLayout = "2006-01-02"
+
+// TransactionsFilter holds the filters of the /transactions command
+type TransactionsFilter struct {
+	Direction string    // "in", "out" or empty for both
+	Query     string    // matched against memo, counterparty, comment and amount
+	From      time.Time // zero if not set
+	To        time.Time // zero if not set
+}
+
+// parseTransactionsFilter parses /transactions [in|out] [from <date>] [to <date>] [search <text>]
+// everything after "search" is used as the search query.
+func parseTransactionsFilter(text string) (filter TransactionsFilter, err error) {
+	args := strings.Fields(text)
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "in", "out":
+			filter.Direction = strings.ToLower(args[i])
+		case "search":
+			filter.Query = strings.ToLower(strings.Join(args[i+1:], " "))
+			i = len(args)
+		case "from", "since":
+			if i+1 >= len(args) {
+				return filter, fmt.Errorf("missing date")
+			}
+			i++
+			filter.From, err = time.Parse(transactionsDateLayout, args[i])
+			if err != nil {
+				return filter, err
+			}
+		case "to", "until":
+			if i+1 >= len(args) {
+				return filter, fmt.Errorf("missing date")
+			}
+			i++
+			filter.To, err = time.Parse(transactionsDateLayout, args[i])
+			if err != nil {
+				return filter, err
+			}
+			// include the entire day
+			filter.To = filter.To.Add(24 * time.Hour)
+		default:
+			return filter, fmt.Errorf("unknown filter %s", args[i])
+		}
+	}
+	return filter, nil
+}
+
+func (filter TransactionsFilter) isEmpty() bool {
+	return filter.Direction == "" && filter.Query == "" && filter.From.IsZero() && filter.To.IsZero()
+}
+
+func (filter TransactionsFilter) match(p lnbits.Payment, counterparty string, comment string) bool {
+	if filter.Direction == "in" && p.Amount < 0 || filter.Direction == "out" && p.Amount > 0 {
+		return false
+	}
+	t := time.Unix(int64(p.Time), 0)
+	if !filter.From.IsZero() && t.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && !t.Before(filter.To) {
+		return false
+	}
+	if filter.Query != "" {
+		amount := p.Amount / 1000
+		if amount < 0 {
+			amount = -amount
+		}
+		if queryAmount, err := GetAmount(filter.Query); err == nil {
+			return queryAmount == amount
+		}
+		return strings.Contains(strings.ToLower(p.Memo), filter.Query) ||
+			strings.Contains(strings.ToLower(counterparty), filter.Query) ||
+			strings.Contains(strings.ToLower(comment), filter.Query) ||
+			strings.Contains(strconv.FormatInt(amount, 10), filter.Query)
+	}
+	return true
+}
+
+func (filter TransactionsFilter) apply(payments lnbits.Payments, counterparties map[string]string, comments map[string]string) lnbits.Payments {
+	if filter.isEmpty() {
+		return payments
+	}
+	filtered := lnbits.Payments{}
+	for _, p := range payments {
+		if filter.match(p, counterparties[p.PaymentHash], comments[p.PaymentHash]) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
 func (bot *TipBot) transactionsHandler(ctx intercept.Context) (intercept.Context, error) {
 	m := ctx.Message()
 	user := LoadUser(ctx)
+	if arg, err := getArgumentFromCommand(m.Text, 1); err == nil && strings.ToLower(arg) == "help" {
+		bot.trySendMessage(m.Sender, Translate(ctx, "transactionsHelpMessage"))
+		return ctx, nil
+	}
+	filter, err := parseTransactionsFilter(m.Text)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "transactionsHelpMessage"))
+		return ctx, err
+	}
 	payments, err := bot.Client.Payments(*user.Wallet)
 	if err != nil {
 		log.Errorf("[transactions] Error: %s", err.Error())
 		return ctx, err
 	}
+	counterparties, comments := bot.loadCounterparties(user.Wallet, payments)
+	payments = filter.apply(payments, counterparties, comments)
+	if len(payments) == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "transactionsNoResultsMessage"))
+		return ctx, nil
+	}
 	tx_per_page := 10
 	transactionsList := TransactionsList{
-		ID:           fmt.Sprintf("txlist:%d:%s", user.Telegram.ID, RandStringRunes(5)),
-		User:         user,
-		Payments:     payments,
-		LanguageCode: ctx.Value("userLanguageCode").(string),
-		CurrentPage:  0,
-		TxPerPage:    tx_per_page,
-		MaxPages:     (len(payments)+1)/tx_per_page + 1,
+		ID:             fmt.Sprintf("txlist:%d:%s", user.Telegram.ID, RandStringRunes(5)),
+		User:           user,
+		Payments:       payments,
+		Counterparties: counterparties,
+		Comments:       comments,
+		LanguageCode:   ctx.Value("userLanguageCode").(string),
+		CurrentPage:    0,
+		TxPerPage:      tx_per_page,
+		MaxPages:       (len(payments)+1)/tx_per_page + 1,
 	}
 	bot.Cache.Set(fmt.Sprintf("%s_transactions", user.Name), transactionsList, &store.Options{Expiration: 1 * time.Minute})
 	txstr := transactionsList.printTransactions(ctx)
@@ -3,22 +3,23 @@ package telegram
 import (
 	"context"
 
-	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	i18n2 "github.com/nicksnyder/go-i18n/v2/i18n"
 	log "github.com/sirupsen/logrus"
 )
 
 func Translate(ctx context.Context, MessgeID string) string {
-	str, err := LoadPublicLocalizer(ctx).Localize(&i18n.LocalizeConfig{MessageID: MessgeID})
+	str, err := LoadPublicLocalizer(ctx).Localize(&i18n2.LocalizeConfig{MessageID: MessgeID})
 	if err != nil {
 		log.Warnf("Error translating message %s: %s", MessgeID, err)
 	}
-	return str
+	return i18n.LabelAmounts(str)
 }
 
 func TranslateUser(ctx context.Context, MessgeID string) string {
-	str, err := LoadUserLocalizer(ctx).Localize(&i18n.LocalizeConfig{MessageID: MessgeID})
+	str, err := LoadUserLocalizer(ctx).Localize(&i18n2.LocalizeConfig{MessageID: MessgeID})
 	if err != nil {
 		log.Warnf("Error translating message %s: %s", MessgeID, err)
 	}
-	return str
+	return i18n.LabelAmounts(str)
 }
@@ -0,0 +1,101 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/eko/gocache/store"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	unitHelpMessage    = "📖 Choose the unit of the amounts that the bot shows you.\n\n`/set display sat` ⚡️ Satoshis.\n`/set display btc` ₿ Bitcoin with 8 decimals.\n`/set display bits` 🔹 Bits, a bit is 100 satoshis.\n\nYour current unit: %s"
+	unitChangedMessage = "✅ The bot shows you amounts like %s now."
+)
+
+func unitCacheKey(chatId int64) string {
+	return fmt.Sprintf("%d_unit", chatId)
+}
+
+// parseUnit returns the unit of an input like sats, BTC or bits.
+func parseUnit(input string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(input)) {
+	case "sat", "sats", "satoshi", "satoshis":
+		return i18n.UnitSat, nil
+	case "btc", "bitcoin", "₿":
+		return i18n.UnitBTC, nil
+	case "bit", "bits":
+		return i18n.UnitBits, nil
+	}
+	return "", fmt.Errorf("invalid unit %s", input)
+}
+
+// getChatUnit returns the unit of the amounts in messages to a chat. Private chats use the
+// unit of the user, groups see sat.
+func (bot *TipBot) getChatUnit(chatId int64) string {
+	if chatId <= 0 || bot.Cache.StoreInterface == nil || bot.DB == nil {
+		return i18n.UnitSat
+	}
+	if unit, err := bot.Cache.Get(unitCacheKey(chatId)); err == nil {
+		return unit.(string)
+	}
+	unit := i18n.UnitSat
+	if user, err := GetLnbitsUserWithSettings(&tb.User{ID: chatId}, *bot); err == nil && len(user.Settings.Display.Unit) > 0 {
+		unit = user.Settings.Display.Unit
+	}
+	bot.Cache.Set(unitCacheKey(chatId), unit, &store.Options{Expiration: 1 * time.Hour})
+	return unit
+}
+
+// formatAmount formats an amount in the unit of the user.
+func (bot *TipBot) formatAmount(user *lnbits.User, amount int64) string {
+	if user == nil || user.Telegram == nil {
+		return i18n.FormatAmount(amount, i18n.UnitSat)
+	}
+	return i18n.FormatAmount(amount, bot.getChatUnit(user.Telegram.ID))
+}
+
+// labelAmounts labels the amounts in a message as tsat if the bot does not run on mainnet and
+// shows them in the unit of the chat.
+func (bot *TipBot) labelAmounts(chatId int64, what interface{}) interface{} {
+	unit := bot.getChatUnit(chatId)
+	switch what := what.(type) {
+	case string:
+		return i18n.ConvertAmounts(i18n.LabelAmounts(what), unit)
+	case *tb.Photo:
+		what.Caption = i18n.ConvertAmounts(i18n.LabelAmounts(what.Caption), unit)
+	}
+	return what
+}
+
+// setUnitHandler is invoked on /set display [sat|btc|bits]
+func (bot *TipBot) setUnitHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	splits := strings.Fields(m.Text)
+	if len(splits) < 3 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(unitHelpMessage, bot.formatAmount(user, 1000)))
+		return ctx, nil
+	}
+	unit, err := parseUnit(splits[2])
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(unitHelpMessage, bot.formatAmount(user, 1000)))
+		return ctx, err
+	}
+	user.Settings.Display.Unit = unit
+	if err := UpdateUserRecord(user, *bot); err != nil {
+		log.Errorf("[setUnitHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	bot.Cache.Set(unitCacheKey(m.Sender.ID), unit, &store.Options{Expiration: 1 * time.Hour})
+	bot.trySendMessage(m.Sender, fmt.Sprintf(unitChangedMessage, i18n.FormatAmount(1000, i18n.UnitSat)))
+	return ctx, nil
+}
@@ -0,0 +1,57 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestConvertAmounts(t *testing.T) {
+	tests := []struct {
+		text string
+		unit string
+		want string
+	}{
+		{text: "💸 1000 sat sent.", unit: i18n.UnitSat, want: "💸 1000 sat sent."},
+		{text: "💸 1000 sat sent.", unit: "", want: "💸 1000 sat sent."},
+		{text: "💸 1000 sat sent.", unit: i18n.UnitBTC, want: "💸 0.00001000 BTC sent."},
+		{text: "💸 1000 sat sent.", unit: i18n.UnitBits, want: "💸 10 bits sent."},
+		{text: "1234 sats and 5 tsat", unit: i18n.UnitBits, want: "12.34 bits and 0.05 tbits"},
+		{text: "2100000000000000 sat", unit: i18n.UnitBTC, want: "21000000.00000000 BTC"},
+		{text: "/tip 1000 satoshi", unit: i18n.UnitBTC, want: "/tip 1000 satoshi"},
+	}
+	for _, tt := range tests {
+		if got := i18n.ConvertAmounts(tt.text, tt.unit); got != tt.want {
+			t.Errorf("ConvertAmounts(%q, %q) = %q, want %q", tt.text, tt.unit, got, tt.want)
+		}
+	}
+}
+
+func TestDisplayUnit(t *testing.T) {
+	h := newTestHarness(t)
+	alice := &tb.User{ID: 9971, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	bob := &tb.User{ID: 9972, Username: "bob", FirstName: "Bob", LanguageCode: "en"}
+	h.newUser(alice, 1000)
+	h.newUser(bob, 0)
+	group := &tb.Chat{ID: -9970, Type: tb.ChatGroup, Title: "group"}
+
+	h.sendMessage(alice, privateChat(alice), "/set display btc")
+	h.sendMessage(alice, privateChat(alice), "/balance")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "0.00001000 BTC") {
+		t.Errorf("balance = %q", text)
+	}
+
+	// groups and other users keep sat
+	post := h.sendMessage(bob, group, "gm")
+	h.sendReply(alice, group, "/tip 10", post)
+	if text := h.lastMessage(bob.ID).Text(); !strings.Contains(text, "10 sat") {
+		t.Errorf("notification of bob = %q", text)
+	}
+	h.sendMessage(alice, privateChat(alice), "/set display bits")
+	h.sendMessage(alice, privateChat(alice), "/balance")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "9.90 bits") {
+		t.Errorf("balance = %q", text)
+	}
+}
@@ -0,0 +1,51 @@
+package telegram
+
+import (
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// updateErrorRetention is how long the errors of updates are kept for support.
+	updateErrorRetention = 30 * 24 * time.Hour
+	updateErrorMaxLength = 300
+)
+
+// UpdateError is an error that a handler returned for an update of a user. Support looks them
+// up with /admin lookup, the correlation id finds the log lines of the update.
+type UpdateError struct {
+	ID            uint      `gorm:"primarykey"`
+	CreatedAt     time.Time `json:"created_at"`
+	UserId        int64     `json:"user_id" gorm:"index"`
+	CorrelationId string    `json:"correlation_id"`
+	Command       string    `json:"command"`
+	Error         string    `json:"error"`
+}
+
+// recordUpdateError stores the error of a handler for the sender of the update.
+func (bot TipBot) recordUpdateError(ctx intercept.Context, err error) {
+	sender := ctx.Sender()
+	if sender == nil || err == nil {
+		return
+	}
+	message := err.Error()
+	if runes := []rune(message); len(runes) > updateErrorMaxLength {
+		message = string(runes[:updateErrorMaxLength])
+	}
+	correlationId, _ := ctx.Value("correlation_id").(string)
+	updateError := &UpdateError{CreatedAt: time.Now(), UserId: sender.ID, CorrelationId: correlationId, Command: commandOf(ctx), Error: message}
+	if tx := bot.DB.Transactions.Create(updateError); tx.Error != nil {
+		log.Errorf("[recordUpdateError] could not record error of %s: %v", GetUserStr(sender), tx.Error)
+		return
+	}
+	bot.DB.Transactions.Where("user_id = ? AND created_at < ?", sender.ID, time.Now().Add(-updateErrorRetention)).Delete(&UpdateError{})
+}
+
+// updateErrors returns the last errors of the updates of the user, the most recent first.
+func (bot *TipBot) updateErrors(userId int64, limit int) ([]UpdateError, error) {
+	var errs []UpdateError
+	tx := bot.DB.Transactions.Where("user_id = ?", userId).Order("id desc").Limit(limit).Find(&errs)
+	return errs, tx.Error
+}
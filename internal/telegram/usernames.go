@@ -0,0 +1,85 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/database"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// usernameChangeWarningPeriod is how long /send warns that a username belonged to someone else.
+const usernameChangeWarningPeriod = 30 * 24 * time.Hour
+
+// usernameRenamed returns true if the user has a different username on Telegram now. Partial
+// users like &tb.User{ID: id} don't come from Telegram and don't rename anyone.
+func usernameRenamed(stored, current *tb.User) bool {
+	if stored == nil || current == nil || current.FirstName == "" {
+		return false
+	}
+	return !strings.EqualFold(stored.Username, current.Username)
+}
+
+// usernameChanged keeps the username history and the routing of lightning addresses up to
+// date after a user renamed on Telegram and tells the user about the new address.
+func (bot *TipBot) usernameChanged(user *lnbits.User, former string) {
+	if former != "" {
+		if err := database.RecordUsernameChange(bot.DB.Users, user, former); err != nil {
+			log.Errorf("[usernameChanged] could not record former username of %s: %v", GetUserStr(user.Telegram), err)
+		}
+	}
+	username := user.Telegram.Username
+	if username != "" {
+		// users who gave up the username and didn't talk to the bot since still have it in
+		// the database, their lightning address must not catch the payments anymore
+		var stale []*lnbits.User
+		bot.DB.Users.Where(database.EqualFold("telegram_username"), username).Where("id <> ?", user.ID).Find(&stale)
+		for _, staleUser := range stale {
+			staleUser.Telegram.Username = ""
+			if err := UpdateUserRecord(staleUser, *bot); err != nil {
+				continue
+			}
+			updateCachedUser(staleUser, *bot)
+			runtime.IgnoreError(database.RecordUsernameChange(bot.DB.Users, staleUser, username))
+			log.Infof("[usernameChanged] @%s changed hands, removed it from user %s", username, staleUser.ID)
+		}
+	}
+	if !user.Initialized || bot.Telegram == nil {
+		return
+	}
+	address, err := bot.UserGetLightningAddress(user)
+	if err != nil {
+		return
+	}
+	message := fmt.Sprintf(i18n.Translate(user.Telegram.LanguageCode, "usernameChangedMessage"), address)
+	if former != "" {
+		formerAddress := fmt.Sprintf("%s@%s", strings.ToLower(former), strings.ToLower(internal.Configuration.Bot.LNURLHostUrl.Hostname()))
+		message += fmt.Sprintf(i18n.Translate(user.Telegram.LanguageCode, "usernameFormerAddressMessage"), formerAddress)
+	}
+	bot.trySendMessage(user.Telegram, message)
+}
+
+// findUserByUsername resolves a username like /send does. A username that nobody has anymore
+// resolves to the user who had it last. The returned change is set if that user renamed or
+// if the username belonged to someone else until recently.
+func findUserByUsername(username string, bot TipBot) (*lnbits.User, *database.UsernameChange, error) {
+	user, err := GetUserByTelegramUsername(username, bot)
+	if err == nil {
+		change, tx := database.LastUsernameChange(bot.DB.Users, username)
+		if tx.Error == nil && change.UserID != user.ID && time.Since(change.CreatedAt) < usernameChangeWarningPeriod {
+			return user, change, nil
+		}
+		return user, nil, nil
+	}
+	former, change, tx := database.FindUserByFormerUsername(bot.DB.Users, username)
+	if tx.Error != nil || former.Wallet == nil || former.Telegram == nil || former.Telegram.Username == "" {
+		return nil, nil, err
+	}
+	return former, change, nil
+}
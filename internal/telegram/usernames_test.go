@@ -0,0 +1,70 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/database"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestUsernameChanges(t *testing.T) {
+	h := newTestHarness(t)
+	sender := &tb.User{ID: 9931, Username: "sender", FirstName: "Sender", LanguageCode: "en"}
+	alice := &tb.User{ID: 9932, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	carol := &tb.User{ID: 9933, Username: "carol", FirstName: "Carol", LanguageCode: "en"}
+	dave := &tb.User{ID: 9934, Username: "dave", FirstName: "Dave", LanguageCode: "en"}
+	h.newUser(sender, 1000)
+	aliceUser := h.newUser(alice, 0)
+	aliceUser.Initialized = true
+	if err := UpdateUserRecord(aliceUser, *h.bot); err != nil {
+		t.Fatal(err)
+	}
+	h.newUser(carol, 0)
+	daveUser := h.newUser(dave, 0)
+
+	// alice renames and learns about her new lightning address
+	renamed := &tb.User{ID: alice.ID, Username: "alice2", FirstName: "Alice", LanguageCode: "en"}
+	h.sendMessage(renamed, privateChat(renamed), "/balance")
+	notified := false
+	for _, request := range h.sent {
+		if request.ChatId() == alice.ID && strings.Contains(request.Text(), "alice2@") && strings.Contains(request.Text(), "`alice@") {
+			notified = true
+		}
+	}
+	if !notified {
+		t.Error("alice was not told about her new lightning address")
+	}
+
+	// payments to the former username reach alice
+	h.sendMessage(sender, privateChat(sender), "/send 10 @alice")
+	confirmation := h.lastMessage(sender.ID)
+	if text := confirmation.Text(); !strings.Contains(text, "@alice is now @alice2") {
+		t.Fatalf("confirmation = %q", text)
+	}
+	h.pressButton(sender, confirmation, "✅ Send")
+	if balance := h.lnbits.Balance(aliceUser.Wallet.ID); balance != 10 {
+		t.Errorf("balance of alice = %d, want 10", balance)
+	}
+
+	// carol gave her username to dave without talking to the bot since
+	takeover := &tb.User{ID: dave.ID, Username: "carol", FirstName: "Dave", LanguageCode: "en"}
+	h.sendMessage(takeover, privateChat(takeover), "/balance")
+	if user, tx := database.FindUser(h.bot.DB.Users, "carol"); tx.Error != nil || user.ID != daveUser.ID {
+		t.Errorf("carol@ resolves to %s, want dave's account %s", user.ID, daveUser.ID)
+	}
+	h.sendMessage(sender, privateChat(sender), "/send 10 @carol")
+	if text := h.lastMessage(sender.ID).Text(); !strings.Contains(text, "belonged to someone else") {
+		t.Errorf("confirmation = %q", text)
+	}
+
+	// partial users don't rename anyone
+	if _, err := GetUser(&tb.User{ID: dave.ID}, *h.bot); err != nil {
+		t.Fatal(err)
+	}
+	var changes int64
+	h.bot.DB.Users.Model(&database.UsernameChange{}).Where("user_id = ?", daveUser.ID).Count(&changes)
+	if changes != 1 {
+		t.Errorf("dave has %d username changes, want 1", changes)
+	}
+}
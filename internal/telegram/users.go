@@ -4,11 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
 	"github.com/LightningTipBot/LightningTipBot/internal/str"
-	"github.com/eko/gocache/store"
 	log "github.com/sirupsen/logrus"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 	"gorm.io/gorm"
@@ -61,13 +59,10 @@ func appendUinqueUsersToSlice(slice []*tb.User, i *tb.User) []*tb.User {
 	return append(slice, i)
 }
 
+// GetUserBalanceCached is kept for callers that only display the balance. Balances
+// are cached by the wallet backend and invalidated on every payment.
 func (bot *TipBot) GetUserBalanceCached(user *lnbits.User) (amount int64, err error) {
-	u, err := bot.Cache.Get(fmt.Sprintf("%s_balance", user.Name))
-	if err != nil {
-		return bot.GetUserBalance(user)
-	}
-	cachedBalance := u.(int64)
-	return cachedBalance, nil
+	return bot.GetUserBalance(user)
 }
 
 func (bot *TipBot) GetUserBalance(user *lnbits.User) (amount int64, err error) {
@@ -75,27 +70,23 @@ func (bot *TipBot) GetUserBalance(user *lnbits.User) (amount int64, err error) {
 		return 0, errors.New("User has no wallet")
 	}
 
-	wallet, err := bot.Client.Info(*user.Wallet)
+	wallet, err := bot.Client.Balance(*user.Wallet)
 	if err != nil {
 		errmsg := fmt.Sprintf("[GetUserBalance] Error: Couldn't fetch user %s's info from LNbits: %s", GetUserStr(user.Telegram), err.Error())
 		log.Errorln(errmsg)
 		return
 	}
-	user.Wallet.Balance = wallet.Balance
-	err = UpdateUserRecord(user, *bot)
-	if err != nil {
-		return
+	// only write the user record if the balance changed
+	if user.Wallet.Balance != wallet.Balance {
+		user.Wallet.Balance = wallet.Balance
+		err = UpdateUserRecord(user, *bot)
+		if err != nil {
+			return
+		}
 	}
 	// msat to sat
 	amount = int64(wallet.Balance) / 1000
 	log.Debugf("[GetUserBalance] %s's balance: %d sat\n", GetUserStr(user.Telegram), amount)
-
-	// update user balance in cache
-	bot.Cache.Set(
-		fmt.Sprintf("%s_balance", user.Name),
-		amount,
-		&store.Options{Expiration: 1 * time.Hour},
-	)
 	return
 }
 
@@ -0,0 +1,520 @@
+package telegram
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/pdf"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/fiatjaf/go-lnurl"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// VoucherEndpoint is the path of the LNURL withdraw endpoint of vouchers.
+	VoucherEndpoint = "lnurlw/voucher"
+	voucherBatchMax = 50
+)
+
+var (
+	vouchersCommandRegex = regexp.MustCompile(`^/\S+\s+(\d+)\s*[xX×*]\s*(\S+)(?:\s+(.+))?$`)
+	voucherTokenRegex    = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	vouchersMenu       = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnRefreshVouchers = vouchersMenu.Data("🔄 Refresh", "refresh_vouchers")
+	btnCancelVouchers  = vouchersMenu.Data("🚫 Cancel unclaimed", "cancel_vouchers")
+	btnPrintVouchers   = vouchersMenu.Data("🖨 Print", "print_vouchers")
+
+	errVoucherNotActive   = fmt.Errorf("voucher was already claimed or cancelled")
+	errVoucherInvalidK1   = fmt.Errorf("invalid k1")
+	errVoucherWrongAmount = fmt.Errorf("invoice amount does not match the voucher")
+)
+
+// VoucherBatch is a set of LNURL withdraw vouchers of the same amount. The funds of all
+// vouchers are held in the escrow wallet until they are claimed or cancelled.
+type VoucherBatch struct {
+	*storage.Base
+	Creator      *lnbits.User `json:"creator"`
+	Amount       int64        `json:"amount"`
+	Memo         string       `json:"memo"`
+	Tokens       []string     `json:"tokens"`
+	Message      *tb.Message  `json:"message,omitempty"`
+	LanguageCode string       `json:"languagecode"`
+	Refunding    int          `json:"refunding,omitempty"` // cancelled vouchers whose refund has an unknown outcome
+}
+
+// Voucher can be claimed once by any wallet that scans its LNURL.
+type Voucher struct {
+	*storage.Base
+	Token       string    `json:"token"`
+	K1          string    `json:"k1"`
+	Batch       string    `json:"batch"`
+	Amount      int64     `json:"amount"`
+	ClaimedAt   time.Time `json:"claimed_at,omitempty"`
+	PaymentHash string    `json:"payment_hash,omitempty"`
+}
+
+func voucherID(token string) string {
+	return fmt.Sprintf("voucher:%s", token)
+}
+
+func voucherBatchID(id string) string {
+	return fmt.Sprintf("voucher-batch:%s", id)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// URL returns the LNURL withdraw endpoint of the voucher.
+func (voucher *Voucher) URL() string {
+	return fmt.Sprintf("%s/%s/%s", internal.Configuration.Bot.LNURLHostName, VoucherEndpoint, voucher.Token)
+}
+
+// LNURL returns the bech32 encoded LNURL of the voucher in upper case, which makes smaller QR codes.
+func (voucher *Voucher) LNURL() (string, error) {
+	encoded, err := lnurl.LNURLEncode(voucher.URL())
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(encoded), nil
+}
+
+// vouchersHandler is invoked on /vouchers <count> x <amount> [<memo>] and creates a batch
+// of vouchers. /vouchers alone lists the batches of the user.
+func (bot *TipBot) vouchersHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Chat.Type != tb.ChatPrivate {
+		// the vouchers must stay secret
+		bot.tryDeleteMessage(m)
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "vouchersHelpText"), Translate(ctx, "vouchersPrivateMessage")))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	creator := LoadUser(ctx)
+	if arguments := strings.Fields(m.Text); len(arguments) == 1 || (len(arguments) == 2 && arguments[1] == "list") {
+		return bot.listVoucherBatches(ctx, creator)
+	}
+	match := vouchersCommandRegex.FindStringSubmatch(m.Text)
+	if match == nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "vouchersHelpText"), ""))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	count, err := strconv.Atoi(match[1])
+	if err != nil || count < 1 || count > voucherBatchMax {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "vouchersHelpText"), fmt.Sprintf(Translate(ctx, "vouchersCountMessage"), voucherBatchMax)))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(match[2])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "vouchersHelpText"), Translate(ctx, "vouchersValidAmountMessage")))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	memo := match[3]
+	if runes := []rune(memo); len(runes) > 100 {
+		memo = string(runes[:100]) + "..."
+	}
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		log.Errorf("[/vouchers] %v", err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	batch := &VoucherBatch{
+		Base:         storage.New(storage.ID(voucherBatchID(id))),
+		Creator:      creator,
+		Amount:       amount,
+		Memo:         memo,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	vouchers := make([]*Voucher, 0, count)
+	for i := 0; i < count; i++ {
+		token, err := randomHex(16)
+		if err != nil {
+			bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+			return ctx, err
+		}
+		k1, err := randomHex(32)
+		if err != nil {
+			bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+			return ctx, err
+		}
+		vouchers = append(vouchers, &Voucher{
+			Base:   storage.New(storage.ID(voucherID(token))),
+			Token:  token,
+			K1:     k1,
+			Batch:  batch.ID,
+			Amount: amount,
+		})
+		batch.Tokens = append(batch.Tokens, token)
+	}
+	archive, err := voucherArchive(batch, vouchers)
+	if err != nil {
+		log.Errorf("[/vouchers] could not create archive: %v", err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+
+	t := NewTransaction(bot, creator, escrow, amount*int64(count), TransactionType("vouchers"), TransactionIdempotencyKey(idempotencyKey(ctx)), TransactionContext(ctx))
+	t.Memo = fmt.Sprintf("🎟 %d vouchers of %s.", count, GetUserStr(creator.Telegram))
+	success, err := t.Send()
+	if isDuplicateOperation(err) {
+		return ctx, err
+	}
+	if !success {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "vouchersFailedMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+	for _, voucher := range vouchers {
+		runtime.IgnoreError(voucher.Set(voucher, bot.Bunt))
+	}
+	logger(ctx).Infof("[🎟 vouchers] %s created %s with %d vouchers of %d sat", GetUserStr(creator.Telegram), batch.ID, count, amount)
+
+	bot.trySendMessage(m.Sender, &tb.Document{
+		File:     tb.File{FileReader: bytes.NewReader(archive)},
+		FileName: fmt.Sprintf("vouchers-%s.zip", id),
+		MIME:     "application/zip",
+	})
+	batch.Message = bot.trySendMessage(m.Sender, bot.voucherBatchStatus(batch), makeVoucherBatchKeyboard(batch))
+	runtime.IgnoreError(batch.Set(batch, bot.Bunt))
+	return ctx, nil
+}
+
+// voucherArchive returns a ZIP file with a QR code of every voucher and a text file
+// with all LNURLs.
+func voucherArchive(batch *VoucherBatch, vouchers []*Voucher) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	archive := zip.NewWriter(buf)
+	index := new(strings.Builder)
+	fmt.Fprintf(index, "%d vouchers of %d sat\n", len(vouchers), batch.Amount)
+	if len(batch.Memo) > 0 {
+		fmt.Fprintf(index, "%s\n", batch.Memo)
+	}
+	for i, voucher := range vouchers {
+		encoded, err := voucher.LNURL()
+		if err != nil {
+			return nil, err
+		}
+		qr, err := qrcode.Encode("lightning:"+encoded, qrcode.Medium, 512)
+		if err != nil {
+			return nil, err
+		}
+		f, err := archive.Create(fmt.Sprintf("voucher-%02d.png", i+1))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(qr); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(index, "\n%02d %s", i+1, encoded)
+	}
+	f, err := archive.Create("vouchers.txt")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write([]byte(index.String() + "\n")); err != nil {
+		return nil, err
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// makeVoucherBatchKeyboard is also used when a voucher is claimed, so it is translated
+// with the language of the batch.
+func makeVoucherBatchKeyboard(batch *VoucherBatch) *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	refreshButton := menu.Data(i18n.Translate(batch.LanguageCode, "refreshButtonMessage"), "refresh_vouchers", batch.ID)
+	cancelButton := menu.Data(i18n.Translate(batch.LanguageCode, "vouchersCancelButtonMessage"), "cancel_vouchers", batch.ID)
+	printButton := menu.Data(i18n.Translate(batch.LanguageCode, "printButtonMessage"), "print_vouchers", batch.ID)
+	menu.Inline(menu.Row(refreshButton, cancelButton), menu.Row(printButton))
+	return menu
+}
+
+// loadVouchers returns the vouchers of a batch in the order in which they were created.
+func (bot *TipBot) loadVouchers(batch *VoucherBatch) []*Voucher {
+	vouchers := make([]*Voucher, 0, len(batch.Tokens))
+	for _, token := range batch.Tokens {
+		voucher := &Voucher{Base: storage.New(storage.ID(voucherID(token)))}
+		sn, err := voucher.Get(voucher, bot.Bunt)
+		if err != nil {
+			log.Errorf("[🎟 vouchers] could not load voucher of %s: %v", batch.ID, err)
+			continue
+		}
+		vouchers = append(vouchers, sn.(*Voucher))
+	}
+	return vouchers
+}
+
+// voucherBatchStatus lists which vouchers of a batch were claimed.
+func (bot *TipBot) voucherBatchStatus(batch *VoucherBatch) string {
+	vouchers := bot.loadVouchers(batch)
+	claimed, cancelled := 0, 0
+	lines := ""
+	for i, voucher := range vouchers {
+		switch {
+		case !voucher.ClaimedAt.IsZero():
+			claimed++
+			lines += fmt.Sprintf("\n`%02d` ✅ %s", i+1, voucher.ClaimedAt.UTC().Format("2 Jan 15:04"))
+		case !voucher.Active:
+			cancelled++
+			lines += fmt.Sprintf("\n`%02d` 🚫", i+1)
+		default:
+			lines += fmt.Sprintf("\n`%02d` ⏳", i+1)
+		}
+	}
+	message := fmt.Sprintf(i18n.Translate(batch.LanguageCode, "vouchersStatusMessage"), len(vouchers), batch.Amount, claimed, len(vouchers))
+	if len(batch.Memo) > 0 {
+		message += fmt.Sprintf("\n✉️ %s", str.MarkdownEscape(batch.Memo))
+	}
+	if cancelled > 0 {
+		message += fmt.Sprintf("\n"+i18n.Translate(batch.LanguageCode, "vouchersCancelledCountMessage"), cancelled)
+	}
+	return message + "\n" + lines
+}
+
+func (bot *TipBot) loadVoucherBatch(id string) (*VoucherBatch, error) {
+	batch := &VoucherBatch{Base: storage.New(storage.ID(id))}
+	sn, err := batch.Get(batch, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	return sn.(*VoucherBatch), nil
+}
+
+// listVoucherBatches shows the open batches of a user.
+func (bot *TipBot) listVoucherBatches(ctx intercept.Context, user *lnbits.User) (intercept.Context, error) {
+	message := ""
+	bot.Bunt.Ascend("voucher-batch", func(key, value string) bool {
+		batch := &VoucherBatch{}
+		if err := json.Unmarshal([]byte(value), batch); err != nil || batch.Base == nil || !batch.Active {
+			return true
+		}
+		if batch.Creator == nil || batch.Creator.Telegram.ID != user.Telegram.ID {
+			return true
+		}
+		open := 0
+		for _, voucher := range bot.loadVouchers(batch) {
+			if voucher.Active {
+				open++
+			}
+		}
+		message += fmt.Sprintf("\n`%s` %d × %d sat, %d open", strings.TrimPrefix(batch.ID, "voucher-batch:"), len(batch.Tokens), batch.Amount, open)
+		return true // continue iteration
+	})
+	if len(message) == 0 {
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "vouchersHelpText"), Translate(ctx, "vouchersNoneMessage")))
+		return ctx, nil
+	}
+	bot.trySendMessage(ctx.Sender(), Translate(ctx, "vouchersListMessage")+"\n"+message)
+	return ctx, nil
+}
+
+// refreshVouchersHandler updates the status message of a batch.
+func (bot *TipBot) refreshVouchersHandler(ctx intercept.Context) (intercept.Context, error) {
+	batch, err := bot.loadVoucherBatch(ctx.Data())
+	if err != nil || batch.Creator.Telegram.ID != ctx.Sender().ID {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if batch.Active {
+		bot.tryEditMessage(ctx.Message(), bot.voucherBatchStatus(batch), makeVoucherBatchKeyboard(batch))
+	} else {
+		bot.tryEditMessage(ctx.Message(), bot.voucherBatchStatus(batch), &tb.ReplyMarkup{})
+	}
+	return ctx, nil
+}
+
+// cancelVouchersHandler refunds all unclaimed vouchers of a batch to the creator and cancels them.
+// The vouchers stay locked during the refund and are only cancelled after it went through.
+func (bot *TipBot) cancelVouchersHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	batch, err := bot.loadVoucherBatch(ctx.Data())
+	if err != nil || batch.Creator.Telegram.ID != ctx.Sender().ID {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if !batch.Active {
+		bot.tryEditMessage(ctx.Message(), bot.voucherBatchStatus(batch), &tb.ReplyMarkup{})
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	var unclaimed []*Voucher
+	for _, token := range batch.Tokens {
+		id := voucherID(token)
+		mutex.LockWithContext(ctx, id)
+		defer mutex.UnlockWithContext(ctx, id)
+		voucher := &Voucher{Base: storage.New(storage.ID(id))}
+		if sn, err := voucher.Get(voucher, bot.Bunt); err == nil && sn.(*Voucher).Active {
+			unclaimed = append(unclaimed, sn.(*Voucher))
+		}
+	}
+	// vouchers of an earlier refund with an unknown outcome are refunded with the same key
+	cancelled := batch.Refunding + len(unclaimed)
+	if cancelled > 0 {
+		escrow, err := bot.EscrowWallet()
+		if err != nil {
+			return ctx, err
+		}
+		creator := LoadUser(ctx)
+		t := NewTransaction(bot, escrow, creator, batch.Amount*int64(cancelled), TransactionType("vouchers refund"), TransactionIdempotencyKey(batch.ID+":cancel"))
+		t.Memo = fmt.Sprintf("🎟 Refund of %d vouchers of %s.", cancelled, GetUserStr(creator.Telegram))
+		success, err := t.Send()
+		if err == errOperationInProgress {
+			// the refund may have gone through, the vouchers must not be claimed anymore
+			for _, voucher := range unclaimed {
+				runtime.IgnoreError(voucher.Inactivate(voucher, bot.Bunt))
+			}
+			batch.Refunding = cancelled
+			runtime.IgnoreError(batch.Set(batch, bot.Bunt))
+		}
+		if !success && err != errDuplicateOperation {
+			log.Errorf("[🎟 vouchers] could not refund %s: %v", batch.ID, err)
+			bot.trySendMessage(ctx.Sender(), Translate(ctx, "errorTryLaterMessage"))
+			return ctx, err
+		}
+	}
+	for _, voucher := range unclaimed {
+		runtime.IgnoreError(voucher.Inactivate(voucher, bot.Bunt))
+	}
+	batch.Refunding = 0
+	runtime.IgnoreError(batch.Inactivate(batch, bot.Bunt))
+	log.Infof("[🎟 vouchers] %s cancelled %d vouchers of %s", GetUserStr(ctx.Sender()), cancelled, batch.ID)
+	bot.tryEditMessage(ctx.Message(), bot.voucherBatchStatus(batch), &tb.ReplyMarkup{})
+	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "vouchersCancelledMessage"), cancelled, batch.Amount*int64(cancelled)))
+	return ctx, nil
+}
+
+// printVouchersHandler sends the unclaimed vouchers of a batch as cards to print.
+func (bot *TipBot) printVouchersHandler(ctx intercept.Context) (intercept.Context, error) {
+	batch, err := bot.loadVoucherBatch(ctx.Data())
+	if err != nil || batch.Creator.Telegram.ID != ctx.Sender().ID {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	var cards []pdf.Card
+	for i, voucher := range bot.loadVouchers(batch) {
+		if !voucher.Active {
+			continue
+		}
+		encoded, err := voucher.LNURL()
+		if err != nil {
+			return ctx, err
+		}
+		cards = append(cards, pdf.Card{
+			Amount:       fmt.Sprintf("%d sat", voucher.Amount),
+			Memo:         batch.Memo,
+			QR:           "lightning:" + encoded,
+			Code:         fmt.Sprintf("#%02d", i+1),
+			Instructions: Translate(ctx, "vouchersCardInstructions"),
+		})
+	}
+	if len(cards) == 0 {
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "vouchersNoneMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	file, err := pdf.Cards{Title: fmt.Sprintf("%d vouchers of %d sat", len(cards), batch.Amount), Brand: bot.brand(), Cards: cards}.Bytes()
+	if err != nil {
+		log.Errorf("[🎟 vouchers] could not print %s: %v", batch.ID, err)
+		bot.trySendMessage(ctx.Sender(), Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	bot.trySendMessage(ctx.Sender(), &tb.Document{
+		File:     tb.File{FileReader: bytes.NewReader(file)},
+		FileName: fmt.Sprintf("vouchers-%s.pdf", strings.TrimPrefix(batch.ID, "voucher-batch:")),
+		MIME:     "application/pdf",
+	})
+	return ctx, nil
+}
+
+// VoucherByToken returns the voucher of an LNURL withdraw request.
+func (bot *TipBot) VoucherByToken(token string) (*Voucher, error) {
+	if !voucherTokenRegex.MatchString(token) {
+		return nil, fmt.Errorf("invalid token %s", token)
+	}
+	voucher := &Voucher{Base: storage.New(storage.ID(voucherID(token)))}
+	sn, err := voucher.Get(voucher, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	return sn.(*Voucher), nil
+}
+
+// ClaimVoucher pays the invoice of the wallet that scanned a voucher from the escrow wallet.
+// The voucher is marked as claimed before the payment, so that it can't be paid twice.
+func (bot *TipBot) ClaimVoucher(token, k1, paymentRequest string) error {
+	id := voucherID(token)
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	voucher, err := bot.VoucherByToken(token)
+	if err != nil {
+		return err
+	}
+	if !voucher.Active {
+		return errVoucherNotActive
+	}
+	if k1 != voucher.K1 {
+		return errVoucherInvalidK1
+	}
+	bolt11, err := decodepay.Decodepay(paymentRequest)
+	if err != nil {
+		return err
+	}
+	if bolt11.MSatoshi != voucher.Amount*1000 {
+		return errVoucherWrongAmount
+	}
+	escrow, err := bot.EscrowWallet()
+	if err != nil {
+		return err
+	}
+	if err := voucher.Inactivate(voucher, bot.Bunt); err != nil {
+		return err
+	}
+	invoice, err := bot.Client.Pay(*escrow.Wallet, lnbits.PaymentParams{Out: true, Bolt11: paymentRequest})
+	if err != nil {
+		log.Errorf("[🎟 vouchers] could not pay out %s: %v", voucher.ID, err)
+		if isPaymentRefused(err) {
+			voucher.Active = true
+		} else {
+			// the payment may still arrive, the voucher stays claimed
+			voucher.ClaimedAt = time.Now()
+			voucher.PaymentHash = bolt11.PaymentHash
+		}
+		runtime.IgnoreError(voucher.Set(voucher, bot.Bunt))
+		return err
+	}
+	voucher.ClaimedAt = time.Now()
+	voucher.PaymentHash = invoice.PaymentHash
+	runtime.IgnoreError(voucher.Set(voucher, bot.Bunt))
+	log.Infof("[🎟 vouchers] %s of %d sat was claimed", voucher.ID, voucher.Amount)
+
+	if batch, err := bot.loadVoucherBatch(voucher.Batch); err == nil && batch.Message != nil && batch.Active {
+		bot.tryEditMessage(batch.Message, bot.voucherBatchStatus(batch), makeVoucherBatchKeyboard(batch))
+	}
+	return nil
+}
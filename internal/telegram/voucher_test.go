@@ -0,0 +1,99 @@
+package telegram
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestVouchersClaimAndCancel(t *testing.T) {
+	h := newTestHarness(t)
+	escrow := h.newUser(testBotUser, 0)
+	alice := &tb.User{ID: 9201, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	from := h.newUser(alice, 5000)
+
+	h.sendMessage(alice, privateChat(alice), "/vouchers 3 x 1000 Meetup")
+	if !h.called("sendDocument", alice.ID) {
+		t.Fatal("no archive of the vouchers was sent")
+	}
+	status := h.lastMessage(alice.ID)
+	if text := status.Text(); !strings.Contains(text, "3 vouchers of 1000 sat") {
+		t.Fatalf("status message = %q", text)
+	}
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 3000 {
+		t.Errorf("balance of the escrow = %d, want 3000", balance)
+	}
+	var batch *VoucherBatch
+	h.bot.Bunt.Ascend("voucher-batch", func(key, value string) bool {
+		batch = &VoucherBatch{}
+		return json.Unmarshal([]byte(value), batch) != nil
+	})
+	if batch == nil || len(batch.Tokens) != 3 {
+		t.Fatalf("batch = %+v", batch)
+	}
+
+	// a wallet claims the first voucher
+	voucher, err := h.bot.VoucherByToken(batch.Tokens[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong, _ := h.lnbits.ExternalInvoice(2000, "")
+	if err := h.bot.ClaimVoucher(voucher.Token, voucher.K1, wrong.PaymentRequest); err != errVoucherWrongAmount {
+		t.Errorf("claim with a wrong amount: %v", err)
+	}
+	invoice, _ := h.lnbits.ExternalInvoice(1000, "")
+	if err := h.bot.ClaimVoucher(voucher.Token, voucher.K1, invoice.PaymentRequest); err != nil {
+		t.Fatal(err)
+	}
+	if !h.lnbits.Paid(invoice.PaymentHash) {
+		t.Error("invoice of the voucher was not paid")
+	}
+	again, _ := h.lnbits.ExternalInvoice(1000, "")
+	if err := h.bot.ClaimVoucher(voucher.Token, voucher.K1, again.PaymentRequest); err != errVoucherNotActive {
+		t.Errorf("second claim: %v", err)
+	}
+
+	h.pressButton(alice, status, "🚫 Cancel unclaimed")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "2 unclaimed vouchers were cancelled") {
+		t.Errorf("message after cancel = %q", text)
+	}
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 4000 {
+		t.Errorf("balance of alice = %d, want 4000", balance)
+	}
+	if balance := h.lnbits.Balance(escrow.Wallet.ID); balance != 0 {
+		t.Errorf("balance of the escrow = %d, want 0", balance)
+	}
+}
+
+func TestVouchersCancelRetry(t *testing.T) {
+	h := newTestHarness(t)
+	escrow := h.newUser(testBotUser, 0)
+	alice := &tb.User{ID: 9211, Username: "alice", FirstName: "Alice", LanguageCode: "en"}
+	from := h.newUser(alice, 1000)
+
+	h.sendMessage(alice, privateChat(alice), "/vouchers 2 x 500")
+	status := h.lastMessage(alice.ID)
+	// the escrow wallet can't pay the refund
+	drain, _ := h.lnbits.ExternalInvoice(1000, "drain")
+	if _, err := h.lnbits.Client().Pay(*escrow.Wallet, lnbits.PaymentParams{Out: true, Bolt11: drain.PaymentRequest}); err != nil {
+		t.Fatal(err)
+	}
+	h.pressButton(alice, status, "🚫 Cancel unclaimed")
+	if text := h.lastMessage(alice.ID).Text(); strings.Contains(text, "cancelled") {
+		t.Fatalf("message after the failed refund = %q", text)
+	}
+
+	if err := h.lnbits.Fund(escrow.Wallet.ID, 1000); err != nil {
+		t.Fatal(err)
+	}
+	h.pressButton(alice, status, "🚫 Cancel unclaimed")
+	if text := h.lastMessage(alice.ID).Text(); !strings.Contains(text, "2 unclaimed vouchers were cancelled") {
+		t.Errorf("message after cancel = %q", text)
+	}
+	if balance := h.lnbits.Balance(from.Wallet.ID); balance != 1000 {
+		t.Errorf("balance of alice = %d, want 1000", balance)
+	}
+}
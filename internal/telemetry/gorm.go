@@ -0,0 +1,54 @@
+package telemetry
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const gormSpanKey = "telemetry:span"
+
+// InstrumentGorm records a span for every query of db. The span is a child of the span in
+// the context of the statement, see gorm.DB.WithContext. Only the statement with placeholders
+// is recorded, never the values.
+func InstrumentGorm(db *gorm.DB) {
+	callback := db.Callback()
+	callback.Create().Before("gorm:create").Register("telemetry:before_create", startGormSpan("create"))
+	callback.Create().After("gorm:create").Register("telemetry:after_create", endGormSpan)
+	callback.Query().Before("gorm:query").Register("telemetry:before_query", startGormSpan("query"))
+	callback.Query().After("gorm:query").Register("telemetry:after_query", endGormSpan)
+	callback.Update().Before("gorm:update").Register("telemetry:before_update", startGormSpan("update"))
+	callback.Update().After("gorm:update").Register("telemetry:after_update", endGormSpan)
+	callback.Delete().Before("gorm:delete").Register("telemetry:before_delete", startGormSpan("delete"))
+	callback.Delete().After("gorm:delete").Register("telemetry:after_delete", endGormSpan)
+	callback.Row().Before("gorm:row").Register("telemetry:before_row", startGormSpan("row"))
+	callback.Row().After("gorm:row").Register("telemetry:after_row", endGormSpan)
+	callback.Raw().Before("gorm:raw").Register("telemetry:before_raw", startGormSpan("raw"))
+	callback.Raw().After("gorm:raw").Register("telemetry:after_raw", endGormSpan)
+}
+
+func startGormSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		_, span := Tracer().Start(db.Statement.Context, "gorm."+operation, trace.WithSpanKind(trace.SpanKindClient))
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func endGormSpan(db *gorm.DB) {
+	value, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	span.SetAttributes(
+		attribute.String("db.table", db.Statement.Table),
+		attribute.String("db.statement", db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	if db.Error != nil && db.Error != gorm.ErrRecordNotFound {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+	span.End()
+}
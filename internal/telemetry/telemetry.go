@@ -0,0 +1,46 @@
+package telemetry
+
+import (
+	"context"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/LightningTipBot/LightningTipBot"
+
+// Start exports traces to the configured OTLP/HTTP endpoint. Without an endpoint, the
+// spans are not recorded. The returned function flushes the remaining spans.
+func Start(config internal.TelemetryConfiguration) func(context.Context) error {
+	if config.OtlpEndpoint == "" {
+		return func(context.Context) error { return nil }
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.OtlpEndpoint)}
+	if config.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		log.Errorf("[Telemetry] could not create exporter: %v", err)
+		return func(context.Context) error { return nil }
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRate))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(config.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	log.Infof("[Telemetry] exporting traces to %s", config.OtlpEndpoint)
+	return provider.Shutdown
+}
+
+// Tracer returns the tracer of the bot.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
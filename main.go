@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"net/http"
 	"runtime/debug"
+	"time"
 
 	"github.com/LightningTipBot/LightningTipBot/internal"
 	"github.com/LightningTipBot/LightningTipBot/internal/api"
@@ -17,26 +20,61 @@ import (
 
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits/webhook"
 	"github.com/LightningTipBot/LightningTipBot/internal/price"
+	"github.com/LightningTipBot/LightningTipBot/internal/reporting"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
+	"github.com/LightningTipBot/LightningTipBot/internal/telemetry"
 	log "github.com/sirupsen/logrus"
 )
 
 // setLogger will initialize the log format
 func setLogger() {
 	log.SetLevel(log.DebugLevel)
+	if internal.Configuration.Log.Format == internal.LogFormatJson {
+		// one object per line with the structured fields, e.g. the correlation_id of an update
+		log.SetFormatter(&log.JSONFormatter{TimestampFormat: "2006-01-02 15:04:05"})
+		return
+	}
 	customFormatter := new(log.TextFormatter)
 	customFormatter.TimestampFormat = "2006-01-02 15:04:05"
 	customFormatter.FullTimestamp = true
 	log.SetFormatter(customFormatter)
 }
 
+// reportingFlushTimeout limits how long the bot waits for reported errors to be sent on exit
+const reportingFlushTimeout = 2 * time.Second
+
+var (
+	migrate        = flag.String("migrate", "", "migrate the databases and exit: latest or <scope>:<version>")
+	importBalances = flag.String("import-balances", "", "credit the balances of a CSV or JSON export of another tip bot from the operator's wallet and exit")
+	importSource   = flag.String("import-source", "", "name of the tip bot of -import-balances, an export is only credited once per source (default: file name)")
+	importDryRun   = flag.Bool("import-dry-run", false, "only report what -import-balances would credit")
+)
+
 func main() {
 	// set logger
 	setLogger()
+	flag.Parse()
+	if *migrate != "" {
+		if err := telegram.RunMigrations(*migrate); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	if *importBalances != "" {
+		if err := telegram.RunBalanceImport(*importBalances, *importSource, *importDryRun); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
 
 	defer withRecovery()
+	reporting.Start(internal.Configuration.Reporting)
+	defer reporting.Flush(reportingFlushTimeout)
+	shutdownTracing := telemetry.Start(internal.Configuration.Telemetry)
+	defer shutdownTracing(context.Background())
 	price.NewPriceWatcher().Start()
 	bot := telegram.NewBot()
 	startApiServer(&bot)
@@ -47,18 +85,23 @@ func startApiServer(bot *telegram.TipBot) {
 	bot.Telegram.OnError = func(err error, ctx tb.Context) {
 		// we already log in the interceptors
 	}
-	// start internal webhook server
-	webhook.NewServer(bot)
+	// start internal webhook server, the lnbits backend delivers incoming payments to it
+	if client, ok := lnbits.Unwrap(bot.Client).(*lnbits.Client); ok {
+		webhook.NewServer(client, bot.KnowsWallet)
+	}
 	// start external api server
 	s := api.NewServer(internal.Configuration.Bot.LNURLServerUrl.Host)
 
 	// append lnurl ctx functions
 	lnUrl := lnurl.New(bot)
 	s.AppendRoute("/.well-known/lnurlp/{username}", lnUrl.Handle, http.MethodGet)
+	s.AppendRoute("/"+lnurl.PoolEndpoint+"/{token}", lnUrl.HandlePool, http.MethodGet)
+	s.AppendRoute("/"+telegram.VoucherEndpoint+"/{token}", lnUrl.HandleVoucher, http.MethodGet)
 	// userpage server
 	userpage := userpage.New(bot)
 	s.AppendRoute("/@{username}", userpage.UserPageHandler, http.MethodGet)
 	s.AppendRoute("/app/@{username}", userpage.UserWebAppHandler, http.MethodGet)
+	s.AppendRoute("/pool/{token}", userpage.PoolPageHandler, http.MethodGet)
 
 	// nostr nip05 identifier
 	nostr := nostr.New(bot)
@@ -66,12 +109,22 @@ func startApiServer(bot *telegram.TipBot) {
 
 	// append lndhub ctx functions
 	hub := lndhub.New(bot)
-	s.AppendAuthorizedRoute(`/lndhub/ext/auth`, api.AuthTypeNone, api.AccessKeyTypeNone, bot.DB.Users, hub.Handle)
-	s.AppendAuthorizedRoute(`/lndhub/ext/{.*}`, api.AuthTypeBearerBase64, api.AccessKeyTypeAdmin, bot.DB.Users, hub.Handle)
-	s.AppendAuthorizedRoute(`/lndhub/ext`, api.AuthTypeBearerBase64, api.AccessKeyTypeAdmin, bot.DB.Users, hub.Handle)
+	s.AppendAuthorizedRoute(`/lndhub/ext/auth`, api.AuthTypeNone, api.AccessKeyTypeNone, bot.DB.Users, hub.Auth, http.MethodPost)
+	s.AppendAuthorizedRoute(`/lndhub/ext/getinfo`, api.AuthTypeNone, api.AccessKeyTypeNone, bot.DB.Users, hub.Authorize(hub.GetInfo), http.MethodGet)
+	s.AppendAuthorizedRoute(`/lndhub/ext/balance`, api.AuthTypeNone, api.AccessKeyTypeNone, bot.DB.Users, hub.Authorize(hub.Balance), http.MethodGet)
+	s.AppendAuthorizedRoute(`/lndhub/ext/addinvoice`, api.AuthTypeNone, api.AccessKeyTypeNone, bot.DB.Users, hub.Authorize(hub.AddInvoice), http.MethodPost)
+	s.AppendAuthorizedRoute(`/lndhub/ext/payinvoice`, api.AuthTypeNone, api.AccessKeyTypeNone, bot.DB.Users, hub.Authorize(hub.PayInvoice), http.MethodPost)
+	s.AppendAuthorizedRoute(`/lndhub/ext/gettxs`, api.AuthTypeNone, api.AccessKeyTypeNone, bot.DB.Users, hub.Authorize(hub.GetTxs), http.MethodGet)
+	s.AppendAuthorizedRoute(`/lndhub/ext/getuserinvoices`, api.AuthTypeNone, api.AccessKeyTypeNone, bot.DB.Users, hub.Authorize(hub.GetUserInvoices), http.MethodGet)
+	s.AppendAuthorizedRoute(`/lndhub/ext/getpending`, api.AuthTypeNone, api.AccessKeyTypeNone, bot.DB.Users, hub.Authorize(hub.GetPending), http.MethodGet)
+	s.AppendAuthorizedRoute(`/lndhub/ext/getbtc`, api.AuthTypeNone, api.AccessKeyTypeNone, bot.DB.Users, hub.Authorize(hub.GetBtc), http.MethodGet)
+	s.AppendAuthorizedRoute(`/lndhub/ext/decodeinvoice`, api.AuthTypeNone, api.AccessKeyTypeNone, bot.DB.Users, hub.Authorize(hub.DecodeInvoice), http.MethodGet)
+	s.AppendAuthorizedRoute(`/lndhub/ext/checkpayment/{payment_hash}`, api.AuthTypeNone, api.AccessKeyTypeNone, bot.DB.Users, hub.Authorize(hub.CheckPayment), http.MethodGet)
 
 	// starting api service
 	apiService := api.Service{Bot: bot}
+	s.AppendRoute("/healthz", apiService.Healthz, http.MethodGet)
+	s.AppendRoute("/readyz", apiService.Readyz, http.MethodGet)
 	s.AppendAuthorizedRoute(`/api/v1/paymentstatus/{payment_hash}`, api.AuthTypeBasic, api.AccessKeyTypeInvoice, bot.DB.Users, apiService.PaymentStatus, http.MethodPost)
 	s.AppendAuthorizedRoute(`/api/v1/invoicestatus/{payment_hash}`, api.AuthTypeBasic, api.AccessKeyTypeInvoice, bot.DB.Users, apiService.InvoiceStatus, http.MethodPost)
 	s.AppendAuthorizedRoute(`/api/v1/payinvoice`, api.AuthTypeBasic, api.AccessKeyTypeAdmin, bot.DB.Users, apiService.PayInvoice, http.MethodPost)
@@ -88,6 +141,11 @@ func startApiServer(bot *telegram.TipBot) {
 	internalAdminServer.AppendRoute("/admin/unban/{id}", adminService.UnbanUser)
 	internalAdminServer.AppendRoute("/admin/dalle/enable", adminService.EnableDalle)
 	internalAdminServer.AppendRoute("/admin/dalle/disable", adminService.DisableDalle)
+	internalAdminServer.AppendRoute("/admin/translations/reload", adminService.ReloadTranslations)
+	internalAdminServer.AppendRoute("/admin/config/reload", adminService.ReloadConfiguration)
+	internalAdminServer.AppendRoute("/admin/addresses", adminService.PendingAddressNames)
+	internalAdminServer.AppendRoute("/admin/addresses/approve/{name}", adminService.ApproveAddressName)
+	internalAdminServer.AppendRoute("/admin/addresses/reject/{name}", adminService.RejectAddressName)
 	internalAdminServer.PathPrefix("/debug/pprof/", http.DefaultServeMux)
 
 }
@@ -96,5 +154,7 @@ func withRecovery() {
 	if r := recover(); r != nil {
 		log.Errorln("Recovered panic: ", r)
 		debug.PrintStack()
+		reporting.ReportPanic(r, nil)
+		reporting.Flush(reportingFlushTimeout)
 	}
 }
@@ -3,16 +3,47 @@ package lightning
 import (
 	"net/mail"
 	"strings"
+	"unicode"
 )
 
-// IsInvoice is used to check if a string matches lnbc invoide pattern.
+// Networks that the bot can run on.
+const (
+	Mainnet = "mainnet"
+	Testnet = "testnet"
+	Signet  = "signet"
+	Regtest = "regtest"
+)
+
+// invoicePrefixes maps the prefixes of BOLT11 invoices to their network. lnbcrt
+// starts with lnbc and lntbs with lntb, so the longer prefixes come first.
+var invoicePrefixes = []struct {
+	prefix  string
+	network string
+}{
+	{"lnbcrt", Regtest},
+	{"lntbs", Signet},
+	{"lntb", Testnet},
+	{"lnbc", Mainnet},
+}
+
+// InvoiceNetwork returns the network of an invoice or an empty string if it is none.
+func InvoiceNetwork(invoice string) string {
+	invoice = strings.TrimPrefix(strings.ToLower(invoice), "lightning:")
+	for _, p := range invoicePrefixes {
+		if strings.HasPrefix(invoice, p.prefix) {
+			return p.network
+		}
+	}
+	return ""
+}
+
+// IsInvoice is used to check if a string matches the invoice pattern of any network.
 // todo -- probably should add regex and validate length
 func IsInvoice(message string) bool {
-	message = strings.ToLower(message)
-	// invoice string must start with lnbc or lightning:lnbc
-	if strings.HasPrefix(message, "lnbc") || strings.HasPrefix(message, "lightning:lnbc") {
+	// invoice string must start with lnbc, lntb or lightning:lnbc...
+	if InvoiceNetwork(message) != "" {
 		// invoice string must be a single word
-		if !strings.Contains(message, " ") {
+		if strings.IndexFunc(message, unicode.IsSpace) == -1 {
 			return true
 		}
 	}
@@ -23,7 +54,7 @@ func IsLnurl(message string) bool {
 	message = strings.ToLower(message)
 	if strings.HasPrefix(message, "lnurl") || strings.HasPrefix(message, "lightning:lnurl") {
 		// string must be a single word
-		if !strings.Contains(message, " ") {
+		if strings.IndexFunc(message, unicode.IsSpace) == -1 {
 			return true
 		}
 	}
@@ -31,6 +62,7 @@ func IsLnurl(message string) bool {
 }
 
 func IsLightningAddress(address string) bool {
-	_, err := mail.ParseAddress(address)
-	return err == nil
+	parsed, err := mail.ParseAddress(address)
+	// only plain addresses like user@domain.com, no display names or <brackets>
+	return err == nil && parsed.Name == "" && parsed.Address == address
 }
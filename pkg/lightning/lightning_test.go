@@ -0,0 +1,81 @@
+package lightning
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestIsLightningAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{address: "satoshi@example.com", want: true},
+		{address: "satoshi", want: false},
+		{address: "@satoshi", want: false},
+		{address: "<satoshi@example.com>", want: false},
+		{address: "Satoshi <satoshi@example.com>", want: false},
+		{address: "satoshi@example.com (comment)", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if got := IsLightningAddress(tt.address); got != tt.want {
+				t.Errorf("IsLightningAddress(%q) = %v, want %v", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvoiceNetwork(t *testing.T) {
+	tests := []struct {
+		invoice string
+		want    string
+	}{
+		{invoice: "lnbc100n1p3", want: Mainnet},
+		{invoice: "LIGHTNING:LNBC100N1P3", want: Mainnet},
+		{invoice: "lntb100n1p3", want: Testnet},
+		{invoice: "lntbs100n1p3", want: Signet},
+		{invoice: "lnbcrt100n1p3", want: Regtest},
+		{invoice: "lnurl1dp68", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.invoice, func(t *testing.T) {
+			if got := InvoiceNetwork(tt.invoice); got != tt.want {
+				t.Errorf("InvoiceNetwork(%q) = %q, want %q", tt.invoice, got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzIsInvoice(f *testing.F) {
+	for _, seed := range []string{"lnbc100n1p3", "LIGHTNING:LNBC1", "lnbc1 2", "lnbc1\nhi", "lntbs1", "lnurl1dp68", "hello"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, message string) {
+		// an invoice or lnurl is a single word with the right prefix
+		for _, detect := range []func(string) bool{IsInvoice, IsLnurl} {
+			if !detect(message) {
+				continue
+			}
+			if strings.IndexFunc(message, unicode.IsSpace) != -1 {
+				t.Errorf("detected %q with whitespace", message)
+			}
+			lower := strings.TrimPrefix(strings.ToLower(message), "lightning:")
+			if !strings.HasPrefix(lower, "lnbc") && !strings.HasPrefix(lower, "lntb") && !strings.HasPrefix(lower, "lnurl") {
+				t.Errorf("detected %q without prefix", message)
+			}
+		}
+	})
+}
+
+func FuzzIsLightningAddress(f *testing.F) {
+	for _, seed := range []string{"satoshi@example.com", "Satoshi <satoshi@example.com>", "@satoshi", "a@b"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, address string) {
+		if IsLightningAddress(address) && (strings.ContainsAny(address, "<> \n") || !strings.Contains(address, "@")) {
+			t.Errorf("%q is not a plain lightning address", address)
+		}
+	})
+}